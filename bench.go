@@ -0,0 +1,347 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	gosync "sync"
+	"time"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	"github.com/stolostron/governance-policy-propagator/controllers/common"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/stolostron/governance-policy-status-sync/controllers/sync"
+)
+
+// benchNamespace is the cluster namespace of the policies of the benchmark
+const benchNamespace = "bench-cluster"
+
+// benchOptions are the options of the bench subcommand
+type benchOptions struct {
+	policies  int
+	templates int
+	events    int
+	rounds    int
+	workers   int
+	crdDir    string
+}
+
+// runBench runs the bench subcommand, which drives the reconciler against envtest hub and managed clusters,
+// which are local API servers with the Policy CRD, and prints the reconcile throughput, latency, and memory
+// stats, so that performance regressions are caught before a release. It returns the exit code for the process.
+func runBench(args []string, out io.Writer) int {
+	opts := benchOptions{}
+
+	flags := flag.NewFlagSet("bench", flag.ContinueOnError)
+	flags.IntVar(&opts.policies, "policies", 100, "The number of policies")
+	flags.IntVar(&opts.templates, "templates", 2, "The number of policy templates of each policy")
+	flags.IntVar(&opts.events, "events", 10, "The number of compliance events of each policy template")
+	flags.IntVar(&opts.rounds, "rounds", 3, "The number of times that every policy is reconciled, with a new "+
+		"compliance event of each policy template after the first round")
+	flags.IntVar(&opts.workers, "workers", 1, "The number of concurrent reconciles")
+	flags.StringVar(&opts.crdDir, "crd-dir", "bin/crds", "The directory of the Policy CRD to install on the "+
+		"envtest clusters, whose API server and etcd binaries are found with the KUBEBUILDER_ASSETS environment "+
+		"variable")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if opts.policies < 1 || opts.templates < 1 || opts.events < 0 || opts.rounds < 1 || opts.workers < 1 {
+		fmt.Fprintln(out, "The policies, templates, rounds, and workers must be at least 1 and the events at least 0")
+
+		return 2
+	}
+
+	ctx := context.Background()
+
+	hubClient, stopHub, err := startBenchCluster(ctx, opts, true)
+	if err != nil {
+		fmt.Fprintf(out, "Failed to start the envtest hub cluster: %v\n", err)
+
+		return 1
+	}
+
+	defer stopHub()
+
+	managedClient, stopManaged, err := startBenchCluster(ctx, opts, false)
+	if err != nil {
+		fmt.Fprintf(out, "Failed to start the envtest managed cluster: %v\n", err)
+
+		return 1
+	}
+
+	defer stopManaged()
+
+	reconciler := &sync.PolicyReconciler{
+		HubClient:       hubClient,
+		ManagedClient:   managedClient,
+		HubRecorder:     &record.FakeRecorder{},
+		ManagedRecorder: &record.FakeRecorder{},
+		Scheme:          scheme,
+		EventCacheSize:  10000,
+	}
+
+	latencies := make([]time.Duration, 0, opts.policies*opts.rounds)
+	failures := 0
+
+	var memBefore, memAfter runtime.MemStats
+
+	runtime.GC()
+	runtime.ReadMemStats(&memBefore)
+
+	start := time.Now()
+
+	for round := 0; round < opts.rounds; round++ {
+		if round > 0 {
+			if err := addBenchEvents(ctx, managedClient, opts, round); err != nil {
+				fmt.Fprintf(out, "Failed to add the compliance events: %v\n", err)
+
+				return 1
+			}
+		}
+
+		roundLatencies, roundFailures := benchRound(ctx, reconciler, opts)
+		latencies = append(latencies, roundLatencies...)
+		failures += roundFailures
+	}
+
+	elapsed := time.Since(start)
+
+	runtime.ReadMemStats(&memAfter)
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	fmt.Fprintf(out, "policies: %d, templates: %d, events: %d, rounds: %d, workers: %d\n", opts.policies,
+		opts.templates, opts.events, opts.rounds, opts.workers)
+	fmt.Fprintf(out, "reconciles: %d, failures: %d, elapsed: %s\n", len(latencies), failures,
+		elapsed.Round(time.Millisecond))
+	fmt.Fprintf(out, "throughput: %.1f reconciles/s\n", float64(len(latencies))/elapsed.Seconds())
+	fmt.Fprintf(out, "latency: p50 %s, p99 %s, max %s\n", percentile(latencies, 0.5), percentile(latencies, 0.99),
+		latencies[len(latencies)-1])
+	fmt.Fprintf(out, "memory: %d bytes allocated per reconcile, %d MiB heap in use, %d GCs\n",
+		(memAfter.TotalAlloc-memBefore.TotalAlloc)/uint64(len(latencies)), memAfter.HeapInuse/1024/1024,
+		memAfter.NumGC-memBefore.NumGC)
+
+	if failures > 0 {
+		return 1
+	}
+
+	return 0
+}
+
+// benchRound reconciles every policy once with the workers, and returns the reconcile latencies and the
+// number of failed reconciles
+func benchRound(ctx context.Context, reconciler *sync.PolicyReconciler, opts benchOptions) ([]time.Duration, int) {
+	requests := make(chan reconcile.Request)
+	latencies := make([]time.Duration, 0, opts.policies)
+	failures := 0
+
+	var lock gosync.Mutex
+
+	var wg gosync.WaitGroup
+
+	for i := 0; i < opts.workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for request := range requests {
+				start := time.Now()
+				_, err := reconciler.Reconcile(ctx, request)
+				latency := time.Since(start)
+
+				lock.Lock()
+				latencies = append(latencies, latency)
+
+				if err != nil {
+					failures++
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+
+	for i := 0; i < opts.policies; i++ {
+		requests <- reconcile.Request{NamespacedName: types.NamespacedName{
+			Namespace: benchNamespace,
+			Name:      benchPolicyName(i),
+		}}
+	}
+
+	close(requests)
+	wg.Wait()
+
+	return latencies, failures
+}
+
+// percentile returns the latency at the percentile of the sorted latencies
+func percentile(sorted []time.Duration, percentile float64) time.Duration {
+	index := int(float64(len(sorted))*percentile+0.5) - 1
+	if index < 0 {
+		index = 0
+	}
+
+	return sorted[index]
+}
+
+func benchPolicyName(i int) string {
+	return fmt.Sprintf("policies.bench-%d", i)
+}
+
+func benchTemplateName(policy int, template int) string {
+	return fmt.Sprintf("bench-%d-%d", policy, template)
+}
+
+// startBenchCluster starts an envtest cluster with the Policy CRD and creates the policies of the benchmark
+// on it, along with their compliance events on the managed cluster. It returns a client of the cluster and a
+// function that stops it.
+func startBenchCluster(ctx context.Context, opts benchOptions, hub bool) (client.Client, func(), error) {
+	env := &envtest.Environment{CRDDirectoryPaths: []string{opts.crdDir}, ErrorIfCRDPathMissing: true}
+
+	cfg, err := env.Start()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stop := func() {
+		if err := env.Stop(); err != nil {
+			log.Error(err, "Failed to stop the envtest cluster")
+		}
+	}
+
+	clusterClient, err := newBenchClient(cfg)
+	if err == nil {
+		err = createBenchObjects(ctx, clusterClient, opts, hub)
+	}
+
+	if err != nil {
+		stop()
+
+		return nil, nil, err
+	}
+
+	return clusterClient, stop, nil
+}
+
+// newBenchClient returns a direct client of the envtest cluster without client-side throttling, so that the
+// benchmark measures the reconciler and the API server
+func newBenchClient(cfg *rest.Config) (client.Client, error) {
+	cfg = rest.CopyConfig(cfg)
+	cfg.QPS = -1
+
+	return client.New(cfg, client.Options{Scheme: scheme})
+}
+
+// createBenchObjects creates the cluster namespace and the policies of the benchmark, along with their
+// compliance events on the managed cluster
+func createBenchObjects(ctx context.Context, clusterClient client.Client, opts benchOptions, hub bool) error {
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: benchNamespace}}
+	if err := clusterClient.Create(ctx, namespace); err != nil {
+		return err
+	}
+
+	for i := 0; i < opts.policies; i++ {
+		plc := &policiesv1.Policy{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: benchNamespace,
+				Name:      benchPolicyName(i),
+				Labels:    map[string]string{common.RootPolicyLabel: benchPolicyName(i)},
+			},
+			Spec: policiesv1.PolicySpec{RemediationAction: "inform"},
+		}
+
+		for t := 0; t < opts.templates; t++ {
+			plc.Spec.PolicyTemplates = append(plc.Spec.PolicyTemplates, &policiesv1.PolicyTemplate{
+				ObjectDefinition: k8sruntime.RawExtension{Raw: []byte(fmt.Sprintf(
+					`{"apiVersion":"policy.open-cluster-management.io/v1","kind":"ConfigurationPolicy",`+
+						`"metadata":{"name":%q}}`, benchTemplateName(i, t),
+				))},
+			})
+		}
+
+		if err := clusterClient.Create(ctx, plc); err != nil {
+			return err
+		}
+
+		if hub {
+			continue
+		}
+
+		for t := 0; t < opts.templates; t++ {
+			for e := 0; e < opts.events; e++ {
+				timestamp := time.Now().Add(-time.Duration(opts.events-e) * time.Minute)
+
+				if err := clusterClient.Create(ctx, benchEvent(plc, benchTemplateName(i, t), e, timestamp)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// benchEvent returns a compliance event of the policy template, which alternates between compliant and
+// noncompliant
+func benchEvent(plc *policiesv1.Policy, templateName string, index int, timestamp time.Time) *corev1.Event {
+	message := "Compliant; notification - the benchmark object was found as specified"
+	if index%2 == 1 {
+		message = "NonCompliant; violation - the benchmark object was not found"
+	}
+
+	return &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: benchNamespace,
+			Name:      fmt.Sprintf("%s.%s.%d", plc.GetName(), templateName, index),
+		},
+		InvolvedObject: corev1.ObjectReference{
+			Kind:       policiesv1.Kind,
+			APIVersion: policiesv1.SchemeGroupVersion.String(),
+			Namespace:  benchNamespace,
+			Name:       plc.GetName(),
+			UID:        plc.GetUID(),
+		},
+		Reason:        fmt.Sprintf("policy: %s/%s", benchNamespace, templateName),
+		Message:       message,
+		Type:          "Normal",
+		LastTimestamp: metav1.NewTime(timestamp),
+		Source:        corev1.EventSource{Component: "config-policy-controller"},
+	}
+}
+
+// addBenchEvents records a new compliance event of each policy template of the round
+func addBenchEvents(ctx context.Context, managedClient client.Client, opts benchOptions, round int) error {
+	for i := 0; i < opts.policies; i++ {
+		plc := &policiesv1.Policy{}
+
+		err := managedClient.Get(ctx, types.NamespacedName{Namespace: benchNamespace, Name: benchPolicyName(i)}, plc)
+		if err != nil {
+			return err
+		}
+
+		for t := 0; t < opts.templates; t++ {
+			event := benchEvent(plc, benchTemplateName(i, t), opts.events+round, time.Now())
+			if err := managedClient.Create(ctx, event); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
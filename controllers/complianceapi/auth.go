@@ -0,0 +1,63 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package complianceapi
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// authenticate returns the user of the bearer token of the request, and false if the token is missing or
+// invalid
+func authenticate(
+	ctx context.Context, kube kubernetes.Interface, req *http.Request,
+) (authenticationv1.UserInfo, bool) {
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if token == "" || token == req.Header.Get("Authorization") {
+		return authenticationv1.UserInfo{}, false
+	}
+
+	review, err := kube.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		log.Error(err, "Failed to review a bearer token")
+
+		return authenticationv1.UserInfo{}, false
+	}
+
+	return review.Status.User, review.Status.Authenticated
+}
+
+// authorize returns true if the user is allowed the resource attributes
+func authorize(
+	ctx context.Context, kube kubernetes.Interface, user authenticationv1.UserInfo,
+	attributes *authorizationv1.ResourceAttributes,
+) (bool, error) {
+	extra := map[string]authorizationv1.ExtraValue{}
+	for key, value := range user.Extra {
+		extra[key] = authorizationv1.ExtraValue(value)
+	}
+
+	review, err := kube.AuthorizationV1().SubjectAccessReviews().Create(ctx,
+		&authorizationv1.SubjectAccessReview{
+			Spec: authorizationv1.SubjectAccessReviewSpec{
+				ResourceAttributes: attributes,
+				User:               user.Username,
+				Groups:             user.Groups,
+				UID:                user.UID,
+				Extra:              extra,
+			},
+		}, metav1.CreateOptions{})
+	if err != nil {
+		return false, err
+	}
+
+	return review.Status.Allowed, nil
+}
@@ -0,0 +1,287 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package complianceapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	"github.com/stolostron/governance-policy-propagator/controllers/common"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// CompliancePath is the path the policy compliance read API is served at
+	CompliancePath = "/compliance"
+	// AccessResource is the resource in the policy.open-cluster-management.io group that a user must be
+	// allowed to get in a namespace to read the compliance of the policies affecting it. It isn't served by
+	// the API server, so it's only used in RBAC rules, such as the ones aggregated to the view role.
+	AccessResource = "policycompliances"
+)
+
+var log = logf.Log.WithName("policy-compliance-api")
+
+// ComplianceAPI is a read-only HTTP API of the compliance of the replicated policies on the managed cluster
+// by the namespaces that they affect. The requester is authenticated with the bearer token of the request,
+// and only sees the namespaces where it may get the AccessResource, so that application teams can see the
+// compliance of the policies affecting their namespaces without the permission to list the policies. The
+// compliance messages aren't returned, since they may describe objects in other namespaces.
+type ComplianceAPI struct {
+	// Client reads the replicated policies
+	Client client.Reader
+	// Kubernetes reviews the tokens and access of the requesters and lists the namespaces
+	Kubernetes kubernetes.Interface
+}
+
+// TemplateCompliance is the compliance of a policy template
+type TemplateCompliance struct {
+	Name      string                     `json:"name"`
+	Compliant policiesv1.ComplianceState `json:"compliant,omitempty"`
+}
+
+// PolicyCompliance is the compliance of a replicated policy
+type PolicyCompliance struct {
+	Namespace  string                     `json:"namespace"`
+	Name       string                     `json:"name"`
+	RootPolicy string                     `json:"rootPolicy,omitempty"`
+	Compliant  policiesv1.ComplianceState `json:"compliant,omitempty"`
+	Templates  []TemplateCompliance       `json:"templates,omitempty"`
+}
+
+// NamespaceCompliance is the compliance of the policies affecting a namespace
+type NamespaceCompliance struct {
+	Namespace string             `json:"namespace"`
+	Policies  []PolicyCompliance `json:"policies"`
+}
+
+// ComplianceList is the response of the API
+type ComplianceList struct {
+	Namespaces []NamespaceCompliance `json:"namespaces"`
+}
+
+// ServeHTTP returns the compliance of the policies affecting the namespaces of the namespace query
+// parameters that the requester may access, or of all the affected namespaces that it may access if none is
+// set
+func (a *ComplianceAPI) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "only GET requests are supported", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	ctx := req.Context()
+
+	user, ok := authenticate(ctx, a.Kubernetes, req)
+	if !ok {
+		http.Error(w, "a valid bearer token is required", http.StatusUnauthorized)
+
+		return
+	}
+
+	policyList := &policiesv1.PolicyList{}
+	if err := a.Client.List(ctx, policyList); err != nil {
+		log.Error(err, "Failed to list the policies")
+		http.Error(w, "failed to list the policies", http.StatusInternalServerError)
+
+		return
+	}
+
+	affected, err := a.affectedNamespaces(ctx, policyList.Items)
+	if err != nil {
+		log.Error(err, "Failed to list the namespaces")
+		http.Error(w, "failed to list the namespaces", http.StatusInternalServerError)
+
+		return
+	}
+
+	requested := req.URL.Query()["namespace"]
+	if len(requested) == 0 {
+		for namespace := range affected {
+			requested = append(requested, namespace)
+		}
+	}
+
+	sort.Strings(requested)
+
+	response := ComplianceList{Namespaces: []NamespaceCompliance{}}
+
+	for _, namespace := range requested {
+		allowed, err := authorize(ctx, a.Kubernetes, user, &authorizationv1.ResourceAttributes{
+			Namespace: namespace,
+			Verb:      "get",
+			Group:     policiesv1.SchemeGroupVersion.Group,
+			Resource:  AccessResource,
+		})
+		if err != nil {
+			log.Error(err, "Failed to review the access of a user", "User", user.Username, "Namespace", namespace)
+			http.Error(w, "failed to review the access", http.StatusInternalServerError)
+
+			return
+		}
+
+		if !allowed {
+			if len(req.URL.Query()["namespace"]) != 0 {
+				http.Error(w, "access to the compliance of namespace "+namespace+" is forbidden",
+					http.StatusForbidden)
+
+				return
+			}
+
+			continue
+		}
+
+		nsCompliance := NamespaceCompliance{Namespace: namespace, Policies: []PolicyCompliance{}}
+
+		for _, index := range affected[namespace] {
+			nsCompliance.Policies = append(nsCompliance.Policies, policyCompliance(&policyList.Items[index]))
+		}
+
+		response.Namespaces = append(response.Namespaces, nsCompliance)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Error(err, "Failed to write the policy compliance response")
+	}
+}
+
+// affectedNamespaces maps the namespaces affected by the policies to the indexes of the policies. The
+// namespaces that a policy affects are the namespace selectors and the namespaces of the object templates
+// of its policy templates. The namespaces are only listed if a namespace selector has a wildcard.
+func (a *ComplianceAPI) affectedNamespaces(ctx context.Context, policies []policiesv1.Policy) (
+	map[string][]int, error,
+) {
+	affected := map[string][]int{}
+
+	var existing []string
+
+	for i := range policies {
+		include, exclude := templateNamespaces(&policies[i])
+
+		if existing == nil && hasWildcard(include) {
+			namespaceList, err := a.Kubernetes.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+			if err != nil {
+				return nil, err
+			}
+
+			existing = make([]string, 0, len(namespaceList.Items))
+			for _, namespace := range namespaceList.Items {
+				existing = append(existing, namespace.GetName())
+			}
+		}
+
+		namespaces := map[string]bool{}
+
+		for _, pattern := range include {
+			if !strings.ContainsAny(pattern, "*?[") {
+				namespaces[pattern] = true
+
+				continue
+			}
+
+			for _, namespace := range existing {
+				if matched, _ := filepath.Match(pattern, namespace); matched {
+					namespaces[namespace] = true
+				}
+			}
+		}
+
+		for namespace := range namespaces {
+			if matchesAny(exclude, namespace) {
+				continue
+			}
+
+			affected[namespace] = append(affected[namespace], i)
+		}
+	}
+
+	return affected, nil
+}
+
+// templateNamespaces returns the included and excluded namespace patterns of the policy templates of the
+// policy. The namespaces of the object templates are included as is.
+func templateNamespaces(plc *policiesv1.Policy) (include []string, exclude []string) {
+	for _, policyT := range plc.Spec.PolicyTemplates {
+		object := &unstructured.Unstructured{}
+		if err := object.UnmarshalJSON(policyT.ObjectDefinition.Raw); err != nil {
+			continue
+		}
+
+		selectorInclude, _, _ := unstructured.NestedStringSlice(object.Object, "spec", "namespaceSelector", "include")
+		selectorExclude, _, _ := unstructured.NestedStringSlice(object.Object, "spec", "namespaceSelector", "exclude")
+		include = append(include, selectorInclude...)
+		exclude = append(exclude, selectorExclude...)
+
+		objectTemplates, _, _ := unstructured.NestedSlice(object.Object, "spec", "object-templates")
+		for _, objectTemplate := range objectTemplates {
+			objectTemplateMap, ok := objectTemplate.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			namespace, _, _ := unstructured.NestedString(objectTemplateMap, "objectDefinition", "metadata",
+				"namespace")
+			if namespace != "" {
+				include = append(include, namespace)
+			}
+		}
+	}
+
+	return include, exclude
+}
+
+// hasWildcard returns true if one of the namespace patterns has a wildcard
+func hasWildcard(patterns []string) bool {
+	for _, pattern := range patterns {
+		if strings.ContainsAny(pattern, "*?[") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchesAny returns true if the namespace matches one of the namespace patterns
+func matchesAny(patterns []string, namespace string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, namespace); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// policyCompliance returns the compliance of the policy and of its templates, without the messages
+func policyCompliance(plc *policiesv1.Policy) PolicyCompliance {
+	compliance := PolicyCompliance{
+		Namespace:  plc.GetNamespace(),
+		Name:       plc.GetName(),
+		RootPolicy: plc.GetLabels()[common.RootPolicyLabel],
+		Compliant:  plc.Status.ComplianceState,
+	}
+
+	for _, dpt := range plc.Status.Details {
+		if dpt == nil {
+			continue
+		}
+
+		compliance.Templates = append(compliance.Templates, TemplateCompliance{
+			Name:      dpt.TemplateMeta.GetName(),
+			Compliant: dpt.ComplianceState,
+		})
+	}
+
+	return compliance
+}
@@ -0,0 +1,172 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package complianceapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// StatusPath is the path the policy status API is served at
+	StatusPath = "/policy-status"
+	// ResyncSubresource is the subresource of the policies that a user must be allowed to create in a
+	// namespace to request an immediate sync of a policy in it. It isn't served by the API server, so it's only
+	// used in RBAC rules.
+	ResyncSubresource = "resync"
+)
+
+// Resyncer queues an immediate sync of a policy to the hub
+type Resyncer interface {
+	// Resync queues an immediate sync of the policy, and returns false if the policy can't be synced, such as
+	// when the controller isn't running
+	Resync(policy types.NamespacedName) bool
+}
+
+// StatusAPI is an HTTP API that the hub or hub tooling can call to read a live snapshot of the status of a
+// replicated policy on the managed cluster, or to request its immediate sync to the hub, such as for the
+// "refresh now" buttons of consoles instead of waiting for the next event. The requester is authenticated
+// with the bearer token of the request, which must be valid on the managed cluster, such as a token of a
+// managed service account used through the cluster proxy. Reading a snapshot requires the permission to get
+// the policies/status, and requesting a sync requires the permission to create the policies/resync in the
+// namespace of the policy.
+type StatusAPI struct {
+	// Client reads the replicated policies
+	Client client.Reader
+	// Kubernetes reviews the tokens and access of the requesters
+	Kubernetes kubernetes.Interface
+	// Resyncer queues the requested syncs
+	Resyncer Resyncer
+}
+
+// StatusSnapshot is the live status of a replicated policy on the managed cluster
+type StatusSnapshot struct {
+	Namespace string                  `json:"namespace"`
+	Name      string                  `json:"name"`
+	Status    policiesv1.PolicyStatus `json:"status"`
+	// Annotations are the policy.open-cluster-management.io annotations of the policy, which include the hub
+	// sync annotations
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// ResyncQueued is true if an immediate sync of the policy to the hub was queued by the request
+	ResyncQueued bool `json:"resyncQueued,omitempty"`
+}
+
+// ServeHTTP returns the status snapshot of the policy of the namespace and name query parameters for GET
+// requests, and also queues an immediate sync of the policy to the hub for POST requests
+func (a *StatusAPI) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodPost {
+		http.Error(w, "only GET and POST requests are supported", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	policy := types.NamespacedName{
+		Namespace: req.URL.Query().Get("namespace"),
+		Name:      req.URL.Query().Get("name"),
+	}
+	if policy.Namespace == "" || policy.Name == "" {
+		http.Error(w, "the namespace and name query parameters are required", http.StatusBadRequest)
+
+		return
+	}
+
+	ctx := req.Context()
+
+	user, ok := authenticate(ctx, a.Kubernetes, req)
+	if !ok {
+		http.Error(w, "a valid bearer token is required", http.StatusUnauthorized)
+
+		return
+	}
+
+	attributes := &authorizationv1.ResourceAttributes{
+		Namespace:   policy.Namespace,
+		Name:        policy.Name,
+		Verb:        "get",
+		Group:       policiesv1.SchemeGroupVersion.Group,
+		Resource:    "policies",
+		Subresource: "status",
+	}
+	if req.Method == http.MethodPost {
+		attributes.Verb = "create"
+		attributes.Subresource = ResyncSubresource
+	}
+
+	allowed, err := authorize(ctx, a.Kubernetes, user, attributes)
+	if err != nil {
+		log.Error(err, "Failed to review the access of a user", "User", user.Username,
+			"Namespace", policy.Namespace, "Name", policy.Name)
+		http.Error(w, "failed to review the access", http.StatusInternalServerError)
+
+		return
+	}
+
+	if !allowed {
+		http.Error(w, "access to the status of policy "+policy.String()+" is forbidden", http.StatusForbidden)
+
+		return
+	}
+
+	instance := &policiesv1.Policy{}
+
+	if err := a.Client.Get(ctx, policy, instance); err != nil {
+		if errors.IsNotFound(err) {
+			http.Error(w, "policy "+policy.String()+" was not found", http.StatusNotFound)
+
+			return
+		}
+
+		log.Error(err, "Failed to get the policy", "Namespace", policy.Namespace, "Name", policy.Name)
+		http.Error(w, "failed to get the policy", http.StatusInternalServerError)
+
+		return
+	}
+
+	snapshot := StatusSnapshot{
+		Namespace: instance.GetNamespace(),
+		Name:      instance.GetName(),
+		Status:    instance.Status,
+	}
+
+	// the other annotations, such as the last applied configuration, may contain the spec
+	for key, value := range instance.GetAnnotations() {
+		if strings.HasPrefix(key, policiesv1.SchemeGroupVersion.Group+"/") {
+			if snapshot.Annotations == nil {
+				snapshot.Annotations = map[string]string{}
+			}
+
+			snapshot.Annotations[key] = value
+		}
+	}
+
+	status := http.StatusOK
+
+	if req.Method == http.MethodPost {
+		if !a.Resyncer.Resync(policy) {
+			http.Error(w, "the policy status sync isn't running", http.StatusServiceUnavailable)
+
+			return
+		}
+
+		log.Info("Queued an immediate sync of the policy", "User", user.Username, "Namespace", policy.Namespace,
+			"Name", policy.Name)
+
+		snapshot.ResyncQueued = true
+		status = http.StatusAccepted
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(snapshot); err != nil {
+		log.Error(err, "Failed to write the policy status response")
+	}
+}
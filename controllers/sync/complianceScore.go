@@ -0,0 +1,277 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	clusterv1alpha1 "open-cluster-management.io/api/cluster/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/stolostron/governance-policy-status-sync/tool"
+)
+
+const (
+	// ComplianceScoreClaimName is the name of the ClusterClaim that the compliance score of the managed
+	// cluster is published in, so that it's reported in the ManagedCluster status on the hub
+	ComplianceScoreClaimName = "compliancescore.policy.open-cluster-management.io"
+	// noSeverity is the compliance score weight key of the templates without a severity
+	noSeverity = "none"
+	// complianceScoreClaimInterval is the interval at which the compliance score ClusterClaim is refreshed
+	complianceScoreClaimInterval = time.Minute
+)
+
+// defaultComplianceScoreWeights are the compliance score weights of the template severities that aren't
+// set in --compliance-score-weights
+var defaultComplianceScoreWeights = map[string]float64{
+	noSeverity: 1,
+	"low":      1,
+	"medium":   2,
+	"high":     4,
+	"critical": 8,
+}
+
+var complianceScoreGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "policy_status_sync_compliance_score",
+		Help: "The compliance score of the managed cluster from 0 to 100, which is the percentage of the " +
+			"severity weights of the policy templates with a known compliance state that are compliant",
+	},
+	[]string{"managed_cluster"},
+)
+
+func init() {
+	metrics.Registry.MustRegister(complianceScoreGauge)
+}
+
+// ParseComplianceScoreWeights returns the compliance score weights of the template severities from the
+// --compliance-score-weights overrides, such as critical=10, along with the default weights of the other
+// severities. The severities are low, medium, high, critical, and none for the templates without a severity.
+func ParseComplianceScoreWeights(overrides map[string]string) (map[string]float64, error) {
+	weights := make(map[string]float64, len(defaultComplianceScoreWeights))
+	for severity, weight := range defaultComplianceScoreWeights {
+		weights[severity] = weight
+	}
+
+	for severity, value := range overrides {
+		severity = strings.ToLower(severity)
+
+		if _, ok := defaultComplianceScoreWeights[severity]; !ok {
+			return nil, fmt.Errorf("invalid compliance score weight severity %q, it must be one of low, medium, "+
+				"high, critical, or none", severity)
+		}
+
+		weight, err := strconv.ParseFloat(value, 64)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid compliance score weight %q of the severity %s, it must be a number of "+
+				"0 or more", value, severity)
+		}
+
+		weights[severity] = weight
+	}
+
+	return weights, nil
+}
+
+// policyScore is the sum of the severity weights of the templates of a policy with a known compliance state,
+// and of the ones that are compliant
+type policyScore struct {
+	compliant float64
+	total     float64
+}
+
+// complianceScores tracks the score of each policy by managed cluster, so that fan-in mode has a score per
+// cluster
+var complianceScores = struct {
+	lock     sync.Mutex
+	clusters map[string]map[types.NamespacedName]policyScore
+}{clusters: map[string]map[types.NamespacedName]policyScore{}}
+
+// recordComplianceScore records the score of the policy from the compliance state and severity of its
+// templates, and updates the compliance score gauge of the managed cluster. The severities are keyed by
+// template name.
+func (r *PolicyReconciler) recordComplianceScore(instance *policiesv1.Policy, severities map[string]string) {
+	weights := r.ComplianceScoreWeights
+	if weights == nil {
+		weights = defaultComplianceScoreWeights
+	}
+
+	score := policyScore{}
+
+	for _, dpt := range instance.Status.Details {
+		severity := severities[dpt.TemplateMeta.Name]
+		if _, ok := weights[severity]; !ok {
+			severity = noSeverity
+		}
+
+		switch dpt.ComplianceState {
+		case policiesv1.Compliant:
+			score.compliant += weights[severity]
+			score.total += weights[severity]
+		case policiesv1.NonCompliant:
+			score.total += weights[severity]
+		}
+	}
+
+	cluster := r.ClusterName
+	if cluster == "" {
+		cluster = instance.GetNamespace()
+	}
+
+	complianceScores.lock.Lock()
+	defer complianceScores.lock.Unlock()
+
+	if complianceScores.clusters[cluster] == nil {
+		complianceScores.clusters[cluster] = map[types.NamespacedName]policyScore{}
+	}
+
+	complianceScores.clusters[cluster][types.NamespacedName{
+		Namespace: instance.GetNamespace(), Name: instance.GetName(),
+	}] = score
+
+	updateComplianceScoreGauge(cluster)
+}
+
+// forgetComplianceScore removes a deleted policy from the compliance score of its managed cluster
+func forgetComplianceScore(policy types.NamespacedName) {
+	complianceScores.lock.Lock()
+	defer complianceScores.lock.Unlock()
+
+	for cluster, policies := range complianceScores.clusters {
+		if _, ok := policies[policy]; ok {
+			delete(policies, policy)
+			updateComplianceScoreGauge(cluster)
+		}
+	}
+}
+
+// complianceScore returns the compliance score of the managed cluster, and false if none of its templates
+// has a known compliance state. The lock of complianceScores must be held.
+func complianceScore(cluster string) (float64, bool) {
+	total := policyScore{}
+
+	for _, score := range complianceScores.clusters[cluster] {
+		total.compliant += score.compliant
+		total.total += score.total
+	}
+
+	if total.total == 0 {
+		return 0, false
+	}
+
+	return 100 * total.compliant / total.total, true
+}
+
+// updateComplianceScoreGauge sets the compliance score gauge of the managed cluster, and deletes it if the
+// score is unknown. The lock of complianceScores must be held.
+func updateComplianceScoreGauge(cluster string) {
+	score, ok := complianceScore(cluster)
+	if !ok {
+		complianceScoreGauge.DeleteLabelValues(cluster)
+
+		if len(complianceScores.clusters[cluster]) == 0 {
+			delete(complianceScores.clusters, cluster)
+		}
+
+		return
+	}
+
+	complianceScoreGauge.WithLabelValues(cluster).Set(score)
+}
+
+// ComplianceScoreClaim publishes the compliance score of the managed cluster in the ComplianceScoreClaimName
+// ClusterClaim every minute, with one decimal, so that the fleet operators can compare the clusters from the
+// ManagedCluster status on the hub. The claim isn't updated while the score is unknown. It must be added to
+// the manager, and only runs on the leader.
+type ComplianceScoreClaim struct {
+	// Client writes the ClusterClaim on the managed cluster
+	Client client.Client
+	// ClusterName is the name of the managed cluster that the score is published for, which must match the
+	// ClusterName of the reconciler
+	ClusterName string
+	// ClusterIdentity is the identity of the managed cluster that annotates the claim, which isn't annotated
+	// if it's empty
+	ClusterIdentity string
+}
+
+// Start refreshes the ClusterClaim until the context is done
+func (c *ComplianceScoreClaim) Start(ctx context.Context) error {
+	ticker := time.NewTicker(complianceScoreClaimInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			complianceScores.lock.Lock()
+			score, ok := complianceScore(c.ClusterName)
+			complianceScores.lock.Unlock()
+
+			if !ok {
+				continue
+			}
+
+			if err := c.publish(ctx, strconv.FormatFloat(score, 'f', 1, 64)); err != nil {
+				log.Error(err, "Failed to publish the compliance score ClusterClaim", "Name", ComplianceScoreClaimName)
+			}
+		}
+	}
+}
+
+// publish creates or updates the ClusterClaim with the score and the cluster identity annotation
+func (c *ComplianceScoreClaim) publish(ctx context.Context, value string) error {
+	claim := &clusterv1alpha1.ClusterClaim{}
+
+	annotations := map[string]string{}
+	if c.ClusterIdentity != "" {
+		annotations[tool.ClusterIdentityAnnotation] = c.ClusterIdentity
+	}
+
+	err := c.Client.Get(ctx, types.NamespacedName{Name: ComplianceScoreClaimName}, claim)
+	if errors.IsNotFound(err) {
+		return c.Client.Create(ctx, &clusterv1alpha1.ClusterClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: ComplianceScoreClaimName, Annotations: annotations},
+			Spec:       clusterv1alpha1.ClusterClaimSpec{Value: value},
+		})
+	}
+
+	if err != nil {
+		return err
+	}
+
+	identity := claim.GetAnnotations()[tool.ClusterIdentityAnnotation]
+	if claim.Spec.Value == value && identity == c.ClusterIdentity {
+		return nil
+	}
+
+	claim.Spec.Value = value
+
+	if identity != c.ClusterIdentity {
+		claimAnnotations := claim.GetAnnotations()
+		if claimAnnotations == nil {
+			claimAnnotations = map[string]string{}
+		}
+
+		if c.ClusterIdentity == "" {
+			delete(claimAnnotations, tool.ClusterIdentityAnnotation)
+		} else {
+			claimAnnotations[tool.ClusterIdentityAnnotation] = c.ClusterIdentity
+		}
+
+		claim.SetAnnotations(claimAnnotations)
+	}
+
+	return c.Client.Update(ctx, claim)
+}
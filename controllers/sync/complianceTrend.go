@@ -0,0 +1,190 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/stolostron/governance-policy-status-sync/tool"
+)
+
+const (
+	// ComplianceTrendConfigMapName is the name of the ConfigMap in the cluster namespace on the hub with the
+	// downsampled compliance trend of the managed cluster
+	ComplianceTrendConfigMapName = "policy-status-sync-compliance-trend"
+	// ComplianceTrendKey is the key of the compliance trend ConfigMap with the JSON array of the trend points,
+	// oldest first
+	ComplianceTrendKey = "trend.json"
+)
+
+// ComplianceTrendPoint is the number of replicated policies in each compliance state at the start of a trend
+// interval
+type ComplianceTrendPoint struct {
+	Time         time.Time `json:"time"`
+	Compliant    int       `json:"compliant"`
+	NonCompliant int       `json:"noncompliant"`
+	Pending      int       `json:"pending"`
+	Unknown      int       `json:"unknown"`
+}
+
+// ComplianceTrendReporter counts the replicated policies of the managed cluster by compliance state at every
+// interval, and appends the point to the compliance trend ConfigMap in the cluster namespace on the hub, so
+// that the long-range compliance trend of the cluster can be charted without keeping every transition in
+// the policy status. The points older than the retention are removed, and the point of the current interval
+// replaces the one written before a restart in the same interval. It must be added to the manager, and only
+// runs on the leader.
+type ComplianceTrendReporter struct {
+	// ManagedClient lists the policies on the managed cluster
+	ManagedClient client.Client
+	// HubClient writes the ConfigMap on the hub
+	HubClient kubernetes.Interface
+	// Namespace is the cluster namespace on the hub
+	Namespace string
+	// ClusterIdentity is the identity of the managed cluster that annotates the ConfigMap, which isn't
+	// annotated if it's empty
+	ClusterIdentity string
+	// Interval is the interval of the trend points
+	Interval time.Duration
+	// Retention is the age of the oldest trend point that is kept
+	Retention time.Duration
+	// Exclusions are the patterns of the policies that aren't counted since their status isn't synced
+	Exclusions *tool.PolicyExclusions
+}
+
+// NeedLeaderElection is true since only the leader writes to the hub
+func (c *ComplianceTrendReporter) NeedLeaderElection() bool {
+	return true
+}
+
+// Start writes a trend point once the policies are synced and then at every interval until the context is
+// done
+func (c *ComplianceTrendReporter) Start(ctx context.Context) error {
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.report(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			log.Error(err, "Failed to report the compliance trend to the hub, retrying on the next interval",
+				"Namespace", c.Namespace, "Name", ComplianceTrendConfigMapName)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// report counts the policies and writes the trend point of the interval of now
+func (c *ComplianceTrendReporter) report(ctx context.Context, now time.Time) error {
+	policies := &policiesv1.PolicyList{}
+
+	if err := c.ManagedClient.List(ctx, policies); err != nil {
+		return err
+	}
+
+	point := ComplianceTrendPoint{Time: now.UTC().Truncate(c.Interval)}
+
+	for _, policy := range policies.Items {
+		if c.Exclusions.Excluded(policy.GetNamespace(), policy.GetName()) {
+			continue
+		}
+
+		switch policy.Status.ComplianceState {
+		case policiesv1.Compliant:
+			point.Compliant++
+		case policiesv1.NonCompliant:
+			point.NonCompliant++
+		case pendingCompliance:
+			point.Pending++
+		default:
+			point.Unknown++
+		}
+	}
+
+	configMaps := c.HubClient.CoreV1().ConfigMaps(c.Namespace)
+
+	configMap, err := configMaps.Get(ctx, ComplianceTrendConfigMapName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: ComplianceTrendConfigMapName, Namespace: c.Namespace},
+		}
+	} else if err != nil {
+		return err
+	}
+
+	points := []ComplianceTrendPoint{}
+
+	if value := configMap.Data[ComplianceTrendKey]; value != "" {
+		if err := json.Unmarshal([]byte(value), &points); err != nil {
+			log.Info("Replacing the invalid compliance trend on the hub", "Namespace", c.Namespace,
+				"Name", ComplianceTrendConfigMapName, "error", err.Error())
+
+			points = []ComplianceTrendPoint{}
+		}
+	}
+
+	points = appendTrendPoint(points, point, point.Time.Add(-c.Retention))
+
+	trend, err := json.Marshal(points)
+	if err != nil {
+		return err
+	}
+
+	if configMap.Data == nil {
+		configMap.Data = map[string]string{}
+	}
+
+	configMap.Data[ComplianceTrendKey] = string(trend)
+
+	if c.ClusterIdentity != "" {
+		annotations := configMap.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+
+		annotations[tool.ClusterIdentityAnnotation] = c.ClusterIdentity
+		configMap.SetAnnotations(annotations)
+	}
+
+	if configMap.GetResourceVersion() == "" {
+		_, err = configMaps.Create(ctx, configMap, metav1.CreateOptions{})
+	} else {
+		_, err = configMaps.Update(ctx, configMap, metav1.UpdateOptions{})
+	}
+
+	if err == nil {
+		log.V(1).Info("Reported the compliance trend to the hub", "Namespace", c.Namespace,
+			"Name", ComplianceTrendConfigMapName, "points", len(points))
+	}
+
+	return err
+}
+
+// appendTrendPoint returns the points with the point, which replaces the points at or after its time, and
+// without the points before the oldest time
+func appendTrendPoint(
+	points []ComplianceTrendPoint, point ComplianceTrendPoint, oldest time.Time,
+) []ComplianceTrendPoint {
+	kept := make([]ComplianceTrendPoint, 0, len(points)+1)
+
+	for _, existing := range points {
+		if existing.Time.Before(oldest) || !existing.Time.Before(point.Time) {
+			continue
+		}
+
+		kept = append(kept, existing)
+	}
+
+	return append(kept, point)
+}
@@ -0,0 +1,140 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+
+	"github.com/stolostron/governance-policy-status-sync/policystatus"
+)
+
+const (
+	// pendingCompliance is the compliance reported by a policy template that is waiting on its dependencies.
+	// Since it isn't a compliance state of the Policy CRD, it is only used for history entries.
+	pendingCompliance = policystatus.Pending
+	// DependencyStateAnnotation is set on the template metadata in the status of a policy template with
+	// dependencies to either DependencyStateWaiting or DependencyStateSatisfied
+	DependencyStateAnnotation = "policy.open-cluster-management.io/dependency-state"
+	// DependencyWaitingOnAnnotation is set on the template metadata in the status of a policy template that
+	// is waiting on its dependencies, and contains the details reported by the template
+	DependencyWaitingOnAnnotation = "policy.open-cluster-management.io/dependency-waiting-on"
+	// DependencyUnmetAnnotation is set on the template metadata in the status of a policy template that is
+	// waiting on its dependencies, and contains the JSON list of the unmet dependencies, including the
+	// extraDependencies of the template, when the template reports them
+	DependencyUnmetAnnotation = "policy.open-cluster-management.io/dependency-unmet"
+	// DependencyWaitingSinceAnnotation is set on the template metadata in the status of a policy template
+	// that is waiting on its dependencies, and contains the time of the oldest Pending entry of the history
+	// since the template was last evaluated
+	DependencyWaitingSinceAnnotation = "policy.open-cluster-management.io/dependency-waiting-since"
+	DependencyStateWaiting           = "waiting"
+	DependencyStateSatisfied         = "satisfied"
+)
+
+// unmetDependency is a dependency of a policy template that isn't satisfied
+type unmetDependency struct {
+	Kind      string `json:"kind,omitempty"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// setDependencyState sets the dependency state annotations of the template status from its history. A
+// template whose latest entry is Pending is waiting on its dependencies, so its compliance state is left
+// empty since it hasn't been evaluated yet rather than being reported as NonCompliant. A template with an
+// older Pending entry had its dependencies satisfied.
+func setDependencyState(dpt *policiesv1.DetailsPerTemplate) {
+	annotations := dpt.TemplateMeta.GetAnnotations()
+	delete(annotations, DependencyStateAnnotation)
+	delete(annotations, DependencyWaitingOnAnnotation)
+	delete(annotations, DependencyUnmetAnnotation)
+	delete(annotations, DependencyWaitingSinceAnnotation)
+
+	for i, entry := range dpt.History {
+		if historyCompliance(entry.Message) != pendingCompliance {
+			continue
+		}
+
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+
+		if i == 0 {
+			dpt.ComplianceState = ""
+			annotations[DependencyStateAnnotation] = DependencyStateWaiting
+
+			// e.g. "Pending; Dependencies were not satisfied: 1 is still pending (Policy default.policy-a)"
+			message := strings.TrimSpace(strings.TrimPrefix(entry.Message, "(combined from similar events):"))
+			details := strings.TrimSpace(strings.TrimLeft(message[len(pendingCompliance):], ";:"))
+			if details != "" {
+				annotations[DependencyWaitingOnAnnotation] = details
+			}
+
+			if unmet := unmetDependencies(details); len(unmet) != 0 {
+				if encoded, err := json.Marshal(unmet); err == nil {
+					annotations[DependencyUnmetAnnotation] = string(encoded)
+				}
+			}
+
+			annotations[DependencyWaitingSinceAnnotation] = pendingSince(dpt.History).UTC().Format(time.RFC3339)
+		} else {
+			annotations[DependencyStateAnnotation] = DependencyStateSatisfied
+		}
+
+		break
+	}
+
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+
+	dpt.TemplateMeta.SetAnnotations(annotations)
+}
+
+// pendingSince returns the time of the oldest entry of the Pending entries at the start of the history, which
+// is when the template started waiting on its dependencies as far as the history goes back
+func pendingSince(history []policiesv1.ComplianceHistory) time.Time {
+	since := history[0].LastTimestamp.Time
+
+	for _, entry := range history {
+		if historyCompliance(entry.Message) != pendingCompliance {
+			break
+		}
+
+		since = entry.LastTimestamp.Time
+	}
+
+	return since
+}
+
+// unmetDependencies parses the unmet dependencies from the details of a Pending event, such as
+// "Dependencies were not satisfied: 2 are still pending (Policy policy-a in namespace default,
+// ConfigurationPolicy policy-b)". It returns nil if the details don't list the dependencies.
+func unmetDependencies(details string) []unmetDependency {
+	start := strings.LastIndex(details, "(")
+	if start == -1 || !strings.HasSuffix(details, ")") {
+		return nil
+	}
+
+	var unmet []unmetDependency
+
+	for _, identifier := range strings.Split(details[start+1:len(details)-1], ",") {
+		fields := strings.Fields(identifier)
+
+		switch {
+		case len(fields) == 1:
+			unmet = append(unmet, unmetDependency{Name: fields[0]})
+		case len(fields) == 2:
+			unmet = append(unmet, unmetDependency{Kind: fields[0], Name: fields[1]})
+		case len(fields) == 5 && fields[2] == "in" && fields[3] == "namespace":
+			unmet = append(unmet, unmetDependency{Kind: fields[0], Name: fields[1], Namespace: fields[4]})
+		default:
+			// not a list of dependencies
+			return nil
+		}
+	}
+
+	return unmet
+}
@@ -0,0 +1,146 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// StatusSyncEpochAnnotation is set on the template metadata in the hub status of each policy template to the
+// leader epoch of the instance that wrote it, which is the number of leader transitions of the leader
+// election lease when the instance became the leader
+const StatusSyncEpochAnnotation = "policy.open-cluster-management.io/status-sync-epoch"
+
+var fencedWritesTotal = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "policy_status_sync_fenced_writes_total",
+		Help: "The number of hub status writes that were refused because the hub status was written by a " +
+			"newer leader",
+	},
+)
+
+func init() {
+	metrics.Registry.MustRegister(fencedWritesTotal)
+}
+
+// LeaderEpoch reads the leader epoch of this instance from the leader election lease, so that the late
+// writes of a deposed leader don't overwrite the hub status written by the new leader
+type LeaderEpoch struct {
+	Client    kubernetes.Interface
+	Namespace string
+	Name      string
+}
+
+// read returns the number of leader transitions of the leader election lease
+func (e *LeaderEpoch) read(ctx context.Context) (int64, error) {
+	lease, err := e.Client.CoordinationV1().Leases(e.Namespace).Get(ctx, e.Name, metav1.GetOptions{})
+	if err != nil {
+		return 0, err
+	}
+
+	if lease.Spec.LeaseTransitions == nil {
+		return 0, nil
+	}
+
+	return int64(*lease.Spec.LeaseTransitions), nil
+}
+
+// setLeaderEpoch reads the leader epoch after this instance became the leader. If it can't be read, the hub
+// writes of this instance aren't fenced.
+func (r *PolicyReconciler) setLeaderEpoch(ctx context.Context) {
+	if r.LeaderEpoch == nil {
+		return
+	}
+
+	epoch, err := r.LeaderEpoch.read(ctx)
+	if err != nil {
+		log.Error(err, "Failed to read the leader epoch from the leader election lease, the hub writes aren't "+
+			"fenced", "Namespace", r.LeaderEpoch.Namespace, "Name", r.LeaderEpoch.Name)
+
+		return
+	}
+
+	atomic.StoreInt64(&r.epoch, epoch)
+	atomic.StoreUint32(&r.epochKnown, 1)
+
+	log.Info("Read the leader epoch from the leader election lease", "Epoch", epoch)
+}
+
+// leaderEpoch returns the leader epoch of this instance and whether it's known
+func (r *PolicyReconciler) leaderEpoch() (int64, bool) {
+	if atomic.LoadUint32(&r.epochKnown) == 0 {
+		return 0, false
+	}
+
+	return atomic.LoadInt64(&r.epoch), true
+}
+
+// hubEpoch returns the newest leader epoch in the hub status and whether there is one
+func hubEpoch(status policiesv1.PolicyStatus) (int64, bool) {
+	var newest int64
+
+	found := false
+
+	for _, dpt := range status.Details {
+		epoch, err := strconv.ParseInt(dpt.TemplateMeta.GetAnnotations()[StatusSyncEpochAnnotation], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		if !found || epoch > newest {
+			newest = epoch
+			found = true
+		}
+	}
+
+	return newest, found
+}
+
+// isFencedStatus returns true if the hub status was written by a newer leader than this instance, in which
+// case this instance was deposed and must not write to the hub. The hub write counts as fenced.
+func (r *PolicyReconciler) isFencedStatus(hubStatus policiesv1.PolicyStatus) bool {
+	epoch, ok := r.leaderEpoch()
+	if !ok {
+		return false
+	}
+
+	newest, ok := hubEpoch(hubStatus)
+	if !ok || newest <= epoch {
+		return false
+	}
+
+	fencedWritesTotal.Inc()
+
+	return true
+}
+
+// withLeaderEpoch returns the hub status with the leader epoch annotation on each template if the leader
+// epoch of this instance is known
+func (r *PolicyReconciler) withLeaderEpoch(status policiesv1.PolicyStatus) policiesv1.PolicyStatus {
+	epoch, ok := r.leaderEpoch()
+	if !ok {
+		return status
+	}
+
+	withEpoch := *status.DeepCopy()
+
+	for _, dpt := range withEpoch.Details {
+		annotations := dpt.TemplateMeta.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+
+		annotations[StatusSyncEpochAnnotation] = strconv.FormatInt(epoch, 10)
+		dpt.TemplateMeta.SetAnnotations(annotations)
+	}
+
+	return withEpoch
+}
@@ -0,0 +1,121 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fencedReconciler returns a reconciler that became the leader with the lease at the leader epoch 3
+func fencedReconciler(t *testing.T) *PolicyReconciler {
+	t.Helper()
+
+	transitions := int32(3)
+	client := fake.NewSimpleClientset(&coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "agent", Name: "lease"},
+		Spec:       coordinationv1.LeaseSpec{LeaseTransitions: &transitions},
+	})
+
+	reconciler := &PolicyReconciler{LeaderEpoch: &LeaderEpoch{Client: client, Namespace: "agent", Name: "lease"}}
+	reconciler.setLeaderEpoch(context.TODO())
+
+	if epoch, ok := reconciler.leaderEpoch(); !ok || epoch != 3 {
+		t.Fatalf("expected the leader epoch 3 of the lease, got %d", epoch)
+	}
+
+	return reconciler
+}
+
+func TestIsFencedStatus(t *testing.T) {
+	t.Parallel()
+
+	reconciler := fencedReconciler(t)
+
+	tests := map[string]struct {
+		// epochs is the leader epoch of each template, where an empty epoch has no annotation
+		epochs   []string
+		expected bool
+	}{
+		"newer leader":               {[]string{"4"}, true},
+		"newer leader on a template": {[]string{"2", "4"}, true},
+		"same leader":                {[]string{"3"}, false},
+		"older leader":               {[]string{"2"}, false},
+		"no epoch":                   {[]string{""}, false},
+		"invalid epoch":              {[]string{"invalid"}, false},
+		"no templates":               {nil, false},
+	}
+
+	for name, test := range tests {
+		templates := []string{}
+		for i := range test.epochs {
+			templates = append(templates, "template"+strconv.Itoa(i))
+		}
+
+		status := testStatus(policiesv1.NonCompliant, nil, templates...)
+
+		for i, epoch := range test.epochs {
+			if epoch != "" {
+				status.Details[i].TemplateMeta.SetAnnotations(map[string]string{StatusSyncEpochAnnotation: epoch})
+			}
+		}
+
+		if fenced := reconciler.isFencedStatus(status); fenced != test.expected {
+			t.Fatalf("%s: expected the status to be fenced: %v, got %v", name, test.expected, fenced)
+		}
+	}
+}
+
+func TestUnknownLeaderEpochIsNotFenced(t *testing.T) {
+	t.Parallel()
+
+	// the lease doesn't exist, so the epoch is unknown
+	reconciler := &PolicyReconciler{
+		LeaderEpoch: &LeaderEpoch{Client: fake.NewSimpleClientset(), Namespace: "agent", Name: "lease"},
+	}
+	reconciler.setLeaderEpoch(context.TODO())
+
+	status := testStatus(policiesv1.NonCompliant, nil, "template0")
+	status.Details[0].TemplateMeta.SetAnnotations(map[string]string{StatusSyncEpochAnnotation: "4"})
+
+	if reconciler.isFencedStatus(status) {
+		t.Fatal("expected the writes to not be fenced without a known leader epoch")
+	}
+
+	status = testStatus(policiesv1.NonCompliant, nil, "template0")
+	if withEpoch := reconciler.withLeaderEpoch(status); len(withEpoch.Details[0].TemplateMeta.GetAnnotations()) != 0 {
+		t.Fatal("expected no leader epoch annotation without a known leader epoch")
+	}
+}
+
+func TestWithLeaderEpoch(t *testing.T) {
+	t.Parallel()
+
+	reconciler := fencedReconciler(t)
+	status := testStatus(policiesv1.NonCompliant, nil, "template0", "template1")
+	status.Details[1].TemplateMeta.SetAnnotations(map[string]string{StatusSyncEpochAnnotation: "2"})
+
+	withEpoch := reconciler.withLeaderEpoch(status)
+
+	for _, dpt := range withEpoch.Details {
+		if epoch := dpt.TemplateMeta.GetAnnotations()[StatusSyncEpochAnnotation]; epoch != "3" {
+			t.Fatalf("expected the %s template to have the leader epoch 3, got %q", dpt.TemplateMeta.Name, epoch)
+		}
+	}
+
+	// the status of the managed policy isn't modified
+	if epoch := status.Details[1].TemplateMeta.GetAnnotations()[StatusSyncEpochAnnotation]; epoch != "2" {
+		t.Fatalf("expected the original status to keep the leader epoch 2, got %q", epoch)
+	}
+
+	// the status written by this leader isn't fenced by itself
+	if reconciler.isFencedStatus(withEpoch) {
+		t.Fatal("expected the status written by this leader to not be fenced")
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var eventCacheLookupsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "policy_status_sync_event_cache_lookups_total",
+		Help: "The number of lookups of parsed events in the event cache by result (hit or miss)",
+	},
+	[]string{"result"},
+)
+
+// parsedEvent is the compliance history entry parsed from a policy template event
+type parsedEvent struct {
+	resourceVersion string
+	// policyName is the name of the policy that the event is on, which is empty if the event isn't a policy
+	// template event
+	policyName   string
+	templateName string
+	history      policiesv1.ComplianceHistory
+	reporter     string
+	// specHash is the spec hash of the replicated policy that the event was generated for, if it's known
+	specHash string
+	// unknownCompliance is set if the message of the event doesn't start with a compliance state
+	unknownCompliance bool
+}
+
+// parseEvent parses the compliance history entry of a policy template event. The policy name of the parsed
+// event is empty if it isn't a policy template event.
+func parseEvent(event *corev1.Event) parsedEvent {
+	parsed := parsedEvent{resourceVersion: event.GetResourceVersion()}
+
+	// sample event.Reason -- reason: 'policy: calamari/policy-grc-rbactest-example'
+	match := policyEventRgx.FindStringSubmatch(event.Reason)
+	if event.InvolvedObject.Kind != policiesv1.Kind || event.InvolvedObject.APIVersion != policiesv1APIVersion ||
+		match == nil {
+		return parsed
+	}
+
+	parsed.policyName = event.InvolvedObject.Name
+	parsed.templateName = match[2]
+	parsed.history = policiesv1.ComplianceHistory{
+		LastTimestamp: event.LastTimestamp,
+		Message:       strings.TrimSpace(strings.TrimPrefix(event.Message, "(combined from similar events):")),
+		EventName:     event.GetName(),
+	}
+
+	if timestamp, source := eventTimestamp(event); source != "" {
+		substitutedTimestampsTotal.WithLabelValues(source).Inc()
+
+		parsed.history.LastTimestamp = timestamp
+		parsed.history.Message = substitutedMessage(parsed.history.Message, source)
+	}
+	parsed.reporter = eventReporter(event)
+	parsed.specHash = event.GetAnnotations()[SpecHashAnnotation]
+
+	if !knownCompliance(parsed.history.Message) {
+		parsed.unknownCompliance = true
+		unknownComplianceStatesTotal.Inc()
+	}
+
+	return parsed
+}
+
+// eventCache is an LRU cache of the parsed events keyed by event UID, so that the events that were already
+// parsed aren't parsed again on every reconcile of a policy in the namespace. The cached events are parsed
+// again when their resource version changes, such as when a recurring event is updated.
+type eventCache struct {
+	lock    sync.Mutex
+	size    int
+	entries map[types.UID]*list.Element
+	// order has the UIDs from the most to the least recently used
+	order *list.List
+}
+
+type eventCacheEntry struct {
+	uid    types.UID
+	parsed parsedEvent
+}
+
+func newEventCache(size int) *eventCache {
+	return &eventCache{size: size, entries: map[types.UID]*list.Element{}, order: list.New()}
+}
+
+// parse returns the parsed event from the cache, or parses and caches it. The event is parsed without the
+// cache if c is nil.
+func (c *eventCache) parse(event *corev1.Event) parsedEvent {
+	if c == nil {
+		return parseEvent(event)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if element, ok := c.entries[event.GetUID()]; ok {
+		entry := element.Value.(*eventCacheEntry)
+		if entry.parsed.resourceVersion == event.GetResourceVersion() {
+			eventCacheLookupsTotal.WithLabelValues("hit").Inc()
+			c.order.MoveToFront(element)
+
+			return entry.parsed
+		}
+
+		entry.parsed = parseEvent(event)
+		c.order.MoveToFront(element)
+		eventCacheLookupsTotal.WithLabelValues("miss").Inc()
+
+		return entry.parsed
+	}
+
+	eventCacheLookupsTotal.WithLabelValues("miss").Inc()
+
+	parsed := parseEvent(event)
+	c.entries[event.GetUID()] = c.order.PushFront(&eventCacheEntry{uid: event.GetUID(), parsed: parsed})
+
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*eventCacheEntry).uid)
+	}
+
+	return parsed
+}
+
+// parsedEvents returns the event cache of the reconciler, which is created on first use. It's nil if the
+// cache is disabled.
+func (r *PolicyReconciler) parsedEvents() *eventCache {
+	if r.EventCacheSize <= 0 {
+		return nil
+	}
+
+	r.eventCacheOnce.Do(func() {
+		r.eventCache = newEventCache(r.EventCacheSize)
+	})
+
+	return r.eventCache
+}
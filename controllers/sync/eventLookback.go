@@ -0,0 +1,41 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"time"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// newestHubHistory returns the time of the newest history entry of each template in the hub status
+func newestHubHistory(hubStatus policiesv1.PolicyStatus) map[string]time.Time {
+	newest := map[string]time.Time{}
+
+	for _, dpt := range hubStatus.Details {
+		for _, entry := range dpt.History {
+			if entry.LastTimestamp.Time.After(newest[dpt.TemplateMeta.Name]) {
+				newest[dpt.TemplateMeta.Name] = entry.LastTimestamp.Time
+			}
+		}
+	}
+
+	return newest
+}
+
+// isOutsideLookback returns true if the event is older than the event lookback window and not newer than the
+// history recorded on the hub for the template, so that the old transitions of events with a long TTL aren't
+// replayed at startup while the transitions that never reached the hub still are. Events without a time
+// are always considered.
+func (r *PolicyReconciler) isOutsideLookback(event *corev1.Event, hubNewest time.Time, now time.Time) bool {
+	if r.EventLookback <= 0 || event.LastTimestamp.IsZero() {
+		return false
+	}
+
+	if !event.LastTimestamp.Time.Before(now.Add(-r.EventLookback)) {
+		return false
+	}
+
+	return !event.LastTimestamp.Time.After(hubNewest)
+}
@@ -12,45 +12,55 @@ import (
 
 var policiesv1APIVersion = policiesv1.SchemeGroupVersion.Group + "/" + policiesv1.SchemeGroupVersion.Version
 
-var eventPredicateFuncs = predicate.Funcs{
-	UpdateFunc: func(e event.UpdateEvent) bool {
-		eventObjNew, eventObjNewOK := e.ObjectNew.(*corev1.Event)
-		if !eventObjNewOK {
+// isOwnEvent returns true if the event was emitted by this controller, whose events have the component. These
+// events never contribute to the compliance history, so they shouldn't trigger a reconcile.
+func isOwnEvent(event *corev1.Event, component string) bool {
+	return event.Source.Component == component
+}
+
+// eventPredicates returns the predicates for the policy events that weren't emitted by this controller, whose
+// events have the component
+func eventPredicates(component string) predicate.Funcs {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			eventObjNew, eventObjNewOK := e.ObjectNew.(*corev1.Event)
+			if !eventObjNewOK || isOwnEvent(eventObjNew, component) {
+				return false
+			}
+			// eventObjOld := e.ObjectOld.(*corev1.Event)
+			if eventObjNew.InvolvedObject.Kind == policiesv1.Kind &&
+				eventObjNew.InvolvedObject.APIVersion == policiesv1APIVersion {
+				return true
+			}
+
 			return false
-		}
-		// eventObjOld := e.ObjectOld.(*corev1.Event)
-		if eventObjNew.InvolvedObject.Kind == policiesv1.Kind &&
-			eventObjNew.InvolvedObject.APIVersion == policiesv1APIVersion {
-			return true
-		}
-
-		return false
-	},
-	CreateFunc: func(e event.CreateEvent) bool {
-		eventObj, eventObjOk := e.Object.(*corev1.Event)
-		if !eventObjOk {
+		},
+		CreateFunc: func(e event.CreateEvent) bool {
+			eventObj, eventObjOk := e.Object.(*corev1.Event)
+			if !eventObjOk || isOwnEvent(eventObj, component) {
+				return false
+			}
+			if eventObj.InvolvedObject.Kind == policiesv1.Kind &&
+				eventObj.InvolvedObject.APIVersion == policiesv1APIVersion {
+				return true
+			}
+
+			return false
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			eventObj, eventObjOk := e.Object.(*corev1.Event)
+			if !eventObjOk || isOwnEvent(eventObj, component) {
+				return false
+			}
+			if eventObj.InvolvedObject.Kind == policiesv1.Kind &&
+				eventObj.InvolvedObject.APIVersion == policiesv1APIVersion {
+				return true
+			}
+
 			return false
-		}
-		if eventObj.InvolvedObject.Kind == policiesv1.Kind &&
-			eventObj.InvolvedObject.APIVersion == policiesv1APIVersion {
-			return true
-		}
-
-		return false
-	},
-	GenericFunc: func(e event.GenericEvent) bool {
-		eventObj, eventObjOk := e.Object.(*corev1.Event)
-		if !eventObjOk {
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
 			return false
-		}
-		if eventObj.InvolvedObject.Kind == policiesv1.Kind &&
-			eventObj.InvolvedObject.APIVersion == policiesv1APIVersion {
-			return true
-		}
-
-		return false
-	},
-	DeleteFunc: func(e event.DeleteEvent) bool {
-		return false
-	},
+		},
+	}
 }
@@ -0,0 +1,53 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var substitutedTimestampsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "policy_status_sync_substituted_event_timestamps_total",
+		Help: "The number of policy template events without a last timestamp whose compliance history entry has " +
+			"a substituted timestamp, by the source of the timestamp",
+	},
+	[]string{"source"},
+)
+
+func init() {
+	metrics.Registry.MustRegister(substitutedTimestampsTotal)
+}
+
+// eventTimestamp returns the timestamp of the compliance history entry of the event. Some template
+// controllers record events without a last timestamp, so the event time, the first timestamp, the creation
+// timestamp, or else the time the event was received is substituted in that order, so that the history
+// stays in order. The source of a substituted timestamp is returned, which is empty if the last timestamp is
+// set.
+func eventTimestamp(event *corev1.Event) (metav1.Time, string) {
+	creationTimestamp := event.GetCreationTimestamp()
+
+	switch {
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp, ""
+	case !event.EventTime.IsZero():
+		return metav1.NewTime(event.EventTime.Time), "event time"
+	case !event.FirstTimestamp.IsZero():
+		return event.FirstTimestamp, "first timestamp"
+	case !creationTimestamp.IsZero():
+		return creationTimestamp, "creation time"
+	default:
+		return metav1.NewTime(time.Now()), "receive time"
+	}
+}
+
+// substitutedMessage flags the substitution of the timestamp of a compliance history entry from the source
+// in its message
+func substitutedMessage(message string, source string) string {
+	return message + " (the event had no timestamp, its " + source + " was used)"
+}
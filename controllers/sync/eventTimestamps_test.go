@@ -0,0 +1,116 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEventTimestamp(t *testing.T) {
+	t.Parallel()
+
+	lastTimestamp := metav1.NewTime(time.Date(2022, 1, 1, 0, 4, 0, 0, time.UTC))
+	eventTime := metav1.NewMicroTime(time.Date(2022, 1, 1, 0, 3, 0, 0, time.UTC))
+	firstTimestamp := metav1.NewTime(time.Date(2022, 1, 1, 0, 2, 0, 0, time.UTC))
+	creationTimestamp := metav1.NewTime(time.Date(2022, 1, 1, 0, 1, 0, 0, time.UTC))
+
+	tests := map[string]struct {
+		lastTimestamp     metav1.Time
+		eventTime         metav1.MicroTime
+		firstTimestamp    metav1.Time
+		creationTimestamp metav1.Time
+		expected          time.Time
+		source            string
+	}{
+		"last timestamp": {
+			lastTimestamp, eventTime, firstTimestamp, creationTimestamp, lastTimestamp.Time, "",
+		},
+		"event time": {
+			metav1.Time{}, eventTime, firstTimestamp, creationTimestamp, eventTime.Time, "event time",
+		},
+		"first timestamp": {
+			metav1.Time{}, metav1.MicroTime{}, firstTimestamp, creationTimestamp, firstTimestamp.Time,
+			"first timestamp",
+		},
+		"creation time": {
+			metav1.Time{}, metav1.MicroTime{}, metav1.Time{}, creationTimestamp, creationTimestamp.Time,
+			"creation time",
+		},
+		"receive time": {
+			metav1.Time{}, metav1.MicroTime{}, metav1.Time{}, metav1.Time{}, time.Time{}, "receive time",
+		},
+	}
+
+	for name, test := range tests {
+		event := testEvent(0, "NonCompliant; violation")
+		event.LastTimestamp = test.lastTimestamp
+		event.EventTime = test.eventTime
+		event.FirstTimestamp = test.firstTimestamp
+		event.SetCreationTimestamp(test.creationTimestamp)
+
+		before := time.Now()
+		timestamp, source := eventTimestamp(event)
+
+		if source != test.source {
+			t.Fatalf("%s: expected the timestamp source %q, got %q", name, test.source, source)
+		}
+
+		// the receive time is the time of the call
+		if test.expected.IsZero() {
+			if timestamp.Time.Before(before) || timestamp.Time.After(time.Now()) {
+				t.Fatalf("%s: expected the receive time, got %v", name, timestamp)
+			}
+
+			continue
+		}
+
+		if !timestamp.Time.Equal(test.expected) {
+			t.Fatalf("%s: expected the timestamp %v, got %v", name, test.expected, timestamp)
+		}
+	}
+}
+
+func TestParseEventSubstitutedTimestamp(t *testing.T) {
+	t.Parallel()
+
+	firstTimestamp := metav1.NewTime(time.Date(2022, 1, 1, 0, 2, 0, 0, time.UTC))
+
+	tests := map[string]struct {
+		lastTimestamp metav1.Time
+		expected      time.Time
+		message       string
+	}{
+		"last timestamp": {
+			testEvent(1, "").LastTimestamp, testEvent(1, "").LastTimestamp.Time, "NonCompliant; violation",
+		},
+		"substituted timestamp": {
+			metav1.Time{}, firstTimestamp.Time,
+			"NonCompliant; violation (the event had no timestamp, its first timestamp was used)",
+		},
+	}
+
+	for name, test := range tests {
+		event := testEvent(1, "NonCompliant; violation")
+		event.LastTimestamp = test.lastTimestamp
+		event.FirstTimestamp = firstTimestamp
+
+		parsed := parseEvent(event)
+
+		if !parsed.history.LastTimestamp.Time.Equal(test.expected) {
+			t.Fatalf("%s: expected the history timestamp %v, got %v", name, test.expected,
+				parsed.history.LastTimestamp)
+		}
+
+		if parsed.history.Message != test.message {
+			t.Fatalf("%s: expected the history message %q, got %q", name, test.message, parsed.history.Message)
+		}
+
+		// the substitution doesn't make the compliance state unknown
+		if parsed.unknownCompliance {
+			t.Fatalf("%s: expected the compliance state of the substituted entry to be known", name)
+		}
+	}
+}
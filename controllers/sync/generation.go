@@ -0,0 +1,57 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"strconv"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+)
+
+const (
+	// ObservedGenerationAnnotation is set on the template metadata in the status to the generation of the
+	// managed policy that the status was computed from. The Policy CRD has no observedGeneration field.
+	ObservedGenerationAnnotation = "policy.open-cluster-management.io/observed-generation"
+	// ObservedUIDAnnotation is set on the template metadata in the status to the UID of the managed policy,
+	// since the generation restarts when the managed policy is recreated
+	ObservedUIDAnnotation = "policy.open-cluster-management.io/observed-uid"
+)
+
+// withObservedGeneration returns a copy of the status with the generation and UID of the managed policy
+// recorded on every template
+func withObservedGeneration(status policiesv1.PolicyStatus, managedPlc *policiesv1.Policy) policiesv1.PolicyStatus {
+	observed := *status.DeepCopy()
+
+	for _, dpt := range observed.Details {
+		annotations := dpt.TemplateMeta.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+
+		annotations[ObservedGenerationAnnotation] = strconv.FormatInt(managedPlc.GetGeneration(), 10)
+		annotations[ObservedUIDAnnotation] = string(managedPlc.GetUID())
+
+		dpt.TemplateMeta.SetAnnotations(annotations)
+	}
+
+	return observed
+}
+
+// isStaleStatus returns true if the hub status was computed from a newer generation of the managed policy,
+// such as when a requeued request is processed with an outdated cached policy. Writing the status would
+// overwrite the newer status on the hub.
+func isStaleStatus(hubStatus policiesv1.PolicyStatus, managedPlc *policiesv1.Policy) bool {
+	for _, dpt := range hubStatus.Details {
+		annotations := dpt.TemplateMeta.GetAnnotations()
+		if annotations[ObservedUIDAnnotation] != string(managedPlc.GetUID()) {
+			continue
+		}
+
+		generation, err := strconv.ParseInt(annotations[ObservedGenerationAnnotation], 10, 64)
+		if err == nil && generation > managedPlc.GetGeneration() {
+			return true
+		}
+	}
+
+	return false
+}
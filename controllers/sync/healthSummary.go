@@ -0,0 +1,270 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/stolostron/governance-policy-status-sync/tool"
+)
+
+const (
+	// HealthSummaryEventName is the name of the Event in the namespace of the agent with its health summary
+	HealthSummaryEventName = "policy-status-sync-health"
+	// HealthyReason is the reason of the health summary Event while the agent is healthy
+	HealthyReason = "StatusSyncHealthy"
+	// DegradedReason is the reason of the health summary Event while the agent is degraded
+	DegradedReason = "StatusSyncDegraded"
+	// healthSummaryCheckInterval is the interval at which the health is checked for a significant change
+	healthSummaryCheckInterval = 10 * time.Second
+	// hubCommunicationStaleAfter is how long without a successful hub API request the hub is unreachable
+	hubCommunicationStaleAfter = 10 * time.Minute
+	// queueStallAfter is how long the hub write queue may make no progress before the agent is degraded
+	queueStallAfter = 5 * time.Minute
+)
+
+// healthSummary is the health of the agent in the health summary Event
+type healthSummary struct {
+	// problems are the reasons why the agent is degraded, which is healthy if there are none
+	problems []string
+	message  string
+}
+
+// HealthSummary keeps a single Event in the namespace of the agent on the managed cluster up to date with a
+// summary of its health, which is the depth of its hub write queue, whether the hub is reachable, and its last
+// sync error, so that the managed cluster administrators without a metrics stack have a visible health signal
+// with kubectl get events. The Event is updated at the Interval, and as soon as the agent becomes degraded or
+// healthy again. It must be added to the manager, and only runs on the leader.
+type HealthSummary struct {
+	// Client writes the Event
+	Client kubernetes.Interface
+	// Namespace is the namespace of the agent
+	Namespace string
+	// Pod is the name of the agent pod, which is the involved object of the Event
+	Pod string
+	// Component is the source component of the Event, which defaults to ControllerName
+	Component string
+	// Interval is the interval at which the Event is updated when the health doesn't change
+	Interval time.Duration
+	// CheckHub degrades the agent when no hub API request succeeded recently, which is disabled when there is
+	// no hub cluster, such as with the fake hub
+	CheckHub bool
+	// reported is the health of the last update of the Event and when it was updated
+	reported   *healthSummary
+	reportedAt time.Time
+}
+
+// NeedLeaderElection is true since the standby replicas don't sync the status
+func (h *HealthSummary) NeedLeaderElection() bool {
+	return true
+}
+
+// Start updates the Event until the context is done
+func (h *HealthSummary) Start(ctx context.Context) error {
+	ticker := time.NewTicker(healthSummaryCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		summary := h.summarize(time.Now())
+
+		if h.changed(summary) || time.Since(h.reportedAt) >= h.Interval {
+			if err := h.report(ctx, summary); err != nil {
+				log.Error(err, "Failed to update the health summary Event", "Namespace", h.Namespace,
+					"Name", HealthSummaryEventName)
+			} else {
+				h.reported = &summary
+				h.reportedAt = time.Now()
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// changed returns true if the agent became degraded or healthy, or is degraded for other reasons, since the
+// last update of the Event
+func (h *HealthSummary) changed(summary healthSummary) bool {
+	if h.reported == nil {
+		return true
+	}
+
+	return strings.Join(summary.problems, ",") != strings.Join(h.reported.problems, ",")
+}
+
+// summarize returns the health of the agent
+func (h *HealthSummary) summarize(now time.Time) healthSummary {
+	summary := healthSummary{}
+	details := []string{}
+
+	hubWriteQueues.lock.Lock()
+
+	queued := 0
+	stalled := time.Duration(0)
+
+	for _, queue := range hubWriteQueues.queues {
+		queued += queue.len()
+
+		if stalledFor := queue.stalledFor(); stalledFor > stalled {
+			stalled = stalledFor
+		}
+	}
+
+	hubWriteQueues.lock.Unlock()
+
+	if stalled > queueStallAfter {
+		summary.problems = append(summary.problems, "queue")
+		details = append(details, fmt.Sprintf("%d policies are queued and the queue has made no progress for %s",
+			queued, stalled.Round(time.Second)))
+	} else {
+		details = append(details, fmt.Sprintf("%d policies are queued", queued))
+	}
+
+	if h.CheckHub {
+		last := tool.LastHubCommunication()
+
+		switch {
+		case last.IsZero():
+			summary.problems = append(summary.problems, "hub")
+			details = append(details, "the hub hasn't been reached")
+		case now.Sub(last) > hubCommunicationStaleAfter:
+			summary.problems = append(summary.problems, "hub")
+			details = append(details, fmt.Sprintf("the hub hasn't been reached for %s",
+				now.Sub(last).Round(time.Second)))
+		default:
+			details = append(details, "the hub is reachable")
+		}
+	}
+
+	if policy, lastError, ok := lastSyncError(); ok {
+		failing := persistentlyFailingPolicies()
+		if failing > 0 {
+			summary.problems = append(summary.problems, "sync")
+		}
+
+		details = append(details, fmt.Sprintf(
+			"%d policies persistently fail to sync, the last sync error was at %s for %s: %s", failing,
+			lastError.Time.Format(time.RFC3339), policy, lastError.Error,
+		))
+	} else {
+		details = append(details, "no policy failed to sync")
+	}
+
+	state := "healthy"
+	if len(summary.problems) > 0 {
+		state = "degraded"
+	}
+
+	summary.message = fmt.Sprintf("The policy status sync is %s: %s", state, strings.Join(details, ", "))
+
+	return summary
+}
+
+// lastSyncError returns the most recent sync error of the policies and its policy, or false if there is none
+func lastSyncError() (string, syncError, bool) {
+	var (
+		policy string
+		last   syncError
+	)
+
+	found := false
+
+	for _, policyErrors := range syncErrors("", "") {
+		for _, recent := range policyErrors.RecentErrors {
+			if !found || recent.Time.After(last.Time) {
+				policy = policyErrors.Namespace + "/" + policyErrors.Name
+				last = recent
+				found = true
+			}
+		}
+	}
+
+	return policy, last, found
+}
+
+// persistentlyFailingPolicies returns the number of policies whose last persistentSyncFailures syncs failed
+func persistentlyFailingPolicies() int {
+	failing := 0
+
+	for _, policyErrors := range syncErrors("", "") {
+		if policyErrors.ConsecutiveFailures >= persistentSyncFailures {
+			failing++
+		}
+	}
+
+	return failing
+}
+
+// component returns the source component of the Event
+func (h *HealthSummary) component() string {
+	if h.Component == "" {
+		return ControllerName
+	}
+
+	return h.Component
+}
+
+// report creates or updates the Event with the health summary
+func (h *HealthSummary) report(ctx context.Context, summary healthSummary) error {
+	eventType := corev1.EventTypeNormal
+	reason := HealthyReason
+
+	if len(summary.problems) > 0 {
+		eventType = corev1.EventTypeWarning
+		reason = DegradedReason
+	}
+
+	now := metav1.NewTime(time.Now())
+	events := h.Client.CoreV1().Events(h.Namespace)
+
+	event, err := events.Get(ctx, HealthSummaryEventName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = events.Create(ctx, &corev1.Event{
+			ObjectMeta: metav1.ObjectMeta{Name: HealthSummaryEventName, Namespace: h.Namespace},
+			InvolvedObject: corev1.ObjectReference{
+				APIVersion: "v1", Kind: "Pod", Namespace: h.Namespace, Name: h.Pod,
+			},
+			Reason:         reason,
+			Message:        summary.message,
+			Type:           eventType,
+			Source:         corev1.EventSource{Component: h.component()},
+			FirstTimestamp: now,
+			LastTimestamp:  now,
+			Count:          1,
+		}, metav1.CreateOptions{})
+
+		return err
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if event.InvolvedObject.Name != h.Pod || event.Reason != reason {
+		// the Event restarts for a new pod or when the agent becomes degraded or healthy
+		event.FirstTimestamp = now
+		event.Count = 0
+	}
+
+	event.InvolvedObject = corev1.ObjectReference{APIVersion: "v1", Kind: "Pod", Namespace: h.Namespace, Name: h.Pod}
+	event.Reason = reason
+	event.Message = summary.message
+	event.Type = eventType
+	event.LastTimestamp = now
+	event.Count++
+
+	_, err = events.Update(ctx, event, metav1.UpdateOptions{})
+
+	return err
+}
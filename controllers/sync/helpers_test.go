@@ -0,0 +1,108 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"strconv"
+	"time"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	"github.com/stolostron/governance-policy-propagator/controllers/common"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// mappedClient is a fake client with a RESTMapper, which the fake client of controller-runtime doesn't have
+type mappedClient struct {
+	client.Client
+	mapper meta.RESTMapper
+}
+
+func (c mappedClient) RESTMapper() meta.RESTMapper {
+	return c.mapper
+}
+
+// fakeClient returns a controller-runtime fake client of the policies and the Kubernetes objects, whose
+// RESTMapper only has the ConfigurationPolicy kind of the policy templates
+func fakeClient(objects ...client.Object) client.Client {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = policiesv1.AddToScheme(scheme)
+
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{
+		Group: "policy.open-cluster-management.io", Version: "v1", Kind: "ConfigurationPolicy",
+	}, meta.RESTScopeNamespace)
+
+	return mappedClient{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build(),
+		mapper: mapper,
+	}
+}
+
+// testPolicy returns a replicated policy of the policies.policy root policy in the cluster1 namespace, which
+// is the name of the policy on both the hub and the managed cluster
+func testPolicy() *policiesv1.Policy {
+	return &policiesv1.Policy{
+		TypeMeta: metav1.TypeMeta{Kind: policiesv1.Kind, APIVersion: policiesv1.GroupVersion.String()},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "cluster1",
+			Name:      "policies.policy",
+			Labels:    map[string]string{common.RootPolicyLabel: "policies.policy"},
+		},
+	}
+}
+
+// testHistory returns a noncompliant history entry for each event, in the order of the events, with the
+// timestamp of the minute of the event
+func testHistory(events ...int) []policiesv1.ComplianceHistory {
+	history := []policiesv1.ComplianceHistory{}
+
+	for _, event := range events {
+		history = append(history, policiesv1.ComplianceHistory{
+			LastTimestamp: metav1.NewTime(time.Date(2022, 1, 1, 0, event, 0, 0, time.UTC)),
+			Message:       "NonCompliant; violation " + strconv.Itoa(event),
+			EventName:     "policy.event" + strconv.Itoa(event),
+		})
+	}
+
+	return history
+}
+
+// testStatus returns a status with the compliance state and a template for each name, which all have the
+// compliance state and the history
+func testStatus(
+	compliance policiesv1.ComplianceState, history []policiesv1.ComplianceHistory, templates ...string,
+) policiesv1.PolicyStatus {
+	status := policiesv1.PolicyStatus{ComplianceState: compliance}
+
+	for _, template := range templates {
+		status.Details = append(status.Details, &policiesv1.DetailsPerTemplate{
+			TemplateMeta:    metav1.ObjectMeta{Name: template},
+			ComplianceState: compliance,
+			History:         history,
+		})
+	}
+
+	return status
+}
+
+// testEvent returns an event of the template1 template of the test policy with the message, which was last
+// seen at the minute of the event
+func testEvent(event int, message string) *corev1.Event {
+	return &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "cluster1", Name: "policy.event" + strconv.Itoa(event)},
+		InvolvedObject: corev1.ObjectReference{
+			Kind: policiesv1.Kind, APIVersion: policiesv1APIVersion, Namespace: "cluster1", Name: "policies.policy",
+		},
+		Reason:        "policy: cluster1/template1",
+		Message:       message,
+		LastTimestamp: metav1.NewTime(time.Date(2022, 1, 1, 0, event, 0, 0, time.UTC)),
+	}
+}
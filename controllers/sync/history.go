@@ -0,0 +1,212 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/stolostron/governance-policy-status-sync/policystatus"
+)
+
+// historyRecentEntries is the number of the most recent history entries that are kept as they are
+const historyRecentEntries = 10
+
+// historyKey returns the idempotency key of a compliance history entry, which is a hash of the policy UID,
+// the template name, the message, and the event time. Entries with the same key are the same compliance
+// event, even if they came from different Event objects, such as when events are replayed after a crash.
+// The key is derived from the fields of the entry rather than stored with it, since the Policy CRD on the
+// hub has no field for it.
+func historyKey(policyUID types.UID, templateName string, history policiesv1.ComplianceHistory) string {
+	hash := sha256.New()
+
+	for _, part := range []string{
+		string(policyUID),
+		templateName,
+		history.Message,
+		// the timestamps are serialized with a precision of seconds
+		history.LastTimestamp.UTC().Format(time.RFC3339),
+	} {
+		hash.Write([]byte(part))
+		// separate the parts so that different splits of the same bytes don't collide
+		hash.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// mergeHistory merges the history entries built from the current events with the existing history entries
+// in the status, keeping only the first entry for each idempotency key.
+func mergeHistory(
+	policyUID types.UID, templateName string, events []policiesv1.ComplianceHistory,
+	existing []policiesv1.ComplianceHistory,
+) []policiesv1.ComplianceHistory {
+	merged := make([]policiesv1.ComplianceHistory, 0, len(events)+len(existing))
+	seen := make(map[string]bool, len(events)+len(existing))
+
+	for _, entries := range [][]policiesv1.ComplianceHistory{events, existing} {
+		for _, entry := range entries {
+			key := historyKey(policyUID, templateName, entry)
+			if seen[key] {
+				continue
+			}
+
+			seen[key] = true
+
+			merged = append(merged, entry)
+		}
+	}
+
+	return merged
+}
+
+// historyCompliance returns the compliance state of a compliance history entry from its message, which is
+// NonCompliant if it's unknown
+func historyCompliance(message string) policiesv1.ComplianceState {
+	if compliance, ok := policystatus.Compliance(message); ok {
+		return compliance
+	}
+
+	return policiesv1.NonCompliant
+}
+
+// templateHistory is a compliance history entry of a policy template
+type templateHistory struct {
+	templateName string
+	entry        policiesv1.ComplianceHistory
+}
+
+// newHistoryEntries returns the compliance history entries in the written status that aren't in the
+// previous status. Summarized entries are skipped since they don't correspond to a compliance event.
+func newHistoryEntries(
+	policyUID types.UID, previous policiesv1.PolicyStatus, written policiesv1.PolicyStatus,
+) []templateHistory {
+	previousKeys := map[string]bool{}
+
+	for _, dpt := range previous.Details {
+		for _, entry := range dpt.History {
+			previousKeys[historyKey(policyUID, dpt.TemplateMeta.Name, entry)] = true
+		}
+	}
+
+	added := []templateHistory{}
+
+	for _, dpt := range written.Details {
+		for _, entry := range dpt.History {
+			if _, summary := parseSummary(entry); summary {
+				continue
+			}
+
+			if !previousKeys[historyKey(policyUID, dpt.TemplateMeta.Name, entry)] {
+				added = append(added, templateHistory{templateName: dpt.TemplateMeta.Name, entry: entry})
+			}
+		}
+	}
+
+	return added
+}
+
+// historySummary is a run of history entries with the same compliance state
+type historySummary struct {
+	compliance policiesv1.ComplianceState
+	count      int
+	start      time.Time
+	end        time.Time
+}
+
+// parseSummary returns the summary of a summarized history entry, or false if the entry isn't a summary
+func parseSummary(entry policiesv1.ComplianceHistory) (historySummary, bool) {
+	parsed := policystatus.ParseEntry(entry)
+	if !parsed.Summary {
+		return historySummary{}, false
+	}
+
+	return historySummary{
+		compliance: parsed.Compliance, count: parsed.Count, start: parsed.Start, end: parsed.End,
+	}, true
+}
+
+// entry returns the history entry of the summary, which is sorted by the time of its newest entry
+func (s historySummary) entry() policiesv1.ComplianceHistory {
+	return policiesv1.ComplianceHistory{
+		LastTimestamp: metav1.NewTime(s.end),
+		Message: fmt.Sprintf("%s %d times between %s and %s", s.compliance, s.count,
+			s.start.UTC().Format(time.RFC3339), s.end.UTC().Format(time.RFC3339)),
+	}
+}
+
+// compactHistory keeps the most recent history entries as they are and collapses the older entries into
+// up to maxSummaries summarized entries, one for each run of entries with the same compliance state, such
+// as "NonCompliant 14 times between X and Y". This preserves the long-term compliance signal while bounding
+// the size of the status. The history must be sorted from newest to oldest. If maxSummaries is 0, the
+// older entries are dropped.
+func compactHistory(history []policiesv1.ComplianceHistory, maxSummaries int) []policiesv1.ComplianceHistory {
+	if len(history) <= historyRecentEntries {
+		return history
+	}
+
+	compacted := append([]policiesv1.ComplianceHistory{}, history[:historyRecentEntries]...)
+
+	if maxSummaries <= 0 {
+		return compacted
+	}
+
+	older := history[historyRecentEntries:]
+
+	// entries that are as old as an existing summary were already counted in it, but may still be listed
+	// from their events
+	var summarizedUntil time.Time
+
+	for _, entry := range older {
+		if summary, ok := parseSummary(entry); ok && summary.end.After(summarizedUntil) {
+			summarizedUntil = summary.end
+		}
+	}
+
+	summaries := []historySummary{}
+
+	for _, entry := range older {
+		summary, ok := parseSummary(entry)
+		if !ok {
+			if !entry.LastTimestamp.Time.After(summarizedUntil) {
+				continue
+			}
+
+			summary = historySummary{
+				compliance: historyCompliance(entry.Message),
+				count:      1,
+				start:      entry.LastTimestamp.Time,
+				end:        entry.LastTimestamp.Time,
+			}
+		}
+
+		last := len(summaries) - 1
+		if last >= 0 && summaries[last].compliance == summary.compliance {
+			// the entries are sorted from newest to oldest
+			summaries[last].count += summary.count
+			if summary.start.Before(summaries[last].start) {
+				summaries[last].start = summary.start
+			}
+
+			continue
+		}
+
+		summaries = append(summaries, summary)
+	}
+
+	for i, summary := range summaries {
+		if i == maxSummaries {
+			break
+		}
+
+		compacted = append(compacted, summary.entry())
+	}
+
+	return compacted
+}
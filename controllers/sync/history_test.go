@@ -0,0 +1,130 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"testing"
+	"time"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// legacyMergeHistory is the merge before the idempotency key, which matched the existing entries to the
+// events by the event name and the exact timestamp
+func legacyMergeHistory(events, existing []policiesv1.ComplianceHistory) []policiesv1.ComplianceHistory {
+	merged := append([]policiesv1.ComplianceHistory{}, events...)
+
+	for _, ech := range existing {
+		exists := false
+
+		for _, ch := range events {
+			if ch.LastTimestamp.Time.Equal(ech.LastTimestamp.Time) && ch.EventName == ech.EventName {
+				exists = true
+
+				break
+			}
+		}
+
+		if !exists {
+			merged = append(merged, ech)
+		}
+	}
+
+	return merged
+}
+
+func TestHistoryKey(t *testing.T) {
+	t.Parallel()
+
+	entry := testHistory(1)[0]
+
+	renamed := entry
+	renamed.EventName = "policy.replayed"
+
+	subSecond := entry
+	subSecond.LastTimestamp = metav1.NewTime(entry.LastTimestamp.Add(500 * time.Millisecond))
+
+	otherMessage := entry
+	otherMessage.Message = "Compliant; notification"
+
+	otherTime := entry
+	otherTime.LastTimestamp = metav1.NewTime(entry.LastTimestamp.Add(time.Second))
+
+	tests := map[string]struct {
+		policyUID    types.UID
+		templateName string
+		entry        policiesv1.ComplianceHistory
+		same         bool
+	}{
+		"same entry":             {"uid", "template", entry, true},
+		"other event name":       {"uid", "template", renamed, true},
+		"sub-second time":        {"uid", "template", subSecond, true},
+		"other message":          {"uid", "template", otherMessage, false},
+		"other time":             {"uid", "template", otherTime, false},
+		"other template":         {"uid", "other-template", entry, false},
+		"other policy":           {"other-uid", "template", entry, false},
+		"split between the keys": {"uidtemplate", "", entry, false},
+	}
+
+	key := historyKey("uid", "template", entry)
+
+	for name, test := range tests {
+		if same := historyKey(test.policyUID, test.templateName, test.entry) == key; same != test.same {
+			t.Fatalf("%s: expected the key to be the same: %v, got %v", name, test.same, same)
+		}
+	}
+}
+
+func TestMergeHistory(t *testing.T) {
+	t.Parallel()
+
+	entry := testHistory(1)[0]
+
+	// the same compliance event that was replayed from another Event object after a crash
+	replayed := entry
+	replayed.EventName = "policy.replayed"
+
+	// the same compliance event with the sub-second precision of the event, which is lost in the status
+	precise := entry
+	precise.LastTimestamp = metav1.NewTime(entry.LastTimestamp.Add(500 * time.Millisecond))
+
+	// an event that reuses the name of the Event object with a new message
+	updated := entry
+	updated.Message = "Compliant; notification"
+
+	tests := map[string]struct {
+		events   []policiesv1.ComplianceHistory
+		existing []policiesv1.ComplianceHistory
+		// expected is the number of entries merged by the idempotency key and legacy by the event name
+		expected int
+		legacy   int
+	}{
+		"entry in the status":       {[]policiesv1.ComplianceHistory{entry}, testHistory(1), 1, 1},
+		"new entry":                 {testHistory(2), testHistory(1), 2, 2},
+		"replayed event":            {[]policiesv1.ComplianceHistory{replayed}, testHistory(1), 1, 2},
+		"sub-second event time":     {[]policiesv1.ComplianceHistory{precise}, testHistory(1), 1, 2},
+		"updated message":           {[]policiesv1.ComplianceHistory{updated}, testHistory(1), 2, 1},
+		"replayed events":           {[]policiesv1.ComplianceHistory{entry, replayed}, nil, 1, 2},
+		"duplicated status entries": {nil, testHistory(1, 1), 1, 2},
+		"no history":                {nil, nil, 0, 0},
+	}
+
+	for name, test := range tests {
+		merged := mergeHistory("uid", "template", test.events, test.existing)
+		if len(merged) != test.expected {
+			t.Fatalf("%s: expected %d merged entries, got %d", name, test.expected, len(merged))
+		}
+
+		if legacy := legacyMergeHistory(test.events, test.existing); len(legacy) != test.legacy {
+			t.Fatalf("%s: expected %d entries merged by the event name, got %d", name, test.legacy, len(legacy))
+		}
+
+		// the entry of the events is kept over the existing entry
+		if len(test.events) != 0 && merged[0] != test.events[0] {
+			t.Fatalf("%s: expected the event entry %s to be kept, got %s", name, test.events[0].EventName,
+				merged[0].EventName)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"context"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// StatusFieldManager is the server-side apply field manager of the hub status fields that are written by
+// this controller
+const StatusFieldManager = "governance-policy-status-sync"
+
+// applyHubStatus writes the per-cluster status fields of the hub policy with server-side apply, so that the
+// managed fields of the hub policy only list the compliant and details status fields as owned by the hub
+// field manager, and the fields of the other writers of the policy, such as the spec of the propagator,
+// are never sent. The resource version is sent so that a concurrent write is still a conflict. The hub policy
+// is updated with the response.
+func (r *PolicyReconciler) applyHubStatus(ctx context.Context, hubPlc *policiesv1.Policy, dryRun bool) error {
+	applyPlc, err := statusApplyConfiguration(hubPlc)
+	if err != nil {
+		return err
+	}
+
+	opts := []client.PatchOption{client.FieldOwner(r.applyFieldManager()), client.ForceOwnership}
+	if dryRun {
+		opts = append(opts, client.DryRunAll)
+	}
+
+	if r.HubCapabilities.hasStatusSubresource() {
+		err = r.HubClient.Status().Patch(ctx, applyPlc, client.Apply, opts...)
+	} else {
+		// without a status subresource, the status is applied to the policy itself
+		err = r.HubClient.Patch(ctx, applyPlc, client.Apply, opts...)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(applyPlc.Object, hubPlc)
+}
+
+// statusApplyConfiguration returns the apply configuration of the hub policy, which only has the identity of
+// the policy and the status fields that are owned by this controller. A cleared status field is left out,
+// which removes it from the hub since it's owned by this controller.
+func statusApplyConfiguration(hubPlc *policiesv1.Policy) (*unstructured.Unstructured, error) {
+	status, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&hubPlc.Status)
+	if err != nil {
+		return nil, err
+	}
+
+	applyStatus := map[string]interface{}{}
+
+	for _, field := range []string{"compliant", "details"} {
+		if value, ok := status[field]; ok {
+			applyStatus[field] = value
+		}
+	}
+
+	applyPlc := &unstructured.Unstructured{Object: map[string]interface{}{"status": applyStatus}}
+	applyPlc.SetGroupVersionKind(policiesv1.SchemeGroupVersion.WithKind("Policy"))
+	applyPlc.SetNamespace(hubPlc.GetNamespace())
+	applyPlc.SetName(hubPlc.GetName())
+	applyPlc.SetResourceVersion(hubPlc.GetResourceVersion())
+
+	return applyPlc, nil
+}
+
+// applyFieldManager returns the field manager of the server-side apply of the hub status, which is
+// StatusFieldManager unless HubFieldManager is set
+func (r *PolicyReconciler) applyFieldManager() string {
+	if r.HubFieldManager != "" {
+		return r.HubFieldManager
+	}
+
+	return StatusFieldManager
+}
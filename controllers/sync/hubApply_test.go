@@ -0,0 +1,182 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// patchRequest is a patch sent by a patchRecordingClient
+type patchRequest struct {
+	status    bool
+	patchType types.PatchType
+	data      map[string]interface{}
+	raw       []byte
+	opts      *client.PatchOptions
+}
+
+// patchRecordingClient records the patches of the hub instead of sending them, and sets the resource version
+// of the patched objects to responseVersion as the response of the hub, unless it fails them with err
+type patchRecordingClient struct {
+	client.Client
+	patches         []patchRequest
+	responseVersion string
+	err             error
+}
+
+func (c *patchRecordingClient) Patch(
+	_ context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption,
+) error {
+	return c.record(false, obj, patch, opts)
+}
+
+func (c *patchRecordingClient) Status() client.StatusWriter {
+	return &patchRecordingStatusWriter{recording: c}
+}
+
+func (c *patchRecordingClient) record(
+	status bool, obj client.Object, patch client.Patch, opts []client.PatchOption,
+) error {
+	data, err := patch.Data(obj)
+	if err != nil {
+		return err
+	}
+
+	request := patchRequest{status: status, patchType: patch.Type(), raw: data, opts: &client.PatchOptions{}}
+	request.opts.ApplyOptions(opts)
+
+	// a JSON patch is a list of operations
+	if patch.Type() != types.JSONPatchType {
+		if err := json.Unmarshal(data, &request.data); err != nil {
+			return err
+		}
+	}
+
+	c.patches = append(c.patches, request)
+
+	if c.err != nil {
+		return c.err
+	}
+
+	obj.SetResourceVersion(c.responseVersion)
+
+	return nil
+}
+
+type patchRecordingStatusWriter struct {
+	client.StatusWriter
+	recording *patchRecordingClient
+}
+
+func (w *patchRecordingStatusWriter) Patch(
+	_ context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption,
+) error {
+	return w.recording.record(true, obj, patch, opts)
+}
+
+func TestApplyHubStatus(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		reconciler   *PolicyReconciler
+		status       bool
+		fieldManager string
+	}{
+		"status subresource": {
+			reconciler:   &PolicyReconciler{},
+			status:       true,
+			fieldManager: StatusFieldManager,
+		},
+		"no status subresource": {
+			reconciler:   &PolicyReconciler{HubCapabilities: &HubCapabilities{statusSubresource: false}},
+			fieldManager: StatusFieldManager,
+		},
+		"field manager": {
+			reconciler:   &PolicyReconciler{HubFieldManager: "custom-manager"},
+			status:       true,
+			fieldManager: "custom-manager",
+		},
+	}
+
+	for name, test := range tests {
+		hubClient := &patchRecordingClient{responseVersion: "2"}
+		test.reconciler.HubClient = hubClient
+		hubPlc := testPolicy()
+		hubPlc.SetResourceVersion("1")
+		hubPlc.Spec.Disabled = true
+		hubPlc.Status = testStatus(policiesv1.NonCompliant, nil, "template")
+		hubPlc.Status.Placement = []*policiesv1.Placement{{PlacementBinding: "binding"}}
+
+		if err := test.reconciler.applyHubStatus(context.TODO(), hubPlc, false); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if len(hubClient.patches) != 1 {
+			t.Fatalf("%s: expected a single patch, got %d", name, len(hubClient.patches))
+		}
+
+		patch := hubClient.patches[0]
+
+		if patch.patchType != types.ApplyPatchType || patch.status != test.status {
+			t.Fatalf("%s: expected an apply patch of the status subresource: %v, got a %s patch of it: %v", name,
+				test.status, patch.patchType, patch.status)
+		}
+
+		if patch.opts.FieldManager != test.fieldManager || patch.opts.Force == nil || !*patch.opts.Force {
+			t.Fatalf("%s: expected the fields to be forced to the %s field manager, got %s", name,
+				test.fieldManager, patch.opts.FieldManager)
+		}
+
+		// only the identity of the policy and the owned status fields are sent
+		if _, found := patch.data["spec"]; found {
+			t.Fatalf("%s: expected the spec to not be sent", name)
+		}
+
+		status, _ := patch.data["status"].(map[string]interface{})
+
+		fields := []string{}
+		for field := range status {
+			fields = append(fields, field)
+		}
+
+		if len(fields) != 2 || status["compliant"] != "NonCompliant" || status["details"] == nil {
+			t.Fatalf("%s: expected only the compliant and details status fields to be sent, got %v", name, fields)
+		}
+
+		metadata, _ := patch.data["metadata"].(map[string]interface{})
+		if metadata["resourceVersion"] != "1" || metadata["name"] != "policies.policy" {
+			t.Fatalf("%s: expected the name and resource version of the policy to be sent, got %v", name, metadata)
+		}
+
+		// the hub policy is updated with the response
+		if hubPlc.GetResourceVersion() != "2" {
+			t.Fatalf("%s: expected the resource version of the response, got %s", name, hubPlc.GetResourceVersion())
+		}
+	}
+}
+
+func TestStatusApplyConfigurationClearedFields(t *testing.T) {
+	t.Parallel()
+
+	hubPlc := testPolicy()
+	hubPlc.Status = testStatus(policiesv1.NonCompliant, nil)
+	hubPlc.Status.Placement = []*policiesv1.Placement{{PlacementBinding: "binding"}}
+
+	applyPlc, err := statusApplyConfiguration(hubPlc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the cleared details are left out so that the hub removes them
+	expected := map[string]interface{}{"compliant": "NonCompliant"}
+	if status := applyPlc.Object["status"]; !reflect.DeepEqual(status, expected) {
+		t.Fatalf("expected the status %v, got %v", expected, status)
+	}
+}
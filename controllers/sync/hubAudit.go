@@ -0,0 +1,43 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"context"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+)
+
+const (
+	// WriterPodAnnotation is set on the hub events of the status writes to the pod of the agent that wrote
+	// the status when the hub write audit is enabled
+	WriterPodAnnotation = "policy.open-cluster-management.io/writer-pod"
+	// WriterVersionAnnotation is set on the hub events of the status writes to the version of the agent that
+	// wrote the status when the hub write audit is enabled
+	WriterVersionAnnotation = "policy.open-cluster-management.io/writer-version"
+)
+
+// HubWriteAudit identifies the agent in the annotations of the hub events of the status writes, so that an
+// unexpected status change on a shared hub can be traced back to the pod, version, and reconcile that wrote
+// it. When it's set, an event is recorded on the hub for every status write. The events are also annotated
+// with the ReconcileIDAnnotation of the write.
+type HubWriteAudit struct {
+	// Pod is the name of the pod of the agent
+	Pod string
+	// Version is the version of the agent
+	Version string
+}
+
+// recordHubWrite records the event of a status write to the hub policy, which is annotated with the reconcile
+// ID of the context, and with the writer of the status if the hub write audit is enabled
+func (r *PolicyReconciler) recordHubWrite(ctx context.Context, instance *policiesv1.Policy, hubPlc *policiesv1.Policy) {
+	annotations := reconcileAnnotations(ctx)
+
+	if r.HubWriteAudit != nil {
+		annotations[WriterPodAnnotation] = r.HubWriteAudit.Pod
+		annotations[WriterVersionAnnotation] = r.HubWriteAudit.Version
+	}
+
+	r.HubRecorder.AnnotatedEventf(instance, annotations, "Normal", "PolicyStatusSync",
+		"Policy %s status was updated in cluster namespace %s", hubPlc.GetName(), hubPlc.GetNamespace())
+}
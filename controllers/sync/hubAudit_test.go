@@ -0,0 +1,93 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	eventsv1 "k8s.io/api/events/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/stolostron/governance-policy-status-sync/tool"
+)
+
+// hubEventRecorder returns the recorder of the hub events with the events.k8s.io/v1 API of a fake hub that
+// grants the permission on the events, and the fake hub client
+func hubEventRecorder(t *testing.T) (record.EventRecorder, *fake.Clientset) {
+	t.Helper()
+
+	client := fake.NewSimpleClientset()
+	client.Resources = []*metav1.APIResourceList{{GroupVersion: eventsv1.SchemeGroupVersion.String()}}
+
+	client.PrependReactor("create", "selfsubjectaccessreviews",
+		func(action clienttesting.Action) (bool, runtime.Object, error) {
+			review := action.(clienttesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+			review.Status.Allowed = true
+
+			return true, review, nil
+		},
+	)
+
+	broadcaster := tool.NewHubEventBroadcaster(client, "", true)
+	t.Cleanup(broadcaster.Shutdown)
+
+	return broadcaster.NewRecorder(runtime.NewScheme(), ControllerName), client
+}
+
+// hubEvent waits for the events.k8s.io/v1 event with the reason to be recorded in the namespace of the hub
+func hubEvent(t *testing.T, client *fake.Clientset, namespace string, reason string) eventsv1.Event {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for time.Now().Before(deadline) {
+		events, err := client.EventsV1().Events(namespace).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, event := range events.Items {
+			if event.Reason == reason {
+				return event
+			}
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("expected the %s event to be recorded on the hub", reason)
+
+	return eventsv1.Event{}
+}
+
+func TestRecordHubWriteAnnotations(t *testing.T) {
+	t.Parallel()
+
+	recorder, client := hubEventRecorder(t)
+	reconciler := &PolicyReconciler{
+		HubRecorder:   recorder,
+		HubWriteAudit: &HubWriteAudit{Pod: "status-sync-abc", Version: "v1.2.3"},
+	}
+
+	ctx := tool.WithReconcileID(context.TODO(), "reconcile-1")
+	reconciler.recordHubWrite(ctx, testPolicy(), testPolicy())
+
+	event := hubEvent(t, client, "cluster1", "PolicyStatusSync")
+
+	for key, expected := range map[string]string{
+		WriterPodAnnotation:     "status-sync-abc",
+		WriterVersionAnnotation: "v1.2.3",
+		ReconcileIDAnnotation:   "reconcile-1",
+	} {
+		if value := event.GetAnnotations()[key]; value != expected {
+			t.Fatalf("expected the %s annotation to be %s, got %q", key, expected, value)
+		}
+	}
+}
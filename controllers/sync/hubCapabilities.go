@@ -0,0 +1,409 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	openapi_v2 "github.com/googleapis/gnostic/openapiv2"
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/discovery"
+
+	"github.com/stolostron/governance-policy-status-sync/tool"
+)
+
+const (
+	hubCapabilitiesInterval = 10 * time.Minute
+	// policyDefinitionSuffix is the suffix of the OpenAPI definition of the Policy kind, whose name is the
+	// reversed API group followed by the version and kind
+	policyDefinitionSuffix = "open-cluster-management.policy.v1.Policy"
+)
+
+// HubCapabilities detects the policy API version and status fields that the hub supports, so that the
+// status sync degrades gracefully when the hub and managed clusters are on different releases. Status
+// fields that the hub Policy CRD doesn't have are removed from the hub status instead of being pruned by
+// the hub on every write, and the status is written with a regular update if the hub has no status
+// subresource. Until the capabilities are detected, or if they can't be, everything is assumed to be
+// supported. It must be added to the manager to be refreshed periodically.
+type HubCapabilities struct {
+	discovery discovery.DiscoveryInterface
+	// phaseKey offsets the periodic detection so that a fleet of agents doesn't query the hub together
+	phaseKey string
+	lock     sync.RWMutex
+	// statusSubresource is false if the hub serves policies without a status subresource
+	statusSubresource bool
+	// fields are the paths of the supported status fields, such as "status.details.history.eventName". It's
+	// nil if the schema is unknown.
+	fields map[string]bool
+	// removedLogged are the paths of the removed status fields that were logged
+	removedLogged map[string]bool
+	// rejectionDetectedAt is the last time the capabilities were detected after the hub rejected a status
+	rejectionDetectedAt time.Time
+}
+
+// NewHubCapabilities returns a HubCapabilities that uses the hub discovery client. The periodic detection
+// is offset by the phase of the cluster name.
+func NewHubCapabilities(discoveryClient discovery.DiscoveryInterface, clusterName string) *HubCapabilities {
+	return &HubCapabilities{discovery: discoveryClient, phaseKey: clusterName, statusSubresource: true}
+}
+
+// NeedLeaderElection is false so that standby instances are ready to write to the hub
+func (c *HubCapabilities) NeedLeaderElection() bool {
+	return false
+}
+
+// Start detects the hub capabilities periodically until the context is done
+func (c *HubCapabilities) Start(ctx context.Context) error {
+	if !tool.WaitForPhase(ctx, c.phaseKey, hubCapabilitiesInterval) {
+		return nil
+	}
+
+	c.Detect()
+
+	ticker := time.NewTicker(hubCapabilitiesInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			c.Detect()
+		}
+	}
+}
+
+// Detect discovers the policy API version, status subresource, and status fields that the hub supports.
+// The capabilities that can't be discovered keep their previous value.
+func (c *HubCapabilities) Detect() {
+	gv := policiesv1.SchemeGroupVersion.String()
+
+	resources, err := c.discovery.ServerResourcesForGroupVersion(gv)
+	if err != nil {
+		log.Error(err, "Failed to discover the policy API on the hub", "GroupVersion", gv)
+
+		return
+	}
+
+	statusSubresource := false
+	servesPolicies := false
+
+	for _, resource := range resources.APIResources {
+		switch resource.Name {
+		case "policies":
+			servesPolicies = true
+		case "policies/status":
+			statusSubresource = true
+		}
+	}
+
+	if !servesPolicies {
+		log.Info("The hub doesn't serve the policy API version, the status can't be synced", "GroupVersion", gv)
+
+		return
+	}
+
+	fields := c.detectStatusFields()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if statusSubresource != c.statusSubresource {
+		log.Info("Detected the policy status subresource support on the hub", "StatusSubresource", statusSubresource)
+	}
+
+	c.statusSubresource = statusSubresource
+
+	if fields != nil {
+		if !reflect.DeepEqual(fields, c.fields) {
+			// the removed fields of the new schema are logged again
+			c.removedLogged = nil
+		}
+
+		c.fields = fields
+	}
+}
+
+// detectStatusFields returns the paths of the status fields in the OpenAPI schema of the Policy kind on the
+// hub, or nil if the schema isn't available
+func (c *HubCapabilities) detectStatusFields() map[string]bool {
+	document, err := c.discovery.OpenAPISchema()
+	if err != nil {
+		log.Error(err, "Failed to get the OpenAPI schema of the hub, assuming all policy status fields are supported")
+
+		return nil
+	}
+
+	for _, definition := range document.GetDefinitions().GetAdditionalProperties() {
+		if !strings.HasSuffix(definition.GetName(), policyDefinitionSuffix) {
+			continue
+		}
+
+		for _, property := range definition.GetValue().GetProperties().GetAdditionalProperties() {
+			if property.GetName() != "status" {
+				continue
+			}
+
+			fields := map[string]bool{}
+			addSchemaFields(fields, "status", property.GetValue())
+
+			return fields
+		}
+
+		// the Policy has no status schema, such as when its schema is preserved unknown fields
+		return nil
+	}
+
+	return nil
+}
+
+// addSchemaFields adds the paths of the properties of the schema and of its array items under the path
+func addSchemaFields(fields map[string]bool, path string, schema *openapi_v2.Schema) {
+	for _, property := range schema.GetProperties().GetAdditionalProperties() {
+		fieldPath := path + "." + property.GetName()
+		fields[fieldPath] = true
+
+		addSchemaFields(fields, fieldPath, property.GetValue())
+	}
+
+	for _, item := range schema.GetItems().GetSchema() {
+		addSchemaFields(fields, path, item)
+	}
+}
+
+// hasStatusSubresource returns false if the hub serves policies without a status subresource. It's true if
+// c is nil.
+func (c *HubCapabilities) hasStatusSubresource() bool {
+	if c == nil {
+		return true
+	}
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.statusSubresource
+}
+
+// adaptStatus removes the status fields that the hub doesn't support, at any depth, so that newer status
+// fields don't get the whole write rejected or pruned by an older hub Policy CRD during mixed-version
+// upgrades. The fields of an object without properties in the schema, such as the template metadata whose
+// unknown fields are preserved, are kept as is. The removed fields are logged the first time. It returns the
+// status as is if c is nil or the hub schema is unknown.
+func (c *HubCapabilities) adaptStatus(status policiesv1.PolicyStatus) policiesv1.PolicyStatus {
+	if c == nil {
+		return status
+	}
+
+	c.lock.RLock()
+	fields := c.fields
+	c.lock.RUnlock()
+
+	if fields == nil {
+		return status
+	}
+
+	unstructuredStatus, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+	if err != nil {
+		log.Error(err, "Failed to convert the policy status, not removing the unsupported status fields")
+
+		return status
+	}
+
+	removed := map[string]bool{}
+	removeUnsupportedFields(fields, schemaParents(fields), "status", unstructuredStatus, removed)
+
+	if len(removed) == 0 {
+		return status
+	}
+
+	adapted := policiesv1.PolicyStatus{}
+
+	err = runtime.DefaultUnstructuredConverter.FromUnstructured(unstructuredStatus, &adapted)
+	if err != nil {
+		log.Error(err, "Failed to convert the policy status, not removing the unsupported status fields")
+
+		return status
+	}
+
+	c.logRemoved(removed)
+
+	return adapted
+}
+
+// schemaParents returns the paths of the objects whose properties are in the schema of the supported fields
+func schemaParents(fields map[string]bool) map[string]bool {
+	parents := map[string]bool{}
+
+	for field := range fields {
+		parents[field[:strings.LastIndex(field, ".")]] = true
+	}
+
+	return parents
+}
+
+// UnsupportedStatusFields returns the sorted paths of the status fields of this agent version that the hub
+// Policy CRD doesn't support and that are removed from the hub status, such as for a compatibility check
+// before an upgrade. It returns false if the hub schema is unknown, such as before Detect is called.
+func (c *HubCapabilities) UnsupportedStatusFields() ([]string, bool) {
+	c.lock.RLock()
+	fields := c.fields
+	c.lock.RUnlock()
+
+	if fields == nil {
+		return nil, false
+	}
+
+	unsupported := map[string]bool{}
+	unsupportedTypeFields(fields, schemaParents(fields), "status", reflect.TypeOf(policiesv1.PolicyStatus{}),
+		unsupported)
+
+	paths := make([]string, 0, len(unsupported))
+	for path := range unsupported {
+		paths = append(paths, path)
+	}
+
+	sort.Strings(paths)
+
+	return paths, true
+}
+
+// unsupportedTypeFields adds the paths of the JSON fields of the type at the path that adaptStatus would
+// remove to the unsupported fields, which is the same as removeUnsupportedFields for a status with every
+// field set
+func unsupportedTypeFields(
+	fields map[string]bool, parents map[string]bool, path string, fieldType reflect.Type,
+	unsupported map[string]bool,
+) {
+	for fieldType.Kind() == reflect.Ptr || fieldType.Kind() == reflect.Slice {
+		fieldType = fieldType.Elem()
+	}
+
+	if fieldType.Kind() != reflect.Struct {
+		return
+	}
+
+	for i := 0; i < fieldType.NumField(); i++ {
+		field := fieldType.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+
+		// the fields of embedded structs without a name are inlined
+		if field.Anonymous && name == "" {
+			unsupportedTypeFields(fields, parents, path, field.Type, unsupported)
+
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		fieldPath := path + "." + name
+
+		if parents[path] && !fields[fieldPath] {
+			unsupported[fieldPath] = true
+
+			continue
+		}
+
+		unsupportedTypeFields(fields, parents, fieldPath, field.Type, unsupported)
+	}
+}
+
+// removeUnsupportedFields removes the fields of the object at the path that aren't in the supported fields
+// if the properties of the object are in the schema, and adds their paths to the removed fields. The objects
+// of the fields and of the arrays are handled the same way.
+func removeUnsupportedFields(
+	fields map[string]bool, parents map[string]bool, path string, object map[string]interface{},
+	removed map[string]bool,
+) {
+	for key, value := range object {
+		fieldPath := path + "." + key
+
+		if parents[path] && !fields[fieldPath] {
+			delete(object, key)
+			removed[fieldPath] = true
+
+			continue
+		}
+
+		switch typedValue := value.(type) {
+		case map[string]interface{}:
+			removeUnsupportedFields(fields, parents, fieldPath, typedValue, removed)
+		case []interface{}:
+			for _, item := range typedValue {
+				if itemObject, ok := item.(map[string]interface{}); ok {
+					removeUnsupportedFields(fields, parents, fieldPath, itemObject, removed)
+				}
+			}
+		}
+	}
+}
+
+// logRemoved logs the removed status fields that weren't logged yet
+func (c *HubCapabilities) logRemoved(removed map[string]bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.removedLogged == nil {
+		c.removedLogged = map[string]bool{}
+	}
+
+	newFields := []string{}
+
+	for field := range removed {
+		if !c.removedLogged[field] {
+			c.removedLogged[field] = true
+			newFields = append(newFields, field)
+		}
+	}
+
+	if len(newFields) == 0 {
+		return
+	}
+
+	sort.Strings(newFields)
+	log.Info("The hub Policy CRD doesn't support the status fields, they are removed from the hub status",
+		"fields", newFields)
+}
+
+// redetectAfterRejection detects the capabilities again after the hub rejected a status as invalid, such as
+// when the hub Policy CRD was rolled back to an older release since the last detection. It returns true if
+// the supported status fields changed, in which case the write may be retried with the adapted status. The
+// capabilities are detected again at most once per minute. It returns false if c is nil.
+func (c *HubCapabilities) redetectAfterRejection() bool {
+	if c == nil {
+		return false
+	}
+
+	c.lock.Lock()
+
+	if time.Since(c.rejectionDetectedAt) < time.Minute {
+		c.lock.Unlock()
+
+		return false
+	}
+
+	c.rejectionDetectedAt = time.Now()
+	previous := c.fields
+	c.lock.Unlock()
+
+	c.Detect()
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return !reflect.DeepEqual(previous, c.fields)
+}
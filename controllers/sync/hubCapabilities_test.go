@@ -0,0 +1,344 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	openapi_v2 "github.com/googleapis/gnostic/openapiv2"
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+// policyStatusFields are the paths of the status fields of the Policy CRD of this agent version
+var policyStatusFields = []string{
+	"status.placement", "status.placement.placementBinding", "status.placement.placementRule",
+	"status.placement.placement", "status.placement.decisions", "status.placement.decisions.clusterName",
+	"status.placement.decisions.clusterNamespace", "status.placement.policySet",
+	"status.status", "status.status.compliant", "status.status.clustername", "status.status.clusternamespace",
+	"status.compliant",
+	"status.details", "status.details.templateMeta", "status.details.compliant", "status.details.history",
+	"status.details.history.lastTimestamp", "status.details.history.message", "status.details.history.eventName",
+}
+
+// supportedFields returns the status fields of this agent version without the fields and their children
+func supportedFields(without ...string) map[string]bool {
+	fields := map[string]bool{}
+
+	for _, field := range policyStatusFields {
+		fields[field] = true
+
+		for _, removed := range without {
+			if field == removed || strings.HasPrefix(field, removed+".") {
+				delete(fields, field)
+			}
+		}
+	}
+
+	return fields
+}
+
+func TestAdaptStatus(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		fields map[string]bool
+		// expected are the sorted removed fields, which are all of the unsupported fields of the status
+		expected string
+	}{
+		"all supported":  {supportedFields(), ""},
+		"unknown schema": {nil, ""},
+		"no event names": {
+			supportedFields("status.details.history.eventName"), "status.details.history.eventName",
+		},
+		"no details":            {supportedFields("status.details"), "status.details"},
+		"no policy sets":        {supportedFields("status.placement.policySet"), "status.placement.policySet"},
+		"no template compliant": {supportedFields("status.details.compliant"), "status.details.compliant"},
+		// the unset fields aren't in the status, so they aren't removed
+		"no decisions": {supportedFields("status.placement.decisions", "status.status"), ""},
+		"several depths": {
+			supportedFields("status.placement.policySet", "status.details.history.message"),
+			"status.details.history.message,status.placement.policySet",
+		},
+	}
+
+	for name, test := range tests {
+		status := testStatus(policiesv1.NonCompliant, testHistory(2, 1), "template1", "template2")
+		status.Details[0].TemplateMeta.SetAnnotations(map[string]string{"policy.open-cluster-management.io/a": "b"})
+		status.Placement = []*policiesv1.Placement{{PlacementBinding: "binding", PolicySet: "policyset"}}
+
+		capabilities := &HubCapabilities{fields: test.fields}
+		adapted := capabilities.adaptStatus(status)
+
+		removed := []string{}
+
+		if test.expected != "" {
+			removed = strings.Split(test.expected, ",")
+		}
+
+		for _, field := range removed {
+			if !capabilities.removedLogged[field] {
+				t.Fatalf("%s: expected the removed field %s to be logged, got %v", name, field,
+					capabilities.removedLogged)
+			}
+		}
+
+		if len(capabilities.removedLogged) != len(removed) {
+			t.Fatalf("%s: expected the removed fields %s, got %v", name, test.expected, capabilities.removedLogged)
+		}
+
+		// the fields of the template metadata are preserved, since the schema has no properties for them
+		annotations := map[string]string{}
+		if len(adapted.Details) != 0 {
+			annotations = adapted.Details[0].TemplateMeta.GetAnnotations()
+		}
+
+		if test.expected != "status.details" && annotations["policy.open-cluster-management.io/a"] != "b" {
+			t.Fatalf("%s: expected the template metadata to be kept, got %v", name, annotations)
+		}
+
+		// the supported fields are kept
+		if adapted.ComplianceState != policiesv1.NonCompliant || adapted.Placement[0].PlacementBinding != "binding" {
+			t.Fatalf("%s: expected the supported fields to be kept, got %v", name, adapted)
+		}
+
+		// the status of the managed policy isn't modified
+		if len(status.Details) != 2 || status.Details[0].History[0].EventName == "" ||
+			status.Placement[0].PolicySet == "" {
+			t.Fatalf("%s: expected the original status to be kept, got %v", name, status)
+		}
+	}
+
+	var disabled *HubCapabilities
+	if status := testStatus(policiesv1.Compliant, nil, "template1"); len(disabled.adaptStatus(status).Details) != 1 {
+		t.Fatal("expected the status to be kept without the hub capabilities")
+	}
+}
+
+func TestUnsupportedStatusFields(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		fields   map[string]bool
+		expected string
+		known    bool
+	}{
+		"all supported":  {supportedFields(), "", true},
+		"unknown schema": {nil, "", false},
+		"no event names": {
+			supportedFields("status.details.history.eventName"), "status.details.history.eventName", true,
+		},
+		"no details": {supportedFields("status.details"), "status.details", true},
+		"several depths": {
+			supportedFields("status.status", "status.placement.decisions.clusterNamespace"),
+			"status.placement.decisions.clusterNamespace,status.status",
+			true,
+		},
+	}
+
+	for name, test := range tests {
+		capabilities := &HubCapabilities{fields: test.fields}
+
+		unsupported, known := capabilities.UnsupportedStatusFields()
+		if known != test.known {
+			t.Fatalf("%s: expected the schema to be known: %v, got %v", name, test.known, known)
+		}
+
+		if joined := strings.Join(unsupported, ","); joined != test.expected {
+			t.Fatalf("%s: expected the unsupported fields %q, got %q", name, test.expected, joined)
+		}
+	}
+}
+
+// fakeDiscovery is a fake hub discovery client that serves the OpenAPI document
+type fakeDiscovery struct {
+	*fakediscovery.FakeDiscovery
+	document *openapi_v2.Document
+	err      error
+}
+
+func (d *fakeDiscovery) OpenAPISchema() (*openapi_v2.Document, error) {
+	return d.document, d.err
+}
+
+// newFakeDiscovery returns a fake discovery client that serves the resources in the policy API version and
+// the OpenAPI document. The policy API version isn't served if there are no resources.
+func newFakeDiscovery(resources []string, document *openapi_v2.Document) *fakeDiscovery {
+	discovery := &fakeDiscovery{
+		FakeDiscovery: &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{}},
+		document:      document,
+	}
+
+	if resources == nil {
+		return discovery
+	}
+
+	resourceList := &metav1.APIResourceList{GroupVersion: policiesv1.SchemeGroupVersion.String()}
+	for _, resource := range resources {
+		resourceList.APIResources = append(resourceList.APIResources, metav1.APIResource{Name: resource})
+	}
+
+	discovery.Resources = []*metav1.APIResourceList{resourceList}
+
+	return discovery
+}
+
+// schemaProperty returns a named schema of an object with the properties
+func schemaProperty(name string, properties ...*openapi_v2.NamedSchema) *openapi_v2.NamedSchema {
+	return &openapi_v2.NamedSchema{
+		Name:  name,
+		Value: &openapi_v2.Schema{Properties: &openapi_v2.Properties{AdditionalProperties: properties}},
+	}
+}
+
+// schemaArrayProperty returns a named schema of an array of objects with the properties
+func schemaArrayProperty(name string, properties ...*openapi_v2.NamedSchema) *openapi_v2.NamedSchema {
+	return &openapi_v2.NamedSchema{
+		Name: name,
+		Value: &openapi_v2.Schema{Items: &openapi_v2.ItemsItem{Schema: []*openapi_v2.Schema{
+			schemaProperty("", properties...).Value,
+		}}},
+	}
+}
+
+// policyDocument returns an OpenAPI document with the Policy definition, which has the status schema
+// unless it's nil
+func policyDocument(status *openapi_v2.NamedSchema) *openapi_v2.Document {
+	policy := schemaProperty("io.open-cluster-management.policy.v1.Policy")
+	if status != nil {
+		policy = schemaProperty(policy.Name, schemaProperty("spec"), status)
+	}
+
+	return &openapi_v2.Document{Definitions: &openapi_v2.Definitions{
+		AdditionalProperties: []*openapi_v2.NamedSchema{schemaProperty("io.k8s.api.core.v1.Pod"), policy},
+	}}
+}
+
+// oldHubStatusSchema is the status schema of an older hub without the placement, the cluster statuses and
+// the event names
+var oldHubStatusSchema = schemaProperty("status",
+	schemaProperty("compliant"),
+	schemaArrayProperty("details",
+		schemaProperty("compliant"),
+		schemaProperty("templateMeta"),
+		schemaArrayProperty("history", schemaProperty("lastTimestamp"), schemaProperty("message")),
+	),
+)
+
+func TestHubCapabilitiesDetect(t *testing.T) {
+	t.Parallel()
+
+	// the previous capabilities are kept if they can't be discovered
+	previousFields := supportedFields("status.placement")
+	oldHubFields := supportedFields("status.placement", "status.status", "status.details.history.eventName")
+
+	tests := map[string]struct {
+		discovery         *fakeDiscovery
+		statusSubresource bool
+		fields            map[string]bool
+	}{
+		"status subresource": {
+			newFakeDiscovery([]string{"policies", "policies/status"}, policyDocument(oldHubStatusSchema)),
+			true,
+			oldHubFields,
+		},
+		"no status subresource": {
+			newFakeDiscovery([]string{"policies"}, policyDocument(oldHubStatusSchema)), false, oldHubFields,
+		},
+		"policies not served": {
+			newFakeDiscovery([]string{"placementbindings"}, policyDocument(oldHubStatusSchema)), false,
+			previousFields,
+		},
+		"discovery error": {newFakeDiscovery(nil, policyDocument(oldHubStatusSchema)), false, previousFields},
+		"no schema": {
+			newFakeDiscovery([]string{"policies", "policies/status"}, &openapi_v2.Document{}), true, previousFields,
+		},
+		"no status schema": {
+			newFakeDiscovery([]string{"policies", "policies/status"}, policyDocument(nil)), true, previousFields,
+		},
+		"schema error": {
+			&fakeDiscovery{
+				FakeDiscovery: newFakeDiscovery([]string{"policies", "policies/status"}, nil).FakeDiscovery,
+				err:           errors.New("the OpenAPI schema isn't available"),
+			},
+			true,
+			previousFields,
+		},
+	}
+
+	for name, test := range tests {
+		capabilities := NewHubCapabilities(test.discovery, "cluster1")
+		capabilities.statusSubresource = false
+		capabilities.fields = previousFields
+		capabilities.removedLogged = map[string]bool{"status.placement": true}
+
+		capabilities.Detect()
+
+		if capabilities.hasStatusSubresource() != test.statusSubresource {
+			t.Fatalf("%s: expected the status subresource: %v, got %v", name, test.statusSubresource,
+				capabilities.hasStatusSubresource())
+		}
+
+		if !reflect.DeepEqual(capabilities.fields, test.fields) {
+			t.Fatalf("%s: expected the status fields %v, got %v", name, test.fields, capabilities.fields)
+		}
+
+		// the removed fields of a new schema are logged again
+		if changed := !reflect.DeepEqual(test.fields, previousFields); changed != (capabilities.removedLogged == nil) {
+			t.Fatalf("%s: expected the logged removed fields to be reset: %v, got %v", name, changed,
+				capabilities.removedLogged)
+		}
+	}
+
+	var disabled *HubCapabilities
+	if !disabled.hasStatusSubresource() {
+		t.Fatal("expected the status subresource without the hub capabilities")
+	}
+}
+
+func TestRedetectAfterRejection(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		// detectedAt is how long ago the capabilities were last detected after a rejection
+		detectedAt time.Duration
+		fields     map[string]bool
+		expected   bool
+	}{
+		"changed schema":         {time.Hour, supportedFields(), true},
+		"unchanged schema":       {time.Hour, map[string]bool{"status.compliant": true, "status.details": true}, false},
+		"recently redetected":    {30 * time.Second, supportedFields(), false},
+		"redetected a while ago": {2 * time.Minute, supportedFields(), true},
+	}
+
+	for name, test := range tests {
+		capabilities := NewHubCapabilities(
+			newFakeDiscovery([]string{"policies", "policies/status"}, policyDocument(schemaProperty("status",
+				schemaProperty("compliant"), schemaProperty("details"),
+			))),
+			"cluster1",
+		)
+		capabilities.fields = test.fields
+		capabilities.rejectionDetectedAt = time.Now().Add(-test.detectedAt)
+
+		if changed := capabilities.redetectAfterRejection(); changed != test.expected {
+			t.Fatalf("%s: expected the status fields to change: %v, got %v", name, test.expected, changed)
+		}
+
+		// the capabilities aren't detected again right after
+		if capabilities.redetectAfterRejection() {
+			t.Fatalf("%s: expected the capabilities to not be detected again within a minute", name)
+		}
+	}
+
+	var disabled *HubCapabilities
+	if disabled.redetectAfterRejection() {
+		t.Fatal("expected no change without the hub capabilities")
+	}
+}
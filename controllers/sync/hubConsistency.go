@@ -0,0 +1,158 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var (
+	hubConsistencyGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "policy_status_sync_hub_consistency",
+			Help: "The ratio of the synced policies of each namespace whose hub status matches the status last " +
+				"synced from the managed cluster, from 0 to 1, at the last consistency check",
+		},
+		[]string{"namespace"},
+	)
+	hubInconsistenciesTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "policy_status_sync_hub_inconsistencies_total",
+			Help: "The number of times the hub status of a policy was found to not match the status last synced " +
+				"from the managed cluster by the consistency check, such as after hub-side data loss",
+		},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(hubConsistencyGauge, hubInconsistenciesTotal)
+}
+
+// hubConsistencyChecker periodically reads the hub status of the synced policies back and compares it to the
+// status on the managed cluster, which is the status that was last synced, so that silent hub-side data loss,
+// such as after an etcd restore, is detected without waiting for a policy to change. The inconsistent policies
+// are queued to be synced again.
+type hubConsistencyChecker struct {
+	reconciler *PolicyReconciler
+}
+
+// NeedLeaderElection is true since only the leader syncs the policies
+func (c *hubConsistencyChecker) NeedLeaderElection() bool {
+	return true
+}
+
+// Start checks the hub consistency every HubConsistencyInterval until the context is done
+func (c *hubConsistencyChecker) Start(ctx context.Context) error {
+	ticker := time.NewTicker(c.reconciler.HubConsistencyInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			c.check(ctx)
+		}
+	}
+}
+
+// check compares the hub status of each synced policy that isn't waiting to be synced to its managed status,
+// and exports the ratio of the consistent policies of each namespace. Failures are only logged.
+func (c *hubConsistencyChecker) check(ctx context.Context) {
+	r := c.reconciler
+
+	policies := &policiesv1.PolicyList{}
+	if err := r.ManagedClient.List(ctx, policies); err != nil {
+		log.Error(err, "Failed to list the policies for the hub consistency check")
+
+		return
+	}
+
+	checked := map[string]int{}
+	consistent := map[string]int{}
+
+	for i := range policies.Items {
+		instance := &policiesv1.Policy{}
+		policies.Items[i].DeepCopyInto(instance)
+
+		key := types.NamespacedName{Namespace: instance.GetNamespace(), Name: instance.GetName()}
+
+		state, err := r.syncState(ctx, instance)
+		if err != nil {
+			log.V(1).Info("Failed to get the hub sync state for the hub consistency check",
+				"Namespace", key.Namespace, "Name", key.Name, "error", err.Error())
+
+			continue
+		}
+
+		// the policies that were never synced or are waiting to be synced are expected to differ
+		if state.lastHubSync.IsZero() || r.hubWrites.has(reconcile.Request{NamespacedName: key}) {
+			continue
+		}
+
+		hubPlc := &policiesv1.Policy{}
+		if err := r.HubClient.Get(ctx, r.hubPolicyKey(instance), hubPlc); err != nil {
+			if !errors.IsNotFound(err) {
+				log.V(1).Info("Failed to get the hub policy for the hub consistency check",
+					"Namespace", key.Namespace, "Name", key.Name, "error", err.Error())
+			}
+
+			continue
+		}
+
+		checked[key.Namespace]++
+
+		if hubStatusConsistent(instance.Status, hubPlc.Status) {
+			consistent[key.Namespace]++
+
+			continue
+		}
+
+		hubInconsistenciesTotal.Inc()
+
+		log.Info("The hub status of the policy doesn't match the status last synced, syncing it again",
+			"Namespace", key.Namespace, "Name", key.Name)
+
+		r.Resync(key)
+	}
+
+	hubConsistencyGauge.Reset()
+
+	for namespace, count := range checked {
+		hubConsistencyGauge.WithLabelValues(namespace).Set(float64(consistent[namespace]) / float64(count))
+	}
+}
+
+// hubStatusConsistent returns true if the hub status has the overall compliance of the managed status and,
+// for each template, the same compliance state and latest history entry. The older history entries aren't
+// compared, since the hub status may have fewer of them, such as when it's trimmed to fit in the size limit.
+func hubStatusConsistent(managed policiesv1.PolicyStatus, hub policiesv1.PolicyStatus) bool {
+	if managed.ComplianceState != hub.ComplianceState || len(managed.Details) != len(hub.Details) {
+		return false
+	}
+
+	matcher := newTemplateMatcher(hub.Details)
+
+	for _, dpt := range managed.Details {
+		hubDpt := matcher.match(dpt.TemplateMeta.Name)
+		if hubDpt == nil || hubDpt.ComplianceState != dpt.ComplianceState ||
+			len(hubDpt.History) == 0 != (len(dpt.History) == 0) {
+			return false
+		}
+
+		if len(dpt.History) != 0 && (hubDpt.History[0].EventName != dpt.History[0].EventName ||
+			!hubDpt.History[0].LastTimestamp.Equal(&dpt.History[0].LastTimestamp)) {
+			return false
+		}
+	}
+
+	return true
+}
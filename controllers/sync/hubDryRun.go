@@ -0,0 +1,132 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// updateHubStatus writes the status of the hub policy, with the rest of the policy if the hub has no status
+// subresource, with server-side apply if HubServerSideApply is set, or with a JSON patch of the changes from
+// the previous hub status if HubStatusPatch is set. The write is validated but not persisted if dryRun is true.
+func (r *PolicyReconciler) updateHubStatus(
+	ctx context.Context, hubPlc *policiesv1.Policy, previous policiesv1.PolicyStatus, dryRun bool,
+) error {
+	if r.HubServerSideApply {
+		return r.applyHubStatus(ctx, hubPlc, dryRun)
+	}
+
+	if r.HubStatusPatch {
+		return r.patchHubStatus(ctx, hubPlc, previous, dryRun)
+	}
+
+	opts := []client.UpdateOption{}
+	if r.HubFieldManager != "" {
+		opts = append(opts, client.FieldOwner(r.HubFieldManager))
+	}
+
+	if dryRun {
+		opts = append(opts, client.DryRunAll)
+	}
+
+	if r.HubCapabilities.hasStatusSubresource() {
+		return r.HubClient.Status().Update(ctx, hubPlc, opts...)
+	}
+
+	// without a status subresource, the status is updated with the rest of the policy
+	return r.HubClient.Update(ctx, hubPlc, opts...)
+}
+
+// dryRunHubStatus validates the hub status write with a server-side dry run if HubDryRun is set, so that
+// an incompatible hub Policy CRD schema, such as during an upgrade, is logged clearly. A rejected status
+// is logged with the invalid fields and returns the error. The status fields that the hub would prune are
+// logged, but the status is still written.
+func (r *PolicyReconciler) dryRunHubStatus(
+	ctx context.Context, hubPlc *policiesv1.Policy, previous policiesv1.PolicyStatus,
+) error {
+	if !r.HubDryRun {
+		return nil
+	}
+
+	dryRunPlc := hubPlc.DeepCopy()
+
+	err := r.updateHubStatus(ctx, dryRunPlc, previous, true)
+	if err != nil {
+		var statusErr *k8serrors.StatusError
+		if k8serrors.IsInvalid(err) && errors.As(err, &statusErr) && statusErr.ErrStatus.Details != nil {
+			causes := []string{}
+			for _, cause := range statusErr.ErrStatus.Details.Causes {
+				causes = append(causes, cause.Field+": "+cause.Message)
+			}
+
+			log.Error(err, "The hub rejected the policy status in a dry run, the hub Policy CRD schema is "+
+				"incompatible", "Namespace", hubPlc.GetNamespace(), "Name", hubPlc.GetName(), "Causes", causes)
+		}
+
+		return err
+	}
+
+	if pruned := prunedFields(hubPlc.Status, dryRunPlc.Status); len(pruned) > 0 {
+		log.Info("The hub would prune policy status fields that aren't in the hub Policy CRD schema",
+			"Namespace", hubPlc.GetNamespace(), "Name", hubPlc.GetName(), "Fields", pruned)
+	}
+
+	return nil
+}
+
+// prunedFields returns the sorted paths of the fields in the sent status that are missing from the persisted
+// status, such as "status.details.history.eventName". Array indexes are omitted from the paths.
+func prunedFields(sent policiesv1.PolicyStatus, persisted policiesv1.PolicyStatus) []string {
+	sentFields, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&sent)
+	if err != nil {
+		return nil
+	}
+
+	persistedFields, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&persisted)
+	if err != nil {
+		return nil
+	}
+
+	sentPaths := map[string]bool{}
+	addFieldPaths(sentPaths, "status", sentFields)
+
+	persistedPaths := map[string]bool{}
+	addFieldPaths(persistedPaths, "status", persistedFields)
+
+	pruned := []string{}
+
+	for path := range sentPaths {
+		if !persistedPaths[path] {
+			pruned = append(pruned, path)
+		}
+	}
+
+	sort.Strings(pruned)
+
+	return pruned
+}
+
+// addFieldPaths adds the paths of the fields of the unstructured value under the path
+func addFieldPaths(paths map[string]bool, path string, value interface{}) {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		for key, field := range typed {
+			fieldPath := strings.Join([]string{path, key}, ".")
+			paths[fieldPath] = true
+
+			addFieldPaths(paths, fieldPath, field)
+		}
+	case []interface{}:
+		for _, item := range typed {
+			addFieldPaths(paths, path, item)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// jsonPatchOperation is an operation of a JSON patch
+type jsonPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// patchHubStatus writes the changes from the previous hub status to the status of the hub policy with a JSON
+// patch, so that a new history entry is sent without the rest of the history of the policy templates. The
+// resource version is replaced with its current value, which makes the patch a conflict if the hub policy
+// changed since it was read, as with an update, so that the array indexes of the patch are those of the
+// previous status. The hub policy is updated with the response.
+func (r *PolicyReconciler) patchHubStatus(
+	ctx context.Context, hubPlc *policiesv1.Policy, previous policiesv1.PolicyStatus, dryRun bool,
+) error {
+	operations := statusPatch(hubPlc.GetResourceVersion(), previous, hubPlc.Status)
+
+	data, err := json.Marshal(operations)
+	if err != nil {
+		return err
+	}
+
+	opts := []client.PatchOption{}
+	if r.HubFieldManager != "" {
+		opts = append(opts, client.FieldOwner(r.HubFieldManager))
+	}
+
+	if dryRun {
+		opts = append(opts, client.DryRunAll)
+	}
+
+	patch := client.RawPatch(types.JSONPatchType, data)
+
+	if r.HubCapabilities.hasStatusSubresource() {
+		return r.HubClient.Status().Patch(ctx, hubPlc, patch, opts...)
+	}
+
+	// without a status subresource, the status is patched in the policy itself
+	return r.HubClient.Patch(ctx, hubPlc, patch, opts...)
+}
+
+// statusPatch returns the JSON patch operations from the previous status to the current one, which replace
+// the compliant status fields that changed, and add the new history entries and remove the trimmed ones of each
+// template. The details are replaced if the templates changed, and the history of a template is replaced if
+// its entries changed otherwise or if that's smaller. The first operation sets the resource version.
+func statusPatch(
+	resourceVersion string, previous policiesv1.PolicyStatus, current policiesv1.PolicyStatus,
+) []jsonPatchOperation {
+	operations := []jsonPatchOperation{{Op: "replace", Path: "/metadata/resourceVersion", Value: resourceVersion}}
+
+	// the status of a new hub policy may not exist yet, so the parent of its fields can't be assumed
+	if equality.Semantic.DeepEqual(previous, policiesv1.PolicyStatus{}) {
+		return append(operations, jsonPatchOperation{Op: "add", Path: "/status", Value: current})
+	}
+
+	operations = append(operations, fieldPatch("/status/compliant", previous.ComplianceState,
+		current.ComplianceState, current.ComplianceState == "")...)
+
+	if len(current.Details) == 0 {
+		if len(previous.Details) != 0 {
+			operations = append(operations, jsonPatchOperation{Op: "remove", Path: "/status/details"})
+		}
+
+		return operations
+	}
+
+	if !sameTemplates(previous.Details, current.Details) {
+		return append(operations, jsonPatchOperation{Op: "add", Path: "/status/details", Value: current.Details})
+	}
+
+	for i, dpt := range current.Details {
+		previousDpt := previous.Details[i]
+		path := fmt.Sprintf("/status/details/%d", i)
+
+		operations = append(operations, fieldPatch(path+"/templateMeta", previousDpt.TemplateMeta,
+			dpt.TemplateMeta, false)...)
+		operations = append(operations, fieldPatch(path+"/compliant", previousDpt.ComplianceState,
+			dpt.ComplianceState, dpt.ComplianceState == "")...)
+		operations = append(operations, historyPatch(path+"/history", previousDpt.History, dpt.History)...)
+	}
+
+	return operations
+}
+
+// fieldPatch returns the operation that sets the field at the path to the current value if it changed, or
+// that removes it if it's empty
+func fieldPatch(path string, previous interface{}, current interface{}, empty bool) []jsonPatchOperation {
+	if equality.Semantic.DeepEqual(previous, current) {
+		return nil
+	}
+
+	if empty {
+		return []jsonPatchOperation{{Op: "remove", Path: path}}
+	}
+
+	// adding an object member replaces it if it exists
+	return []jsonPatchOperation{{Op: "add", Path: path, Value: current}}
+}
+
+// sameTemplates returns true if the details are the same templates in the same order
+func sameTemplates(previous []*policiesv1.DetailsPerTemplate, current []*policiesv1.DetailsPerTemplate) bool {
+	if len(previous) != len(current) {
+		return false
+	}
+
+	for i := range current {
+		if previous[i] == nil || current[i] == nil || previous[i].TemplateMeta.Name != current[i].TemplateMeta.Name {
+			return false
+		}
+	}
+
+	return true
+}
+
+// historyPatch returns the operations from the previous history at the path to the current one. When the
+// current history is new entries followed by the most recent previous entries, the oldest previous entries that
+// were trimmed are removed and the new entries are added at the start. Otherwise, or if the replacement is
+// smaller, the history is replaced.
+func historyPatch(
+	path string, previous []policiesv1.ComplianceHistory, current []policiesv1.ComplianceHistory,
+) []jsonPatchOperation {
+	if equality.Semantic.DeepEqual(previous, current) {
+		return nil
+	}
+
+	if len(current) == 0 {
+		return []jsonPatchOperation{{Op: "remove", Path: path}}
+	}
+
+	replacement := []jsonPatchOperation{{Op: "add", Path: path, Value: current}}
+
+	if len(previous) == 0 {
+		return replacement
+	}
+
+	for added := 0; added < len(current); added++ {
+		kept := len(current) - added
+		if kept > len(previous) || !equality.Semantic.DeepEqual(current[added:], previous[:kept]) {
+			continue
+		}
+
+		operations := []jsonPatchOperation{}
+
+		// the trimmed entries are removed from the end so that the indexes of the other ones don't move
+		for i := len(previous) - 1; i >= kept; i-- {
+			operations = append(operations, jsonPatchOperation{Op: "remove", Path: fmt.Sprintf("%s/%d", path, i)})
+		}
+
+		// the new entries are added at the start from the oldest to the newest
+		for i := added - 1; i >= 0; i-- {
+			operations = append(operations, jsonPatchOperation{Op: "add", Path: path + "/0", Value: current[i]})
+		}
+
+		if patchSize(operations) < patchSize(replacement) {
+			return operations
+		}
+
+		return replacement
+	}
+
+	return replacement
+}
+
+// patchSize returns the size of the JSON of the operations, or -1 if they can't be marshaled
+func patchSize(operations []jsonPatchOperation) int {
+	data, err := json.Marshal(operations)
+	if err != nil {
+		return -1
+	}
+
+	return len(data)
+}
@@ -0,0 +1,204 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// applyStatusPatch applies the JSON patch from the previous status to the current one on a hub policy with the
+// previous status and the resource version, and returns the patched policy
+func applyStatusPatch(
+	t *testing.T, resourceVersion string, previous policiesv1.PolicyStatus, current policiesv1.PolicyStatus,
+) (*policiesv1.Policy, []jsonPatchOperation) {
+	t.Helper()
+
+	document, err := json.Marshal(&policiesv1.Policy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "cluster1", Name: "policies.policy", ResourceVersion: resourceVersion},
+		Status:     previous,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	operations := statusPatch("1", previous, current)
+
+	data, err := json.Marshal(operations)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patch, err := jsonpatch.DecodePatch(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patched, err := patch.Apply(document)
+	if err != nil {
+		t.Fatalf("failed to apply the patch %s: %v", data, err)
+	}
+
+	patchedPlc := &policiesv1.Policy{}
+	if err := json.Unmarshal(patched, patchedPlc); err != nil {
+		t.Fatal(err)
+	}
+
+	return patchedPlc, operations
+}
+
+func TestStatusPatch(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		previous policiesv1.PolicyStatus
+		current  policiesv1.PolicyStatus
+		// operations is the expected number of operations after the resource version
+		operations int
+	}{
+		"new hub status": {
+			current:    testStatus(policiesv1.NonCompliant, testHistory(1), "template"),
+			operations: 1,
+		},
+		"same status": {
+			previous:   testStatus(policiesv1.NonCompliant, testHistory(2, 1), "template"),
+			current:    testStatus(policiesv1.NonCompliant, testHistory(2, 1), "template"),
+			operations: 0,
+		},
+		"new entry": {
+			previous: testStatus(policiesv1.NonCompliant, testHistory(2, 1), "template"),
+			current:  testStatus(policiesv1.NonCompliant, testHistory(3, 2, 1), "template"),
+			// only the new entry is added
+			operations: 1,
+		},
+		"new entries and trimmed entries": {
+			previous: testStatus(policiesv1.NonCompliant, testHistory(8, 7, 6, 5, 4, 3, 2, 1), "template"),
+			current:  testStatus(policiesv1.NonCompliant, testHistory(10, 9, 8, 7, 6, 5, 4, 3), "template"),
+			// the two oldest entries are removed and the two new ones are added
+			operations: 4,
+		},
+		"short trimmed history": {
+			previous: testStatus(policiesv1.NonCompliant, testHistory(3, 2, 1), "template"),
+			current:  testStatus(policiesv1.NonCompliant, testHistory(5, 4, 3), "template"),
+			// replacing the history is smaller
+			operations: 1,
+		},
+		"compliance change": {
+			previous: testStatus(policiesv1.NonCompliant, testHistory(2, 1), "template1", "template2"),
+			current:  testStatus(policiesv1.Compliant, testHistory(3, 2, 1), "template1", "template2"),
+			// the policy compliance, and the compliance and new entry of each template
+			operations: 5,
+		},
+		"rewritten history": {
+			previous:   testStatus(policiesv1.NonCompliant, testHistory(3, 2, 1), "template"),
+			current:    testStatus(policiesv1.NonCompliant, testHistory(3, 1), "template"),
+			operations: 1,
+		},
+		"cleared history": {
+			previous:   testStatus(policiesv1.NonCompliant, testHistory(2, 1), "template"),
+			current:    testStatus(policiesv1.NonCompliant, nil, "template"),
+			operations: 1,
+		},
+		"new template": {
+			previous:   testStatus(policiesv1.NonCompliant, testHistory(1), "template1"),
+			current:    testStatus(policiesv1.NonCompliant, testHistory(1), "template1", "template2"),
+			operations: 1,
+		},
+		"cleared compliance": {
+			previous:   testStatus(policiesv1.NonCompliant, testHistory(1), "template"),
+			current:    testStatus("", testHistory(1), "template"),
+			operations: 2,
+		},
+		"removed templates": {
+			previous:   testStatus(policiesv1.NonCompliant, testHistory(1), "template"),
+			current:    testStatus(policiesv1.NonCompliant, nil),
+			operations: 1,
+		},
+	}
+
+	for name, test := range tests {
+		patched, operations := applyStatusPatch(t, "1", test.previous, test.current)
+
+		if !equality.Semantic.DeepEqual(patched.Status, test.current) {
+			t.Fatalf("%s: expected the patched status to be the current status, got %+v", name, patched.Status)
+		}
+
+		if len(operations)-1 != test.operations {
+			t.Fatalf("%s: expected %d operations after the resource version, got %+v", name, test.operations,
+				operations[1:])
+		}
+	}
+}
+
+func TestStatusPatchConflict(t *testing.T) {
+	t.Parallel()
+
+	previous := testStatus(policiesv1.NonCompliant, testHistory(2, 1), "template")
+	current := testStatus(policiesv1.NonCompliant, testHistory(3, 2, 1), "template")
+
+	// the hub policy changed since it was read at the resource version 1, so the patched policy has the read
+	// resource version, which the hub rejects as a conflict instead of applying the array indexes of the patch
+	// to another history
+	patched, operations := applyStatusPatch(t, "5", previous, current)
+
+	if operations[0].Op != "replace" || operations[0].Path != "/metadata/resourceVersion" {
+		t.Fatalf("expected the first operation to replace the resource version, got %+v", operations[0])
+	}
+
+	if patched.GetResourceVersion() != "1" {
+		t.Fatalf("expected the patch to set the read resource version 1, got %s", patched.GetResourceVersion())
+	}
+}
+
+func TestPatchHubStatus(t *testing.T) {
+	t.Parallel()
+
+	hubPlc := &policiesv1.Policy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "cluster1", Name: "policies.policy", ResourceVersion: "1"},
+		Status:     testStatus(policiesv1.NonCompliant, testHistory(3, 2, 1), "template"),
+	}
+	previous := testStatus(policiesv1.NonCompliant, testHistory(2, 1), "template")
+
+	hubClient := &patchRecordingClient{responseVersion: "2"}
+	reconciler := &PolicyReconciler{HubClient: hubClient, HubFieldManager: "custom-manager"}
+
+	if err := reconciler.patchHubStatus(context.TODO(), hubPlc, previous, false); err != nil {
+		t.Fatal(err)
+	}
+
+	patch := hubClient.patches[0]
+	if !patch.status || patch.patchType != types.JSONPatchType || patch.opts.FieldManager != "custom-manager" {
+		t.Fatalf("expected a JSON patch of the status subresource by the field manager, got a %s patch by %s",
+			patch.patchType, patch.opts.FieldManager)
+	}
+
+	operations := []jsonPatchOperation{}
+	if err := json.Unmarshal(patch.raw, &operations); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(operations) != 2 || operations[0].Value != "1" || operations[1].Path != "/status/details/0/history/0" {
+		t.Fatalf("expected the resource version and the new history entry to be sent, got %s", patch.raw)
+	}
+
+	if hubPlc.GetResourceVersion() != "2" {
+		t.Fatalf("expected the resource version of the response, got %s", hubPlc.GetResourceVersion())
+	}
+
+	// a conflict is returned as is so that the reconcile is retried with the current hub policy
+	policies := policiesv1.GroupVersion.WithResource("policies").GroupResource()
+	hubClient.err = k8serrors.NewConflict(policies, "policies.policy", errors.New("modified"))
+
+	if err := reconciler.patchHubStatus(context.TODO(), hubPlc, previous, false); !k8serrors.IsConflict(err) {
+		t.Fatalf("expected a conflict, got %v", err)
+	}
+}
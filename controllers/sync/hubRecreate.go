@@ -0,0 +1,55 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"context"
+	"time"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+)
+
+// syncHubUID records the UID of the hub policy in the sync state of the managed policy. If the hub policy was
+// re-created since the last reconcile, the status of the managed policy is reset so that the history of the
+// deleted hub policy isn't synced to the new one, unless KeepHistoryOnHubRecreate is set. In both cases, the
+// last hub sync is forgotten since the new hub policy never had the status.
+func (r *PolicyReconciler) syncHubUID(
+	ctx context.Context, instance *policiesv1.Policy, hubPlc *policiesv1.Policy, state *syncState,
+) error {
+	hubKey := r.hubPolicyKey(instance).String()
+	if state.hubUID == string(hubPlc.GetUID()) && state.hubPolicy == hubKey {
+		return nil
+	}
+
+	updated := *state
+	updated.hubPolicy = hubKey
+	updated.hubUID = string(hubPlc.GetUID())
+
+	// without a recorded UID, such as for a new managed policy, the hub policy is assumed to be the same
+	if state.hubUID != "" && state.hubUID != updated.hubUID {
+		log.Info("The hub policy was re-created, resetting the synced status", "Namespace",
+			instance.GetNamespace(), "Name", instance.GetName(), "KeepHistory", r.KeepHistoryOnHubRecreate)
+
+		hubRecreationsTotal.Inc()
+
+		updated.lastHubSync = time.Time{}
+
+		if !r.KeepHistoryOnHubRecreate {
+			instance.Status = policiesv1.PolicyStatus{}
+
+			if err := r.ManagedClient.Status().Update(ctx, instance); err != nil {
+				return err
+			}
+
+			updated.historyReset = time.Now()
+		}
+	}
+
+	if err := r.saveSyncState(ctx, instance, updated); err != nil {
+		return err
+	}
+
+	*state = updated
+
+	return nil
+}
@@ -0,0 +1,93 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// hubRestoreMargin is how much older than the last hub sync a history entry must be for its absence from
+	// the hub status to indicate a restore, which allows for events that were cached after the last sync
+	hubRestoreMargin = time.Minute
+	// hubRestoreReplayInterval is the minimum time between two replays of all the policies, since the other
+	// policies also detect the restore until their status is replayed
+	hubRestoreReplayInterval = 5 * time.Minute
+)
+
+// isRestoredStatus returns true if the hub status is older than the last hub sync, such as after the hub was
+// restored from a backup. This is the case when history entries of the new hub status that were already in
+// the status at the last sync are missing from the hub status.
+func isRestoredStatus(
+	policyUID types.UID, hubStatus policiesv1.PolicyStatus, newHubStatus policiesv1.PolicyStatus, lastSync time.Time,
+) bool {
+	if lastSync.IsZero() {
+		return false
+	}
+
+	for _, added := range newHistoryEntries(policyUID, hubStatus, newHubStatus) {
+		if added.entry.LastTimestamp.Time.Before(lastSync.Add(-hubRestoreMargin)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// historySince returns the history entries that occurred after the time
+func historySince(added []templateHistory, since time.Time) []templateHistory {
+	newer := []templateHistory{}
+
+	for _, history := range added {
+		if history.entry.LastTimestamp.Time.After(since) {
+			newer = append(newer, history)
+		}
+	}
+
+	return newer
+}
+
+// replayHubStatus queues all the managed policies to be reconciled, so that their current status is written
+// again to a restored hub. The replay is skipped if the policies were queued recently.
+func (r *PolicyReconciler) replayHubStatus(ctx context.Context) {
+	if r.hubWrites == nil {
+		return
+	}
+
+	now := time.Now()
+	replayedAt := atomic.LoadInt64(&r.restoreReplayedAt)
+
+	if replayedAt != 0 && now.Sub(time.Unix(0, replayedAt)) < hubRestoreReplayInterval {
+		return
+	}
+
+	if !atomic.CompareAndSwapInt64(&r.restoreReplayedAt, replayedAt, now.UnixNano()) {
+		return
+	}
+
+	policyList := &policiesv1.PolicyList{}
+
+	if err := r.ManagedClient.List(ctx, policyList); err != nil {
+		log.Error(err, "Failed to list the policies on managed to replay their status to the hub")
+
+		return
+	}
+
+	log.Info("The hub policy status is older than the last sync, the hub may have been restored from a backup. "+
+		"Replaying the status of all policies.", "Policies", len(policyList.Items))
+
+	hubRestoresTotal.Inc()
+
+	for _, plc := range policyList.Items {
+		r.hubWrites.add(reconcile.Request{NamespacedName: types.NamespacedName{
+			Namespace: plc.GetNamespace(),
+			Name:      plc.GetName(),
+		}}, priorityStateChange)
+	}
+}
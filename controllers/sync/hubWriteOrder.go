@@ -0,0 +1,171 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// outOfOrderRetryDelay is how long the hub write of a status older than the last written status is deferred
+	outOfOrderRetryDelay = time.Second
+	// maxOutOfOrderDeferrals is the number of consecutive times the hub write of a policy is deferred before
+	// its status is written anyway, so that a template whose history was rewritten isn't blocked forever
+	maxOutOfOrderDeferrals = 5
+)
+
+var outOfOrderHubWritesTotal = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "policy_status_sync_out_of_order_hub_writes_total",
+		Help: "The number of hub status writes that were deferred since a template had an older latest " +
+			"compliance event than in the last status written to the hub",
+	},
+)
+
+func init() {
+	metrics.Registry.MustRegister(outOfOrderHubWritesTotal)
+}
+
+// policyExecutor serializes the reconciles of a policy, and is removed once no reconcile uses it
+type policyExecutor struct {
+	lock sync.Mutex
+	refs int
+}
+
+// writtenStatus is the time of the latest compliance event of each template in the last status of a policy
+// written to the hub
+type writtenStatus struct {
+	generation int64
+	latest     map[string]time.Time
+}
+
+// hubWriteOrder applies the hub writes of each policy in the order of its compliance events. The reconciles
+// of a policy are serialized, including the reconciles outside of the hubWriteQueue such as the one-time
+// sync, so that an older reconcile can't finish after a newer one. A status whose template has an older
+// latest event than in the last status written to the hub, such as one built from a managed cache that
+// isn't up to date after a retry or a worker handoff, is deferred until the cache catches up, so that the
+// hub never shows an older state as the latest.
+type hubWriteOrder struct {
+	lock      sync.Mutex
+	executors map[types.NamespacedName]*policyExecutor
+	written   map[types.NamespacedName]writtenStatus
+	// deferred is the number of consecutive deferred hub writes of each policy
+	deferred map[types.NamespacedName]int
+}
+
+// serialize waits for the other reconciles of the policy to finish, and returns the function that releases
+// the policy once this reconcile is done
+func (o *hubWriteOrder) serialize(key types.NamespacedName) func() {
+	o.lock.Lock()
+
+	if o.executors == nil {
+		o.executors = map[types.NamespacedName]*policyExecutor{}
+	}
+
+	executor := o.executors[key]
+	if executor == nil {
+		executor = &policyExecutor{}
+		o.executors[key] = executor
+	}
+
+	executor.refs++
+	o.lock.Unlock()
+
+	executor.lock.Lock()
+
+	return func() {
+		executor.lock.Unlock()
+
+		o.lock.Lock()
+		defer o.lock.Unlock()
+
+		executor.refs--
+		if executor.refs == 0 {
+			delete(o.executors, key)
+		}
+	}
+}
+
+// latestEvents returns the time of the latest compliance event of each template of the status with a history
+func latestEvents(status policiesv1.PolicyStatus) map[string]time.Time {
+	latest := make(map[string]time.Time, len(status.Details))
+
+	for _, dpt := range status.Details {
+		if dpt == nil || len(dpt.History) == 0 {
+			continue
+		}
+
+		latest[dpt.TemplateMeta.Name] = dpt.History[0].LastTimestamp.Time
+	}
+
+	return latest
+}
+
+// isOutOfOrderStatus returns true if the hub write of the status of the policy is deferred since a template
+// has an older latest compliance event than in the last status written to the hub for the same generation.
+// The write isn't deferred after maxOutOfOrderDeferrals consecutive deferrals.
+func (r *PolicyReconciler) isOutOfOrderStatus(instance *policiesv1.Policy, status policiesv1.PolicyStatus) bool {
+	key := types.NamespacedName{Namespace: instance.GetNamespace(), Name: instance.GetName()}
+	o := &r.hubWriteOrder
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	written, ok := o.written[key]
+	if !ok || written.generation != instance.GetGeneration() {
+		return false
+	}
+
+	for template, latest := range latestEvents(status) {
+		if writtenLatest, ok := written.latest[template]; ok && latest.Before(writtenLatest) {
+			if o.deferred[key] >= maxOutOfOrderDeferrals {
+				log.Info("Writing the status to the hub although it's older than the last written status",
+					"Namespace", key.Namespace, "Name", key.Name, "template", template)
+
+				return false
+			}
+
+			if o.deferred == nil {
+				o.deferred = map[types.NamespacedName]int{}
+			}
+
+			o.deferred[key]++
+			outOfOrderHubWritesTotal.Inc()
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// recordHubWriteOrder records the latest compliance events of the status of the policy on the hub
+func (r *PolicyReconciler) recordHubWriteOrder(instance *policiesv1.Policy, status policiesv1.PolicyStatus) {
+	key := types.NamespacedName{Namespace: instance.GetNamespace(), Name: instance.GetName()}
+	o := &r.hubWriteOrder
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if o.written == nil {
+		o.written = map[types.NamespacedName]writtenStatus{}
+	}
+
+	o.written[key] = writtenStatus{generation: instance.GetGeneration(), latest: latestEvents(status)}
+	delete(o.deferred, key)
+}
+
+// forget removes the written status of a deleted policy
+func (o *hubWriteOrder) forget(key types.NamespacedName) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	delete(o.written, key)
+	delete(o.deferred, key)
+}
@@ -0,0 +1,128 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestHubWriteOrderSerialize(t *testing.T) {
+	t.Parallel()
+
+	order := &hubWriteOrder{}
+	key := types.NamespacedName{Namespace: "cluster1", Name: "policies.policy"}
+
+	var running int32
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			release := order.serialize(key)
+			defer release()
+
+			if atomic.AddInt32(&running, 1) != 1 {
+				t.Error("expected the reconciles of the policy to not run concurrently")
+			}
+
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		}()
+	}
+
+	// the reconciles of another policy don't wait for them
+	other := order.serialize(types.NamespacedName{Namespace: "cluster1", Name: "policies.other"})
+	other()
+
+	wg.Wait()
+
+	if len(order.executors) != 0 {
+		t.Fatalf("expected the executors to be removed once they're unused, got %d", len(order.executors))
+	}
+}
+
+func TestIsOutOfOrderStatus(t *testing.T) {
+	t.Parallel()
+
+	reconciler := &PolicyReconciler{}
+	plc := testPolicy()
+	newer := testStatus(policiesv1.NonCompliant, testHistory(3, 2), "template1", "template2")
+	older := testStatus(policiesv1.NonCompliant, testHistory(2), "template1", "template2")
+
+	if reconciler.isOutOfOrderStatus(plc, older) {
+		t.Fatal("expected the first hub write of the policy to not be deferred")
+	}
+
+	reconciler.recordHubWriteOrder(plc, newer)
+
+	if reconciler.isOutOfOrderStatus(plc, newer) {
+		t.Fatal("expected the status with the same latest events to not be deferred")
+	}
+
+	if !reconciler.isOutOfOrderStatus(plc, older) {
+		t.Fatal("expected the status with older latest events to be deferred")
+	}
+
+	// a new template and a template without a history can't be out of order
+	newTemplate := testStatus(policiesv1.NonCompliant, testHistory(1), "template3")
+	newTemplate.Details = append(newTemplate.Details, &policiesv1.DetailsPerTemplate{
+		TemplateMeta: metav1.ObjectMeta{Name: "template1"},
+	})
+
+	if reconciler.isOutOfOrderStatus(plc, newTemplate) {
+		t.Fatal("expected the status of other templates to not be deferred")
+	}
+
+	// the events of a new generation of the policy are compared to its own writes
+	plc.SetGeneration(plc.GetGeneration() + 1)
+
+	if reconciler.isOutOfOrderStatus(plc, older) {
+		t.Fatal("expected the status of a new generation to not be deferred")
+	}
+}
+
+func TestIsOutOfOrderStatusMaxDeferrals(t *testing.T) {
+	t.Parallel()
+
+	reconciler := &PolicyReconciler{}
+	plc := testPolicy()
+	newer := testStatus(policiesv1.NonCompliant, testHistory(3), "template")
+	older := testStatus(policiesv1.NonCompliant, testHistory(2), "template")
+
+	reconciler.recordHubWriteOrder(plc, newer)
+
+	for i := 0; i < maxOutOfOrderDeferrals; i++ {
+		if !reconciler.isOutOfOrderStatus(plc, older) {
+			t.Fatalf("expected the deferral %d of the older status", i+1)
+		}
+	}
+
+	if reconciler.isOutOfOrderStatus(plc, older) {
+		t.Fatal("expected the older status to be written after the maximum deferrals")
+	}
+
+	// writing a status resets the deferrals
+	reconciler.recordHubWriteOrder(plc, newer)
+
+	if !reconciler.isOutOfOrderStatus(plc, older) {
+		t.Fatal("expected the older status to be deferred again after a hub write")
+	}
+
+	// a deleted policy is forgotten
+	reconciler.hubWriteOrder.forget(types.NamespacedName{Namespace: "cluster1", Name: "policies.policy"})
+
+	if reconciler.isOutOfOrderStatus(plc, older) {
+		t.Fatal("expected the status of a forgotten policy to not be deferred")
+	}
+}
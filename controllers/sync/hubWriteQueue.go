@@ -0,0 +1,619 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/stolostron/governance-policy-status-sync/tool"
+)
+
+// hubWritePriority is the tier of a request in the hubWriteQueue. A lower value is processed first.
+type hubWritePriority int
+
+const (
+	// priorityViolation is for the requests of created policies that are noncompliant or have a high or
+	// critical severity template, so that violations are visible on the hub first after a restart
+	priorityViolation hubWritePriority = iota
+	// priorityStateChange is for requests that may change the compliance state of a policy on the hub
+	priorityStateChange
+	// priorityHistory is for requests that only append history or refresh timestamps in the hub status
+	priorityHistory
+	hubWritePriorities
+)
+
+func (p hubWritePriority) String() string {
+	switch p {
+	case priorityViolation:
+		return "violation"
+	case priorityStateChange:
+		return "state-change"
+	default:
+		return "history"
+	}
+}
+
+// hubWriteQueue is a tiered work queue of reconcile requests. Requests that may change the compliance
+// state of a policy are always processed before requests that only refresh its history, so that compliance
+// changes reach the hub first when the queue backs up. The violations of created policies, such as during
+// the initial pass after startup, are processed before both. Like a client-go work queue, a request is
+// queued at most once and isn't processed concurrently. A request that is added again is promoted to the
+// higher of its priorities. Within a tier, the namespaces take turns, so that a namespace with thousands of
+// policies doesn't starve the others during an event storm when several namespaces are watched.
+type hubWriteQueue struct {
+	lock sync.Mutex
+	cond *sync.Cond
+	// tiers are the queued requests of each priority
+	tiers [hubWritePriorities]fairTier
+	// queued is the priority of each queued request
+	queued map[reconcile.Request]hubWritePriority
+	// addedAt is when each queued request was added, to measure the time it waited in the queue
+	addedAt map[reconcile.Request]time.Time
+	// processing are the requests being reconciled and when they started
+	processing map[reconcile.Request]time.Time
+	// dirty are the requests added while being reconciled, which are queued again once done
+	dirty map[reconcile.Request]hubWritePriority
+	// pending are the priorities of the requests waiting in the controller queue to be dispatched
+	pending map[reconcile.Request]hubWritePriority
+	// coalescing are the requests held in the controller queue until the end of their coalescing window
+	coalescing map[reconcile.Request]time.Time
+	// retrying are the requests waiting for their retry after a failed reconcile
+	retrying     map[reconcile.Request]retryState
+	rateLimiter  workqueue.RateLimiter
+	shuttingDown bool
+	// lastDone is when the last request was reconciled
+	lastDone time.Time
+}
+
+func newHubWriteQueue() *hubWriteQueue {
+	queue := &hubWriteQueue{
+		queued:      map[reconcile.Request]hubWritePriority{},
+		addedAt:     map[reconcile.Request]time.Time{},
+		processing:  map[reconcile.Request]time.Time{},
+		dirty:       map[reconcile.Request]hubWritePriority{},
+		pending:     map[reconcile.Request]hubWritePriority{},
+		coalescing:  map[reconcile.Request]time.Time{},
+		retrying:    map[reconcile.Request]retryState{},
+		rateLimiter: workqueue.DefaultControllerRateLimiter(),
+	}
+	queue.cond = sync.NewCond(&queue.lock)
+
+	return queue
+}
+
+// len returns the number of requests that are queued or waiting to be dispatched from the controller queue
+func (q *hubWriteQueue) len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	count := len(q.queued)
+
+	for request := range q.pending {
+		if _, queued := q.queued[request]; !queued {
+			count++
+		}
+	}
+
+	return count
+}
+
+// has returns true if the request is queued, being reconciled, waiting for its retry, or waiting to be
+// dispatched from the controller queue
+func (q *hubWriteQueue) has(request reconcile.Request) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	_, queued := q.queued[request]
+	_, processing := q.processing[request]
+	_, pending := q.pending[request]
+	_, coalescing := q.coalescing[request]
+	_, retrying := q.retrying[request]
+
+	return queued || processing || pending || coalescing || retrying
+}
+
+// setPending records the priority of a request that was added to the controller queue, keeping the
+// higher priority if it's already pending
+func (q *hubWriteQueue) setPending(request reconcile.Request, priority hubWritePriority) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if existing, ok := q.pending[request]; !ok || priority < existing {
+		q.pending[request] = priority
+	}
+}
+
+// dispatch moves a request from the controller queue to its tier. Requests without a pending priority,
+// such as resyncs, are queued as history refreshes.
+func (q *hubWriteQueue) dispatch(request reconcile.Request) {
+	q.lock.Lock()
+
+	priority, ok := q.pending[request]
+	if !ok {
+		priority = priorityHistory
+	}
+
+	delete(q.pending, request)
+	delete(q.coalescing, request)
+	q.lock.Unlock()
+
+	q.add(request, priority)
+}
+
+// coalesce returns true if the request is already held in the controller queue until the end of its
+// coalescing window, and otherwise starts a coalescing window of the request
+func (q *hubWriteQueue) coalesce(request reconcile.Request, window time.Duration) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	now := time.Now()
+
+	if until, ok := q.coalescing[request]; ok && now.Before(until) {
+		return true
+	}
+
+	q.coalescing[request] = now.Add(window)
+
+	return false
+}
+
+// add queues the request with the priority, or promotes it if it's already queued with a lower priority
+func (q *hubWriteQueue) add(request reconcile.Request, priority hubWritePriority) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.shuttingDown {
+		return
+	}
+
+	// a request that is queued again before its retry is no longer waiting for it
+	delete(q.retrying, request)
+
+	if _, processing := q.processing[request]; processing {
+		if existing, ok := q.dirty[request]; !ok || priority < existing {
+			q.dirty[request] = priority
+		}
+
+		return
+	}
+
+	if existing, ok := q.queued[request]; ok {
+		if priority >= existing {
+			return
+		}
+
+		q.remove(request, existing)
+	} else {
+		q.addedAt[request] = time.Now()
+	}
+
+	q.queued[request] = priority
+	q.tiers[priority].push(request)
+	q.cond.Signal()
+}
+
+// remove removes a queued request from its tier and must be called with the lock held
+func (q *hubWriteQueue) remove(request reconcile.Request, priority hubWritePriority) {
+	q.tiers[priority].remove(request)
+}
+
+// addAfter queues the request with the priority once the delay has passed
+func (q *hubWriteQueue) addAfter(request reconcile.Request, priority hubWritePriority, delay time.Duration) {
+	if delay <= 0 {
+		q.add(request, priority)
+
+		return
+	}
+
+	time.AfterFunc(delay, func() { q.add(request, priority) })
+}
+
+// addRateLimited queues the request with the priority once the rate limiter allows it, and records when it's
+// retried along with the error of the reconcile, if any
+func (q *hubWriteQueue) addRateLimited(request reconcile.Request, priority hubWritePriority, err error) {
+	delay := q.rateLimiter.When(request)
+
+	q.lock.Lock()
+
+	state := q.retrying[request]
+	state.priority = priority
+	state.retryAt = time.Now().Add(delay)
+	state.attempts = q.rateLimiter.NumRequeues(request)
+
+	if err != nil {
+		state.lastError = err.Error()
+	}
+
+	q.retrying[request] = state
+	q.lock.Unlock()
+
+	q.addAfter(request, priority, delay)
+}
+
+// forget resets the rate limiting of the request
+func (q *hubWriteQueue) forget(request reconcile.Request) {
+	q.rateLimiter.Forget(request)
+}
+
+// get blocks until a request is queued and returns the oldest request of the next namespace in turn of the
+// highest priority. It returns false once the queue is shut down.
+func (q *hubWriteQueue) get() (reconcile.Request, hubWritePriority, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for {
+		if q.shuttingDown {
+			return reconcile.Request{}, 0, false
+		}
+
+		for priority := range q.tiers {
+			request, ok := q.tiers[priority].pop()
+			if !ok {
+				continue
+			}
+
+			hubWriteQueueSeconds.WithLabelValues(hubWritePriority(priority).String()).Observe(
+				time.Since(q.addedAt[request]).Seconds(),
+			)
+
+			delete(q.queued, request)
+			delete(q.addedAt, request)
+			q.processing[request] = time.Now()
+
+			return request, hubWritePriority(priority), true
+		}
+
+		q.cond.Wait()
+	}
+}
+
+// done marks the request as processed and queues it again if it was added while being processed
+func (q *hubWriteQueue) done(request reconcile.Request) {
+	q.lock.Lock()
+
+	delete(q.processing, request)
+	q.lastDone = time.Now()
+
+	priority, dirty := q.dirty[request]
+	delete(q.dirty, request)
+	q.lock.Unlock()
+
+	if dirty {
+		q.add(request, priority)
+	}
+}
+
+// stalledFor returns how long the queue has made no progress while requests were waiting, which is zero if
+// a request was reconciled since the oldest waiting or processing request was added
+func (q *hubWriteQueue) stalledFor() time.Duration {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	var oldest time.Time
+
+	for _, times := range []map[reconcile.Request]time.Time{q.addedAt, q.processing} {
+		for _, added := range times {
+			if oldest.IsZero() || added.Before(oldest) {
+				oldest = added
+			}
+		}
+	}
+
+	if oldest.IsZero() || q.lastDone.After(oldest) {
+		return 0
+	}
+
+	return time.Since(oldest)
+}
+
+// shutDown stops the queue and wakes up the waiting workers
+func (q *hubWriteQueue) shutDown() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.shuttingDown = true
+	q.cond.Broadcast()
+}
+
+// fairTier is a tier of the hubWriteQueue, whose namespaces take turns. The requests of each namespace are
+// processed in the order they were added.
+type fairTier struct {
+	// namespaces are the namespaces with queued requests in the order of their turns
+	namespaces []string
+	// requests are the queued requests of each namespace in the order they were added
+	requests map[string][]reconcile.Request
+}
+
+// push queues the request after the other requests of its namespace. A namespace without queued requests
+// takes the last turn.
+func (t *fairTier) push(request reconcile.Request) {
+	if t.requests == nil {
+		t.requests = map[string][]reconcile.Request{}
+	}
+
+	if len(t.requests[request.Namespace]) == 0 {
+		t.namespaces = append(t.namespaces, request.Namespace)
+	}
+
+	t.requests[request.Namespace] = append(t.requests[request.Namespace], request)
+}
+
+// pop returns the oldest request of the namespace in turn, which then takes the last turn if it has other
+// queued requests. It returns false if no request is queued.
+func (t *fairTier) pop() (reconcile.Request, bool) {
+	if len(t.namespaces) == 0 {
+		return reconcile.Request{}, false
+	}
+
+	namespace := t.namespaces[0]
+	t.namespaces = t.namespaces[1:]
+	requests := t.requests[namespace]
+
+	if len(requests) > 1 {
+		t.requests[namespace] = requests[1:]
+		t.namespaces = append(t.namespaces, namespace)
+	} else {
+		delete(t.requests, namespace)
+	}
+
+	return requests[0], true
+}
+
+// remove removes a queued request, along with the turn of its namespace if it has no other queued requests
+func (t *fairTier) remove(request reconcile.Request) {
+	requests := t.requests[request.Namespace]
+
+	for i := range requests {
+		if requests[i] != request {
+			continue
+		}
+
+		if len(requests) > 1 {
+			t.requests[request.Namespace] = append(requests[:i], requests[i+1:]...)
+
+			return
+		}
+
+		delete(t.requests, request.Namespace)
+
+		for j := range t.namespaces {
+			if t.namespaces[j] == request.Namespace {
+				t.namespaces = append(t.namespaces[:j], t.namespaces[j+1:]...)
+
+				break
+			}
+		}
+
+		return
+	}
+}
+
+// hubWriteDispatcher is the reconciler of the controller, which moves each request to the hubWriteQueue
+// with the priority recorded by the event handlers. The requests are reconciled by the hubWriteWorker.
+type hubWriteDispatcher struct {
+	queue *hubWriteQueue
+}
+
+func (d *hubWriteDispatcher) Reconcile(_ context.Context, request reconcile.Request) (reconcile.Result, error) {
+	d.queue.dispatch(request)
+
+	return reconcile.Result{}, nil
+}
+
+// hubWriteWorker reconciles the requests of the hubWriteQueue in priority order. Failed and requeued
+// requests are retried with the same priority and rate limiting as with a controller queue.
+type hubWriteWorker struct {
+	reconciler *PolicyReconciler
+}
+
+// NeedLeaderElection is true since only the leader reconciles policies
+func (w *hubWriteWorker) NeedLeaderElection() bool {
+	return true
+}
+
+// Start reconciles the queued requests until the context is done. The queue is in the hubWriteQueues while
+// the worker runs.
+func (w *hubWriteWorker) Start(ctx context.Context) error {
+	queue := w.reconciler.hubWrites
+
+	unregister := registerHubWriteQueue(queue)
+	defer unregister()
+
+	go func() {
+		<-ctx.Done()
+		queue.shutDown()
+	}()
+
+	for {
+		request, priority, ok := queue.get()
+		if !ok {
+			return nil
+		}
+
+		// the history refreshes are deferred while only the reserve of the hub API budget is left
+		if priority == priorityHistory {
+			if spendable, delay := w.reconciler.HubAPIBudget.Spendable(); !spendable {
+				queue.addAfter(request, priority, delay)
+				queue.done(request)
+
+				continue
+			}
+		}
+
+		result, err := w.reconciler.Reconcile(ctx, request)
+
+		switch {
+		case err != nil:
+			log.Error(err, "Reconciler error", "Namespace", request.Namespace, "Name", request.Name,
+				"errorClass", tool.ClassifyError(err))
+			queue.addRateLimited(request, priority, err)
+		case result.RequeueAfter > 0:
+			queue.forget(request)
+			queue.addAfter(request, priority, result.RequeueAfter)
+		case result.Requeue:
+			queue.addRateLimited(request, priority, nil)
+		default:
+			queue.forget(request)
+		}
+
+		queue.done(request)
+	}
+}
+
+// hubWriteHandler enqueues the requests for an object in the controller queue and records their priority
+// in the hubWriteQueue
+type hubWriteHandler struct {
+	queue    *hubWriteQueue
+	requests func(obj client.Object) []reconcile.Request
+	// priority returns the priority for the new object, where the old object is nil unless it was updated
+	priority func(oldObj client.Object, newObj client.Object) hubWritePriority
+	// createdPriority returns the priority for a created object if it's set, and otherwise priority is used
+	createdPriority func(obj client.Object) hubWritePriority
+	// coalescingWindow is how long the requests are held in the controller queue so that the requests for
+	// the same policy in the window are reconciled once. The requests aren't held if it's 0.
+	coalescingWindow time.Duration
+}
+
+// blank assignment to verify that hubWriteHandler implements handler.EventHandler
+var _ handler.EventHandler = &hubWriteHandler{}
+
+func (h *hubWriteHandler) enqueue(
+	q workqueue.RateLimitingInterface, oldObj client.Object, newObj client.Object,
+) {
+	h.enqueueWithPriority(q, newObj, h.priority(oldObj, newObj))
+}
+
+func (h *hubWriteHandler) enqueueWithPriority(
+	q workqueue.RateLimitingInterface, obj client.Object, priority hubWritePriority,
+) {
+	for _, request := range h.requests(obj) {
+		h.queue.setPending(request, priority)
+
+		if h.coalescingWindow <= 0 {
+			q.Add(request)
+
+			continue
+		}
+
+		if h.queue.coalesce(request, h.coalescingWindow) {
+			coalescedHubWritesTotal.Inc()
+
+			continue
+		}
+
+		q.AddAfter(request, h.coalescingWindow)
+	}
+}
+
+func (h *hubWriteHandler) Create(e event.CreateEvent, q workqueue.RateLimitingInterface) {
+	if h.createdPriority != nil {
+		h.enqueueWithPriority(q, e.Object, h.createdPriority(e.Object))
+
+		return
+	}
+
+	h.enqueue(q, nil, e.Object)
+}
+
+func (h *hubWriteHandler) Update(e event.UpdateEvent, q workqueue.RateLimitingInterface) {
+	h.enqueue(q, e.ObjectOld, e.ObjectNew)
+}
+
+func (h *hubWriteHandler) Delete(e event.DeleteEvent, q workqueue.RateLimitingInterface) {
+	h.enqueue(q, nil, e.Object)
+}
+
+func (h *hubWriteHandler) Generic(e event.GenericEvent, q workqueue.RateLimitingInterface) {
+	h.enqueue(q, nil, e.Object)
+}
+
+// policyRequests returns the request for the policy itself
+func policyRequests(obj client.Object) []reconcile.Request {
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+	}}}
+}
+
+// policyPriority returns priorityStateChange when a policy is created or deleted, or when its spec or
+// compliance state changes
+func policyPriority(oldObj client.Object, newObj client.Object) hubWritePriority {
+	oldPlc, oldOK := oldObj.(*policiesv1.Policy)
+	newPlc, newOK := newObj.(*policiesv1.Policy)
+
+	if !oldOK || !newOK || oldPlc.GetGeneration() != newPlc.GetGeneration() ||
+		oldPlc.Status.ComplianceState != newPlc.Status.ComplianceState {
+		return priorityStateChange
+	}
+
+	return priorityHistory
+}
+
+// createdPolicyPriority returns priorityViolation when a policy with a violation is created, such as
+// during the initial pass after startup when all the existing policies are created in the cache, and
+// priorityStateChange otherwise
+func createdPolicyPriority(obj client.Object) hubWritePriority {
+	if plc, ok := obj.(*policiesv1.Policy); ok && hasViolation(plc) {
+		return priorityViolation
+	}
+
+	return priorityStateChange
+}
+
+// hasViolation returns true if the policy is noncompliant or has a template with a high or critical
+// severity
+func hasViolation(plc *policiesv1.Policy) bool {
+	if plc.Status.ComplianceState == policiesv1.NonCompliant {
+		return true
+	}
+
+	for _, policyT := range plc.Spec.PolicyTemplates {
+		object, _, err := unstructured.UnstructuredJSONScheme.Decode(policyT.ObjectDefinition.Raw, nil, nil)
+		if err != nil {
+			continue
+		}
+
+		if severityRanks[templateSeverity(object)] >= severityRanks["high"] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// eventPriority returns priorityHistory when a policy template event repeats the compliance of its previous
+// occurrence, and priorityStateChange otherwise. It's computed from the event alone, since the event handlers
+// must not read from the API server.
+func eventPriority(oldObj client.Object, newObj client.Object) hubWritePriority {
+	evt, ok := newObj.(*corev1.Event)
+	if !ok || policyEventRgx.FindStringSubmatch(evt.Reason) == nil {
+		return priorityStateChange
+	}
+
+	if oldEvt, ok := oldObj.(*corev1.Event); ok {
+		if historyCompliance(oldEvt.Message) == historyCompliance(evt.Message) {
+			return priorityHistory
+		}
+
+		return priorityStateChange
+	}
+
+	// a repeated event has the same message, and so the same compliance, as its previous occurrences
+	if evt.Count > 1 || (evt.Series != nil && evt.Series.Count > 1) {
+		return priorityHistory
+	}
+
+	return priorityStateChange
+}
@@ -0,0 +1,489 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func request(namespace string, name string) reconcile.Request {
+	return reconcile.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: name}}
+}
+
+// getNow returns the next request of the queue, and fails if get blocks since no request is queued
+func getNow(t *testing.T, queue *hubWriteQueue) (reconcile.Request, hubWritePriority) {
+	t.Helper()
+
+	if queue.len() == 0 {
+		t.Fatal("expected a queued request")
+	}
+
+	got, priority, ok := queue.get()
+	if !ok {
+		t.Fatal("expected the queue to not be shut down")
+	}
+
+	return got, priority
+}
+
+func TestHubWriteQueuePriorityOrder(t *testing.T) {
+	t.Parallel()
+
+	queue := newHubWriteQueue()
+
+	queue.add(request("ns", "history"), priorityHistory)
+	queue.add(request("ns", "state-change"), priorityStateChange)
+	queue.add(request("ns", "violation"), priorityViolation)
+
+	for _, expected := range []struct {
+		name     string
+		priority hubWritePriority
+	}{
+		{"violation", priorityViolation},
+		{"state-change", priorityStateChange},
+		{"history", priorityHistory},
+	} {
+		got, priority := getNow(t, queue)
+		if got.Name != expected.name || priority != expected.priority {
+			t.Fatalf("expected %s with the priority %s, got %s with %s", expected.name, expected.priority,
+				got.Name, priority)
+		}
+
+		queue.done(got)
+	}
+
+	if queue.len() != 0 {
+		t.Fatalf("expected an empty queue, got %d requests", queue.len())
+	}
+}
+
+func TestHubWriteQueuePromotionOnReAdd(t *testing.T) {
+	t.Parallel()
+
+	queue := newHubWriteQueue()
+
+	queue.add(request("ns", "promoted"), priorityHistory)
+	queue.add(request("ns", "other"), priorityStateChange)
+
+	// adding again with a lower priority doesn't demote the request
+	queue.add(request("ns", "other"), priorityHistory)
+	queue.add(request("ns", "promoted"), priorityViolation)
+
+	if queue.len() != 2 {
+		t.Fatalf("expected each request to be queued once, got %d requests", queue.len())
+	}
+
+	got, priority := getNow(t, queue)
+	if got.Name != "promoted" || priority != priorityViolation {
+		t.Fatalf("expected the promoted request first, got %s with %s", got.Name, priority)
+	}
+
+	queue.done(got)
+
+	got, priority = getNow(t, queue)
+	if got.Name != "other" || priority != priorityStateChange {
+		t.Fatalf("expected the other request with its higher priority, got %s with %s", got.Name, priority)
+	}
+
+	queue.done(got)
+
+	if queue.len() != 0 {
+		t.Fatalf("expected the promoted request to be removed from its previous tier, got %d requests",
+			queue.len())
+	}
+}
+
+func TestHubWriteQueueDirtyWhileProcessing(t *testing.T) {
+	t.Parallel()
+
+	queue := newHubWriteQueue()
+	policy := request("ns", "policy")
+
+	queue.add(policy, priorityHistory)
+
+	got, _ := getNow(t, queue)
+	if got != policy {
+		t.Fatalf("expected %s, got %s", policy, got)
+	}
+
+	// the request isn't processed concurrently, so it's queued again once done with the highest priority
+	queue.add(policy, priorityHistory)
+	queue.add(policy, priorityStateChange)
+	queue.add(policy, priorityHistory)
+
+	if queue.len() != 0 {
+		t.Fatalf("expected the request being processed to not be queued, got %d requests", queue.len())
+	}
+
+	if !queue.has(policy) {
+		t.Fatal("expected the request being processed to be in the queue")
+	}
+
+	queue.done(policy)
+
+	got, priority := getNow(t, queue)
+	if got != policy || priority != priorityStateChange {
+		t.Fatalf("expected the requeued request with %s, got %s with %s", priorityStateChange, got, priority)
+	}
+
+	queue.done(policy)
+
+	if queue.len() != 0 || queue.has(policy) {
+		t.Fatal("expected the request to be processed once more")
+	}
+}
+
+func TestHubWriteQueueRetryingClearedByAdd(t *testing.T) {
+	t.Parallel()
+
+	queue := newHubWriteQueue()
+	// the retry doesn't fire during the test
+	queue.rateLimiter = workqueue.NewItemExponentialFailureRateLimiter(time.Hour, time.Hour)
+	policy := request("ns", "policy")
+
+	queue.addRateLimited(policy, priorityHistory, errors.New("the hub is unreachable"))
+
+	queue.lock.Lock()
+	state, retrying := queue.retrying[policy]
+	queue.lock.Unlock()
+
+	if !retrying || state.lastError != "the hub is unreachable" || state.priority != priorityHistory {
+		t.Fatalf("expected the request to wait for its retry, got %+v", state)
+	}
+
+	if queue.len() != 0 || !queue.has(policy) {
+		t.Fatal("expected the request waiting for its retry to be in the queue without being queued")
+	}
+
+	queue.add(policy, priorityStateChange)
+
+	queue.lock.Lock()
+	_, retrying = queue.retrying[policy]
+	queue.lock.Unlock()
+
+	if retrying {
+		t.Fatal("expected the newer add to clear the retry")
+	}
+
+	got, priority := getNow(t, queue)
+	if got != policy || priority != priorityStateChange {
+		t.Fatalf("expected the added request with %s, got %s with %s", priorityStateChange, got, priority)
+	}
+}
+
+func TestHubWriteQueueShutDownWakesGet(t *testing.T) {
+	t.Parallel()
+
+	queue := newHubWriteQueue()
+	returned := make(chan bool)
+
+	go func() {
+		_, _, ok := queue.get()
+		returned <- ok
+	}()
+
+	select {
+	case <-returned:
+		t.Fatal("expected get to block while the queue is empty")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	queue.shutDown()
+
+	select {
+	case ok := <-returned:
+		if ok {
+			t.Fatal("expected get to return false once the queue is shut down")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the shut down to wake up get")
+	}
+
+	queue.add(request("ns", "policy"), priorityViolation)
+
+	if queue.len() != 0 {
+		t.Fatal("expected the requests added after the shut down to be ignored")
+	}
+}
+
+func TestFairTierRoundRobin(t *testing.T) {
+	t.Parallel()
+
+	tier := fairTier{}
+
+	for _, queued := range []reconcile.Request{
+		request("busy", "a"), request("busy", "b"), request("busy", "c"), request("quiet", "d"),
+		request("other", "e"),
+	} {
+		tier.push(queued)
+	}
+
+	// the namespaces take turns, and the requests of a namespace are in the order they were added
+	expected := []string{"a", "d", "e", "b", "c"}
+
+	for _, name := range expected {
+		got, ok := tier.pop()
+		if !ok || got.Name != name {
+			t.Fatalf("expected %s, got %s (%v)", name, got.Name, ok)
+		}
+	}
+
+	if _, ok := tier.pop(); ok {
+		t.Fatal("expected an empty tier")
+	}
+
+	// a namespace whose requests were all processed takes the last turn once it has queued requests again
+	tier.push(request("busy", "f"))
+	tier.push(request("quiet", "g"))
+	tier.push(request("busy", "h"))
+
+	for _, name := range []string{"f", "g", "h"} {
+		if got, _ := tier.pop(); got.Name != name {
+			t.Fatalf("expected %s, got %s", name, got.Name)
+		}
+	}
+}
+
+func TestFairTierRemove(t *testing.T) {
+	t.Parallel()
+
+	tier := fairTier{}
+
+	for _, queued := range []reconcile.Request{
+		request("first", "a"), request("first", "b"), request("second", "c"), request("third", "d"),
+	} {
+		tier.push(queued)
+	}
+
+	// removing a request keeps the turn of its namespace while it has other requests
+	tier.remove(request("first", "a"))
+	// removing the only request of a namespace removes its turn
+	tier.remove(request("second", "c"))
+	// removing a request that isn't queued does nothing
+	tier.remove(request("second", "missing"))
+	tier.remove(request("fourth", "missing"))
+
+	for _, name := range []string{"b", "d"} {
+		got, ok := tier.pop()
+		if !ok || got.Name != name {
+			t.Fatalf("expected %s, got %s (%v)", name, got.Name, ok)
+		}
+	}
+
+	if _, ok := tier.pop(); ok {
+		t.Fatal("expected the removed requests to not be popped")
+	}
+
+	if len(tier.namespaces) != 0 || len(tier.requests) != 0 {
+		t.Fatalf("expected no turns left, got %v and %v", tier.namespaces, tier.requests)
+	}
+}
+
+func TestHubWriteQueueNamespaceStarvation(t *testing.T) {
+	t.Parallel()
+
+	queue := newHubWriteQueue()
+
+	// an event storm in one namespace is queued before the requests of the other namespaces
+	for i := 0; i < 100; i++ {
+		queue.add(request("busy", fmt.Sprintf("policy-%d", i)), priorityStateChange)
+	}
+
+	queue.add(request("quiet", "policy"), priorityStateChange)
+	queue.add(request("busy", "history"), priorityHistory)
+	queue.add(request("other", "history"), priorityHistory)
+
+	// the other namespaces take their turn after the first request of the busy namespace
+	for i, expected := range []reconcile.Request{request("busy", "policy-0"), request("quiet", "policy")} {
+		got, priority := getNow(t, queue)
+		if got != expected || priority != priorityStateChange {
+			t.Fatalf("expected the request %d to be %s, got %s with %s", i, expected, got, priority)
+		}
+
+		queue.done(got)
+	}
+
+	// the history refreshes wait for all the state changes, even of the busy namespace
+	for i := 1; i < 100; i++ {
+		got, _ := getNow(t, queue)
+		if got.Namespace != "busy" || got.Name != fmt.Sprintf("policy-%d", i) {
+			t.Fatalf("expected the state change policy-%d of the busy namespace, got %s", i, got)
+		}
+
+		queue.done(got)
+	}
+
+	for _, expected := range []reconcile.Request{request("busy", "history"), request("other", "history")} {
+		if got, priority := getNow(t, queue); got != expected || priority != priorityHistory {
+			t.Fatalf("expected the history refresh %s, got %s with %s", expected, got, priority)
+		}
+	}
+}
+
+func TestHubWriteHandlerCoalescing(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		window   time.Duration
+		expected int
+	}{
+		"coalesced":     {time.Hour, 0},
+		"not coalesced": {0, 1},
+	}
+
+	for name, test := range tests {
+		queue := newHubWriteQueue()
+		controllerQueue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+		priorities := []hubWritePriority{priorityHistory, priorityStateChange, priorityHistory}
+		handler := &hubWriteHandler{
+			queue:    queue,
+			requests: policyRequests,
+			priority: func(client.Object, client.Object) hubWritePriority {
+				priority := priorities[0]
+				priorities = priorities[1:]
+
+				return priority
+			},
+			coalescingWindow: test.window,
+		}
+
+		for i := 0; i < 3; i++ {
+			handler.Generic(event.GenericEvent{Object: testPolicy()}, controllerQueue)
+		}
+
+		// the coalesced requests are held in the controller queue until the end of the window
+		if controllerQueue.Len() != test.expected {
+			t.Fatalf("%s: expected %d requests in the controller queue, got %d", name, test.expected,
+				controllerQueue.Len())
+		}
+
+		controllerQueue.ShutDown()
+
+		policy := request("cluster1", "policies.policy")
+		if !queue.has(policy) {
+			t.Fatalf("%s: expected the request to be pending", name)
+		}
+
+		// the request is dispatched once with the highest priority of the coalesced requests
+		queue.dispatch(policy)
+
+		if got, priority := getNow(t, queue); got != policy || priority != priorityStateChange {
+			t.Fatalf("%s: expected %s with %s, got %s with %s", name, policy, priorityStateChange, got, priority)
+		}
+
+		queue.done(policy)
+
+		if queue.len() != 0 || queue.has(policy) {
+			t.Fatalf("%s: expected the coalesced requests to be reconciled once", name)
+		}
+	}
+}
+
+func TestEventPriority(t *testing.T) {
+	t.Parallel()
+
+	templateEvent := func(message string, count int32) *corev1.Event {
+		return &corev1.Event{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "cluster1", Name: "policies.policy.1"},
+			Reason:     "policy: cluster1/my-template",
+			Message:    message,
+			Count:      count,
+		}
+	}
+
+	compliant := "Compliant; notification - the object was found as specified"
+	noncompliant := "NonCompliant; violation - the object was not found"
+
+	series := templateEvent(compliant, 0)
+	series.Series = &corev1.EventSeries{Count: 2}
+
+	tests := map[string]struct {
+		oldObj   client.Object
+		newObj   client.Object
+		expected hubWritePriority
+	}{
+		"new event":          {nil, templateEvent(compliant, 1), priorityStateChange},
+		"repeated event":     {nil, templateEvent(compliant, 3), priorityHistory},
+		"repeated series":    {nil, series, priorityHistory},
+		"same compliance":    {templateEvent(compliant, 1), templateEvent(compliant, 2), priorityHistory},
+		"changed compliance": {templateEvent(compliant, 3), templateEvent(noncompliant, 3), priorityStateChange},
+		"not a template event": {
+			nil, &corev1.Event{Reason: "PolicyStatusSync", Message: compliant, Count: 3}, priorityStateChange,
+		},
+		"not an event": {nil, testPolicy(), priorityStateChange},
+	}
+
+	for name, test := range tests {
+		if priority := eventPriority(test.oldObj, test.newObj); priority != test.expected {
+			t.Fatalf("%s: expected %s, got %s", name, test.expected, priority)
+		}
+	}
+}
+
+// registeredQueue returns true if the queue is in the hubWriteQueues
+func registeredQueue(queue *hubWriteQueue) bool {
+	hubWriteQueues.lock.Lock()
+	defer hubWriteQueues.lock.Unlock()
+
+	for _, registered := range hubWriteQueues.queues {
+		if registered == queue {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestHubWriteWorkerRegistersQueue(t *testing.T) {
+	t.Parallel()
+
+	reconciler := &PolicyReconciler{hubWrites: newHubWriteQueue()}
+
+	if registeredQueue(reconciler.hubWrites) {
+		t.Fatal("expected the queue to not be registered before its worker starts")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+
+	go func() {
+		stopped <- (&hubWriteWorker{reconciler: reconciler}).Start(ctx)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for !registeredQueue(reconciler.hubWrites) {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the running worker to register its queue")
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	cancel()
+
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the worker to stop")
+	}
+
+	if registeredQueue(reconciler.hubWrites) {
+		t.Fatal("expected the stopped worker to unregister its queue")
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// logBudgetWindow is the window in which the info logs of each policy are limited to the log budget
+const logBudgetWindow = time.Minute
+
+// logBudgets limit the info logs of the reconciles of each policy, so that the identical logs of busy
+// clusters don't drown the log aggregation. The info logs over the budget are logged at the debug level,
+// and the number of them is logged when the next window starts.
+type logBudgets struct {
+	lock     sync.Mutex
+	policies map[types.NamespacedName]*logBudget
+}
+
+type logBudget struct {
+	windowStart time.Time
+	logged      int
+	suppressed  int
+}
+
+// allow returns true if the info log of the policy fits in the budget of info logs per window. When a new
+// window starts with logs that were suppressed in the previous one, it also returns their number.
+func (b *logBudgets) allow(policy types.NamespacedName, budgetSize int, now time.Time) (bool, int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.policies == nil {
+		b.policies = map[types.NamespacedName]*logBudget{}
+	}
+
+	budget, ok := b.policies[policy]
+	if !ok {
+		budget = &logBudget{windowStart: now}
+		b.policies[policy] = budget
+	}
+
+	suppressed := 0
+
+	if now.Sub(budget.windowStart) >= logBudgetWindow {
+		suppressed = budget.suppressed
+		*budget = logBudget{windowStart: now}
+	}
+
+	if budget.logged >= budgetSize {
+		budget.suppressed++
+
+		return false, suppressed
+	}
+
+	budget.logged++
+
+	return true, suppressed
+}
+
+// forget removes the log budget of a policy that was deleted
+func (b *logBudgets) forget(policy types.NamespacedName) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	delete(b.policies, policy)
+}
+
+// budgetLogger is a logger whose info logs over the log budget of the policy are logged at the debug level.
+// The errors are always logged.
+type budgetLogger struct {
+	logr.Logger
+	budgets *logBudgets
+	policy  types.NamespacedName
+	budget  int
+}
+
+// Info logs the message at the info level if it fits in the log budget of the policy, and at the debug
+// level otherwise
+func (l budgetLogger) Info(msg string, keysAndValues ...interface{}) {
+	allowed, suppressed := l.budgets.allow(l.policy, l.budget, time.Now())
+
+	if suppressed > 0 {
+		l.Logger.Info("Logged the info logs over the log budget of the policy at the debug level",
+			"suppressed", suppressed, "window", logBudgetWindow.String())
+	}
+
+	if allowed {
+		l.Logger.Info(msg, keysAndValues...)
+	} else {
+		l.Logger.V(1).Info(msg, keysAndValues...)
+	}
+}
+
+// WithValues returns a logger with the key and value pairs that shares the log budget of the policy
+func (l budgetLogger) WithValues(keysAndValues ...interface{}) logr.Logger {
+	l.Logger = l.Logger.WithValues(keysAndValues...)
+
+	return l
+}
+
+// WithName returns a logger with the name that shares the log budget of the policy
+func (l budgetLogger) WithName(name string) logr.Logger {
+	l.Logger = l.Logger.WithName(name)
+
+	return l
+}
+
+// policyLogger returns the logger of the reconciles of the policy, which limits its info logs to
+// LogBudget per minute. It's the logger with the policy values if LogBudget is 0.
+func (r *PolicyReconciler) policyLogger(policy types.NamespacedName) logr.Logger {
+	logger := log.WithValues("Request.Namespace", policy.Namespace, "Request.Name", policy.Name)
+
+	if r.LogBudget <= 0 {
+		return logger
+	}
+
+	return budgetLogger{Logger: logger, budgets: &r.logBudgets, policy: policy, budget: r.LogBudget}
+}
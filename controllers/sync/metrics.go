@@ -0,0 +1,285 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var leaderTakeoverSeconds = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "policy_status_sync_leader_takeover_duration_seconds",
+		Help: "The time in seconds from becoming the leader to completing the first successful reconcile",
+	},
+)
+
+var propagationLatencySeconds = prometheus.NewHistogram(
+	prometheus.HistogramOpts{
+		Name: "policy_status_sync_propagation_latency_seconds",
+		Help: "The time in seconds from a compliance event on the managed cluster to the successful write of " +
+			"the policy status on the hub",
+		// 100ms to about 27 minutes
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 15),
+	},
+)
+
+var hubWriteQueueSeconds = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name: "policy_status_sync_hub_write_queue_duration_seconds",
+		Help: "The time in seconds a policy reconcile request waited in the hub write queue, by priority tier",
+		// 10ms to about 5 minutes
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 15),
+	},
+	[]string{"priority"},
+)
+
+var templateComplianceGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "policy_status_sync_template_compliance",
+		Help: "The compliance state of each policy template on the managed cluster, where 0 is compliant, " +
+			"1 is noncompliant, and -1 is pending or unknown",
+	},
+	[]string{"namespace", "policy", "template", "kind"},
+)
+
+var watchedPoliciesGauge = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "policy_status_sync_watched_policies",
+		Help: "The number of policies currently watched on the managed cluster",
+	},
+)
+
+var namespacePoliciesGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "policy_status_sync_namespace_policies",
+		Help: "The number of policies currently watched in each namespace",
+	},
+	[]string{"namespace"},
+)
+
+var pendingHubWritesGauge = prometheus.NewGaugeFunc(
+	prometheus.GaugeOpts{
+		Name: "policy_status_sync_pending_hub_writes",
+		Help: "The number of policies waiting in the hub write queues to be reconciled",
+	},
+	pendingHubWrites,
+)
+
+var hubRestoresTotal = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "policy_status_sync_hub_restores_total",
+		Help: "The number of times a hub policy status older than the last sync was detected, such as after a " +
+			"hub restore, and the status of all policies was replayed",
+	},
+)
+
+var hubRecreationsTotal = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "policy_status_sync_hub_recreations_total",
+		Help: "The number of times a hub policy was detected to be deleted and re-created with the same name",
+	},
+)
+
+var coalescedHubWritesTotal = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "policy_status_sync_coalesced_hub_writes_total",
+		Help: "The number of policy template events whose hub status write was coalesced with the write of an " +
+			"earlier event of the same policy in the hub write coalescing window",
+	},
+)
+
+var statusTruncationsTotal = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "policy_status_sync_status_truncations_total",
+		Help: "The number of hub status writes whose history was trimmed to fit in the hub policy size limit",
+	},
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		leaderTakeoverSeconds, propagationLatencySeconds, hubWriteQueueSeconds, templateComplianceGauge,
+		watchedPoliciesGauge, namespacePoliciesGauge, pendingHubWritesGauge, hubRestoresTotal,
+		eventCacheLookupsTotal, hubRecreationsTotal, statusTruncationsTotal, coalescedHubWritesTotal,
+	)
+}
+
+// observePropagationLatency records the propagation latency of the compliance history entries that were
+// added to the hub status. The time of an entry is the last time its event occurred, since the creation
+// time of a recurring event is its first occurrence.
+func observePropagationLatency(added []templateHistory, writtenAt time.Time) {
+	for _, history := range added {
+		if history.entry.LastTimestamp.IsZero() {
+			continue
+		}
+
+		latency := writtenAt.Sub(history.entry.LastTimestamp.Time)
+		if latency < 0 {
+			// clock skew between the managed cluster and this process
+			latency = 0
+		}
+
+		propagationLatencySeconds.Observe(latency.Seconds())
+	}
+}
+
+// templateSeries tracks the template compliance series of each policy so that the series of removed
+// templates and deleted policies are deleted
+var templateSeries = struct {
+	lock sync.Mutex
+	// kinds maps each policy to the kind of each of its templates
+	kinds map[types.NamespacedName]map[string]string
+}{kinds: map[types.NamespacedName]map[string]string{}}
+
+// complianceValue returns the template compliance gauge value of a compliance state
+func complianceValue(compliance policiesv1.ComplianceState) float64 {
+	switch compliance {
+	case policiesv1.Compliant:
+		return 0
+	case policiesv1.NonCompliant:
+		return 1
+	default:
+		return -1
+	}
+}
+
+// recordTemplateCompliance sets the template compliance gauges of a policy from its status and deletes the
+// series of the templates that were removed. The kinds map each template name to its kind.
+func recordTemplateCompliance(
+	policy types.NamespacedName, details []*policiesv1.DetailsPerTemplate, kinds map[string]string,
+) {
+	templateSeries.lock.Lock()
+	defer templateSeries.lock.Unlock()
+
+	current := make(map[string]string, len(details))
+
+	for _, dpt := range details {
+		name := dpt.TemplateMeta.Name
+		current[name] = kinds[name]
+
+		templateComplianceGauge.WithLabelValues(policy.Namespace, policy.Name, name, kinds[name]).Set(
+			complianceValue(dpt.ComplianceState),
+		)
+	}
+
+	for name, kind := range templateSeries.kinds[policy] {
+		if currentKind, ok := current[name]; !ok || currentKind != kind {
+			templateComplianceGauge.DeleteLabelValues(policy.Namespace, policy.Name, name, kind)
+		}
+	}
+
+	templateSeries.kinds[policy] = current
+}
+
+// deletePolicyMetrics deletes the metrics of a deleted policy
+func deletePolicyMetrics(policy types.NamespacedName) {
+	untrackPolicy(policy)
+	forgetPolicySetCompliance(policy)
+	forgetComplianceScore(policy)
+
+	templateSeries.lock.Lock()
+	defer templateSeries.lock.Unlock()
+
+	for name, kind := range templateSeries.kinds[policy] {
+		templateComplianceGauge.DeleteLabelValues(policy.Namespace, policy.Name, name, kind)
+	}
+
+	delete(templateSeries.kinds, policy)
+}
+
+// watchedPolicies are the names of the reconciled policies that exist in each namespace
+var watchedPolicies = struct {
+	lock       sync.Mutex
+	namespaces map[string]map[string]bool
+}{namespaces: map[string]map[string]bool{}}
+
+// trackPolicy counts the policy in the watched policy gauges
+func trackPolicy(policy types.NamespacedName) {
+	watchedPolicies.lock.Lock()
+	defer watchedPolicies.lock.Unlock()
+
+	names := watchedPolicies.namespaces[policy.Namespace]
+	if names == nil {
+		names = map[string]bool{}
+		watchedPolicies.namespaces[policy.Namespace] = names
+	}
+
+	if names[policy.Name] {
+		return
+	}
+
+	names[policy.Name] = true
+
+	watchedPoliciesGauge.Inc()
+	namespacePoliciesGauge.WithLabelValues(policy.Namespace).Set(float64(len(names)))
+}
+
+// untrackPolicy removes a deleted policy from the watched policy gauges
+func untrackPolicy(policy types.NamespacedName) {
+	watchedPolicies.lock.Lock()
+	defer watchedPolicies.lock.Unlock()
+
+	names := watchedPolicies.namespaces[policy.Namespace]
+	if !names[policy.Name] {
+		return
+	}
+
+	delete(names, policy.Name)
+	watchedPoliciesGauge.Dec()
+
+	if len(names) == 0 {
+		delete(watchedPolicies.namespaces, policy.Namespace)
+		namespacePoliciesGauge.DeleteLabelValues(policy.Namespace)
+
+		return
+	}
+
+	namespacePoliciesGauge.WithLabelValues(policy.Namespace).Set(float64(len(names)))
+}
+
+// hubWriteQueues are the queues of the running hub write workers, which are summed in the pending hub writes
+// gauge
+var hubWriteQueues = struct {
+	lock   sync.Mutex
+	queues []*hubWriteQueue
+}{}
+
+// registerHubWriteQueue adds the queue to the hubWriteQueues and returns the function that removes it
+func registerHubWriteQueue(queue *hubWriteQueue) func() {
+	hubWriteQueues.lock.Lock()
+	defer hubWriteQueues.lock.Unlock()
+
+	hubWriteQueues.queues = append(hubWriteQueues.queues, queue)
+
+	return func() {
+		hubWriteQueues.lock.Lock()
+		defer hubWriteQueues.lock.Unlock()
+
+		for i, registered := range hubWriteQueues.queues {
+			if registered == queue {
+				hubWriteQueues.queues = append(hubWriteQueues.queues[:i], hubWriteQueues.queues[i+1:]...)
+
+				return
+			}
+		}
+	}
+}
+
+// pendingHubWrites returns the number of requests waiting in the hub write queues
+func pendingHubWrites() float64 {
+	hubWriteQueues.lock.Lock()
+	defer hubWriteQueues.lock.Unlock()
+
+	pending := 0
+
+	for _, queue := range hubWriteQueues.queues {
+		pending += queue.len()
+	}
+
+	return float64(pending)
+}
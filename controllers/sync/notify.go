@@ -0,0 +1,159 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+
+	"github.com/stolostron/governance-policy-status-sync/sinks"
+)
+
+const (
+	// HubEventsSink is the name of the sink that records the compliance transitions as events on the hub
+	HubEventsSink = "hub-events"
+	// ManagedEventsSink is the name of the sink that records the compliance transitions as events on the
+	// managed cluster
+	ManagedEventsSink = "managed-events"
+	// NotifyChannelAnnotation is the annotation of a policy with its notification channel, such as a team
+	// name, which the external sinks route its compliance transitions by
+	NotifyChannelAnnotation = "notify.policy.open-cluster-management.io/channel"
+)
+
+// complianceSinks returns the pipeline of the compliance transitions, which multiplexes them to the external
+// sinks and to the hub and managed recorders by the SinkControls. The recorders are disabled by default,
+// since the status sync events are already recorded on the policy.
+func (r *PolicyReconciler) complianceSinks() *sinks.Multiplexer {
+	r.multiplexerOnce.Do(func() {
+		all := append([]sinks.Sink{}, r.Sinks...)
+
+		if r.ManagedRecorder != nil {
+			all = append(all, sinks.NewRecorderSink(ManagedEventsSink, r.ManagedRecorder))
+		}
+
+		// on the hub cluster, the managed recorder records on the hub
+		if r.HubRecorder != nil && !r.LocalCluster {
+			all = append(all, sinks.NewRecorderSink(HubEventsSink, r.HubRecorder))
+		}
+
+		r.multiplexer = sinks.NewMultiplexer(all, r.SinkControls, func(sink sinks.Sink) bool {
+			_, recorder := sink.(*sinks.RecorderSink)
+
+			return !recorder
+		})
+	})
+
+	return r.multiplexer
+}
+
+// notifySinks sends the compliance transition of the policy to every enabled sink. Failures are logged but
+// don't fail the reconcile, since the sinks are best effort. The severities are keyed by template name.
+func (r *PolicyReconciler) notifySinks(
+	ctx context.Context, instance *policiesv1.Policy, previous policiesv1.ComplianceState,
+	severities map[string]string,
+) {
+	complianceSinks := r.complianceSinks()
+	if complianceSinks.Empty() {
+		return
+	}
+
+	transition := sinks.ComplianceTransition{
+		SchemaVersion:      sinks.SchemaVersion,
+		Cluster:            r.ClusterName,
+		ClusterIdentity:    r.ClusterIdentity,
+		Namespace:          instance.GetNamespace(),
+		Policy:             instance.GetName(),
+		PreviousCompliance: string(previous),
+		Compliance:         string(instance.Status.ComplianceState),
+		Timestamp:          time.Now().UTC(),
+	}
+
+	if transition.Cluster == "" {
+		transition.Cluster = instance.GetNamespace()
+	}
+
+	// an invalid channel is left out so that the transition is still sent to the default destination
+	if channel := instance.GetAnnotations()[NotifyChannelAnnotation]; sinks.ValidChannel(channel) {
+		transition.Channel = channel
+	} else if channel != "" {
+		log.Info("Ignoring the invalid notification channel of the policy", "Namespace", instance.GetNamespace(),
+			"Name", instance.GetName(), "channel", channel)
+	}
+
+	for _, dpt := range instance.Status.Details {
+		template := sinks.TemplateCompliance{
+			Name:       dpt.TemplateMeta.GetName(),
+			Compliance: string(dpt.ComplianceState),
+		}
+
+		// the severities that aren't in the schema of the sinks are left out
+		if severity := severities[dpt.TemplateMeta.GetName()]; severityRanks[severity] > 0 {
+			template.Severity = severity
+		}
+
+		if severityRanks[template.Severity] > severityRanks[transition.Severity] {
+			transition.Severity = template.Severity
+		}
+
+		if len(dpt.History) > 0 {
+			template.Message = dpt.History[0].Message
+		}
+
+		transition.Templates = append(transition.Templates, template)
+	}
+
+	if err := complianceSinks.Send(ctx, transition); err != nil {
+		log.Error(err, "Failed to send the compliance transition to the sinks", "sinks", complianceSinks.Name(),
+			"Namespace", instance.GetNamespace(), "Name", instance.GetName())
+	}
+}
+
+// recordComplianceEvents queues the compliance history entries that were added to the hub status to be
+// sent to the compliance history API. The entries are reported by the controller that reported their
+// event if it's in the reporters, which are keyed by the idempotency key of the entries, and otherwise by
+// this controller.
+func (r *PolicyReconciler) recordComplianceEvents(
+	instance *policiesv1.Policy, added []templateHistory, reporters map[string]string,
+) {
+	if r.HistoryReporter == nil || len(added) == 0 {
+		return
+	}
+
+	cluster := r.ClusterName
+	if cluster == "" {
+		cluster = instance.GetNamespace()
+	}
+
+	// replicated policies are named after their root policy: ${namespace}.${name}
+	parent := sinks.ComplianceEventPolicy{Name: instance.GetName()}
+	_, root := r.rootPolicy(instance)
+	if parts := strings.SplitN(root, ".", 2); len(parts) == 2 {
+		parent = sinks.ComplianceEventPolicy{Name: parts[1], Namespace: parts[0]}
+	}
+
+	events := make([]sinks.ComplianceEvent, 0, len(added))
+
+	for _, history := range added {
+		reportedBy := reporters[historyKey(instance.GetUID(), history.templateName, history.entry)]
+		if reportedBy == "" {
+			reportedBy = r.eventComponent()
+		}
+
+		events = append(events, sinks.ComplianceEvent{
+			Cluster:      sinks.ComplianceEventCluster{Name: cluster},
+			ParentPolicy: parent,
+			Policy:       sinks.ComplianceEventPolicy{Name: history.templateName},
+			Event: sinks.ComplianceEventDetails{
+				Compliance: string(historyCompliance(history.entry.Message)),
+				Message:    history.entry.Message,
+				Timestamp:  history.entry.LastTimestamp.UTC(),
+				ReportedBy: reportedBy,
+			},
+		})
+	}
+
+	r.HistoryReporter.Record(events...)
+}
@@ -0,0 +1,98 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var excludedPoliciesGauge = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "policy_status_sync_excluded_policies",
+		Help: "The number of policies whose status isn't synced since they match a policy exclusion",
+	},
+)
+
+func init() {
+	metrics.Registry.MustRegister(excludedPoliciesGauge)
+}
+
+// excludedPolicies are the reconciled policies that matched the PolicyExclusions, so that they are synced
+// again once the exclusions change and they no longer match
+type excludedPolicies struct {
+	lock     sync.Mutex
+	policies map[types.NamespacedName]bool
+}
+
+// excludePolicy returns true if the status of the policy isn't synced since it matches the PolicyExclusions,
+// and records the policy so that it's synced once it no longer matches
+func (r *PolicyReconciler) excludePolicy(key types.NamespacedName) bool {
+	excluded := r.PolicyExclusions.Excluded(key.Namespace, key.Name)
+
+	e := &r.excludedPolicies
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if !excluded {
+		if e.policies[key] {
+			delete(e.policies, key)
+			excludedPoliciesGauge.Dec()
+		}
+
+		return false
+	}
+
+	if e.policies == nil {
+		e.policies = map[types.NamespacedName]bool{}
+	}
+
+	if !e.policies[key] {
+		e.policies[key] = true
+		excludedPoliciesGauge.Inc()
+	}
+
+	return true
+}
+
+// resyncIncludedPolicies syncs the excluded policies that no longer match the PolicyExclusions after they
+// changed
+func (r *PolicyReconciler) resyncIncludedPolicies() {
+	e := &r.excludedPolicies
+
+	e.lock.Lock()
+
+	included := []types.NamespacedName{}
+
+	for key := range e.policies {
+		if !r.PolicyExclusions.Excluded(key.Namespace, key.Name) {
+			included = append(included, key)
+		}
+	}
+
+	e.lock.Unlock()
+
+	for _, key := range included {
+		log.Info("Syncing the policy since it no longer matches a policy exclusion", "Namespace", key.Namespace,
+			"Name", key.Name)
+
+		r.Resync(key)
+	}
+}
+
+// forgetExcludedPolicy removes a deleted policy from the excluded policies
+func (r *PolicyReconciler) forgetExcludedPolicy(key types.NamespacedName) {
+	e := &r.excludedPolicies
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.policies[key] {
+		delete(e.policies, key)
+		excludedPoliciesGauge.Dec()
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/stolostron/governance-policy-status-sync/tool"
+)
+
+func TestExcludePolicy(t *testing.T) {
+	t.Parallel()
+
+	canary := types.NamespacedName{Namespace: "cluster1", Name: "policies.canary"}
+	other := types.NamespacedName{Namespace: "cluster1", Name: "policies.other"}
+
+	tests := map[string]struct {
+		// patterns are the patterns of the ConfigMap after the policies were first reconciled
+		patterns string
+		// resynced are the policies that are queued to be synced after the exclusions changed
+		resynced []types.NamespacedName
+		// excluded are the policies that are excluded on their next reconcile
+		excluded []types.NamespacedName
+	}{
+		"unchanged":          {"policies.canary", nil, []types.NamespacedName{canary}},
+		"no longer excluded": {"", []types.NamespacedName{canary}, nil},
+		"other exclusion":    {"policies.other", []types.NamespacedName{canary}, []types.NamespacedName{other}},
+		"both excluded":      {"policies.*", nil, []types.NamespacedName{canary, other}},
+	}
+
+	for name, test := range tests {
+		exclusions := &tool.PolicyExclusions{}
+		if err := exclusions.Update("policies.canary"); err != nil {
+			t.Fatal(err)
+		}
+
+		reconciler := &PolicyReconciler{PolicyExclusions: exclusions, hubWrites: newHubWriteQueue()}
+		exclusions.OnChange(reconciler.resyncIncludedPolicies)
+
+		if !reconciler.excludePolicy(canary) || reconciler.excludePolicy(other) {
+			t.Fatalf("%s: expected only the canary policy to be excluded", name)
+		}
+
+		if err := exclusions.Update(test.patterns); err != nil {
+			t.Fatal(err)
+		}
+
+		if queued := reconciler.hubWrites.len(); queued != len(test.resynced) {
+			t.Fatalf("%s: expected %d policies to be synced again, got %d", name, len(test.resynced), queued)
+		}
+
+		for _, key := range test.resynced {
+			if !reconciler.hubWrites.has(reconcile.Request{NamespacedName: key}) {
+				t.Fatalf("%s: expected the policy %s to be synced again", name, key)
+			}
+		}
+
+		// the other policy was never excluded, so it's only synced by its own reconciles
+		if reconciler.hubWrites.has(reconcile.Request{NamespacedName: other}) {
+			t.Fatalf("%s: expected the policy %s to not be synced again", name, other)
+		}
+
+		// the excluded policies are recorded on their next reconcile
+		reconciler.excludePolicy(canary)
+		reconciler.excludePolicy(other)
+
+		if len(reconciler.excludedPolicies.policies) != len(test.excluded) {
+			t.Fatalf("%s: expected %d excluded policies, got %v", name, len(test.excluded),
+				reconciler.excludedPolicies.policies)
+		}
+
+		for _, key := range test.excluded {
+			if !reconciler.excludedPolicies.policies[key] {
+				t.Fatalf("%s: expected the policy %s to be excluded", name, key)
+			}
+		}
+
+		reconciler.forgetExcludedPolicy(canary)
+		reconciler.forgetExcludedPolicy(other)
+
+		if len(reconciler.excludedPolicies.policies) != 0 {
+			t.Fatalf("%s: expected the deleted policies to be forgotten", name)
+		}
+	}
+}
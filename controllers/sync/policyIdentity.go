@@ -0,0 +1,89 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"fmt"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	"github.com/stolostron/governance-policy-propagator/controllers/common"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// rootPolicyLabels returns the labels that identify the root policy of a replicated policy in order of
+// precedence
+func (r *PolicyReconciler) rootPolicyLabels() []string {
+	if len(r.RootPolicyLabels) == 0 {
+		return []string{common.RootPolicyLabel}
+	}
+
+	return r.RootPolicyLabels
+}
+
+// rootPolicy returns the first root policy label set on the policy and its value, which is the
+// ${namespace}.${name} of the root policy. The label is empty if none are set.
+func (r *PolicyReconciler) rootPolicy(plc metav1.Object) (string, string) {
+	for _, label := range r.rootPolicyLabels() {
+		if root := plc.GetLabels()[label]; root != "" {
+			return label, root
+		}
+	}
+
+	return "", ""
+}
+
+// hubPolicyKey returns the key of the hub policy that the status of the managed policy is synced to. It's in
+// the namespace of the HubNamespaceLabel of the managed policy if it's set, such as the namespace of an
+// intermediate hub object in a hub-of-hubs topology, and otherwise in the same namespace.
+func (r *PolicyReconciler) hubPolicyKey(managedPlc *policiesv1.Policy) types.NamespacedName {
+	key := types.NamespacedName{Namespace: managedPlc.GetNamespace(), Name: managedPlc.GetName()}
+
+	if r.HubNamespaceLabel != "" {
+		if namespace := managedPlc.GetLabels()[r.HubNamespaceLabel]; namespace != "" {
+			key.Namespace = namespace
+		}
+	}
+
+	return key
+}
+
+// validateParentPolicy verifies that the replicated policy on the managed cluster and the policy on the hub
+// belong to the same root policy, and that the hub policy is the one recorded in the sync state of the managed
+// policy. This prevents a renamed or spoofed managed policy from overwriting the status of another policy on
+// the hub. A hub policy with the recorded name and another UID was re-created, which is handled by syncHubUID.
+// A nil error means the status may be synced.
+func (r *PolicyReconciler) validateParentPolicy(
+	managedPlc *policiesv1.Policy, hubPlc *policiesv1.Policy, state syncState,
+) error {
+	label, hubRoot := r.rootPolicy(hubPlc)
+	if label == "" {
+		return fmt.Errorf("the hub policy is missing the %s label", r.rootPolicyLabels()[0])
+	}
+
+	// replicated policies are named after their root policy: ${namespace}.${name}, while the labels of a
+	// higher-level hub may refer to a root policy on that hub instead
+	if label == common.RootPolicyLabel && hubRoot != hubPlc.GetName() {
+		return fmt.Errorf(
+			"the hub policy %s label %s does not match the replicated policy name", label, hubRoot,
+		)
+	}
+
+	managedRoot := managedPlc.GetLabels()[label]
+	if managedRoot != hubRoot {
+		return fmt.Errorf(
+			"the managed policy %s label %q does not match the hub policy label %q", label, managedRoot, hubRoot,
+		)
+	}
+
+	// a hub policy other than the recorded one means the managed policy was changed to point to it, such as
+	// with the HubNamespaceLabel
+	if hubKey := r.hubPolicyKey(managedPlc).String(); state.hubPolicy != "" && state.hubPolicy != hubKey {
+		return fmt.Errorf(
+			"the hub policy %s does not match the hub policy %s (UID %q) recorded in the sync state", hubKey,
+			state.hubPolicy, state.hubUID,
+		)
+	}
+
+	return nil
+}
@@ -0,0 +1,204 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	"github.com/stolostron/governance-policy-propagator/controllers/common"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestValidateParentPolicy(t *testing.T) {
+	t.Parallel()
+
+	hubPlc := testPolicy()
+	hubPlc.SetUID("hub-uid")
+
+	managedPlc := testPolicy()
+	managedPlc.SetUID("managed-uid")
+
+	tests := map[string]struct {
+		managedPlc *policiesv1.Policy
+		state      syncState
+		expected   string
+	}{
+		"matching hub policy": {
+			managedPlc: managedPlc,
+			state:      syncState{hubPolicy: "cluster1/policies.policy", hubUID: "hub-uid"},
+		},
+		"no recorded hub policy": {
+			managedPlc: managedPlc,
+		},
+		"re-created hub policy": {
+			managedPlc: managedPlc,
+			state:      syncState{hubPolicy: "cluster1/policies.policy", hubUID: "old-uid"},
+		},
+		"other hub policy": {
+			managedPlc: managedPlc,
+			state:      syncState{hubPolicy: "cluster2/policies.policy", hubUID: "other-uid"},
+			expected: "the hub policy cluster1/policies.policy does not match the hub policy " +
+				`cluster2/policies.policy (UID "other-uid") recorded in the sync state`,
+		},
+		"other root policy": {
+			managedPlc: &policiesv1.Policy{ObjectMeta: metav1.ObjectMeta{
+				Labels: map[string]string{common.RootPolicyLabel: "policies.other"},
+			}},
+			expected: `label "policies.other" does not match the hub policy label "policies.policy"`,
+		},
+	}
+
+	reconciler := &PolicyReconciler{}
+
+	for name, test := range tests {
+		err := reconciler.validateParentPolicy(test.managedPlc, hubPlc, test.state)
+
+		if test.expected == "" && err != nil {
+			t.Fatalf("%s: expected the status to be synced, got %v", name, err)
+		}
+
+		if test.expected != "" && (err == nil || !strings.Contains(err.Error(), test.expected)) {
+			t.Fatalf("%s: expected the error to contain %q, got %v", name, test.expected, err)
+		}
+	}
+}
+
+// identityReconciler returns a reconciler of the managed policy with the sync state ConfigMap that records the
+// hub policy cluster1/policies.policy with the UID, and of the hub policies
+func identityReconciler(
+	managedPlc *policiesv1.Policy, recordedUID string, hubPolicies ...client.Object,
+) (*PolicyReconciler, *record.FakeRecorder) {
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "cluster1",
+			Name:      syncStateName(managedPlc.GetName()),
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: policiesv1.GroupVersion.String(), Kind: policiesv1.Kind, Name: managedPlc.GetName(),
+				UID: managedPlc.GetUID(),
+			}},
+		},
+		Data: map[string]string{SyncStateHubPolicyKey: "cluster1/policies.policy", SyncStateHubUIDKey: recordedUID},
+	}
+	recorder := record.NewFakeRecorder(10)
+
+	return &PolicyReconciler{
+		ManagedClient:     fakeClient(managedPlc, configMap),
+		HubClient:         fakeClient(hubPolicies...),
+		ManagedRecorder:   recorder,
+		HubRecorder:       record.NewFakeRecorder(10),
+		HubNamespaceLabel: "example.com/hub-namespace",
+	}, recorder
+}
+
+func TestReconcileRefusesOtherHubPolicy(t *testing.T) {
+	t.Parallel()
+
+	recreatedHubPlc := testPolicy()
+	recreatedHubPlc.SetUID("new-uid")
+
+	otherHubPlc := testPolicy()
+	otherHubPlc.SetUID("other-uid")
+	otherHubPlc.SetNamespace("cluster2")
+	otherHubPlc.Status.ComplianceState = policiesv1.Compliant
+
+	redirected := testPolicy()
+	redirected.SetUID("managed-uid")
+	redirected.Labels["example.com/hub-namespace"] = "cluster2"
+	redirected.Status.ComplianceState = policiesv1.NonCompliant
+
+	spoofed := testPolicy()
+	spoofed.SetUID("managed-uid")
+	spoofed.Labels[common.RootPolicyLabel] = "policies.other"
+	spoofed.Status.ComplianceState = policiesv1.NonCompliant
+
+	tests := map[string]struct {
+		managedPlc *policiesv1.Policy
+		hubPlc     *policiesv1.Policy
+		expected   string
+	}{
+		// the other hub policy looks like a re-creation of the recorded one if its UID is recorded first
+		"other hub namespace": {redirected, otherHubPlc, "does not match the hub policy cluster1/policies.policy"},
+		"spoofed root label":  {spoofed, recreatedHubPlc, `label "policies.other" does not match`},
+	}
+
+	for name, test := range tests {
+		reconciler, recorder := identityReconciler(
+			test.managedPlc.DeepCopy(), "old-uid", recreatedHubPlc.DeepCopy(), otherHubPlc.DeepCopy(),
+		)
+		request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "cluster1", Name: "policies.policy"}}
+
+		if _, err := reconciler.Reconcile(context.TODO(), request); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		select {
+		case event := <-recorder.Events:
+			if !strings.HasPrefix(event, "Warning PolicyStatusSync") || !strings.Contains(event, test.expected) {
+				t.Fatalf("%s: expected a warning event containing %q, got %s", name, test.expected, event)
+			}
+		default:
+			t.Fatalf("%s: expected a warning event on the managed policy", name)
+		}
+
+		hubPlc := &policiesv1.Policy{}
+		if err := reconciler.HubClient.Get(context.TODO(), client.ObjectKeyFromObject(test.hubPlc), hubPlc); err != nil {
+			t.Fatal(err)
+		}
+
+		if hubPlc.Status.ComplianceState != test.hubPlc.Status.ComplianceState {
+			t.Fatalf("%s: expected the hub status to not be written, got %v", name, hubPlc.Status)
+		}
+
+		managedPlc := &policiesv1.Policy{}
+		if err := reconciler.ManagedClient.Get(context.TODO(), request.NamespacedName, managedPlc); err != nil {
+			t.Fatal(err)
+		}
+
+		if managedPlc.Status.ComplianceState != policiesv1.NonCompliant {
+			t.Fatalf("%s: expected the managed status to not be reset, got %v", name, managedPlc.Status)
+		}
+
+		if uid := syncStateConfigMap(t, reconciler).Data[SyncStateHubUIDKey]; uid != "old-uid" {
+			t.Fatalf("%s: expected the recorded hub UID to be kept, got %s", name, uid)
+		}
+	}
+}
+
+func TestReconcileRecreatedHubPolicy(t *testing.T) {
+	t.Parallel()
+
+	managedPlc := testPolicy()
+	managedPlc.SetUID("managed-uid")
+	managedPlc.Status.ComplianceState = policiesv1.NonCompliant
+
+	hubPlc := testPolicy()
+	hubPlc.SetUID("new-uid")
+
+	reconciler, _ := identityReconciler(managedPlc, "old-uid", hubPlc)
+	request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "cluster1", Name: "policies.policy"}}
+
+	if _, err := reconciler.Reconcile(context.TODO(), request); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := reconciler.ManagedClient.Get(context.TODO(), request.NamespacedName, managedPlc); err != nil {
+		t.Fatal(err)
+	}
+
+	if managedPlc.Status.ComplianceState == policiesv1.NonCompliant {
+		t.Fatalf("expected the managed status of the re-created hub policy to be reset, got %v", managedPlc.Status)
+	}
+
+	data := syncStateConfigMap(t, reconciler).Data
+	if data[SyncStateHubUIDKey] != "new-uid" || data[SyncStateHistoryResetKey] == "" {
+		t.Fatalf("expected the UID of the re-created hub policy and the reset time to be recorded, got %v", data)
+	}
+}
@@ -0,0 +1,205 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// PolicyOverflowLog doesn't sync the policies over the MaxWatchedPolicies limit
+	PolicyOverflowLog = "log"
+	// PolicyOverflowPeriodic syncs the policies over the MaxWatchedPolicies limit at the
+	// PolicyOverflowInterval instead of on every change
+	PolicyOverflowPeriodic = "periodic"
+)
+
+var overflowPoliciesGauge = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "policy_status_sync_overflow_policies",
+		Help: "The number of policies over the --max-watched-policies limit, which aren't synced on every change",
+	},
+)
+
+func init() {
+	metrics.Registry.MustRegister(overflowPoliciesGauge)
+}
+
+// policyLimit are the policies that are synced on every change, up to the MaxWatchedPolicies limit, and
+// the overflow policies over the limit, so that a runaway policy generator on the managed cluster doesn't
+// grow the per-policy state of the agent until it runs out of memory and stops the status sync of every
+// policy. The policies are admitted in the order that they are first reconciled, and an overflow policy is
+// admitted once a watched policy is deleted and the overflow policy is reconciled again.
+type policyLimit struct {
+	lock     sync.Mutex
+	admitted map[types.NamespacedName]bool
+	// overflow are the policies over the limit, which are true when they are due to be synced once by the
+	// periodic overflow sync
+	overflow map[types.NamespacedName]bool
+}
+
+// validatePolicyOverflow returns an error if the PolicyOverflow behavior is invalid
+func (r *PolicyReconciler) validatePolicyOverflow() error {
+	switch r.PolicyOverflow {
+	case "", PolicyOverflowLog, PolicyOverflowPeriodic:
+		return nil
+	}
+
+	return fmt.Errorf("invalid policy overflow behavior %q, it must be %s or %s", r.PolicyOverflow,
+		PolicyOverflowLog, PolicyOverflowPeriodic)
+}
+
+// admitPolicy returns true if the policy is synced, which is when it's within the MaxWatchedPolicies limit or,
+// for an overflow policy, when it's due to be synced by the periodic overflow sync. It's always true if
+// MaxWatchedPolicies is 0.
+func (r *PolicyReconciler) admitPolicy(key types.NamespacedName) bool {
+	if r.MaxWatchedPolicies <= 0 {
+		return true
+	}
+
+	w := &r.policyLimit
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.admitted[key] {
+		return true
+	}
+
+	due, overflowed := w.overflow[key]
+
+	if len(w.admitted) < r.MaxWatchedPolicies {
+		if w.admitted == nil {
+			w.admitted = map[types.NamespacedName]bool{}
+		}
+
+		w.admitted[key] = true
+
+		if overflowed {
+			delete(w.overflow, key)
+			overflowPoliciesGauge.Dec()
+		}
+
+		return true
+	}
+
+	if due {
+		w.overflow[key] = false
+
+		return true
+	}
+
+	if overflowed {
+		return false
+	}
+
+	if w.overflow == nil {
+		w.overflow = map[types.NamespacedName]bool{}
+	}
+
+	// only the first overflow policy is logged, so that a runaway policy generator doesn't flood the logs
+	if len(w.overflow) == 0 {
+		log.Info("The number of policies is over the --max-watched-policies limit, the policies over it aren't "+
+			"synced on every change", "limit", r.MaxWatchedPolicies, "overflow", r.PolicyOverflow)
+	}
+
+	w.overflow[key] = false
+	overflowPoliciesGauge.Inc()
+
+	log.V(1).Info("The policy is over the --max-watched-policies limit", "Namespace", key.Namespace,
+		"Name", key.Name)
+
+	return false
+}
+
+// forgetPolicy removes a deleted policy from the watched or overflow policies
+func (r *PolicyReconciler) forgetPolicy(key types.NamespacedName) {
+	w := &r.policyLimit
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	delete(w.admitted, key)
+
+	if _, ok := w.overflow[key]; ok {
+		delete(w.overflow, key)
+		overflowPoliciesGauge.Dec()
+	}
+}
+
+// policyOverflowSync lists the policies at the PolicyOverflowInterval and syncs the overflow policies once,
+// so that the policies over the MaxWatchedPolicies limit degrade to a periodic sync instead of not being
+// synced. The overflow policies that are no longer listed are forgotten.
+type policyOverflowSync struct {
+	reconciler *PolicyReconciler
+}
+
+// NeedLeaderElection is true since only the leader syncs the policies
+func (s *policyOverflowSync) NeedLeaderElection() bool {
+	return true
+}
+
+// Start syncs the overflow policies every PolicyOverflowInterval until the context is done
+func (s *policyOverflowSync) Start(ctx context.Context) error {
+	ticker := time.NewTicker(s.reconciler.PolicyOverflowInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			s.sync(ctx)
+		}
+	}
+}
+
+// sync marks the listed overflow policies as due and queues them as history refreshes, so that they don't
+// delay the compliance state changes of the watched policies. Failures are only logged.
+func (s *policyOverflowSync) sync(ctx context.Context) {
+	r := s.reconciler
+
+	policies := &policiesv1.PolicyList{}
+	if err := r.ManagedClient.List(ctx, policies); err != nil {
+		log.Error(err, "Failed to list the policies for the periodic sync of the overflow policies")
+
+		return
+	}
+
+	listed := make(map[types.NamespacedName]bool, len(policies.Items))
+	for i := range policies.Items {
+		listed[types.NamespacedName{Namespace: policies.Items[i].Namespace, Name: policies.Items[i].Name}] = true
+	}
+
+	w := &r.policyLimit
+	due := []types.NamespacedName{}
+
+	w.lock.Lock()
+
+	for key := range w.overflow {
+		if !listed[key] {
+			delete(w.overflow, key)
+			overflowPoliciesGauge.Dec()
+
+			continue
+		}
+
+		w.overflow[key] = true
+		due = append(due, key)
+	}
+
+	w.lock.Unlock()
+
+	for _, key := range due {
+		r.hubWrites.add(reconcile.Request{NamespacedName: key}, priorityHistory)
+	}
+}
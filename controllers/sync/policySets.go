@@ -0,0 +1,219 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	"github.com/stolostron/governance-policy-propagator/controllers/common"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// PolicySetsAnnotation is set on the template metadata in the status of the policy templates of a policy
+// that belongs to policy sets, and contains the comma-separated names of the policy sets on the hub, so
+// that the hub and local views can group the results by policy set
+const PolicySetsAnnotation = "policy.open-cluster-management.io/policy-sets"
+
+// policySetRefresh is the interval at which the policy sets of a namespace are listed again on the hub
+const policySetRefresh = time.Minute
+
+var policySetComplianceGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "policy_status_sync_policy_set_compliance",
+		Help: "The compliance state of each policy set on the managed cluster, aggregated from the policies of " +
+			"the set, where 0 is compliant, 1 is noncompliant, and -1 is pending or unknown",
+	},
+	[]string{"namespace", "policy_set"},
+)
+
+func init() {
+	metrics.Registry.MustRegister(policySetComplianceGauge)
+}
+
+// policySetList is the policy sets of a namespace on the hub, keyed by the name of their policies
+type policySetList struct {
+	sets      map[string][]string
+	fetchedAt time.Time
+}
+
+// policySetMembership caches the policy sets of the namespaces of the root policies
+type policySetMembership struct {
+	lock       sync.Mutex
+	namespaces map[string]policySetList
+}
+
+// rootPolicyName returns the namespace and name of the root policy of the replicated policy from the root
+// policy label, whose value is ${namespace}.${name}. It returns empty strings if the label isn't set.
+func rootPolicyName(plc *policiesv1.Policy) (string, string) {
+	// namespaces can't contain dots, so the first one separates the namespace from the name
+	parts := strings.SplitN(plc.GetLabels()[common.RootPolicyLabel], ".", 2)
+	if len(parts) != 2 {
+		return "", ""
+	}
+
+	return parts[0], parts[1]
+}
+
+// policySets returns the sorted names of the policy sets on the hub that the root policy of the replicated
+// policy belongs to. The policy sets of each namespace are listed at most once per policySetRefresh. It
+// returns false if the membership is unknown, such as when the policy sets can't be listed on the hub.
+func (r *PolicyReconciler) policySets(ctx context.Context, plc *policiesv1.Policy) ([]string, bool) {
+	namespace, name := rootPolicyName(plc)
+	if namespace == "" {
+		return nil, false
+	}
+
+	r.policySetMembership.lock.Lock()
+	defer r.policySetMembership.lock.Unlock()
+
+	list, ok := r.policySetMembership.namespaces[namespace]
+	if !ok || time.Since(list.fetchedAt) > policySetRefresh {
+		setList := &policiesv1.PolicySetList{}
+
+		err := r.HubClient.List(ctx, setList, client.InNamespace(namespace))
+		if err != nil {
+			log.V(1).Info("Failed to list the policy sets on the hub, the policy set membership is unchanged",
+				"Namespace", namespace, "error", err.Error())
+
+			return nil, false
+		}
+
+		list = policySetList{sets: map[string][]string{}, fetchedAt: time.Now()}
+
+		for _, set := range setList.Items {
+			for _, policy := range set.Spec.Policies {
+				list.sets[string(policy)] = append(list.sets[string(policy)], set.GetName())
+			}
+		}
+
+		for _, sets := range list.sets {
+			sort.Strings(sets)
+		}
+
+		if r.policySetMembership.namespaces == nil {
+			r.policySetMembership.namespaces = map[string]policySetList{}
+		}
+
+		r.policySetMembership.namespaces[namespace] = list
+	}
+
+	return list.sets[name], true
+}
+
+// setPolicySets sets the policy sets annotation of the template status from the policy sets of the policy.
+// The annotation is left as is if the membership is unknown, and removed if the policy doesn't belong to a
+// policy set or the membership is disabled.
+func setPolicySets(dpt *policiesv1.DetailsPerTemplate, sets []string, known bool) {
+	if !known {
+		return
+	}
+
+	annotations := dpt.TemplateMeta.GetAnnotations()
+
+	if len(sets) == 0 {
+		if annotations[PolicySetsAnnotation] == "" {
+			return
+		}
+
+		delete(annotations, PolicySetsAnnotation)
+	} else {
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+
+		annotations[PolicySetsAnnotation] = strings.Join(sets, ",")
+	}
+
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+
+	dpt.TemplateMeta.SetAnnotations(annotations)
+}
+
+// policySetSeries tracks the compliance of the policies of each policy set so that the compliance of the
+// policy set is aggregated from its policies on the managed cluster
+var policySetSeries = struct {
+	lock sync.Mutex
+	// members maps each policy set to the compliance state of each of its policies
+	members map[types.NamespacedName]map[types.NamespacedName]policiesv1.ComplianceState
+	// sets maps each policy to its policy sets
+	sets map[types.NamespacedName][]types.NamespacedName
+}{
+	members: map[types.NamespacedName]map[types.NamespacedName]policiesv1.ComplianceState{},
+	sets:    map[types.NamespacedName][]types.NamespacedName{},
+}
+
+// recordPolicySetCompliance records the compliance state of the policy in the policy sets that it belongs
+// to, which are in the namespace of its root policy, and updates the compliance gauges of the policy sets
+// that it was added to or removed from
+func recordPolicySetCompliance(
+	policy types.NamespacedName, namespace string, sets []string, compliance policiesv1.ComplianceState,
+) {
+	policySetSeries.lock.Lock()
+	defer policySetSeries.lock.Unlock()
+
+	current := make([]types.NamespacedName, 0, len(sets))
+	for _, name := range sets {
+		current = append(current, types.NamespacedName{Namespace: namespace, Name: name})
+	}
+
+	for _, set := range policySetSeries.sets[policy] {
+		delete(policySetSeries.members[set], policy)
+		updatePolicySetGauge(set)
+	}
+
+	for _, set := range current {
+		if policySetSeries.members[set] == nil {
+			policySetSeries.members[set] = map[types.NamespacedName]policiesv1.ComplianceState{}
+		}
+
+		policySetSeries.members[set][policy] = compliance
+		updatePolicySetGauge(set)
+	}
+
+	if len(current) == 0 {
+		delete(policySetSeries.sets, policy)
+	} else {
+		policySetSeries.sets[policy] = current
+	}
+}
+
+// forgetPolicySetCompliance removes a deleted policy from the compliance of its policy sets
+func forgetPolicySetCompliance(policy types.NamespacedName) {
+	recordPolicySetCompliance(policy, "", nil, "")
+}
+
+// updatePolicySetGauge sets the compliance gauge of the policy set from the compliance of its policies, and
+// deletes it if it no longer has policies on the managed cluster. The lock of policySetSeries must be held.
+func updatePolicySetGauge(set types.NamespacedName) {
+	members := policySetSeries.members[set]
+	if len(members) == 0 {
+		delete(policySetSeries.members, set)
+		policySetComplianceGauge.DeleteLabelValues(set.Namespace, set.Name)
+
+		return
+	}
+
+	compliance := policiesv1.Compliant
+
+	for _, memberCompliance := range members {
+		if memberCompliance == policiesv1.NonCompliant {
+			compliance = policiesv1.NonCompliant
+
+			break
+		} else if memberCompliance != policiesv1.Compliant {
+			compliance = ""
+		}
+	}
+
+	policySetComplianceGauge.WithLabelValues(set.Namespace, set.Name).Set(complianceValue(compliance))
+}
@@ -6,10 +6,11 @@ package sync
 import (
 	"context"
 	"fmt"
-	"os"
 	"regexp"
 	"sort"
-	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
 	"github.com/stolostron/governance-policy-propagator/controllers/common"
@@ -21,28 +22,131 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
-	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/cluster"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/stolostron/governance-policy-status-sync/sinks"
+	"github.com/stolostron/governance-policy-status-sync/tool"
 )
 
 const ControllerName string = "policy-status-sync"
 
 var log = logf.Log.WithName(ControllerName)
 
+// policyEventRgx matches the reason of a policy template event, such as
+// 'policy: calamari/policy-grc-rbactest-example', where the second group is the template name.
+var policyEventRgx = regexp.MustCompile(`(?i)^policy:\s*([A-Za-z0-9.-]+)\s*\/([A-Za-z0-9.-]+)`)
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *PolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&policiesv1.Policy{}).
-		Watches(
-			&source.Kind{Type: &corev1.Event{}},
-			handler.EnqueueRequestsFromMapFunc(eventMapper),
-			builder.WithPredicates(eventPredicateFuncs),
-		).
-		Complete(r)
+	return r.setupController(
+		mgr, ControllerName, &source.Kind{Type: &policiesv1.Policy{}}, &source.Kind{Type: &corev1.Event{}},
+	)
+}
+
+// SetupWithCluster sets up a controller with the Manager that reconciles the policies of a managed cluster
+// other than the one the Manager runs on. The name must be unique for each cluster. The cluster must be
+// added to the Manager separately so that its cache is started.
+func (r *PolicyReconciler) SetupWithCluster(mgr ctrl.Manager, managedCluster cluster.Cluster, name string) error {
+	return r.setupController(
+		mgr,
+		name,
+		source.NewKindWithCache(&policiesv1.Policy{}, managedCluster.GetCache()),
+		source.NewKindWithCache(&corev1.Event{}, managedCluster.GetCache()),
+	)
+}
+
+// setupController sets up a controller that watches the policies and their events from the sources. The
+// controller moves the requests to a hubWriteQueue, which is reconciled by a hubWriteWorker so that hub
+// writes that may change the compliance state of a policy are processed before history refreshes.
+func (r *PolicyReconciler) setupController(
+	mgr ctrl.Manager, name string, policySource source.Source, eventSource source.Source,
+) error {
+	if err := mgr.Add(&leaderTakeover{reconciler: r}); err != nil {
+		return err
+	}
+
+	r.hubWrites = newHubWriteQueue()
+
+	syncFailureRegistry.lock.Lock()
+	syncFailureRegistry.failures = append(syncFailureRegistry.failures, &r.syncFailures)
+	syncFailureRegistry.lock.Unlock()
+
+	if err := mgr.Add(&hubWriteWorker{reconciler: r}); err != nil {
+		return err
+	}
+
+	if r.HubConsistencyInterval > 0 && !r.LocalCluster {
+		if err := mgr.Add(&hubConsistencyChecker{reconciler: r}); err != nil {
+			return err
+		}
+	}
+
+	if err := r.validatePolicyOverflow(); err != nil {
+		return err
+	}
+
+	if err := r.validateUnknownCompliance(); err != nil {
+		return err
+	}
+
+	if r.MaxWatchedPolicies > 0 && r.PolicyOverflow == PolicyOverflowPeriodic && r.PolicyOverflowInterval > 0 {
+		if err := mgr.Add(&policyOverflowSync{reconciler: r}); err != nil {
+			return err
+		}
+	}
+
+	if r.PolicyExclusions != nil {
+		r.PolicyExclusions.OnChange(r.resyncIncludedPolicies)
+	}
+
+	if r.MarkStoppedOnShutdown && !r.LocalCluster {
+		if err := mgr.Add(&stoppedMarker{reconciler: r}); err != nil {
+			return err
+		}
+	}
+
+	ctrlr, err := controller.New(name, mgr, controller.Options{Reconciler: &hubWriteDispatcher{queue: r.hubWrites}})
+	if err != nil {
+		return err
+	}
+
+	err = ctrlr.Watch(
+		policySource,
+		&hubWriteHandler{
+			queue:           r.hubWrites,
+			requests:        policyRequests,
+			priority:        policyPriority,
+			createdPriority: createdPolicyPriority,
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	return ctrlr.Watch(
+		eventSource,
+		&hubWriteHandler{
+			queue:            r.hubWrites,
+			requests:         eventMapper,
+			priority:         eventPriority,
+			coalescingWindow: r.HubWriteCoalescingWindow,
+		},
+		eventPredicates(r.eventComponent()),
+	)
+}
+
+// eventComponent returns the source component of the events recorded by this controller
+func (r *PolicyReconciler) eventComponent() string {
+	if r.EventComponent == "" {
+		return ControllerName
+	}
+
+	return r.EventComponent
 }
 
 // blank assignment to verify that ReconcilePolicy implements reconcile.Reconciler
@@ -57,24 +161,237 @@ type PolicyReconciler struct {
 	HubRecorder     record.EventRecorder
 	ManagedRecorder record.EventRecorder
 	Scheme          *runtime.Scheme
+	// EventComponent is the source component of the events recorded by the recorders, which identifies the
+	// events of this controller. It defaults to ControllerName.
+	EventComponent string
+	// ClusterName is the name of the managed cluster reported to the sinks. It defaults to the namespace of
+	// the policy.
+	ClusterName string
+	// ClusterIdentity is the identity of the managed cluster in the sink payloads, so that they remain
+	// attributable when they are exported off the cluster. It isn't reported if it's empty.
+	ClusterIdentity string
+	// Sinks are the external destinations that compliance transitions are sent to
+	Sinks []sinks.Sink
+	// SinkControls enable, disable, or rate limit each sink by name, including the HubEventsSink and
+	// ManagedEventsSink recorders of the compliance transitions, which are disabled by default
+	SinkControls map[string]sinks.SinkControls
+	// LocalCluster indicates that the managed cluster is the hub itself, or that the ManifestWork status
+	// feedback reports the managed status to the hub. In this case, HubClient is the same as ManagedClient and
+	// the status is not written to the hub a second time.
+	LocalCluster bool
+	// RootPolicyLabels are the labels that identify the root policy of a replicated policy in order of
+	// precedence, such as the ownership label of a higher-level hub in a hub-of-hubs topology followed by the
+	// root policy label. It defaults to the root policy label.
+	RootPolicyLabels []string
+	// HubNamespaceLabel is the label of the managed policy whose value is the namespace of the hub policy that
+	// its status is synced to. If it's empty or the label isn't set, the hub policy is in the same namespace.
+	HubNamespaceLabel string
+	// EventCacheSize is the maximum number of parsed events that are cached, so that the events that were
+	// already parsed aren't parsed again on every reconcile. If it's 0, the cache is disabled.
+	EventCacheSize int
+	// HistoryMinSeverity is the minimum template severity for which compliance history accumulates on the
+	// hub. An empty value keeps the history of all templates.
+	HistoryMinSeverity string
+	// EventLookback is the age after which events that aren't newer than the history on the hub are ignored,
+	// which limits the transitions replayed at startup on clusters with a long event TTL. If it's 0, all
+	// events are considered.
+	EventLookback time.Duration
+	// HistorySummaryEntries is the maximum number of summarized entries that replace the history entries
+	// older than the 10 most recent ones. If it's 0, the older entries are dropped.
+	HistorySummaryEntries int
+	// AllHubEvents records an event on the hub for every hub status update. Otherwise, updates that keep a
+	// policy compliant don't record an event, which reduces the events stored on the hub.
+	AllHubEvents bool
+	// DisableHubEvents doesn't record any events on the hub for status updates, such as when the compliance
+	// history API is used instead.
+	DisableHubEvents bool
+	// HubDryRun validates each hub status write with a server-side dry run first, which logs the status
+	// fields that an incompatible hub Policy CRD schema rejects or prunes, such as during an upgrade
+	HubDryRun bool
+	// KeepHistoryOnHubRecreate keeps the status of the managed policy when the hub policy is deleted and
+	// re-created with the same name, so that its history carries forward to the new hub policy. Otherwise,
+	// the status is reset.
+	KeepHistoryOnHubRecreate bool
+	// HubServerSideApply writes the hub status with server-side apply, so that this controller only owns the
+	// per-cluster status fields of the hub policy and coexists with the other writers of the policy
+	HubServerSideApply bool
+	// HubStatusPatch writes the hub status with a JSON patch of the changes from the hub status that was read,
+	// such as the new history entries, instead of an update of the whole status, which shrinks the requests
+	// for the policies with a long history. HubServerSideApply takes precedence.
+	HubStatusPatch bool
+	// HubFieldManager is the field manager of the hub status writes. If it's empty, the status updates use the
+	// manager from the User-Agent of the hub client and the server-side apply uses StatusFieldManager.
+	HubFieldManager string
+	// HubStatusIntegrity annotates the hub status of each policy template with the hash of the synced status
+	// and the identity of the agent, so that a modification of the hub status between the writes of the agent
+	// can be detected, and is logged when the policy is synced again
+	HubStatusIntegrity bool
+	// StatusSyncHealth annotates the hub status of each policy template with whether the agent was healthy when
+	// it wrote the status
+	StatusSyncHealth bool
+	// StatusHeartbeatInterval is the interval at which the status sync heartbeat in the hub status of each
+	// policy is refreshed, so that the hub can tell when the agent stopped syncing. It's disabled if 0.
+	StatusHeartbeatInterval time.Duration
+	// TemplateStaleThreshold is the age of the latest compliance event of a policy template after which its
+	// status is marked as stale, and the policies are reconciled again at this interval so that the templates
+	// whose events stopped arriving are marked. It's disabled if 0.
+	TemplateStaleThreshold time.Duration
+	// MarkStoppedOnShutdown marks the hub status of every synced policy with the StatusSyncStoppedAnnotation
+	// when the agent shuts down gracefully, so that the hub users can tell that it's no longer live
+	MarkStoppedOnShutdown bool
+	// HubConsistencyInterval is the interval at which the hub status of the synced policies is compared to
+	// their managed status, and the inconsistent policies are synced again. It's disabled if 0.
+	HubConsistencyInterval time.Duration
+	// MaxWatchedPolicies is the maximum number of policies that are synced on every change, so that a runaway
+	// policy generator can't grow the per-policy state of the agent without bounds. The policies over it are
+	// handled by PolicyOverflow. It's unlimited if 0.
+	MaxWatchedPolicies int
+	// PolicyOverflow is the behavior for the policies over MaxWatchedPolicies, which is PolicyOverflowLog to
+	// not sync them, or PolicyOverflowPeriodic to sync them at the PolicyOverflowInterval. It defaults to
+	// PolicyOverflowLog.
+	PolicyOverflow         string
+	PolicyOverflowInterval time.Duration
+	// PolicyExclusions are the patterns of the policies whose status isn't synced, which are synced once they
+	// no longer match after the exclusions change. No policy is excluded if it's nil.
+	PolicyExclusions *tool.PolicyExclusions
+	// UnknownCompliance is the behavior for the events whose message doesn't start with a known compliance
+	// state, which is UnknownComplianceUnknown, UnknownComplianceNonCompliant, or UnknownComplianceDrop. It
+	// defaults to UnknownComplianceUnknown.
+	UnknownCompliance string
+	// LeaderEpoch reads the leader epoch from the leader election lease when this instance becomes the
+	// leader, so that its hub writes are refused once a newer leader wrote the hub status. It's disabled if nil.
+	LeaderEpoch *LeaderEpoch
+	// SpecDriftAudit marks the status of the policy templates whose latest compliance event was generated for
+	// a previous spec of the replicated policy as possibly stale, by comparing the spec hash annotation of the
+	// event with the one of the policy
+	SpecDriftAudit bool
+	// PolicySetMembership annotates the status of the policy templates with the policy sets on the hub that
+	// the root policy belongs to, and aggregates the compliance of each policy set in the
+	// policy_status_sync_policy_set_compliance metric. It requires the permission to list the policy sets in
+	// the namespaces of the root policies on the hub.
+	PolicySetMembership bool
+	// ComplianceScoreWeights are the weights of the template severities in the compliance score of the managed
+	// cluster, keyed by severity and none for the templates without a severity. The default weights are used
+	// if it's nil.
+	ComplianceScoreWeights map[string]float64
+	// HubWriteCoalescingWindow is how long the reconcile of a policy is delayed after a policy template event,
+	// so that the events of its other templates in the window are synced to the hub in a single status write.
+	// It's disabled if 0.
+	HubWriteCoalescingWindow time.Duration
+	// MaxHubPolicyBytes is the maximum size of the JSON of the hub policy, over which the oldest history
+	// entries are removed from its status so that the write fits in the request size limit of the hub. If
+	// it's 0, the status isn't trimmed until the hub rejects it as too large.
+	MaxHubPolicyBytes int
+	// LogBudget is the number of info logs of the reconciles of each policy per minute, after which they are
+	// logged at the debug level. If it's 0, the info logs aren't limited.
+	LogBudget int
+	// HubCapabilities are the policy status capabilities of the hub, which are used to degrade gracefully
+	// when the hub is on a different release. All capabilities are assumed if it's nil.
+	HubCapabilities *HubCapabilities
+	// HubWriteAudit annotates the hub events of the status writes with the pod, version, and reconcile ID of
+	// the agent that wrote the status, and records one for every status write. It is disabled if nil.
+	HubWriteAudit *HubWriteAudit
+	// HubAPIBudget caps the hub API requests of the agent, and the history refreshes are deferred while only
+	// the reserve of the budget for the compliance state changes is left. It is disabled if nil.
+	HubAPIBudget *tool.HubAPIBudget
+	// HistoryReporter sends the compliance history entries added to the hub status to the compliance
+	// history API. It is disabled if nil.
+	HistoryReporter *sinks.ComplianceHistoryReporter
+	// reconciled is set to 1 once the first reconcile request is processed
+	reconciled uint32
+	// started is set to 1 once the startup check passes
+	started uint32
+	// electedAt is the time in Unix nanoseconds when this instance became the leader
+	electedAt int64
+	// tookOver is set to 1 once the first reconcile after becoming the leader succeeds
+	tookOver uint32
+	// epoch is the leader epoch of this instance, which is only set if epochKnown is 1
+	epoch      int64
+	epochKnown uint32
+	// restoreReplayedAt is the time in Unix nanoseconds when all policies were last replayed to a restored hub
+	restoreReplayedAt int64
+	// logBudgets are the info log budgets of the policies when LogBudget is set
+	logBudgets logBudgets
+	// syncFailures counts the consecutive hub sync failures of each policy
+	syncFailures syncFailures
+	// eventCache caches the parsed events when EventCacheSize is set
+	eventCache     *eventCache
+	eventCacheOnce sync.Once
+	// hubPolicyLimit is the size in bytes of the hub policy that the hub rejected as too large, lowered by a
+	// margin, or 0 if the hub never rejected one
+	hubPolicyLimit int64
+	// terminating are the namespaces whose policies aren't synced since the namespace is terminating
+	terminating terminatingNamespaces
+	// multiplexer sends the compliance transitions to the enabled sinks
+	multiplexer     *sinks.Multiplexer
+	multiplexerOnce sync.Once
+	// syncTransactions are the side effects of the reconciles that wait for the status to reach the hub
+	syncTransactions syncTransactions
+	// policyLimit are the policies within MaxWatchedPolicies and the overflow policies when it's set
+	policyLimit policyLimit
+	// excludedPolicies are the reconciled policies that match the PolicyExclusions
+	excludedPolicies excludedPolicies
+	// hubWriteOrder serializes the reconciles of each policy and orders its hub writes by event time
+	hubWriteOrder hubWriteOrder
+	// syncStates caches the hub sync state of the managed policies
+	syncStates syncStates
+	// hubWrites is the queue of the requests to reconcile in priority order
+	hubWrites *hubWriteQueue
+	// policySetMembership caches the policy sets on the hub when PolicySetMembership is set
+	policySetMembership policySetMembership
 }
 
 //+kubebuilder:rbac:groups=policy.open-cluster-management.io,resources=policies,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=policy.open-cluster-management.io,resources=policies/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=policy.open-cluster-management.io,resources=policies/finalizers,verbs=update
 //+kubebuilder:rbac:groups=core,resources=events;namespaces,verbs=get;list;watch;create;update;patch;delete
+// This is required to keep the hub sync state of the managed policies
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;create;patch
 // This is required for the status lease for the addon framework
 //+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list
+// The permissions of the optional features, such as the compliance API, are in deploy/rbac/optional so that
+// they aren't part of the default role
+
+// Reconcile syncs the status of a policy, unless its namespace is terminating. The policies of a namespace
+// whose writes fail because the namespace on the hub or on the managed cluster is terminating aren't synced
+// until terminatingNamespaceRetry passes, and the sync resumes once a retry succeeds. The reconciles of a
+// policy are serialized by its hubWriteOrder.
+func (r *PolicyReconciler) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	release := r.hubWriteOrder.serialize(request.NamespacedName)
+	defer release()
 
-// Reconcile reads that state of the cluster for a Policy object and makes changes based on the state read
-// and what is in the Policy.Spec
+	if wait, ok := r.terminating.wait(request.Namespace, time.Now()); ok {
+		return r.terminatingResult(wait), nil
+	}
+
+	result, err := r.reconcilePolicy(ctx, request)
+	if err != nil && isNamespaceTerminating(err) {
+		r.terminating.quiesce(request.Namespace, err, time.Now())
+
+		return r.terminatingResult(terminatingNamespaceRetry), nil
+	}
+
+	if err == nil {
+		r.terminating.resume(request.Namespace)
+	}
+
+	return result, err
+}
+
+// reconcilePolicy reads that state of the cluster for a Policy object and makes changes based on the state
+// read and what is in the Policy.Spec
 // Note:
 // The Controller will requeue the Request to be processed again if the returned error is non-nil or
 // Result.Requeue is true, otherwise upon completion it will remove the work from the queue.
-func (r *PolicyReconciler) Reconcile(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
-	reqLogger := log.WithValues("Request.Namespace", request.Namespace, "Request.Name", request.Name)
+func (r *PolicyReconciler) reconcilePolicy(ctx context.Context, request reconcile.Request) (reconcile.Result, error) {
+	// the reconcile ID correlates the log lines, API requests, and events of the reconcile
+	reconcileID := newReconcileID()
+	ctx = tool.WithReconcileID(ctx, reconcileID)
+	reqLogger := r.policyLogger(request.NamespacedName).WithValues("ReconcileID", reconcileID)
 	reqLogger.Info("Reconciling Policy...")
 
+	atomic.StoreUint32(&r.reconciled, 1)
+
 	// Fetch the Policy instance
 	instance := &policiesv1.Policy{}
 
@@ -83,6 +400,7 @@ func (r *PolicyReconciler) Reconcile(ctx context.Context, request reconcile.Requ
 		if errors.IsNotFound(err) {
 			// repliated policy on hub was deleted
 			// check if it was deleted by user by checking if it still exists on hub
+			// the labels that route it to another hub namespace are gone, so only the same namespace is checked
 			hubInstance := &policiesv1.Policy{}
 			err = r.HubClient.Get(ctx, request.NamespacedName, hubInstance)
 
@@ -90,6 +408,14 @@ func (r *PolicyReconciler) Reconcile(ctx context.Context, request reconcile.Requ
 				if errors.IsNotFound(err) {
 					// confirmed deleted on hub, doing nothing
 					reqLogger.Info("Policy was deleted, no status to update...")
+					deletePolicyMetrics(request.NamespacedName)
+					r.syncFailures.forget(request.NamespacedName)
+					r.logBudgets.forget(request.NamespacedName)
+					r.syncTransactions.forget(request.NamespacedName)
+					r.forgetPolicy(request.NamespacedName)
+					r.forgetExcludedPolicy(request.NamespacedName)
+					r.hubWriteOrder.forget(request.NamespacedName)
+					r.syncStates.forget(request.NamespacedName)
 
 					return reconcile.Result{}, nil
 				}
@@ -105,9 +431,26 @@ func (r *PolicyReconciler) Reconcile(ctx context.Context, request reconcile.Requ
 		// Error reading the object - requeue the request.
 		return reconcile.Result{}, err
 	}
+
+	if r.excludePolicy(request.NamespacedName) {
+		reqLogger.V(1).Info("Not syncing the policy since it matches a policy exclusion")
+		// an excluded policy frees its place in the MaxWatchedPolicies limit
+		r.forgetPolicy(request.NamespacedName)
+
+		return reconcile.Result{}, nil
+	}
+
+	if !r.admitPolicy(request.NamespacedName) {
+		reqLogger.V(1).Info("Not syncing the policy since it's over the --max-watched-policies limit")
+
+		return reconcile.Result{}, nil
+	}
+
+	trackPolicy(request.NamespacedName)
+
 	// get hub policy
 	hubPlc := &policiesv1.Policy{}
-	err = r.HubClient.Get(ctx, request.NamespacedName, hubPlc)
+	err = r.HubClient.Get(ctx, r.hubPolicyKey(instance), hubPlc)
 
 	if err != nil {
 		// hub policy not found, it has been deleted
@@ -116,6 +459,15 @@ func (r *PolicyReconciler) Reconcile(ctx context.Context, request reconcile.Requ
 			err = r.ManagedClient.Delete(ctx, instance)
 			if err == nil || errors.IsNotFound(err) {
 				// no err or err is not found means local policy has been deleted
+				deletePolicyMetrics(request.NamespacedName)
+				r.syncFailures.forget(request.NamespacedName)
+				r.logBudgets.forget(request.NamespacedName)
+				r.syncTransactions.forget(request.NamespacedName)
+				r.forgetPolicy(request.NamespacedName)
+				r.forgetExcludedPolicy(request.NamespacedName)
+				r.hubWriteOrder.forget(request.NamespacedName)
+				r.syncStates.forget(request.NamespacedName)
+
 				return reconcile.Result{}, nil
 			}
 			// otherwise requeue to delete again
@@ -135,6 +487,33 @@ func (r *PolicyReconciler) Reconcile(ctx context.Context, request reconcile.Requ
 		return reconcile.Result{}, r.ManagedClient.Update(ctx, instance)
 	}
 
+	state, err := r.syncState(ctx, instance)
+	if err != nil {
+		reqLogger.Error(err, "Failed to get the hub sync state of the policy on managed")
+
+		return reconcile.Result{}, err
+	}
+
+	if !r.LocalCluster {
+		// the hub policy is validated before its UID is recorded, so that a managed policy that points to another
+		// hub policy isn't taken for a re-created hub policy
+		if err := r.validateParentPolicy(instance, hubPlc, state); err != nil {
+			reqLogger.Error(err, "Refusing to update the policy status on hub")
+
+			recordEvent(ctx, r.ManagedRecorder, instance, "Warning", "PolicyStatusSync",
+				fmt.Sprintf("Policy %s status was not synced to the hub: %s", instance.GetName(), err))
+			r.recordHubSync(ctx, instance, state, err)
+
+			return reconcile.Result{}, nil
+		}
+
+		if err := r.syncHubUID(ctx, instance, hubPlc, &state); err != nil {
+			reqLogger.Error(err, "Failed to record the hub policy UID on managed")
+
+			return reconcile.Result{}, err
+		}
+	}
+
 	// plc matches hub plc, then get events
 	eventList := &corev1.EventList{}
 	err = r.ManagedClient.List(ctx, eventList, client.InNamespace(instance.GetNamespace()))
@@ -145,56 +524,91 @@ func (r *PolicyReconciler) Reconcile(ctx context.Context, request reconcile.Requ
 	}
 	// filter events to current policy instance and build map
 	eventForPolicyMap := make(map[string]*[]policiesv1.ComplianceHistory)
-	// panic if regexp invalid
-	rgx := regexp.MustCompile(`(?i)^policy:\s*([A-Za-z0-9.-]+)\s*\/([A-Za-z0-9.-]+)`)
-	for _, event := range eventList.Items {
-		// sample event.Reason -- reason: 'policy: calamari/policy-grc-rbactest-example'
-		reason := rgx.FindString(event.Reason)
-		if event.InvolvedObject.Kind == policiesv1.Kind && event.InvolvedObject.APIVersion == policiesv1APIVersion &&
-			event.InvolvedObject.Name == instance.GetName() && reason != "" {
-			templateName := rgx.FindStringSubmatch(event.Reason)[2]
-			eventHistory := policiesv1.ComplianceHistory{
-				LastTimestamp: event.LastTimestamp,
-				Message:       strings.TrimSpace(strings.TrimPrefix(event.Message, "(combined from similar events):")),
-				EventName:     event.GetName(),
-			}
+	// reporters maps the idempotency key of the history entries to the controller that reported their event
+	reporters := map[string]string{}
+	// specHashes maps the idempotency key of the history entries to the spec hash of their event
+	specHashes := map[string]string{}
+	hubNewest := newestHubHistory(hubPlc.Status)
+	now := time.Now()
+	resetAt := state.historyReset
+
+	events := r.parsedEvents()
+
+	for i := range eventList.Items {
+		parsed := events.parse(&eventList.Items[i])
+		if parsed.policyName != instance.GetName() {
+			continue
+		}
 
-			if eventForPolicyMap[templateName] == nil {
-				eventForPolicyMap[templateName] = &[]policiesv1.ComplianceHistory{}
-			}
+		templateName := parsed.templateName
+		if r.isOutsideLookback(&eventList.Items[i], hubNewest[templateName], now) {
+			continue
+		}
 
-			templateEvents := append(*eventForPolicyMap[templateName], eventHistory)
-			eventForPolicyMap[templateName] = &templateEvents
+		// the events before the status was reset are for the hub policy that was deleted
+		if parsed.history.LastTimestamp.Time.Before(resetAt) {
+			continue
 		}
+
+		if parsed.unknownCompliance && r.UnknownCompliance == UnknownComplianceDrop {
+			reqLogger.V(1).Info("Ignoring the event with an unknown compliance state", "PolicyTemplate",
+				templateName, "EventName", parsed.history.EventName)
+
+			continue
+		}
+
+		if parsed.reporter != "" {
+			reporters[historyKey(instance.GetUID(), templateName, parsed.history)] = parsed.reporter
+		}
+
+		r.recordSpecHash(specHashes, instance.GetUID(), parsed)
+
+		if eventForPolicyMap[templateName] == nil {
+			eventForPolicyMap[templateName] = &[]policiesv1.ComplianceHistory{}
+		}
+
+		templateEvents := append(*eventForPolicyMap[templateName], parsed.history)
+		eventForPolicyMap[templateName] = &templateEvents
 	}
 
 	oldStatus := *instance.Status.DeepCopy()
 	newStatus := policiesv1.PolicyStatus{}
+	templateSeverities := map[string]string{}
+	templateKinds := map[string]string{}
+	// the policy sets are removed from the status if the membership is disabled
+	policySets, policySetsKnown := []string(nil), true
+
+	if r.PolicySetMembership {
+		policySets, policySetsKnown = r.policySets(ctx, instance)
+	}
 
-	for _, policyT := range instance.Spec.PolicyTemplates {
+	// the templates are matched to the existing statuses in the order of the spec
+	matcher := newTemplateMatcher(instance.Status.Details)
+
+	for index, policyT := range instance.Spec.PolicyTemplates {
 		object, _, err := unstructured.UnstructuredJSONScheme.Decode(policyT.ObjectDefinition.Raw, nil, nil)
-		if err != nil {
-			// failed to decode PolicyTemplate, skipping it
-			break
-		}
+		if err != nil || object.(metav1.Object).GetName() == "" {
+			// the template isn't applied, but it still has a status so that the hub has one for each template
+			reason := "the policy template has no name"
+			if err != nil {
+				reason = "the policy template can't be parsed: " + err.Error()
+			}
 
-		tName := object.(metav1.Object).GetName()
-		existingDpt := &policiesv1.DetailsPerTemplate{}
-		// retrieve existingDpt from instance.status.details field
-		found := false
+			existing := matcher.match(skippedTemplateName(index))
+			newStatus.Details = append(newStatus.Details, skippedTemplateDetails(existing, index, reason))
 
-		for _, dpt := range instance.Status.Details {
-			if dpt.TemplateMeta.Name == tName {
-				// found existing status for policyTemplate
-				// retrieve it
-				existingDpt = dpt
-				found = true
+			reqLogger.Info("Skipped the policy template", "index", index, "reason", reason)
 
-				break
-			}
+			continue
 		}
+
+		tName := object.(metav1.Object).GetName()
+		templateSeverities[tName] = templateSeverity(object)
+		templateKinds[tName] = object.GetObjectKind().GroupVersionKind().Kind
+		// retrieve existingDpt from instance.status.details field
+		existingDpt := matcher.match(tName)
 		// no dpt from status field, initialize it
-		if !found {
+		if existingDpt == nil {
 			existingDpt = &policiesv1.DetailsPerTemplate{
 				TemplateMeta: metav1.ObjectMeta{
 					Name: tName,
@@ -207,23 +621,8 @@ func (r *PolicyReconciler) Reconcile(ctx context.Context, request reconcile.Requ
 		if eventForPolicyMap[tName] != nil {
 			history = *eventForPolicyMap[tName]
 		}
-
-		for _, ech := range existingDpt.History {
-			exists := false
-
-			for _, ch := range history {
-				if ch.LastTimestamp.Time.Equal(ech.LastTimestamp.Time) && ch.EventName == ech.EventName {
-					// do nothing
-					exists = true
-
-					break
-				}
-			}
-			// doesn't exists, append to history
-			if !exists {
-				history = append(history, ech)
-			}
-		}
+		// append the existing history that doesn't match an event
+		history = mergeHistory(instance.GetUID(), tName, history, existingDpt.History)
 		// sort by lasttimestamp
 		sort.Slice(history, func(i, j int) bool {
 			return history[i].LastTimestamp.Time.After(history[j].LastTimestamp.Time)
@@ -245,23 +644,29 @@ func (r *PolicyReconciler) Reconcile(ctx context.Context, request reconcile.Requ
 				}
 			}
 		}
-		// shorten it to first 10
-		size := 10
-		if len(newHistory) < 10 {
-			size = len(newHistory)
-		}
-
-		existingDpt.History = newHistory[0:size]
+		// shorten it to the first 10 and summarize the older entries if enabled
+		existingDpt.History = compactHistory(newHistory, r.HistorySummaryEntries)
 
 		// set compliancy at different level
+		var statusErr error
 		if len(existingDpt.History) > 0 {
-			if strings.HasPrefix(strings.ToLower(strings.TrimSpace(
-				strings.TrimPrefix(existingDpt.History[0].Message, "(combined from similar events):"))), "compliant") {
-				existingDpt.ComplianceState = policiesv1.Compliant
-			} else {
-				existingDpt.ComplianceState = policiesv1.NonCompliant
-			}
+			existingDpt.ComplianceState, statusErr = r.templateCompliance(existingDpt.History[0])
+		}
+
+		setDependencyState(existingDpt)
+		setReportedBy(instance.GetUID(), existingDpt, reporters)
+		r.setSpecDrift(instance, existingDpt, specHashes)
+		setTemplateError(existingDpt, hubTemplatesError(instance, object.(metav1.Object)))
+		setTemplateSkipped(existingDpt, r.templateSkipReason(object))
+		r.setTemplateStale(existingDpt)
+		// a template whose status can't be derived doesn't fail the status of the other templates
+		setTemplateStatusError(existingDpt, statusErr)
+
+		if statusErr != nil {
+			reqLogger.Info("Failed to derive the status of the policy template", "PolicyTemplate", tName,
+				"error", statusErr.Error())
 		}
+		setPolicySets(existingDpt, policySets, policySetsKnown)
 
 		// append existingDpt to status
 		newStatus.Details = append(newStatus.Details, existingDpt)
@@ -288,9 +693,27 @@ func (r *PolicyReconciler) Reconcile(ctx context.Context, request reconcile.Requ
 		instance.Status.ComplianceState = policiesv1.Compliant
 	}
 
+	recordTemplateCompliance(request.NamespacedName, instance.Status.Details, templateKinds)
+	r.recordComplianceScore(instance, templateSeverities)
+
+	if policySetsKnown {
+		rootNamespace, _ := rootPolicyName(instance)
+		recordPolicySetCompliance(request.NamespacedName, rootNamespace, policySets, instance.Status.ComplianceState)
+	}
+
+	instance.Status = withObservedGeneration(instance.Status, instance)
+	// on the hub cluster, the managed status is the hub status
+	if r.LocalCluster {
+		instance.Status = r.hubStatus(instance.Status, templateSeverities)
+	}
+
+	// the managed events and the sink notifications are committed once the status reaches the hub
+	tx := r.syncTransactions.begin(request.NamespacedName)
+	defer tx.end()
+
 	// all done, update status on managed and hub
 	// instance.Status.Details = nil
-	if !equality.Semantic.DeepEqual(newStatus.Details, oldStatus.Details) ||
+	if !equality.Semantic.DeepEqual(instance.Status.Details, oldStatus.Details) ||
 		instance.Status.ComplianceState != oldStatus.ComplianceState {
 		reqLogger.Info("status mismatch on managed, update it... ")
 
@@ -302,33 +725,126 @@ func (r *PolicyReconciler) Reconcile(ctx context.Context, request reconcile.Requ
 			return reconcile.Result{}, err
 		}
 
-		r.ManagedRecorder.Event(instance, "Normal", "PolicyStatusSync",
-			fmt.Sprintf("Policy %s status was updated in cluster namespace %s", instance.GetName(),
-				instance.GetNamespace()))
+		tx.updateStatus(oldStatus.ComplianceState, instance.Status.ComplianceState)
+
+		// on the hub cluster, the managed status is the hub status
+		if r.LocalCluster {
+			added := newHistoryEntries(instance.GetUID(), oldStatus, instance.Status)
+			observePropagationLatency(added, time.Now())
+			r.recordComplianceEvents(instance, added, reporters)
+		}
 	} else {
 		reqLogger.Info("status match on managed, nothing to update... ")
 	}
 
-	if os.Getenv("ON_MULTICLUSTERHUB") != "true" && !equality.Semantic.DeepEqual(hubPlc.Status, instance.Status) {
+	newHubStatus := r.HubCapabilities.adaptStatus(
+		r.withLeaderEpoch(r.withSyncHealth(r.hubStatus(instance.Status, templateSeverities), time.Now())),
+	)
+
+	if !r.LocalCluster {
+		if isStaleStatus(hubPlc.Status, instance) {
+			reqLogger.Info("The hub status is from a newer generation of the policy, not updating the hub",
+				"Generation", instance.GetGeneration())
+
+			return reconcile.Result{}, nil
+		}
+
+		if r.isFencedStatus(hubPlc.Status) {
+			reqLogger.Info("The hub status was written by a newer leader, not updating the hub")
+
+			return reconcile.Result{}, nil
+		}
+
+		if r.isOutOfOrderStatus(instance, newHubStatus) {
+			reqLogger.Info("The status has older compliance events than the last status written to the hub, " +
+				"retrying once the managed policy is up to date")
+
+			return reconcile.Result{RequeueAfter: outOfOrderRetryDelay}, nil
+		}
+
+		r.checkStatusIntegrity(ctx, instance, hubPlc.Status)
+	}
+
+	truncated := false
+	if !r.LocalCluster {
+		newHubStatus, truncated = fitHubStatus(hubPlc, newHubStatus, r.maxHubPolicyBytes())
+		newHubStatus = r.withStatusIntegrity(newHubStatus)
+	}
+
+	if !r.LocalCluster && !equality.Semantic.DeepEqual(hubPlc.Status, newHubStatus) {
 		reqLogger.Info("status not in sync, update the hub... ")
 
-		hubPlc.Status = instance.Status
-		err = r.HubClient.Status().Update(ctx, hubPlc)
+		previousHubStatus := hubPlc.Status
+		lastSync := state.lastHubSync
+		restored := isRestoredStatus(instance.GetUID(), previousHubStatus, newHubStatus, lastSync)
+		hubPlc.Status = newHubStatus
+
+		err = r.dryRunHubStatus(ctx, hubPlc, previousHubStatus)
+		if err == nil {
+			err = r.updateHubStatus(ctx, hubPlc, previousHubStatus, false)
+		}
+
+		if err != nil && errors.IsInvalid(err) && r.HubCapabilities.redetectAfterRejection() {
+			// the hub Policy CRD changed since the capabilities were detected, such as during a rollback
+			reqLogger.Info("The hub rejected the status, retrying without the status fields that it doesn't support")
+
+			hubPlc.Status = r.withStatusIntegrity(r.HubCapabilities.adaptStatus(hubPlc.Status))
+			err = r.updateHubStatus(ctx, hubPlc, previousHubStatus, false)
+		}
+
+		if err != nil && isTooLargeError(err) {
+			// the hub limit is lower than MaxHubPolicyBytes, so the history is trimmed further
+			r.lowerHubPolicyLimit(policySize(hubPlc, hubPlc.Status))
+			hubPlc.Status, truncated = fitHubStatus(hubPlc, hubPlc.Status, r.maxHubPolicyBytes())
+			hubPlc.Status = r.withStatusIntegrity(hubPlc.Status)
+
+			err = r.updateHubStatus(ctx, hubPlc, previousHubStatus, false)
+		}
 
 		if err != nil {
 			reqLogger.Error(err, "Failed to get update policy status on hub")
+			r.recordHubSync(ctx, instance, state, err)
 
 			return reconcile.Result{}, err
 		}
 
-		r.HubRecorder.Event(instance, "Normal", "PolicyStatusSync",
-			fmt.Sprintf("Policy %s status was updated in cluster namespace %s", hubPlc.GetName(),
-				hubPlc.GetNamespace()))
+		r.recordHubSync(ctx, instance, state, nil)
+		r.recordHubWriteOrder(instance, hubPlc.Status)
+		r.commitSyncTransaction(ctx, tx, instance, templateSeverities)
+
+		if truncated {
+			r.recordTruncation(ctx, instance)
+		}
+
+		added := newHistoryEntries(instance.GetUID(), previousHubStatus, hubPlc.Status)
+		if restored {
+			// the entries up to the last sync were already written to the hub before the restore
+			added = historySince(added, lastSync)
+
+			r.replayHubStatus(ctx)
+		}
+
+		observePropagationLatency(added, time.Now())
+		r.recordComplianceEvents(instance, added, reporters)
+
+		if !r.DisableHubEvents && (r.AllHubEvents || r.HubWriteAudit != nil ||
+			previousHubStatus.ComplianceState != policiesv1.Compliant ||
+			hubPlc.Status.ComplianceState != policiesv1.Compliant) {
+			r.recordHubWrite(ctx, instance, hubPlc)
+		}
 	} else {
 		reqLogger.Info("status match on hub, nothing to update... ")
+
+		if !r.LocalCluster {
+			r.recordHubWriteOrder(instance, hubPlc.Status)
+		}
+
+		r.commitSyncTransaction(ctx, tx, instance, templateSeverities)
 	}
 
 	reqLogger.Info("Reconciling complete...")
 
-	return reconcile.Result{}, nil
+	r.observeTakeover()
+
+	return r.heartbeatResult(), nil
 }
@@ -0,0 +1,42 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/stolostron/governance-policy-status-sync/tool"
+)
+
+// ReconcileIDAnnotation is set on the events recorded by a reconcile to the ID of the reconcile, which is
+// also in its log lines and the debug logs of its API requests, so that an event can be traced back to the
+// logs of the reconcile that recorded it
+const ReconcileIDAnnotation = "policy.open-cluster-management.io/reconcile-id"
+
+// newReconcileID returns a unique ID of a reconcile
+func newReconcileID() string {
+	return string(uuid.NewUUID())
+}
+
+// reconcileAnnotations returns the annotations of the events recorded by the reconcile of the context, which
+// are empty if the context has no reconcile ID
+func reconcileAnnotations(ctx context.Context) map[string]string {
+	annotations := map[string]string{}
+
+	if reconcileID := tool.ReconcileID(ctx); reconcileID != "" {
+		annotations[ReconcileIDAnnotation] = reconcileID
+	}
+
+	return annotations
+}
+
+// recordEvent records an event annotated with the reconcile ID of the context
+func recordEvent(
+	ctx context.Context, recorder record.EventRecorder, object runtime.Object, eventtype, reason, message string,
+) {
+	recorder.AnnotatedEventf(object, reconcileAnnotations(ctx), eventtype, reason, "%s", message)
+}
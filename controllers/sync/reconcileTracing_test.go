@@ -0,0 +1,30 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stolostron/governance-policy-status-sync/tool"
+)
+
+func TestRecordEventReconcileID(t *testing.T) {
+	t.Parallel()
+
+	recorder, client := hubEventRecorder(t)
+
+	ctx := tool.WithReconcileID(context.TODO(), "reconcile-1")
+	recordEvent(ctx, recorder, testPolicy(), "Normal", "Traced", "The policy was reconciled")
+	recordEvent(context.TODO(), recorder, testPolicy(), "Normal", "Untraced", "The policy is synced")
+
+	event := hubEvent(t, client, "cluster1", "Traced")
+	if reconcileID := event.GetAnnotations()[ReconcileIDAnnotation]; reconcileID != "reconcile-1" {
+		t.Fatalf("expected the reconcile ID annotation of the reconcile, got %q", reconcileID)
+	}
+
+	event = hubEvent(t, client, "cluster1", "Untraced")
+	if _, ok := event.GetAnnotations()[ReconcileIDAnnotation]; ok {
+		t.Fatalf("expected no reconcile ID annotation outside of a reconcile, got %v", event.GetAnnotations())
+	}
+}
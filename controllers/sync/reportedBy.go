@@ -0,0 +1,61 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"fmt"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/stolostron/governance-policy-status-sync/policystatus"
+)
+
+// ReportedByAnnotation is set on the template metadata in the status of a policy template, and contains the
+// controller that reported the latest compliance history entry, along with the related object if the event
+// has one. The history entries themselves have no field for it in the Policy CRD.
+const ReportedByAnnotation = policystatus.ReportedByAnnotation
+
+// eventReporter returns the controller that reported the event, such as config-policy-controller, along
+// with the related object if it's set, or an empty string if it's unknown
+func eventReporter(event *corev1.Event) string {
+	reporter := event.ReportingController
+	if reporter == "" {
+		reporter = event.Source.Component
+	}
+
+	if reporter == "" || event.Related == nil {
+		return reporter
+	}
+
+	related := event.Related.Name
+	if event.Related.Namespace != "" {
+		related = event.Related.Namespace + "/" + related
+	}
+
+	return fmt.Sprintf("%s (%s %s)", reporter, event.Related.Kind, related)
+}
+
+// setReportedBy sets the reported by annotation of the template status from the reporter of its latest
+// history entry. The reporters are keyed by the idempotency key of the history entries built from the
+// current events. If the reporter of the latest entry is unknown, such as after its event expired, the
+// annotation is left as is since it was set when the entry was added.
+func setReportedBy(policyUID types.UID, dpt *policiesv1.DetailsPerTemplate, reporters map[string]string) {
+	if len(dpt.History) == 0 {
+		return
+	}
+
+	reporter := reporters[historyKey(policyUID, dpt.TemplateMeta.Name, dpt.History[0])]
+	if reporter == "" {
+		return
+	}
+
+	annotations := dpt.TemplateMeta.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+
+	annotations[ReportedByAnnotation] = reporter
+	dpt.TemplateMeta.SetAnnotations(annotations)
+}
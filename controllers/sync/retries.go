@@ -0,0 +1,101 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// RetriesPath is the path of the debug endpoint of the health probe server with the policies waiting for
+// their retry
+const RetriesPath = "/debug/retries"
+
+// retryState is a request of a hub write queue waiting for its retry after a failed reconcile
+type retryState struct {
+	priority hubWritePriority
+	retryAt  time.Time
+	// attempts is the number of consecutive retries, which sets the backoff
+	attempts int
+	// lastError is the error of the last failed reconcile, which is empty if the reconcile requested a requeue
+	// without an error
+	lastError string
+}
+
+// policyRetry is a policy waiting for its retry, which is reported by the retries debug endpoint
+type policyRetry struct {
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	Priority  string    `json:"priority"`
+	RetryAt   time.Time `json:"retryAt"`
+	RetryIn   string    `json:"retryIn"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"lastError,omitempty"`
+}
+
+// RetriesHandler serves the policies of all the controllers that are waiting for their retry after a failed
+// reconcile as JSON, with the time of their next retry and their last error, so that what is stuck is visible
+// without inferring it from the logs. The namespace and name query parameters filter the policies.
+func RetriesHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		retries := policyRetries(req.URL.Query().Get("namespace"), req.URL.Query().Get("name"))
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if err := json.NewEncoder(w).Encode(retries); err != nil {
+			log.Error(err, "Failed to write the retries")
+		}
+	})
+}
+
+// Retries returns the policies waiting for their retry for the support bundle
+func Retries(_ context.Context) (interface{}, error) {
+	return policyRetries("", ""), nil
+}
+
+// policyRetries returns the policies of all the controllers that are waiting for their retry, soonest retry
+// first, which are filtered by the namespace and name if they're not empty
+func policyRetries(namespace string, name string) []policyRetry {
+	hubWriteQueues.lock.Lock()
+	queues := append([]*hubWriteQueue(nil), hubWriteQueues.queues...)
+	hubWriteQueues.lock.Unlock()
+
+	retries := []policyRetry{}
+	now := time.Now()
+
+	for _, queue := range queues {
+		queue.lock.Lock()
+
+		for request, state := range queue.retrying {
+			if (namespace != "" && request.Namespace != namespace) || (name != "" && request.Name != name) {
+				continue
+			}
+
+			retryIn := state.retryAt.Sub(now)
+			if retryIn < 0 {
+				retryIn = 0
+			}
+
+			retries = append(retries, policyRetry{
+				Namespace: request.Namespace,
+				Name:      request.Name,
+				Priority:  state.priority.String(),
+				RetryAt:   state.retryAt.UTC(),
+				RetryIn:   retryIn.Round(time.Second).String(),
+				Attempts:  state.attempts,
+				LastError: state.lastError,
+			})
+		}
+
+		queue.lock.Unlock()
+	}
+
+	sort.Slice(retries, func(i, j int) bool {
+		return retries[i].RetryAt.Before(retries[j].RetryAt)
+	})
+
+	return retries
+}
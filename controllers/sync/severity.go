@@ -0,0 +1,68 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"fmt"
+	"strings"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// severityRanks orders the severities of policy templates. A template without a known severity has a rank
+// of 0, which is below all of them.
+var severityRanks = map[string]int{
+	"low":      1,
+	"medium":   2,
+	"high":     3,
+	"critical": 4,
+}
+
+// ValidateSeverity returns an error if the severity is not empty or one of the policy template severities
+func ValidateSeverity(severity string) error {
+	if severity == "" {
+		return nil
+	}
+
+	if _, ok := severityRanks[strings.ToLower(severity)]; !ok {
+		return fmt.Errorf("invalid severity %q, it must be one of low, medium, high, or critical", severity)
+	}
+
+	return nil
+}
+
+// templateSeverity returns the spec.severity of a decoded policy template or an empty string if it's not set
+func templateSeverity(object runtime.Object) string {
+	unstructuredObj, ok := object.(*unstructured.Unstructured)
+	if !ok {
+		return ""
+	}
+
+	severity, _, _ := unstructured.NestedString(unstructuredObj.Object, "spec", "severity")
+
+	return strings.ToLower(severity)
+}
+
+// hubStatus returns the status to write to the hub. When HistoryMinSeverity is set, the templates with a
+// lower severity only keep their latest history entry, so their compliance state is still synced but their
+// history doesn't accumulate on the hub. The severities are keyed by template name.
+func (r *PolicyReconciler) hubStatus(
+	status policiesv1.PolicyStatus, severities map[string]string,
+) policiesv1.PolicyStatus {
+	if r.HistoryMinSeverity == "" {
+		return status
+	}
+
+	minRank := severityRanks[strings.ToLower(r.HistoryMinSeverity)]
+	filtered := *status.DeepCopy()
+
+	for _, dpt := range filtered.Details {
+		if severityRanks[severities[dpt.TemplateMeta.Name]] < minRank && len(dpt.History) > 1 {
+			dpt.History = dpt.History[:1]
+		}
+	}
+
+	return filtered
+}
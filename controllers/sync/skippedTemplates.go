@@ -0,0 +1,83 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"strconv"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// TemplateSkippedAnnotation is set on the template metadata in the status of a policy template that isn't
+// applied on the managed cluster, such as when it can't be parsed or its kind isn't installed, and contains
+// the reason. The compliance state of a skipped template is left empty, which is unknown, so that the hub still
+// has a status for each template of the spec.
+const TemplateSkippedAnnotation = "policy.open-cluster-management.io/template-skipped"
+
+// skippedTemplateName returns the name of the status of a policy template that can't be parsed or has no
+// name, which is from the position of the template in the spec
+func skippedTemplateName(index int) string {
+	return "policy-template-" + strconv.Itoa(index)
+}
+
+// templateSkipReason returns why the policy template isn't applied on the managed cluster, or an empty
+// string if it is. The kind of the template isn't installed if the managed cluster has no mapping for it,
+// and other failures of the mapping aren't considered as skipping the template.
+func (r *PolicyReconciler) templateSkipReason(object runtime.Object) string {
+	gvk := object.GetObjectKind().GroupVersionKind()
+	if gvk.Kind == "" {
+		return "the policy template has no kind"
+	}
+
+	_, err := r.ManagedClient.RESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version)
+	if meta.IsNoMatchError(err) {
+		return "the kind " + gvk.Kind + " of the policy template isn't installed on the managed cluster"
+	}
+
+	return ""
+}
+
+// skippedTemplateDetails returns the status of the policy template at the index of the spec that can't be
+// parsed or has no name, which keeps the history of its existing status if any
+func skippedTemplateDetails(
+	existing *policiesv1.DetailsPerTemplate, index int, reason string,
+) *policiesv1.DetailsPerTemplate {
+	dpt := &policiesv1.DetailsPerTemplate{
+		TemplateMeta: metav1.ObjectMeta{Name: skippedTemplateName(index)},
+		History:      []policiesv1.ComplianceHistory{},
+	}
+
+	if existing != nil {
+		dpt.History = existing.History
+	}
+
+	setTemplateSkipped(dpt, reason)
+
+	return dpt
+}
+
+// setTemplateSkipped sets the skipped annotation of the template status from the reason, and removes it if
+// the reason is empty. The compliance state of a skipped template is cleared since the template isn't
+// evaluated on the managed cluster.
+func setTemplateSkipped(dpt *policiesv1.DetailsPerTemplate, reason string) {
+	annotations := dpt.TemplateMeta.GetAnnotations()
+	delete(annotations, TemplateSkippedAnnotation)
+
+	if reason != "" {
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+
+		dpt.ComplianceState = ""
+		annotations[TemplateSkippedAnnotation] = reason
+	}
+
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+
+	dpt.TemplateMeta.SetAnnotations(annotations)
+}
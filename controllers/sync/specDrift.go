@@ -0,0 +1,85 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// SpecHashAnnotation is set on the replicated policy to the hash of its spec, and copied by the template
+	// controllers onto the compliance events that they record, so that an event can be matched to the
+	// version of the policy that it was generated for
+	SpecHashAnnotation = "policy.open-cluster-management.io/spec-hash"
+	// PossiblyStaleAnnotation is set on the template metadata in the status of a policy template whose latest
+	// compliance event was generated for a previous spec of the policy
+	PossiblyStaleAnnotation = "policy.open-cluster-management.io/possibly-stale"
+	// possiblyStaleSpecChanged is the value of the PossiblyStaleAnnotation when the spec changed
+	possiblyStaleSpecChanged = "spec changed"
+)
+
+// setSpecDrift marks the template status as possibly stale if the spec hash of its latest history entry
+// differs from the current spec hash of the replicated policy. The spec hashes are keyed by the idempotency
+// key of the history entries built from the current events. Since the compliance of a possibly stale
+// template is for an old version of the policy, its compliance state is left empty until the template
+// controller reports the current version. The annotation is left as is if the spec hash of the latest entry
+// or of the policy is unknown, such as after its event expired, and removed if the audit is disabled.
+func (r *PolicyReconciler) setSpecDrift(
+	instance *policiesv1.Policy, dpt *policiesv1.DetailsPerTemplate, specHashes map[string]string,
+) {
+	if !r.SpecDriftAudit {
+		if annotations := dpt.TemplateMeta.GetAnnotations(); annotations[PossiblyStaleAnnotation] != "" {
+			delete(annotations, PossiblyStaleAnnotation)
+
+			if len(annotations) == 0 {
+				annotations = nil
+			}
+
+			dpt.TemplateMeta.SetAnnotations(annotations)
+		}
+
+		return
+	}
+
+	specHash := instance.GetAnnotations()[SpecHashAnnotation]
+	if specHash == "" || len(dpt.History) == 0 {
+		return
+	}
+
+	eventHash := specHashes[historyKey(instance.GetUID(), dpt.TemplateMeta.Name, dpt.History[0])]
+	if eventHash == "" {
+		if dpt.TemplateMeta.GetAnnotations()[PossiblyStaleAnnotation] != "" {
+			dpt.ComplianceState = ""
+		}
+
+		return
+	}
+
+	annotations := dpt.TemplateMeta.GetAnnotations()
+
+	if eventHash == specHash {
+		delete(annotations, PossiblyStaleAnnotation)
+	} else {
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+
+		dpt.ComplianceState = ""
+		annotations[PossiblyStaleAnnotation] = possiblyStaleSpecChanged
+	}
+
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+
+	dpt.TemplateMeta.SetAnnotations(annotations)
+}
+
+// recordSpecHash adds the spec hash of the parsed event to the spec hashes, keyed by the idempotency key of
+// its history entry, if the spec drift audit is enabled and the event has one
+func (r *PolicyReconciler) recordSpecHash(specHashes map[string]string, policyUID types.UID, parsed parsedEvent) {
+	if r.SpecDriftAudit && parsed.specHash != "" {
+		specHashes[historyKey(policyUID, parsed.templateName, parsed.history)] = parsed.specHash
+	}
+}
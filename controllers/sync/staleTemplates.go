@@ -0,0 +1,41 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"time"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+)
+
+// TemplateStaleSinceAnnotation is set on the template metadata in the status of a policy template whose
+// compliance events stopped arriving for longer than TemplateStaleThreshold, such as when its template
+// controller is down, and contains the time of its latest event. The compliance state is the last one
+// reported by the template controller.
+const TemplateStaleSinceAnnotation = "policy.open-cluster-management.io/template-stale-since"
+
+// setTemplateStale sets the stale annotation of the template status if its latest history entry is older
+// than TemplateStaleThreshold, and removes it otherwise. The templates without history and the skipped
+// templates aren't stale since no event is expected for them.
+func (r *PolicyReconciler) setTemplateStale(dpt *policiesv1.DetailsPerTemplate) {
+	annotations := dpt.TemplateMeta.GetAnnotations()
+	delete(annotations, TemplateStaleSinceAnnotation)
+
+	if r.TemplateStaleThreshold > 0 && len(dpt.History) != 0 && annotations[TemplateSkippedAnnotation] == "" {
+		latest := dpt.History[0].LastTimestamp.Time
+
+		if !latest.IsZero() && time.Since(latest) > r.TemplateStaleThreshold {
+			if annotations == nil {
+				annotations = map[string]string{}
+			}
+
+			annotations[TemplateStaleSinceAnnotation] = latest.UTC().Format(time.RFC3339)
+		}
+	}
+
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+
+	dpt.TemplateMeta.SetAnnotations(annotations)
+}
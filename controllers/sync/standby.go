@@ -0,0 +1,102 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// CacheWarmer pre-syncs the managed cluster cache and the optional hub cache before this instance becomes
+// the leader, so that a standby instance can produce correct writes shortly after taking over. It is a
+// manager.Runnable that doesn't need leader election.
+type CacheWarmer struct {
+	ManagedCache cache.Cache
+	// HubCache is started by the CacheWarmer if it is set
+	HubCache cache.Cache
+}
+
+var (
+	_ manager.Runnable               = &CacheWarmer{}
+	_ manager.LeaderElectionRunnable = &CacheWarmer{}
+)
+
+// NeedLeaderElection returns false so that the caches are warmed while on standby
+func (w *CacheWarmer) NeedLeaderElection() bool {
+	return false
+}
+
+// Start creates the informers the controller uses and waits for them to sync. If a hub cache is set, it is
+// started and runs until the context is done.
+func (w *CacheWarmer) Start(ctx context.Context) error {
+	start := time.Now()
+
+	for _, obj := range []client.Object{&policiesv1.Policy{}, &corev1.Event{}} {
+		if _, err := w.ManagedCache.GetInformer(ctx, obj); err != nil {
+			return err
+		}
+	}
+
+	if w.HubCache != nil {
+		if _, err := w.HubCache.GetInformer(ctx, &policiesv1.Policy{}); err != nil {
+			return err
+		}
+
+		go func() {
+			if err := w.HubCache.Start(ctx); err != nil {
+				log.Error(err, "The hub cache stopped unexpectedly")
+			}
+		}()
+
+		if !w.HubCache.WaitForCacheSync(ctx) {
+			return errors.New("failed to sync the hub cache")
+		}
+	}
+
+	if !w.ManagedCache.WaitForCacheSync(ctx) {
+		return errors.New("failed to sync the managed cluster cache")
+	}
+
+	log.Info("The caches are warm", "duration", time.Since(start).String())
+
+	<-ctx.Done()
+
+	return nil
+}
+
+// leaderTakeover records when this instance became the leader so that the takeover duration can be measured
+// on the first successful reconcile.
+type leaderTakeover struct {
+	reconciler *PolicyReconciler
+}
+
+// Start is only called once this instance is elected
+func (l *leaderTakeover) Start(ctx context.Context) error {
+	atomic.StoreInt64(&l.reconciler.electedAt, time.Now().UnixNano())
+	l.reconciler.setLeaderEpoch(ctx)
+
+	<-ctx.Done()
+
+	return nil
+}
+
+// observeTakeover sets the leader takeover metric on the first successful reconcile after being elected
+func (r *PolicyReconciler) observeTakeover() {
+	electedAt := atomic.LoadInt64(&r.electedAt)
+	if electedAt == 0 || !atomic.CompareAndSwapUint32(&r.tookOver, 0, 1) {
+		return
+	}
+
+	duration := time.Since(time.Unix(0, electedAt))
+	leaderTakeoverSeconds.Set(duration.Seconds())
+
+	log.Info("Completed the first reconcile as the leader", "takeoverDuration", duration.String())
+}
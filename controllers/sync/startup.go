@@ -0,0 +1,79 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+)
+
+// startupCheckTimeout bounds how long a single startup probe waits on the cache
+const startupCheckTimeout = time.Second
+
+// StartupCheck returns a health check that passes once the manager's cache is synced and, if this instance
+// is the leader, the first batch of reconcile requests has been queued. A standby instance only needs a
+// synced cache. Once the check passes, it always passes.
+func (r *PolicyReconciler) StartupCheck(mgr ctrl.Manager) healthz.Checker {
+	return func(req *http.Request) error {
+		if atomic.LoadUint32(&r.started) == 1 {
+			return nil
+		}
+
+		ctx, cancel := context.WithTimeout(req.Context(), startupCheckTimeout)
+		defer cancel()
+
+		if !mgr.GetCache().WaitForCacheSync(ctx) {
+			return errors.New("the cache is not synced yet")
+		}
+
+		select {
+		case <-mgr.Elected():
+		default:
+			atomic.StoreUint32(&r.started, 1)
+
+			return nil
+		}
+
+		if atomic.LoadUint32(&r.reconciled) == 0 {
+			// without any policies, there is nothing to queue
+			policyList := &policiesv1.PolicyList{}
+			if err := r.ManagedClient.List(ctx, policyList); err != nil {
+				return fmt.Errorf("failed to list policies: %w", err)
+			}
+
+			if len(policyList.Items) != 0 {
+				return errors.New("the first reconcile batch has not been queued yet")
+			}
+		}
+
+		atomic.StoreUint32(&r.started, 1)
+
+		return nil
+	}
+}
+
+// QueueStallCheck returns a health check that fails when a hub write queue has made no progress for longer
+// than the timeout while requests were waiting, such as when a worker is wedged, so that the controller is
+// restarted instead of silently no longer syncing.
+func QueueStallCheck(timeout time.Duration) healthz.Checker {
+	return func(_ *http.Request) error {
+		hubWriteQueues.lock.Lock()
+		defer hubWriteQueues.lock.Unlock()
+
+		for _, queue := range hubWriteQueues.queues {
+			if stalled := queue.stalledFor(); stalled > timeout {
+				return fmt.Errorf("the policy queue has made no progress for %s", stalled.Round(time.Second))
+			}
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// StatusHashAnnotation is set on the template metadata in the hub status of each policy template to the
+	// sha256: prefixed hash of the synced hub status, so that a modification of the status between the writes
+	// of the agent can be detected
+	StatusHashAnnotation = "policy.open-cluster-management.io/status-hash"
+	// StatusWriterAnnotation is set on the template metadata in the hub status of each policy template to the
+	// identity of the agent that computed the StatusHashAnnotation, which is the field manager of its hub writes
+	StatusWriterAnnotation = "policy.open-cluster-management.io/status-writer"
+)
+
+var modifiedHubStatusesTotal = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "policy_status_sync_modified_hub_statuses_total",
+		Help: "The number of hub policy statuses whose content no longer matched their status hash when they " +
+			"were synced again, such as after a manual or out-of-band modification",
+	},
+)
+
+func init() {
+	metrics.Registry.MustRegister(modifiedHubStatusesTotal)
+}
+
+// statusHash returns the sha256: prefixed hash of the identity of the writer followed by a newline and the
+// JSON of the overall compliance and the details of the status, without the StatusHashAnnotation and
+// StatusWriterAnnotation of the templates. These are the status fields that the agent syncs to the hub.
+func statusHash(status policiesv1.PolicyStatus, writer string) (string, error) {
+	details := make([]*policiesv1.DetailsPerTemplate, 0, len(status.Details))
+
+	for _, dpt := range status.Details {
+		if dpt == nil {
+			details = append(details, nil)
+
+			continue
+		}
+
+		annotations := dpt.TemplateMeta.GetAnnotations()
+		_, hasHash := annotations[StatusHashAnnotation]
+		_, hasWriter := annotations[StatusWriterAnnotation]
+
+		if !hasHash && !hasWriter {
+			details = append(details, dpt)
+
+			continue
+		}
+
+		stripped := dpt.DeepCopy()
+		strippedAnnotations := map[string]string{}
+
+		for key, value := range annotations {
+			if key != StatusHashAnnotation && key != StatusWriterAnnotation {
+				strippedAnnotations[key] = value
+			}
+		}
+
+		stripped.TemplateMeta.SetAnnotations(strippedAnnotations)
+		details = append(details, stripped)
+	}
+
+	content, err := json.Marshal(policiesv1.PolicyStatus{ComplianceState: status.ComplianceState, Details: details})
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(append([]byte(writer+"\n"), content...))
+
+	return "sha256:" + hex.EncodeToString(hash[:]), nil
+}
+
+// withStatusIntegrity returns the hub status with the StatusHashAnnotation and StatusWriterAnnotation on each
+// template when HubStatusIntegrity is set. It must be called on the final status of the write, such as after
+// it's trimmed to fit in the hub policy size limit.
+func (r *PolicyReconciler) withStatusIntegrity(status policiesv1.PolicyStatus) policiesv1.PolicyStatus {
+	if !r.HubStatusIntegrity || len(status.Details) == 0 {
+		return status
+	}
+
+	writer := r.applyFieldManager()
+
+	hash, err := statusHash(status, writer)
+	if err != nil {
+		log.Error(err, "Failed to hash the hub status, it isn't annotated with its status hash")
+
+		return status
+	}
+
+	withHash := *status.DeepCopy()
+
+	for _, dpt := range withHash.Details {
+		if dpt == nil {
+			continue
+		}
+
+		annotations := dpt.TemplateMeta.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+
+		annotations[StatusHashAnnotation] = hash
+		annotations[StatusWriterAnnotation] = writer
+		dpt.TemplateMeta.SetAnnotations(annotations)
+	}
+
+	return withHash
+}
+
+// checkStatusIntegrity compares the hub status to its status hash when HubStatusIntegrity is set, and records
+// a warning event on the managed policy if the status was modified since it was written. The hub status
+// without a status hash, such as one written before HubStatusIntegrity was set, isn't checked.
+func (r *PolicyReconciler) checkStatusIntegrity(
+	ctx context.Context, instance *policiesv1.Policy, hubStatus policiesv1.PolicyStatus,
+) {
+	if !r.HubStatusIntegrity {
+		return
+	}
+
+	expected, writer := "", ""
+
+	for _, dpt := range hubStatus.Details {
+		if dpt == nil {
+			continue
+		}
+
+		if hash := dpt.TemplateMeta.GetAnnotations()[StatusHashAnnotation]; hash != "" {
+			expected = hash
+			writer = dpt.TemplateMeta.GetAnnotations()[StatusWriterAnnotation]
+
+			break
+		}
+	}
+
+	if expected == "" {
+		return
+	}
+
+	hash, err := statusHash(hubStatus, writer)
+	if err != nil || hash == expected {
+		return
+	}
+
+	modifiedHubStatusesTotal.Inc()
+
+	log.Info("The hub status was modified since it was written by the agent, syncing it again",
+		"Namespace", instance.GetNamespace(), "Name", instance.GetName(), "Writer", writer)
+
+	recordEvent(ctx, r.ManagedRecorder, instance, "Warning", "PolicyStatusModified",
+		fmt.Sprintf("Policy %s status on the hub was modified since it was written by %s, it's synced again",
+			instance.GetName(), writer))
+}
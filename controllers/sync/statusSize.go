@@ -0,0 +1,112 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// tooLargeMargin is the fraction of the size of a hub policy rejected as too large that the hub status is
+// trimmed to
+const tooLargeMargin = 0.75
+
+// policySize returns the size in bytes of the JSON of the hub policy with the status
+func policySize(hubPlc *policiesv1.Policy, status policiesv1.PolicyStatus) int {
+	sized := *hubPlc
+	sized.Status = status
+
+	data, err := json.Marshal(&sized)
+	if err != nil {
+		return 0
+	}
+
+	return len(data)
+}
+
+// fitHubStatus returns the hub status with the oldest history entries removed until the hub policy is at
+// most maxBytes, starting with the templates that have the longest history. The most recent entry of each
+// template is kept. It returns true if the history was trimmed.
+func fitHubStatus(hubPlc *policiesv1.Policy, status policiesv1.PolicyStatus, maxBytes int) (
+	policiesv1.PolicyStatus, bool,
+) {
+	if maxBytes <= 0 || policySize(hubPlc, status) <= maxBytes {
+		return status, false
+	}
+
+	fitted := *status.DeepCopy()
+
+	for policySize(hubPlc, fitted) > maxBytes {
+		var longest *policiesv1.DetailsPerTemplate
+
+		for _, dpt := range fitted.Details {
+			if len(dpt.History) > 1 && (longest == nil || len(dpt.History) > len(longest.History)) {
+				longest = dpt
+			}
+		}
+
+		if longest == nil {
+			log.Info("The hub policy is still too large with a single history entry per template",
+				"Namespace", hubPlc.GetNamespace(), "Name", hubPlc.GetName(), "MaxBytes", maxBytes)
+
+			break
+		}
+
+		longest.History = longest.History[:len(longest.History)-1]
+	}
+
+	return fitted, true
+}
+
+// isTooLargeError returns true if the hub rejected the write since the policy is too large, either as the
+// request size limit of the API server or as the object size limit of etcd
+func isTooLargeError(err error) bool {
+	return k8serrors.IsRequestEntityTooLargeError(err) || strings.Contains(err.Error(), "request is too large")
+}
+
+// maxHubPolicyBytes returns the maximum size of the hub policy, which is the lower of MaxHubPolicyBytes and
+// the limit learned from the hub rejecting a policy as too large, or 0 if there is none
+func (r *PolicyReconciler) maxHubPolicyBytes() int {
+	limit := int(atomic.LoadInt64(&r.hubPolicyLimit))
+
+	if limit == 0 || (r.MaxHubPolicyBytes > 0 && r.MaxHubPolicyBytes < limit) {
+		return r.MaxHubPolicyBytes
+	}
+
+	return limit
+}
+
+// lowerHubPolicyLimit lowers the maximum size of the hub policy below the size that the hub rejected
+func (r *PolicyReconciler) lowerHubPolicyLimit(rejectedSize int) {
+	limit := int64(float64(rejectedSize) * tooLargeMargin)
+
+	for {
+		current := atomic.LoadInt64(&r.hubPolicyLimit)
+		if current != 0 && current <= limit {
+			return
+		}
+
+		if atomic.CompareAndSwapInt64(&r.hubPolicyLimit, current, limit) {
+			log.Info("The hub rejected a policy as too large, lowering the maximum hub policy size",
+				"RejectedBytes", rejectedSize, "MaxBytes", limit)
+
+			return
+		}
+	}
+}
+
+// recordTruncation counts and records an event on the managed policy when the history of its hub status was
+// trimmed to fit in the hub size limit
+func (r *PolicyReconciler) recordTruncation(ctx context.Context, instance *policiesv1.Policy) {
+	statusTruncationsTotal.Inc()
+
+	recordEvent(ctx, r.ManagedRecorder, instance, "Warning", "PolicyStatusTruncated",
+		fmt.Sprintf("Policy %s status history was trimmed on the hub to fit in the hub policy size limit of %d "+
+			"bytes", instance.GetName(), r.maxHubPolicyBytes()))
+}
@@ -0,0 +1,133 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"errors"
+	"testing"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// historyLengths returns the history length of each template of the status
+func historyLengths(status policiesv1.PolicyStatus) []int {
+	lengths := []int{}
+	for _, dpt := range status.Details {
+		lengths = append(lengths, len(dpt.History))
+	}
+
+	return lengths
+}
+
+func TestFitHubStatusAtTheLimit(t *testing.T) {
+	t.Parallel()
+
+	hubPlc := testPolicy()
+	status := testStatus(policiesv1.NonCompliant, nil, "template0", "template1")
+	status.Details[0].History = testHistory(0, 1, 2)
+	status.Details[1].History = testHistory(0, 1, 2, 3, 4)
+	size := policySize(hubPlc, status)
+
+	fitted, trimmed := fitHubStatus(hubPlc, status, size)
+	if trimmed || len(fitted.Details[1].History) != 5 {
+		t.Fatal("expected a hub policy of exactly the limit to not be trimmed")
+	}
+
+	fitted, trimmed = fitHubStatus(hubPlc, status, 0)
+	if trimmed || len(fitted.Details[1].History) != 5 {
+		t.Fatal("expected the status to not be trimmed without a limit")
+	}
+
+	// one byte over the limit removes the oldest entry of the longest history
+	fitted, trimmed = fitHubStatus(hubPlc, status, size-1)
+	if lengths := historyLengths(fitted); !trimmed || lengths[0] != 3 || lengths[1] != 4 {
+		t.Fatalf("expected the oldest entry of the longest history to be removed, got the lengths %v", lengths)
+	}
+
+	if last := fitted.Details[1].History[3].EventName; last != "policy.event3" {
+		t.Fatalf("expected the oldest entry policy.event4 to be removed, the oldest entry is %s", last)
+	}
+
+	if policySize(hubPlc, fitted) > size-1 {
+		t.Fatalf("expected the trimmed hub policy to fit in %d bytes, got %d", size-1, policySize(hubPlc, fitted))
+	}
+
+	// the status of the managed policy isn't modified
+	if lengths := historyLengths(status); lengths[0] != 3 || lengths[1] != 5 {
+		t.Fatalf("expected the original status to keep its history, got the lengths %v", lengths)
+	}
+}
+
+func TestFitHubStatusKeepsTheNewestEntries(t *testing.T) {
+	t.Parallel()
+
+	hubPlc := testPolicy()
+
+	status := testStatus(policiesv1.NonCompliant, nil, "template0", "template1", "template2")
+	status.Details[0].History = testHistory(0, 1, 2)
+	status.Details[1].History = testHistory(0, 1, 2, 3, 4)
+	status.Details[2].History = testHistory(0)
+
+	// a limit that can't be met keeps the newest entry of each template
+	fitted, trimmed := fitHubStatus(hubPlc, status, 1)
+	if lengths := historyLengths(fitted); !trimmed || lengths[0] != 1 || lengths[1] != 1 || lengths[2] != 1 {
+		t.Fatalf("expected a single history entry per template, got the lengths %v", lengths)
+	}
+
+	for _, dpt := range fitted.Details {
+		if dpt.History[0].EventName != "policy.event0" {
+			t.Fatalf("expected the newest entry of %s to be kept, got %s", dpt.TemplateMeta.Name,
+				dpt.History[0].EventName)
+		}
+	}
+}
+
+func TestHubPolicyLimit(t *testing.T) {
+	t.Parallel()
+
+	reconciler := &PolicyReconciler{MaxHubPolicyBytes: 1000}
+
+	if limit := reconciler.maxHubPolicyBytes(); limit != 1000 {
+		t.Fatalf("expected the configured limit 1000, got %d", limit)
+	}
+
+	// a rejection above the configured limit doesn't raise it
+	reconciler.lowerHubPolicyLimit(2000)
+
+	if limit := reconciler.maxHubPolicyBytes(); limit != 1000 {
+		t.Fatalf("expected the configured limit 1000 to be kept, got %d", limit)
+	}
+
+	reconciler.lowerHubPolicyLimit(800)
+
+	if limit := reconciler.maxHubPolicyBytes(); limit != 600 {
+		t.Fatalf("expected the limit to be lowered to 600 below the rejected size, got %d", limit)
+	}
+
+	// a later rejection of a larger policy doesn't raise the learned limit
+	reconciler.lowerHubPolicyLimit(900)
+
+	if limit := reconciler.maxHubPolicyBytes(); limit != 600 {
+		t.Fatalf("expected the learned limit 600 to be kept, got %d", limit)
+	}
+}
+
+func TestIsTooLargeError(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		err      error
+		expected bool
+	}{
+		"request entity too large": {k8serrors.NewRequestEntityTooLargeError("limit is 3145728"), true},
+		"etcd limit":               {errors.New("etcdserver: request is too large"), true},
+		"other":                    {k8serrors.NewBadRequest("invalid"), false},
+	}
+
+	for name, test := range tests {
+		if tooLarge := isTooLargeError(test.err); tooLarge != test.expected {
+			t.Fatalf("%s: expected the error to be too large: %v, got %v", name, test.expected, tooLarge)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"context"
+	"time"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// stoppedMarkerTimeout bounds the time spent marking the hub statuses as stopped, which must be shorter than
+// the graceful shutdown timeout of the manager
+const stoppedMarkerTimeout = 20 * time.Second
+
+// stoppedMarker marks the hub status of every synced policy as stopped when the agent shuts down gracefully,
+// such as when the managed cluster is detached or the addon is disabled, so that the hub users can tell that
+// the status is no longer live instead of it silently freezing at its last values. The
+// StatusSyncStoppedAnnotation is set on each template of the hub status, and is removed by the next status
+// write of an agent. It only runs on the leader.
+type stoppedMarker struct {
+	reconciler *PolicyReconciler
+}
+
+// NeedLeaderElection is true since only the leader writes the hub status
+func (m *stoppedMarker) NeedLeaderElection() bool {
+	return true
+}
+
+// Start waits for the context to be done, and then marks the hub statuses as stopped
+func (m *stoppedMarker) Start(ctx context.Context) error {
+	<-ctx.Done()
+
+	markCtx, cancel := context.WithTimeout(context.Background(), stoppedMarkerTimeout)
+	defer cancel()
+
+	m.reconciler.markStopped(markCtx, time.Now())
+
+	return nil
+}
+
+// markStopped sets the StatusSyncStoppedAnnotation to the time on each template of the hub status of the
+// synced policies. The policies whose hub status was written by a newer leader aren't marked.
+func (r *PolicyReconciler) markStopped(ctx context.Context, stoppedAt time.Time) {
+	policies := &policiesv1.PolicyList{}
+
+	if err := r.ManagedClient.List(ctx, policies); err != nil {
+		log.Error(err, "Failed to list the policies to mark their hub status as stopped")
+
+		return
+	}
+
+	marked := 0
+
+	for i := range policies.Items {
+		instance := &policies.Items[i]
+		key := types.NamespacedName{Namespace: instance.GetNamespace(), Name: instance.GetName()}
+
+		if r.PolicyExclusions.Excluded(key.Namespace, key.Name) {
+			continue
+		}
+
+		if ctx.Err() != nil {
+			log.Info("Stopped marking the hub statuses as stopped before the shutdown timeout",
+				"marked", marked, "policies", len(policies.Items))
+
+			return
+		}
+
+		if err := r.markPolicyStopped(ctx, key, instance, stoppedAt); err != nil {
+			log.V(1).Info("Failed to mark the hub status as stopped", "Namespace", key.Namespace,
+				"Name", key.Name, "error", err.Error())
+
+			continue
+		}
+
+		marked++
+	}
+
+	log.Info("Marked the hub statuses as stopped", "marked", marked, "policies", len(policies.Items))
+}
+
+// markPolicyStopped sets the StatusSyncStoppedAnnotation on each template of the hub status of the policy,
+// after the reconciles of the policy in progress are done
+func (r *PolicyReconciler) markPolicyStopped(
+	ctx context.Context, key types.NamespacedName, instance *policiesv1.Policy, stoppedAt time.Time,
+) error {
+	release := r.hubWriteOrder.serialize(key)
+	defer release()
+
+	hubPlc := &policiesv1.Policy{}
+
+	if err := r.HubClient.Get(ctx, r.hubPolicyKey(instance), hubPlc); err != nil {
+		return err
+	}
+
+	if len(hubPlc.Status.Details) == 0 || r.isFencedStatus(hubPlc.Status) {
+		return nil
+	}
+
+	previous := *hubPlc.Status.DeepCopy()
+
+	for _, dpt := range hubPlc.Status.Details {
+		if dpt == nil {
+			continue
+		}
+
+		annotations := dpt.TemplateMeta.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+
+		annotations[StatusSyncStoppedAnnotation] = stoppedAt.UTC().Format(time.RFC3339)
+		dpt.TemplateMeta.SetAnnotations(annotations)
+	}
+
+	hubPlc.Status = r.withStatusIntegrity(hubPlc.Status)
+
+	return r.updateHubStatus(ctx, hubPlc, previous, false)
+}
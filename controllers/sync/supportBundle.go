@@ -0,0 +1,131 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+
+	"github.com/stolostron/governance-policy-status-sync/tool"
+)
+
+// queueState is the state of a hub write queue in the support bundle
+type queueState struct {
+	// Queued are the numbers of the queued requests of each priority
+	Queued map[string]int `json:"queued"`
+	// Processing are the requests being reconciled and how long they've been reconciled for
+	Processing map[string]string `json:"processing"`
+	// Pending is the number of the requests waiting in the controller queue to be dispatched
+	Pending int `json:"pending"`
+	// Coalescing is the number of the requests held until the end of their coalescing window
+	Coalescing int    `json:"coalescing"`
+	StalledFor string `json:"stalledFor"`
+}
+
+// policySyncStatus is the sync status of a policy in the support bundle
+type policySyncStatus struct {
+	Cluster         string                     `json:"cluster"`
+	ClusterIdentity string                     `json:"clusterIdentity,omitempty"`
+	Namespace       string                     `json:"namespace"`
+	Name            string                     `json:"name"`
+	Compliant       policiesv1.ComplianceState `json:"compliant,omitempty"`
+	Templates       int                        `json:"templates"`
+	// Annotations are the policy.open-cluster-management.io annotations of the policy, which include the hub
+	// sync annotations
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// QueueState returns the state of the hub write queues of all the controllers for the support bundle
+func QueueState(_ context.Context) (interface{}, error) {
+	hubWriteQueues.lock.Lock()
+	queues := append([]*hubWriteQueue(nil), hubWriteQueues.queues...)
+	hubWriteQueues.lock.Unlock()
+
+	states := make([]queueState, 0, len(queues))
+
+	for _, queue := range queues {
+		stalledFor := queue.stalledFor()
+
+		queue.lock.Lock()
+
+		state := queueState{
+			Queued:     map[string]int{},
+			Processing: map[string]string{},
+			Pending:    len(queue.pending),
+			Coalescing: len(queue.coalescing),
+			StalledFor: stalledFor.Round(time.Second).String(),
+		}
+
+		for _, priority := range queue.queued {
+			state.Queued[priority.String()]++
+		}
+
+		for request, started := range queue.processing {
+			state.Processing[request.String()] = time.Since(started).Round(time.Millisecond).String()
+		}
+
+		queue.lock.Unlock()
+
+		states = append(states, state)
+	}
+
+	return states, nil
+}
+
+// SyncErrors returns the recent sync errors of each policy for the support bundle
+func SyncErrors(_ context.Context) (interface{}, error) {
+	return syncErrors("", ""), nil
+}
+
+// PolicySyncStatuses returns a support bundle file with the sync status of each replicated policy of the
+// controllers
+func PolicySyncStatuses(reconcilers ...*PolicyReconciler) tool.SupportBundleFile {
+	return func(ctx context.Context) (interface{}, error) {
+		statuses := []policySyncStatus{}
+
+		for _, r := range reconcilers {
+			policies := &policiesv1.PolicyList{}
+			if err := r.ManagedClient.List(ctx, policies); err != nil {
+				return nil, err
+			}
+
+			for i := range policies.Items {
+				statuses = append(statuses, r.policySyncStatus(&policies.Items[i]))
+			}
+		}
+
+		return statuses, nil
+	}
+}
+
+// policySyncStatus returns the sync status of the replicated policy
+func (r *PolicyReconciler) policySyncStatus(instance *policiesv1.Policy) policySyncStatus {
+	status := policySyncStatus{
+		Cluster:         r.ClusterName,
+		ClusterIdentity: r.ClusterIdentity,
+		Namespace:       instance.GetNamespace(),
+		Name:            instance.GetName(),
+		Compliant:       instance.Status.ComplianceState,
+		Templates:       len(instance.Spec.PolicyTemplates),
+	}
+
+	if status.Cluster == "" {
+		status.Cluster = instance.GetNamespace()
+	}
+
+	// the other annotations, such as the last applied configuration, may contain the spec
+	for key, value := range instance.GetAnnotations() {
+		if strings.HasPrefix(key, policiesv1.SchemeGroupVersion.Group+"/") {
+			if status.Annotations == nil {
+				status.Annotations = map[string]string{}
+			}
+
+			status.Annotations[key] = value
+		}
+	}
+
+	return status
+}
@@ -0,0 +1,110 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"context"
+	"fmt"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// maxSyncAttempts is the number of times a single policy is reconciled during SyncAll when the reconcile
+// fails or only updates the managed policy to match the hub.
+const maxSyncAttempts = 3
+
+// SyncAll performs a single reconcile pass over every policy in the given namespaces on the managed
+// cluster. It does not depend on the manager's cache, so the ManagedClient should be a direct client.
+// All policies are attempted even if some fail, and the number of failed policies is returned in the
+// error.
+func (r *PolicyReconciler) SyncAll(ctx context.Context, namespaces []string) error {
+	failed := 0
+	total := 0
+
+	for _, ns := range namespaces {
+		policyList := &policiesv1.PolicyList{}
+
+		err := r.ManagedClient.List(ctx, policyList, client.InNamespace(ns))
+		if err != nil {
+			log.Error(err, "Failed to list policies on managed", "Namespace", ns)
+
+			return err
+		}
+
+		for _, plc := range policyList.Items {
+			total++
+
+			request := reconcile.Request{NamespacedName: types.NamespacedName{
+				Namespace: plc.GetNamespace(),
+				Name:      plc.GetName(),
+			}}
+
+			if err := r.syncOnce(ctx, request); err != nil {
+				log.Error(err, "Failed to sync policy", "Namespace", plc.GetNamespace(), "Name", plc.GetName())
+
+				failed++
+			}
+		}
+	}
+
+	log.Info("Finished syncing all policies", "Total", total, "Failed", failed)
+
+	if failed > 0 {
+		return fmt.Errorf("failed to sync %d of %d policies", failed, total)
+	}
+
+	return nil
+}
+
+// Resync queues an immediate sync of the policy with the priority of a compliance state change, and returns
+// false if the controller isn't set up
+func (r *PolicyReconciler) Resync(policy types.NamespacedName) bool {
+	if r.hubWrites == nil {
+		return false
+	}
+
+	r.hubWrites.add(reconcile.Request{NamespacedName: policy}, priorityStateChange)
+
+	return true
+}
+
+// syncOnce reconciles a single request, retrying it immediately when the reconcile fails, and reconciling it
+// again when the reconcile updated the managed policy to match the hub, since its status isn't synced then. A
+// result that requeues the request later, such as for the status heartbeat, is a success since the status
+// was synced.
+func (r *PolicyReconciler) syncOnce(ctx context.Context, request reconcile.Request) error {
+	var err error
+
+	for attempt := 1; attempt <= maxSyncAttempts; attempt++ {
+		before := r.managedSpecVersion(ctx, request)
+
+		if _, err = r.Reconcile(ctx, request); err != nil {
+			continue
+		}
+
+		if after := r.managedSpecVersion(ctx, request); after == before {
+			return nil
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return fmt.Errorf("policy %s was still updated to match the hub after %d attempts", request.NamespacedName,
+		maxSyncAttempts)
+}
+
+// managedSpecVersion returns the generation and the annotations of the managed policy, which change when the
+// managed policy is updated to match the hub, or an empty string if it can't be read
+func (r *PolicyReconciler) managedSpecVersion(ctx context.Context, request reconcile.Request) string {
+	instance := &policiesv1.Policy{}
+	if err := r.ManagedClient.Get(ctx, request.NamespacedName, instance); err != nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%d %v", instance.GetGeneration(), instance.GetAnnotations())
+}
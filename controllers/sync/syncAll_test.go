@@ -0,0 +1,73 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestSyncAll(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		heartbeat time.Duration
+		specSync  bool
+	}{
+		"synced spec":               {},
+		"outdated spec":             {specSync: true},
+		"heartbeat requeue":         {heartbeat: time.Minute},
+		"outdated spec and requeue": {heartbeat: time.Minute, specSync: true},
+	}
+
+	for name, test := range tests {
+		hubPlc := testPolicy()
+		hubPlc.SetUID("hub-uid")
+		hubPlc.SetAnnotations(map[string]string{"policy.open-cluster-management.io/standards": "NIST SP 800-53"})
+		hubPlc.Spec.Disabled = true
+
+		managedPlc := testPolicy()
+		managedPlc.SetUID("managed-uid")
+
+		if !test.specSync {
+			managedPlc.SetAnnotations(hubPlc.GetAnnotations())
+			managedPlc.Spec = hubPlc.Spec
+		}
+
+		reconciler := &PolicyReconciler{
+			ManagedClient:           fakeClient(managedPlc),
+			HubClient:               fakeClient(hubPlc),
+			ManagedRecorder:         record.NewFakeRecorder(10),
+			HubRecorder:             record.NewFakeRecorder(10),
+			StatusHeartbeatInterval: test.heartbeat,
+		}
+
+		if err := reconciler.SyncAll(context.TODO(), []string{"cluster1"}); err != nil {
+			t.Fatalf("%s: expected the policy to be synced, got %v", name, err)
+		}
+
+		key := types.NamespacedName{Namespace: "cluster1", Name: "policies.policy"}
+
+		if err := reconciler.ManagedClient.Get(context.TODO(), key, managedPlc); err != nil {
+			t.Fatal(err)
+		}
+
+		if !managedPlc.Spec.Disabled {
+			t.Fatalf("%s: expected the managed spec to be updated to match the hub, got %v", name, managedPlc.Spec)
+		}
+
+		if err := reconciler.HubClient.Get(context.TODO(), key, hubPlc); err != nil {
+			t.Fatal(err)
+		}
+
+		// the policy has no templates, so its computed status is compliant
+		if hubPlc.Status.ComplianceState != policiesv1.Compliant {
+			t.Fatalf("%s: expected the hub status to be synced, got %v", name, hubPlc.Status)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/stolostron/governance-policy-status-sync/tool"
+)
+
+const (
+	// persistentSyncFailures is the number of consecutive failures after which a sync error is persistent
+	persistentSyncFailures = 3
+	// recentSyncErrors is the number of recent sync errors kept for each policy
+	recentSyncErrors = 5
+)
+
+// syncError is a recent sync error of a policy
+type syncError struct {
+	Time  time.Time       `json:"time"`
+	Class tool.ErrorClass `json:"class"`
+	Error string          `json:"error"`
+}
+
+// syncFailures counts the consecutive hub sync failures of each policy and keeps its recent sync errors
+type syncFailures struct {
+	lock     sync.Mutex
+	failures map[types.NamespacedName]int
+	// recent are the last recentSyncErrors sync errors of each policy, oldest first, which are kept after
+	// a successful sync
+	recent map[types.NamespacedName][]syncError
+}
+
+// record returns the number of consecutive failures including this one, or resets the count if err is nil
+func (s *syncFailures) record(policy types.NamespacedName, err error) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err == nil {
+		delete(s.failures, policy)
+
+		return 0
+	}
+
+	if s.failures == nil {
+		s.failures = map[types.NamespacedName]int{}
+		s.recent = map[types.NamespacedName][]syncError{}
+	}
+
+	s.failures[policy]++
+
+	recent := append(s.recent[policy], syncError{
+		Time: time.Now().UTC(), Class: tool.ClassifyError(err), Error: err.Error(),
+	})
+	if len(recent) > recentSyncErrors {
+		recent = recent[len(recent)-recentSyncErrors:]
+	}
+
+	s.recent[policy] = recent
+
+	return s.failures[policy]
+}
+
+// forget removes the sync failures of a deleted policy
+func (s *syncFailures) forget(policy types.NamespacedName) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.failures, policy)
+	delete(s.recent, policy)
+}
+
+// policySyncErrors are the sync errors of a policy reported by the sync errors debug endpoint
+type policySyncErrors struct {
+	Namespace           string      `json:"namespace"`
+	Name                string      `json:"name"`
+	ConsecutiveFailures int         `json:"consecutiveFailures"`
+	RecentErrors        []syncError `json:"recentErrors"`
+}
+
+// list returns the sync errors of the policies with recent errors, optionally filtered by namespace and name
+func (s *syncFailures) list(namespace string, name string) []policySyncErrors {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	policies := []policySyncErrors{}
+
+	for policy, recent := range s.recent {
+		if (namespace != "" && policy.Namespace != namespace) || (name != "" && policy.Name != name) {
+			continue
+		}
+
+		policies = append(policies, policySyncErrors{
+			Namespace:           policy.Namespace,
+			Name:                policy.Name,
+			ConsecutiveFailures: s.failures[policy],
+			RecentErrors:        append([]syncError{}, recent...),
+		})
+	}
+
+	return policies
+}
+
+// syncFailureRegistry are the sync failures of all the controllers, which are served by SyncErrorsHandler
+var syncFailureRegistry = struct {
+	lock     sync.Mutex
+	failures []*syncFailures
+}{}
+
+// SyncErrorsHandler serves the recent sync errors of each policy as JSON, which answers why the status of
+// a policy isn't updating without searching the logs. The namespace and name query parameters filter the
+// policies.
+func SyncErrorsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		namespace := req.URL.Query().Get("namespace")
+		name := req.URL.Query().Get("name")
+
+		policies := syncErrors(namespace, name)
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if err := json.NewEncoder(w).Encode(policies); err != nil {
+			log.Error(err, "Failed to write the sync errors")
+		}
+	})
+}
+
+// syncErrors returns the recent sync errors of the policies of all the controllers, which are filtered by the
+// namespace and name if they're not empty
+func syncErrors(namespace string, name string) []policySyncErrors {
+	policies := []policySyncErrors{}
+
+	syncFailureRegistry.lock.Lock()
+	defer syncFailureRegistry.lock.Unlock()
+
+	for _, failures := range syncFailureRegistry.failures {
+		policies = append(policies, failures.list(namespace, name)...)
+	}
+
+	return policies
+}
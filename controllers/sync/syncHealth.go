@@ -0,0 +1,94 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"strconv"
+	"time"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/stolostron/governance-policy-status-sync/policystatus"
+	"github.com/stolostron/governance-policy-status-sync/tool"
+)
+
+const (
+	// StatusSyncHealthyAnnotation is set on the template metadata in the hub status of each policy template
+	// when the status sync health is enabled, and is "false" if the agent was degraded when it wrote the status, such as when its requests to the
+	// managed cluster persistently fail with auth errors, in which case the status may be stale
+	StatusSyncHealthyAnnotation = "policy.open-cluster-management.io/status-sync-healthy"
+	// StatusSyncHeartbeatAnnotation is set on the template metadata in the hub status of each policy template
+	// when the status heartbeat is enabled, and is the time of the last heartbeat truncated to the interval.
+	// A heartbeat older than twice the interval means that the agent stopped syncing the status.
+	StatusSyncHeartbeatAnnotation = policystatus.StatusSyncHeartbeatAnnotation
+	// StatusSyncStaleAfterAnnotation is set with the heartbeat to the time after which the status is stale if
+	// the heartbeat isn't renewed, which is twice the heartbeat interval after the heartbeat
+	StatusSyncStaleAfterAnnotation = policystatus.StatusSyncStaleAfterAnnotation
+	// StatusSyncStoppedAnnotation is set on the template metadata in the hub status of each policy template
+	// by the stoppedMarker when the agent stops gracefully, and is removed by the next status write
+	StatusSyncStoppedAnnotation = policystatus.StatusSyncStoppedAnnotation
+)
+
+// syncHealthy returns true if the agent isn't degraded, so that the status it writes is current
+func syncHealthy() bool {
+	return tool.AuthReadyzCheck(nil) == nil
+}
+
+// withSyncHealth returns the hub status with the enabled status sync health annotations on each template. The
+// heartbeat is truncated to StatusHeartbeatInterval so that the status only changes once per interval.
+func (r *PolicyReconciler) withSyncHealth(status policiesv1.PolicyStatus, now time.Time) policiesv1.PolicyStatus {
+	healthy := strconv.FormatBool(syncHealthy())
+	withHealth := *status.DeepCopy()
+
+	for _, dpt := range withHealth.Details {
+		annotations := dpt.TemplateMeta.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+
+		if r.StatusSyncHealth {
+			annotations[StatusSyncHealthyAnnotation] = healthy
+		} else {
+			delete(annotations, StatusSyncHealthyAnnotation)
+		}
+
+		if r.StatusHeartbeatInterval > 0 {
+			heartbeat := now.UTC().Truncate(r.StatusHeartbeatInterval)
+			annotations[StatusSyncHeartbeatAnnotation] = heartbeat.Format(time.RFC3339)
+			annotations[StatusSyncStaleAfterAnnotation] = heartbeat.Add(2 * r.StatusHeartbeatInterval).Format(
+				time.RFC3339,
+			)
+		} else {
+			delete(annotations, StatusSyncHeartbeatAnnotation)
+			delete(annotations, StatusSyncStaleAfterAnnotation)
+		}
+
+		// the status is live again once the agent writes it
+		delete(annotations, StatusSyncStoppedAnnotation)
+
+		dpt.TemplateMeta.SetAnnotations(annotations)
+	}
+
+	return withHealth
+}
+
+// heartbeatResult returns the result of a successful reconcile, which requeues the policy for its next
+// heartbeat when the status heartbeat is enabled and the policy is reconciled by a controller, or sooner for
+// its next stale template check when TemplateStaleThreshold is set
+func (r *PolicyReconciler) heartbeatResult() reconcile.Result {
+	if r.hubWrites == nil || r.LocalCluster {
+		return reconcile.Result{}
+	}
+
+	interval := r.StatusHeartbeatInterval
+	if r.TemplateStaleThreshold > 0 && (interval <= 0 || r.TemplateStaleThreshold < interval) {
+		interval = r.TemplateStaleThreshold
+	}
+
+	if interval <= 0 {
+		return reconcile.Result{}
+	}
+
+	return reconcile.Result{RequeueAfter: interval}
+}
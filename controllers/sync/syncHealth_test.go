@@ -0,0 +1,56 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"testing"
+	"time"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestWithSyncHealth(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 10, 15, 10, 7, 0, 0, time.UTC)
+	status := policiesv1.PolicyStatus{Details: []*policiesv1.DetailsPerTemplate{{
+		TemplateMeta: metav1.ObjectMeta{Name: "template", Annotations: map[string]string{
+			StatusSyncHealthyAnnotation: "false", StatusSyncStoppedAnnotation: "2026-10-15T09:00:00Z",
+		}},
+	}}}
+
+	tests := map[string]struct {
+		reconciler *PolicyReconciler
+		expected   map[string]string
+	}{
+		"disabled": {&PolicyReconciler{}, nil},
+		"health":   {&PolicyReconciler{StatusSyncHealth: true}, map[string]string{StatusSyncHealthyAnnotation: "true"}},
+		"heartbeat": {
+			&PolicyReconciler{StatusHeartbeatInterval: 5 * time.Minute},
+			map[string]string{
+				StatusSyncHeartbeatAnnotation:  "2026-10-15T10:05:00Z",
+				StatusSyncStaleAfterAnnotation: "2026-10-15T10:15:00Z",
+			},
+		},
+	}
+
+	for name, test := range tests {
+		annotations := test.reconciler.withSyncHealth(status, now).Details[0].TemplateMeta.GetAnnotations()
+
+		if len(annotations) != len(test.expected) {
+			t.Fatalf("%s: expected the annotations %v, got %v", name, test.expected, annotations)
+		}
+
+		for key, value := range test.expected {
+			if annotations[key] != value {
+				t.Fatalf("%s: expected the annotations %v, got %v", name, test.expected, annotations)
+			}
+		}
+	}
+
+	// the status isn't modified
+	if len(status.Details[0].TemplateMeta.GetAnnotations()) != 2 {
+		t.Fatalf("expected the status to not be modified, got %v", status.Details[0].TemplateMeta.GetAnnotations())
+	}
+}
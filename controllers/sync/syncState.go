@@ -0,0 +1,271 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/stolostron/governance-policy-status-sync/tool"
+)
+
+const (
+	// SyncStateConfigMapPrefix is the prefix of the name of the ConfigMap that has the hub sync state of a
+	// managed policy, which is followed by the name of the policy. The ConfigMap is in the namespace of the
+	// managed policy and is owned by it. The state isn't kept in the annotations of the managed policy, since
+	// they're synced from the hub policy by the spec sync.
+	SyncStateConfigMapPrefix = "policy-status-sync."
+	// SyncStateHubPolicyKey is the key of the sync state with the namespace/name of the hub policy that the
+	// status is synced to
+	SyncStateHubPolicyKey = "hub-policy"
+	// SyncStateHubUIDKey is the key of the sync state with the UID of the hub policy, so that a hub policy that
+	// is deleted and re-created with the same name is detected
+	SyncStateHubUIDKey = "hub-uid"
+	// SyncStateHistoryResetKey is the key of the sync state with the time the status was reset after the hub
+	// policy was re-created. The events that occurred before are ignored.
+	SyncStateHistoryResetKey = "history-reset"
+	// SyncStateLastHubSyncKey is the key of the sync state with the time of the last successful update of the
+	// status on the hub
+	SyncStateLastHubSyncKey = "last-hub-sync"
+	// SyncStateHubSyncErrorKey is the key of the sync state with the last error while the status persistently
+	// fails to sync to the hub
+	SyncStateHubSyncErrorKey = "hub-sync-error"
+)
+
+// syncState is the hub sync state of a managed policy
+type syncState struct {
+	hubPolicy    string
+	hubUID       string
+	historyReset time.Time
+	lastHubSync  time.Time
+	hubSyncError string
+}
+
+// parseSyncState returns the sync state of the ConfigMap data. The times that can't be parsed are zero.
+func parseSyncState(data map[string]string) syncState {
+	state := syncState{
+		hubPolicy:    data[SyncStateHubPolicyKey],
+		hubUID:       data[SyncStateHubUIDKey],
+		hubSyncError: data[SyncStateHubSyncErrorKey],
+	}
+
+	state.historyReset, _ = time.Parse(time.RFC3339, data[SyncStateHistoryResetKey])
+	state.lastHubSync, _ = time.Parse(time.RFC3339, data[SyncStateLastHubSyncKey])
+
+	return state
+}
+
+// data returns the ConfigMap data of the sync state, without the keys that aren't set
+func (s syncState) data() map[string]string {
+	data := map[string]string{}
+
+	for key, value := range map[string]string{
+		SyncStateHubPolicyKey:    s.hubPolicy,
+		SyncStateHubUIDKey:       s.hubUID,
+		SyncStateHubSyncErrorKey: s.hubSyncError,
+	} {
+		if value != "" {
+			data[key] = value
+		}
+	}
+
+	if !s.historyReset.IsZero() {
+		data[SyncStateHistoryResetKey] = s.historyReset.UTC().Format(time.RFC3339)
+	}
+
+	if !s.lastHubSync.IsZero() {
+		data[SyncStateLastHubSyncKey] = s.lastHubSync.UTC().Format(time.RFC3339)
+	}
+
+	return data
+}
+
+// syncStateName returns the name of the sync state ConfigMap of the managed policy
+func syncStateName(policyName string) string {
+	return SyncStateConfigMapPrefix + policyName
+}
+
+// savedSyncState is the last sync state read or written for a managed policy
+type savedSyncState struct {
+	// policyUID is the UID of the managed policy that owns the state
+	policyUID types.UID
+	// exists is set if the ConfigMap of the state exists
+	exists bool
+	state  syncState
+}
+
+// syncStates caches the sync state of each managed policy, so that its ConfigMap is only read on the first
+// reconcile of the policy and is only written when the state changes
+type syncStates struct {
+	lock   sync.Mutex
+	states map[types.NamespacedName]savedSyncState
+}
+
+// get returns the cached sync state of the managed policy with the UID, or false if it isn't cached
+func (s *syncStates) get(key types.NamespacedName, policyUID types.UID) (savedSyncState, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	saved, ok := s.states[key]
+
+	return saved, ok && saved.policyUID == policyUID
+}
+
+// set caches the sync state of the managed policy
+func (s *syncStates) set(key types.NamespacedName, saved savedSyncState) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.states == nil {
+		s.states = map[types.NamespacedName]savedSyncState{}
+	}
+
+	s.states[key] = saved
+}
+
+// forget removes the sync state of a deleted policy
+func (s *syncStates) forget(key types.NamespacedName) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.states, key)
+}
+
+// syncState returns the hub sync state of the managed policy. The state of a ConfigMap that isn't owned by
+// the managed policy, such as one left by a deleted policy with the same name, is ignored.
+func (r *PolicyReconciler) syncState(ctx context.Context, instance *policiesv1.Policy) (syncState, error) {
+	key := types.NamespacedName{Namespace: instance.GetNamespace(), Name: instance.GetName()}
+
+	if saved, ok := r.syncStates.get(key, instance.GetUID()); ok {
+		return saved.state, nil
+	}
+
+	configMap := &corev1.ConfigMap{}
+
+	err := r.ManagedClient.Get(
+		ctx, types.NamespacedName{Namespace: instance.GetNamespace(), Name: syncStateName(instance.GetName())},
+		configMap,
+	)
+	if err != nil && !errors.IsNotFound(err) {
+		return syncState{}, err
+	}
+
+	saved := savedSyncState{policyUID: instance.GetUID(), exists: err == nil}
+
+	for _, owner := range configMap.GetOwnerReferences() {
+		if owner.UID == instance.GetUID() {
+			saved.state = parseSyncState(configMap.Data)
+		}
+	}
+
+	r.syncStates.set(key, saved)
+
+	return saved.state, nil
+}
+
+// saveSyncState writes the hub sync state of the managed policy to its ConfigMap if it changed
+func (r *PolicyReconciler) saveSyncState(ctx context.Context, instance *policiesv1.Policy, state syncState) error {
+	key := types.NamespacedName{Namespace: instance.GetNamespace(), Name: instance.GetName()}
+
+	saved, ok := r.syncStates.get(key, instance.GetUID())
+	if ok && saved.exists && equality.Semantic.DeepEqual(saved.state.data(), state.data()) {
+		return nil
+	}
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: instance.GetNamespace(),
+			Name:      syncStateName(instance.GetName()),
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: policiesv1.GroupVersion.String(),
+				Kind:       policiesv1.Kind,
+				Name:       instance.GetName(),
+				UID:        instance.GetUID(),
+			}},
+		},
+		Data: state.data(),
+	}
+
+	var err error
+	if !ok || !saved.exists {
+		err = r.ManagedClient.Create(ctx, configMap)
+	}
+
+	if (ok && saved.exists) || errors.IsAlreadyExists(err) {
+		err = r.ManagedClient.Patch(ctx, configMap, syncStatePatch(configMap))
+	}
+
+	if err != nil {
+		return err
+	}
+
+	r.syncStates.set(key, savedSyncState{policyUID: instance.GetUID(), exists: true, state: state})
+
+	return nil
+}
+
+// syncStatePatch returns a merge patch that replaces the owner and the data of the sync state ConfigMap, which
+// removes the keys that are no longer set
+func syncStatePatch(configMap *corev1.ConfigMap) client.Patch {
+	data := map[string]interface{}{
+		SyncStateHubPolicyKey:    nil,
+		SyncStateHubUIDKey:       nil,
+		SyncStateHistoryResetKey: nil,
+		SyncStateLastHubSyncKey:  nil,
+		SyncStateHubSyncErrorKey: nil,
+	}
+
+	for key, value := range configMap.Data {
+		data[key] = value
+	}
+
+	// the patch of known types can't fail to be marshaled
+	patch, _ := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"ownerReferences": configMap.GetOwnerReferences()},
+		"data":     data,
+	})
+
+	return client.RawPatch(types.MergePatchType, patch)
+}
+
+// recordHubSync updates the sync state of the managed policy after a hub sync attempt, so that cluster
+// administrators can tell from its sync state ConfigMap whether its status reaches the hub. A successful sync
+// sets the sync time and clears the error, and a persistent failure sets the error and records a warning
+// event on the managed policy. Failures to write the sync state are only logged.
+func (r *PolicyReconciler) recordHubSync(
+	ctx context.Context, instance *policiesv1.Policy, state syncState, syncErr error,
+) {
+	key := types.NamespacedName{Namespace: instance.GetNamespace(), Name: instance.GetName()}
+	failures := r.syncFailures.record(key, syncErr)
+
+	if syncErr == nil {
+		state.lastHubSync = time.Now()
+		state.hubSyncError = ""
+	} else {
+		if failures < persistentSyncFailures || state.hubSyncError == syncErr.Error() {
+			return
+		}
+
+		state.hubSyncError = syncErr.Error()
+
+		recordEvent(ctx, r.ManagedRecorder, instance, "Warning", "PolicyStatusSyncFailed",
+			fmt.Sprintf("Policy %s status failed to sync to the hub %d times in a row: %v", instance.GetName(),
+				failures, syncErr))
+	}
+
+	if err := r.saveSyncState(ctx, instance, state); err != nil {
+		log.Error(err, "Failed to update the hub sync state of the policy",
+			"Namespace", instance.GetNamespace(), "Name", instance.GetName(), "ReconcileID", tool.ReconcileID(ctx))
+	}
+}
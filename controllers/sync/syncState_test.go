@@ -0,0 +1,172 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// syncStateConfigMap returns the sync state ConfigMap of the policies.policy policy in the cluster1 namespace
+func syncStateConfigMap(t *testing.T, reconciler *PolicyReconciler) *corev1.ConfigMap {
+	t.Helper()
+
+	configMap := &corev1.ConfigMap{}
+
+	err := reconciler.ManagedClient.Get(context.TODO(),
+		types.NamespacedName{Namespace: "cluster1", Name: "policy-status-sync.policies.policy"}, configMap)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return configMap
+}
+
+func TestSyncStateData(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC)
+	state := syncState{
+		hubPolicy: "cluster1/policies.policy", hubUID: "hub-uid", historyReset: now, lastHubSync: now,
+		hubSyncError: "the hub is unreachable",
+	}
+
+	data := state.data()
+	if data[SyncStateLastHubSyncKey] != "2026-10-15T10:00:00Z" {
+		t.Fatalf("expected the last hub sync in RFC 3339, got %v", data)
+	}
+
+	if parsed := parseSyncState(data); !equality.Semantic.DeepEqual(parsed.data(), data) {
+		t.Fatalf("expected the parsed state to have the data %v, got %v", data, parsed.data())
+	}
+
+	if data := (syncState{hubUID: "hub-uid"}).data(); len(data) != 1 {
+		t.Fatalf("expected only the keys that are set, got %v", data)
+	}
+}
+
+func TestSaveSyncState(t *testing.T) {
+	t.Parallel()
+
+	instance := &policiesv1.Policy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "cluster1", Name: "policies.policy", UID: "managed-uid"},
+	}
+	reconciler := &PolicyReconciler{ManagedClient: fakeClient(instance)}
+
+	state, err := reconciler.syncState(context.TODO(), instance)
+	if err != nil || state != (syncState{}) {
+		t.Fatalf("expected an empty state without a ConfigMap, got %v and %v", state, err)
+	}
+
+	state.hubUID = "hub-uid"
+	state.hubSyncError = "the hub is unreachable"
+
+	if err := reconciler.saveSyncState(context.TODO(), instance, state); err != nil {
+		t.Fatal(err)
+	}
+
+	configMap := syncStateConfigMap(t, reconciler)
+	if owners := configMap.GetOwnerReferences(); len(owners) != 1 || owners[0].UID != "managed-uid" {
+		t.Fatalf("expected the ConfigMap to be owned by the managed policy, got %v", owners)
+	}
+
+	// the keys that are no longer set are removed
+	state.hubSyncError = ""
+
+	if err := reconciler.saveSyncState(context.TODO(), instance, state); err != nil {
+		t.Fatal(err)
+	}
+
+	configMap = syncStateConfigMap(t, reconciler)
+	if !equality.Semantic.DeepEqual(configMap.Data, map[string]string{SyncStateHubUIDKey: "hub-uid"}) {
+		t.Fatalf("expected the ConfigMap to only have the hub UID, got %v", configMap.Data)
+	}
+
+	// the state is read from the ConfigMap after a restart
+	restarted := &PolicyReconciler{ManagedClient: reconciler.ManagedClient}
+
+	state, err = restarted.syncState(context.TODO(), instance)
+	if err != nil || state.hubUID != "hub-uid" {
+		t.Fatalf("expected the hub UID of the ConfigMap, got %v and %v", state, err)
+	}
+
+	// the state of a deleted policy with the same name is ignored
+	recreated := instance.DeepCopy()
+	recreated.SetUID("recreated-uid")
+
+	state, err = restarted.syncState(context.TODO(), recreated)
+	if err != nil || state != (syncState{}) {
+		t.Fatalf("expected the state of another policy to be ignored, got %v and %v", state, err)
+	}
+
+	if err := restarted.saveSyncState(context.TODO(), recreated, syncState{hubUID: "other-uid"}); err != nil {
+		t.Fatal(err)
+	}
+
+	configMap = syncStateConfigMap(t, reconciler)
+	if owners := configMap.GetOwnerReferences(); len(owners) != 1 || owners[0].UID != "recreated-uid" {
+		t.Fatalf("expected the ConfigMap to be owned by the re-created policy, got %v", owners)
+	}
+}
+
+func TestSyncHubUID(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		recorded    string
+		keepHistory bool
+		reset       bool
+	}{
+		"first sync":               {recorded: "", reset: false},
+		"same hub policy":          {recorded: "hub-uid", reset: false},
+		"re-created hub policy":    {recorded: "old-uid", reset: true},
+		"re-created, keep history": {recorded: "old-uid", keepHistory: true, reset: false},
+	}
+
+	for name, test := range tests {
+		instance := testPolicy()
+		instance.SetUID("managed-uid")
+		instance.Status.ComplianceState = policiesv1.NonCompliant
+
+		hubPlc := testPolicy()
+		hubPlc.SetUID("hub-uid")
+		hubPlc.Status.ComplianceState = policiesv1.NonCompliant
+
+		reconciler := &PolicyReconciler{ManagedClient: fakeClient(instance), KeepHistoryOnHubRecreate: test.keepHistory}
+		lastSync := time.Now().Add(-time.Hour)
+		state := syncState{hubPolicy: "cluster1/policies.policy", hubUID: test.recorded, lastHubSync: lastSync}
+
+		if err := reconciler.syncHubUID(context.TODO(), instance, hubPlc, &state); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if state.hubUID != "hub-uid" {
+			t.Fatalf("%s: expected the hub UID to be recorded, got %v", name, state)
+		}
+
+		// the unchanged state isn't written
+		if test.recorded != "hub-uid" && syncStateConfigMap(t, reconciler).Data[SyncStateHubUIDKey] != "hub-uid" {
+			t.Fatalf("%s: expected the hub UID to be written to the ConfigMap", name)
+		}
+
+		if reset := instance.Status.ComplianceState == ""; reset != test.reset {
+			t.Fatalf("%s: expected the status to be reset: %v, got %v", name, test.reset, instance.Status)
+		}
+
+		if reset := !state.historyReset.IsZero(); reset != test.reset {
+			t.Fatalf("%s: expected the history reset time to be set: %v, got %v", name, test.reset, state)
+		}
+
+		recreated := test.recorded != "" && test.recorded != "hub-uid"
+		if forgotten := state.lastHubSync.IsZero(); forgotten != recreated {
+			t.Fatalf("%s: expected the last hub sync to be forgotten: %v, got %v", name, recreated, state)
+		}
+	}
+}
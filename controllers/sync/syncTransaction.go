@@ -0,0 +1,121 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var pendingSideEffectsGauge = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "policy_status_sync_pending_side_effects",
+		Help: "The number of policies whose managed events and sink notifications wait for their status to be " +
+			"written to the hub",
+	},
+)
+
+func init() {
+	metrics.Registry.MustRegister(pendingSideEffectsGauge)
+}
+
+// syncTransaction collects the local side effects of the reconcile of a policy, which are the
+// PolicyStatusSync event on the managed policy and the compliance transition sent to the sinks. They are only
+// committed once the status is written to the hub, or already matches it, so that the managed events and the
+// sinks never get ahead of the hub status. When the reconcile ends without committing, such as when the hub
+// write fails, the side effects are kept pending and committed together by the next successful reconcile.
+type syncTransaction struct {
+	store *syncTransactions
+	key   types.NamespacedName
+	// statusUpdated is set once the managed status was updated, which records a PolicyStatusSync event
+	statusUpdated bool
+	// transitioned is set once the compliance state changed, and previousCompliance is the compliance state
+	// before the first uncommitted change
+	transitioned       bool
+	previousCompliance policiesv1.ComplianceState
+	committed          bool
+}
+
+// syncTransactions are the pending side effects of the policies whose reconcile ended without committing
+type syncTransactions struct {
+	lock    sync.Mutex
+	pending map[types.NamespacedName]*syncTransaction
+}
+
+// begin returns the transaction of the reconcile of the policy, which has its pending side effects if any.
+// end must be called once the reconcile is done.
+func (s *syncTransactions) begin(key types.NamespacedName) *syncTransaction {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if tx, ok := s.pending[key]; ok {
+		delete(s.pending, key)
+		pendingSideEffectsGauge.Dec()
+
+		return tx
+	}
+
+	return &syncTransaction{store: s, key: key}
+}
+
+// forget removes the pending side effects of a deleted policy
+func (s *syncTransactions) forget(key types.NamespacedName) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.pending[key]; ok {
+		delete(s.pending, key)
+		pendingSideEffectsGauge.Dec()
+	}
+}
+
+// updateStatus records that the managed status was updated from the previous compliance state
+func (tx *syncTransaction) updateStatus(previous policiesv1.ComplianceState, current policiesv1.ComplianceState) {
+	tx.statusUpdated = true
+
+	if previous != current && !tx.transitioned {
+		tx.transitioned = true
+		tx.previousCompliance = previous
+	}
+}
+
+// end keeps the side effects of the transaction pending if it wasn't committed
+func (tx *syncTransaction) end() {
+	if tx.committed || (!tx.statusUpdated && !tx.transitioned) {
+		return
+	}
+
+	tx.store.lock.Lock()
+	defer tx.store.lock.Unlock()
+
+	if tx.store.pending == nil {
+		tx.store.pending = map[types.NamespacedName]*syncTransaction{}
+	}
+
+	tx.store.pending[tx.key] = tx
+	pendingSideEffectsGauge.Inc()
+}
+
+// commitSyncTransaction records the side effects of the transaction for the policy whose status reached
+// the hub. A compliance transition that was undone before it was committed isn't sent to the sinks.
+func (r *PolicyReconciler) commitSyncTransaction(
+	ctx context.Context, tx *syncTransaction, instance *policiesv1.Policy, severities map[string]string,
+) {
+	tx.committed = true
+
+	if tx.statusUpdated {
+		recordEvent(ctx, r.ManagedRecorder, instance, "Normal", "PolicyStatusSync",
+			fmt.Sprintf("Policy %s status was updated in cluster namespace %s", instance.GetName(),
+				instance.GetNamespace()))
+	}
+
+	if tx.transitioned && tx.previousCompliance != instance.Status.ComplianceState {
+		r.notifySinks(ctx, instance, tx.previousCompliance, severities)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"context"
+	"testing"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/stolostron/governance-policy-status-sync/sinks"
+)
+
+// transitionRecordingSink records the compliance transitions it receives
+type transitionRecordingSink struct {
+	transitions []sinks.ComplianceTransition
+}
+
+func (s *transitionRecordingSink) Name() string {
+	return "recording"
+}
+
+func (s *transitionRecordingSink) Send(_ context.Context, transition sinks.ComplianceTransition) error {
+	s.transitions = append(s.transitions, transition)
+
+	return nil
+}
+
+// transactionReconciler returns a reconciler whose managed events and sink transitions are recorded
+func transactionReconciler() (*PolicyReconciler, *record.FakeRecorder, *transitionRecordingSink) {
+	recorder := record.NewFakeRecorder(10)
+	sink := &transitionRecordingSink{}
+
+	return &PolicyReconciler{ManagedRecorder: recorder, Sinks: []sinks.Sink{sink}}, recorder, sink
+}
+
+var transactionKey = types.NamespacedName{Namespace: "cluster1", Name: "policies.policy"}
+
+func TestSyncTransactionRollback(t *testing.T) {
+	t.Parallel()
+
+	reconciler, recorder, sink := transactionReconciler()
+
+	// the hub write fails, so the side effects are rolled back to pending
+	tx := reconciler.syncTransactions.begin(transactionKey)
+	tx.updateStatus(policiesv1.Compliant, policiesv1.NonCompliant)
+	tx.end()
+
+	if len(recorder.Events) != 0 || len(sink.transitions) != 0 {
+		t.Fatal("expected no side effects before the status reached the hub")
+	}
+
+	// the next reconcile writes the hub status and commits the pending side effects with its own
+	tx = reconciler.syncTransactions.begin(transactionKey)
+	if !tx.statusUpdated || !tx.transitioned || tx.previousCompliance != policiesv1.Compliant {
+		t.Fatal("expected the pending side effects to be resumed by the next reconcile")
+	}
+
+	instance := testPolicy()
+	instance.Status.ComplianceState = policiesv1.NonCompliant
+
+	tx.updateStatus(policiesv1.NonCompliant, policiesv1.NonCompliant)
+	reconciler.commitSyncTransaction(context.TODO(), tx, instance, nil)
+	tx.end()
+
+	if len(recorder.Events) != 1 {
+		t.Fatalf("expected a single PolicyStatusSync event, got %d", len(recorder.Events))
+	}
+
+	if len(sink.transitions) != 1 || sink.transitions[0].PreviousCompliance != string(policiesv1.Compliant) ||
+		sink.transitions[0].Compliance != string(policiesv1.NonCompliant) {
+		t.Fatalf("expected a single transition from Compliant to NonCompliant, got %v", sink.transitions)
+	}
+
+	// the committed side effects aren't pending anymore
+	if tx := reconciler.syncTransactions.begin(transactionKey); tx.statusUpdated || tx.transitioned {
+		t.Fatal("expected no pending side effects after the commit")
+	}
+}
+
+func TestSyncTransactionUndoneTransition(t *testing.T) {
+	t.Parallel()
+
+	reconciler, recorder, sink := transactionReconciler()
+
+	tx := reconciler.syncTransactions.begin(transactionKey)
+	tx.updateStatus(policiesv1.Compliant, policiesv1.NonCompliant)
+	tx.end()
+
+	// the policy is compliant again when the hub status is written
+	tx = reconciler.syncTransactions.begin(transactionKey)
+	instance := testPolicy()
+	instance.Status.ComplianceState = policiesv1.Compliant
+
+	tx.updateStatus(policiesv1.NonCompliant, policiesv1.Compliant)
+	reconciler.commitSyncTransaction(context.TODO(), tx, instance, nil)
+	tx.end()
+
+	if len(recorder.Events) != 1 {
+		t.Fatalf("expected a single PolicyStatusSync event, got %d", len(recorder.Events))
+	}
+
+	if len(sink.transitions) != 0 {
+		t.Fatalf("expected the undone transition to not be sent, got %v", sink.transitions)
+	}
+}
+
+func TestSyncTransactionForget(t *testing.T) {
+	t.Parallel()
+
+	reconciler, _, _ := transactionReconciler()
+
+	tx := reconciler.syncTransactions.begin(transactionKey)
+	tx.updateStatus(policiesv1.Compliant, policiesv1.NonCompliant)
+	tx.end()
+
+	// the policy was deleted
+	reconciler.syncTransactions.forget(transactionKey)
+
+	if tx := reconciler.syncTransactions.begin(transactionKey); tx.statusUpdated || tx.transitioned {
+		t.Fatal("expected the pending side effects of the deleted policy to be dropped")
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// HubTemplatesErrorAnnotation is set by the propagator on a policy template, or on the replicated policy,
+	// when its hub templates fail to resolve, and contains the error
+	HubTemplatesErrorAnnotation = "policy.open-cluster-management.io/hub-templates-error"
+	// TemplateErrorAnnotation is set on the template metadata in the status of a policy template whose hub
+	// templates failed to resolve, and contains the error
+	TemplateErrorAnnotation = "policy.open-cluster-management.io/template-error"
+)
+
+// hubTemplatesError returns the hub template resolution error of the policy template, which is on either
+// the template or the replicated policy, or an empty string if the hub templates resolved
+func hubTemplatesError(instance *policiesv1.Policy, template metav1.Object) string {
+	if message := template.GetAnnotations()[HubTemplatesErrorAnnotation]; message != "" {
+		return message
+	}
+
+	return instance.GetAnnotations()[HubTemplatesErrorAnnotation]
+}
+
+// setTemplateError sets the template error annotation of the template status from the hub template
+// resolution error. Since the template on the managed cluster wasn't updated with the resolved hub
+// templates, its compliance state is left empty rather than reporting the stale state of the last
+// successfully resolved template.
+func setTemplateError(dpt *policiesv1.DetailsPerTemplate, templateErr string) {
+	annotations := dpt.TemplateMeta.GetAnnotations()
+	delete(annotations, TemplateErrorAnnotation)
+
+	if templateErr != "" {
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+
+		dpt.ComplianceState = ""
+		annotations[TemplateErrorAnnotation] = templateErr
+	}
+
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+
+	dpt.TemplateMeta.SetAnnotations(annotations)
+}
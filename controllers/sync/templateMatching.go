@@ -0,0 +1,43 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+)
+
+// templateMatcher matches the policy templates of the spec, in order, to their existing statuses
+type templateMatcher struct {
+	details []*policiesv1.DetailsPerTemplate
+	// seen are the number of templates of the spec with each name that were already matched
+	seen map[string]int
+}
+
+// newTemplateMatcher returns a matcher of the policy templates to the existing statuses
+func newTemplateMatcher(details []*policiesv1.DetailsPerTemplate) *templateMatcher {
+	return &templateMatcher{details: details, seen: map[string]int{}}
+}
+
+// match returns the existing status of the next policy template of the spec with the name, or nil if it has
+// none. The templates with the same name are matched by their index among them, so that the second template
+// named "a" gets the second status named "a" even after another template was inserted before them. This keeps
+// a status per template when the names are duplicated, and a renamed template doesn't take the status of
+// another template.
+func (m *templateMatcher) match(name string) *policiesv1.DetailsPerTemplate {
+	occurrence := m.seen[name]
+	m.seen[name]++
+
+	for _, dpt := range m.details {
+		if dpt.TemplateMeta.Name != name {
+			continue
+		}
+
+		if occurrence == 0 {
+			return dpt
+		}
+
+		occurrence--
+	}
+
+	return nil
+}
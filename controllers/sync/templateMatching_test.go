@@ -0,0 +1,82 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"testing"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+)
+
+func TestTemplateMatcher(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		// existing are the names of the existing statuses, and templates the names of the templates of the spec
+		existing  []string
+		templates []string
+		// expected are the indexes of the existing statuses that the templates match, or -1 for none
+		expected []int
+	}{
+		"same order":       {[]string{"a", "b"}, []string{"a", "b"}, []int{0, 1}},
+		"reordered":        {[]string{"a", "b"}, []string{"b", "a"}, []int{1, 0}},
+		"new template":     {[]string{"a"}, []string{"a", "b"}, []int{0, -1}},
+		"removed template": {[]string{"a", "b"}, []string{"b"}, []int{1}},
+		"renamed template": {[]string{"a", "b"}, []string{"a", "c"}, []int{0, -1}},
+		"duplicated name":  {[]string{"a", "a"}, []string{"a", "a"}, []int{0, 1}},
+		"new duplicate":    {[]string{"a"}, []string{"a", "a"}, []int{0, -1}},
+		"inserted before":  {[]string{"a", "b", "a"}, []string{"c", "a", "b", "a"}, []int{-1, 0, 1, 2}},
+		"interleaved":      {[]string{"a", "b", "a", "b"}, []string{"b", "b", "a"}, []int{1, 3, 0}},
+		"no statuses":      {nil, []string{"a"}, []int{-1}},
+	}
+
+	for name, test := range tests {
+		details := testStatus(policiesv1.NonCompliant, nil, test.existing...).Details
+
+		matcher := newTemplateMatcher(details)
+
+		for i, template := range test.templates {
+			matched := matcher.match(template)
+
+			expected := test.expected[i]
+			if expected == -1 {
+				if matched != nil {
+					t.Fatalf("%s: expected the template %d to not match a status, got %s", name, i,
+						matched.TemplateMeta.Name)
+				}
+
+				continue
+			}
+
+			if matched != details[expected] {
+				t.Fatalf("%s: expected the template %d to match the status %d", name, i, expected)
+			}
+		}
+	}
+}
+
+func TestSkippedTemplateDetails(t *testing.T) {
+	t.Parallel()
+
+	existing := testStatus(policiesv1.NonCompliant, testHistory(2, 1), skippedTemplateName(1)).Details[0]
+
+	tests := map[string]struct {
+		existing *policiesv1.DetailsPerTemplate
+		expected int
+	}{
+		"existing status":    {existing, 2},
+		"no existing status": {nil, 0},
+	}
+
+	for name, test := range tests {
+		dpt := skippedTemplateDetails(test.existing, 1, "the policy template has no name")
+
+		if dpt.TemplateMeta.Name != skippedTemplateName(1) {
+			t.Fatalf("%s: expected the name %s, got %s", name, skippedTemplateName(1), dpt.TemplateMeta.Name)
+		}
+
+		if len(dpt.History) != test.expected {
+			t.Fatalf("%s: expected %d history entries, got %d", name, test.expected, len(dpt.History))
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"fmt"
+	"strings"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+
+	"github.com/stolostron/governance-policy-status-sync/policystatus"
+)
+
+// TemplateStatusErrorAnnotation is set on the template metadata in the status of a policy template whose
+// status can't be derived, such as when the message of its latest compliance event has no compliance state,
+// and contains the error. The other templates of the policy are still synced.
+const TemplateStatusErrorAnnotation = policystatus.TemplateStatusErrorAnnotation
+
+// knownCompliance returns true if the message of a compliance history entry starts with a compliance state
+func knownCompliance(message string) bool {
+	message = strings.ToLower(strings.TrimSpace(message))
+
+	for _, prefix := range []string{"compliant", "noncompliant", "non-compliant", "violation", "pending"} {
+		if strings.HasPrefix(message, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseHistoryCompliance returns the compliance state of the message of a compliance history entry, and an
+// error if the message doesn't start with a compliance state
+func parseHistoryCompliance(entry policiesv1.ComplianceHistory) (policiesv1.ComplianceState, error) {
+	if knownCompliance(entry.Message) {
+		return historyCompliance(entry.Message), nil
+	}
+
+	return "", fmt.Errorf(
+		"the compliance state of the event %s can't be parsed from its message %q", entry.EventName, entry.Message,
+	)
+}
+
+// setTemplateStatusError sets the status error annotation of the template status from the error, and
+// removes it if the error is nil. The compliance state of the template is cleared since it's unknown.
+func setTemplateStatusError(dpt *policiesv1.DetailsPerTemplate, statusErr error) {
+	annotations := dpt.TemplateMeta.GetAnnotations()
+	delete(annotations, TemplateStatusErrorAnnotation)
+
+	if statusErr != nil {
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+
+		dpt.ComplianceState = ""
+		annotations[TemplateStatusErrorAnnotation] = statusErr.Error()
+	}
+
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+
+	dpt.TemplateMeta.SetAnnotations(annotations)
+}
@@ -0,0 +1,87 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// terminatingNamespaceRetry is how long the policies of a terminating namespace aren't synced before the
+// sync is retried, which resumes it if the namespace is no longer terminating
+const terminatingNamespaceRetry = time.Minute
+
+// terminatingNamespaces are the namespaces whose writes failed because the namespace on the hub or on the
+// managed cluster is terminating, so that the sync of their policies is quiesced instead of failing
+// repeatedly.
+type terminatingNamespaces struct {
+	lock sync.Mutex
+	// retryAt is the time of the next sync attempt of the policies in each terminating namespace
+	retryAt map[string]time.Time
+}
+
+// isNamespaceTerminating returns true if the error is caused by a write to a terminating namespace
+func isNamespaceTerminating(err error) bool {
+	return k8serrors.HasStatusCause(err, corev1.NamespaceTerminatingCause)
+}
+
+// wait returns how long the policies of the namespace aren't synced, or false if the namespace isn't
+// terminating or its sync may be retried
+func (t *terminatingNamespaces) wait(namespace string, now time.Time) (time.Duration, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	retryAt, ok := t.retryAt[namespace]
+	if !ok || !now.Before(retryAt) {
+		return 0, false
+	}
+
+	return retryAt.Sub(now), true
+}
+
+// quiesce stops the sync of the policies of the namespace until terminatingNamespaceRetry passes. It logs
+// when the namespace is first found terminating.
+func (t *terminatingNamespaces) quiesce(namespace string, err error, now time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.retryAt == nil {
+		t.retryAt = map[string]time.Time{}
+	}
+
+	if _, ok := t.retryAt[namespace]; !ok {
+		log.Info("The namespace is terminating, not syncing its policies until it's no longer terminating",
+			"Namespace", namespace, "error", err.Error(), "retryInterval", terminatingNamespaceRetry.String())
+	}
+
+	t.retryAt[namespace] = now.Add(terminatingNamespaceRetry)
+}
+
+// resume syncs the policies of the namespace again after a successful sync, and logs it if the namespace
+// was terminating
+func (t *terminatingNamespaces) resume(namespace string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if _, ok := t.retryAt[namespace]; !ok {
+		return
+	}
+
+	delete(t.retryAt, namespace)
+
+	log.Info("The namespace is no longer terminating, syncing its policies again", "Namespace", namespace)
+}
+
+// terminatingResult returns the result of a reconcile of a policy in a terminating namespace, which retries
+// it after the wait when the policy is reconciled by a controller
+func (r *PolicyReconciler) terminatingResult(wait time.Duration) reconcile.Result {
+	if r.hubWrites == nil {
+		return reconcile.Result{}
+	}
+
+	return reconcile.Result{RequeueAfter: wait}
+}
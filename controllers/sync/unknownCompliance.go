@@ -0,0 +1,57 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// UnknownComplianceUnknown clears the compliance state of a template whose latest event has an unknown
+	// compliance state and sets its TemplateStatusErrorAnnotation
+	UnknownComplianceUnknown = "unknown"
+	// UnknownComplianceNonCompliant treats an unknown compliance state as NonCompliant
+	UnknownComplianceNonCompliant = "noncompliant"
+	// UnknownComplianceDrop ignores the events with an unknown compliance state, so that they aren't in the
+	// history
+	UnknownComplianceDrop = "drop"
+)
+
+var unknownComplianceStatesTotal = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "policy_status_sync_unknown_compliance_states_total",
+		Help: "The number of parsed policy template events whose message doesn't start with a known compliance " +
+			"state, such as a misspelled state from a third-party controller",
+	},
+)
+
+func init() {
+	metrics.Registry.MustRegister(unknownComplianceStatesTotal)
+}
+
+// validateUnknownCompliance returns an error if the UnknownCompliance behavior is invalid
+func (r *PolicyReconciler) validateUnknownCompliance() error {
+	switch r.UnknownCompliance {
+	case "", UnknownComplianceUnknown, UnknownComplianceNonCompliant, UnknownComplianceDrop:
+		return nil
+	}
+
+	return fmt.Errorf("invalid unknown compliance behavior %q, it must be %s, %s, or %s", r.UnknownCompliance,
+		UnknownComplianceUnknown, UnknownComplianceNonCompliant, UnknownComplianceDrop)
+}
+
+// templateCompliance returns the compliance state of a template from its latest history entry, and an error
+// if it's unknown. An unknown compliance state is NonCompliant without an error if UnknownCompliance is
+// UnknownComplianceNonCompliant.
+func (r *PolicyReconciler) templateCompliance(latest policiesv1.ComplianceHistory) (policiesv1.ComplianceState, error) {
+	compliance, err := parseHistoryCompliance(latest)
+	if err != nil && r.UnknownCompliance == UnknownComplianceNonCompliant {
+		return policiesv1.NonCompliant, nil
+	}
+
+	return compliance, err
+}
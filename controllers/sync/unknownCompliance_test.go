@@ -0,0 +1,147 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sync
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/stolostron/governance-policy-status-sync/policystatus"
+)
+
+func TestValidateUnknownCompliance(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		behavior string
+		valid    bool
+	}{
+		"default":      {"", true},
+		"unknown":      {UnknownComplianceUnknown, true},
+		"noncompliant": {UnknownComplianceNonCompliant, true},
+		"drop":         {UnknownComplianceDrop, true},
+		"capitalized":  {"NonCompliant", false},
+		"other":        {"ignore", false},
+	}
+
+	for name, test := range tests {
+		reconciler := &PolicyReconciler{UnknownCompliance: test.behavior}
+
+		if err := reconciler.validateUnknownCompliance(); (err == nil) != test.valid {
+			t.Fatalf("%s: expected the behavior to be valid: %v, got %v", name, test.valid, err)
+		}
+	}
+}
+
+func TestTemplateCompliance(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		behavior string
+		message  string
+		expected policiesv1.ComplianceState
+		err      bool
+	}{
+		"compliant":               {"", "Compliant; notification", policiesv1.Compliant, false},
+		"noncompliant":            {"", "NonCompliant; violation", policiesv1.NonCompliant, false},
+		"violation":               {"", "violation - the object was not found", policiesv1.NonCompliant, false},
+		"pending":                 {"", "Pending; the template is waiting", policystatus.Pending, false},
+		"lowercase with spaces":   {"", "  compliant; notification", policiesv1.Compliant, false},
+		"unknown by default":      {"", "Complaint; misspelled", "", true},
+		"unknown":                 {UnknownComplianceUnknown, "Unknown; state", "", true},
+		"unknown as noncompliant": {UnknownComplianceNonCompliant, "Unknown; state", policiesv1.NonCompliant, false},
+		"known with noncompliant": {UnknownComplianceNonCompliant, "Compliant; ok", policiesv1.Compliant, false},
+		"empty message":           {UnknownComplianceUnknown, "", "", true},
+		// the events are dropped before, so a remaining unknown history entry is still an error
+		"unknown with drop": {UnknownComplianceDrop, "Unknown; state", "", true},
+	}
+
+	for name, test := range tests {
+		reconciler := &PolicyReconciler{UnknownCompliance: test.behavior}
+
+		entry := testHistory(1)[0]
+		entry.Message = test.message
+
+		compliance, err := reconciler.templateCompliance(entry)
+
+		if compliance != test.expected || (err != nil) != test.err {
+			t.Fatalf("%s: expected the compliance %q with an error: %v, got %q with %v", name, test.expected,
+				test.err, compliance, err)
+		}
+	}
+}
+
+func TestReconcileUnknownCompliance(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		behavior   string
+		compliance policiesv1.ComplianceState
+		history    int
+		statusErr  bool
+	}{
+		"unknown":      {UnknownComplianceUnknown, "", 2, true},
+		"noncompliant": {UnknownComplianceNonCompliant, policiesv1.NonCompliant, 2, false},
+		"drop":         {UnknownComplianceDrop, policiesv1.Compliant, 1, false},
+	}
+
+	template := policiesv1.PolicyTemplate{ObjectDefinition: runtime.RawExtension{Raw: []byte(
+		`{"apiVersion":"policy.open-cluster-management.io/v1","kind":"ConfigurationPolicy",` +
+			`"metadata":{"name":"template1"}}`,
+	)}}
+
+	for name, test := range tests {
+		hubPlc := testPolicy()
+		hubPlc.SetUID("hub-uid")
+		hubPlc.Spec.PolicyTemplates = []*policiesv1.PolicyTemplate{&template}
+
+		managedPlc := testPolicy()
+		managedPlc.SetUID("managed-uid")
+		managedPlc.Spec = hubPlc.Spec
+
+		reconciler := &PolicyReconciler{
+			ManagedClient: fakeClient(
+				managedPlc,
+				testEvent(1, "Compliant; notification - the object was found"),
+				testEvent(2, "Complaint; a misspelled compliance state"),
+			),
+			HubClient:         fakeClient(hubPlc),
+			ManagedRecorder:   record.NewFakeRecorder(10),
+			HubRecorder:       record.NewFakeRecorder(10),
+			UnknownCompliance: test.behavior,
+		}
+
+		key := types.NamespacedName{Namespace: "cluster1", Name: "policies.policy"}
+
+		if _, err := reconciler.Reconcile(context.TODO(), reconcile.Request{NamespacedName: key}); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if err := reconciler.HubClient.Get(context.TODO(), key, hubPlc); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(hubPlc.Status.Details) != 1 {
+			t.Fatalf("%s: expected the status of the template, got %v", name, hubPlc.Status.Details)
+		}
+
+		dpt := hubPlc.Status.Details[0]
+
+		if dpt.ComplianceState != test.compliance || len(dpt.History) != test.history {
+			t.Fatalf("%s: expected the compliance %q with %d history entries, got %q with %d", name,
+				test.compliance, test.history, dpt.ComplianceState, len(dpt.History))
+		}
+
+		statusErr := dpt.TemplateMeta.GetAnnotations()[TemplateStatusErrorAnnotation]
+		if (statusErr != "") != test.statusErr || (test.statusErr && !strings.Contains(statusErr, "Complaint")) {
+			t.Fatalf("%s: expected a template status error: %v, got %q", name, test.statusErr, statusErr)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	admissionv1 "k8s.io/api/admission/v1"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// StatusWebhookPath is the path the policy status validating webhook is served at
+const StatusWebhookPath = "/validate-policy-status"
+
+var log = logf.Log.WithName("policy-status-webhook")
+
+// blank assignment to verify that StatusValidator implements admission.Handler
+var _ admission.Handler = &StatusValidator{}
+
+// StatusValidator is a validating admission webhook handler that rejects updates to the status of
+// replicated policies on the managed cluster unless they are made by the policy framework. This prevents
+// local tampering from propagating bogus compliance to the hub.
+type StatusValidator struct {
+	// AllowedNamespaces are the namespaces whose service accounts may update the policy status, such as
+	// the namespace of the policy framework
+	AllowedNamespaces []string
+	// AllowedUsers are additional usernames that may update the policy status
+	AllowedUsers []string
+}
+
+// Handle denies policy status updates from anyone other than the allowed service accounts and users
+func (v *StatusValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Kind.Kind != policiesv1.Kind || req.SubResource != "status" || req.Operation != admissionv1.Update {
+		return admission.Allowed("")
+	}
+
+	if v.isAllowed(req.UserInfo.Username) {
+		return admission.Allowed("")
+	}
+
+	log.Info("Denied a policy status update", "Namespace", req.Namespace, "Name", req.Name,
+		"User", req.UserInfo.Username)
+
+	return admission.Denied(fmt.Sprintf(
+		"the status of replicated policies can only be updated by the policy framework, not %s",
+		req.UserInfo.Username,
+	))
+}
+
+// isAllowed returns true if the user is one of the allowed users or a service account in an allowed
+// namespace
+func (v *StatusValidator) isAllowed(username string) bool {
+	for _, user := range v.AllowedUsers {
+		if username == user {
+			return true
+		}
+	}
+
+	for _, ns := range v.AllowedNamespaces {
+		if strings.HasPrefix(username, "system:serviceaccount:"+ns+":") {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,212 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+
+	"github.com/stolostron/governance-policy-status-sync/sinks"
+	"github.com/stolostron/governance-policy-status-sync/tool"
+)
+
+// newSinks returns the external sinks configured by the command line flags. The managed cluster config is
+// used to read the Kubernetes version of the managed cluster for the compliance digests.
+func newSinks(clusterName string, managedCfg *rest.Config) ([]sinks.Sink, error) {
+	configured := []sinks.Sink{}
+
+	compression, err := sinks.NewCompression(tool.Options.SinkCompression, tool.Options.SinkCompressionThreshold)
+	if err != nil {
+		return nil, err
+	}
+
+	if tool.Options.MQTTBroker != "" {
+		clientID := tool.Options.MQTTClientID
+		if clientID == "" {
+			clientID = "policy-status-sync-" + clusterName
+		}
+
+		mqttAuth, err := newMQTTAuth()
+		if err != nil {
+			return nil, err
+		}
+
+		mqttSink, err := sinks.NewMQTTSink(sinks.MQTTOptions{
+			Broker:               tool.Options.MQTTBroker,
+			TopicPrefix:          tool.Options.MQTTTopicPrefix,
+			ChannelTopicPrefixes: tool.Options.MQTTChannelTopicPrefixes,
+			ClientID:             clientID,
+			Auth:                 mqttAuth,
+			QoS:                  byte(tool.Options.MQTTQoS),
+			CAFile:               tool.Options.MQTTCAFile,
+			CertFile:             tool.Options.MQTTCertFile,
+			KeyFile:              tool.Options.MQTTKeyFile,
+			Compression:          compression,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		log.Info("Sending compliance transitions to the MQTT broker", "broker", tool.Options.MQTTBroker)
+
+		configured = append(configured, mqttSink)
+	}
+
+	if tool.Options.SinkDigestInterval > 0 && len(configured) != 0 {
+		kubernetesVersion := managedKubernetesVersion(managedCfg)
+
+		for i, sink := range configured {
+			configured[i] = sinks.NewPeriodicDigestSink(sink, tool.Options.SinkDigestInterval, kubernetesVersion)
+		}
+	}
+
+	if tool.Options.SinkFilter != "" {
+		filter, err := sinks.NewFilter(tool.Options.SinkFilter)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, sink := range configured {
+			configured[i] = sinks.NewFilteredSink(sink, filter)
+		}
+	}
+
+	if len(tool.Options.SinkQuietHours) != 0 {
+		if tool.Options.SinkQuietHoursMode != "digest" && tool.Options.SinkQuietHoursMode != "suppress" {
+			return nil, fmt.Errorf("invalid --sink-quiet-hours-mode %q, it must be digest or suppress",
+				tool.Options.SinkQuietHoursMode)
+		}
+
+		location, err := time.LoadLocation(tool.Options.SinkQuietHoursTimezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --sink-quiet-hours-timezone: %w", err)
+		}
+
+		quietHours, err := sinks.NewQuietHours(tool.Options.SinkQuietHours, location)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, sink := range configured {
+			configured[i] = sinks.NewQuietHoursSink(sink, quietHours, tool.Options.SinkQuietHoursMode == "digest")
+		}
+	}
+
+	return configured, nil
+}
+
+// newSinkControls returns the controls of the sinks configured by the command line flags
+func newSinkControls() (map[string]sinks.SinkControls, error) {
+	return sinks.ParseSinkControls(tool.Options.SinkControls)
+}
+
+// newComplianceHistoryReporter returns the compliance history API reporter configured by the command line
+// flags, or nil if it's not configured. The periodic sends are offset by the phase of the cluster name.
+func newComplianceHistoryReporter(clusterName string) (*sinks.ComplianceHistoryReporter, error) {
+	if tool.Options.ComplianceHistoryAPIURL == "" {
+		if tool.Options.ComplianceHistoryOnly {
+			return nil, errors.New("--compliance-history-api-only requires --compliance-history-api-url")
+		}
+
+		return nil, nil
+	}
+
+	compression, err := sinks.NewCompression(tool.Options.SinkCompression, tool.Options.SinkCompressionThreshold)
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := newComplianceHistoryAuth()
+	if err != nil {
+		return nil, err
+	}
+
+	reporter, err := sinks.NewComplianceHistoryReporter(sinks.ComplianceHistoryOptions{
+		URL:         tool.Options.ComplianceHistoryAPIURL,
+		Auth:        auth,
+		CAFile:      tool.Options.ComplianceHistoryCAFile,
+		BatchSize:   tool.Options.ComplianceHistoryBatch,
+		FlushPhase:  tool.PhaseOffset(clusterName, sinks.ComplianceHistoryFlushInterval),
+		Compression: compression,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info("Sending compliance events to the compliance history API", "url", tool.Options.ComplianceHistoryAPIURL)
+
+	return reporter, nil
+}
+
+// managedKubernetesVersion returns the Kubernetes version of the managed cluster, or an empty string if it
+// can't be read, which is only logged
+func managedKubernetesVersion(managedCfg *rest.Config) string {
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(tool.ClientsetConfig(managedCfg))
+	if err != nil {
+		log.Error(err, "Failed to read the Kubernetes version of the managed cluster")
+
+		return ""
+	}
+
+	serverVersion, err := discoveryClient.ServerVersion()
+	if err != nil {
+		log.Error(err, "Failed to read the Kubernetes version of the managed cluster")
+
+		return ""
+	}
+
+	return serverVersion.GitVersion
+}
+
+// newMQTTAuth returns the authentication of the MQTT broker, which is the username and password keys of the
+// --mqtt-auth-secret-dir if it's set, or else --mqtt-username and --mqtt-password-file
+func newMQTTAuth() (sinks.PasswordAuthProvider, error) {
+	if tool.Options.MQTTAuthSecretDir == "" {
+		if tool.Options.MQTTUsername == "" && tool.Options.MQTTPasswordFile == "" {
+			return nil, nil
+		}
+
+		// MQTT 3.1.1 doesn't allow a password without a username
+		if tool.Options.MQTTUsername == "" {
+			return nil, errors.New("--mqtt-password-file requires --mqtt-username")
+		}
+
+		return sinks.NewBasicAuth(tool.Options.MQTTUsername, tool.Options.MQTTPasswordFile), nil
+	}
+
+	auth, err := sinks.NewAuthProvider(sinks.AuthBasic, tool.Options.MQTTAuthSecretDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return auth.(sinks.PasswordAuthProvider), nil
+}
+
+// newComplianceHistoryAuth returns the authentication of the compliance history API, which is
+// --compliance-history-api-auth if it's set, or else the bearer token in --compliance-history-api-token-file
+func newComplianceHistoryAuth() (sinks.AuthProvider, error) {
+	if tool.Options.ComplianceHistoryAuth == "" {
+		if tool.Options.ComplianceHistoryToken == "" {
+			return nil, nil
+		}
+
+		return sinks.NewTokenAuth(tool.Options.ComplianceHistoryToken), nil
+	}
+
+	if tool.Options.ComplianceHistoryToken != "" {
+		return nil, errors.New(
+			"--compliance-history-api-token-file can't be set with --compliance-history-api-auth",
+		)
+	}
+
+	auth, err := sinks.NewAuthProvider(tool.Options.ComplianceHistoryAuth, tool.Options.ComplianceHistoryAuthDir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --compliance-history-api-auth: %w", err)
+	}
+
+	return auth, nil
+}
@@ -0,0 +1,532 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	gosync "sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	addonutils "open-cluster-management.io/addon-framework/pkg/utils"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/cluster"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/stolostron/governance-policy-status-sync/controllers/sync"
+	"github.com/stolostron/governance-policy-status-sync/tool"
+)
+
+const (
+	// fanInKubeconfigKey is the key in a managed cluster Secret that contains the kubeconfig
+	fanInKubeconfigKey = "kubeconfig"
+	// fanInClusterNameKey is the optional key in a managed cluster Secret that contains the cluster name,
+	// which defaults to the name of the Secret
+	fanInClusterNameKey = "cluster-name"
+	// fanInClusterIdentityKey is the optional key in a managed cluster Secret that contains the cluster identity,
+	// which defaults to the --cluster-identity, or else to the cluster name
+	fanInClusterIdentityKey = "cluster-identity"
+	// fanInSecretsCheckInterval is the interval at which the managed cluster Secrets are checked for changes
+	fanInSecretsCheckInterval = time.Minute
+)
+
+// managedClusterConfig is the connection information for a managed cluster in fan-in mode
+type managedClusterConfig struct {
+	name     string
+	identity string
+	config   *rest.Config
+}
+
+// listFanInSecrets returns the Secrets matching the fan-in selector on the hosting cluster and their namespace
+func listFanInSecrets(ctx context.Context, hostingCfg *rest.Config) ([]v1.Secret, string, error) {
+	secretNs := tool.Options.FanInSecretNamespace
+	if secretNs == "" {
+		var err error
+
+		secretNs, err = tool.GetOperatorNamespace()
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to determine the namespace of the managed cluster secrets: %w", err)
+		}
+	}
+
+	hostingClient, err := kubernetes.NewForConfig(tool.ClientsetConfig(hostingCfg))
+	if err != nil {
+		return nil, "", err
+	}
+
+	secrets, err := hostingClient.CoreV1().Secrets(secretNs).List(
+		ctx, metav1.ListOptions{LabelSelector: tool.Options.FanInSecretSelector},
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list the managed cluster secrets: %w", err)
+	}
+
+	return secrets.Items, secretNs, nil
+}
+
+// fanInClusterName returns the managed cluster name of the Secret
+func fanInClusterName(secret v1.Secret) string {
+	if name := string(secret.Data[fanInClusterNameKey]); name != "" {
+		return name
+	}
+
+	return secret.GetName()
+}
+
+// fanInClusterIdentity returns the managed cluster identity of the Secret
+func fanInClusterIdentity(secret v1.Secret) string {
+	if identity := string(secret.Data[fanInClusterIdentityKey]); identity != "" {
+		return identity
+	}
+
+	if tool.Options.ClusterIdentity != "" {
+		return tool.Options.ClusterIdentity
+	}
+
+	return fanInClusterName(secret)
+}
+
+// fanInFingerprint returns a hash of the managed clusters configured by the Secrets, which changes when a
+// managed cluster is added, removed, or its kubeconfig or identity changes
+func fanInFingerprint(secrets []v1.Secret) string {
+	entries := make([]string, 0, len(secrets))
+
+	for _, secret := range secrets {
+		entries = append(entries, fanInClusterName(secret)+"\x00"+string(secret.Data[fanInKubeconfigKey])+"\x00"+
+			string(secret.Data[fanInClusterIdentityKey]))
+	}
+
+	sort.Strings(entries)
+
+	hash := sha256.Sum256([]byte(strings.Join(entries, "\x00")))
+
+	return hex.EncodeToString(hash[:])
+}
+
+// getFanInClusters returns the managed clusters from the Secrets matching the fan-in selector on the
+// hosting cluster, and the fingerprint of the Secrets.
+func getFanInClusters(hostingCfg *rest.Config) ([]managedClusterConfig, string, error) {
+	secrets, secretNs, err := listFanInSecrets(context.TODO(), hostingCfg)
+	if err != nil {
+		return nil, "", err
+	}
+
+	clusters := make([]managedClusterConfig, 0, len(secrets))
+	seen := map[string]bool{}
+
+	for _, secret := range secrets {
+		clusterName := fanInClusterName(secret)
+
+		if seen[clusterName] {
+			return nil, "", tool.Permanent(
+				fmt.Errorf("the managed cluster %s is configured by more than one secret", clusterName),
+			)
+		}
+
+		seen[clusterName] = true
+
+		cfg, err := clientcmd.RESTConfigFromKubeConfig(secret.Data[fanInKubeconfigKey])
+		if err != nil {
+			return nil, "", tool.Permanent(
+				fmt.Errorf("the secret %s/%s has an invalid kubeconfig: %w", secretNs, secret.GetName(), err),
+			)
+		}
+
+		clusters = append(clusters, managedClusterConfig{
+			name: clusterName, identity: fanInClusterIdentity(secret), config: cfg,
+		})
+	}
+
+	return clusters, fanInFingerprint(secrets), nil
+}
+
+// fanInSecretsChecker is a liveness check that fails once the Secrets matching the fan-in selector changed
+// since startup, such as when a managed cluster is onboarded or removed, so that the agent is restarted to
+// sync the managed clusters of the current Secrets, like the config checker does for the kubeconfig files.
+type fanInSecretsChecker struct {
+	hostingCfg *rest.Config
+	// fingerprint is the fingerprint of the Secrets at startup
+	fingerprint string
+	lock        gosync.Mutex
+	lastCheck   time.Time
+	changed     bool
+}
+
+// Check lists the Secrets at most once per fanInSecretsCheckInterval, and fails once they changed. A failed
+// list doesn't fail the check, so that the hosting cluster being unreachable doesn't restart the agent.
+func (c *fanInSecretsChecker) Check(req *http.Request) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if !c.changed && time.Since(c.lastCheck) >= fanInSecretsCheckInterval {
+		c.lastCheck = time.Now()
+
+		secrets, _, err := listFanInSecrets(req.Context(), c.hostingCfg)
+		if err != nil {
+			log.V(1).Info("Failed to check the managed cluster secrets for changes", "error", err.Error())
+
+			return nil
+		}
+
+		if fanInFingerprint(secrets) != c.fingerprint {
+			log.Info("The managed cluster secrets changed, restarting to sync the managed clusters",
+				"selector", tool.Options.FanInSecretSelector)
+
+			c.changed = true
+		}
+	}
+
+	if c.changed {
+		return errors.New("the managed cluster secrets changed since startup")
+	}
+
+	return nil
+}
+
+// runFanIn runs a policy status sync controller for every managed cluster configured in a Secret on the
+// hosting cluster. The policies of each managed cluster are read from the namespace named after the
+// cluster, and their status is written to the cluster namespace with the same name on the hub. It returns
+// the exit code for the process. The compliance score of each managed cluster uses the complianceScoreWeights,
+// and the logLevels are updated from the log level ConfigMap if it's set.
+func runFanIn(
+	hubCfg *rest.Config, hostingCfg *rest.Config, complianceScoreWeights map[string]float64,
+	logLevels *tool.LogLevels,
+) int {
+	var (
+		clusters    []managedClusterConfig
+		fingerprint string
+	)
+
+	err := tool.RetryStartup("get the managed clusters", func() error {
+		var err error
+		clusters, fingerprint, err = getFanInClusters(hostingCfg)
+
+		return err
+	})
+	if err != nil {
+		log.Error(err, "Failed to get the managed clusters for fan-in mode")
+
+		return 1
+	}
+
+	if len(clusters) == 0 {
+		log.Error(errors.New("no managed clusters were found"), "No secret matches the fan-in selector",
+			"selector", tool.Options.FanInSecretSelector)
+
+		return 1
+	}
+
+	// the fan-in agent syncs several clusters, so the cluster placeholder is the cluster name flag if set
+	identityClusterName := tool.Options.ClusterName
+	if identityClusterName == "" {
+		identityClusterName = "fan-in"
+	}
+
+	hubFieldManager, err := tool.ApplyHubIdentity(hubCfg, identityClusterName)
+	if err != nil {
+		log.Error(err, "Invalid hub identity")
+
+		return 1
+	}
+
+	// the budget is shared by the reconcilers of all the managed clusters
+	hubAPIBudget, err := tool.NewHubAPIBudget()
+	if err != nil {
+		log.Error(err, "Invalid --hub-api-budget")
+
+		return 1
+	}
+
+	hubAPIBudget.Apply(hubCfg)
+	tool.TrackHubCommunication(hubCfg)
+
+	// the exclusions are shared by the reconcilers of all the managed clusters
+	policyExclusions, err := tool.NewPolicyExclusions()
+	if err != nil {
+		log.Error(err, "Invalid --exclude-policies")
+
+		return 1
+	}
+
+	var directHubClient client.Client
+
+	err = tool.RetryStartup("create the hub client", func() error {
+		var err error
+		directHubClient, err = hubClientFunc(nil)(hubCfg)
+
+		return err
+	})
+	if err != nil {
+		log.Error(err, "Failed to generate client to the hub cluster")
+
+		return 1
+	}
+
+	hubClient, err := tool.NewHubConnection(hubCfg, directHubClient, hubClientFunc(nil))
+	if err != nil {
+		log.Error(err, "Failed to set up the hub connection")
+
+		return 1
+	}
+
+	hubWriteFaults, err := tool.NewHubWriteFaults()
+	if err != nil {
+		log.Error(err, "Invalid hub write fault injection flags")
+
+		return 1
+	}
+
+	// the hub client is shared by the reconcilers of all the managed clusters
+	classifyingHubClient := tool.NewClassifyingClient(hubWriteFaults.Client(hubClient), tool.TargetHub)
+
+	var hubKubeClient kubernetes.Interface = kubernetes.NewForConfigOrDie(tool.ClientsetConfig(hubCfg))
+
+	// the events are recorded in the namespace of each policy, which is its cluster namespace on the hub
+	eventBroadcaster := tool.NewHubEventBroadcaster(hubKubeClient, "", tool.Options.HubEventsAPI)
+	defer eventBroadcaster.Shutdown()
+
+	hubRecorder := eventBroadcaster.NewRecorder(eventsScheme, eventComponent())
+
+	// the metrics of several clusters are only labeled with an explicit cluster name and identity, such as
+	// those of the hosting cluster, while the other records are attributed to each cluster namespace
+	tool.LabelMetrics(tool.Options.ClusterName, tool.Options.ClusterIdentity)
+
+	resyncPeriod := tool.ResyncPeriod(tool.Options.ClusterName)
+
+	var mgr manager.Manager
+
+	err = tool.RetryStartup("create the manager", func() error {
+		var err error
+		mgr, err = ctrl.NewManager(hostingCfg, manager.Options{
+			LeaderElection:   tool.Options.EnableLeaderElection,
+			LeaderElectionID: leaderElectionID,
+			// The metrics endpoint is disabled by default
+			MetricsBindAddress: tool.Options.MetricsAddr,
+			Scheme:             scheme,
+			// spread the resyncs of the agents that restarted together
+			SyncPeriod: &resyncPeriod,
+		})
+
+		return err
+	})
+	if err != nil {
+		log.Error(err, "unable to start manager")
+
+		return 1
+	}
+
+	if err := mgr.Add(hubClient); err != nil {
+		log.Error(err, "Failed to add the hub connection to the manager")
+
+		return 1
+	}
+
+	if err := mgr.Add(&tool.LeaderTracker{}); err != nil {
+		log.Error(err, "Failed to add the leader tracker to the manager")
+
+		return 1
+	}
+
+	if err := addHealthSummary(mgr, hostingCfg, true); err != nil {
+		log.Error(err, "Failed to add the health summary Event to the manager")
+
+		return 1
+	}
+
+	hubCapabilities, err := newHubCapabilities(mgr, hubCfg, tool.Options.ClusterName)
+	if err != nil {
+		log.Error(err, "Failed to set up the hub capability detection")
+
+		return 1
+	}
+
+	sinkControls, err := newSinkControls()
+	if err != nil {
+		log.Error(err, "Invalid --sink-controls")
+
+		return 1
+	}
+
+	historyReporter, err := newComplianceHistoryReporter(tool.Options.ClusterName)
+	if err != nil {
+		log.Error(err, "Failed to set up the compliance history API reporter")
+
+		return 1
+	}
+
+	if historyReporter != nil {
+		if err := mgr.Add(historyReporter); err != nil {
+			log.Error(err, "Failed to add the compliance history API reporter to the manager")
+
+			return 1
+		}
+	}
+
+	// the leader epoch is shared by the reconcilers of all the managed clusters, since they have the same leader
+	leaderEpoch := newLeaderEpoch(hostingCfg)
+
+	reconcilers := make([]*sync.PolicyReconciler, 0, len(clusters))
+
+	for _, managed := range clusters {
+		clusterName := managed.name
+
+		var managedCluster cluster.Cluster
+
+		err := tool.RetryStartup("set up the managed cluster", func() error {
+			var err error
+			managedCluster, err = cluster.New(managed.config, func(o *cluster.Options) {
+				o.Scheme = scheme
+				o.Namespace = clusterName
+				// the hub sync state ConfigMaps are only read on the first reconcile of each policy
+				o.ClientDisableCacheFor = []client.Object{&v1.ConfigMap{}}
+			})
+
+			return err
+		})
+		if err != nil {
+			log.Error(err, "Failed to set up the managed cluster", "cluster", clusterName)
+
+			return 1
+		}
+
+		if err := mgr.Add(managedCluster); err != nil {
+			log.Error(err, "Failed to add the managed cluster to the manager", "cluster", clusterName)
+
+			return 1
+		}
+
+		externalSinks, err := newSinks(clusterName, managed.config)
+		if err != nil {
+			log.Error(err, "Failed to set up the external sinks", "cluster", clusterName)
+
+			return 1
+		}
+
+		// the hub writes of each managed cluster are restricted to its cluster namespace
+		hubGuard := tool.NewHubNamespaceGuard(append([]string{clusterName}, tool.Options.HubWriteNamespaces...))
+
+		reconciler := newPolicyReconciler(reconcilerOptions{
+			clusterName:            clusterName,
+			clusterIdentity:        managed.identity,
+			historyReporter:        historyReporter,
+			hubFieldManager:        hubFieldManager,
+			hubAPIBudget:           hubAPIBudget,
+			policyExclusions:       policyExclusions,
+			sinks:                  externalSinks,
+			sinkControls:           sinkControls,
+			complianceScoreWeights: complianceScoreWeights,
+		})
+		reconciler.LeaderEpoch = leaderEpoch
+		reconciler.HubCapabilities = hubCapabilities
+		reconciler.HubClient = hubGuard.Client(classifyingHubClient)
+		reconciler.HubRecorder = hubGuard.Recorder(hubRecorder)
+		reconciler.ManagedClient = tool.NewClassifyingClient(managedCluster.GetClient(), tool.TargetManaged)
+		reconciler.ManagedRecorder = managedCluster.GetEventRecorderFor(eventComponent())
+		reconciler.Scheme = scheme
+
+		identifyEvents(reconciler)
+
+		err = reconciler.SetupWithCluster(mgr, managedCluster, sync.ControllerName+"-"+clusterName)
+		if err != nil {
+			log.Error(err, "unable to create controller", "controller", "Policy", "cluster", clusterName)
+
+			return 1
+		}
+
+		if tool.Options.ComplianceTrendInterval > 0 {
+			err := mgr.Add(&sync.ComplianceTrendReporter{
+				ManagedClient:   managedCluster.GetClient(),
+				HubClient:       hubKubeClient,
+				Namespace:       clusterName,
+				ClusterIdentity: managed.identity,
+				Interval:        tool.Options.ComplianceTrendInterval,
+				Retention:       tool.Options.ComplianceTrendRetention,
+				Exclusions:      policyExclusions,
+			})
+			if err != nil {
+				log.Error(err, "unable to set up the compliance trend reporter", "cluster", clusterName)
+
+				return 1
+			}
+		}
+
+		reconcilers = append(reconcilers, reconciler)
+
+		log.Info("Set up the policy status sync for the managed cluster", "cluster", clusterName)
+	}
+
+	configChecker, err := addonutils.NewConfigChecker(
+		"policy-status-sync", configCheckFiles(nil, hubCfg, hostingCfg)...,
+	)
+	if err != nil {
+		log.Error(err, "unable to setup a configChecker")
+
+		return 1
+	}
+
+	healthServer := tool.NewHealthServer(tool.Options.ProbeAddr)
+	healthServer.AddHealthzCheck("healthz", configChecker.Check)
+	healthServer.AddHealthzCheck(
+		"fan-in-secrets", (&fanInSecretsChecker{hostingCfg: hostingCfg, fingerprint: fingerprint}).Check,
+	)
+
+	if tool.Options.QueueStallTimeout > 0 {
+		healthServer.AddHealthzCheck("queue", sync.QueueStallCheck(tool.Options.QueueStallTimeout))
+	}
+
+	healthServer.AddReadyzCheck("readyz", healthz.Ping)
+	healthServer.AddReadyzCheck("api-auth", tool.AuthReadyzCheck)
+	healthServer.AddStartupzCheck("startupz", healthz.Ping)
+	healthServer.AddHandler("/debug/sync-errors", sync.SyncErrorsHandler())
+	healthServer.AddHandler(sync.RetriesPath, sync.RetriesHandler())
+	healthServer.AddHandler("/debug/config", tool.ConfigHandler())
+
+	if tool.Options.EnableSupportBundle {
+		supportBundle := tool.NewSupportBundle()
+		supportBundle.Add("health.json", healthServer.CheckResults)
+		supportBundle.Add("queue.json", sync.QueueState)
+		supportBundle.Add("sync-errors.json", sync.SyncErrors)
+		supportBundle.Add("retries.json", sync.Retries)
+		supportBundle.Add("policies.json", sync.PolicySyncStatuses(reconcilers...))
+		supportBundle.Add("replica.json", tool.ReplicaState)
+		supportBundle.Add("hub-connectivity.json", hubClient.Connectivity)
+
+		healthServer.AddHandler(tool.SupportBundlePath, supportBundle)
+	}
+
+	ctx := ctrl.SetupSignalHandler()
+
+	startLogLevelWatcher(ctx, logLevels, hostingCfg)
+	startPolicyExclusionWatcher(ctx, policyExclusions, hostingCfg)
+
+	go func() {
+		if err := healthServer.Start(ctx); err != nil {
+			log.Error(err, "problem running the health probe server")
+			os.Exit(1)
+		}
+	}()
+
+	log.Info("starting manager in fan-in mode", "clusters", len(clusters))
+
+	if err := mgr.Start(ctx); err != nil {
+		log.Error(err, "problem running manager")
+
+		return 1
+	}
+
+	return 0
+}
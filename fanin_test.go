@@ -0,0 +1,131 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package main
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/stolostron/governance-policy-status-sync/tool"
+)
+
+func fanInSecret(name string, data map[string]string) v1.Secret {
+	secret := v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name}, Data: map[string][]byte{}}
+
+	for key, value := range data {
+		secret.Data[key] = []byte(value)
+	}
+
+	return secret
+}
+
+func TestFanInFingerprint(t *testing.T) {
+	t.Parallel()
+
+	cluster1 := fanInSecret("cluster1", map[string]string{fanInKubeconfigKey: "kubeconfig1"})
+	cluster2 := fanInSecret("cluster2", map[string]string{fanInKubeconfigKey: "kubeconfig2"})
+	startup := fanInFingerprint([]v1.Secret{cluster1, cluster2})
+
+	tests := map[string]struct {
+		secrets []v1.Secret
+		changed bool
+	}{
+		"same secrets": {
+			secrets: []v1.Secret{cluster1, cluster2},
+		},
+		"other order": {
+			secrets: []v1.Secret{cluster2, cluster1},
+		},
+		"same cluster name from another secret": {
+			secrets: []v1.Secret{
+				cluster1,
+				fanInSecret("renamed", map[string]string{
+					fanInKubeconfigKey: "kubeconfig2", fanInClusterNameKey: "cluster2",
+				}),
+			},
+		},
+		"onboarded cluster": {
+			secrets: []v1.Secret{
+				cluster1, cluster2, fanInSecret("cluster3", map[string]string{fanInKubeconfigKey: "kubeconfig3"}),
+			},
+			changed: true,
+		},
+		"removed cluster": {
+			secrets: []v1.Secret{cluster1},
+			changed: true,
+		},
+		"changed kubeconfig": {
+			secrets: []v1.Secret{
+				cluster1, fanInSecret("cluster2", map[string]string{fanInKubeconfigKey: "rotated"}),
+			},
+			changed: true,
+		},
+		"changed identity": {
+			secrets: []v1.Secret{
+				cluster1,
+				fanInSecret("cluster2", map[string]string{
+					fanInKubeconfigKey: "kubeconfig2", fanInClusterIdentityKey: "identity2",
+				}),
+			},
+			changed: true,
+		},
+		"renamed cluster": {
+			secrets: []v1.Secret{
+				cluster1,
+				fanInSecret("cluster2", map[string]string{
+					fanInKubeconfigKey: "kubeconfig2", fanInClusterNameKey: "cluster3",
+				}),
+			},
+			changed: true,
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if changed := fanInFingerprint(test.secrets) != startup; changed != test.changed {
+				t.Fatalf("expected the fingerprint to change: %v, got: %v", test.changed, changed)
+			}
+		})
+	}
+}
+
+func TestFanInClusterIdentity(t *testing.T) {
+	// not parallel since the default identity is a global option
+	original := tool.Options.ClusterIdentity
+	defer func() { tool.Options.ClusterIdentity = original }()
+
+	tests := map[string]struct {
+		secret          v1.Secret
+		defaultIdentity string
+		expected        string
+	}{
+		"secret identity": {
+			secret:          fanInSecret("cluster1", map[string]string{fanInClusterIdentityKey: "identity1"}),
+			defaultIdentity: "fleet",
+			expected:        "identity1",
+		},
+		"cluster identity flag": {
+			secret:          fanInSecret("cluster1", map[string]string{}),
+			defaultIdentity: "fleet",
+			expected:        "fleet",
+		},
+		"cluster name": {
+			secret:   fanInSecret("secret1", map[string]string{fanInClusterNameKey: "cluster1"}),
+			expected: "cluster1",
+		},
+	}
+
+	for name, test := range tests {
+		tool.Options.ClusterIdentity = test.defaultIdentity
+
+		if identity := fanInClusterIdentity(test.secret); identity != test.expected {
+			t.Fatalf("%s: expected the identity %s, got %s", name, test.expected, identity)
+		}
+	}
+}
@@ -8,17 +8,23 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/spf13/pflag"
 	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
 
 	// to ensure that exec-entrypoint and run can make use of them.
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	k8sruntime "k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
@@ -28,16 +34,24 @@ import (
 	"k8s.io/client-go/tools/record"
 	"open-cluster-management.io/addon-framework/pkg/lease"
 	addonutils "open-cluster-management.io/addon-framework/pkg/utils"
+	addonclient "open-cluster-management.io/api/client/addon/clientset/versioned"
+	clusterv1alpha1 "open-cluster-management.io/api/cluster/v1alpha1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
+	"sigs.k8s.io/controller-runtime/pkg/cluster"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
+	"github.com/stolostron/governance-policy-status-sync/controllers/complianceapi"
 	"github.com/stolostron/governance-policy-status-sync/controllers/sync"
+	statuswebhook "github.com/stolostron/governance-policy-status-sync/controllers/webhook"
+	"github.com/stolostron/governance-policy-status-sync/nscache"
+	"github.com/stolostron/governance-policy-status-sync/sinks"
 	"github.com/stolostron/governance-policy-status-sync/tool"
 	"github.com/stolostron/governance-policy-status-sync/version"
 )
@@ -54,25 +68,105 @@ func printVersion() {
 	log.Info(fmt.Sprintf("Go OS/Arch: %s/%s", runtime.GOOS, runtime.GOARCH))
 }
 
+// leaseUpdatePeriod is the period of the addon framework lease updates, which is the lease duration
+const leaseUpdatePeriod = 60 * time.Second
+
+// addonLeaseName is the name of the addon framework lease that reports the status of the controller
+const addonLeaseName = "policy-controller"
+
+// leaderElectionID is the name of the leader election lease, whose leader transitions are the leader epoch
+const leaderElectionID = "policy-status-sync.open-cluster-management.io"
+
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 	utilruntime.Must(v1.AddToScheme(eventsScheme))
 	//+kubebuilder:scaffold:scheme
 	utilruntime.Must(policiesv1.AddToScheme(scheme))
+	utilruntime.Must(clusterv1alpha1.AddToScheme(scheme))
 }
 
 func main() {
+	// the bench subcommand drives the reconciler against envtest clusters instead of running the controller
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		os.Exit(runBench(os.Args[2:], os.Stdout))
+	}
+
+	// the preflight subcommand checks the compatibility of this agent version with the hub and managed clusters
+	if len(os.Args) > 1 && os.Args[1] == "preflight" {
+		os.Exit(runPreflight(os.Args[2:], os.Stdout))
+	}
+
 	// custom flags for the controler
 	tool.ProcessFlags()
 
+	// the zap flags set the log level, such as --zap-log-level=debug
+	zapOpts := zap.Options{DestWriter: io.MultiWriter(os.Stderr, tool.RecentLogs)}
+	zapOpts.BindFlags(flag.CommandLine)
+
 	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
 
 	pflag.Parse()
 
-	logf.SetLogger(zap.New())
+	// the --log-level overrides filter the logs of each subsystem, so zap logs at the most verbose level
+	logLevels, logLevelsErr := tool.NewLogLevels(
+		tool.Options.LogLevels, zapOpts.Level, tool.Options.LogLevelConfigMap != "",
+	)
+	if logLevels != nil {
+		zapOpts.Level = logLevels.ZapLevel()
+	}
+
+	logf.SetLogger(logLevels.Logger(zap.New(zap.UseFlagOptions(&zapOpts))))
+
+	if logLevelsErr != nil {
+		log.Error(logLevelsErr, "Invalid --log-level")
+		os.Exit(1)
+	}
+
+	if len(tool.Options.LogLevels) != 0 {
+		log.Info("Overriding the log levels of the subsystems", "levels", logLevels.String())
+	}
 
 	printVersion()
 
+	tool.ConfigureMemory()
+
+	if err := sync.ValidateSeverity(tool.Options.HistoryMinSeverity); err != nil {
+		log.Error(err, "Invalid --history-min-severity")
+		os.Exit(1)
+	}
+
+	complianceScoreWeights, err := sync.ParseComplianceScoreWeights(tool.Options.ComplianceScoreWeights)
+	if err != nil {
+		log.Error(err, "Invalid --compliance-score-weights")
+		os.Exit(1)
+	}
+
+	if tool.Options.HubWriteAudit && tool.Options.ComplianceHistoryOnly {
+		log.Error(errors.New("--hub-write-audit is not supported with --compliance-history-api-only, since "+
+			"the audit is recorded in the hub events"), "")
+		os.Exit(1)
+	}
+
+	if _, err := labels.Parse(tool.Options.NamespaceSelector); err != nil {
+		log.Error(err, "Invalid --namespace-selector")
+		os.Exit(1)
+	}
+
+	if err := tool.ValidateBindAddress(tool.Options.ProbeAddr); err != nil {
+		log.Error(err, "Invalid --health-probe-bind-address")
+		os.Exit(1)
+	}
+
+	if err := tool.ValidateBindAddress(tool.Options.MetricsAddr); err != nil {
+		log.Error(err, "Invalid --metrics-bind-address")
+		os.Exit(1)
+	}
+
+	if err := tool.ValidateStatusTransport(); err != nil {
+		log.Error(err, "Invalid --status-transport")
+		os.Exit(1)
+	}
+
 	// Get hubconfig to talk to hub apiserver
 	if tool.Options.HubConfigFilePathName == "" {
 		var found bool
@@ -84,64 +178,269 @@ func main() {
 		}
 	}
 
-	hubCfg, err := clientcmd.BuildConfigFromFlags("", tool.Options.HubConfigFilePathName)
+	// the in-memory hub for local development and the ManifestWork status transport have no config
+	hubCfg := &rest.Config{}
+
+	if !tool.Options.FakeHub && !tool.ManifestWorkTransport() {
+		hubCfg, err = loadConfig(tool.Options.HubConfigFilePathName)
+		if err != nil {
+			log.Error(err, "")
+			os.Exit(1)
+		}
+	}
+
+	// In fan-in mode, the managed cluster configurations come from Secrets on the hosting cluster
+	if tool.Options.FanInSecretSelector != "" {
+		if tool.Options.FakeHub {
+			log.Error(errors.New("--fake-hub is not supported in fan-in mode"), "")
+			os.Exit(1)
+		}
+
+		if tool.ManifestWorkTransport() {
+			log.Error(errors.New("--status-transport=manifestwork is not supported in fan-in mode"), "")
+			os.Exit(1)
+		}
+
+		hostingCfg, err := getConfig(&tool.Options.HostingConfigFilePathName, "HOSTING_CONFIG")
+		if err != nil {
+			log.Error(err, "")
+			os.Exit(1)
+		}
+
+		if err := tool.ApplyContentType(hubCfg, hostingCfg); err != nil {
+			log.Error(err, "")
+			os.Exit(1)
+		}
+
+		tool.LogSnapshot()
+		os.Exit(runFanIn(hubCfg, hostingCfg, complianceScoreWeights, logLevels))
+	}
+
+	// Get managedconfig to talk to managed apiserver
+	if tool.Options.Hosted && tool.Options.ManagedConfigFilePathName == "" {
+		if _, found := os.LookupEnv("MANAGED_CONFIG"); !found {
+			log.Error(errors.New("no managed cluster configuration was provided"),
+				"The managed cluster kubeconfig is required in hosted mode")
+			os.Exit(1)
+		}
+	}
+
+	managedCfg, err := getConfig(&tool.Options.ManagedConfigFilePathName, "MANAGED_CONFIG")
 	if err != nil {
 		log.Error(err, "")
 		os.Exit(1)
 	}
 
-	// Get managedconfig to talk to managed apiserver
-	var managedCfg *rest.Config
+	// with the ManifestWork status transport, the policies are only read and written on the managed cluster,
+	// as on a self-managed hub, and nothing connects to the hub
+	if tool.ManifestWorkTransport() {
+		log.Info("Reporting the status to the hub with the ManifestWork status feedback")
+	}
 
-	if tool.Options.ManagedConfigFilePathName == "" {
-		var found bool
+	// Get hostingconfig to talk to the hosting apiserver, where leader election and status reporting
+	// happen. Outside of hosted mode, this is the managed cluster.
+	hostingCfg := managedCfg
 
-		tool.Options.ManagedConfigFilePathName, found = os.LookupEnv("MANAGED_CONFIG")
-		if found {
-			log.Info("Found ENV MANAGED_CONFIG, initializing using", "tool.Options.ManagedConfigFilePathName",
-				tool.Options.ManagedConfigFilePathName)
+	if tool.Options.Hosted {
+		log.Info("Running in hosted mode")
 
-			managedCfg, err = clientcmd.BuildConfigFromFlags("", tool.Options.ManagedConfigFilePathName)
-			if err != nil {
-				log.Error(err, "")
-				os.Exit(1)
-			}
-		} else {
-			managedCfg, err = config.GetConfig()
-			if err != nil {
-				log.Error(err, "")
-				os.Exit(1)
-			}
+		hostingCfg, err = getConfig(&tool.Options.HostingConfigFilePathName, "HOSTING_CONFIG")
+		if err != nil {
+			log.Error(err, "")
+			os.Exit(1)
+		}
+	}
+
+	if err := tool.ApplyContentType(hubCfg, managedCfg, hostingCfg); err != nil {
+		log.Error(err, "")
+		os.Exit(1)
+	}
+
+	namespace, err := tool.GetWatchNamespace()
+	if err != nil && tool.Options.ClusterNameSource == "" {
+		log.Error(err, "Failed to get watch namespace")
+		os.Exit(1)
+	}
+
+	if tool.Options.ClusterNameSource != "" {
+		namespace, err = discoverClusterName(namespace, err == nil, hubCfg, hostingCfg)
+		if err != nil {
+			log.Error(err, "Failed to discover the cluster name", "source", tool.Options.ClusterNameSource)
+			os.Exit(1)
 		}
 	}
 
-	hubClient, err := client.New(hubCfg, client.Options{Scheme: scheme})
+	if tool.Options.CachePolicyEventsOnly && isMultiNamespace(namespace) {
+		log.Error(errors.New("it's only supported when watching a single namespace"),
+			"Invalid --cache-policy-events-only")
+		os.Exit(1)
+	}
+
+	tool.LogSnapshot()
+
+	// When the managed cluster is the hub itself (self-managed hub), the replicated policy on the managed
+	// cluster is the same object that the hub sees, so the hub client and recorder are not needed.
+	// The hub and managed API server hosts aren't compared, since the same proxy or load balancer URL can
+	// serve a different identity, which would silently turn off the hub writes.
+	localCluster := !tool.Options.FakeHub && (tool.Options.LocalCluster || tool.ManifestWorkTransport() ||
+		os.Getenv("ON_MULTICLUSTERHUB") == "true")
+
+	if !localCluster && !tool.Options.FakeHub && hubCfg.Host == managedCfg.Host {
+		log.Info("The hub and managed configurations point to the same API server, pass --local-cluster if the "+
+			"managed cluster is the hub itself", "host", hubCfg.Host)
+	}
+
+	clusterName := tool.Options.ClusterName
+	if clusterName == "" {
+		clusterName = namespace
+	}
+
+	// the metrics are labeled with the cluster namespace when several namespaces are watched
+	metricsClusterName := clusterName
+	if tool.Options.ClusterName == "" && isMultiNamespace(namespace) {
+		metricsClusterName = tool.Options.ClusterNamespace
+	}
+
+	// the records are attributed to the cluster namespace unless a cluster identity is set
+	clusterIdentity := tool.Options.ClusterIdentity
+	if clusterIdentity == "" {
+		clusterIdentity = tool.Options.ClusterNamespace
+	}
+
+	if clusterIdentity == "" && !isMultiNamespace(namespace) {
+		clusterIdentity = namespace
+	}
+
+	tool.LabelMetrics(metricsClusterName, clusterIdentity)
+
+	hubFieldManager, err := tool.ApplyHubIdentity(hubCfg, metricsClusterName)
+	if err != nil {
+		log.Error(err, "Invalid hub identity")
+		os.Exit(1)
+	}
+
+	hubAPIBudget, err := tool.NewHubAPIBudget()
 	if err != nil {
-		log.Error(err, "Failed to generate client to the hub cluster")
+		log.Error(err, "Invalid --hub-api-budget")
 		os.Exit(1)
 	}
-	var kubeClient kubernetes.Interface = kubernetes.NewForConfigOrDie(hubCfg)
 
-	eventBroadcaster := record.NewBroadcaster()
+	hubAPIBudget.Apply(hubCfg)
+	tool.TrackHubCommunication(hubCfg)
 
-	namespace, err := tool.GetWatchNamespace()
+	externalSinks, err := newSinks(clusterName, managedCfg)
 	if err != nil {
-		log.Error(err, "Failed to get watch namespace")
+		log.Error(err, "Failed to set up the external sinks")
+		os.Exit(1)
+	}
+
+	sinkControls, err := newSinkControls()
+	if err != nil {
+		log.Error(err, "Invalid --sink-controls")
+		os.Exit(1)
+	}
+
+	hubWriteFaults, err := tool.NewHubWriteFaults()
+	if err != nil {
+		log.Error(err, "Invalid hub write fault injection flags")
+		os.Exit(1)
+	}
+
+	policyExclusions, err := tool.NewPolicyExclusions()
+	if err != nil {
+		log.Error(err, "Invalid --exclude-policies")
+		os.Exit(1)
+	}
+
+	historyReporter, err := newComplianceHistoryReporter(clusterName)
+	if err != nil {
+		log.Error(err, "Failed to set up the compliance history API reporter")
 		os.Exit(1)
 	}
 
-	eventBroadcaster.StartRecordingToSink(&corev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events(namespace)})
-	hubRecorder := eventBroadcaster.NewRecorder(eventsScheme, v1.EventSource{Component: sync.ControllerName})
+	reconciler := newPolicyReconciler(reconcilerOptions{
+		clusterName:            clusterName,
+		clusterIdentity:        clusterIdentity,
+		historyReporter:        historyReporter,
+		hubFieldManager:        hubFieldManager,
+		hubAPIBudget:           hubAPIBudget,
+		policyExclusions:       policyExclusions,
+		sinks:                  externalSinks,
+		sinkControls:           sinkControls,
+		complianceScoreWeights: complianceScoreWeights,
+	})
+	reconciler.LocalCluster = localCluster
+
+	var eventBroadcaster *tool.HubEventBroadcaster
+
+	var hubCache cache.Cache
+
+	if localCluster {
+		log.Info("The managed cluster is the hub, using a single client for the hub and managed cluster")
+	} else if tool.Options.FakeHub {
+		log.Info("Using an in-memory hub, the hub writes are dumped", "file", tool.Options.FakeHubDumpFile)
+
+		fakeHub, err := newFakeHub(managedCfg)
+		if err != nil {
+			log.Error(err, "Failed to set up the fake hub")
+			os.Exit(1)
+		}
+
+		reconciler.HubClient = fakeHub.Client()
+		reconciler.HubRecorder = fakeHub.Recorder(eventComponent())
+	} else {
+		err = tool.RetryStartup("create the hub client", func() error {
+			var err error
+			reconciler.HubClient, hubCache, err = newHubClient(hubCfg, namespace)
+
+			return err
+		})
+		if err != nil {
+			log.Error(err, "Failed to generate client to the hub cluster")
+			os.Exit(1)
+		}
+		var kubeClient kubernetes.Interface = kubernetes.NewForConfigOrDie(tool.ClientsetConfig(hubCfg))
+
+		eventsNamespace := namespace
+		if isMultiNamespace(namespace) {
+			// the events are recorded in the namespace of each policy
+			eventsNamespace = ""
+		}
+
+		eventBroadcaster = tool.NewHubEventBroadcaster(kubeClient, eventsNamespace, tool.Options.HubEventsAPI)
+		reconciler.HubRecorder = eventBroadcaster.NewRecorder(eventsScheme, eventComponent())
+	}
+
+	if tool.Options.Once {
+		exitCode := runOnce(managedCfg, reconciler, namespace, hubWriteFaults)
+
+		if eventBroadcaster != nil {
+			eventBroadcaster.Shutdown()
+		}
+
+		os.Exit(exitCode)
+	}
+
+	resyncPeriod := tool.ResyncPeriod(clusterName)
 
 	options := manager.Options{
-		LeaderElection:         tool.Options.EnableLeaderElection,
-		LeaderElectionID:       "policy-status-sync.open-cluster-management.io",
-		HealthProbeBindAddress: tool.Options.ProbeAddr,
-		// Disable the metrics endpoint
-		MetricsBindAddress: "0",
+		LeaderElection:       tool.Options.EnableLeaderElection,
+		LeaderElectionID:     leaderElectionID,
+		LeaderElectionConfig: hostingCfg,
+		// The metrics endpoint is disabled by default
+		MetricsBindAddress: tool.Options.MetricsAddr,
 		Namespace:          namespace,
 		Scheme:             scheme,
+		// spread the resyncs of the clusters that restarted together
+		SyncPeriod: &resyncPeriod,
+		// the hub sync state ConfigMaps are only read on the first reconcile of each policy
+		ClientDisableCacheFor: []client.Object{&v1.ConfigMap{}},
 	}
+	if tool.Options.EnableStatusWebhook || tool.Options.EnableComplianceAPI || tool.Options.EnableStatusAPI {
+		options.Port = tool.Options.WebhookPort
+		options.CertDir = tool.Options.WebhookCertDir
+	}
+
 	if tool.Options.LegacyLeaderElection {
 		// If legacyLeaderElection is enabled, then that means the lease API is not available.
 		// In this case, use the legacy leader election method of a ConfigMap.
@@ -151,58 +450,330 @@ func main() {
 	// Note that this is not intended to be used for excluding namespaces, this is better done via a Predicate
 	// Also note that you may face performance issues when using this with a high number of namespaces.
 	// More Info: https://godoc.org/github.com/kubernetes-sigs/controller-runtime/pkg/cache#MultiNamespacedCacheBuilder
-	if strings.Contains(namespace, ",") {
+	// Unlike the controller-runtime cache, a namespace that fails to sync doesn't block the others.
+	if isMultiNamespace(namespace) {
 		options.Namespace = ""
-		options.NewCache = cache.MultiNamespacedCacheBuilder(strings.Split(namespace, ","))
+		options.NewCache = nscache.Builder(watchedNamespaces(namespace))
+	} else if tool.Options.CachePolicyEventsOnly {
+		options.NewCache = cache.BuilderWithOptions(cache.Options{
+			SelectorsByObject: cache.SelectorsByObject{
+				&v1.Event{}: {Field: fields.OneTermEqualSelector("involvedObject.kind", policiesv1.Kind)},
+			},
+		})
 	}
 
-	mgr, err := ctrl.NewManager(managedCfg, options)
+	var mgr manager.Manager
+
+	err = tool.RetryStartup("create the manager", func() error {
+		var err error
+		mgr, err = ctrl.NewManager(managedCfg, options)
+
+		return err
+	})
 	if err != nil {
 		log.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
-	if err = (&sync.PolicyReconciler{
-		HubClient:       hubClient,
-		HubRecorder:     hubRecorder,
-		ManagedClient:   mgr.GetClient(),
-		ManagedRecorder: mgr.GetEventRecorderFor(sync.ControllerName),
-		Scheme:          mgr.GetScheme(),
-	}).SetupWithManager(mgr); err != nil {
+	reconciler.ManagedClient = tool.NewClassifyingClient(mgr.GetClient(), tool.TargetManaged)
+	reconciler.ManagedRecorder = mgr.GetEventRecorderFor(eventComponent())
+	reconciler.Scheme = mgr.GetScheme()
+	reconciler.LeaderEpoch = newLeaderEpoch(hostingCfg)
+
+	// the hub connection is only monitored with a remote hub
+	var hubConnection *tool.HubConnection
+
+	if localCluster {
+		reconciler.HubClient = reconciler.ManagedClient
+		reconciler.HubRecorder = reconciler.ManagedRecorder
+	} else if tool.Options.FakeHub {
+		reconciler.HubClient = tool.NewClassifyingClient(hubWriteFaults.Client(reconciler.HubClient), tool.TargetHub)
+		guardHubWrites(reconciler, namespace)
+	} else {
+		hubConnection, err = tool.NewHubConnection(hubCfg, reconciler.HubClient, hubClientFunc(hubCache))
+		if err != nil {
+			log.Error(err, "Failed to set up the hub connection")
+			os.Exit(1)
+		}
+
+		if err := mgr.Add(hubConnection); err != nil {
+			log.Error(err, "Failed to add the hub connection to the manager")
+			os.Exit(1)
+		}
+
+		reconciler.HubClient = tool.NewClassifyingClient(hubWriteFaults.Client(hubConnection), tool.TargetHub)
+		guardHubWrites(reconciler, namespace)
+
+		reconciler.HubCapabilities, err = newHubCapabilities(mgr, hubCfg, clusterName)
+		if err != nil {
+			log.Error(err, "Failed to set up the hub capability detection")
+			os.Exit(1)
+		}
+	}
+
+	identifyEvents(reconciler)
+
+	if err = reconciler.SetupWithManager(mgr); err != nil {
 		log.Error(err, "unable to create controller", "controller", "Policy")
 		os.Exit(1)
 	}
 
+	if tool.Options.EnableStatusWebhook {
+		allowedNamespaces := []string{}
+
+		if operatorNs, err := tool.GetOperatorNamespace(); err == nil {
+			allowedNamespaces = append(allowedNamespaces, operatorNs)
+		}
+
+		log.Info("Starting the policy status webhook", "allowedNamespaces", allowedNamespaces,
+			"allowedUsers", tool.Options.StatusWebhookAllowedUsers)
+
+		mgr.GetWebhookServer().Register(statuswebhook.StatusWebhookPath, &webhook.Admission{
+			Handler: &statuswebhook.StatusValidator{
+				AllowedNamespaces: allowedNamespaces,
+				AllowedUsers:      tool.Options.StatusWebhookAllowedUsers,
+			},
+		})
+	}
+
+	if historyReporter != nil {
+		if err := mgr.Add(historyReporter); err != nil {
+			log.Error(err, "unable to set up the compliance history API reporter")
+			os.Exit(1)
+		}
+	}
+
+	if tool.Options.WarmStandby {
+		if err := mgr.Add(&sync.CacheWarmer{ManagedCache: mgr.GetCache(), HubCache: hubCache}); err != nil {
+			log.Error(err, "unable to set up the cache warmer")
+			os.Exit(1)
+		}
+	}
+
+	// the debug endpoints of a standby replica are labeled with its role and are read-only
+	if err := mgr.Add(&tool.LeaderTracker{}); err != nil {
+		log.Error(err, "unable to set up the leader tracker")
+		os.Exit(1)
+	}
+
+	if err := addHealthSummary(mgr, hostingCfg, !tool.Options.FakeHub && !localCluster); err != nil {
+		log.Error(err, "unable to set up the health summary Event")
+		os.Exit(1)
+	}
+
+	// the rotated client certificate files of the managed config are reloaded by the clients instead
+	var reloadedFiles []string
+
+	if tool.Options.ManagedCertRotation {
+		reloadedFiles = tool.ReloadedCertFiles(managedCfg)
+
+		err := mgr.Add(&tool.ManagedCertMonitor{
+			Kubeconfig: tool.Options.ManagedConfigFilePathName,
+			Config:     managedCfg,
+			Warning:    tool.Options.ManagedCertExpiryWarning,
+		})
+		if err != nil {
+			log.Error(err, "unable to set up the managed client certificate monitor")
+			os.Exit(1)
+		}
+	}
+
 	// use config check
-	configChecker, err := addonutils.NewConfigChecker("policy-status-sync", tool.Options.HubConfigFilePathName)
+	configChecker, err := addonutils.NewConfigChecker(
+		"policy-status-sync", configCheckFiles(reloadedFiles, hubCfg, managedCfg, hostingCfg)...,
+	)
 	if err != nil {
 		log.Error(err, "unable to setup a configChecker")
 		os.Exit(1)
 	}
 
+	// the health probes are served separately from the manager to support a startup probe
+	healthServer := tool.NewHealthServer(tool.Options.ProbeAddr)
+
 	//+kubebuilder:scaffold:builder
-	if err := mgr.AddHealthzCheck("healthz", configChecker.Check); err != nil {
-		log.Error(err, "unable to set up health check")
-		os.Exit(1)
+	healthServer.AddHealthzCheck("healthz", configChecker.Check)
+
+	if tool.Options.QueueStallTimeout > 0 {
+		healthServer.AddHealthzCheck("queue", sync.QueueStallCheck(tool.Options.QueueStallTimeout))
 	}
 
-	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
-		log.Error(err, "unable to set up ready check")
-		os.Exit(1)
+	healthServer.AddReadyzCheck("readyz", healthz.Ping)
+	healthServer.AddStartupzCheck("startupz", reconciler.StartupCheck(mgr))
+	healthServer.AddHandler("/debug/sync-errors", sync.SyncErrorsHandler())
+	healthServer.AddHandler(sync.RetriesPath, sync.RetriesHandler())
+	healthServer.AddHandler("/debug/config", tool.ConfigHandler())
+
+	var generatedClient kubernetes.Interface = kubernetes.NewForConfigOrDie(tool.ClientsetConfig(managedCfg))
+
+	if tool.Options.EnableComplianceAPI {
+		log.Info("Starting the policy compliance API", "path", complianceapi.CompliancePath)
+
+		mgr.GetWebhookServer().Register(complianceapi.CompliancePath, tool.ReadOnlyOnStandby(
+			&complianceapi.ComplianceAPI{
+				Client:     mgr.GetClient(),
+				Kubernetes: generatedClient,
+			},
+		))
+	}
+
+	if tool.Options.EnableStatusAPI {
+		log.Info("Starting the policy status API", "path", complianceapi.StatusPath)
+
+		mgr.GetWebhookServer().Register(complianceapi.StatusPath, tool.ReadOnlyOnStandby(
+			&complianceapi.StatusAPI{
+				Client:     mgr.GetClient(),
+				Kubernetes: generatedClient,
+				Resyncer:   reconciler,
+			},
+		))
+	}
+
+	// check the RBAC on each cluster up front, since missing permissions otherwise only show up as
+	// reconcile errors
+	permissionChecker := tool.NewPermissionChecker()
+	watchNamespaces := watchedNamespaces(namespace)
+
+	permissionChecker.AddCluster("managed", generatedClient, tool.ManagedPermissions(watchNamespaces))
+
+	if !localCluster && !tool.Options.FakeHub {
+		permissionChecker.AddCluster(
+			"hub", kubernetes.NewForConfigOrDie(tool.ClientsetConfig(hubCfg)), tool.HubPermissions(watchNamespaces),
+		)
+	}
+
+	permissionCtx, cancelPermissionCheck := context.WithTimeout(context.Background(), tool.PermissionCheckTimeout)
+
+	if _, err := permissionChecker.Run(permissionCtx); err != nil {
+		log.Error(err, "Failed to check the controller permissions, retrying from the startup probe")
+	}
+
+	cancelPermissionCheck()
+
+	healthServer.AddStartupzCheck("permissions", permissionChecker.StartupCheck)
+	healthServer.AddReadyzCheck("permissions", permissionChecker.Check)
+	healthServer.AddReadyzCheck("api-auth", tool.AuthReadyzCheck)
+
+	if tool.Options.EnableSupportBundle {
+		supportBundle := tool.NewSupportBundle()
+		supportBundle.Add("health.json", healthServer.CheckResults)
+		supportBundle.Add("queue.json", sync.QueueState)
+		supportBundle.Add("sync-errors.json", sync.SyncErrors)
+		supportBundle.Add("retries.json", sync.Retries)
+		supportBundle.Add("policies.json", sync.PolicySyncStatuses(reconciler))
+		supportBundle.Add("replica.json", tool.ReplicaState)
+
+		if hubConnection != nil {
+			supportBundle.Add("hub-connectivity.json", hubConnection.Connectivity)
+		}
+
+		healthServer.AddHandler(tool.SupportBundlePath, supportBundle)
+	}
+
+	if tool.Options.NamespaceSelector != "" {
+		caches := []*nscache.MultiNamespaceCache{}
+
+		for _, namespaceCache := range []cache.Cache{mgr.GetCache(), hubCache} {
+			if multiCache, ok := namespaceCache.(*nscache.MultiNamespaceCache); ok {
+				caches = append(caches, multiCache)
+			}
+		}
+
+		err := mgr.Add(&nscache.NamespaceWatcher{
+			Client:   generatedClient,
+			Selector: tool.Options.NamespaceSelector,
+			Caches:   caches,
+		})
+		if err != nil {
+			log.Error(err, "unable to set up the namespace watcher")
+			os.Exit(1)
+		}
 	}
 
 	// create namespace with labels
-	var generatedClient kubernetes.Interface = kubernetes.NewForConfigOrDie(managedCfg)
-	if err := tool.CreateClusterNs(&generatedClient, namespace); err != nil {
+	err = tool.RetryStartup("create the cluster namespace", func() error {
+		return tool.CreateClusterNs(&generatedClient, namespace)
+	})
+	if err != nil {
 		log.Error(err, "")
 		os.Exit(1)
 	}
 
+	if tool.Options.HubAPIBudgetLease != "" {
+		if hubAPIBudget == nil || isMultiNamespace(namespace) {
+			log.Error(errors.New("it requires the --hub-api-budget and a single cluster namespace"),
+				"Invalid --hub-api-budget-lease")
+			os.Exit(1)
+		}
+
+		err := mgr.Add(&tool.SharedHubAPIBudget{
+			Budget:    hubAPIBudget,
+			Client:    generatedClient,
+			Namespace: namespace,
+			Name:      tool.Options.HubAPIBudgetLease,
+			Holder:    eventComponent(),
+		})
+		if err != nil {
+			log.Error(err, "unable to set up the shared hub API budget")
+			os.Exit(1)
+		}
+	}
+
+	// keep the labels of the cluster namespace converged after it's created
+	err = mgr.Add(&tool.ClusterNamespaceKeeper{
+		Client:    generatedClient,
+		Namespace: namespace,
+		Recorder:  tool.IdentityRecorder(mgr.GetEventRecorderFor(eventComponent()), clusterIdentity),
+	})
+	if err != nil {
+		log.Error(err, "unable to set up the cluster namespace keeper")
+		os.Exit(1)
+	}
+
+	if tool.Options.ComplianceScoreClaim {
+		// the claim is read directly, since it's the only cluster-scoped object of the controller
+		claimClient, err := client.New(managedCfg, client.Options{Scheme: scheme})
+		if err == nil {
+			err = mgr.Add(&sync.ComplianceScoreClaim{
+				Client: claimClient, ClusterName: clusterName, ClusterIdentity: clusterIdentity,
+			})
+		}
+
+		if err != nil {
+			log.Error(err, "unable to set up the compliance score ClusterClaim")
+			os.Exit(1)
+		}
+	}
+
+	if tool.Options.ComplianceTrendInterval > 0 {
+		if tool.Options.FakeHub || tool.ManifestWorkTransport() || isMultiNamespace(namespace) {
+			log.Error(errors.New("it requires a hub and a single cluster namespace"),
+				"Invalid --compliance-trend-interval")
+			os.Exit(1)
+		}
+
+		err := mgr.Add(&sync.ComplianceTrendReporter{
+			ManagedClient:   mgr.GetClient(),
+			HubClient:       kubernetes.NewForConfigOrDie(tool.ClientsetConfig(hubCfg)),
+			Namespace:       namespace,
+			ClusterIdentity: clusterIdentity,
+			Interval:        tool.Options.ComplianceTrendInterval,
+			Retention:       tool.Options.ComplianceTrendRetention,
+			Exclusions:      policyExclusions,
+		})
+		if err != nil {
+			log.Error(err, "unable to set up the compliance trend reporter")
+			os.Exit(1)
+		}
+	}
+
 	// This lease is not related to leader election. This is to report the status of the controller
 	// to the addon framework. This can be seen in the "status" section of the ManagedClusterAddOn
 	// resource objects.
 	if tool.Options.EnableLease {
 		ctx := context.TODO()
+		// the in-memory hub and the ManifestWork status transport have no hub connection, so the lease and
+		// the addon condition are only reported on the hosting cluster
+		hubConnected := !tool.Options.FakeHub && !tool.ManifestWorkTransport()
 
 		operatorNs, err := tool.GetOperatorNamespace()
 		if err != nil {
@@ -214,26 +785,631 @@ func main() {
 			}
 		} else {
 			log.Info("Starting lease controller to report status")
+
+			var hostingClient kubernetes.Interface = kubernetes.NewForConfigOrDie(tool.ClientsetConfig(hostingCfg))
+
 			leaseUpdater := lease.NewLeaseUpdater(
-				generatedClient,
-				"policy-controller",
+				hostingClient,
+				addonLeaseName,
 				operatorNs,
-				lease.CheckAddonPodFunc(generatedClient.CoreV1(), operatorNs, "app=policy-framework"),
+				tool.ObserveHealthCheck("policy-framework",
+					lease.CheckAddonPodFunc(hostingClient.CoreV1(), operatorNs, "app=policy-framework")),
 				// this additional CheckAddonPodFunc is temporary until the
 				// addon framework independently verifies the config-policy-controller via its lease
 				// see https://github.com/stolostron/backlog/issues/11508
-				lease.CheckAddonPodFunc(generatedClient.CoreV1(), operatorNs, "app=policy-config-policy"),
-			).WithHubLeaseConfig(hubCfg, namespace)
-			go leaseUpdater.Start(ctx)
+				tool.ObserveHealthCheck("config-policy-controller",
+					lease.CheckAddonPodFunc(hostingClient.CoreV1(), operatorNs, "app=policy-config-policy")),
+			)
+			if hubConnected {
+				leaseUpdater = leaseUpdater.WithHubLeaseConfig(tool.ClientsetConfig(hubCfg), namespace)
+			}
+
+			go func() {
+				// spread the heartbeats of the clusters that restarted together over the lease update period
+				if tool.WaitForPhase(ctx, clusterName, leaseUpdatePeriod) {
+					leaseUpdater.Start(ctx)
+				}
+			}()
+
+			leaseMonitor := &tool.LeaseMonitor{
+				Client:       hostingClient,
+				Namespace:    operatorNs,
+				Name:         addonLeaseName,
+				HubNamespace: namespace,
+				Interval:     leaseUpdatePeriod,
+			}
+			if hubConnected {
+				if hubClient, err := kubernetes.NewForConfig(tool.ClientsetConfig(hubCfg)); err == nil {
+					leaseMonitor.HubClient = hubClient
+				}
+			}
+
+			go leaseMonitor.Start(ctx)
+
+			if tool.Options.SelfMonitor {
+				selfMonitor := &tool.SelfMonitor{
+					Client:       hostingClient,
+					Namespace:    operatorNs,
+					Pod:          os.Getenv("HOSTNAME"),
+					HubNamespace: namespace,
+					AddonName:    tool.Options.AddonName,
+					Interval:     leaseUpdatePeriod,
+				}
+				if hubConnected {
+					if hubClient, err := addonclient.NewForConfig(tool.ClientsetConfig(hubCfg)); err == nil {
+						selfMonitor.HubClient = hubClient
+					}
+				}
+
+				go selfMonitor.Start(ctx)
+			}
 		}
 	} else {
 		log.Info("Status reporting is not enabled")
 	}
 
+	ctx := ctrl.SetupSignalHandler()
+
+	startLogLevelWatcher(ctx, logLevels, hostingCfg)
+	startPolicyExclusionWatcher(ctx, policyExclusions, hostingCfg)
+
+	if tool.Options.HubConditionInterval > 0 && !tool.Options.FakeHub && !tool.ManifestWorkTransport() &&
+		!isMultiNamespace(namespace) {
+		hubClient, err := addonclient.NewForConfig(tool.ClientsetConfig(hubCfg))
+		if err != nil {
+			log.Error(err, "Failed to create the hub client to report the hub communication condition")
+			os.Exit(1)
+		}
+
+		hubCommunicationReporter := &tool.HubCommunicationReporter{
+			HubClient:    hubClient,
+			HubNamespace: namespace,
+			AddonName:    tool.Options.AddonName,
+			Interval:     tool.Options.HubConditionInterval,
+		}
+
+		go hubCommunicationReporter.Start(ctx)
+	}
+
+	go func() {
+		if err := healthServer.Start(ctx); err != nil {
+			log.Error(err, "problem running the health probe server")
+			os.Exit(1)
+		}
+	}()
+
 	log.Info("starting manager")
 
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	if err := mgr.Start(ctx); err != nil {
 		log.Error(err, "problem running manager")
 		os.Exit(1)
 	}
 }
+
+// getConfig builds a REST config from the kubeconfig at pathName. If pathName is empty, the kubeconfig
+// path is read from the envVar environment variable and stored in pathName. If neither is set, the
+// default configuration (e.g. the in-cluster configuration) is used.
+func getConfig(pathName *string, envVar string) (*rest.Config, error) {
+	if *pathName == "" {
+		var found bool
+
+		*pathName, found = os.LookupEnv(envVar)
+		if !found {
+			return config.GetConfig()
+		}
+
+		log.Info(fmt.Sprintf("Found ENV %s, initializing using", envVar), "path", *pathName)
+	}
+
+	return loadConfig(*pathName)
+}
+
+// configCheckFiles returns the mounted files that the config checker watches, so that rotating any of them
+// restarts the container. These are the kubeconfigs, the certificate files that the configs reference, and
+// the credential files of the external sinks. Service account tokens are excluded since they are rotated
+// regularly and are reloaded by the clients, as are the reloaded files, such as the rotated client
+// certificate files of the managed config.
+func configCheckFiles(reloaded []string, cfgs ...*rest.Config) []string {
+	candidates := []string{
+		tool.Options.HubConfigFilePathName,
+		tool.Options.ManagedConfigFilePathName,
+		tool.Options.HostingConfigFilePathName,
+	}
+
+	for _, cfg := range cfgs {
+		candidates = append(candidates, cfg.TLSClientConfig.CAFile, cfg.TLSClientConfig.CertFile,
+			cfg.TLSClientConfig.KeyFile)
+	}
+
+	candidates = append(candidates, tool.Options.MQTTPasswordFile, tool.Options.MQTTCAFile,
+		tool.Options.MQTTCertFile, tool.Options.MQTTKeyFile, tool.Options.ComplianceHistoryToken,
+		tool.Options.ComplianceHistoryCAFile, tool.Options.ProbeCertFile, tool.Options.ProbeKeyFile)
+
+	files := []string{}
+	seen := map[string]bool{}
+
+	for _, file := range reloaded {
+		seen[file] = true
+	}
+
+	for _, file := range candidates {
+		if file == "" || seen[file] {
+			continue
+		}
+
+		seen[file] = true
+
+		files = append(files, file)
+	}
+
+	return files
+}
+
+// loadConfig loads the kubeconfig at the path, waiting for it to be mounted if it doesn't exist yet. An
+// empty path uses the in-cluster config.
+func loadConfig(path string) (*rest.Config, error) {
+	var cfg *rest.Config
+
+	err := tool.RetryStartup("load the kubeconfig", func() error {
+		if path != "" {
+			if err := tool.RequireFile(path); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		cfg, err = clientcmd.BuildConfigFromFlags("", path)
+
+		return tool.Permanent(err)
+	})
+
+	return cfg, err
+}
+
+// newFakeHub returns an in-memory hub that seeds its policies from the managed cluster and dumps the hub
+// writes to --fake-hub-dump-file, or to stdout if it's empty
+func newFakeHub(managedCfg *rest.Config) (*tool.FakeHub, error) {
+	managedClient, err := client.New(managedCfg, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, err
+	}
+
+	var dump io.Writer = os.Stdout
+
+	if tool.Options.FakeHubDumpFile != "" {
+		dump, err = os.OpenFile(tool.Options.FakeHubDumpFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open the fake hub dump file: %w", err)
+		}
+	}
+
+	return tool.NewFakeHub(scheme, managedClient, dump), nil
+}
+
+// newHubCapabilities detects the policy status capabilities of the hub and adds the HubCapabilities to the
+// manager to refresh them periodically, offset by the phase of the cluster name
+func newHubCapabilities(
+	mgr manager.Manager, hubCfg *rest.Config, clusterName string,
+) (*sync.HubCapabilities, error) {
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(tool.ClientsetConfig(hubCfg))
+	if err != nil {
+		return nil, err
+	}
+
+	capabilities := sync.NewHubCapabilities(discoveryClient, clusterName)
+	capabilities.Detect()
+
+	if err := mgr.Add(capabilities); err != nil {
+		return nil, err
+	}
+
+	return capabilities, nil
+}
+
+// eventComponent returns the source component of the recorded events
+func eventComponent() string {
+	if tool.Options.EventComponent == "" {
+		return sync.ControllerName
+	}
+
+	return tool.Options.EventComponent
+}
+
+// guardHubWrites restricts the hub writes of the reconciler, including events, to the hub namespaces that
+// this agent owns. These are the --hub-write-namespaces, or else the cluster name, or else the namespaces
+// listed in WATCH_NAMESPACE. The guard is disabled if the namespaces are only selected with a label
+// selector.
+func guardHubWrites(reconciler *sync.PolicyReconciler, namespace string) {
+	allowed := tool.Options.HubWriteNamespaces
+
+	switch {
+	case len(allowed) != 0:
+	case tool.Options.ClusterName != "":
+		allowed = []string{tool.Options.ClusterName}
+	case namespace != "" && tool.Options.NamespaceSelector == "":
+		allowed = watchedNamespaces(namespace)
+	default:
+		log.Info("Not restricting the hub writes to the cluster namespace since it's unknown, set " +
+			"--cluster-name or --hub-write-namespaces to restrict them")
+
+		return
+	}
+
+	log.Info("Restricting the hub writes to the allowed hub namespaces", "namespaces", allowed)
+
+	guard := tool.NewHubNamespaceGuard(allowed)
+	reconciler.HubClient = guard.Client(reconciler.HubClient)
+	reconciler.HubRecorder = guard.Recorder(reconciler.HubRecorder)
+}
+
+// startLogLevelWatcher updates the log levels from the --log-level-configmap in the namespace of the agent
+// until the context is done
+func startLogLevelWatcher(ctx context.Context, logLevels *tool.LogLevels, hostingCfg *rest.Config) {
+	if tool.Options.LogLevelConfigMap == "" {
+		return
+	}
+
+	operatorNs, err := tool.GetOperatorNamespace()
+	if err != nil {
+		log.Error(err, "Failed to get the namespace of the log level ConfigMap")
+
+		return
+	}
+
+	watcher := &tool.LogLevelWatcher{
+		Client:    kubernetes.NewForConfigOrDie(tool.ClientsetConfig(hostingCfg)),
+		Namespace: operatorNs,
+		Name:      tool.Options.LogLevelConfigMap,
+		Levels:    logLevels,
+	}
+
+	log.Info("Watching the log level ConfigMap", "Namespace", operatorNs, "Name", tool.Options.LogLevelConfigMap)
+
+	go watcher.Start(ctx)
+}
+
+// addHealthSummary adds the HealthSummary that keeps the health summary Event in the namespace of the agent
+// up to date at the --health-summary-interval, if it's set. The hub connectivity is only summarized if checkHub
+// is true.
+func addHealthSummary(mgr manager.Manager, hostingCfg *rest.Config, checkHub bool) error {
+	if tool.Options.HealthSummaryInterval <= 0 {
+		return nil
+	}
+
+	operatorNs, err := tool.GetOperatorNamespace()
+	if err != nil {
+		return err
+	}
+
+	return mgr.Add(&sync.HealthSummary{
+		Client:    kubernetes.NewForConfigOrDie(tool.ClientsetConfig(hostingCfg)),
+		Namespace: operatorNs,
+		Pod:       os.Getenv("HOSTNAME"),
+		Component: eventComponent(),
+		Interval:  tool.Options.HealthSummaryInterval,
+		CheckHub:  checkHub,
+	})
+}
+
+// startPolicyExclusionWatcher updates the policy exclusions from the --policy-exclusions-configmap in the
+// namespace of the agent until the context is done
+func startPolicyExclusionWatcher(ctx context.Context, exclusions *tool.PolicyExclusions, hostingCfg *rest.Config) {
+	if tool.Options.PolicyExclusionsConfigMap == "" || exclusions == nil {
+		return
+	}
+
+	operatorNs, err := tool.GetOperatorNamespace()
+	if err != nil {
+		log.Error(err, "Failed to get the namespace of the policy exclusions ConfigMap")
+
+		return
+	}
+
+	watcher := &tool.PolicyExclusionWatcher{
+		Client:     kubernetes.NewForConfigOrDie(tool.ClientsetConfig(hostingCfg)),
+		Namespace:  operatorNs,
+		Name:       tool.Options.PolicyExclusionsConfigMap,
+		Exclusions: exclusions,
+	}
+
+	log.Info("Watching the policy exclusions ConfigMap", "Namespace", operatorNs,
+		"Name", tool.Options.PolicyExclusionsConfigMap)
+
+	go watcher.Start(ctx)
+}
+
+// newLeaderEpoch returns the LeaderEpoch that reads the leader election lease on the hosting cluster, so
+// that the hub writes of a deposed leader are fenced. It returns nil if leader election doesn't use a lease.
+func newLeaderEpoch(hostingCfg *rest.Config) *sync.LeaderEpoch {
+	if !tool.Options.EnableLeaderElection || tool.Options.LegacyLeaderElection {
+		return nil
+	}
+
+	namespace, err := tool.GetOperatorNamespace()
+	if err != nil {
+		log.Info("Not fencing the hub writes of deposed leaders since the leader election namespace is unknown",
+			"error", err.Error())
+
+		return nil
+	}
+
+	hostingClient, err := kubernetes.NewForConfig(tool.ClientsetConfig(hostingCfg))
+	if err != nil {
+		log.Error(err, "Failed to create the client for the leader election lease, not fencing the hub writes")
+
+		return nil
+	}
+
+	return &sync.LeaderEpoch{Client: hostingClient, Namespace: namespace, Name: leaderElectionID}
+}
+
+// identifyEvents annotates the hub and managed events of the reconciler with its cluster identity. It must be
+// called once the recorders of the reconciler are set.
+func identifyEvents(reconciler *sync.PolicyReconciler) {
+	reconciler.HubRecorder = tool.IdentityRecorder(reconciler.HubRecorder, reconciler.ClusterIdentity)
+	reconciler.ManagedRecorder = tool.IdentityRecorder(reconciler.ManagedRecorder, reconciler.ClusterIdentity)
+}
+
+// discoverClusterName discovers the cluster name from the --cluster-name-source and sets it as the cluster
+// name if --cluster-name isn't set. It returns the namespace to watch, which is the discovered cluster name
+// if WATCH_NAMESPACE isn't set. It returns an error if a single watched namespace or the cluster name flag
+// don't match the discovered cluster name, since the status would then be synced to the wrong cluster
+// namespace on the hub.
+func discoverClusterName(
+	namespace string, namespaceSet bool, hubCfg *rest.Config, hostingCfg *rest.Config,
+) (string, error) {
+	discovered, err := tool.DiscoverClusterName(
+		tool.Options.ClusterNameSource, tool.Options.HubConfigFilePathName, hubCfg, hostingCfg,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	log.Info("Discovered the cluster name", "source", tool.Options.ClusterNameSource, "clusterName", discovered)
+
+	if tool.Options.ClusterName == "" {
+		tool.Options.ClusterName = discovered
+	} else if tool.Options.ClusterName != discovered {
+		return "", fmt.Errorf("the --cluster-name %s doesn't match the discovered cluster name %s",
+			tool.Options.ClusterName, discovered)
+	}
+
+	if !namespaceSet {
+		return discovered, nil
+	}
+
+	if namespace != "" && !isMultiNamespace(namespace) && namespace != discovered {
+		return "", fmt.Errorf("the WATCH_NAMESPACE %s doesn't match the discovered cluster name %s", namespace,
+			discovered)
+	}
+
+	return namespace, nil
+}
+
+// isMultiNamespace returns true if multiple namespaces are watched, either from a WATCH_NAMESPACE list or
+// from the namespace selector
+func isMultiNamespace(namespace string) bool {
+	return strings.Contains(namespace, ",") || tool.Options.NamespaceSelector != ""
+}
+
+// watchedNamespaces returns the namespaces listed in WATCH_NAMESPACE without the empty and duplicate entries,
+// since an empty namespace would watch the whole cluster. An empty WATCH_NAMESPACE watches all namespaces. With
+// --namespace-selector, the list is empty since the namespaces are only the ones matching the selector.
+func watchedNamespaces(namespace string) []string {
+	if tool.Options.NamespaceSelector != "" {
+		return []string{}
+	}
+
+	namespaces := []string{}
+	seen := map[string]bool{}
+
+	for _, ns := range strings.Split(namespace, ",") {
+		ns = strings.TrimSpace(ns)
+		if ns == "" || seen[ns] {
+			continue
+		}
+
+		seen[ns] = true
+		namespaces = append(namespaces, ns)
+	}
+
+	if len(namespaces) == 0 {
+		return []string{metav1.NamespaceAll}
+	}
+
+	return namespaces
+}
+
+// reconcilerOptions are the settings of a PolicyReconciler that aren't read from the flags, since they are
+// set up separately in the single cluster and fan-in modes
+type reconcilerOptions struct {
+	clusterName            string
+	clusterIdentity        string
+	historyReporter        *sinks.ComplianceHistoryReporter
+	hubFieldManager        string
+	hubAPIBudget           *tool.HubAPIBudget
+	policyExclusions       *tool.PolicyExclusions
+	sinks                  []sinks.Sink
+	sinkControls           map[string]sinks.SinkControls
+	complianceScoreWeights map[string]float64
+}
+
+// newPolicyReconciler returns the PolicyReconciler of the flags and options, which is shared by the single
+// cluster and fan-in modes so that a flag can't be missed in one of them. The caller sets the clients, the
+// recorders, and the settings that only apply to its mode.
+func newPolicyReconciler(opts reconcilerOptions) *sync.PolicyReconciler {
+	return &sync.PolicyReconciler{
+		AllHubEvents:             tool.Options.AllHubEvents,
+		ClusterName:              opts.clusterName,
+		ClusterIdentity:          opts.clusterIdentity,
+		DisableHubEvents:         tool.Options.ComplianceHistoryOnly,
+		EventCacheSize:           tool.Options.EventCacheSize,
+		EventComponent:           tool.Options.EventComponent,
+		EventLookback:            tool.Options.EventLookback,
+		HistoryMinSeverity:       tool.Options.HistoryMinSeverity,
+		HistorySummaryEntries:    tool.Options.HistorySummaryEntries,
+		HistoryReporter:          opts.historyReporter,
+		HubWriteAudit:            hubWriteAudit(),
+		HubDryRun:                tool.Options.HubDryRun,
+		HubServerSideApply:       tool.Options.HubServerSideApply,
+		HubFieldManager:          opts.hubFieldManager,
+		HubStatusIntegrity:       tool.Options.HubStatusIntegrity,
+		HubStatusPatch:           tool.Options.HubStatusPatch,
+		HubAPIBudget:             opts.hubAPIBudget,
+		HubConsistencyInterval:   tool.Options.HubConsistencyInterval,
+		MaxWatchedPolicies:       tool.Options.MaxWatchedPolicies,
+		PolicyOverflow:           tool.Options.PolicyOverflow,
+		PolicyOverflowInterval:   tool.Options.PolicyOverflowInterval,
+		PolicyExclusions:         opts.policyExclusions,
+		UnknownCompliance:        tool.Options.UnknownCompliance,
+		KeepHistoryOnHubRecreate: tool.Options.KeepHistoryOnHubRecreate,
+		LogBudget:                tool.Options.LogBudget,
+		MaxHubPolicyBytes:        tool.Options.MaxHubPolicyBytes,
+		HubNamespaceLabel:        tool.Options.HubNamespaceLabel,
+		RootPolicyLabels:         tool.Options.RootPolicyLabels,
+		Sinks:                    opts.sinks,
+		SinkControls:             opts.sinkControls,
+		SpecDriftAudit:           tool.Options.SpecDriftAudit,
+		HubWriteCoalescingWindow: tool.Options.HubWriteCoalescingWindow,
+		ComplianceScoreWeights:   opts.complianceScoreWeights,
+		PolicySetMembership:      tool.Options.PolicySetMembership,
+		StatusHeartbeatInterval:  tool.Options.StatusHeartbeatInterval,
+		StatusSyncHealth:         tool.Options.StatusSyncHealth,
+		TemplateStaleThreshold:   tool.Options.TemplateStaleThreshold,
+		MarkStoppedOnShutdown:    tool.Options.MarkStoppedOnShutdown,
+	}
+}
+
+// hubWriteAudit returns the identity of this agent that annotates the hub events of the status writes, or
+// nil if the hub write audit is disabled
+func hubWriteAudit() *sync.HubWriteAudit {
+	if !tool.Options.HubWriteAudit {
+		return nil
+	}
+
+	return &sync.HubWriteAudit{Pod: os.Getenv("HOSTNAME"), Version: version.Version}
+}
+
+// newHubClient returns a client to the hub cluster. When warm standby is enabled and the controller is not
+// running once, reads of policies are served from the returned hub cache, which must be started separately,
+// so that the hub view is already synced when this instance becomes the leader. Otherwise, the returned
+// cache is nil and the client reads directly from the hub.
+func newHubClient(hubCfg *rest.Config, namespace string) (client.Client, cache.Cache, error) {
+	if !tool.Options.WarmStandby || tool.Options.Once {
+		hubClient, err := hubClientFunc(nil)(hubCfg)
+
+		return hubClient, nil, err
+	}
+
+	newCache := cache.New
+	if isMultiNamespace(namespace) {
+		newCache = nscache.Builder(watchedNamespaces(namespace))
+		namespace = ""
+	}
+
+	hubCache, err := newCache(hubCfg, cache.Options{Scheme: scheme, Namespace: namespace})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hubClient, err := hubClientFunc(hubCache)(hubCfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return hubClient, hubCache, nil
+}
+
+// hubClientFunc returns a function that builds a hub client, which reads from the hub cache if it's not nil.
+// A rebuilt client still reads from the same cache, since the informers reconnect on their own.
+func hubClientFunc(hubCache cache.Cache) tool.HubClientFunc {
+	return func(cfg *rest.Config) (client.Client, error) {
+		if hubCache == nil {
+			return client.New(cfg, client.Options{Scheme: scheme})
+		}
+
+		return cluster.DefaultNewClient(hubCache, cfg, client.Options{Scheme: scheme})
+	}
+}
+
+// runOnce syncs the status of every policy in the watched namespaces a single time without starting the
+// manager, and returns the exit code for the process. The reconciler must already have its hub client and
+// recorder set unless it is running on a self-managed hub. The hubWriteFaults are injected in the hub writes
+// if it's not nil.
+func runOnce(
+	managedCfg *rest.Config, reconciler *sync.PolicyReconciler, namespace string, hubWriteFaults *tool.HubWriteFaults,
+) int {
+	var managedClient client.Client
+
+	err := tool.RetryStartup("create the managed cluster client", func() error {
+		var err error
+		managedClient, err = client.New(managedCfg, client.Options{Scheme: scheme})
+
+		return err
+	})
+	if err != nil {
+		log.Error(err, "Failed to generate client to the managed cluster")
+
+		return 1
+	}
+
+	var managedKubeClient kubernetes.Interface = kubernetes.NewForConfigOrDie(tool.ClientsetConfig(managedCfg))
+
+	managedBroadcaster := record.NewBroadcaster()
+	defer managedBroadcaster.Shutdown()
+
+	managedBroadcaster.StartRecordingToSink(
+		&corev1.EventSinkImpl{Interface: managedKubeClient.CoreV1().Events("")},
+	)
+
+	reconciler.ManagedClient = tool.NewClassifyingClient(managedClient, tool.TargetManaged)
+	reconciler.ManagedRecorder = managedBroadcaster.NewRecorder(scheme, v1.EventSource{Component: eventComponent()})
+	reconciler.Scheme = scheme
+
+	if reconciler.LocalCluster {
+		reconciler.HubClient = reconciler.ManagedClient
+		reconciler.HubRecorder = reconciler.ManagedRecorder
+	} else {
+		reconciler.HubClient = tool.NewClassifyingClient(hubWriteFaults.Client(reconciler.HubClient), tool.TargetHub)
+		guardHubWrites(reconciler, namespace)
+	}
+
+	identifyEvents(reconciler)
+
+	namespaces := watchedNamespaces(namespace)
+
+	if tool.Options.NamespaceSelector != "" {
+		selected, err := managedKubeClient.CoreV1().Namespaces().List(
+			context.TODO(), metav1.ListOptions{LabelSelector: tool.Options.NamespaceSelector},
+		)
+		if err != nil {
+			log.Error(err, "Failed to list the namespaces matching the namespace selector")
+
+			return 1
+		}
+
+		for _, ns := range selected.Items {
+			namespaces = append(namespaces, ns.GetName())
+		}
+	}
+
+	log.Info("Syncing the status of all policies once")
+
+	if err := reconciler.SyncAll(context.TODO(), namespaces); err != nil {
+		log.Error(err, "One-time status sync failed")
+
+		return 1
+	}
+
+	if reconciler.HistoryReporter != nil {
+		if err := reconciler.HistoryReporter.Flush(context.TODO()); err != nil {
+			log.Error(err, "Failed to send the compliance events to the compliance history API")
+
+			return 1
+		}
+	}
+
+	log.Info("One-time status sync completed successfully")
+
+	return 0
+}
@@ -0,0 +1,39 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stolostron/governance-policy-status-sync/tool"
+)
+
+func TestWatchedNamespaces(t *testing.T) {
+	// not parallel since the namespaces depend on the global options
+	original := tool.Options.NamespaceSelector
+	defer func() { tool.Options.NamespaceSelector = original }()
+
+	tests := map[string]struct {
+		namespace string
+		selector  string
+		expected  []string
+	}{
+		"single namespace":             {"cluster1", "", []string{"cluster1"}},
+		"list":                         {"cluster1,cluster2", "", []string{"cluster1", "cluster2"}},
+		"empty entries":                {"cluster1,,cluster2,", "", []string{"cluster1", "cluster2"}},
+		"duplicates":                   {"cluster1, cluster2,cluster1", "", []string{"cluster1", "cluster2"}},
+		"empty WATCH_NAMESPACE":        {"", "", []string{""}},
+		"only commas":                  {",,", "", []string{""}},
+		"selector":                     {"cluster1,cluster2", "environment=dev", []string{}},
+		"selector and empty namespace": {"", "environment=dev", []string{}},
+	}
+
+	for name, test := range tests {
+		tool.Options.NamespaceSelector = test.selector
+
+		if namespaces := watchedNamespaces(test.namespace); !reflect.DeepEqual(namespaces, test.expected) {
+			t.Fatalf("%s: expected the namespaces %q, got %q", name, test.expected, namespaces)
+		}
+	}
+}
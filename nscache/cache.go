@@ -0,0 +1,627 @@
+// Copyright Contributors to the Open Cluster Management project
+
+// Package nscache contains a multi-namespace cache that isolates failures of individual namespaces, so that
+// a deleted or forbidden namespace doesn't prevent the other namespaces from syncing.
+package nscache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var log = logf.Log.WithName("nscache")
+
+const (
+	// namespaceSyncTimeout is how long an informer of a namespace may take to sync before the namespace is
+	// considered degraded
+	namespaceSyncTimeout = 30 * time.Second
+	// namespaceRetryInterval is how long each retry waits for an informer of a degraded namespace to sync
+	namespaceRetryInterval = time.Minute
+)
+
+var namespacesDegraded = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "policy_status_sync_namespaces_degraded",
+		Help: "The number of watched namespaces whose cache failed to sync, such as a deleted or forbidden namespace",
+	},
+)
+
+func init() {
+	metrics.Registry.MustRegister(namespacesDegraded)
+}
+
+// namespaceCache is the cache of a single namespace
+type namespaceCache struct {
+	cache cache.Cache
+	// ctx is the context the cache was started with, and is nil until the cache is started
+	ctx    context.Context
+	cancel context.CancelFunc
+	// pending is the number of informers that haven't synced yet. The namespace is degraded while it's
+	// not 0.
+	pending int
+}
+
+// MultiNamespaceCache is a cache for multiple namespaces, like the controller-runtime multi-namespace cache,
+// except that an informer of a namespace that doesn't sync in time doesn't block the others. The namespace
+// is marked as degraded and its informer is retried in the background until it syncs, while the other
+// namespaces keep syncing. Reads from a degraded namespace fail and lists across all namespaces skip it.
+type MultiNamespaceCache struct {
+	config *rest.Config
+	opts   cache.Options
+	// newCache creates the cache of each namespace, which is cache.New
+	newCache     cache.NewCacheFunc
+	clusterCache cache.Cache
+	lock         sync.RWMutex
+	namespaces   map[string]*namespaceCache
+	informers    map[schema.GroupVersionKind]*multiNamespaceInformer
+	// ctx is the context the cache was started with, and is nil until the cache is started
+	ctx context.Context
+}
+
+// blank assignment to verify that MultiNamespaceCache implements cache.Cache
+var _ cache.Cache = &MultiNamespaceCache{}
+
+// Builder returns a function to create a MultiNamespaceCache for the namespaces, which replaces
+// cache.MultiNamespacedCacheBuilder.
+func Builder(namespaces []string) cache.NewCacheFunc {
+	return func(config *rest.Config, opts cache.Options) (cache.Cache, error) {
+		return New(config, opts, namespaces)
+	}
+}
+
+// New returns a MultiNamespaceCache for the namespaces. The namespace in the options is ignored.
+func New(config *rest.Config, opts cache.Options, namespaces []string) (*MultiNamespaceCache, error) {
+	if opts.Scheme == nil {
+		opts.Scheme = scheme.Scheme
+	}
+
+	if opts.Mapper == nil {
+		var err error
+
+		opts.Mapper, err = apiutil.NewDynamicRESTMapper(config)
+		if err != nil {
+			return nil, fmt.Errorf("could not create RESTMapper from config: %w", err)
+		}
+	}
+
+	opts.Namespace = ""
+
+	clusterCache, err := cache.New(config, opts)
+	if err != nil {
+		return nil, fmt.Errorf("error creating the cluster scoped cache: %w", err)
+	}
+
+	multiCache := &MultiNamespaceCache{
+		config:       config,
+		opts:         opts,
+		newCache:     cache.New,
+		clusterCache: clusterCache,
+		namespaces:   map[string]*namespaceCache{},
+		informers:    map[schema.GroupVersionKind]*multiNamespaceInformer{},
+	}
+
+	for _, namespace := range namespaces {
+		nsCache, err := multiCache.newNamespaceCache(namespace)
+		if err != nil {
+			return nil, err
+		}
+
+		multiCache.namespaces[namespace] = nsCache
+	}
+
+	return multiCache, nil
+}
+
+func (c *MultiNamespaceCache) newNamespaceCache(namespace string) (*namespaceCache, error) {
+	opts := c.opts
+	opts.Namespace = namespace
+
+	nsCache, err := c.newCache(c.config, opts)
+	if err != nil {
+		return nil, fmt.Errorf("error creating the cache for namespace %s: %w", namespace, err)
+	}
+
+	return &namespaceCache{cache: nsCache}, nil
+}
+
+// startNamespaceCache starts the cache of a namespace in the background. The lock must be held.
+func (c *MultiNamespaceCache) startNamespaceCache(namespace string, nsCache *namespaceCache) {
+	nsCache.ctx, nsCache.cancel = context.WithCancel(c.ctx)
+
+	go func() {
+		if err := nsCache.cache.Start(nsCache.ctx); err != nil {
+			log.Error(err, "The namespace cache failed to start", "namespace", namespace)
+		}
+	}()
+}
+
+// isNamespaced returns true if the kind is namespaced
+func (c *MultiNamespaceCache) isNamespaced(gvk schema.GroupVersionKind) (bool, error) {
+	mapping, err := c.opts.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return false, err
+	}
+
+	return mapping.Scope.Name() == apimeta.RESTScopeNameNamespace, nil
+}
+
+// GetInformer returns the informer of the kind of the object
+func (c *MultiNamespaceCache) GetInformer(ctx context.Context, obj client.Object) (cache.Informer, error) {
+	gvk, err := apiutil.GVKForObject(obj, c.opts.Scheme)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.GetInformerForKind(ctx, gvk)
+}
+
+// GetInformerForKind returns the informer of the kind. For a namespaced kind, it waits for the informer of
+// each namespace to sync for a limited time, after which the namespace is degraded.
+func (c *MultiNamespaceCache) GetInformerForKind(
+	ctx context.Context, gvk schema.GroupVersionKind,
+) (cache.Informer, error) {
+	namespaced, err := c.isNamespaced(gvk)
+	if err != nil {
+		return nil, err
+	}
+
+	if !namespaced {
+		return c.clusterCache.GetInformerForKind(ctx, gvk)
+	}
+
+	c.lock.Lock()
+
+	if informer, ok := c.informers[gvk]; ok {
+		c.lock.Unlock()
+
+		return informer, nil
+	}
+
+	informer := newMultiNamespaceInformer()
+	c.informers[gvk] = informer
+
+	namespaces := make(map[string]*namespaceCache, len(c.namespaces))
+	for namespace, nsCache := range c.namespaces {
+		namespaces[namespace] = nsCache
+	}
+
+	c.lock.Unlock()
+
+	var wg sync.WaitGroup
+
+	errs := make(chan error, len(namespaces))
+
+	for namespace, nsCache := range namespaces {
+		wg.Add(1)
+
+		go func(namespace string, nsCache *namespaceCache) {
+			defer wg.Done()
+
+			errs <- c.attachInformer(ctx, namespace, nsCache, gvk, informer)
+		}(namespace, nsCache)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			c.lock.Lock()
+			delete(c.informers, gvk)
+			c.lock.Unlock()
+
+			return nil, err
+		}
+	}
+
+	return informer, nil
+}
+
+// attachInformer gets the informer of the kind from the namespace cache and attaches it to the combined
+// informer. If it doesn't sync in time, the namespace is degraded and it's retried in the background.
+func (c *MultiNamespaceCache) attachInformer(
+	ctx context.Context, namespace string, nsCache *namespaceCache, gvk schema.GroupVersionKind,
+	informer *multiNamespaceInformer,
+) error {
+	attachCtx, cancel := context.WithTimeout(ctx, namespaceSyncTimeout)
+	defer cancel()
+
+	nsInformer, err := nsCache.cache.GetInformerForKind(attachCtx, gvk)
+	if err == nil {
+		informer.attach(namespace, nsInformer)
+
+		return nil
+	}
+
+	if !apierrors.IsTimeout(err) || ctx.Err() != nil {
+		return err
+	}
+
+	log.Error(err, "The namespace cache did not sync in time, the namespace is degraded", "namespace", namespace,
+		"kind", gvk.Kind)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// the namespace may have been removed in the meantime
+	if c.namespaces[namespace] == nsCache {
+		c.setPending(nsCache, 1)
+
+		go c.retryInformer(namespace, nsCache, gvk, informer)
+	}
+
+	return nil
+}
+
+// retryInformer waits for the informer of a degraded namespace to sync and then attaches it. It stops when
+// the namespace cache is stopped.
+func (c *MultiNamespaceCache) retryInformer(
+	namespace string, nsCache *namespaceCache, gvk schema.GroupVersionKind, informer *multiNamespaceInformer,
+) {
+	for {
+		retryCtx, cancel := context.WithTimeout(nsCache.ctx, namespaceRetryInterval)
+		nsInformer, err := nsCache.cache.GetInformerForKind(retryCtx, gvk)
+
+		cancel()
+
+		if nsCache.ctx.Err() != nil {
+			return
+		}
+
+		if err == nil {
+			c.lock.Lock()
+			// the namespace may have been removed in the meantime
+			if c.namespaces[namespace] == nsCache {
+				informer.attach(namespace, nsInformer)
+				c.setPending(nsCache, -1)
+			}
+			c.lock.Unlock()
+
+			log.Info("The namespace cache synced, the namespace is no longer degraded", "namespace", namespace,
+				"kind", gvk.Kind)
+
+			return
+		}
+
+		log.Error(err, "The namespace cache is still not synced, retrying", "namespace", namespace, "kind", gvk.Kind)
+	}
+}
+
+// setPending changes the number of pending informers of the namespace and updates the degraded namespaces
+// metric. The lock must be held.
+func (c *MultiNamespaceCache) setPending(nsCache *namespaceCache, delta int) {
+	wasDegraded := nsCache.pending > 0
+	nsCache.pending += delta
+
+	if isDegraded := nsCache.pending > 0; isDegraded != wasDegraded {
+		if isDegraded {
+			namespacesDegraded.Inc()
+		} else {
+			namespacesDegraded.Dec()
+		}
+	}
+}
+
+// AddNamespace starts watching the namespace. The informers that were already requested are added for the
+// namespace in the background, and the namespace is degraded until they sync.
+func (c *MultiNamespaceCache) AddNamespace(namespace string) error {
+	c.lock.Lock()
+
+	if _, ok := c.namespaces[namespace]; ok {
+		c.lock.Unlock()
+
+		return nil
+	}
+
+	nsCache, err := c.newNamespaceCache(namespace)
+	if err != nil {
+		c.lock.Unlock()
+
+		return err
+	}
+
+	c.namespaces[namespace] = nsCache
+
+	attachCtx := context.Background()
+
+	if c.ctx != nil {
+		c.startNamespaceCache(namespace, nsCache)
+
+		attachCtx = nsCache.ctx
+	}
+
+	informers := make(map[schema.GroupVersionKind]*multiNamespaceInformer, len(c.informers))
+	for gvk, informer := range c.informers {
+		informers[gvk] = informer
+	}
+
+	c.lock.Unlock()
+
+	log.Info("Watching the namespace", "namespace", namespace)
+
+	for gvk, informer := range informers {
+		go func(gvk schema.GroupVersionKind, informer *multiNamespaceInformer) {
+			if err := c.attachInformer(attachCtx, namespace, nsCache, gvk, informer); err != nil &&
+				attachCtx.Err() == nil {
+				log.Error(err, "Failed to get the informer for the namespace", "namespace", namespace, "kind", gvk.Kind)
+			}
+		}(gvk, informer)
+	}
+
+	return nil
+}
+
+// RemoveNamespace stops watching the namespace and stops its informers
+func (c *MultiNamespaceCache) RemoveNamespace(namespace string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	nsCache, ok := c.namespaces[namespace]
+	if !ok {
+		return
+	}
+
+	if nsCache.pending > 0 {
+		c.setPending(nsCache, -nsCache.pending)
+	}
+
+	for _, informer := range c.informers {
+		informer.detach(namespace)
+	}
+
+	delete(c.namespaces, namespace)
+
+	if nsCache.cancel != nil {
+		nsCache.cancel()
+	}
+
+	log.Info("Stopped watching the namespace", "namespace", namespace)
+}
+
+// Namespaces returns the watched namespaces
+func (c *MultiNamespaceCache) Namespaces() []string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	namespaces := make([]string, 0, len(c.namespaces))
+	for namespace := range c.namespaces {
+		namespaces = append(namespaces, namespace)
+	}
+
+	return namespaces
+}
+
+// DegradedNamespaces returns the namespaces whose cache hasn't synced
+func (c *MultiNamespaceCache) DegradedNamespaces() []string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	degraded := []string{}
+
+	for namespace, nsCache := range c.namespaces {
+		if nsCache.pending > 0 {
+			degraded = append(degraded, namespace)
+		}
+	}
+
+	return degraded
+}
+
+// Start starts the caches of all namespaces and blocks until the context is done
+func (c *MultiNamespaceCache) Start(ctx context.Context) error {
+	go func() {
+		if err := c.clusterCache.Start(ctx); err != nil {
+			log.Error(err, "The cluster scoped cache failed to start")
+		}
+	}()
+
+	c.lock.Lock()
+	c.ctx = ctx
+
+	for namespace, nsCache := range c.namespaces {
+		c.startNamespaceCache(namespace, nsCache)
+	}
+	c.lock.Unlock()
+
+	<-ctx.Done()
+
+	return nil
+}
+
+// WaitForCacheSync waits for the caches of the namespaces that aren't degraded to sync
+func (c *MultiNamespaceCache) WaitForCacheSync(ctx context.Context) bool {
+	c.lock.RLock()
+
+	healthy := []cache.Cache{}
+
+	for _, nsCache := range c.namespaces {
+		if nsCache.pending == 0 {
+			healthy = append(healthy, nsCache.cache)
+		}
+	}
+
+	c.lock.RUnlock()
+
+	synced := true
+
+	for _, nsCache := range healthy {
+		if !nsCache.WaitForCacheSync(ctx) {
+			synced = false
+		}
+	}
+
+	if !c.clusterCache.WaitForCacheSync(ctx) {
+		synced = false
+	}
+
+	return synced
+}
+
+// IndexField adds the index to the informer of the kind in every namespace
+func (c *MultiNamespaceCache) IndexField(
+	ctx context.Context, obj client.Object, field string, extractValue client.IndexerFunc,
+) error {
+	gvk, err := apiutil.GVKForObject(obj, c.opts.Scheme)
+	if err != nil {
+		return err
+	}
+
+	namespaced, err := c.isNamespaced(gvk)
+	if err != nil {
+		return err
+	}
+
+	if !namespaced {
+		return c.clusterCache.IndexField(ctx, obj, field, extractValue)
+	}
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	for _, nsCache := range c.namespaces {
+		if err := nsCache.cache.IndexField(ctx, obj, field, extractValue); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// namespaceReader returns the cache of a namespace, or an error if the namespace isn't watched or is
+// degraded
+func (c *MultiNamespaceCache) namespaceReader(namespace string) (cache.Cache, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	nsCache, ok := c.namespaces[namespace]
+	if !ok {
+		return nil, fmt.Errorf("unable to read from namespace %s because it is not watched by the cache", namespace)
+	}
+
+	if nsCache.pending > 0 {
+		return nil, fmt.Errorf("unable to read from namespace %s because its cache is degraded", namespace)
+	}
+
+	return nsCache.cache, nil
+}
+
+// Get reads the object from the cache of its namespace
+func (c *MultiNamespaceCache) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	gvk, err := apiutil.GVKForObject(obj, c.opts.Scheme)
+	if err != nil {
+		return err
+	}
+
+	namespaced, err := c.isNamespaced(gvk)
+	if err != nil {
+		return err
+	}
+
+	if !namespaced {
+		return c.clusterCache.Get(ctx, key, obj)
+	}
+
+	nsCache, err := c.namespaceReader(key.Namespace)
+	if err != nil {
+		return err
+	}
+
+	return nsCache.Get(ctx, key, obj)
+}
+
+// List lists the objects from the cache of the namespace in the options, or from every namespace that isn't
+// degraded if the options have no namespace
+func (c *MultiNamespaceCache) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	listOpts := client.ListOptions{}
+	listOpts.ApplyOptions(opts)
+
+	gvk, err := apiutil.GVKForObject(list, c.opts.Scheme)
+	if err != nil {
+		return err
+	}
+
+	// the kind of a list is the kind of its items followed by List
+	gvk.Kind = strings.TrimSuffix(gvk.Kind, "List")
+
+	namespaced, err := c.isNamespaced(gvk)
+	if err != nil {
+		return err
+	}
+
+	if !namespaced {
+		return c.clusterCache.List(ctx, list, opts...)
+	}
+
+	if listOpts.Namespace != corev1.NamespaceAll {
+		nsCache, err := c.namespaceReader(listOpts.Namespace)
+		if err != nil {
+			return err
+		}
+
+		return nsCache.List(ctx, list, opts...)
+	}
+
+	c.lock.RLock()
+
+	healthy := []cache.Cache{}
+
+	for _, nsCache := range c.namespaces {
+		if nsCache.pending == 0 {
+			healthy = append(healthy, nsCache.cache)
+		}
+	}
+
+	c.lock.RUnlock()
+
+	allItems, err := apimeta.ExtractList(list)
+	if err != nil {
+		return err
+	}
+
+	limitSet := listOpts.Limit > 0
+
+	var resourceVersion string
+
+	for _, nsCache := range healthy {
+		listObj := list.DeepCopyObject().(client.ObjectList)
+		if err := nsCache.List(ctx, listObj, &listOpts); err != nil {
+			return err
+		}
+
+		items, err := apimeta.ExtractList(listObj)
+		if err != nil {
+			return err
+		}
+
+		allItems = append(allItems, items...)
+		// the last list call has the most recent resource version
+		resourceVersion = listObj.GetResourceVersion()
+
+		if limitSet {
+			listOpts.Limit -= int64(len(items))
+			if listOpts.Limit <= 0 {
+				break
+			}
+		}
+	}
+
+	list.SetResourceVersion(resourceVersion)
+
+	return apimeta.SetList(list, allItems)
+}
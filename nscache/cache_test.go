@@ -0,0 +1,343 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package nscache
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllertest"
+)
+
+var configMapGVK = corev1.SchemeGroupVersion.WithKind("ConfigMap")
+
+// fakeNamespaceCache is the cache of a namespace with a fake informer, which doesn't sync until the namespace
+// is recovered if it's failing
+type fakeNamespaceCache struct {
+	client.Reader
+	informer *controllertest.FakeInformer
+	// recovered is closed once the informer of a failing namespace syncs
+	recovered chan struct{}
+	// attempts is the number of times the informer was requested
+	lock     sync.Mutex
+	attempts int
+}
+
+func (f *fakeNamespaceCache) GetInformer(ctx context.Context, _ client.Object) (cache.Informer, error) {
+	return f.GetInformerForKind(ctx, configMapGVK)
+}
+
+func (f *fakeNamespaceCache) GetInformerForKind(
+	ctx context.Context, _ schema.GroupVersionKind,
+) (cache.Informer, error) {
+	f.lock.Lock()
+	f.attempts++
+	f.lock.Unlock()
+
+	select {
+	case <-f.recovered:
+		return f.informer, nil
+	case <-ctx.Done():
+	// like the informer of a forbidden namespace, which never syncs
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	return nil, apierrors.NewTimeoutError("the informer did not sync", 0)
+}
+
+func (f *fakeNamespaceCache) Start(ctx context.Context) error {
+	<-ctx.Done()
+
+	return nil
+}
+
+func (f *fakeNamespaceCache) WaitForCacheSync(_ context.Context) bool {
+	return true
+}
+
+func (f *fakeNamespaceCache) IndexField(_ context.Context, _ client.Object, _ string, _ client.IndexerFunc) error {
+	return nil
+}
+
+func (f *fakeNamespaceCache) getAttempts() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.attempts
+}
+
+// newTestCache returns a started MultiNamespaceCache of the namespaces with a ConfigMap in each, where the
+// informers of the failing namespaces don't sync until they are recovered
+func newTestCache(
+	t *testing.T, namespaces []string, failing ...string,
+) (*MultiNamespaceCache, map[string]*fakeNamespaceCache) {
+	t.Helper()
+
+	mapper := apimeta.NewDefaultRESTMapper(nil)
+	mapper.Add(configMapGVK, apimeta.RESTScopeNamespace)
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("Namespace"), apimeta.RESTScopeRoot)
+
+	fakes := map[string]*fakeNamespaceCache{}
+	lock := sync.Mutex{}
+
+	newCache := func(_ *rest.Config, opts cache.Options) (cache.Cache, error) {
+		recovered := make(chan struct{})
+
+		isFailing := false
+
+		for _, namespace := range failing {
+			isFailing = isFailing || namespace == opts.Namespace
+		}
+
+		if !isFailing {
+			close(recovered)
+		}
+
+		configMap := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "config-" + opts.Namespace, Namespace: opts.Namespace},
+		}
+
+		nsCache := &fakeNamespaceCache{
+			Reader:    fake.NewClientBuilder().WithObjects(configMap).Build(),
+			informer:  &controllertest.FakeInformer{Synced: true},
+			recovered: recovered,
+		}
+
+		lock.Lock()
+		fakes[opts.Namespace] = nsCache
+		lock.Unlock()
+
+		return nsCache, nil
+	}
+
+	opts := cache.Options{Scheme: scheme.Scheme, Mapper: mapper}
+
+	multiCache := &MultiNamespaceCache{
+		opts:         opts,
+		newCache:     newCache,
+		clusterCache: &fakeNamespaceCache{Reader: fake.NewClientBuilder().Build()},
+		namespaces:   map[string]*namespaceCache{},
+		informers:    map[schema.GroupVersionKind]*multiNamespaceInformer{},
+	}
+
+	for _, namespace := range namespaces {
+		nsCache, err := multiCache.newNamespaceCache(namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		multiCache.namespaces[namespace] = nsCache
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	go func() {
+		_ = multiCache.Start(ctx)
+	}()
+
+	// wait for the namespace caches to be started
+	waitFor(t, "the cache to start", func() bool {
+		multiCache.lock.RLock()
+		defer multiCache.lock.RUnlock()
+
+		return multiCache.ctx != nil
+	})
+
+	return multiCache, fakes
+}
+
+func waitFor(t *testing.T, description string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", description)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// listedNamespaces returns the sorted namespaces of the ConfigMaps listed across all namespaces
+func listedNamespaces(t *testing.T, multiCache *MultiNamespaceCache) []string {
+	t.Helper()
+
+	configMaps := &corev1.ConfigMapList{}
+	if err := multiCache.List(context.TODO(), configMaps); err != nil {
+		t.Fatal(err)
+	}
+
+	namespaces := []string{}
+	for _, configMap := range configMaps.Items {
+		namespaces = append(namespaces, configMap.GetNamespace())
+	}
+
+	sort.Strings(namespaces)
+
+	return namespaces
+}
+
+func getConfigMap(multiCache *MultiNamespaceCache, namespace string) error {
+	key := client.ObjectKey{Namespace: namespace, Name: "config-" + namespace}
+
+	return multiCache.Get(context.TODO(), key, &corev1.ConfigMap{})
+}
+
+// recordingHandler records the namespaces of the objects it was notified of
+type recordingHandler struct {
+	lock       sync.Mutex
+	namespaces []string
+}
+
+func (h *recordingHandler) OnAdd(obj interface{}) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.namespaces = append(h.namespaces, obj.(metav1.Object).GetNamespace())
+}
+
+func (h *recordingHandler) OnUpdate(_, _ interface{}) {}
+
+func (h *recordingHandler) OnDelete(_ interface{}) {}
+
+func (h *recordingHandler) notified() string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return strings.Join(h.namespaces, ",")
+}
+
+var _ toolscache.ResourceEventHandler = &recordingHandler{}
+
+func TestFailingNamespaceIsIsolated(t *testing.T) {
+	multiCache, fakes := newTestCache(t, []string{"healthy", "failing"}, "failing")
+
+	informer, err := multiCache.GetInformerForKind(context.TODO(), configMapGVK)
+	if err != nil {
+		t.Fatalf("expected the failing namespace to not fail the informer: %v", err)
+	}
+
+	if degraded := multiCache.DegradedNamespaces(); len(degraded) != 1 || degraded[0] != "failing" {
+		t.Fatalf("expected the failing namespace to be degraded, got %v", degraded)
+	}
+
+	if value := testutil.ToFloat64(namespacesDegraded); value != 1 {
+		t.Fatalf("expected 1 degraded namespace in the metric, got %v", value)
+	}
+
+	handler := &recordingHandler{}
+	informer.AddEventHandler(handler)
+
+	// the healthy namespace keeps working
+	if err := getConfigMap(multiCache, "healthy"); err != nil {
+		t.Fatalf("expected to read from the healthy namespace: %v", err)
+	}
+
+	if err := getConfigMap(multiCache, "failing"); err == nil || !strings.Contains(err.Error(), "degraded") {
+		t.Fatalf("expected the read from the degraded namespace to fail, got %v", err)
+	}
+
+	if listed := listedNamespaces(t, multiCache); strings.Join(listed, ",") != "healthy" {
+		t.Fatalf("expected the list to skip the degraded namespace, got %v", listed)
+	}
+
+	if !multiCache.WaitForCacheSync(context.TODO()) {
+		t.Fatal("expected the degraded namespace to not block the cache sync")
+	}
+
+	fakes["healthy"].informer.Add(&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "healthy"}})
+
+	if notified := handler.notified(); notified != "healthy" {
+		t.Fatalf("expected the handler to be notified of the healthy namespace, got %s", notified)
+	}
+
+	// the informer of the failing namespace keeps being retried in the background until it syncs
+	waitFor(t, "the informer to be retried", func() bool { return fakes["failing"].getAttempts() > 2 })
+
+	close(fakes["failing"].recovered)
+
+	waitFor(t, "the failing namespace to recover", func() bool {
+		return len(multiCache.DegradedNamespaces()) == 0
+	})
+
+	if value := testutil.ToFloat64(namespacesDegraded); value != 0 {
+		t.Fatalf("expected no degraded namespace in the metric, got %v", value)
+	}
+
+	if err := getConfigMap(multiCache, "failing"); err != nil {
+		t.Fatalf("expected to read from the recovered namespace: %v", err)
+	}
+
+	if listed := listedNamespaces(t, multiCache); strings.Join(listed, ",") != "failing,healthy" {
+		t.Fatalf("expected the list to include the recovered namespace, got %v", listed)
+	}
+
+	// the handler that was added while the namespace was degraded is added once it recovers
+	fakes["failing"].informer.Add(&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "failing"}})
+
+	if notified := handler.notified(); notified != "healthy,failing" {
+		t.Fatalf("expected the handler to be notified of the recovered namespace, got %s", notified)
+	}
+}
+
+func TestRemoveDegradedNamespace(t *testing.T) {
+	multiCache, fakes := newTestCache(t, []string{"healthy", "failing"}, "failing")
+
+	if _, err := multiCache.GetInformerForKind(context.TODO(), configMapGVK); err != nil {
+		t.Fatal(err)
+	}
+
+	multiCache.RemoveNamespace("failing")
+
+	if degraded := multiCache.DegradedNamespaces(); len(degraded) != 0 {
+		t.Fatalf("expected the removed namespace to no longer be degraded, got %v", degraded)
+	}
+
+	if value := testutil.ToFloat64(namespacesDegraded); value != 0 {
+		t.Fatalf("expected no degraded namespace in the metric, got %v", value)
+	}
+
+	if err := getConfigMap(multiCache, "failing"); err == nil || !strings.Contains(err.Error(), "not watched") {
+		t.Fatalf("expected the read from the removed namespace to fail, got %v", err)
+	}
+
+	// the retries stop with the namespace cache
+	attempts := fakes["failing"].getAttempts()
+
+	time.Sleep(50 * time.Millisecond)
+
+	if retried := fakes["failing"].getAttempts(); retried > attempts+1 {
+		t.Fatalf("expected the retries to stop, got %d more attempts", retried-attempts)
+	}
+
+	if err := multiCache.AddNamespace("added"); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "the added namespace to sync", func() bool {
+		return getConfigMap(multiCache, "added") == nil && fakes["added"].getAttempts() > 0
+	})
+
+	if listed := listedNamespaces(t, multiCache); strings.Join(listed, ",") != "added,healthy" {
+		t.Fatalf("expected the list to include the added namespace, got %v", listed)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package nscache
+
+import (
+	"sync"
+	"time"
+
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+)
+
+// eventHandler is an event handler added to a multiNamespaceInformer
+type eventHandler struct {
+	handler      toolscache.ResourceEventHandler
+	resyncPeriod *time.Duration
+}
+
+// multiNamespaceInformer combines the informers of a kind across namespaces. The informers of degraded
+// namespaces are attached once they sync, at which point the event handlers and indexers that were added
+// so far are added to them.
+type multiNamespaceInformer struct {
+	lock      sync.Mutex
+	informers map[string]cache.Informer
+	handlers  []eventHandler
+	indexers  []toolscache.Indexers
+}
+
+// blank assignment to verify that multiNamespaceInformer implements cache.Informer
+var _ cache.Informer = &multiNamespaceInformer{}
+
+func newMultiNamespaceInformer() *multiNamespaceInformer {
+	return &multiNamespaceInformer{informers: map[string]cache.Informer{}}
+}
+
+// attach adds the informer of the namespace along with the existing event handlers and indexers
+func (i *multiNamespaceInformer) attach(namespace string, informer cache.Informer) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	for _, indexers := range i.indexers {
+		if err := informer.AddIndexers(indexers); err != nil {
+			log.Error(err, "Failed to add the indexers to the informer", "namespace", namespace)
+		}
+	}
+
+	for _, handler := range i.handlers {
+		addEventHandler(informer, handler)
+	}
+
+	i.informers[namespace] = informer
+}
+
+// detach removes the informer of the namespace. The informer must be stopped separately, since event
+// handlers can't be removed from it.
+func (i *multiNamespaceInformer) detach(namespace string) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	delete(i.informers, namespace)
+}
+
+func addEventHandler(informer cache.Informer, handler eventHandler) {
+	if handler.resyncPeriod == nil {
+		informer.AddEventHandler(handler.handler)
+	} else {
+		informer.AddEventHandlerWithResyncPeriod(handler.handler, *handler.resyncPeriod)
+	}
+}
+
+// AddEventHandler adds the handler to each namespaced informer
+func (i *multiNamespaceInformer) AddEventHandler(handler toolscache.ResourceEventHandler) {
+	i.addEventHandler(eventHandler{handler: handler})
+}
+
+// AddEventHandlerWithResyncPeriod adds the handler with a resync period to each namespaced informer
+func (i *multiNamespaceInformer) AddEventHandlerWithResyncPeriod(
+	handler toolscache.ResourceEventHandler, resyncPeriod time.Duration,
+) {
+	i.addEventHandler(eventHandler{handler: handler, resyncPeriod: &resyncPeriod})
+}
+
+func (i *multiNamespaceInformer) addEventHandler(handler eventHandler) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	i.handlers = append(i.handlers, handler)
+
+	for _, informer := range i.informers {
+		addEventHandler(informer, handler)
+	}
+}
+
+// AddIndexers adds the indexers to each namespaced informer
+func (i *multiNamespaceInformer) AddIndexers(indexers toolscache.Indexers) error {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	i.indexers = append(i.indexers, indexers)
+
+	for _, informer := range i.informers {
+		if err := informer.AddIndexers(indexers); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// HasSynced returns true if each attached namespaced informer has synced
+func (i *multiNamespaceInformer) HasSynced() bool {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	for _, informer := range i.informers {
+		if !informer.HasSynced() {
+			return false
+		}
+	}
+
+	return true
+}
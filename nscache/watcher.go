@@ -0,0 +1,75 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package nscache
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	toolscache "k8s.io/client-go/tools/cache"
+)
+
+// NamespaceWatcher adds the namespaces matching a label selector to the caches when they are created or
+// labeled, and removes them when they are deleted or no longer match, so that new namespaces are watched
+// without a restart. It must be added to the manager.
+type NamespaceWatcher struct {
+	Client kubernetes.Interface
+	// Selector is the label selector of the namespaces to watch
+	Selector string
+	Caches   []*MultiNamespaceCache
+}
+
+// NeedLeaderElection is false so that the caches of standby instances also watch the namespaces
+func (w *NamespaceWatcher) NeedLeaderElection() bool {
+	return false
+}
+
+// Start watches the namespaces until the context is done
+func (w *NamespaceWatcher) Start(ctx context.Context) error {
+	factory := informers.NewSharedInformerFactoryWithOptions(w.Client, 0,
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = w.Selector
+		}),
+	)
+
+	factory.Core().V1().Namespaces().Informer().AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			namespace, ok := obj.(*corev1.Namespace)
+			if !ok {
+				return
+			}
+
+			for _, multiCache := range w.Caches {
+				if err := multiCache.AddNamespace(namespace.GetName()); err != nil {
+					log.Error(err, "Failed to watch the namespace", "namespace", namespace.GetName())
+				}
+			}
+		},
+		// the API server sends a delete event when the namespace no longer matches the selector
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+
+			namespace, ok := obj.(*corev1.Namespace)
+			if !ok {
+				return
+			}
+
+			for _, multiCache := range w.Caches {
+				multiCache.RemoveNamespace(namespace.GetName())
+			}
+		},
+	})
+
+	log.Info("Watching the namespaces matching the selector", "selector", w.Selector)
+
+	factory.Start(ctx.Done())
+
+	<-ctx.Done()
+
+	return nil
+}
@@ -0,0 +1,280 @@
+// Copyright Contributors to the Open Cluster Management project
+
+// Package policystatus contains typed helpers to read and interpret the status that the policy status sync
+// writes to the replicated policies on the hub, such as the compliance of the history entries, the latest
+// compliance transition of a template, and the status of each template, so that the hub-side tools and tests
+// don't re-implement the parsing of the status.
+package policystatus
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+)
+
+const (
+	// Pending is the compliance state of the history entries of a template that is waiting on its
+	// dependencies, which has no compliance state in the Policy CRD
+	Pending policiesv1.ComplianceState = "Pending"
+	// ReportedByAnnotation is set on the template metadata in the status of a policy template, and contains
+	// the controller that reported the latest compliance history entry, along with the related object if the
+	// event has one
+	ReportedByAnnotation = "policy.open-cluster-management.io/reported-by"
+	// TemplateStatusErrorAnnotation is set on the template metadata in the status of a policy template when
+	// the compliance state of its latest history entry can't be parsed from its message
+	TemplateStatusErrorAnnotation = "policy.open-cluster-management.io/template-status-error"
+	// StatusSyncHeartbeatAnnotation is set on the template metadata in the hub status of each policy template
+	// when the status heartbeat is enabled, and is the time of the last heartbeat truncated to the interval
+	StatusSyncHeartbeatAnnotation = "policy.open-cluster-management.io/status-sync-heartbeat"
+	// StatusSyncStaleAfterAnnotation is set on the template metadata in the hub status of each policy template
+	// when the status heartbeat is enabled, and is the time after which the status is stale if the heartbeat
+	// isn't renewed, such as when the managed cluster is detached
+	StatusSyncStaleAfterAnnotation = "policy.open-cluster-management.io/status-sync-stale-after"
+	// StatusSyncStoppedAnnotation is set on the template metadata in the hub status of each policy template
+	// when the agent stopped gracefully, and is the time it stopped, after which the status is no longer live
+	StatusSyncStoppedAnnotation = "policy.open-cluster-management.io/status-sync-stopped"
+)
+
+// combinedPrefix is the prefix of the message of an event that the event recorder combined from similar events
+const combinedPrefix = "(combined from similar events):"
+
+// summaryRgx matches the message of a summarized history entry, such as
+// "NonCompliant 14 times between 2021-11-01T10:00:00Z and 2021-11-02T10:00:00Z"
+var summaryRgx = regexp.MustCompile(`^(\S+) (\d+) times between (\S+) and (\S+)$`)
+
+// Entry is a compliance history entry of a policy template with its parsed compliance state
+type Entry struct {
+	policiesv1.ComplianceHistory
+	// Compliance is the compliance state of the entry from its message, which is empty if it's unknown
+	Compliance policiesv1.ComplianceState
+	// Summary is true if the entry summarizes older entries with the same compliance state, which don't
+	// correspond to a single compliance event
+	Summary bool
+	// Count is the number of the entries that the entry summarizes, which is 1 if it's not a summary
+	Count int
+	// Start and End are the times of the oldest and newest entries that the entry summarizes, which are the
+	// time of the entry if it's not a summary
+	Start time.Time
+	End   time.Time
+}
+
+// Transition is the latest change of the compliance state of a policy template in its history
+type Transition struct {
+	// Template is the name of the policy template
+	Template string
+	// From is the compliance state before the transition, which is empty if the history has no older state
+	From policiesv1.ComplianceState
+	// To is the current compliance state of the template
+	To policiesv1.ComplianceState
+	// Time is the time of the first entry with the current compliance state
+	Time time.Time
+}
+
+// Compliance returns the compliance state of the message of a compliance history entry, and false if the
+// message doesn't start with a compliance state
+func Compliance(message string) (policiesv1.ComplianceState, bool) {
+	message = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(message), combinedPrefix)))
+
+	switch {
+	case strings.HasPrefix(message, "compliant"):
+		return policiesv1.Compliant, true
+	case strings.HasPrefix(message, "pending"):
+		return Pending, true
+	default:
+		for _, prefix := range []string{"noncompliant", "non-compliant", "violation"} {
+			if strings.HasPrefix(message, prefix) {
+				return policiesv1.NonCompliant, true
+			}
+		}
+
+		return "", false
+	}
+}
+
+// ParseEntry returns the compliance history entry with its parsed compliance state, and the summarized
+// entries if it's a summary
+func ParseEntry(history policiesv1.ComplianceHistory) Entry {
+	entry := Entry{
+		ComplianceHistory: history,
+		Count:             1,
+		Start:             history.LastTimestamp.Time,
+		End:               history.LastTimestamp.Time,
+	}
+
+	if match := summaryRgx.FindStringSubmatch(history.Message); match != nil {
+		count, countErr := strconv.Atoi(match[2])
+		start, startErr := time.Parse(time.RFC3339, match[3])
+		end, endErr := time.Parse(time.RFC3339, match[4])
+
+		if countErr == nil && startErr == nil && endErr == nil {
+			entry.Summary = true
+			entry.Count = count
+			entry.Start = start
+			entry.End = end
+			entry.Compliance, _ = Compliance(match[1])
+
+			return entry
+		}
+	}
+
+	entry.Compliance, _ = Compliance(history.Message)
+
+	return entry
+}
+
+// History returns the parsed compliance history entries of the policy template, from newest to oldest
+func History(dpt *policiesv1.DetailsPerTemplate) []Entry {
+	if dpt == nil {
+		return nil
+	}
+
+	entries := make([]Entry, 0, len(dpt.History))
+
+	for _, history := range dpt.History {
+		entries = append(entries, ParseEntry(history))
+	}
+
+	return entries
+}
+
+// Template returns the status of the policy template with the name, or false if the status has none
+func Template(status policiesv1.PolicyStatus, name string) (*policiesv1.DetailsPerTemplate, bool) {
+	for _, dpt := range status.Details {
+		if dpt != nil && dpt.TemplateMeta.Name == name {
+			return dpt, true
+		}
+	}
+
+	return nil, false
+}
+
+// Latest returns the latest compliance history entry of the policy template, or false if its history is empty
+func Latest(dpt *policiesv1.DetailsPerTemplate) (Entry, bool) {
+	if dpt == nil || len(dpt.History) == 0 {
+		return Entry{}, false
+	}
+
+	return ParseEntry(dpt.History[0]), true
+}
+
+// LatestTransition returns the latest change of the compliance state in the history of the policy template,
+// or false if its history is empty. The entries with an unknown compliance state are skipped.
+func LatestTransition(dpt *policiesv1.DetailsPerTemplate) (Transition, bool) {
+	var transition Transition
+
+	found := false
+
+	for _, entry := range History(dpt) {
+		if entry.Compliance == "" {
+			continue
+		}
+
+		if !found {
+			transition = Transition{Template: dpt.TemplateMeta.Name, To: entry.Compliance, Time: entry.Start}
+			found = true
+
+			continue
+		}
+
+		if entry.Compliance != transition.To {
+			transition.From = entry.Compliance
+
+			break
+		}
+
+		transition.Time = entry.Start
+	}
+
+	return transition, found
+}
+
+// LatestPolicyTransition returns the most recent of the latest transitions of the policy templates, or false
+// if none of them has a history
+func LatestPolicyTransition(status policiesv1.PolicyStatus) (Transition, bool) {
+	var latest Transition
+
+	found := false
+
+	for _, dpt := range status.Details {
+		transition, ok := LatestTransition(dpt)
+		if ok && (!found || transition.Time.After(latest.Time)) {
+			latest = transition
+			found = true
+		}
+	}
+
+	return latest, found
+}
+
+// ReportedBy returns the controller that reported the latest compliance history entry of the policy template,
+// or an empty string if it's unknown
+func ReportedBy(dpt *policiesv1.DetailsPerTemplate) string {
+	if dpt == nil {
+		return ""
+	}
+
+	return dpt.TemplateMeta.GetAnnotations()[ReportedByAnnotation]
+}
+
+// StatusError returns the error parsing the compliance state of the latest compliance history entry of the
+// policy template, or an empty string if there is none
+func StatusError(dpt *policiesv1.DetailsPerTemplate) string {
+	if dpt == nil {
+		return ""
+	}
+
+	return dpt.TemplateMeta.GetAnnotations()[TemplateStatusErrorAnnotation]
+}
+
+// Heartbeat returns the time of the last status sync heartbeat of the policy template, or false if the
+// heartbeat is disabled
+func Heartbeat(dpt *policiesv1.DetailsPerTemplate) (time.Time, bool) {
+	if dpt == nil {
+		return time.Time{}, false
+	}
+
+	heartbeat, err := time.Parse(time.RFC3339, dpt.TemplateMeta.GetAnnotations()[StatusSyncHeartbeatAnnotation])
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return heartbeat, true
+}
+
+// Stopped returns the time that the agent stopped syncing the status of the policy template, or false if the
+// agent didn't stop since it last wrote the status
+func Stopped(dpt *policiesv1.DetailsPerTemplate) (time.Time, bool) {
+	if dpt == nil {
+		return time.Time{}, false
+	}
+
+	stopped, err := time.Parse(time.RFC3339, dpt.TemplateMeta.GetAnnotations()[StatusSyncStoppedAnnotation])
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return stopped, true
+}
+
+// Live returns false if the status of the policy template is no longer kept up to date, which is when the
+// agent stopped or when its heartbeat wasn't renewed before the stale after time. It's true if the heartbeat
+// is disabled and the agent didn't stop.
+func Live(dpt *policiesv1.DetailsPerTemplate, now time.Time) bool {
+	if dpt == nil {
+		return false
+	}
+
+	if _, stopped := Stopped(dpt); stopped {
+		return false
+	}
+
+	staleAfter, err := time.Parse(time.RFC3339, dpt.TemplateMeta.GetAnnotations()[StatusSyncStaleAfterAnnotation])
+	if err != nil {
+		return true
+	}
+
+	return !now.After(staleAfter)
+}
@@ -0,0 +1,312 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/stolostron/governance-policy-status-sync/controllers/sync"
+	"github.com/stolostron/governance-policy-status-sync/tool"
+	"github.com/stolostron/governance-policy-status-sync/version"
+)
+
+const (
+	preflightPass = "pass"
+	preflightWarn = "warn"
+	preflightFail = "fail"
+)
+
+// preflightReport is the machine-readable report of the preflight subcommand. The agent is compatible if no
+// check failed, while the warnings are the features that degrade, such as the status fields that an older hub
+// doesn't support.
+type preflightReport struct {
+	AgentVersion             string           `json:"agentVersion"`
+	ClusterNamespace         string           `json:"clusterNamespace"`
+	HubKubernetesVersion     string           `json:"hubKubernetesVersion,omitempty"`
+	ManagedKubernetesVersion string           `json:"managedKubernetesVersion,omitempty"`
+	Compatible               bool             `json:"compatible"`
+	Checks                   []preflightCheck `json:"checks"`
+}
+
+// preflightCheck is the result of a check on the hub or managed cluster
+type preflightCheck struct {
+	Name    string `json:"name"`
+	Cluster string `json:"cluster"`
+	Result  string `json:"result"`
+	Message string `json:"message"`
+}
+
+// preflightPermission is an access that the agent needs in the cluster namespace. The agent degrades without
+// the optional ones.
+type preflightPermission struct {
+	group       string
+	resource    string
+	subresource string
+	verbs       []string
+	optional    bool
+}
+
+var (
+	// hubPermissions are the accesses of the agent on the hub
+	hubPermissions = []preflightPermission{
+		{group: policiesv1.SchemeGroupVersion.Group, resource: "policies", verbs: []string{"get", "list", "watch"}},
+		{group: policiesv1.SchemeGroupVersion.Group, resource: "policies", subresource: "status",
+			verbs: []string{"update", "patch"}},
+		{resource: "events", verbs: []string{"create", "patch"}},
+		{group: "addon.open-cluster-management.io", resource: "managedclusteraddons", subresource: "status",
+			verbs: []string{"update"}, optional: true},
+	}
+	// managedPermissions are the accesses of the agent on the managed cluster
+	managedPermissions = []preflightPermission{
+		{group: policiesv1.SchemeGroupVersion.Group, resource: "policies",
+			verbs: []string{"get", "list", "watch", "create", "update", "patch", "delete"}},
+		{group: policiesv1.SchemeGroupVersion.Group, resource: "policies", subresource: "status",
+			verbs: []string{"update", "patch"}},
+		{resource: "events", verbs: []string{"get", "list", "watch", "create", "patch"}},
+	}
+)
+
+// runPreflight runs the preflight subcommand, which checks with the hub and managed kubeconfigs that this agent
+// version is compatible with the policy CRDs, the RBAC, and the hub status schema, and prints the JSON report,
+// so that fleet upgrades can be gated on it. It returns the exit code for the process, which is 1 if a check
+// failed.
+func runPreflight(args []string, out io.Writer) int {
+	var hubConfig, managedConfig, clusterNamespace string
+
+	flags := flag.NewFlagSet("preflight", flag.ContinueOnError)
+	flags.StringVar(&hubConfig, "hub-cluster-configfile", os.Getenv("HUB_CONFIG"),
+		"The kubeconfig of the hub, which is the HUB_CONFIG environment variable by default")
+	flags.StringVar(&managedConfig, "managed-cluster-configfile", os.Getenv("MANAGED_CONFIG"),
+		"The kubeconfig of the managed cluster, which is the MANAGED_CONFIG environment variable or the "+
+			"in-cluster configuration by default")
+	flags.StringVar(&clusterNamespace, "cluster-namespace", os.Getenv("WATCH_NAMESPACE"),
+		"The cluster namespace on the hub and the managed cluster, which is the WATCH_NAMESPACE environment "+
+			"variable by default")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if clusterNamespace == "" || hubConfig == "" {
+		fmt.Fprintln(out, "The --hub-cluster-configfile and --cluster-namespace are required")
+
+		return 2
+	}
+
+	report := &preflightReport{AgentVersion: version.Version, ClusterNamespace: clusterNamespace}
+
+	hubCfg, err := clientcmd.BuildConfigFromFlags("", hubConfig)
+	if err != nil {
+		report.add("kubeconfig", "hub", preflightFail, err.Error())
+	}
+
+	managedCfg, err := clientcmd.BuildConfigFromFlags("", managedConfig)
+	if err != nil {
+		report.add("kubeconfig", "managed", preflightFail, err.Error())
+	}
+
+	ctx := context.Background()
+
+	if hubCfg != nil {
+		report.HubKubernetesVersion = report.checkCluster(ctx, "hub", hubCfg, clusterNamespace, hubPermissions)
+	}
+
+	if managedCfg != nil {
+		report.ManagedKubernetesVersion = report.checkCluster(
+			ctx, "managed", managedCfg, clusterNamespace, managedPermissions,
+		)
+	}
+
+	report.Compatible = true
+
+	for _, check := range report.Checks {
+		if check.Result == preflightFail {
+			report.Compatible = false
+		}
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+
+	if err := encoder.Encode(report); err != nil {
+		return 1
+	}
+
+	if !report.Compatible {
+		return 1
+	}
+
+	return 0
+}
+
+// add adds the result of a check to the report
+func (p *preflightReport) add(name string, cluster string, result string, message string) {
+	p.Checks = append(p.Checks, preflightCheck{Name: name, Cluster: cluster, Result: result, Message: message})
+}
+
+// checkCluster checks the policy API, the status schema on the hub, and the permissions of the cluster, and
+// returns its Kubernetes version
+func (p *preflightReport) checkCluster(
+	ctx context.Context, cluster string, cfg *rest.Config, namespace string, permissions []preflightPermission,
+) string {
+	clientset, err := kubernetes.NewForConfig(tool.ClientsetConfig(cfg))
+	if err != nil {
+		p.add("connection", cluster, preflightFail, err.Error())
+
+		return ""
+	}
+
+	serverVersion, err := clientset.Discovery().ServerVersion()
+	if err != nil {
+		p.add("connection", cluster, preflightFail, err.Error())
+
+		return ""
+	}
+
+	p.add("connection", cluster, preflightPass, "Connected to Kubernetes "+serverVersion.GitVersion)
+
+	if p.checkPolicyAPI(cluster, clientset.Discovery()) && cluster == "hub" {
+		p.checkStatusSchema(clientset.Discovery(), namespace)
+	}
+
+	for _, permission := range permissions {
+		p.checkPermission(ctx, cluster, clientset, namespace, permission)
+	}
+
+	return serverVersion.GitVersion
+}
+
+// checkPolicyAPI checks that the cluster serves the policy API version of the agent with a status subresource,
+// which is only required on the managed cluster since the hub status is then written with a regular update.
+// It returns false if the policies aren't served.
+func (p *preflightReport) checkPolicyAPI(cluster string, discoveryClient discovery.DiscoveryInterface) bool {
+	gv := policiesv1.SchemeGroupVersion.String()
+
+	resources, err := discoveryClient.ServerResourcesForGroupVersion(gv)
+	if err != nil {
+		p.add("policy-api", cluster, preflightFail,
+			fmt.Sprintf("The policy API version %s isn't served: %v", gv, err))
+
+		return false
+	}
+
+	servesPolicies := false
+	statusSubresource := false
+
+	for _, resource := range resources.APIResources {
+		switch resource.Name {
+		case "policies":
+			servesPolicies = true
+		case "policies/status":
+			statusSubresource = true
+		}
+	}
+
+	if !servesPolicies {
+		p.add("policy-api", cluster, preflightFail, fmt.Sprintf("The policies of %s aren't served", gv))
+
+		return false
+	}
+
+	p.add("policy-api", cluster, preflightPass, fmt.Sprintf("The policies of %s are served", gv))
+
+	switch {
+	case statusSubresource:
+		p.add("status-subresource", cluster, preflightPass, "The policies have a status subresource")
+	case cluster == "hub":
+		p.add("status-subresource", cluster, preflightWarn,
+			"The policies have no status subresource, the status is written with a regular update")
+	default:
+		p.add("status-subresource", cluster, preflightFail, "The policies have no status subresource")
+	}
+
+	return true
+}
+
+// checkStatusSchema checks that the hub Policy CRD has all the status fields of the agent, which are otherwise
+// removed from the hub status
+func (p *preflightReport) checkStatusSchema(discoveryClient discovery.DiscoveryInterface, clusterName string) {
+	capabilities := sync.NewHubCapabilities(discoveryClient, clusterName)
+	capabilities.Detect()
+
+	unsupported, known := capabilities.UnsupportedStatusFields()
+
+	switch {
+	case !known:
+		p.add("status-schema", "hub", preflightWarn,
+			"The status schema of the hub Policy CRD is unknown, all status fields are assumed to be supported")
+	case len(unsupported) != 0:
+		p.add("status-schema", "hub", preflightWarn, "The hub Policy CRD doesn't support the status fields, "+
+			"they are removed from the hub status: "+strings.Join(unsupported, ", "))
+	default:
+		p.add("status-schema", "hub", preflightPass, "The hub Policy CRD supports all the status fields")
+	}
+}
+
+// checkPermission checks with a self subject access review that the agent has each verb of the permission in
+// the namespace
+func (p *preflightReport) checkPermission(
+	ctx context.Context, cluster string, clientset kubernetes.Interface, namespace string,
+	permission preflightPermission,
+) {
+	resource := permission.resource
+	if permission.subresource != "" {
+		resource += "/" + permission.subresource
+	}
+
+	denied := []string{}
+
+	for _, verb := range permission.verbs {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace:   namespace,
+					Verb:        verb,
+					Group:       permission.group,
+					Resource:    permission.resource,
+					Subresource: permission.subresource,
+				},
+			},
+		}
+
+		result, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(
+			ctx, review, metav1.CreateOptions{},
+		)
+		if err != nil {
+			p.add("rbac", cluster, preflightFail,
+				fmt.Sprintf("Failed to review the access to %s: %v", resource, err))
+
+			return
+		}
+
+		if !result.Status.Allowed {
+			denied = append(denied, verb)
+		}
+	}
+
+	switch {
+	case len(denied) == 0:
+		p.add("rbac", cluster, preflightPass, fmt.Sprintf("Can %s %s in namespace %s",
+			strings.Join(permission.verbs, ", "), resource, namespace))
+	case permission.optional:
+		p.add("rbac", cluster, preflightWarn, fmt.Sprintf("Can't %s %s in namespace %s, the features that "+
+			"need it are disabled", strings.Join(denied, ", "), resource, namespace))
+	default:
+		p.add("rbac", cluster, preflightFail, fmt.Sprintf("Can't %s %s in namespace %s",
+			strings.Join(denied, ", "), resource, namespace))
+	}
+}
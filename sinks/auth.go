@@ -0,0 +1,415 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sinks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AuthToken  = "token"
+	AuthBasic  = "basic"
+	AuthOAuth2 = "oauth2"
+	AuthSigV4  = "sigv4"
+	// oauth2TokenExpiryMargin is how long before its expiry an OAuth2 access token is renewed
+	oauth2TokenExpiryMargin = 30 * time.Second
+	// oauth2DefaultTokenLifetime is how long an OAuth2 access token without an expiry is reused
+	oauth2DefaultTokenLifetime = 5 * time.Minute
+)
+
+// AuthProvider adds the credentials of an external sink to its requests. The credentials are read from the
+// files of a mounted Secret for every request, or for every OAuth2 access token, so that they can be
+// rotated without restarting the controller.
+type AuthProvider interface {
+	// Authorize adds the credentials to the request, whose body is passed for the providers that sign it. It
+	// must be called once all the other headers are set.
+	Authorize(ctx context.Context, req *http.Request, body []byte) error
+}
+
+// PasswordAuthProvider is an AuthProvider whose credentials are a username and a password, which the sinks
+// that don't send HTTP requests, such as MQTT, support
+type PasswordAuthProvider interface {
+	AuthProvider
+	// Credentials returns the username and the password, which may be empty
+	Credentials() (string, string, error)
+}
+
+// NewAuthProvider returns the provider of the authentication type with the credentials in the directory of
+// a mounted Secret, which has the token key for token, the username and password keys for basic, the
+// client-id, client-secret, token-url, and optional scopes keys for oauth2, and the access-key-id,
+// secret-access-key, region, and optional session-token, service, and signed-headers keys for sigv4. The
+// service of sigv4 defaults to s3, and the signed-headers are the names of the headers to sign in addition to
+// the host, content type, and x-amz headers. It returns nil if the authentication type is empty or none.
+func NewAuthProvider(authType string, secretDir string) (AuthProvider, error) {
+	if authType == "" || authType == "none" {
+		return nil, nil
+	}
+
+	if secretDir == "" {
+		return nil, fmt.Errorf("the %s authentication requires the directory of its Secret", authType)
+	}
+
+	switch authType {
+	case AuthToken:
+		return NewTokenAuth(filepath.Join(secretDir, "token")), nil
+	case AuthBasic:
+		return &basicAuth{usernameFile: filepath.Join(secretDir, "username"),
+			passwordFile: filepath.Join(secretDir, "password")}, nil
+	case AuthOAuth2:
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+
+		return &oauth2Auth{secretDir: secretDir, client: &http.Client{Transport: transport, Timeout: SinkTimeout}}, nil
+	case AuthSigV4:
+		return &sigV4Auth{secretDir: secretDir}, nil
+	}
+
+	return nil, fmt.Errorf("invalid authentication type %q, it must be one of %s, %s, %s, %s, or none",
+		authType, AuthToken, AuthBasic, AuthOAuth2, AuthSigV4)
+}
+
+// NewTokenAuth returns a provider that authenticates with the bearer token in the file
+func NewTokenAuth(tokenFile string) AuthProvider {
+	return &tokenAuth{tokenFile: tokenFile}
+}
+
+// NewBasicAuth returns a provider that authenticates with the username and the password in the file, which
+// isn't sent if the file is empty
+func NewBasicAuth(username string, passwordFile string) PasswordAuthProvider {
+	return &basicAuth{username: username, passwordFile: passwordFile}
+}
+
+// readSecretKey returns the trimmed content of the file, or an empty string if it doesn't exist and isn't
+// required
+func readSecretKey(path string, required bool) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !required && errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	return strings.TrimSpace(string(content)), nil
+}
+
+// tokenAuth authenticates with a bearer token
+type tokenAuth struct {
+	tokenFile string
+}
+
+// Authorize sets the bearer token
+func (a *tokenAuth) Authorize(_ context.Context, req *http.Request, _ []byte) error {
+	token, err := readSecretKey(a.tokenFile, true)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	return nil
+}
+
+// basicAuth authenticates with a username and a password, where the username is read from usernameFile if
+// it's set
+type basicAuth struct {
+	username     string
+	usernameFile string
+	passwordFile string
+}
+
+// Credentials returns the username and the password
+func (a *basicAuth) Credentials() (string, string, error) {
+	username := a.username
+
+	if a.usernameFile != "" {
+		var err error
+
+		username, err = readSecretKey(a.usernameFile, true)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	if a.passwordFile == "" {
+		return username, "", nil
+	}
+
+	password, err := readSecretKey(a.passwordFile, true)
+
+	return username, password, err
+}
+
+// Authorize sets the basic authentication
+func (a *basicAuth) Authorize(_ context.Context, req *http.Request, _ []byte) error {
+	username, password, err := a.Credentials()
+	if err != nil {
+		return err
+	}
+
+	req.SetBasicAuth(username, password)
+
+	return nil
+}
+
+// oauth2Auth authenticates with an access token from the OAuth2 client credentials grant, which is reused
+// until it expires
+type oauth2Auth struct {
+	secretDir string
+	client    *http.Client
+	lock      sync.Mutex
+	token     string
+	expiry    time.Time
+}
+
+// Authorize sets the access token, which is requested if there's no valid one
+func (a *oauth2Auth) Authorize(ctx context.Context, req *http.Request, _ []byte) error {
+	token, err := a.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	return nil
+}
+
+// accessToken returns the cached access token, or requests a new one from the token URL if it's about to
+// expire
+func (a *oauth2Auth) accessToken(ctx context.Context) (string, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.token != "" && time.Now().Before(a.expiry) {
+		return a.token, nil
+	}
+
+	values := map[string]string{}
+
+	for key, required := range map[string]bool{
+		"client-id": true, "client-secret": true, "token-url": true, "scopes": false,
+	} {
+		value, err := readSecretKey(filepath.Join(a.secretDir, key), required)
+		if err != nil {
+			return "", err
+		}
+
+		values[key] = value
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if values["scopes"] != "" {
+		form.Set("scope", strings.Join(strings.Fields(values["scopes"]), " "))
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, values["token-url"], strings.NewReader(form.Encode()),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(values["client-id"]), url.QueryEscape(values["client-secret"]))
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+
+		return "", fmt.Errorf(
+			"the OAuth2 token URL responded with %d: %s", resp.StatusCode, strings.TrimSpace(string(message)),
+		)
+	}
+
+	token := struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}{}
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return "", fmt.Errorf("invalid OAuth2 token response: %w", err)
+	}
+
+	if token.AccessToken == "" {
+		return "", errors.New("the OAuth2 token response has no access token")
+	}
+
+	lifetime := oauth2DefaultTokenLifetime
+	if token.ExpiresIn > 0 {
+		lifetime = time.Duration(token.ExpiresIn)*time.Second - oauth2TokenExpiryMargin
+	}
+
+	a.token = token.AccessToken
+	a.expiry = time.Now().Add(lifetime)
+
+	return a.token, nil
+}
+
+// sigV4SignedHeaders are the headers that are signed when they're set, along with the headers of the
+// signed-headers key of the Secret. The other headers, such as the ones that a proxy may change, aren't signed.
+var sigV4SignedHeaders = []string{"host", "content-type", "x-amz-content-sha256", "x-amz-date", "x-amz-security-token"}
+
+// sigV4Auth signs the requests with AWS Signature Version 4, such as for S3 compatible object stores
+type sigV4Auth struct {
+	secretDir string
+}
+
+// sigV4Credentials are the credentials and the scope of the AWS Signature Version 4 signatures
+type sigV4Credentials struct {
+	accessKeyID     string
+	secretAccessKey string
+	region          string
+	service         string
+}
+
+// Authorize signs the request and its body
+func (a *sigV4Auth) Authorize(_ context.Context, req *http.Request, body []byte) error {
+	values := map[string]string{}
+
+	for key, required := range map[string]bool{
+		"access-key-id": true, "secret-access-key": true, "region": true, "session-token": false, "service": false,
+		"signed-headers": false,
+	} {
+		value, err := readSecretKey(filepath.Join(a.secretDir, key), required)
+		if err != nil {
+			return err
+		}
+
+		values[key] = value
+	}
+
+	credentials := sigV4Credentials{
+		accessKeyID:     values["access-key-id"],
+		secretAccessKey: values["secret-access-key"],
+		region:          values["region"],
+		service:         values["service"],
+	}
+
+	if credentials.service == "" {
+		credentials.service = "s3"
+	}
+
+	headerNames := append([]string{}, sigV4SignedHeaders...)
+	headerNames = append(headerNames, strings.FieldsFunc(values["signed-headers"], func(r rune) bool {
+		return r == ',' || r == '\n'
+	})...)
+
+	now := time.Now().UTC()
+	payloadHash := sha256Hex(body)
+
+	// a retried request isn't signed with the signature of the previous attempt
+	req.Header.Del("Authorization")
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	if values["session-token"] != "" {
+		req.Header.Set("X-Amz-Security-Token", values["session-token"])
+	}
+
+	req.Header.Set("Authorization", signV4(req, headerNames, payloadHash, now, credentials))
+
+	return nil
+}
+
+// signV4 returns the AWS Signature Version 4 Authorization header of the request, which signs the headers
+// with the names that are set on the request
+func signV4(
+	req *http.Request, headerNames []string, payloadHash string, now time.Time, credentials sigV4Credentials,
+) string {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	headers := map[string]string{}
+
+	for _, name := range headerNames {
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		if name == "host" {
+			headers[name] = host
+		} else if values := req.Header.Values(name); len(values) != 0 {
+			// the values are trimmed, with their sequential spaces replaced by a single space
+			trimmed := make([]string, 0, len(values))
+			for _, value := range values {
+				trimmed = append(trimmed, strings.Join(strings.Fields(value), " "))
+			}
+
+			headers[name] = strings.Join(trimmed, ",")
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method, path, strings.ReplaceAll(req.URL.Query().Encode(), "+", "%20"), canonicalHeaders,
+		signedHeaders, payloadHash,
+	}, "\n")
+
+	amzDate := now.Format("20060102T150405Z")
+	scope := now.Format("20060102") + "/" + credentials.region + "/" + credentials.service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := []byte("AWS4" + credentials.secretAccessKey)
+	for _, part := range strings.Split(scope, "/") {
+		key = hmacSHA256(key, part)
+	}
+
+	return fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		credentials.accessKeyID, scope, signedHeaders, hex.EncodeToString(hmacSHA256(key, stringToSign)))
+}
+
+// sha256Hex returns the hex encoded SHA-256 hash of the data
+func sha256Hex(data []byte) string {
+	hash := sha256.Sum256(data)
+
+	return hex.EncodeToString(hash[:])
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of the data with the key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
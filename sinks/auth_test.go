@@ -0,0 +1,576 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sinks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// writeSecretDir returns a directory with a file for each key of the Secret, like a mounted Secret
+func writeSecretDir(t *testing.T, data map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	for key, value := range data {
+		if err := ioutil.WriteFile(filepath.Join(dir, key), []byte(value), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func authorize(t *testing.T, provider AuthProvider) (*http.Request, error) {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(context.TODO(), http.MethodPost, "https://sink.example.com/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return req, provider.Authorize(context.TODO(), req, []byte("{}"))
+}
+
+func TestNewAuthProvider(t *testing.T) {
+	t.Parallel()
+
+	dir := writeSecretDir(t, nil)
+
+	tests := map[string]struct {
+		authType  string
+		secretDir string
+		expected  string
+		err       string
+	}{
+		"empty":         {"", "", "<nil>", ""},
+		"none":          {"none", dir, "<nil>", ""},
+		"token":         {AuthToken, dir, "*sinks.tokenAuth", ""},
+		"basic":         {AuthBasic, dir, "*sinks.basicAuth", ""},
+		"oauth2":        {AuthOAuth2, dir, "*sinks.oauth2Auth", ""},
+		"sigv4":         {AuthSigV4, dir, "*sinks.sigV4Auth", ""},
+		"no secret":     {AuthToken, "", "", "the token authentication requires the directory of its Secret"},
+		"invalid":       {"kerberos", dir, "", `invalid authentication type "kerberos"`},
+		"invalid empty": {"kerberos", "", "", "the kerberos authentication requires the directory of its Secret"},
+	}
+
+	for name, test := range tests {
+		provider, err := NewAuthProvider(test.authType, test.secretDir)
+
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("%s: expected the error %q, got %v", name, test.err, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if fmt.Sprintf("%T", provider) != test.expected {
+			t.Fatalf("%s: expected %s, got %T", name, test.expected, provider)
+		}
+	}
+}
+
+func TestTokenAuth(t *testing.T) {
+	t.Parallel()
+
+	dir := writeSecretDir(t, map[string]string{"token": " secret-token\n"})
+	provider := NewTokenAuth(filepath.Join(dir, "token"))
+
+	req, err := authorize(t, provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if header := req.Header.Get("Authorization"); header != "Bearer secret-token" {
+		t.Fatalf("expected the trimmed bearer token, got %q", header)
+	}
+
+	// the token is read for every request, so a rotated token is used without restarting
+	if err := ioutil.WriteFile(filepath.Join(dir, "token"), []byte("rotated-token"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	req, err = authorize(t, provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if header := req.Header.Get("Authorization"); header != "Bearer rotated-token" {
+		t.Fatalf("expected the rotated bearer token, got %q", header)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "token")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := authorize(t, provider); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the missing token file to fail the request, got %v", err)
+	}
+}
+
+func TestBasicAuthCredentials(t *testing.T) {
+	t.Parallel()
+
+	dir := writeSecretDir(t, map[string]string{"username": "user\n", "password": "pass\n"})
+
+	tests := map[string]struct {
+		provider PasswordAuthProvider
+		username string
+		password string
+		err      bool
+	}{
+		"secret": {&basicAuth{usernameFile: filepath.Join(dir, "username"),
+			passwordFile: filepath.Join(dir, "password")}, "user", "pass", false},
+		"username flag":    {NewBasicAuth("flag-user", filepath.Join(dir, "password")), "flag-user", "pass", false},
+		"no password file": {NewBasicAuth("flag-user", ""), "flag-user", "", false},
+		"missing username": {&basicAuth{usernameFile: filepath.Join(dir, "missing"),
+			passwordFile: filepath.Join(dir, "password")}, "", "", true},
+		"missing password": {NewBasicAuth("flag-user", filepath.Join(dir, "missing")), "flag-user", "", true},
+	}
+
+	for name, test := range tests {
+		username, password, err := test.provider.Credentials()
+		if test.err != (err != nil) {
+			t.Fatalf("%s: expected an error %v, got %v", name, test.err, err)
+		}
+
+		if username != test.username || password != test.password {
+			t.Fatalf("%s: expected %q and %q, got %q and %q", name, test.username, test.password, username, password)
+		}
+	}
+
+	req, err := authorize(t, tests["secret"].provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if username, password, ok := req.BasicAuth(); !ok || username != "user" || password != "pass" {
+		t.Fatalf("expected the basic authentication of the Secret, got %q and %q", username, password)
+	}
+
+	if _, err := authorize(t, tests["missing password"].provider); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the missing password file to fail the request, got %v", err)
+	}
+}
+
+func TestBasicAuthConcurrentCredentials(t *testing.T) {
+	t.Parallel()
+
+	dir := writeSecretDir(t, map[string]string{"password": "pass"})
+	provider := NewBasicAuth("user", filepath.Join(dir, "password"))
+
+	wg := sync.WaitGroup{}
+	errs := make(chan error, 20)
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			username, password, err := provider.Credentials()
+			if err == nil && (username != "user" || password != "pass") {
+				err = fmt.Errorf("unexpected credentials %q and %q", username, password)
+			}
+
+			errs <- err
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// tokenServer is an OAuth2 token URL that counts the token requests and responds with the token of its
+// response function
+type tokenServer struct {
+	*httptest.Server
+	requests int32
+}
+
+func newTokenServer(t *testing.T, respond func(w http.ResponseWriter, r *http.Request, count int32)) *tokenServer {
+	t.Helper()
+
+	server := &tokenServer{}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		respond(w, r, atomic.AddInt32(&server.requests, 1))
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func (s *tokenServer) count() int32 {
+	return atomic.LoadInt32(&s.requests)
+}
+
+func newTestOAuth2(t *testing.T, server *tokenServer, extra map[string]string) *oauth2Auth {
+	t.Helper()
+
+	data := map[string]string{"client-id": "my client", "client-secret": "s3cret&", "token-url": server.URL}
+	for key, value := range extra {
+		data[key] = value
+	}
+
+	return &oauth2Auth{secretDir: writeSecretDir(t, data), client: server.Client()}
+}
+
+func TestOAuth2TokenRequest(t *testing.T) {
+	t.Parallel()
+
+	server := newTokenServer(t, func(w http.ResponseWriter, r *http.Request, count int32) {
+		clientID, clientSecret, _ := r.BasicAuth()
+
+		if r.Method != http.MethodPost || r.FormValue("grant_type") != "client_credentials" ||
+			r.FormValue("scope") != "read write" || clientID != "my+client" || clientSecret != "s3cret%26" {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+
+			return
+		}
+
+		fmt.Fprintf(w, `{"access_token": "token-%d", "expires_in": 3600}`, count)
+	})
+
+	provider := newTestOAuth2(t, server, map[string]string{"scopes": "read\nwrite\n"})
+
+	for i := 0; i < 3; i++ {
+		req, err := authorize(t, provider)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if header := req.Header.Get("Authorization"); header != "Bearer token-1" {
+			t.Fatalf("expected the cached access token, got %q", header)
+		}
+	}
+
+	if server.count() != 1 {
+		t.Fatalf("expected the access token to be requested once, got %d requests", server.count())
+	}
+
+	// the expiry has the margin to renew the token before the token URL considers it expired
+	if remaining := time.Until(provider.expiry); remaining > time.Hour-oauth2TokenExpiryMargin ||
+		remaining < time.Hour-oauth2TokenExpiryMargin-time.Minute {
+		t.Fatalf("expected the token to expire in about %s, got %s", time.Hour-oauth2TokenExpiryMargin, remaining)
+	}
+}
+
+func TestOAuth2TokenRefresh(t *testing.T) {
+	t.Parallel()
+
+	server := newTokenServer(t, func(w http.ResponseWriter, _ *http.Request, count int32) {
+		fmt.Fprintf(w, `{"access_token": "token-%d"}`, count)
+	})
+
+	provider := newTestOAuth2(t, server, nil)
+
+	token, err := provider.accessToken(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a token without an expiry is reused for the default lifetime
+	if remaining := time.Until(provider.expiry); remaining > oauth2DefaultTokenLifetime ||
+		remaining < oauth2DefaultTokenLifetime-time.Minute {
+		t.Fatalf("expected the token to expire in about %s, got %s", oauth2DefaultTokenLifetime, remaining)
+	}
+
+	provider.lock.Lock()
+	provider.expiry = time.Now().Add(-time.Second)
+	provider.lock.Unlock()
+
+	refreshed, err := provider.accessToken(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token != "token-1" || refreshed != "token-2" || server.count() != 2 {
+		t.Fatalf("expected the expired token to be refreshed, got %s then %s with %d requests", token, refreshed,
+			server.count())
+	}
+}
+
+func TestOAuth2ConcurrentRequests(t *testing.T) {
+	t.Parallel()
+
+	server := newTokenServer(t, func(w http.ResponseWriter, _ *http.Request, count int32) {
+		// the other requests wait for the token instead of requesting their own
+		time.Sleep(20 * time.Millisecond)
+		fmt.Fprintf(w, `{"access_token": "token-%d", "expires_in": 3600}`, count)
+	})
+
+	provider := newTestOAuth2(t, server, nil)
+
+	wg := sync.WaitGroup{}
+	tokens := make(chan string, 20)
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			token, err := provider.accessToken(context.TODO())
+			if err != nil {
+				token = err.Error()
+			}
+
+			tokens <- token
+		}()
+	}
+
+	wg.Wait()
+	close(tokens)
+
+	for token := range tokens {
+		if token != "token-1" {
+			t.Fatalf("expected every request to get the same access token, got %s", token)
+		}
+	}
+
+	if server.count() != 1 {
+		t.Fatalf("expected the access token to be requested once, got %d requests", server.count())
+	}
+}
+
+func TestOAuth2Errors(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		status   int
+		response string
+		expected string
+	}{
+		"status": {
+			http.StatusUnauthorized, "invalid client\n", "the OAuth2 token URL responded with 401: invalid client",
+		},
+		"invalid json":    {http.StatusOK, "not json", "invalid OAuth2 token response"},
+		"no access token": {http.StatusOK, `{"expires_in": 3600}`, "the OAuth2 token response has no access token"},
+	}
+
+	for name, test := range tests {
+		test := test
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			server := newTokenServer(t, func(w http.ResponseWriter, _ *http.Request, _ int32) {
+				w.WriteHeader(test.status)
+				fmt.Fprint(w, test.response)
+			})
+
+			provider := newTestOAuth2(t, server, nil)
+
+			for i := 0; i < 2; i++ {
+				if _, err := authorize(t, provider); err == nil || !strings.Contains(err.Error(), test.expected) {
+					t.Fatalf("expected the error %q, got %v", test.expected, err)
+				}
+			}
+
+			// a failed token request isn't cached, so the next request retries it
+			if server.count() != 2 {
+				t.Fatalf("expected the failed token request to be retried, got %d requests", server.count())
+			}
+		})
+	}
+}
+
+func TestOAuth2MissingSecretKey(t *testing.T) {
+	t.Parallel()
+
+	server := newTokenServer(t, func(w http.ResponseWriter, _ *http.Request, _ int32) {
+		fmt.Fprint(w, `{"access_token": "token"}`)
+	})
+
+	provider := newTestOAuth2(t, server, nil)
+
+	if err := os.Remove(filepath.Join(provider.secretDir, "client-secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := authorize(t, provider); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the missing client secret to fail the request, got %v", err)
+	}
+
+	if server.count() != 0 {
+		t.Fatalf("expected no token request without the client secret, got %d requests", server.count())
+	}
+}
+
+func TestOAuth2UnreachableTokenURL(t *testing.T) {
+	t.Parallel()
+
+	server := newTokenServer(t, func(w http.ResponseWriter, _ *http.Request, _ int32) {})
+	provider := newTestOAuth2(t, server, nil)
+
+	server.Close()
+
+	if _, err := authorize(t, provider); err == nil {
+		t.Fatal("expected the unreachable token URL to fail the request")
+	}
+}
+
+func TestSigV4Authorize(t *testing.T) {
+	t.Parallel()
+
+	dir := writeSecretDir(t, map[string]string{
+		"access-key-id": "AKIDEXAMPLE", "secret-access-key": "secret", "region": "us-east-1",
+		"session-token": "session",
+	})
+
+	req, err := authorize(t, &sigV4Auth{secretDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := req.Header.Get("Authorization")
+	prefix := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/" + req.Header.Get("X-Amz-Date")[:8] +
+		"/us-east-1/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date;x-amz-security-token, " +
+		"Signature="
+
+	if !strings.HasPrefix(header, prefix) || len(header) != len(prefix)+64 {
+		t.Fatalf("expected the authorization header to start with %q and have the signature, got %q", prefix, header)
+	}
+
+	if req.Header.Get("X-Amz-Content-Sha256") != sha256Hex([]byte("{}")) {
+		t.Fatalf("expected the hash of the body, got %s", req.Header.Get("X-Amz-Content-Sha256"))
+	}
+
+	if req.Header.Get("X-Amz-Security-Token") != "session" {
+		t.Fatalf("expected the session token, got %s", req.Header.Get("X-Amz-Security-Token"))
+	}
+
+	if err := os.Remove(filepath.Join(dir, "region")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := authorize(t, &sigV4Auth{secretDir: dir}); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the missing region to fail the request, got %v", err)
+	}
+}
+
+func TestSigV4SignedHeaders(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		signedHeaders string
+		expected      string
+	}{
+		"default": {"", "content-type;host;x-amz-content-sha256;x-amz-date"},
+		"configured": {"Content-Encoding, x-request-id\n", "content-encoding;content-type;host;x-amz-content-sha256;" +
+			"x-amz-date;x-request-id"},
+		"unset configured header": {"x-missing", "content-type;host;x-amz-content-sha256;x-amz-date"},
+	}
+
+	for name, test := range tests {
+		dir := writeSecretDir(t, map[string]string{
+			"access-key-id": "AKIDEXAMPLE", "secret-access-key": "secret", "region": "us-east-1",
+			"signed-headers": test.signedHeaders,
+		})
+
+		req, err := http.NewRequestWithContext(context.TODO(), http.MethodPost, "https://sink.example.com/events", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Encoding", "gzip")
+		req.Header.Set("X-Request-Id", "  request   1 ")
+		req.Header.Set("User-Agent", "proxy")
+		// the signature of a previous attempt isn't signed or kept
+		req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=stale")
+
+		if err := (&sigV4Auth{secretDir: dir}).Authorize(context.TODO(), req, []byte("{}")); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if values := req.Header.Values("Authorization"); len(values) != 1 ||
+			!strings.Contains(values[0], "SignedHeaders="+test.expected+", ") {
+			t.Fatalf("%s: expected the signed headers %s, got %v", name, test.expected, values)
+		}
+
+		if value := req.Header.Get("X-Request-Id"); value != "  request   1 " {
+			t.Fatalf("%s: expected the header to be sent unchanged, got %q", name, value)
+		}
+	}
+}
+
+func TestSignV4TestVectors(t *testing.T) {
+	t.Parallel()
+
+	// the get-vanilla request of the AWS Signature Version 4 test suite and the IAM ListUsers request of the
+	// AWS signing examples, with their example credentials
+	tests := map[string]struct {
+		url         string
+		headers     map[string]string
+		headerNames []string
+		service     string
+		expected    string
+	}{
+		"get-vanilla": {
+			url:         "https://example.amazonaws.com/",
+			headers:     map[string]string{"X-Amz-Date": "20150830T123600Z"},
+			headerNames: []string{"host", "x-amz-date"},
+			service:     "service",
+			expected: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+				"SignedHeaders=host;x-amz-date, " +
+				"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		"iam-list-users": {
+			url: "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08",
+			headers: map[string]string{
+				"Content-Type": "application/x-www-form-urlencoded; charset=utf-8", "X-Amz-Date": "20150830T123600Z",
+			},
+			headerNames: sigV4SignedHeaders,
+			service:     "iam",
+			expected: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, " +
+				"SignedHeaders=content-type;host;x-amz-date, " +
+				"Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+		},
+	}
+
+	credentials := sigV4Credentials{
+		accessKeyID: "AKIDEXAMPLE", secretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", region: "us-east-1",
+	}
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	for name, test := range tests {
+		req, err := http.NewRequestWithContext(context.TODO(), http.MethodGet, test.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for key, value := range test.headers {
+			req.Header.Set(key, value)
+		}
+
+		credentials.service = test.service
+
+		if header := signV4(req, test.headerNames, sha256Hex(nil), now, credentials); header != test.expected {
+			t.Fatalf("%s: expected %s, got %s", name, test.expected, header)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var log = logf.Log.WithName("sinks")
+
+// ComplianceHistoryFlushInterval is the interval of the periodic sends to the compliance history API
+const ComplianceHistoryFlushInterval = 10 * time.Second
+
+const (
+	complianceHistoryTimeout = 30 * time.Second
+	// complianceHistoryMaxPending bounds the events kept in memory while the API is unavailable
+	complianceHistoryMaxPending = 10000
+)
+
+// ComplianceEventCluster identifies the managed cluster of a compliance event
+type ComplianceEventCluster struct {
+	Name string `json:"name"`
+}
+
+// ComplianceEventPolicy identifies a policy of a compliance event
+type ComplianceEventPolicy struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// ComplianceEventDetails is the compliance reported by a compliance event
+type ComplianceEventDetails struct {
+	Compliance string    `json:"compliance"`
+	Message    string    `json:"message"`
+	Timestamp  time.Time `json:"timestamp"`
+	ReportedBy string    `json:"reported_by"`
+}
+
+// ComplianceEvent is a compliance history entry of a policy template in the format of the compliance
+// history API. The parent policy is the root policy on the hub and the policy is the policy template.
+type ComplianceEvent struct {
+	Cluster      ComplianceEventCluster `json:"cluster"`
+	ParentPolicy ComplianceEventPolicy  `json:"parent_policy"`
+	Policy       ComplianceEventPolicy  `json:"policy"`
+	Event        ComplianceEventDetails `json:"event"`
+}
+
+// ComplianceHistoryOptions configures the ComplianceHistoryReporter
+type ComplianceHistoryOptions struct {
+	// URL is the compliance events endpoint of the compliance history API
+	URL string
+	// Auth authenticates the requests, which aren't authenticated if it's nil
+	Auth   AuthProvider
+	CAFile string
+	// BatchSize is the maximum number of events sent in a single request
+	BatchSize int
+	// FlushPhase delays the periodic sends within the flush interval so that a fleet of agents doesn't send
+	// on the same schedule
+	FlushPhase time.Duration
+	// Compression compresses the request bodies, which are sent with the Content-Encoding header
+	Compression *Compression
+}
+
+// ComplianceHistoryReporter sends compliance events to the compliance history API on the hub. The events
+// are queued and sent in batches as a JSON array, either when a batch is full or periodically. Events that
+// fail to be sent are retried with the next batch. It must be added to the manager to send the events.
+type ComplianceHistoryReporter struct {
+	options ComplianceHistoryOptions
+	client  *http.Client
+	lock    sync.Mutex
+	pending []ComplianceEvent
+	// full is signaled when a batch is ready to be sent
+	full chan struct{}
+}
+
+// NewComplianceHistoryReporter validates the options and returns a ComplianceHistoryReporter
+func NewComplianceHistoryReporter(options ComplianceHistoryOptions) (*ComplianceHistoryReporter, error) {
+	if !strings.HasPrefix(options.URL, "https://") && !strings.HasPrefix(options.URL, "http://") {
+		return nil, fmt.Errorf("the compliance history API URL %q must be an HTTP or HTTPS URL", options.URL)
+	}
+
+	if options.BatchSize < 1 {
+		return nil, fmt.Errorf("invalid compliance history API batch size %d, it must be at least 1", options.BatchSize)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if options.CAFile != "" {
+		caBundle, err := ioutil.ReadFile(options.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caBundle) {
+			return nil, fmt.Errorf("no certificates were found in %s", options.CAFile)
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &ComplianceHistoryReporter{
+		options: options,
+		client:  &http.Client{Transport: transport, Timeout: complianceHistoryTimeout},
+		full:    make(chan struct{}, 1),
+	}, nil
+}
+
+// Record queues the compliance events to be sent
+func (c *ComplianceHistoryReporter) Record(events ...ComplianceEvent) {
+	if len(events) == 0 {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.pending = append(c.pending, events...)
+
+	if dropped := len(c.pending) - complianceHistoryMaxPending; dropped > 0 {
+		log.Info("Dropping the oldest compliance events since the compliance history API is behind",
+			"dropped", dropped)
+
+		c.pending = c.pending[dropped:]
+	}
+
+	if len(c.pending) >= c.options.BatchSize {
+		select {
+		case c.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// NeedLeaderElection is true since only the leader records compliance events
+func (c *ComplianceHistoryReporter) NeedLeaderElection() bool {
+	return true
+}
+
+// Start sends the queued events until the context is done, and then sends the remaining events
+func (c *ComplianceHistoryReporter) Start(ctx context.Context) error {
+	// the ticker starts once the flush phase has passed
+	phase := time.NewTimer(c.options.FlushPhase)
+	defer phase.Stop()
+
+	var ticks <-chan time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), complianceHistoryTimeout)
+			defer cancel()
+
+			if err := c.Flush(flushCtx); err != nil {
+				log.Error(err, "Failed to send the remaining compliance events to the compliance history API")
+			}
+
+			return nil
+		case <-phase.C:
+			ticker := time.NewTicker(ComplianceHistoryFlushInterval)
+			defer ticker.Stop()
+
+			ticks = ticker.C
+		case <-ticks:
+		case <-c.full:
+		}
+
+		if err := c.Flush(ctx); err != nil && ctx.Err() == nil {
+			log.Error(err, "Failed to send the compliance events to the compliance history API")
+		}
+	}
+}
+
+// Flush sends all the queued events in batches. The events of a failed batch stay queued.
+func (c *ComplianceHistoryReporter) Flush(ctx context.Context) error {
+	for {
+		c.lock.Lock()
+
+		size := len(c.pending)
+		if size > c.options.BatchSize {
+			size = c.options.BatchSize
+		}
+
+		batch := append([]ComplianceEvent{}, c.pending[:size]...)
+
+		c.lock.Unlock()
+
+		if len(batch) == 0 {
+			return nil
+		}
+
+		if err := c.send(ctx, batch); err != nil {
+			return err
+		}
+
+		c.lock.Lock()
+		// the oldest events may have been dropped while the batch was sent
+		if len(c.pending) >= size {
+			c.pending = c.pending[size:]
+		} else {
+			c.pending = nil
+		}
+		c.lock.Unlock()
+	}
+}
+
+// send POSTs a batch of events to the compliance history API
+func (c *ComplianceHistoryReporter) send(ctx context.Context, batch []ComplianceEvent) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	body, encoding, err := c.options.Compression.compress(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.options.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+
+	if c.options.Auth != nil {
+		if err := c.options.Auth.Authorize(ctx, req, body); err != nil {
+			return fmt.Errorf("failed to authenticate to the compliance history API: %w", err)
+		}
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+
+		return fmt.Errorf(
+			"the compliance history API responded with %d: %s", resp.StatusCode, strings.TrimSpace(string(message)),
+		)
+	}
+
+	return nil
+}
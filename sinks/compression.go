@@ -0,0 +1,64 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sinks
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+)
+
+const (
+	// CompressionNone sends the payloads of the external sinks uncompressed
+	CompressionNone = "none"
+	// CompressionGzip compresses the payloads of the external sinks with gzip
+	CompressionGzip = "gzip"
+)
+
+// Compression compresses the payloads of the external sinks that are at least the threshold in bytes, to
+// reduce the egress bandwidth of edge clusters on metered links. A nil Compression doesn't compress.
+type Compression struct {
+	algorithm string
+	threshold int
+}
+
+// NewCompression returns the compression of the algorithm, which is none or gzip, for the payloads of at
+// least the threshold in bytes. It returns nil if the algorithm is none.
+func NewCompression(algorithm string, threshold int) (*Compression, error) {
+	switch algorithm {
+	case CompressionNone:
+		return nil, nil
+	case CompressionGzip:
+	default:
+		return nil, fmt.Errorf("invalid sink compression %q, it must be %s or %s", algorithm, CompressionNone,
+			CompressionGzip)
+	}
+
+	if threshold < 0 {
+		return nil, fmt.Errorf("the sink compression threshold %d must not be negative", threshold)
+	}
+
+	return &Compression{algorithm: algorithm, threshold: threshold}, nil
+}
+
+// compress returns the payload compressed along with its content encoding, or the payload as is with an
+// empty encoding if it's smaller than the threshold
+func (c *Compression) compress(payload []byte) ([]byte, string, error) {
+	if c == nil || len(payload) < c.threshold {
+		return payload, "", nil
+	}
+
+	var compressed bytes.Buffer
+
+	writer := gzip.NewWriter(&compressed)
+
+	if _, err := writer.Write(payload); err != nil {
+		return nil, "", err
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, "", err
+	}
+
+	return compressed.Bytes(), c.algorithm, nil
+}
@@ -0,0 +1,171 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sinks
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewCompression(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		algorithm string
+		threshold int
+		enabled   bool
+		expected  string
+	}{
+		"none":               {CompressionNone, 1024, false, ""},
+		"none with negative": {CompressionNone, -1, false, ""},
+		"gzip":               {CompressionGzip, 1024, true, ""},
+		"gzip always":        {CompressionGzip, 0, true, ""},
+		"unknown algorithm":  {"zstd", 1024, false, `invalid sink compression "zstd", it must be none or gzip`},
+		"empty algorithm":    {"", 1024, false, `invalid sink compression ""`},
+		"negative threshold": {CompressionGzip, -1, false, "threshold -1 must not be negative"},
+	}
+
+	for name, test := range tests {
+		compression, err := NewCompression(test.algorithm, test.threshold)
+		if test.expected != "" {
+			if err == nil || !strings.Contains(err.Error(), test.expected) {
+				t.Fatalf("%s: expected an error containing %q, got %v", name, test.expected, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if (compression != nil) != test.enabled {
+			t.Fatalf("%s: expected the compression to be enabled: %v, got %v", name, test.enabled, compression)
+		}
+	}
+}
+
+// decompress returns the payload as is without an encoding, and otherwise the gzip decompressed payload
+func decompress(t *testing.T, payload []byte, encoding string) []byte {
+	t.Helper()
+
+	if encoding == "" {
+		return payload
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decompressed, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return decompressed
+}
+
+func TestCompress(t *testing.T) {
+	t.Parallel()
+
+	gzipCompression, err := NewCompression(CompressionGzip, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		compression *Compression
+		size        int
+		encoding    string
+	}{
+		"below the threshold": {gzipCompression, 99, ""},
+		"at the threshold":    {gzipCompression, 100, CompressionGzip},
+		"above the threshold": {gzipCompression, 10000, CompressionGzip},
+		"no compression":      {nil, 10000, ""},
+	}
+
+	for name, test := range tests {
+		payload := []byte(strings.Repeat("a", test.size))
+
+		compressed, encoding, err := test.compression.compress(payload)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if encoding != test.encoding {
+			t.Fatalf("%s: expected the encoding %q, got %q", name, test.encoding, encoding)
+		}
+
+		if encoding != "" && len(compressed) >= len(payload) {
+			t.Fatalf("%s: expected the compressed payload to be smaller than %d bytes, got %d", name,
+				len(payload), len(compressed))
+		}
+
+		if !bytes.Equal(decompress(t, compressed, encoding), payload) {
+			t.Fatalf("%s: expected the payload to be restored from its encoding", name)
+		}
+	}
+}
+
+func TestComplianceHistoryCompression(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		algorithm string
+		threshold int
+		encoding  string
+	}{
+		"compressed":          {CompressionGzip, 0, CompressionGzip},
+		"below the threshold": {CompressionGzip, 1 << 20, ""},
+		"not compressed":      {CompressionNone, 0, ""},
+	}
+
+	for name, test := range tests {
+		compression, err := NewCompression(test.algorithm, test.threshold)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		var encoding string
+
+		var body []byte
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encoding = r.Header.Get("Content-Encoding")
+			body, _ = ioutil.ReadAll(r.Body)
+		}))
+
+		reporter, err := NewComplianceHistoryReporter(ComplianceHistoryOptions{
+			URL: server.URL, BatchSize: 10, Compression: compression,
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		err = reporter.send(context.TODO(), []ComplianceEvent{{}, {}})
+
+		server.Close()
+
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		received := []ComplianceEvent{}
+		if err := json.Unmarshal(decompress(t, body, encoding), &received); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if encoding != test.encoding || len(received) != 2 {
+			t.Fatalf("%s: expected 2 events with the encoding %q, got %d with %q", name, test.encoding,
+				len(received), encoding)
+		}
+	}
+}
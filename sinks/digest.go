@@ -0,0 +1,138 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sinks
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/stolostron/governance-policy-status-sync/version"
+)
+
+// transitionDigest collapses the compliance transitions of each policy into its last transition, from the
+// compliance state that the policy had before the first one
+type transitionDigest struct {
+	// held are the last transitions of the policies, which are keyed by namespace and policy
+	held map[string]*ComplianceTransition
+	// order are the keys of the held transitions in the order they were first held
+	order []string
+	// start is when the first transition was held
+	start time.Time
+}
+
+// add holds the transition as the last transition of its policy
+func (d *transitionDigest) add(transition ComplianceTransition) {
+	if d.held == nil {
+		d.held = map[string]*ComplianceTransition{}
+		d.start = time.Now().UTC()
+	}
+
+	key := transition.Namespace + "/" + transition.Policy
+
+	if previous, ok := d.held[key]; ok {
+		transition.PreviousCompliance = previous.PreviousCompliance
+		transition.HeldTransitions = previous.HeldTransitions + 1
+	} else {
+		transition.HeldTransitions = 1
+		d.order = append(d.order, key)
+	}
+
+	d.held[key] = &transition
+}
+
+// take returns the held transitions in the order they were first held along with when the first one was
+// held, and empties the digest
+func (d *transitionDigest) take() ([]ComplianceTransition, time.Time) {
+	transitions := make([]ComplianceTransition, 0, len(d.order))
+	for _, key := range d.order {
+		transitions = append(transitions, *d.held[key])
+	}
+
+	start := d.start
+	*d = transitionDigest{}
+
+	return transitions, start
+}
+
+// PeriodicDigestSink sends the compliance transitions to the wrapped sink in a digest at most once per
+// interval instead of one message per transition, so that clusters with many flapping policies don't flood
+// the sink. The digest is sent an interval after its first transition, with the last transition of each
+// policy in the interval. If the wrapped sink is a DigestSink, the digest is sent in a single message, and
+// otherwise each transition of the digest is sent. The transitions held when the controller stops are lost.
+type PeriodicDigestSink struct {
+	Sink
+	interval time.Duration
+	// kubernetesVersion is the Kubernetes version of the managed cluster in the digests
+	kubernetesVersion string
+	lock              sync.Mutex
+	digest            transitionDigest
+	timer             *time.Timer
+}
+
+// blank assignment to verify that PeriodicDigestSink implements Sink
+var _ Sink = &PeriodicDigestSink{}
+
+// NewPeriodicDigestSink returns a sink that sends the transitions to the wrapped sink in a digest every
+// interval, along with the Kubernetes version of the managed cluster and the version of the agent
+func NewPeriodicDigestSink(wrapped Sink, interval time.Duration, kubernetesVersion string) *PeriodicDigestSink {
+	return &PeriodicDigestSink{Sink: wrapped, interval: interval, kubernetesVersion: kubernetesVersion}
+}
+
+// Send holds the transition for the next digest
+func (s *PeriodicDigestSink) Send(_ context.Context, transition ComplianceTransition) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.digest.add(transition)
+
+	if s.timer == nil {
+		s.timer = time.AfterFunc(s.interval, s.flush)
+	}
+
+	return nil
+}
+
+// flush sends the digest of the held transitions. Failures are only logged.
+func (s *PeriodicDigestSink) flush() {
+	s.lock.Lock()
+	transitions, start := s.digest.take()
+	s.timer = nil
+	s.lock.Unlock()
+
+	if len(transitions) == 0 {
+		return
+	}
+
+	if digestSink, ok := s.Sink.(DigestSink); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), SinkTimeout)
+		defer cancel()
+
+		err := digestSink.SendDigest(ctx, ComplianceDigest{
+			SchemaVersion:     SchemaVersion,
+			Cluster:           transitions[0].Cluster,
+			ClusterIdentity:   transitions[0].ClusterIdentity,
+			KubernetesVersion: s.kubernetesVersion,
+			AgentVersion:      version.Version,
+			Start:             start,
+			End:               time.Now().UTC(),
+			Transitions:       transitions,
+		})
+		if err != nil {
+			log.Error(err, "Failed to send the compliance digest", "sink", s.Name(), "policies", len(transitions))
+		}
+
+		return
+	}
+
+	for _, transition := range transitions {
+		ctx, cancel := context.WithTimeout(context.Background(), SinkTimeout)
+
+		if err := s.Sink.Send(ctx, transition); err != nil {
+			log.Error(err, "Failed to send the compliance transition of the digest", "sink", s.Name(),
+				"Namespace", transition.Namespace, "Name", transition.Policy)
+		}
+
+		cancel()
+	}
+}
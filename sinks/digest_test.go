@@ -0,0 +1,168 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sinks
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stolostron/governance-policy-status-sync/version"
+)
+
+// recordingDigestSink records the digests it receives
+type recordingDigestSink struct {
+	recordingSink
+	digests []ComplianceDigest
+}
+
+func (s *recordingDigestSink) SendDigest(_ context.Context, digest ComplianceDigest) error {
+	s.digests = append(s.digests, digest)
+
+	return nil
+}
+
+func TestTransitionDigest(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		// transitions are namespace/policy:previous>compliance
+		transitions []string
+		// expected are namespace/policy:previous>compliance*held in the order the policies were first held
+		expected string
+	}{
+		"no transitions":    {nil, ""},
+		"single transition": {[]string{"ns/a:Compliant>NonCompliant"}, "ns/a:Compliant>NonCompliant*1"},
+		"flapping policy": {
+			[]string{"ns/a:Compliant>NonCompliant", "ns/a:NonCompliant>Compliant", "ns/a:Compliant>NonCompliant"},
+			"ns/a:Compliant>NonCompliant*3",
+		},
+		"back to the previous state": {
+			[]string{"ns/a:Compliant>NonCompliant", "ns/a:NonCompliant>Compliant"}, "ns/a:Compliant>Compliant*2",
+		},
+		"first held order": {
+			[]string{"ns/b:Compliant>NonCompliant", "ns/a:>Compliant", "ns/b:NonCompliant>Compliant"},
+			"ns/b:Compliant>Compliant*2,ns/a:>Compliant*1",
+		},
+		"same policy name in other namespaces": {
+			[]string{"ns1/a:>Compliant", "ns2/a:>NonCompliant"}, "ns1/a:>Compliant*1,ns2/a:>NonCompliant*1",
+		},
+	}
+
+	for name, test := range tests {
+		digest := transitionDigest{}
+		before := time.Now().UTC()
+
+		for _, transition := range test.transitions {
+			var namespace, policy, previous, compliance string
+
+			fields := strings.FieldsFunc(transition, func(r rune) bool { return strings.ContainsRune("/:>", r) })
+			if len(fields) == 3 {
+				namespace, policy, compliance = fields[0], fields[1], fields[2]
+			} else {
+				namespace, policy, previous, compliance = fields[0], fields[1], fields[2], fields[3]
+			}
+
+			digest.add(ComplianceTransition{
+				Namespace: namespace, Policy: policy, PreviousCompliance: previous, Compliance: compliance,
+			})
+		}
+
+		held, start := digest.take()
+
+		summary := []string{}
+		for _, transition := range held {
+			summary = append(summary, fmt.Sprintf("%s/%s:%s>%s*%d", transition.Namespace, transition.Policy,
+				transition.PreviousCompliance, transition.Compliance, transition.HeldTransitions))
+		}
+
+		if actual := strings.Join(summary, ","); actual != test.expected {
+			t.Fatalf("%s: expected the digest %q, got %q", name, test.expected, actual)
+		}
+
+		if len(test.transitions) != 0 && start.Before(before) {
+			t.Fatalf("%s: expected the digest to start when the first transition was held, got %v", name, start)
+		}
+
+		// the digest is emptied
+		if held, _ := digest.take(); len(held) != 0 {
+			t.Fatalf("%s: expected the digest to be empty after it was taken, got %d transitions", name, len(held))
+		}
+	}
+}
+
+func TestPeriodicDigestSink(t *testing.T) {
+	t.Parallel()
+
+	transitions := []ComplianceTransition{
+		{Cluster: "managed", Namespace: "policies", Policy: "a", PreviousCompliance: "Compliant"},
+		{Cluster: "managed", Namespace: "policies", Policy: "b", PreviousCompliance: "Compliant"},
+		{Cluster: "managed", Namespace: "policies", Policy: "a", PreviousCompliance: "NonCompliant"},
+	}
+
+	tests := map[string]struct {
+		digestSink bool
+		// sent are the policies sent as transitions, and digested the policies of the digest message
+		sent     string
+		digested string
+	}{
+		"digest sink":     {true, "", "a,b"},
+		"transition sink": {false, "a,b", ""},
+	}
+
+	for name, test := range tests {
+		recording := &recordingDigestSink{}
+
+		var wrapped Sink = &recording.recordingSink
+		if test.digestSink {
+			wrapped = recording
+		}
+
+		// the interval is long enough that the digest is only sent by the explicit flush
+		sink := NewPeriodicDigestSink(wrapped, time.Hour, "v1.22.1")
+
+		for _, transition := range transitions {
+			if err := sink.Send(context.TODO(), transition); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+
+		if len(recording.policies) != 0 || len(recording.digests) != 0 {
+			t.Fatalf("%s: expected the transitions to be held until the end of the interval", name)
+		}
+
+		sink.timer.Stop()
+		sink.flush()
+
+		if sent := strings.Join(recording.policies, ","); sent != test.sent {
+			t.Fatalf("%s: expected the policies %q to be sent, got %q", name, test.sent, sent)
+		}
+
+		digested := []string{}
+
+		for _, digest := range recording.digests {
+			if digest.SchemaVersion != SchemaVersion || digest.Cluster != "managed" ||
+				digest.KubernetesVersion != "v1.22.1" || digest.AgentVersion != version.Version {
+				t.Fatalf("%s: unexpected digest metadata %+v", name, digest)
+			}
+
+			for _, transition := range digest.Transitions {
+				digested = append(digested, transition.Policy)
+			}
+		}
+
+		if actual := strings.Join(digested, ","); actual != test.digested {
+			t.Fatalf("%s: expected the policies %q in the digest, got %q", name, test.digested, actual)
+		}
+
+		// the flush of an empty digest doesn't send anything
+		recording.policies = nil
+		sink.flush()
+
+		if len(recording.policies) != 0 || len(recording.digests) > 1 {
+			t.Fatalf("%s: expected nothing to be sent without held transitions", name)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sinks
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker/decls"
+	"google.golang.org/protobuf/proto"
+)
+
+// Filter is a compiled CEL expression for compliance transitions, such as
+// `state == 'NonCompliant' && severity in ['high', 'critical']`. The variables are cluster, clusterIdentity,
+// policyNamespace, policy, state, previousState, severity, and channel, which are all strings. The namespace of the
+// policy is policyNamespace since namespace is a reserved word in CEL.
+type Filter struct {
+	expression string
+	program    cel.Program
+}
+
+// NewFilter compiles the CEL expression. It returns an error if the expression is invalid, uses an undeclared
+// variable or an operator with operands of the wrong types, or doesn't evaluate to a boolean.
+func NewFilter(expression string) (*Filter, error) {
+	env, err := cel.NewEnv(
+		cel.Declarations(
+			decls.NewVar("cluster", decls.String),
+			decls.NewVar("clusterIdentity", decls.String),
+			decls.NewVar("policyNamespace", decls.String),
+			decls.NewVar("policy", decls.String),
+			decls.NewVar("state", decls.String),
+			decls.NewVar("previousState", decls.String),
+			decls.NewVar("severity", decls.String),
+			decls.NewVar("channel", decls.String),
+		),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	ast, issues := env.Compile(expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, fmt.Errorf("invalid filter expression %q: %w", expression, issues.Err())
+	}
+
+	if !proto.Equal(ast.ResultType(), decls.Bool) {
+		return nil, fmt.Errorf("invalid filter expression %q: the expression evaluates to %s instead of bool",
+			expression, cel.FormatType(ast.ResultType()))
+	}
+
+	program, err := env.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter expression %q: %w", expression, err)
+	}
+
+	return &Filter{expression: expression, program: program}, nil
+}
+
+// Matches returns true if the transition matches the filter expression. The transition doesn't match if the
+// evaluation fails, such as for an invalid regular expression in matches().
+func (f *Filter) Matches(transition ComplianceTransition) bool {
+	result, _, err := f.program.Eval(transitionVariables(transition))
+	if err != nil {
+		log.Error(err, "Failed to evaluate the sink filter expression", "expression", f.expression,
+			"policy", transition.Policy)
+
+		return false
+	}
+
+	matches, ok := result.Value().(bool)
+
+	return ok && matches
+}
+
+// transitionVariables returns the values of the filter expression variables for the transition
+func transitionVariables(transition ComplianceTransition) map[string]interface{} {
+	return map[string]interface{}{
+		"cluster":         transition.Cluster,
+		"clusterIdentity": transition.ClusterIdentity,
+		"policyNamespace": transition.Namespace,
+		"policy":          transition.Policy,
+		"state":           transition.Compliance,
+		"previousState":   transition.PreviousCompliance,
+		"severity":        transition.Severity,
+		"channel":         transition.Channel,
+	}
+}
+
+// FilteredSink only sends the compliance transitions that match the filter to the wrapped sink
+type FilteredSink struct {
+	Sink
+	filter *Filter
+}
+
+// blank assignment to verify that FilteredSink implements Sink
+var _ Sink = &FilteredSink{}
+
+// NewFilteredSink returns a sink that sends the transitions matching the filter to the wrapped sink
+func NewFilteredSink(wrapped Sink, filter *Filter) *FilteredSink {
+	return &FilteredSink{Sink: wrapped, filter: filter}
+}
+
+// Send delivers the transition to the wrapped sink if it matches the filter
+func (s *FilteredSink) Send(ctx context.Context, transition ComplianceTransition) error {
+	if !s.filter.Matches(transition) {
+		return nil
+	}
+
+	return s.Sink.Send(ctx, transition)
+}
@@ -0,0 +1,183 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sinks
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func filterTransition() ComplianceTransition {
+	return ComplianceTransition{
+		Cluster:            "managed1",
+		ClusterIdentity:    "1234",
+		Namespace:          "policies",
+		Policy:             "policy-pod",
+		PreviousCompliance: "Compliant",
+		Compliance:         "NonCompliant",
+		Severity:           "high",
+		Channel:            "payments-team",
+	}
+}
+
+func TestFilterMatches(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		expression string
+		expected   bool
+	}{
+		"request example":           {`state == 'NonCompliant' && severity in ['high','critical']`, true},
+		"request example spaced":    {`state == 'NonCompliant' && severity in ['high', 'critical']`, true},
+		"not in the list":           {`severity in ['low', 'medium']`, false},
+		"empty list":                {`severity in []`, false},
+		"not equal":                 {`previousState != 'Compliant'`, false},
+		"double quotes":             {`channel == "payments-team"`, true},
+		"escaped quote":             {`policy == 'policy\'pod'`, false},
+		"all variables":             {allVariablesExpression, true},
+		"or":                        {`state == 'Compliant' || severity == 'high'`, true},
+		"or neither":                {`state == 'Compliant' || severity == 'low'`, false},
+		"not":                       {`!(state == 'Compliant')`, true},
+		"double not":                {`!!(state == 'Compliant')`, false},
+		"and binds tighter than or": {`true || false && false`, true},
+		"parentheses":               {`(true || false) && false`, false},
+		"bool equality":             {`(state == 'NonCompliant') == true`, true},
+		"bool inequality":           {`false != (severity == 'high')`, true},
+		"list equality":             {`['a', 'b'] == ['a', 'b']`, true},
+		"list order":                {`['a', 'b'] == ['b', 'a']`, false},
+		"list length":               {`['a'] != ['a', 'b']`, true},
+		"literal":                   {`true`, true},
+		"starts with":               {`policy.startsWith('policy-')`, true},
+		"ends with":                 {`!policyNamespace.endsWith('-dev')`, true},
+		"contains":                  {`channel.contains('payments')`, true},
+		"regular expression":        {`cluster.matches('^managed[0-9]+$')`, true},
+		"size":                      {`size(clusterIdentity) == 4`, true},
+		"exists macro":              {`['payments', 'billing'].exists(team, channel.startsWith(team))`, true},
+		"all macro":                 {`['high', 'critical'].all(s, s != severity)`, false},
+		"conditional":               {`state == 'NonCompliant' ? severity == 'high' : false`, true},
+	}
+
+	for name, test := range tests {
+		test := test
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			filter, err := NewFilter(test.expression)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if matches := filter.Matches(filterTransition()); matches != test.expected {
+				t.Fatalf("expected %q to evaluate to %v, got %v", test.expression, test.expected, matches)
+			}
+		})
+	}
+}
+
+const allVariablesExpression = `cluster == 'managed1' && clusterIdentity == '1234' && policyNamespace == 'policies' && ` +
+	`policy == 'policy-pod' && state == 'NonCompliant' && previousState == 'Compliant' && severity == 'high' && ` +
+	`channel == 'payments-team'`
+
+func TestNewFilterErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		expression string
+		expected   string
+	}{
+		"empty":               {``, "Syntax error"},
+		"unterminated string": {`state == 'NonCompliant`, "Syntax error"},
+		"assignment":          {`state = 'NonCompliant'`, "Syntax error"},
+		"missing parenthesis": {`(state == 'NonCompliant'`, "Syntax error"},
+		"undeclared variable": {`status == 'NonCompliant'`, "undeclared reference to 'status'"},
+		"string result":       {`state`, "the expression evaluates to string instead of bool"},
+		"list result":         {`['high']`, "the expression evaluates to list(string) instead of bool"},
+		"string and bool":     {`state == true`, "found no matching overload for '_==_'"},
+		"in a string":         {`severity in 'high'`, "found no matching overload for '@in'"},
+		"not a string":        {`!state`, "found no matching overload for '!_'"},
+		"and a string":        {`false && severity`, "found no matching overload for '_&&_'"},
+		"unknown function":    {`state.lowerAscii() == 'noncompliant'`, "undeclared reference to 'lowerAscii'"},
+		"reserved namespace":  {`namespace == 'policies'`, "reserved identifier: namespace"},
+	}
+
+	for name, test := range tests {
+		test := test
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewFilter(test.expression)
+			if err == nil {
+				t.Fatalf("expected %q to be invalid", test.expression)
+			}
+
+			if !strings.Contains(err.Error(), test.expected) {
+				t.Fatalf("expected the error to contain %q, got %v", test.expected, err)
+			}
+		})
+	}
+}
+
+func TestFilterEvaluationError(t *testing.T) {
+	t.Parallel()
+
+	filter, err := NewFilter(`policy.matches(channel)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transition := filterTransition()
+	transition.Channel = "("
+
+	if filter.Matches(transition) {
+		t.Fatal("expected a transition that fails the evaluation to not match")
+	}
+}
+
+// recordingSink records the policies of the transitions it receives
+type recordingSink struct {
+	policies []string
+}
+
+func (s *recordingSink) Name() string {
+	return "recording"
+}
+
+func (s *recordingSink) Send(_ context.Context, transition ComplianceTransition) error {
+	s.policies = append(s.policies, transition.Policy)
+
+	return nil
+}
+
+func TestFilteredSink(t *testing.T) {
+	t.Parallel()
+
+	filter, err := NewFilter(`state == 'NonCompliant' && severity in ['high','critical']`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recording := &recordingSink{}
+	sink := NewFilteredSink(recording, filter)
+
+	for _, transition := range []ComplianceTransition{
+		{Policy: "high", Compliance: "NonCompliant", Severity: "high"},
+		{Policy: "low", Compliance: "NonCompliant", Severity: "low"},
+		{Policy: "compliant", Compliance: "Compliant", Severity: "critical"},
+		{Policy: "critical", Compliance: "NonCompliant", Severity: "critical"},
+	} {
+		if err := sink.Send(context.TODO(), transition); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if sent := strings.Join(recording.policies, ","); sent != "high,critical" {
+		t.Fatalf("expected only the matching transitions to be sent, got %s", sent)
+	}
+
+	if sink.Name() != "recording" {
+		t.Fatalf("expected the name of the wrapped sink, got %s", sink.Name())
+	}
+}
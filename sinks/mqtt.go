@@ -0,0 +1,393 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sinks
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// MQTT 3.1.1 control packet types
+const (
+	mqttConnect    byte = 1
+	mqttConnack    byte = 2
+	mqttPublish    byte = 3
+	mqttPuback     byte = 4
+	mqttDisconnect byte = 14
+)
+
+const (
+	mqttDefaultPort    = "1883"
+	mqttDefaultTLSPort = "8883"
+	mqttTimeout        = 10 * time.Second
+	// mqttPacketID is the packet identifier of QoS 1 messages. Since a connection is only used for a single
+	// message, it doesn't need to be unique.
+	mqttPacketID uint16 = 1
+)
+
+// MQTTOptions configures the MQTT sink
+type MQTTOptions struct {
+	// Broker is the URL of the MQTT broker, e.g. tcp://broker:1883 or ssl://broker:8883
+	Broker string
+	// TopicPrefix is prepended to <cluster>/<namespace>/<policy> to form the topic
+	TopicPrefix string
+	// ChannelTopicPrefixes are the topic prefixes of the notification channels, which replace TopicPrefix for
+	// the transitions of the policies with the channel
+	ChannelTopicPrefixes map[string]string
+	ClientID             string
+	// Auth has the username and password of the broker, which are read on every send so that they can be
+	// rotated. The connections aren't authenticated if it's nil.
+	Auth PasswordAuthProvider
+	// QoS is the MQTT quality of service level, which is either 0 or 1
+	QoS      byte
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	// Compression compresses the payloads, which consumers detect from the gzip magic bytes since MQTT 3.1.1
+	// messages have no headers
+	Compression *Compression
+}
+
+// MQTTSink publishes compliance transitions to an MQTT broker. This is an alternative transport for edge
+// environments where access to the hub API is restricted. Since transitions are infrequent, a new
+// connection is made for every message, which avoids keeping a connection alive.
+type MQTTSink struct {
+	options MQTTOptions
+	address string
+	useTLS  bool
+}
+
+// blank assignment to verify that MQTTSink implements DigestSink
+var _ DigestSink = &MQTTSink{}
+
+// NewMQTTSink validates the options and returns an MQTTSink
+func NewMQTTSink(options MQTTOptions) (*MQTTSink, error) {
+	brokerURL, err := url.Parse(options.Broker)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MQTT broker URL: %w", err)
+	}
+
+	sink := &MQTTSink{options: options}
+
+	port := mqttDefaultPort
+
+	switch brokerURL.Scheme {
+	case "tcp", "mqtt":
+	case "ssl", "tls", "mqtts":
+		sink.useTLS = true
+		port = mqttDefaultTLSPort
+	default:
+		return nil, fmt.Errorf("unsupported MQTT broker URL scheme %q", brokerURL.Scheme)
+	}
+
+	if brokerURL.Port() != "" {
+		port = brokerURL.Port()
+	}
+
+	sink.address = net.JoinHostPort(brokerURL.Hostname(), port)
+
+	if options.QoS > 1 {
+		return nil, fmt.Errorf("unsupported MQTT QoS %d, only 0 and 1 are supported", options.QoS)
+	}
+
+	if options.ClientID == "" {
+		return nil, errors.New("an MQTT client ID is required")
+	}
+
+	return sink, nil
+}
+
+// Name identifies the sink in logs
+func (m *MQTTSink) Name() string {
+	return "mqtt"
+}
+
+// topicPrefix returns the topic prefix of the notification channel, which is TopicPrefix if the channel has
+// no topic prefix
+func (m *MQTTSink) topicPrefix(channel string) string {
+	prefix, ok := m.options.ChannelTopicPrefixes[channel]
+	if !ok || channel == "" {
+		prefix = m.options.TopicPrefix
+	}
+
+	return strings.TrimSuffix(prefix, "/")
+}
+
+// Send publishes the transition as JSON to <prefix>/<cluster>/<namespace>/<policy>, where the prefix is
+// the one of the channel of the transition
+func (m *MQTTSink) Send(ctx context.Context, transition ComplianceTransition) error {
+	payload, err := encodeRecord(&transition)
+	if err != nil {
+		return err
+	}
+
+	topic := strings.Join([]string{
+		m.topicPrefix(transition.Channel), transition.Cluster, transition.Namespace, transition.Policy,
+	}, "/")
+
+	return m.publish(ctx, strings.TrimPrefix(topic, "/"), payload)
+}
+
+// SendDigest publishes the digest as JSON to <prefix>/<cluster>/digest. The transitions of the channels with
+// a topic prefix are published in a digest of their own to the prefix of the channel.
+func (m *MQTTSink) SendDigest(ctx context.Context, digest ComplianceDigest) error {
+	prefixes := []string{}
+	transitions := map[string][]ComplianceTransition{}
+
+	for _, transition := range digest.Transitions {
+		prefix := m.topicPrefix(transition.Channel)
+		if _, ok := transitions[prefix]; !ok {
+			prefixes = append(prefixes, prefix)
+		}
+
+		transitions[prefix] = append(transitions[prefix], transition)
+	}
+
+	for _, prefix := range prefixes {
+		routed := digest
+		routed.Transitions = transitions[prefix]
+
+		payload, err := encodeRecord(&routed)
+		if err != nil {
+			return err
+		}
+
+		topic := strings.Join([]string{prefix, digest.Cluster, "digest"}, "/")
+
+		if err := m.publish(ctx, strings.TrimPrefix(topic, "/"), payload); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *MQTTSink) dial(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: mqttTimeout}
+
+	if !m.useTLS {
+		return dialer.DialContext(ctx, "tcp", m.address)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if m.options.CAFile != "" {
+		caBundle, err := ioutil.ReadFile(m.options.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caBundle) {
+			return nil, fmt.Errorf("no certificates were found in %s", m.options.CAFile)
+		}
+	}
+
+	if m.options.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(m.options.CertFile, m.options.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", m.address)
+}
+
+// publish connects to the broker, publishes the message, and disconnects
+func (m *MQTTSink) publish(ctx context.Context, topic string, payload []byte) error {
+	payload, _, err := m.options.Compression.compress(payload)
+	if err != nil {
+		return err
+	}
+
+	conn, err := m.dial(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to the MQTT broker: %w", err)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(mqttTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(conn)
+
+	connectPacket, err := m.connectPacket()
+	if err != nil {
+		return err
+	}
+
+	if _, err := conn.Write(connectPacket); err != nil {
+		return err
+	}
+
+	packetType, body, err := readPacket(reader)
+	if err != nil {
+		return fmt.Errorf("failed to read the MQTT CONNACK: %w", err)
+	}
+
+	if packetType != mqttConnack || len(body) != 2 {
+		return fmt.Errorf("unexpected MQTT packet type %d instead of CONNACK", packetType)
+	}
+
+	if body[1] != 0 {
+		return fmt.Errorf("the MQTT broker refused the connection with return code %d", body[1])
+	}
+
+	var header byte = mqttPublish << 4
+	variableHeader := encodeString(topic)
+
+	if m.options.QoS == 1 {
+		header |= 1 << 1
+		variableHeader = append(variableHeader, byte(mqttPacketID>>8), byte(mqttPacketID))
+	}
+
+	if _, err := conn.Write(encodePacket(header, append(variableHeader, payload...))); err != nil {
+		return err
+	}
+
+	if m.options.QoS == 1 {
+		packetType, body, err := readPacket(reader)
+		if err != nil {
+			return fmt.Errorf("failed to read the MQTT PUBACK: %w", err)
+		}
+
+		if packetType != mqttPuback || len(body) != 2 || binary.BigEndian.Uint16(body) != mqttPacketID {
+			return fmt.Errorf("unexpected MQTT packet type %d instead of PUBACK", packetType)
+		}
+	}
+
+	_, err = conn.Write(encodePacket(mqttDisconnect<<4, nil))
+
+	return err
+}
+
+// connectPacket returns a CONNECT packet with a clean session and keep alive disabled. A password without a
+// username is an error, since MQTT 3.1.1 doesn't allow it and the brokers close the connection.
+func (m *MQTTSink) connectPacket() ([]byte, error) {
+	var flags byte = 1 << 1
+
+	body := encodeString("MQTT")
+	// protocol level 4 is MQTT 3.1.1
+	body = append(body, 4)
+
+	payload := encodeString(m.options.ClientID)
+
+	if m.options.Auth != nil {
+		username, password, err := m.options.Auth.Credentials()
+		if err != nil {
+			return nil, err
+		}
+
+		if username == "" && password != "" {
+			return nil, errors.New("the MQTT broker password requires a username")
+		}
+
+		if username != "" {
+			flags |= 1 << 7
+
+			payload = append(payload, encodeString(username)...)
+		}
+
+		if password != "" {
+			flags |= 1 << 6
+
+			payload = append(payload, encodeString(password)...)
+		}
+	}
+
+	body = append(body, flags, 0, 0)
+
+	return encodePacket(mqttConnect<<4, append(body, payload...)), nil
+}
+
+// encodeString encodes a UTF-8 string prefixed with its two byte length
+func encodeString(value string) []byte {
+	encoded := make([]byte, 2, 2+len(value))
+	binary.BigEndian.PutUint16(encoded, uint16(len(value)))
+
+	return append(encoded, value...)
+}
+
+// encodePacket prefixes the body with the fixed header and the variable length encoded remaining length
+func encodePacket(header byte, body []byte) []byte {
+	var buf bytes.Buffer
+
+	buf.WriteByte(header)
+
+	length := len(body)
+
+	for {
+		digit := byte(length % 128)
+		length /= 128
+
+		if length > 0 {
+			digit |= 128
+		}
+
+		buf.WriteByte(digit)
+
+		if length == 0 {
+			break
+		}
+	}
+
+	buf.Write(body)
+
+	return buf.Bytes()
+}
+
+// readPacket reads a packet and returns its type and body
+func readPacket(reader *bufio.Reader) (byte, []byte, error) {
+	header, err := reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length := 0
+	multiplier := 1
+
+	for i := 0; ; i++ {
+		if i == 4 {
+			return 0, nil, errors.New("malformed MQTT remaining length")
+		}
+
+		digit, err := reader.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		length += int(digit&127) * multiplier
+		multiplier *= 128
+
+		if digit&128 == 0 {
+			break
+		}
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return 0, nil, err
+	}
+
+	return header >> 4, body, nil
+}
@@ -0,0 +1,619 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sinks
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// staticPasswordAuth is a PasswordAuthProvider with fixed credentials
+type staticPasswordAuth struct {
+	username string
+	password string
+	err      error
+}
+
+func (a *staticPasswordAuth) Authorize(_ context.Context, _ *http.Request, _ []byte) error {
+	return nil
+}
+
+func (a *staticPasswordAuth) Credentials() (string, string, error) {
+	return a.username, a.password, a.err
+}
+
+func TestEncodePacketRemainingLength(t *testing.T) {
+	t.Parallel()
+
+	// the boundaries of the one to four byte remaining lengths of the MQTT 3.1.1 specification
+	tests := []struct {
+		length   int
+		expected []byte
+	}{
+		{0, []byte{0x00}},
+		{1, []byte{0x01}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x80, 0x01}},
+		{321, []byte{0xc1, 0x02}},
+		{16383, []byte{0xff, 0x7f}},
+		{16384, []byte{0x80, 0x80, 0x01}},
+		{2097151, []byte{0xff, 0xff, 0x7f}},
+		{2097152, []byte{0x80, 0x80, 0x80, 0x01}},
+	}
+
+	for _, test := range tests {
+		body := bytes.Repeat([]byte{'x'}, test.length)
+		packet := encodePacket(mqttPublish<<4, body)
+
+		if packet[0] != mqttPublish<<4 {
+			t.Fatalf("length %d: expected the header %#x, got %#x", test.length, mqttPublish<<4, packet[0])
+		}
+
+		if encoded := packet[1 : 1+len(test.expected)]; !bytes.Equal(encoded, test.expected) {
+			t.Fatalf("length %d: expected the remaining length %#v, got %#v", test.length, test.expected, encoded)
+		}
+
+		if !bytes.Equal(packet[1+len(test.expected):], body) {
+			t.Fatalf("length %d: the body doesn't follow the remaining length", test.length)
+		}
+
+		packetType, decoded, err := readPacket(bufio.NewReader(bytes.NewReader(packet)))
+		if err != nil {
+			t.Fatalf("length %d: failed to read the packet: %v", test.length, err)
+		}
+
+		if packetType != mqttPublish || len(decoded) != test.length {
+			t.Fatalf("length %d: expected a PUBLISH of %d bytes, got the type %d of %d bytes", test.length,
+				test.length, packetType, len(decoded))
+		}
+	}
+}
+
+func TestReadPacketMalformed(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string][]byte{
+		"five byte remaining length": {mqttConnack << 4, 0x80, 0x80, 0x80, 0x80, 0x01},
+		"truncated remaining length": {mqttConnack << 4, 0x80},
+		"truncated body":             {mqttConnack << 4, 0x02, 0x00},
+		"empty":                      {},
+	}
+
+	for name, packet := range tests {
+		if _, _, err := readPacket(bufio.NewReader(bytes.NewReader(packet))); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestEncodeString(t *testing.T) {
+	t.Parallel()
+
+	if encoded := encodeString("MQTT"); !bytes.Equal(encoded, []byte{0x00, 0x04, 'M', 'Q', 'T', 'T'}) {
+		t.Fatalf("unexpected encoding %#v", encoded)
+	}
+
+	if encoded := encodeString(""); !bytes.Equal(encoded, []byte{0x00, 0x00}) {
+		t.Fatalf("unexpected encoding of the empty string %#v", encoded)
+	}
+
+	long := strings.Repeat("a", 300)
+	if encoded := encodeString(long); binary.BigEndian.Uint16(encoded) != 300 || string(encoded[2:]) != long {
+		t.Fatal("unexpected encoding of a string longer than 255 bytes")
+	}
+}
+
+// connectFields are the fields decoded from a CONNECT packet
+type connectFields struct {
+	flags    byte
+	clientID string
+	username string
+	password string
+}
+
+// decodeString decodes a two byte length prefixed string from the body
+func decodeString(t *testing.T, body []byte) (string, []byte) {
+	t.Helper()
+
+	if len(body) < 2 {
+		t.Fatal("the string length is truncated")
+	}
+
+	length := int(binary.BigEndian.Uint16(body))
+	if len(body) < 2+length {
+		t.Fatal("the string is truncated")
+	}
+
+	return string(body[2 : 2+length]), body[2+length:]
+}
+
+// decodeConnect decodes the body of a CONNECT packet
+func decodeConnect(t *testing.T, body []byte) connectFields {
+	t.Helper()
+
+	protocol, rest := decodeString(t, body)
+	if protocol != "MQTT" || rest[0] != 4 {
+		t.Fatalf("expected the MQTT 3.1.1 protocol, got %q level %d", protocol, rest[0])
+	}
+
+	fields := connectFields{flags: rest[1]}
+
+	if keepAlive := binary.BigEndian.Uint16(rest[2:4]); keepAlive != 0 {
+		t.Fatalf("expected the keep alive to be disabled, got %d", keepAlive)
+	}
+
+	fields.clientID, rest = decodeString(t, rest[4:])
+
+	if fields.flags&(1<<7) != 0 {
+		fields.username, rest = decodeString(t, rest)
+	}
+
+	if fields.flags&(1<<6) != 0 {
+		fields.password, rest = decodeString(t, rest)
+	}
+
+	if len(rest) != 0 {
+		t.Fatalf("unexpected %d bytes after the CONNECT payload", len(rest))
+	}
+
+	return fields
+}
+
+func TestConnectPacketAuthFlags(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		auth     PasswordAuthProvider
+		flags    byte
+		username string
+		password string
+		err      bool
+	}{
+		"no auth": {
+			flags: 0x02,
+		},
+		"empty credentials": {
+			auth:  &staticPasswordAuth{},
+			flags: 0x02,
+		},
+		"username only": {
+			auth:     &staticPasswordAuth{username: "agent"},
+			flags:    0x82,
+			username: "agent",
+		},
+		"username and password": {
+			auth:     &staticPasswordAuth{username: "agent", password: "secret"},
+			flags:    0xc2,
+			username: "agent",
+			password: "secret",
+		},
+		// MQTT-3.1.2-22 forbids the password flag without the username flag
+		"password only": {
+			auth: &staticPasswordAuth{password: "secret"},
+			err:  true,
+		},
+		"credentials error": {
+			auth: &staticPasswordAuth{err: errors.New("unreadable")},
+			err:  true,
+		},
+	}
+
+	for name, test := range tests {
+		options := MQTTOptions{Broker: "tcp://broker", ClientID: "client"}
+		if test.auth != nil {
+			options.Auth = test.auth
+		}
+
+		sink, err := NewMQTTSink(options)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		packet, err := sink.connectPacket()
+		if test.err {
+			if err == nil {
+				t.Fatalf("%s: expected an error", name)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		packetType, body, err := readPacket(bufio.NewReader(bytes.NewReader(packet)))
+		if err != nil || packetType != mqttConnect {
+			t.Fatalf("%s: expected a CONNECT packet, got the type %d: %v", name, packetType, err)
+		}
+
+		fields := decodeConnect(t, body)
+
+		if fields.flags != test.flags || fields.clientID != "client" || fields.username != test.username ||
+			fields.password != test.password {
+			t.Fatalf("%s: unexpected CONNECT %+v", name, fields)
+		}
+	}
+}
+
+func TestNewMQTTSink(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		options MQTTOptions
+		address string
+		useTLS  bool
+		err     bool
+	}{
+		"tcp default port": {
+			options: MQTTOptions{Broker: "tcp://broker", ClientID: "client"},
+			address: "broker:1883",
+		},
+		"ssl default port": {
+			options: MQTTOptions{Broker: "ssl://broker", ClientID: "client"},
+			address: "broker:8883",
+			useTLS:  true,
+		},
+		"explicit port": {
+			options: MQTTOptions{Broker: "mqtts://broker:9000", ClientID: "client"},
+			address: "broker:9000",
+			useTLS:  true,
+		},
+		"unsupported scheme": {
+			options: MQTTOptions{Broker: "http://broker", ClientID: "client"},
+			err:     true,
+		},
+		"unsupported QoS": {
+			options: MQTTOptions{Broker: "tcp://broker", ClientID: "client", QoS: 2},
+			err:     true,
+		},
+		"no client ID": {
+			options: MQTTOptions{Broker: "tcp://broker"},
+			err:     true,
+		},
+	}
+
+	for name, test := range tests {
+		sink, err := NewMQTTSink(test.options)
+		if test.err {
+			if err == nil {
+				t.Fatalf("%s: expected an error", name)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if sink.address != test.address || sink.useTLS != test.useTLS {
+			t.Fatalf("%s: expected %s with TLS %v, got %s with TLS %v", name, test.address, test.useTLS,
+				sink.address, sink.useTLS)
+		}
+	}
+}
+
+// published is a message received by the testBroker
+type published struct {
+	connect connectFields
+	header  byte
+	topic   string
+	payload []byte
+}
+
+// testBroker is an in-process MQTT broker that accepts a single connection with a single message
+type testBroker struct {
+	listener net.Listener
+	// connackCode is the return code of the CONNACK
+	connackCode byte
+	// pubackID is the packet identifier of the PUBACK, which defaults to the one of the PUBLISH
+	pubackID uint16
+	messages chan published
+	errors   chan error
+}
+
+func newTestBroker(t *testing.T, listener net.Listener) *testBroker {
+	t.Helper()
+
+	broker := &testBroker{listener: listener, messages: make(chan published, 1), errors: make(chan error, 1)}
+
+	t.Cleanup(func() { listener.Close() })
+
+	go broker.serve(t)
+
+	return broker
+}
+
+func (b *testBroker) serve(t *testing.T) {
+	conn, err := b.listener.Accept()
+	if err != nil {
+		b.errors <- err
+
+		return
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+
+	packetType, body, err := readPacket(reader)
+	if err != nil || packetType != mqttConnect {
+		b.errors <- errors.New("expected a CONNECT packet")
+
+		return
+	}
+
+	message := published{connect: decodeConnect(t, body)}
+
+	if _, err := conn.Write(encodePacket(mqttConnack<<4, []byte{0, b.connackCode})); err != nil {
+		b.errors <- err
+
+		return
+	}
+
+	if b.connackCode != 0 {
+		b.errors <- nil
+
+		return
+	}
+
+	header, err := reader.Peek(1)
+	if err != nil {
+		b.errors <- err
+
+		return
+	}
+
+	message.header = header[0]
+
+	packetType, body, err = readPacket(reader)
+	if err != nil || packetType != mqttPublish {
+		b.errors <- errors.New("expected a PUBLISH packet")
+
+		return
+	}
+
+	message.topic, body = decodeString(t, body)
+
+	if qos := (message.header >> 1) & 3; qos == 1 {
+		packetID := binary.BigEndian.Uint16(body)
+		body = body[2:]
+
+		if b.pubackID != 0 {
+			packetID = b.pubackID
+		}
+
+		puback := make([]byte, 2)
+		binary.BigEndian.PutUint16(puback, packetID)
+
+		if _, err := conn.Write(encodePacket(mqttPuback<<4, puback)); err != nil {
+			b.errors <- err
+
+			return
+		}
+	}
+
+	message.payload = body
+	b.messages <- message
+
+	packetType, _, err = readPacket(reader)
+	if err == nil && packetType != mqttDisconnect {
+		err = errors.New("expected a DISCONNECT packet")
+	}
+
+	b.errors <- err
+}
+
+func testTransition() ComplianceTransition {
+	return ComplianceTransition{
+		SchemaVersion:      SchemaVersion,
+		Cluster:            "managed",
+		Namespace:          "managed",
+		Policy:             "default.policy",
+		PreviousCompliance: "Compliant",
+		Compliance:         "NonCompliant",
+		Timestamp:          time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+// sendToTestBroker sends the test transition with the sink options to the broker and returns the message
+func sendToTestBroker(t *testing.T, broker *testBroker, options MQTTOptions) (published, error) {
+	t.Helper()
+
+	sink, err := NewMQTTSink(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sendErr := sink.Send(ctx, testTransition())
+
+	select {
+	case err := <-broker.errors:
+		if err != nil && sendErr == nil {
+			t.Fatalf("the broker failed: %v", err)
+		}
+	case <-ctx.Done():
+		t.Fatal("the broker didn't receive the message")
+	}
+
+	select {
+	case message := <-broker.messages:
+		return message, sendErr
+	default:
+		return published{}, sendErr
+	}
+}
+
+func listenTCP(t *testing.T) net.Listener {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return listener
+}
+
+func TestMQTTSinkPublish(t *testing.T) {
+	t.Parallel()
+
+	for _, qos := range []byte{0, 1} {
+		broker := newTestBroker(t, listenTCP(t))
+
+		message, err := sendToTestBroker(t, broker, MQTTOptions{
+			Broker:      "tcp://" + broker.listener.Addr().String(),
+			TopicPrefix: "compliance/",
+			ClientID:    "client",
+			Auth:        &staticPasswordAuth{username: "agent", password: "secret"},
+			QoS:         qos,
+		})
+		if err != nil {
+			t.Fatalf("QoS %d: %v", qos, err)
+		}
+
+		if message.connect.username != "agent" || message.connect.password != "secret" {
+			t.Fatalf("QoS %d: unexpected credentials %+v", qos, message.connect)
+		}
+
+		if message.header != mqttPublish<<4|qos<<1 {
+			t.Fatalf("QoS %d: unexpected PUBLISH header %#x", qos, message.header)
+		}
+
+		if message.topic != "compliance/managed/managed/default.policy" {
+			t.Fatalf("QoS %d: unexpected topic %s", qos, message.topic)
+		}
+
+		transition := ComplianceTransition{}
+		if err := json.Unmarshal(message.payload, &transition); err != nil {
+			t.Fatalf("QoS %d: invalid payload: %v", qos, err)
+		}
+
+		if transition.Compliance != "NonCompliant" || transition.Policy != "default.policy" {
+			t.Fatalf("QoS %d: unexpected transition %+v", qos, transition)
+		}
+	}
+}
+
+func TestMQTTSinkPublishErrors(t *testing.T) {
+	t.Parallel()
+
+	refusing := newTestBroker(t, listenTCP(t))
+	refusing.connackCode = 5
+
+	_, err := sendToTestBroker(t, refusing, MQTTOptions{
+		Broker: "tcp://" + refusing.listener.Addr().String(), ClientID: "client",
+	})
+	if err == nil || !strings.Contains(err.Error(), "return code 5") {
+		t.Fatalf("expected the refused connection error, got %v", err)
+	}
+
+	wrongPuback := newTestBroker(t, listenTCP(t))
+	wrongPuback.pubackID = 7
+
+	_, err = sendToTestBroker(t, wrongPuback, MQTTOptions{
+		Broker: "tcp://" + wrongPuback.listener.Addr().String(), ClientID: "client", QoS: 1,
+	})
+	if err == nil || !strings.Contains(err.Error(), "PUBACK") {
+		t.Fatalf("expected the PUBACK error, got %v", err)
+	}
+}
+
+// writeTestCA writes a self-signed certificate for 127.0.0.1 to a CA file and returns the file and the
+// certificate to serve
+func writeTestCA(t *testing.T) (string, tls.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "broker"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+
+	err = os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return caFile, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestMQTTSinkPublishTLS(t *testing.T) {
+	t.Parallel()
+
+	caFile, cert := writeTestCA(t)
+
+	listener := tls.NewListener(listenTCP(t), &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	})
+	broker := newTestBroker(t, listener)
+
+	message, err := sendToTestBroker(t, broker, MQTTOptions{
+		Broker:   "ssl://" + listener.Addr().String(),
+		ClientID: "client",
+		CAFile:   caFile,
+		QoS:      1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if message.topic != "managed/managed/default.policy" {
+		t.Fatalf("unexpected topic %s", message.topic)
+	}
+
+	untrusted := newTestBroker(t, tls.NewListener(listenTCP(t), &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}))
+
+	sink, err := NewMQTTSink(MQTTOptions{Broker: "ssl://" + untrusted.listener.Addr().String(), ClientID: "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sink.Send(context.Background(), testTransition()); err == nil {
+		t.Fatal("expected the certificate of the broker to be untrusted without the CA file")
+	}
+}
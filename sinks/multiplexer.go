@@ -0,0 +1,147 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sinks
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// SinkTimeout bounds how long sending a compliance transition to a single sink may take
+const SinkTimeout = 10 * time.Second
+
+// SinkControls are the controls of a sink in a Multiplexer
+type SinkControls struct {
+	// Enabled sends the compliance transitions to the sink
+	Enabled bool
+	// RateLimit is the maximum number of compliance transitions sent to the sink per second, over which they
+	// are dropped. If it's 0, the transitions aren't limited.
+	RateLimit float64
+}
+
+// ParseSinkControls parses the controls of each sink name, which are "on", "off", or a rate limit such as
+// "5/s" or "30/m" that also enables the sink
+func ParseSinkControls(values map[string]string) (map[string]SinkControls, error) {
+	controls := map[string]SinkControls{}
+
+	for name, value := range values {
+		switch value {
+		case "on":
+			controls[name] = SinkControls{Enabled: true}
+		case "off":
+			controls[name] = SinkControls{}
+		default:
+			parts := strings.SplitN(value, "/", 2)
+
+			count, err := strconv.ParseFloat(parts[0], 64)
+			if err != nil || count <= 0 || len(parts) != 2 || (parts[1] != "s" && parts[1] != "m") {
+				return nil, fmt.Errorf("invalid controls %q of the sink %s, they must be on, off, or a rate such "+
+					"as 5/s or 30/m", value, name)
+			}
+
+			if parts[1] == "m" {
+				count /= 60
+			}
+
+			controls[name] = SinkControls{Enabled: true, RateLimit: count}
+		}
+	}
+
+	return controls, nil
+}
+
+// multiplexedSink is a sink of a Multiplexer with its rate limiter
+type multiplexedSink struct {
+	sink    Sink
+	limiter flowcontrol.RateLimiter
+}
+
+// Multiplexer sends the same compliance transitions to several sinks, so that the external sinks and the
+// recorders of compliance events receive them through one pipeline. Each sink can be disabled or rate
+// limited by its controls.
+type Multiplexer struct {
+	sinks []multiplexedSink
+}
+
+// blank assignment to verify that Multiplexer implements Sink
+var _ Sink = &Multiplexer{}
+
+// NewMultiplexer returns a Multiplexer of the sinks that are enabled. The sinks in the controls have their
+// controls, and the other sinks are enabled if they are in enabledByDefault.
+func NewMultiplexer(
+	sinks []Sink, controls map[string]SinkControls, enabledByDefault func(Sink) bool,
+) *Multiplexer {
+	multiplexer := &Multiplexer{}
+
+	for _, sink := range sinks {
+		sinkControls, ok := controls[sink.Name()]
+		if !ok {
+			sinkControls = SinkControls{Enabled: enabledByDefault(sink)}
+		}
+
+		if !sinkControls.Enabled {
+			continue
+		}
+
+		multiplexed := multiplexedSink{sink: sink}
+
+		if sinkControls.RateLimit > 0 {
+			burst := int(sinkControls.RateLimit)
+			if burst < 1 {
+				burst = 1
+			}
+
+			multiplexed.limiter = flowcontrol.NewTokenBucketRateLimiter(float32(sinkControls.RateLimit), burst)
+		}
+
+		multiplexer.sinks = append(multiplexer.sinks, multiplexed)
+	}
+
+	return multiplexer
+}
+
+// Name identifies the sink in logs
+func (m *Multiplexer) Name() string {
+	names := make([]string, 0, len(m.sinks))
+	for _, multiplexed := range m.sinks {
+		names = append(names, multiplexed.sink.Name())
+	}
+
+	return strings.Join(names, ",")
+}
+
+// Empty returns true if the Multiplexer has no enabled sinks
+func (m *Multiplexer) Empty() bool {
+	return len(m.sinks) == 0
+}
+
+// Send delivers the transition to each sink that isn't over its rate limit, each within SinkTimeout. All the
+// sinks are attempted, and the errors name the sinks that failed.
+func (m *Multiplexer) Send(ctx context.Context, transition ComplianceTransition) error {
+	errs := []error{}
+
+	for _, multiplexed := range m.sinks {
+		if multiplexed.limiter != nil && !multiplexed.limiter.TryAccept() {
+			log.V(1).Info("Dropped the compliance transition over the rate limit of the sink",
+				"sink", multiplexed.sink.Name(), "Namespace", transition.Namespace, "Name", transition.Policy)
+
+			continue
+		}
+
+		sinkCtx, cancel := context.WithTimeout(ctx, SinkTimeout)
+
+		if err := multiplexed.sink.Send(sinkCtx, transition); err != nil {
+			errs = append(errs, fmt.Errorf("sink %s: %w", multiplexed.sink.Name(), err))
+		}
+
+		cancel()
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
@@ -0,0 +1,286 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sinks
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxQuietWindow is the longest duration of a quiet hours window
+const maxQuietWindow = 7 * 24 * time.Hour
+
+// cronField is the set of the values of a field of a cron schedule that it matches
+type cronField struct {
+	values map[int]bool
+	// any is true if the field is *, so that it doesn't restrict the day of the month or of the week
+	any bool
+}
+
+// quietWindow is a window of the quiet hours, which starts at each time the schedule matches and lasts for
+// the duration
+type quietWindow struct {
+	minute, hour, dayOfMonth, month, dayOfWeek cronField
+	duration                                   time.Duration
+}
+
+// QuietHours are the windows in which the compliance transitions aren't sent to the external sinks, such as
+// during planned maintenance
+type QuietHours struct {
+	windows  []quietWindow
+	location *time.Location
+}
+
+// NewQuietHours parses the quiet hours windows, which are a cron schedule of when a window starts followed by
+// its duration, such as "0 22 * * 5 56h" for the weekends from 22:00 on Friday. The schedules are in the
+// location. The cron fields are the minute, hour, day of the month, month, and day of the week, from 0 for
+// Sunday, and support *, lists, ranges, and steps.
+func NewQuietHours(windows []string, location *time.Location) (*QuietHours, error) {
+	quietHours := &QuietHours{location: location}
+
+	for _, window := range windows {
+		parsed, err := parseQuietWindow(window)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quiet hours window %q: %w", window, err)
+		}
+
+		quietHours.windows = append(quietHours.windows, parsed)
+	}
+
+	return quietHours, nil
+}
+
+// parseQuietWindow parses a cron schedule followed by a duration
+func parseQuietWindow(window string) (quietWindow, error) {
+	fields := strings.Fields(window)
+	if len(fields) != 6 {
+		return quietWindow{}, fmt.Errorf("it must be a cron schedule of 5 fields followed by a duration")
+	}
+
+	duration, err := time.ParseDuration(fields[5])
+	if err != nil || duration <= 0 || duration > maxQuietWindow {
+		return quietWindow{}, fmt.Errorf("the duration %q must be positive and at most %s", fields[5],
+			maxQuietWindow)
+	}
+
+	parsed := quietWindow{duration: duration}
+
+	for i, field := range []struct {
+		value    *cronField
+		min, max int
+	}{
+		{&parsed.minute, 0, 59},
+		{&parsed.hour, 0, 23},
+		{&parsed.dayOfMonth, 1, 31},
+		{&parsed.month, 1, 12},
+		{&parsed.dayOfWeek, 0, 7},
+	} {
+		*field.value, err = parseCronField(fields[i], field.min, field.max)
+		if err != nil {
+			return quietWindow{}, err
+		}
+	}
+
+	// 7 is another name for Sunday
+	if parsed.dayOfWeek.values[7] {
+		parsed.dayOfWeek.values[0] = true
+	}
+
+	return parsed, nil
+}
+
+// parseCronField parses a cron field of comma-separated values, ranges, or *, each with an optional step
+func parseCronField(field string, min, max int) (cronField, error) {
+	parsed := cronField{values: map[int]bool{}, any: field == "*"}
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+
+		if parts := strings.SplitN(part, "/", 2); len(parts) == 2 {
+			var err error
+
+			step, err = strconv.Atoi(parts[1])
+			if err != nil || step <= 0 {
+				return cronField{}, fmt.Errorf("invalid step in the cron field %q", field)
+			}
+
+			part = parts[0]
+		}
+
+		low, high := min, max
+
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+
+			var err error
+
+			low, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return cronField{}, fmt.Errorf("invalid value in the cron field %q", field)
+			}
+
+			high = low
+			if len(bounds) == 2 {
+				high, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return cronField{}, fmt.Errorf("invalid range in the cron field %q", field)
+				}
+			}
+		}
+
+		if low < min || high > max || low > high {
+			return cronField{}, fmt.Errorf("the cron field %q must be between %d and %d", field, min, max)
+		}
+
+		for value := low; value <= high; value += step {
+			parsed.values[value] = true
+		}
+	}
+
+	return parsed, nil
+}
+
+// matches returns true if the schedule of the window matches the minute. Like in cron, a restricted day of
+// the month and day of the week match if either matches.
+func (w *quietWindow) matches(t time.Time) bool {
+	if !w.minute.values[t.Minute()] || !w.hour.values[t.Hour()] || !w.month.values[int(t.Month())] {
+		return false
+	}
+
+	dayOfMonth := w.dayOfMonth.values[t.Day()]
+	dayOfWeek := w.dayOfWeek.values[int(t.Weekday())]
+
+	if w.dayOfMonth.any || w.dayOfWeek.any {
+		return dayOfMonth && dayOfWeek
+	}
+
+	return dayOfMonth || dayOfWeek
+}
+
+// End returns when the quiet hours at the time end, and false if it's not in a quiet hours window. When
+// windows overlap, the latest end of the windows that the time is in is returned.
+func (q *QuietHours) End(now time.Time) (time.Time, bool) {
+	now = now.In(q.location)
+
+	var end time.Time
+
+	for i := range q.windows {
+		window := &q.windows[i]
+
+		// the latest start of the window is the first minute that matches going back from now
+		for start := now.Truncate(time.Minute); now.Sub(start) < window.duration; start = start.Add(-time.Minute) {
+			if window.matches(start) {
+				if windowEnd := start.Add(window.duration); windowEnd.After(end) {
+					end = windowEnd
+				}
+
+				break
+			}
+		}
+	}
+
+	return end, !end.IsZero()
+}
+
+// QuietHoursSink doesn't send the compliance transitions during the quiet hours to the wrapped sink. When
+// the digest is enabled, the transitions are held instead, and the last transition of each policy is sent
+// once the quiet hours end, from the compliance state that the policy had before the quiet hours.
+type QuietHoursSink struct {
+	Sink
+	quietHours *QuietHours
+	digest     bool
+	lock       sync.Mutex
+	held       transitionDigest
+	timer      *time.Timer
+}
+
+// blank assignment to verify that QuietHoursSink implements Sink
+var _ Sink = &QuietHoursSink{}
+
+// NewQuietHoursSink returns a sink that sends the transitions outside of the quiet hours to the wrapped
+// sink, and sends the digest of the transitions during the quiet hours after them if digest is true
+func NewQuietHoursSink(wrapped Sink, quietHours *QuietHours, digest bool) *QuietHoursSink {
+	return &QuietHoursSink{Sink: wrapped, quietHours: quietHours, digest: digest}
+}
+
+// Send delivers the transition to the wrapped sink outside of the quiet hours, and otherwise suppresses it
+// or holds it for the digest
+func (s *QuietHoursSink) Send(ctx context.Context, transition ComplianceTransition) error {
+	end, quiet := s.quietHours.End(time.Now())
+	if !quiet {
+		// the digest is sent first if the timer hasn't fired yet
+		s.flush()
+
+		return s.Sink.Send(ctx, transition)
+	}
+
+	if !s.digest {
+		log.V(1).Info("Suppressed the compliance transition during the quiet hours", "sink", s.Name(),
+			"Namespace", transition.Namespace, "Name", transition.Policy)
+
+		return nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.held.add(transition)
+
+	if s.timer == nil {
+		s.timer = time.AfterFunc(time.Until(end), s.flush)
+	}
+
+	return nil
+}
+
+// flush sends the digest of the held transitions once the quiet hours end, and waits for the end of the
+// quiet hours again if they were extended by an overlapping window. The policies whose compliance state is
+// the same as before the quiet hours aren't sent. Failures are only logged.
+func (s *QuietHoursSink) flush() {
+	s.lock.Lock()
+
+	if end, quiet := s.quietHours.End(time.Now()); quiet {
+		if s.timer != nil {
+			s.timer = time.AfterFunc(time.Until(end), s.flush)
+		}
+
+		s.lock.Unlock()
+
+		return
+	}
+
+	held, _ := s.held.take()
+
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+
+	s.lock.Unlock()
+
+	if len(held) == 0 {
+		return
+	}
+
+	log.Info("Sending the digest of the compliance transitions during the quiet hours", "sink", s.Name(),
+		"policies", len(held))
+
+	for _, transition := range held {
+		if transition.PreviousCompliance == transition.Compliance {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), SinkTimeout)
+
+		if err := s.Sink.Send(ctx, transition); err != nil {
+			log.Error(err, "Failed to send the compliance transition of the quiet hours digest", "sink", s.Name(),
+				"Namespace", transition.Namespace, "Name", transition.Policy)
+		}
+
+		cancel()
+	}
+}
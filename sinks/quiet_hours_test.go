@@ -0,0 +1,173 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sinks
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewQuietHoursErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		window   string
+		expected string
+	}{
+		"no duration":       {"0 22 * * 5", "cron schedule of 5 fields followed by a duration"},
+		"too many fields":   {"0 22 * * 5 * 1h", "cron schedule of 5 fields followed by a duration"},
+		"invalid duration":  {"0 22 * * 5 2d", `the duration "2d" must be positive`},
+		"zero duration":     {"0 22 * * 5 0s", `the duration "0s" must be positive`},
+		"too long duration": {"0 22 * * 5 169h", "at most 168h0m0s"},
+		"out of range":      {"60 * * * * 1h", `the cron field "60" must be between 0 and 59`},
+		"day of week":       {"0 0 * * 8 1h", `the cron field "8" must be between 0 and 7`},
+		"reversed range":    {"0 5-1 * * * 1h", `the cron field "5-1" must be between 0 and 23`},
+		"invalid value":     {"a * * * * 1h", `invalid value in the cron field "a"`},
+		"invalid range":     {"1-x * * * * 1h", `invalid range in the cron field "1-x"`},
+		"zero step":         {"*/0 * * * * 1h", `invalid step in the cron field "*/0"`},
+	}
+
+	for name, test := range tests {
+		_, err := NewQuietHours([]string{test.window}, time.UTC)
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Fatalf("%s: expected an error containing %q, got %v", name, test.expected, err)
+		}
+	}
+}
+
+func TestQuietHoursEnd(t *testing.T) {
+	t.Parallel()
+
+	// the weekend from 22:00 on Friday, 2022-01-07, to 06:00 on Monday, 2022-01-10
+	weekend := "0 22 * * 5 56h"
+	est := time.FixedZone("EST", -5*60*60)
+
+	tests := map[string]struct {
+		windows  []string
+		location *time.Location
+		now      time.Time
+		// expected is the end of the quiet hours, which is zero outside of them
+		expected time.Time
+	}{
+		"before the window": {
+			[]string{weekend}, time.UTC, time.Date(2022, 1, 7, 21, 59, 0, 0, time.UTC), time.Time{},
+		},
+		"start of the window": {
+			[]string{weekend}, time.UTC, time.Date(2022, 1, 7, 22, 0, 0, 0, time.UTC),
+			time.Date(2022, 1, 10, 6, 0, 0, 0, time.UTC),
+		},
+		"in the window": {
+			[]string{weekend}, time.UTC, time.Date(2022, 1, 8, 10, 30, 15, 0, time.UTC),
+			time.Date(2022, 1, 10, 6, 0, 0, 0, time.UTC),
+		},
+		"end of the window": {
+			[]string{weekend}, time.UTC, time.Date(2022, 1, 10, 6, 0, 0, 0, time.UTC), time.Time{},
+		},
+		"overlapping windows": {
+			[]string{weekend, "0 0 * * 1 12h"}, time.UTC, time.Date(2022, 1, 10, 1, 0, 0, 0, time.UTC),
+			time.Date(2022, 1, 10, 12, 0, 0, 0, time.UTC),
+		},
+		"day of the week": {
+			[]string{"0 0 1 * 1 1h"}, time.UTC, time.Date(2022, 1, 3, 0, 30, 0, 0, time.UTC),
+			time.Date(2022, 1, 3, 1, 0, 0, 0, time.UTC),
+		},
+		"day of the month": {
+			[]string{"0 0 1 * 1 1h"}, time.UTC, time.Date(2022, 1, 1, 0, 30, 0, 0, time.UTC),
+			time.Date(2022, 1, 1, 1, 0, 0, 0, time.UTC),
+		},
+		"neither day": {
+			[]string{"0 0 1 * 1 1h"}, time.UTC, time.Date(2022, 1, 2, 0, 30, 0, 0, time.UTC), time.Time{},
+		},
+		"sunday as 7": {
+			[]string{"0 0 * * 7 1h"}, time.UTC, time.Date(2022, 1, 2, 0, 30, 0, 0, time.UTC),
+			time.Date(2022, 1, 2, 1, 0, 0, 0, time.UTC),
+		},
+		"step": {
+			[]string{"*/15 * * * * 5m"}, time.UTC, time.Date(2022, 1, 1, 0, 17, 0, 0, time.UTC),
+			time.Date(2022, 1, 1, 0, 20, 0, 0, time.UTC),
+		},
+		"between the steps": {
+			[]string{"*/15 * * * * 5m"}, time.UTC, time.Date(2022, 1, 1, 0, 22, 0, 0, time.UTC), time.Time{},
+		},
+		"list and range": {
+			[]string{"0 1,3-4 * * * 30m"}, time.UTC, time.Date(2022, 1, 1, 4, 10, 0, 0, time.UTC),
+			time.Date(2022, 1, 1, 4, 30, 0, 0, time.UTC),
+		},
+		"location": {
+			[]string{"0 22 * * * 1h"}, est, time.Date(2022, 1, 2, 3, 30, 0, 0, time.UTC),
+			time.Date(2022, 1, 2, 4, 0, 0, 0, time.UTC),
+		},
+		"no windows": {nil, time.UTC, time.Date(2022, 1, 8, 10, 0, 0, 0, time.UTC), time.Time{}},
+	}
+
+	for name, test := range tests {
+		quietHours, err := NewQuietHours(test.windows, test.location)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		end, quiet := quietHours.End(test.now)
+		if quiet != !test.expected.IsZero() || !end.Equal(test.expected) {
+			t.Fatalf("%s: expected the quiet hours to end at %v, got %v (quiet: %v)", name, test.expected, end, quiet)
+		}
+	}
+}
+
+func TestQuietHoursSink(t *testing.T) {
+	t.Parallel()
+
+	always, err := NewQuietHours([]string{"* * * * * 1h"}, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	never, err := NewQuietHours(nil, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transitions := []ComplianceTransition{
+		{Namespace: "policies", Policy: "flapping", PreviousCompliance: "Compliant", Compliance: "NonCompliant"},
+		{Namespace: "policies", Policy: "violated", PreviousCompliance: "Compliant", Compliance: "NonCompliant"},
+		{Namespace: "policies", Policy: "flapping", PreviousCompliance: "NonCompliant", Compliance: "Compliant"},
+	}
+
+	tests := map[string]struct {
+		quietHours *QuietHours
+		digest     bool
+		// sent are the policies sent during the quiet hours, and digested after them
+		sent     string
+		digested string
+	}{
+		"outside of the quiet hours":     {never, false, "flapping,violated,flapping", ""},
+		"suppressed":                     {always, false, "", ""},
+		"digest":                         {always, true, "", "violated"},
+		"digest outside the quiet hours": {never, true, "flapping,violated,flapping", ""},
+	}
+
+	for name, test := range tests {
+		recording := &recordingSink{}
+		sink := NewQuietHoursSink(recording, test.quietHours, test.digest)
+
+		for _, transition := range transitions {
+			if err := sink.Send(context.TODO(), transition); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		}
+
+		if sent := strings.Join(recording.policies, ","); sent != test.sent {
+			t.Fatalf("%s: expected the policies %q to be sent, got %q", name, test.sent, sent)
+		}
+
+		// end the quiet hours, which sends the digest of the policies whose compliance state changed
+		recording.policies = nil
+		sink.quietHours = never
+		sink.flush()
+
+		if digested := strings.Join(recording.policies, ","); digested != test.digested {
+			t.Fatalf("%s: expected the policies %q in the digest, got %q", name, test.digested, digested)
+		}
+	}
+}
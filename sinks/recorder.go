@@ -0,0 +1,62 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sinks
+
+import (
+	"context"
+	"fmt"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// RecorderSink records the compliance transitions as events on the policy, such as on the hub or on the
+// managed cluster
+type RecorderSink struct {
+	name     string
+	recorder record.EventRecorder
+}
+
+// blank assignment to verify that RecorderSink implements Sink
+var _ Sink = &RecorderSink{}
+
+// NewRecorderSink returns a sink with the name that records the compliance transitions with the recorder
+func NewRecorderSink(name string, recorder record.EventRecorder) *RecorderSink {
+	return &RecorderSink{name: name, recorder: recorder}
+}
+
+// Name identifies the sink in logs
+func (s *RecorderSink) Name() string {
+	return s.name
+}
+
+// Send records a PolicyComplianceChanged event on the policy, which is a warning if it's NonCompliant
+func (s *RecorderSink) Send(_ context.Context, transition ComplianceTransition) error {
+	eventType := corev1.EventTypeNormal
+	if transition.Compliance == string(policiesv1.NonCompliant) {
+		eventType = corev1.EventTypeWarning
+	}
+
+	previous := transition.PreviousCompliance
+	if previous == "" {
+		previous = "unknown"
+	}
+
+	policy := &corev1.ObjectReference{
+		APIVersion: policiesv1.SchemeGroupVersion.String(),
+		Kind:       policiesv1.Kind,
+		Namespace:  transition.Namespace,
+		Name:       transition.Policy,
+	}
+
+	message := fmt.Sprintf("Policy %s compliance changed from %s to %s", transition.Policy, previous,
+		transition.Compliance)
+	if transition.Severity != "" {
+		message += fmt.Sprintf(" with the %s severity", transition.Severity)
+	}
+
+	s.recorder.Event(policy, eventType, "PolicyComplianceChanged", message)
+
+	return nil
+}
@@ -0,0 +1,112 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sinks
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// SchemaVersion is the version of the JSON schemas of the compliance records that are sent to the external
+// sinks, which are in the schemas directory. A change that isn't backward compatible, such as removing or
+// renaming a field, requires a new version.
+const SchemaVersion = "v1"
+
+var (
+	// recordCompliances are the compliance states allowed by the schemas, where an empty state is unknown
+	recordCompliances = map[string]bool{"": true, "Compliant": true, "NonCompliant": true, "Pending": true}
+	// recordSeverities are the severities allowed by the schemas
+	recordSeverities = map[string]bool{"": true, "low": true, "medium": true, "high": true, "critical": true}
+	// channelRgx matches the notification channels allowed by the schemas, which are a topic level of MQTT
+	channelRgx = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._-]{0,61}[A-Za-z0-9])?$`)
+)
+
+// ValidChannel returns true if the notification channel is allowed by the schemas
+func ValidChannel(channel string) bool {
+	return channelRgx.MatchString(channel)
+}
+
+// Validate returns an error if the transition doesn't match the compliance-transition schema of the
+// SchemaVersion
+func (t *ComplianceTransition) Validate() error {
+	if t.SchemaVersion != SchemaVersion {
+		return fmt.Errorf("the schema version %q isn't %s", t.SchemaVersion, SchemaVersion)
+	}
+
+	if t.Cluster == "" || t.Namespace == "" || t.Policy == "" {
+		return errors.New("the cluster, namespace, and policy are required")
+	}
+
+	if t.Timestamp.IsZero() {
+		return errors.New("the timestamp is required")
+	}
+
+	if !recordCompliances[t.PreviousCompliance] || !recordCompliances[t.Compliance] {
+		return fmt.Errorf("invalid compliance transition from %q to %q", t.PreviousCompliance, t.Compliance)
+	}
+
+	if !recordSeverities[t.Severity] {
+		return fmt.Errorf("invalid severity %q", t.Severity)
+	}
+
+	if t.Channel != "" && !ValidChannel(t.Channel) {
+		return fmt.Errorf("invalid channel %q", t.Channel)
+	}
+
+	if t.HeldTransitions < 0 {
+		return fmt.Errorf("invalid number of held transitions %d", t.HeldTransitions)
+	}
+
+	for _, template := range t.Templates {
+		if template.Name == "" {
+			return errors.New("the template name is required")
+		}
+
+		if !recordCompliances[template.Compliance] || !recordSeverities[template.Severity] {
+			return fmt.Errorf("invalid compliance %q or severity %q of the template %s", template.Compliance,
+				template.Severity, template.Name)
+		}
+	}
+
+	return nil
+}
+
+// Validate returns an error if the digest doesn't match the compliance-digest schema of the SchemaVersion
+func (d *ComplianceDigest) Validate() error {
+	if d.SchemaVersion != SchemaVersion {
+		return fmt.Errorf("the schema version %q isn't %s", d.SchemaVersion, SchemaVersion)
+	}
+
+	if d.Cluster == "" {
+		return errors.New("the cluster is required")
+	}
+
+	if d.Start.IsZero() || d.End.IsZero() {
+		return errors.New("the start and end are required")
+	}
+
+	if len(d.Transitions) == 0 {
+		return errors.New("at least one transition is required")
+	}
+
+	for i := range d.Transitions {
+		if err := d.Transitions[i].Validate(); err != nil {
+			return fmt.Errorf("invalid transition of the policy %s/%s: %w", d.Transitions[i].Namespace,
+				d.Transitions[i].Policy, err)
+		}
+	}
+
+	return nil
+}
+
+// encodeRecord validates the compliance record and returns it as JSON, so that a record that doesn't match
+// its schema is never sent to a sink
+func encodeRecord(record interface{ Validate() error }) ([]byte, error) {
+	if err := record.Validate(); err != nil {
+		return nil, fmt.Errorf("the compliance record doesn't match the schema %s: %w", SchemaVersion, err)
+	}
+
+	return json.Marshal(record)
+}
@@ -0,0 +1,250 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package sinks
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestComplianceTransitionValidate(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		modify   func(transition *ComplianceTransition)
+		expected string
+	}{
+		"valid":                   {func(*ComplianceTransition) {}, ""},
+		"unknown compliance":      {func(tr *ComplianceTransition) { tr.PreviousCompliance = "" }, ""},
+		"other schema version":    {func(tr *ComplianceTransition) { tr.SchemaVersion = "v2" }, `schema version "v2"`},
+		"no schema version":       {func(tr *ComplianceTransition) { tr.SchemaVersion = "" }, `schema version ""`},
+		"no cluster":              {func(tr *ComplianceTransition) { tr.Cluster = "" }, "are required"},
+		"no namespace":            {func(tr *ComplianceTransition) { tr.Namespace = "" }, "are required"},
+		"no policy":               {func(tr *ComplianceTransition) { tr.Policy = "" }, "are required"},
+		"no timestamp":            {func(tr *ComplianceTransition) { tr.Timestamp = time.Time{} }, "timestamp"},
+		"invalid compliance":      {func(tr *ComplianceTransition) { tr.Compliance = "compliant" }, "invalid compliance"},
+		"invalid severity":        {func(tr *ComplianceTransition) { tr.Severity = "urgent" }, `invalid severity "urgent"`},
+		"valid channel":           {func(tr *ComplianceTransition) { tr.Channel = "payments-team.eu" }, ""},
+		"invalid channel":         {func(tr *ComplianceTransition) { tr.Channel = "payments/#" }, "invalid channel"},
+		"channel ending in a dot": {func(tr *ComplianceTransition) { tr.Channel = "payments." }, "invalid channel"},
+		"negative held transitions": {
+			func(tr *ComplianceTransition) { tr.HeldTransitions = -1 }, "held transitions",
+		},
+		"valid template": {
+			func(tr *ComplianceTransition) {
+				tr.Templates = []TemplateCompliance{{Name: "template", Compliance: "Pending", Severity: "low"}}
+			},
+			"",
+		},
+		"template without a name": {
+			func(tr *ComplianceTransition) { tr.Templates = []TemplateCompliance{{Compliance: "Compliant"}} },
+			"template name is required",
+		},
+		"invalid template severity": {
+			func(tr *ComplianceTransition) { tr.Templates = []TemplateCompliance{{Name: "template", Severity: "x"}} },
+			"of the template template",
+		},
+	}
+
+	for name, test := range tests {
+		transition := testTransition()
+		test.modify(&transition)
+
+		err := transition.Validate()
+		if test.expected == "" && err != nil {
+			t.Fatalf("%s: expected the transition to be valid, got %v", name, err)
+		}
+
+		if test.expected != "" && (err == nil || !strings.Contains(err.Error(), test.expected)) {
+			t.Fatalf("%s: expected an error containing %q, got %v", name, test.expected, err)
+		}
+
+		// a record that doesn't match the schema is never encoded
+		if encoded, encodeErr := encodeRecord(&transition); (encodeErr == nil) != (err == nil) ||
+			(encodeErr != nil && encoded != nil) {
+			t.Fatalf("%s: expected the encoding error to match the validation error %v, got %v", name, err,
+				encodeErr)
+		}
+	}
+}
+
+func TestComplianceDigestValidate(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		modify   func(digest *ComplianceDigest)
+		expected string
+	}{
+		"valid":                {func(*ComplianceDigest) {}, ""},
+		"other schema version": {func(d *ComplianceDigest) { d.SchemaVersion = "v0" }, `schema version "v0"`},
+		"no cluster":           {func(d *ComplianceDigest) { d.Cluster = "" }, "cluster is required"},
+		"no start":             {func(d *ComplianceDigest) { d.Start = time.Time{} }, "start and end"},
+		"no end":               {func(d *ComplianceDigest) { d.End = time.Time{} }, "start and end"},
+		"no transitions":       {func(d *ComplianceDigest) { d.Transitions = nil }, "at least one transition"},
+		"invalid transition": {
+			func(d *ComplianceDigest) { d.Transitions[0].Compliance = "Unknown" },
+			"invalid transition of the policy managed/default.policy",
+		},
+	}
+
+	for name, test := range tests {
+		digest := ComplianceDigest{
+			SchemaVersion: SchemaVersion,
+			Cluster:       "managed",
+			Start:         time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+			End:           time.Date(2021, 1, 1, 0, 15, 0, 0, time.UTC),
+			Transitions:   []ComplianceTransition{testTransition()},
+		}
+		test.modify(&digest)
+
+		err := digest.Validate()
+		if test.expected == "" && err != nil {
+			t.Fatalf("%s: expected the digest to be valid, got %v", name, err)
+		}
+
+		if test.expected != "" && (err == nil || !strings.Contains(err.Error(), test.expected)) {
+			t.Fatalf("%s: expected an error containing %q, got %v", name, test.expected, err)
+		}
+	}
+}
+
+// schemaFile is the part of a JSON schema that the validation of the records is compared to
+type schemaFile struct {
+	Required   []string `json:"required"`
+	Properties map[string]struct {
+		Const   string `json:"const"`
+		Pattern string `json:"pattern"`
+	} `json:"properties"`
+	Defs map[string]struct {
+		Enum []string `json:"enum"`
+	} `json:"$defs"`
+}
+
+// recordFields returns the JSON fields of the record with all of its fields set
+func recordFields(t *testing.T, record interface{}) []string {
+	t.Helper()
+
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(encoded, &fields); err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	for name := range fields {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func TestSchemaFiles(t *testing.T) {
+	t.Parallel()
+
+	transition := testTransition()
+	transition.ClusterIdentity = "1234"
+	transition.Severity = "high"
+	transition.Templates = []TemplateCompliance{{Name: "template"}}
+	transition.Channel = "payments"
+	transition.HeldTransitions = 1
+
+	tests := map[string]struct {
+		record   interface{}
+		required []string
+	}{
+		"compliance-transition": {
+			transition, []string{"cluster", "namespace", "policy", "schemaVersion", "timestamp"},
+		},
+		"compliance-digest": {
+			ComplianceDigest{
+				ClusterIdentity: "1234", KubernetesVersion: "v1.22.1", AgentVersion: "0.0.1",
+				Transitions: []ComplianceTransition{transition},
+			},
+			[]string{"cluster", "end", "schemaVersion", "start", "transitions"},
+		},
+	}
+
+	for name, test := range tests {
+		content, err := os.ReadFile(filepath.Join("schemas", name+"."+SchemaVersion+".json"))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		schema := schemaFile{}
+		if err := json.Unmarshal(content, &schema); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		properties := []string{}
+		for property := range schema.Properties {
+			properties = append(properties, property)
+		}
+
+		sort.Strings(properties)
+		sort.Strings(schema.Required)
+
+		// the schema has a property for each field of the record, and requires the fields that are validated
+		if fields := strings.Join(recordFields(t, test.record), ","); fields != strings.Join(properties, ",") {
+			t.Fatalf("%s: expected the schema properties %s to be the record fields %s", name, properties, fields)
+		}
+
+		if required := strings.Join(schema.Required, ","); required != strings.Join(test.required, ",") {
+			t.Fatalf("%s: expected the required properties %s, got %s", name, test.required, required)
+		}
+
+		if version := schema.Properties["schemaVersion"].Const; version != SchemaVersion {
+			t.Fatalf("%s: expected the schema version %s, got %s", name, SchemaVersion, version)
+		}
+	}
+}
+
+func TestSchemaEnums(t *testing.T) {
+	t.Parallel()
+
+	content, err := os.ReadFile(filepath.Join("schemas", "compliance-transition."+SchemaVersion+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema := schemaFile{}
+	if err := json.Unmarshal(content, &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		enum    []string
+		allowed map[string]bool
+	}{
+		"compliance": {schema.Defs["compliance"].Enum, recordCompliances},
+		"severity":   {schema.Defs["severity"].Enum, recordSeverities},
+	}
+
+	for name, test := range tests {
+		// the empty value is allowed since it's omitted from the record
+		if len(test.enum) != len(test.allowed)-1 || !test.allowed[""] {
+			t.Fatalf("%s: expected the schema values %v to be the validated values %v", name, test.enum,
+				test.allowed)
+		}
+
+		for _, value := range test.enum {
+			if !test.allowed[value] {
+				t.Fatalf("%s: expected the schema value %s to be valid", name, value)
+			}
+		}
+	}
+
+	if pattern := schema.Properties["channel"].Pattern; pattern != channelRgx.String() {
+		t.Fatalf("expected the channel pattern %s of the schema to be validated, got %s", pattern, channelRgx)
+	}
+}
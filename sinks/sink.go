@@ -0,0 +1,72 @@
+// Copyright Contributors to the Open Cluster Management project
+
+// Package sinks contains the external destinations that compliance transitions can be sent to in addition
+// to the policy status on the hub.
+package sinks
+
+import (
+	"context"
+	"time"
+)
+
+// TemplateCompliance is the compliance of a single policy template
+type TemplateCompliance struct {
+	Name       string `json:"name"`
+	Compliance string `json:"compliance,omitempty"`
+	Severity   string `json:"severity,omitempty"`
+	Message    string `json:"message,omitempty"`
+}
+
+// ComplianceTransition is a change in the compliance state of a replicated policy
+type ComplianceTransition struct {
+	// SchemaVersion is the version of the schema of the transition, which is SchemaVersion
+	SchemaVersion      string `json:"schemaVersion"`
+	Cluster            string `json:"cluster"`
+	ClusterIdentity    string `json:"clusterIdentity,omitempty"`
+	Namespace          string `json:"namespace"`
+	Policy             string `json:"policy"`
+	PreviousCompliance string `json:"previousCompliance,omitempty"`
+	Compliance         string `json:"compliance,omitempty"`
+	// Severity is the highest severity of the policy templates
+	Severity  string               `json:"severity,omitempty"`
+	Templates []TemplateCompliance `json:"templates,omitempty"`
+	Timestamp time.Time            `json:"timestamp"`
+	// Channel is the notification channel of the policy, which the sinks route the transition by, such as a
+	// team name
+	Channel string `json:"channel,omitempty"`
+	// HeldTransitions is the number of transitions of the policy that a transition of a digest summarizes,
+	// such as of the quiet hours digest
+	HeldTransitions int `json:"heldTransitions,omitempty"`
+}
+
+// ComplianceDigest summarizes the compliance transitions in a period with the last transition of each
+// policy, from the compliance state that the policy had before the period
+type ComplianceDigest struct {
+	// SchemaVersion is the version of the schema of the digest, which is SchemaVersion
+	SchemaVersion string `json:"schemaVersion"`
+	Cluster       string `json:"cluster"`
+	// ClusterIdentity is the identity of the managed cluster, which is empty if it's unknown
+	ClusterIdentity string `json:"clusterIdentity,omitempty"`
+	// KubernetesVersion is the Kubernetes version of the managed cluster, which is empty if it's unknown
+	KubernetesVersion string `json:"kubernetesVersion,omitempty"`
+	// AgentVersion is the version of the agent that sent the digest
+	AgentVersion string                 `json:"agentVersion,omitempty"`
+	Start        time.Time              `json:"start"`
+	End          time.Time              `json:"end"`
+	Transitions  []ComplianceTransition `json:"transitions"`
+}
+
+// Sink is an external destination for compliance transitions
+type Sink interface {
+	// Name identifies the sink in logs
+	Name() string
+	// Send delivers a compliance transition to the sink
+	Send(ctx context.Context, transition ComplianceTransition) error
+}
+
+// DigestSink is a sink that can deliver a digest of compliance transitions in a single message
+type DigestSink interface {
+	Sink
+	// SendDigest delivers the digest to the sink
+	SendDigest(ctx context.Context, digest ComplianceDigest) error
+}
@@ -0,0 +1,61 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+// ClusterIdentityAnnotation is set on the events and the aggregated objects of the agent to the cluster
+// identity, so that they remain attributable to the managed cluster when they are exported off the cluster
+const ClusterIdentityAnnotation = "policy.open-cluster-management.io/cluster-identity"
+
+// identityRecorder annotates every event with the cluster identity
+type identityRecorder struct {
+	recorder record.EventRecorder
+	identity string
+}
+
+// IdentityRecorder returns a recorder that annotates the events of the recorder with the cluster identity. It
+// returns the recorder itself if the identity is empty.
+func IdentityRecorder(recorder record.EventRecorder, identity string) record.EventRecorder {
+	if identity == "" || recorder == nil {
+		return recorder
+	}
+
+	return &identityRecorder{recorder: recorder, identity: identity}
+}
+
+// annotations returns a copy of the annotations with the cluster identity
+func (r *identityRecorder) annotations(annotations map[string]string) map[string]string {
+	withIdentity := make(map[string]string, len(annotations)+1)
+
+	for key, value := range annotations {
+		withIdentity[key] = value
+	}
+
+	withIdentity[ClusterIdentityAnnotation] = r.identity
+
+	return withIdentity
+}
+
+// Event records an event on the object
+func (r *identityRecorder) Event(object runtime.Object, eventtype string, reason string, message string) {
+	r.recorder.AnnotatedEventf(object, r.annotations(nil), eventtype, reason, "%s", message)
+}
+
+// Eventf records an event on the object with a formatted message
+func (r *identityRecorder) Eventf(
+	object runtime.Object, eventtype string, reason string, messageFmt string, args ...interface{},
+) {
+	r.recorder.AnnotatedEventf(object, r.annotations(nil), eventtype, reason, messageFmt, args...)
+}
+
+// AnnotatedEventf records an event on the object with annotations and a formatted message
+func (r *identityRecorder) AnnotatedEventf(
+	object runtime.Object, annotations map[string]string, eventtype string, reason string, messageFmt string,
+	args ...interface{},
+) {
+	r.recorder.AnnotatedEventf(object, r.annotations(annotations), eventtype, reason, messageFmt, args...)
+}
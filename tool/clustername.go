@@ -0,0 +1,101 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// ClusterNameSourceHubKubeconfig discovers the cluster name from the hub kubeconfig
+	ClusterNameSourceHubKubeconfig = "hub-kubeconfig"
+	// ClusterNameSourceKlusterlet discovers the cluster name from the Klusterlet resource
+	ClusterNameSourceKlusterlet = "klusterlet"
+	// hubUserPrefix is the prefix of the hub user of the agents registered by the klusterlet, which is
+	// followed by the cluster name, such as system:open-cluster-management:cluster:cluster1:addon:...
+	hubUserPrefix = "system:open-cluster-management:cluster:"
+	// klusterletName is the name of the Klusterlet resource created by the klusterlet operator
+	klusterletName              = "klusterlet"
+	clusterNameDiscoveryTimeout = 10 * time.Second
+)
+
+var klusterletGVR = schema.GroupVersionResource{
+	Group:    "operator.open-cluster-management.io",
+	Version:  "v1",
+	Resource: "klusterlets",
+}
+
+// DiscoverClusterName returns the name of the managed cluster from the source, which is
+// ClusterNameSourceHubKubeconfig or ClusterNameSourceKlusterlet. The hub kubeconfig source reads the
+// cluster name from the user of its client certificate, or else from the namespace of its current
+// context. The klusterlet source reads the spec.clusterName of the Klusterlet resource on the cluster of
+// klusterletCfg, which is the hosting cluster in hosted mode.
+func DiscoverClusterName(source string, hubKubeconfig string, hubCfg *rest.Config, klusterletCfg *rest.Config) (
+	string, error,
+) {
+	switch source {
+	case ClusterNameSourceHubKubeconfig:
+		if name := certClusterName(hubCfg); name != "" {
+			return name, nil
+		}
+
+		if hubKubeconfig != "" {
+			kubeconfig, err := clientcmd.LoadFromFile(hubKubeconfig)
+			if err != nil {
+				return "", fmt.Errorf("failed to load the hub kubeconfig: %w", err)
+			}
+
+			if kubeContext, ok := kubeconfig.Contexts[kubeconfig.CurrentContext]; ok && kubeContext.Namespace != "" {
+				return kubeContext.Namespace, nil
+			}
+		}
+
+		return "", errors.New("the hub kubeconfig has neither a klusterlet client certificate nor a context " +
+			"namespace")
+	case ClusterNameSourceKlusterlet:
+		dynamicClient, err := dynamic.NewForConfig(klusterletCfg)
+		if err != nil {
+			return "", err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), clusterNameDiscoveryTimeout)
+		defer cancel()
+
+		klusterlet, err := dynamicClient.Resource(klusterletGVR).Get(ctx, klusterletName, metav1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("failed to get the Klusterlet %s: %w", klusterletName, err)
+		}
+
+		name, _, _ := unstructured.NestedString(klusterlet.Object, "spec", "clusterName")
+		if name == "" {
+			return "", fmt.Errorf("the Klusterlet %s has no spec.clusterName", klusterletName)
+		}
+
+		return name, nil
+	default:
+		return "", fmt.Errorf("invalid cluster name source %q, it must be %s or %s", source,
+			ClusterNameSourceHubKubeconfig, ClusterNameSourceKlusterlet)
+	}
+}
+
+// certClusterName returns the cluster name in the user of the client certificate of the config, or an empty
+// string if it doesn't have a certificate issued by the klusterlet registration
+func certClusterName(cfg *rest.Config) string {
+	cert, err := clientCertificate(cfg)
+	if err != nil || cert == nil || !strings.HasPrefix(cert.Subject.CommonName, hubUserPrefix) {
+		return ""
+	}
+
+	return strings.SplitN(strings.TrimPrefix(cert.Subject.CommonName, hubUserPrefix), ":", 2)[0]
+}
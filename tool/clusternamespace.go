@@ -0,0 +1,122 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// ClusterNamespaceLabel is the label that identifies the cluster namespace on the managed cluster
+const ClusterNamespaceLabel = "policy.open-cluster-management.io/isClusterNamespace"
+
+var clusterNamespaceDivergencesTotal = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "policy_status_sync_cluster_namespace_divergences_total",
+		Help: "The number of times that the required labels of the cluster namespace were removed and re-applied",
+	},
+)
+
+func init() {
+	metrics.Registry.MustRegister(clusterNamespaceDivergencesTotal)
+}
+
+// clusterNamespaceLabels are the labels that the cluster namespace must have
+var clusterNamespaceLabels = map[string]string{ClusterNamespaceLabel: "true"}
+
+// missingClusterNamespaceLabels returns the required labels that the namespace doesn't have
+func missingClusterNamespaceLabels(namespace *corev1.Namespace) map[string]string {
+	missing := map[string]string{}
+
+	for key, value := range clusterNamespaceLabels {
+		if _, ok := namespace.GetLabels()[key]; !ok {
+			missing[key] = value
+		}
+	}
+
+	return missing
+}
+
+// ClusterNamespaceKeeper keeps the required labels of the cluster namespace converged after CreateClusterNs
+// created or labeled it at startup. When a required label is removed, the divergence is logged, counted in
+// the policy_status_sync_cluster_namespace_divergences_total metric, and recorded as a warning event on the
+// namespace before the label is re-applied. It must be added to the manager, and only runs on the leader.
+type ClusterNamespaceKeeper struct {
+	Client    kubernetes.Interface
+	Namespace string
+	Recorder  record.EventRecorder
+}
+
+// Start watches the cluster namespace until the context is done
+func (k *ClusterNamespaceKeeper) Start(ctx context.Context) error {
+	factory := informers.NewSharedInformerFactoryWithOptions(k.Client, 0,
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", k.Namespace).String()
+		}),
+	)
+
+	factory.Core().V1().Namespaces().Informer().AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			k.converge(ctx, obj)
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			k.converge(ctx, obj)
+		},
+	})
+
+	factory.Start(ctx.Done())
+
+	<-ctx.Done()
+
+	return nil
+}
+
+// converge re-applies the required labels that were removed from the namespace. Failures are only logged,
+// since the next update or resync of the namespace retries them.
+func (k *ClusterNamespaceKeeper) converge(ctx context.Context, obj interface{}) {
+	namespace, ok := obj.(*corev1.Namespace)
+	if !ok || namespace.GetDeletionTimestamp() != nil {
+		return
+	}
+
+	missing := missingClusterNamespaceLabels(namespace)
+	if len(missing) == 0 {
+		return
+	}
+
+	clusterNamespaceDivergencesTotal.Inc()
+	log.Info("The required labels of the cluster namespace were removed, re-applying them",
+		"Namespace", namespace.GetName(), "labels", missing)
+
+	if k.Recorder != nil {
+		k.Recorder.Eventf(namespace, "Warning", "ClusterNamespaceDiverged",
+			"The required labels %v of the cluster namespace were removed and are re-applied", missing)
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"labels": missing},
+	})
+	if err != nil {
+		log.Error(err, "Failed to re-apply the cluster namespace labels", "Namespace", namespace.GetName())
+
+		return
+	}
+
+	_, err = k.Client.CoreV1().Namespaces().Patch(
+		ctx, namespace.GetName(), types.MergePatchType, patch, metav1.PatchOptions{},
+	)
+	if err != nil {
+		log.Error(err, "Failed to re-apply the cluster namespace labels", "Namespace", namespace.GetName())
+	}
+}
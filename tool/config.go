@@ -0,0 +1,136 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+const (
+	// ConfigSourceFlag is the source of an option that was set on the command line
+	ConfigSourceFlag = "flag"
+	// ConfigSourceDefault is the source of an option that has its default value
+	ConfigSourceDefault = "default"
+	// ConfigSourceStartup is the source of an option that was set at startup, such as from an environment
+	// variable or the cluster name discovery
+	ConfigSourceStartup = "startup"
+	redacted            = "<redacted>"
+)
+
+// configEnvVars are the environment variables that configure the controller
+var configEnvVars = []string{
+	"GOMEMLIMIT", "HOSTING_CONFIG", "HOSTNAME", "HUB_CONFIG", "MANAGED_CONFIG", "ON_MULTICLUSTERHUB",
+	ForceRunModeEnv, watchNamespaceEnvVar,
+}
+
+// secretOptionNames are the substrings of the names of the options whose values are secrets, unless they are
+// paths to files
+var secretOptionNames = []string{"token", "password", "secret"}
+
+// ConfigOption is the effective value of an option and the source that set it
+type ConfigOption struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// ConfigSnapshot is the effective configuration of the controller, with the secrets redacted
+type ConfigSnapshot struct {
+	Flags []ConfigOption `json:"flags"`
+	Env   []ConfigOption `json:"env"`
+}
+
+// Snapshot returns the effective configuration of the controller from the command line flags and the
+// environment variables it reads. An option that differs from its default without being set on the command
+// line was set at startup. The values of secret options and the credentials in URLs are redacted.
+func Snapshot() ConfigSnapshot {
+	snapshot := ConfigSnapshot{Flags: []ConfigOption{}, Env: []ConfigOption{}}
+
+	pflag.CommandLine.VisitAll(func(f *pflag.Flag) {
+		source := ConfigSourceDefault
+
+		switch {
+		case f.Changed:
+			source = ConfigSourceFlag
+		case f.Value.String() != f.DefValue:
+			source = ConfigSourceStartup
+		}
+
+		snapshot.Flags = append(snapshot.Flags, ConfigOption{
+			Name: f.Name, Value: redactValue(f.Name, f.Value.String()), Source: source,
+		})
+	})
+
+	for _, name := range configEnvVars {
+		if value, found := os.LookupEnv(name); found {
+			snapshot.Env = append(snapshot.Env, ConfigOption{
+				Name: name, Value: redactValue(name, value), Source: "env",
+			})
+		}
+	}
+
+	sort.Slice(snapshot.Env, func(i, j int) bool { return snapshot.Env[i].Name < snapshot.Env[j].Name })
+
+	return snapshot
+}
+
+// redactValue returns the value of the option with secrets redacted
+func redactValue(name string, value string) string {
+	if value == "" {
+		return value
+	}
+
+	for _, secret := range secretOptionNames {
+		lowerName := strings.ToLower(name)
+		if strings.Contains(lowerName, secret) && !strings.HasSuffix(lowerName, "-file") {
+			return redacted
+		}
+	}
+
+	if parsed, err := url.Parse(value); err == nil && parsed.User != nil {
+		parsed.User = url.User(redacted)
+
+		return parsed.String()
+	}
+
+	return value
+}
+
+// LogSnapshot logs the effective configuration of the controller, so that the running configuration of a
+// remote agent can be confirmed from its logs
+func LogSnapshot() {
+	snapshot := Snapshot()
+
+	values := []interface{}{}
+
+	for _, option := range snapshot.Flags {
+		if option.Source != ConfigSourceDefault {
+			values = append(values, option.Name, option.Value+" ("+option.Source+")")
+		}
+	}
+
+	for _, option := range snapshot.Env {
+		values = append(values, option.Name, option.Value+" ("+option.Source+")")
+	}
+
+	log.Info("Effective configuration, the other options have their default value", values...)
+}
+
+// ConfigHandler serves the effective configuration of the controller as JSON, including the options that
+// have their default value
+func ConfigHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if err := json.NewEncoder(w).Encode(Snapshot()); err != nil {
+			log.Error(err, "Failed to write the configuration snapshot")
+		}
+	})
+}
@@ -0,0 +1,51 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// ContentTypeProtobuf negotiates protobuf with the API server for built-in types, falling back to JSON
+	ContentTypeProtobuf = "protobuf"
+	// ContentTypeJSON forces JSON for all API traffic
+	ContentTypeJSON = "json"
+)
+
+// ApplyContentType validates the --kube-api-content-type flag and, when JSON is forced, sets the content
+// type on the given configs. Otherwise, the configs are left unchanged since the controller-runtime
+// clients already use protobuf for built-in types and must use JSON for custom resources such as policies.
+func ApplyContentType(configs ...*rest.Config) error {
+	switch Options.KubeAPIContentType {
+	case ContentTypeProtobuf:
+	case ContentTypeJSON:
+		for _, cfg := range configs {
+			cfg.ContentType = runtime.ContentTypeJSON
+		}
+	default:
+		return fmt.Errorf(
+			"invalid API content type %q, it must be %s or %s",
+			Options.KubeAPIContentType, ContentTypeProtobuf, ContentTypeJSON,
+		)
+	}
+
+	return nil
+}
+
+// ClientsetConfig returns a copy of the config to use for client-go clientsets, which only handle built-in
+// types, so that protobuf is negotiated with the API server unless JSON is forced. This reduces the
+// serialization CPU and bandwidth of event traffic.
+func ClientsetConfig(cfg *rest.Config) *rest.Config {
+	cfg = rest.CopyConfig(cfg)
+
+	if Options.KubeAPIContentType != ContentTypeJSON {
+		cfg.ContentType = runtime.ContentTypeProtobuf
+		cfg.AcceptContentTypes = runtime.ContentTypeProtobuf + "," + runtime.ContentTypeJSON
+	}
+
+	return cfg
+}
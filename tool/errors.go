@@ -0,0 +1,280 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// ErrorClass is a category of Kubernetes API errors
+type ErrorClass string
+
+const (
+	ErrorClassAuth      ErrorClass = "auth"
+	ErrorClassNotFound  ErrorClass = "not-found"
+	ErrorClassConflict  ErrorClass = "conflict"
+	ErrorClassThrottled ErrorClass = "throttled"
+	ErrorClassNetwork   ErrorClass = "network"
+	ErrorClassOther     ErrorClass = "other"
+)
+
+const (
+	// TargetHub is the target of the API requests to the hub cluster
+	TargetHub = "hub"
+	// TargetManaged is the target of the API requests to the managed cluster
+	TargetManaged = "managed"
+	// persistentAuthFailures is the number of consecutive auth errors before the readiness check fails
+	persistentAuthFailures = 3
+)
+
+var apiErrorsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "policy_status_sync_api_errors_total",
+		Help: "The number of failed Kubernetes API requests by target cluster (hub or managed) and error class",
+	},
+	[]string{"target", "class"},
+)
+
+func init() {
+	metrics.Registry.MustRegister(apiErrorsTotal)
+}
+
+// ClassifyError returns the class of an API error. Errors that are already classified keep their class.
+func ClassifyError(err error) ErrorClass {
+	var classified *ClassifiedError
+	if errors.As(err, &classified) {
+		return classified.Class
+	}
+
+	var netErr net.Error
+
+	switch {
+	case k8serrors.IsUnauthorized(err) || k8serrors.IsForbidden(err):
+		return ErrorClassAuth
+	case k8serrors.IsNotFound(err):
+		return ErrorClassNotFound
+	case k8serrors.IsConflict(err) || k8serrors.IsAlreadyExists(err):
+		return ErrorClassConflict
+	case k8serrors.IsTooManyRequests(err):
+		return ErrorClassThrottled
+	case errors.Is(err, ErrHubNotReady) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.As(err, &netErr) || k8serrors.IsServiceUnavailable(err) || k8serrors.IsTimeout(err) ||
+		k8serrors.IsServerTimeout(err) || k8serrors.IsUnexpectedServerError(err):
+		return ErrorClassNetwork
+	default:
+		return ErrorClassOther
+	}
+}
+
+// ClassifiedError is an API error with its class and the cluster it came from. It unwraps to the original
+// error, so the Kubernetes API error checks such as IsNotFound still apply.
+type ClassifiedError struct {
+	Class  ErrorClass
+	Target string
+	Err    error
+}
+
+func (e *ClassifiedError) Error() string {
+	return fmt.Sprintf("%s %s error: %v", e.Target, e.Class, e.Err)
+}
+
+func (e *ClassifiedError) Unwrap() error {
+	return e.Err
+}
+
+// ClassifyingClient is a client that classifies the errors of the wrapped client, counts them in the
+// policy_status_sync_api_errors_total metric, and tracks consecutive auth errors for the readiness check
+// returned by AuthReadyzCheck.
+type ClassifyingClient struct {
+	client.Client
+	target       string
+	lock         sync.Mutex
+	authFailures int
+}
+
+// blank assignment to verify that ClassifyingClient implements client.Client
+var _ client.Client = &ClassifyingClient{}
+
+var (
+	classifyingClientsLock sync.Mutex
+	classifyingClients     []*ClassifyingClient
+)
+
+// NewClassifyingClient returns a ClassifyingClient for the wrapped client of the target cluster, which is
+// TargetHub or TargetManaged
+func NewClassifyingClient(wrapped client.Client, target string) *ClassifyingClient {
+	classifying := &ClassifyingClient{Client: wrapped, target: target}
+
+	classifyingClientsLock.Lock()
+	classifyingClients = append(classifyingClients, classifying)
+	classifyingClientsLock.Unlock()
+
+	return classifying
+}
+
+// AuthReadyzCheck fails while the requests of a ClassifyingClient persistently fail with auth errors,
+// such as when the credentials were revoked. Transient errors, such as network errors, don't affect it.
+func AuthReadyzCheck(_ *http.Request) error {
+	classifyingClientsLock.Lock()
+	defer classifyingClientsLock.Unlock()
+
+	for _, classifying := range classifyingClients {
+		classifying.lock.Lock()
+		failures := classifying.authFailures
+		classifying.lock.Unlock()
+
+		if failures >= persistentAuthFailures {
+			return fmt.Errorf("the last %d %s cluster API requests failed with auth errors", failures,
+				classifying.target)
+		}
+	}
+
+	return nil
+}
+
+// classify wraps the error of a request with its class and updates the metrics and auth error tracking. The
+// request is logged at the debug level 2 with the reconcile ID of the context, so that the API requests of a
+// reconcile can be correlated with its other logs.
+func (c *ClassifyingClient) classify(ctx context.Context, operation string, key client.ObjectKey, err error) error {
+	c.logRequest(ctx, operation, key, err)
+
+	if err == nil {
+		c.lock.Lock()
+		c.authFailures = 0
+		c.lock.Unlock()
+
+		return nil
+	}
+
+	class := ClassifyError(err)
+	apiErrorsTotal.WithLabelValues(c.target, string(class)).Inc()
+
+	c.lock.Lock()
+	if class == ErrorClassAuth {
+		c.authFailures++
+	} else if class == ErrorClassNotFound || class == ErrorClassConflict {
+		// the request was authorized
+		c.authFailures = 0
+	}
+	c.lock.Unlock()
+
+	return &ClassifiedError{Class: class, Target: c.target, Err: err}
+}
+
+// logRequest logs the API request of the object key at the debug level 2. The key is empty for the
+// requests of lists.
+func (c *ClassifyingClient) logRequest(ctx context.Context, operation string, key client.ObjectKey, err error) {
+	logger := log
+	if c.target == TargetHub {
+		logger = hubLog
+	}
+
+	logger = logger.V(2)
+	if !logger.Enabled() {
+		return
+	}
+
+	keysAndValues := []interface{}{"target", c.target, "operation", operation}
+
+	if key.Name != "" {
+		keysAndValues = append(keysAndValues, "Namespace", key.Namespace, "Name", key.Name)
+	}
+
+	if reconcileID := ReconcileID(ctx); reconcileID != "" {
+		keysAndValues = append(keysAndValues, "ReconcileID", reconcileID)
+	}
+
+	if err != nil {
+		keysAndValues = append(keysAndValues, "errorClass", ClassifyError(err))
+	}
+
+	logger.Info("API request", keysAndValues...)
+}
+
+// Get retrieves an object
+func (c *ClassifyingClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	return c.classify(ctx, "get", key, c.Client.Get(ctx, key, obj))
+}
+
+// List retrieves a list of objects
+func (c *ClassifyingClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	return c.classify(ctx, "list", client.ObjectKey{}, c.Client.List(ctx, list, opts...))
+}
+
+// Create creates an object
+func (c *ClassifyingClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	return c.classify(ctx, "create", client.ObjectKeyFromObject(obj), c.Client.Create(ctx, obj, opts...))
+}
+
+// Delete deletes an object
+func (c *ClassifyingClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	return c.classify(ctx, "delete", client.ObjectKeyFromObject(obj), c.Client.Delete(ctx, obj, opts...))
+}
+
+// Update updates an object
+func (c *ClassifyingClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	return c.classify(ctx, "update", client.ObjectKeyFromObject(obj), c.Client.Update(ctx, obj, opts...))
+}
+
+// Patch patches an object
+func (c *ClassifyingClient) Patch(
+	ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption,
+) error {
+	return c.classify(ctx, "patch", client.ObjectKeyFromObject(obj), c.Client.Patch(ctx, obj, patch, opts...))
+}
+
+// DeleteAllOf deletes all objects of the given type matching the options
+func (c *ClassifyingClient) DeleteAllOf(
+	ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption,
+) error {
+	return c.classify(ctx, "delete all of", client.ObjectKeyFromObject(obj), c.Client.DeleteAllOf(ctx, obj, opts...))
+}
+
+// Status returns a writer for the status subresource that classifies its errors
+func (c *ClassifyingClient) Status() client.StatusWriter {
+	return &classifyingStatusWriter{classifying: c, writer: c.Client.Status()}
+}
+
+// Scheme returns the scheme of the wrapped client
+func (c *ClassifyingClient) Scheme() *runtime.Scheme {
+	return c.Client.Scheme()
+}
+
+// RESTMapper returns the REST mapper of the wrapped client
+func (c *ClassifyingClient) RESTMapper() meta.RESTMapper {
+	return c.Client.RESTMapper()
+}
+
+// classifyingStatusWriter classifies the errors of a status writer of a ClassifyingClient
+type classifyingStatusWriter struct {
+	classifying *ClassifyingClient
+	writer      client.StatusWriter
+}
+
+// Update updates the status of an object
+func (w *classifyingStatusWriter) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	err := w.writer.Update(ctx, obj, opts...)
+
+	return w.classifying.classify(ctx, "update status", client.ObjectKeyFromObject(obj), err)
+}
+
+// Patch patches the status of an object
+func (w *classifyingStatusWriter) Patch(
+	ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption,
+) error {
+	err := w.writer.Patch(ctx, obj, patch, opts...)
+
+	return w.classifying.classify(ctx, "patch status", client.ObjectKeyFromObject(obj), err)
+}
@@ -0,0 +1,111 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var policyResource = schema.GroupResource{Group: policyGroup, Resource: "policies"}
+
+func TestClassifyError(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		err      error
+		expected ErrorClass
+	}{
+		"unauthorized":      {k8serrors.NewUnauthorized("expired token"), ErrorClassAuth},
+		"forbidden":         {k8serrors.NewForbidden(policyResource, "policy", errors.New("denied")), ErrorClassAuth},
+		"not found":         {k8serrors.NewNotFound(policyResource, "policy"), ErrorClassNotFound},
+		"conflict":          {k8serrors.NewConflict(policyResource, "policy", errors.New("modified")), ErrorClassConflict},
+		"already exists":    {k8serrors.NewAlreadyExists(policyResource, "policy"), ErrorClassConflict},
+		"too many requests": {k8serrors.NewTooManyRequests("slow down", 1), ErrorClassThrottled},
+		"unavailable":       {k8serrors.NewServiceUnavailable("unavailable"), ErrorClassNetwork},
+		"server timeout":    {k8serrors.NewServerTimeout(policyResource, "get", 1), ErrorClassNetwork},
+		"deadline":          {fmt.Errorf("request: %w", context.DeadlineExceeded), ErrorClassNetwork},
+		"hub not ready":     {fmt.Errorf("request: %w", ErrHubNotReady), ErrorClassNetwork},
+		"network":           {&net.OpError{Op: "dial", Err: errors.New("connection refused")}, ErrorClassNetwork},
+		"invalid":           {k8serrors.NewBadRequest("invalid"), ErrorClassOther},
+		"other":             {errors.New("unknown"), ErrorClassOther},
+		"already classified": {
+			fmt.Errorf("wrapped: %w", &ClassifiedError{Class: ErrorClassThrottled, Err: errors.New("budget")}),
+			ErrorClassThrottled,
+		},
+	}
+
+	for name, test := range tests {
+		if class := ClassifyError(test.err); class != test.expected {
+			t.Fatalf("%s: expected the class %s, got %s", name, test.expected, class)
+		}
+	}
+}
+
+// erroringClient is a client whose Get requests fail with its error
+type erroringClient struct {
+	client.Client
+	err error
+}
+
+func (c *erroringClient) Get(_ context.Context, _ client.ObjectKey, _ client.Object) error {
+	return c.err
+}
+
+func TestClassifyingClient(t *testing.T) {
+	// not parallel since the auth readiness check covers every classifying client
+	wrapped := &erroringClient{}
+	classifying := NewClassifyingClient(wrapped, TargetHub)
+	key := client.ObjectKey{Namespace: "cluster1", Name: "policy"}
+
+	wrapped.err = k8serrors.NewNotFound(policyResource, "policy")
+
+	err := classifying.Get(context.TODO(), key, nil)
+	if !k8serrors.IsNotFound(err) {
+		t.Fatalf("expected the classified error to still be a not found error, got %v", err)
+	}
+
+	var classified *ClassifiedError
+	if !errors.As(err, &classified) || classified.Class != ErrorClassNotFound || classified.Target != TargetHub {
+		t.Fatalf("expected a not found error of the hub, got %v", err)
+	}
+
+	wrapped.err = k8serrors.NewForbidden(policyResource, "policy", errors.New("denied"))
+
+	for i := 0; i < persistentAuthFailures; i++ {
+		if err := AuthReadyzCheck(nil); err != nil {
+			t.Fatalf("expected the readiness check to pass after %d auth errors, got %v", i, err)
+		}
+
+		_ = classifying.Get(context.TODO(), key, nil)
+	}
+
+	if err := AuthReadyzCheck(nil); err == nil {
+		t.Fatal("expected the readiness check to fail after persistent auth errors")
+	}
+
+	// a network error doesn't show whether the request was authorized
+	wrapped.err = k8serrors.NewServiceUnavailable("unavailable")
+	_ = classifying.Get(context.TODO(), key, nil)
+
+	if err := AuthReadyzCheck(nil); err == nil {
+		t.Fatal("expected the readiness check to still fail after a network error")
+	}
+
+	wrapped.err = nil
+
+	if err := classifying.Get(context.TODO(), key, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := AuthReadyzCheck(nil); err != nil {
+		t.Fatalf("expected the readiness check to pass after a successful request, got %v", err)
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	policiesv1 "github.com/stolostron/governance-policy-propagator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/tools/reference"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// FakeHub is an in-memory hub for local development, such as running the controller against a kind cluster
+// without a hub. The hub policies are seeded from the managed policies with the same name the first time
+// that they are read, and every write to the hub, including the events, is dumped as a line of JSON, so that
+// the status that would be synced to the hub can be inspected.
+type FakeHub struct {
+	store   client.Client
+	managed client.Client
+	lock    sync.Mutex
+	dump    io.Writer
+}
+
+// NewFakeHub returns an in-memory hub that seeds its policies from the managed client and dumps the writes
+// to the writer
+func NewFakeHub(scheme *runtime.Scheme, managed client.Client, dump io.Writer) *FakeHub {
+	return &FakeHub{
+		store:   fake.NewClientBuilder().WithScheme(scheme).Build(),
+		managed: managed,
+		dump:    dump,
+	}
+}
+
+// Client returns the client of the in-memory hub
+func (h *FakeHub) Client() client.Client {
+	return &fakeHubClient{Client: h.store, hub: h}
+}
+
+// Recorder returns a recorder that dumps the events recorded on the in-memory hub
+func (h *FakeHub) Recorder(component string) record.EventRecorder {
+	return &fakeHubRecorder{hub: h, component: component}
+}
+
+// fakeHubDump is a line of the dump of the in-memory hub
+type fakeHubDump struct {
+	Time      string      `json:"time"`
+	Operation string      `json:"operation"`
+	Object    interface{} `json:"object"`
+}
+
+// write dumps the written object along with the operation. Failures are only logged.
+func (h *FakeHub) write(operation string, obj interface{}) {
+	line, err := json.Marshal(fakeHubDump{
+		Time:      time.Now().UTC().Format(time.RFC3339),
+		Operation: operation,
+		Object:    obj,
+	})
+	if err != nil {
+		log.Error(err, "Failed to dump a write to the fake hub", "Operation", operation)
+
+		return
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if _, err := h.dump.Write(append(line, '\n')); err != nil {
+		log.Error(err, "Failed to dump a write to the fake hub", "Operation", operation)
+	}
+}
+
+// seed creates the hub policy from the managed policy with the same name, without its status, if the hub
+// policy isn't in the store yet. It returns a not found error if there's no such managed policy.
+func (h *FakeHub) seed(ctx context.Context, key client.ObjectKey) error {
+	policyList := &policiesv1.PolicyList{}
+	if err := h.managed.List(ctx, policyList); err != nil {
+		return err
+	}
+
+	for _, managedPlc := range policyList.Items {
+		if managedPlc.GetName() != key.Name {
+			continue
+		}
+
+		hubPlc := &policiesv1.Policy{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   key.Namespace,
+				Name:        key.Name,
+				Labels:      managedPlc.GetLabels(),
+				Annotations: managedPlc.GetAnnotations(),
+			},
+			Spec: managedPlc.Spec,
+		}
+
+		err := h.store.Create(ctx, hubPlc)
+		if err == nil {
+			log.Info("Seeded the fake hub policy from the managed policy", "Namespace", key.Namespace,
+				"Name", key.Name)
+		}
+
+		if k8serrors.IsAlreadyExists(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	return k8serrors.NewNotFound(policiesv1.SchemeGroupVersion.WithResource("policies").GroupResource(), key.Name)
+}
+
+// fakeHubClient is the client of the in-memory hub, which seeds the policies on reads and dumps the writes
+type fakeHubClient struct {
+	client.Client
+	hub *FakeHub
+}
+
+// Get retrieves an object, seeding the hub policies from the managed policies
+func (c *fakeHubClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	err := c.Client.Get(ctx, key, obj)
+	if _, isPolicy := obj.(*policiesv1.Policy); !isPolicy || !k8serrors.IsNotFound(err) {
+		return err
+	}
+
+	if err := c.hub.seed(ctx, key); err != nil {
+		return err
+	}
+
+	return c.Client.Get(ctx, key, obj)
+}
+
+// Create creates an object
+func (c *fakeHubClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	return c.dumped("create", obj, c.Client.Create(ctx, obj, opts...))
+}
+
+// Delete deletes an object
+func (c *fakeHubClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	return c.dumped("delete", obj, c.Client.Delete(ctx, obj, opts...))
+}
+
+// Update updates an object
+func (c *fakeHubClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	return c.dumped("update", obj, c.Client.Update(ctx, obj, opts...))
+}
+
+// Patch patches an object
+func (c *fakeHubClient) Patch(
+	ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption,
+) error {
+	return c.dumped("patch", obj, c.Client.Patch(ctx, obj, patch, opts...))
+}
+
+// Status returns a writer for the status subresource that dumps the writes
+func (c *fakeHubClient) Status() client.StatusWriter {
+	return &fakeHubStatusWriter{writer: c.Client.Status(), client: c}
+}
+
+// dumped dumps the written object if the write succeeded, and returns the error of the write
+func (c *fakeHubClient) dumped(operation string, obj client.Object, err error) error {
+	if err == nil {
+		c.hub.write(operation, obj)
+	}
+
+	return err
+}
+
+// fakeHubStatusWriter dumps the status writes to the in-memory hub
+type fakeHubStatusWriter struct {
+	writer client.StatusWriter
+	client *fakeHubClient
+}
+
+// Update updates the status of an object
+func (w *fakeHubStatusWriter) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	return w.client.dumped("update status", obj, w.writer.Update(ctx, obj, opts...))
+}
+
+// Patch patches the status of an object
+func (w *fakeHubStatusWriter) Patch(
+	ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption,
+) error {
+	return w.client.dumped("patch status", obj, w.writer.Patch(ctx, obj, patch, opts...))
+}
+
+// fakeHubRecorder dumps the events recorded on the in-memory hub
+type fakeHubRecorder struct {
+	hub       *FakeHub
+	component string
+}
+
+// Event records an event on the object
+func (r *fakeHubRecorder) Event(object runtime.Object, eventtype string, reason string, message string) {
+	r.AnnotatedEventf(object, nil, eventtype, reason, "%s", message)
+}
+
+// Eventf records an event on the object with a formatted message
+func (r *fakeHubRecorder) Eventf(
+	object runtime.Object, eventtype string, reason string, messageFmt string, args ...interface{},
+) {
+	r.AnnotatedEventf(object, nil, eventtype, reason, messageFmt, args...)
+}
+
+// AnnotatedEventf records an event on the object with annotations and a formatted message
+func (r *fakeHubRecorder) AnnotatedEventf(
+	object runtime.Object, annotations map[string]string, eventtype string, reason string, messageFmt string,
+	args ...interface{},
+) {
+	ref, err := reference.GetReference(r.hub.store.Scheme(), object)
+	if err != nil {
+		log.Error(err, "Failed to dump an event of the fake hub", "Reason", reason)
+
+		return
+	}
+
+	now := metav1.Now()
+
+	r.hub.write("record event", &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   ref.Namespace,
+			Annotations: annotations,
+		},
+		InvolvedObject: *ref,
+		Type:           eventtype,
+		Reason:         reason,
+		Message:        fmt.Sprintf(messageFmt, args...),
+		Source:         corev1.EventSource{Component: r.component},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	})
+}
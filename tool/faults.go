@@ -0,0 +1,193 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// FeatureFaultInjection is the feature gate of the hub write fault injection flags, which are only meant
+// for resilience testing
+const FeatureFaultInjection = "FaultInjection"
+
+var injectedHubWriteFaultsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "policy_status_sync_injected_hub_write_faults_total",
+		Help: "The number of faults injected in the hub writes for resilience testing, by fault (latency or error)",
+	},
+	[]string{"fault"},
+)
+
+func init() {
+	metrics.Registry.MustRegister(injectedHubWriteFaultsTotal)
+}
+
+// FeatureEnabled returns true if the feature gate is enabled in --feature-gates
+func FeatureEnabled(name string) bool {
+	enabled, err := strconv.ParseBool(Options.FeatureGates[name])
+
+	return err == nil && enabled
+}
+
+// HubWriteFaults injects latency and errors in the hub writes, so that the buffering and retries of the hub
+// writes can be tested without a faulty proxy in front of the hub. The injected errors are service
+// unavailable errors, which are classified as network errors.
+type HubWriteFaults struct {
+	latency   time.Duration
+	errorRate float64
+}
+
+// NewHubWriteFaults returns the HubWriteFaults of the hidden --hub-write-fault-latency and
+// --hub-write-fault-error-rate flags, or nil if no fault is injected. It returns an error if the flags are
+// set without enabling the FaultInjection feature gate.
+func NewHubWriteFaults() (*HubWriteFaults, error) {
+	latency := Options.HubWriteFaultLatency
+	errorRate := Options.HubWriteFaultErrorRate
+
+	if latency == 0 && errorRate == 0 {
+		return nil, nil
+	}
+
+	if !FeatureEnabled(FeatureFaultInjection) {
+		return nil, fmt.Errorf("the hub write fault injection requires --feature-gates=%s=true",
+			FeatureFaultInjection)
+	}
+
+	if latency < 0 || errorRate < 0 || errorRate > 1 {
+		return nil, fmt.Errorf("invalid hub write faults, the latency must be positive and the error rate "+
+			"between 0 and 1, got %s and %v", latency, errorRate)
+	}
+
+	log.Info("Injecting faults in the hub writes, this is only meant for testing", "latency", latency.String(),
+		"errorRate", errorRate)
+
+	return &HubWriteFaults{latency: latency, errorRate: errorRate}, nil
+}
+
+// Client returns a client that injects the faults in the writes of the wrapped hub client. It returns the
+// wrapped client if f is nil.
+func (f *HubWriteFaults) Client(wrapped client.Client) client.Client {
+	if f == nil {
+		return wrapped
+	}
+
+	return &faultClient{Client: wrapped, faults: f}
+}
+
+// inject delays the write by the latency and then returns an injected error at the error rate
+func (f *HubWriteFaults) inject(ctx context.Context, operation string) error {
+	if f.latency > 0 {
+		injectedHubWriteFaultsTotal.WithLabelValues("latency").Inc()
+
+		timer := time.NewTimer(f.latency)
+		defer timer.Stop()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	//nolint:gosec // the faults don't need a secure random number
+	if f.errorRate > 0 && rand.Float64() < f.errorRate {
+		injectedHubWriteFaultsTotal.WithLabelValues("error").Inc()
+
+		return k8serrors.NewServiceUnavailable(fmt.Sprintf("injected fault to %s on the hub", operation))
+	}
+
+	return nil
+}
+
+// faultClient injects the faults of the HubWriteFaults in the writes
+type faultClient struct {
+	client.Client
+	faults *HubWriteFaults
+}
+
+// Create creates an object
+func (c *faultClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if err := c.faults.inject(ctx, "create"); err != nil {
+		return err
+	}
+
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+// Delete deletes an object
+func (c *faultClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	if err := c.faults.inject(ctx, "delete"); err != nil {
+		return err
+	}
+
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+// Update updates an object
+func (c *faultClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if err := c.faults.inject(ctx, "update"); err != nil {
+		return err
+	}
+
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+// Patch patches an object
+func (c *faultClient) Patch(
+	ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption,
+) error {
+	if err := c.faults.inject(ctx, "patch"); err != nil {
+		return err
+	}
+
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+// DeleteAllOf deletes all objects of the given type matching the options
+func (c *faultClient) DeleteAllOf(ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
+	if err := c.faults.inject(ctx, "delete"); err != nil {
+		return err
+	}
+
+	return c.Client.DeleteAllOf(ctx, obj, opts...)
+}
+
+// Status returns a writer for the status subresource that injects the faults
+func (c *faultClient) Status() client.StatusWriter {
+	return &faultStatusWriter{writer: c.Client.Status(), faults: c.faults}
+}
+
+// faultStatusWriter injects the faults of the HubWriteFaults in the status writes
+type faultStatusWriter struct {
+	writer client.StatusWriter
+	faults *HubWriteFaults
+}
+
+// Update updates the status of an object
+func (w *faultStatusWriter) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if err := w.faults.inject(ctx, "update status"); err != nil {
+		return err
+	}
+
+	return w.writer.Update(ctx, obj, opts...)
+}
+
+// Patch patches the status of an object
+func (w *faultStatusWriter) Patch(
+	ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption,
+) error {
+	if err := w.faults.inject(ctx, "patch status"); err != nil {
+		return err
+	}
+
+	return w.writer.Patch(ctx, obj, patch, opts...)
+}
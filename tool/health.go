@@ -0,0 +1,179 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+)
+
+const (
+	livenessEndpoint  = "/healthz"
+	readinessEndpoint = "/readyz"
+	startupEndpoint   = "/startupz"
+)
+
+// HealthServer serves the liveness, readiness, and startup probe endpoints. It replaces the health probe
+// server of the controller-runtime manager, which doesn't support a startup endpoint. All checks must be
+// added before the server is started. The endpoints are served over HTTPS when the probe certificate and
+// key files are set, and only on localhost when the probe is restricted to localhost.
+type HealthServer struct {
+	addr          string
+	certFile      string
+	keyFile       string
+	localhostOnly bool
+	healthz       *healthz.Handler
+	readyz        *healthz.Handler
+	startupz      *healthz.Handler
+	// handlers are the debug endpoints served along with the probe endpoints
+	handlers map[string]http.Handler
+}
+
+// NewHealthServer returns a HealthServer that will listen on addr. An empty addr or "0" disables it.
+func NewHealthServer(addr string) *HealthServer {
+	return &HealthServer{
+		addr:          addr,
+		certFile:      Options.ProbeCertFile,
+		keyFile:       Options.ProbeKeyFile,
+		localhostOnly: Options.ProbeLocalhostOnly,
+		healthz:       &healthz.Handler{Checks: map[string]healthz.Checker{}},
+		readyz:        &healthz.Handler{Checks: map[string]healthz.Checker{}},
+		startupz:      &healthz.Handler{Checks: map[string]healthz.Checker{}},
+		handlers:      map[string]http.Handler{},
+	}
+}
+
+// AddHealthzCheck adds a check to the liveness endpoint
+func (s *HealthServer) AddHealthzCheck(name string, check healthz.Checker) {
+	s.healthz.Checks[name] = check
+}
+
+// AddReadyzCheck adds a check to the readiness endpoint
+func (s *HealthServer) AddReadyzCheck(name string, check healthz.Checker) {
+	s.readyz.Checks[name] = check
+}
+
+// AddStartupzCheck adds a check to the startup endpoint
+func (s *HealthServer) AddStartupzCheck(name string, check healthz.Checker) {
+	s.startupz.Checks[name] = check
+}
+
+// AddHandler adds a debug endpoint, such as /debug/sync-errors, which is read-only on a standby replica
+func (s *HealthServer) AddHandler(path string, handler http.Handler) {
+	s.handlers[path] = ReadOnlyOnStandby(handler)
+}
+
+// CheckResults runs every check of the probe endpoints and returns their results by endpoint and check
+// name, which is "ok" or the error of the check, for the support bundle
+func (s *HealthServer) CheckResults(ctx context.Context) (interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	results := map[string]map[string]string{}
+
+	for endpoint, handler := range map[string]*healthz.Handler{
+		livenessEndpoint:  s.healthz,
+		readinessEndpoint: s.readyz,
+		startupEndpoint:   s.startupz,
+	} {
+		results[endpoint] = map[string]string{}
+
+		for name, check := range handler.Checks {
+			results[endpoint][name] = "ok"
+
+			if err := check(req); err != nil {
+				results[endpoint][name] = err.Error()
+			}
+		}
+	}
+
+	return results, nil
+}
+
+// Start serves the probe and debug endpoints until the context is done
+func (s *HealthServer) Start(ctx context.Context) error {
+	if s.addr == "" || s.addr == "0" {
+		return nil
+	}
+
+	if (s.certFile == "") != (s.keyFile == "") {
+		return errors.New("both the health probe certificate and key files must be set to serve over TLS")
+	}
+
+	if err := ValidateBindAddress(s.addr); err != nil {
+		return err
+	}
+
+	addr := s.addr
+
+	var listener net.Listener
+
+	var err error
+
+	if s.localhostOnly {
+		_, port, _ := net.SplitHostPort(addr)
+		addr = net.JoinHostPort("localhost", port)
+		listener, err = listenLocalhost(port)
+	} else {
+		listener, err = net.Listen("tcp", addr)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+
+	for endpoint, handler := range map[string]*healthz.Handler{
+		livenessEndpoint:  s.healthz,
+		readinessEndpoint: s.readyz,
+		startupEndpoint:   s.startupz,
+	} {
+		// the subpaths allow querying a single check, e.g. /readyz/permissions
+		mux.Handle(endpoint, http.StripPrefix(endpoint, handler))
+		mux.Handle(endpoint+"/", http.StripPrefix(endpoint, handler))
+	}
+
+	for path, handler := range s.handlers {
+		mux.Handle(path, handler)
+	}
+
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		TLSConfig:         &tls.Config{MinVersion: tls.VersionTLS12},
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Error(err, "Failed to shut down the health probe server")
+		}
+	}()
+
+	log.Info("Starting the health probe server", "address", addr, "tls", s.certFile != "")
+
+	if s.certFile != "" {
+		err = server.ServeTLS(listener, s.certFile, s.keyFile)
+	} else {
+		err = server.Serve(listener)
+	}
+
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
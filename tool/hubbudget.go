@@ -0,0 +1,209 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/flowcontrol"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// hubAPIBudgetReserve is the fraction of the hub API budget that is kept for the writes that may change the
+// compliance state of a policy on the hub
+const hubAPIBudgetReserve = 0.25
+
+var (
+	hubAPIBudgetExhaustedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "policy_status_sync_hub_api_budget_exhausted_total",
+			Help: "The number of hub API requests that waited since the --hub-api-budget of the agent was spent",
+		},
+	)
+	hubAPIBudgetDeferredTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "policy_status_sync_hub_api_budget_deferred_total",
+			Help: "The number of reconciles of policy history refreshes that were deferred to keep the reserve " +
+				"of the --hub-api-budget for the compliance state changes",
+		},
+	)
+	hubAPIBudgetRemaining = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "policy_status_sync_hub_api_budget_remaining",
+			Help: "The number of hub API requests left in the --hub-api-budget of the agent at the last request",
+		},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(hubAPIBudgetExhaustedTotal, hubAPIBudgetDeferredTotal, hubAPIBudgetRemaining)
+}
+
+// HubAPIBudget caps the hub API requests of the agent at a number of requests per minute, so that the hub
+// operators of large fleets have a guaranteed upper bound of the load of each agent. It's a token bucket that
+// holds up to a minute of requests and is the rate limiter of all the hub clients. A part of the budget is
+// kept for the compliance state changes: the lower priority work checks Spendable before it's started and is
+// deferred while only the reserve is left.
+type HubAPIBudget struct {
+	lock      sync.Mutex
+	perMinute float64
+	tokens    float64
+	last      time.Time
+	// spent is the number of requests since the last sync of the SharedHubAPIBudget
+	spent float64
+}
+
+// blank assignment to verify that HubAPIBudget implements flowcontrol.RateLimiter
+var _ flowcontrol.RateLimiter = &HubAPIBudget{}
+
+// NewHubAPIBudget returns the HubAPIBudget of the --hub-api-budget flag, or nil if the hub API requests aren't
+// limited by a budget
+func NewHubAPIBudget() (*HubAPIBudget, error) {
+	perMinute := Options.HubAPIBudget
+	if perMinute == 0 {
+		return nil, nil
+	}
+
+	if perMinute < 0 {
+		return nil, fmt.Errorf("the hub API budget must be positive, got %d", perMinute)
+	}
+
+	log.Info("Limiting the hub API requests to the budget", "requestsPerMinute", perMinute)
+
+	return &HubAPIBudget{perMinute: float64(perMinute), tokens: float64(perMinute), last: time.Now()}, nil
+}
+
+// Apply sets the budget as the rate limiter of the hub config, which the configs copied from it share. It
+// does nothing if b is nil.
+func (b *HubAPIBudget) Apply(hubCfg *rest.Config) {
+	if b == nil {
+		return
+	}
+
+	hubCfg.RateLimiter = b
+}
+
+// Spendable returns true if more than the reserve of the budget is left, so that work that isn't a compliance
+// state change can be started, and otherwise how long it's until the reserve is refilled. It's always true
+// if b is nil.
+func (b *HubAPIBudget) Spendable() (bool, time.Duration) {
+	if b == nil {
+		return true, 0
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.refill(time.Now())
+
+	missing := b.perMinute*hubAPIBudgetReserve - b.tokens
+	if missing < 0 {
+		return true, 0
+	}
+
+	hubAPIBudgetDeferredTotal.Inc()
+
+	return false, b.durationFor(missing) + time.Second
+}
+
+// refill adds the tokens of the time since the last refill, up to a minute of requests
+func (b *HubAPIBudget) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Minutes() * b.perMinute
+	if b.tokens > b.perMinute {
+		b.tokens = b.perMinute
+	}
+
+	b.last = now
+}
+
+// durationFor returns how long it takes to refill the number of tokens
+func (b *HubAPIBudget) durationFor(tokens float64) time.Duration {
+	return time.Duration(tokens / b.perMinute * float64(time.Minute))
+}
+
+// take spends a token and returns how long the request must wait for it, which is 0 if the budget wasn't
+// spent
+func (b *HubAPIBudget) take() time.Duration {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.refill(time.Now())
+	b.tokens--
+	b.spent++
+
+	hubAPIBudgetRemaining.Set(b.tokens)
+
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	hubAPIBudgetExhaustedTotal.Inc()
+
+	return b.durationFor(-b.tokens)
+}
+
+// TryAccept spends a token if one is left without waiting
+func (b *HubAPIBudget) TryAccept() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.refill(time.Now())
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	b.spent++
+
+	hubAPIBudgetRemaining.Set(b.tokens)
+
+	return true
+}
+
+// Accept spends a token, waiting until one is refilled if the budget is spent
+func (b *HubAPIBudget) Accept() {
+	if wait := b.take(); wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// Wait spends a token, waiting until one is refilled if the budget is spent. The token is still spent if the
+// context is done before then.
+func (b *HubAPIBudget) Wait(ctx context.Context) error {
+	wait := b.take()
+	if wait == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// spentRequests returns the number of requests since the last sync of the SharedHubAPIBudget
+func (b *HubAPIBudget) spentRequests() float64 {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.spent
+}
+
+// QPS returns the budget in requests per second
+func (b *HubAPIBudget) QPS() float32 {
+	return float32(b.perMinute / 60)
+}
+
+// Stop does nothing since the budget has no goroutine
+func (b *HubAPIBudget) Stop() {}
@@ -0,0 +1,184 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/rest"
+)
+
+// budgetWithTokens returns a budget of 60 requests per minute with the tokens left at the time of the call
+func budgetWithTokens(tokens float64) *HubAPIBudget {
+	return &HubAPIBudget{perMinute: 60, tokens: tokens, last: time.Now()}
+}
+
+// closeTo returns true if the duration is the expected duration, less the time the test took to run
+func closeTo(duration time.Duration, expected time.Duration) bool {
+	return duration <= expected && duration > expected-100*time.Millisecond
+}
+
+func TestNewHubAPIBudget(t *testing.T) {
+	// not parallel since the budget depends on the global options
+	original := Options
+	defer func() { Options = original }()
+
+	tests := map[string]struct {
+		perMinute int
+		enabled   bool
+		expected  string
+	}{
+		"no budget":       {0, false, ""},
+		"budget":          {120, true, ""},
+		"negative budget": {-1, false, "the hub API budget must be positive, got -1"},
+	}
+
+	for name, test := range tests {
+		Options.HubAPIBudget = test.perMinute
+
+		budget, err := NewHubAPIBudget()
+		if test.expected != "" {
+			if err == nil || err.Error() != test.expected {
+				t.Fatalf("%s: expected the error %q, got %v", name, test.expected, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if (budget != nil) != test.enabled {
+			t.Fatalf("%s: expected the budget to be enabled: %v", name, test.enabled)
+		}
+
+		hubCfg := &rest.Config{}
+		budget.Apply(hubCfg)
+
+		if (hubCfg.RateLimiter != nil) != test.enabled {
+			t.Fatalf("%s: expected the budget to be the rate limiter of the hub config: %v", name, test.enabled)
+		}
+
+		// a new budget has a minute of requests
+		if test.enabled && (budget.QPS() != 2 || !budget.TryAccept()) {
+			t.Fatalf("%s: expected a full budget of 2 requests per second, got %v", name, budget.QPS())
+		}
+	}
+}
+
+func TestHubAPIBudgetSpendable(t *testing.T) {
+	t.Parallel()
+
+	// the reserve is 15 of the 60 requests per minute
+	tests := map[string]struct {
+		budget    *HubAPIBudget
+		spendable bool
+		delay     time.Duration
+	}{
+		"full budget":            {budgetWithTokens(60), true, 0},
+		"above the reserve":      {budgetWithTokens(16), true, 0},
+		"just above the reserve": {budgetWithTokens(15.5), true, 0},
+		"below the reserve":      {budgetWithTokens(14), false, 2 * time.Second},
+		"spent budget":           {budgetWithTokens(0), false, 16 * time.Second},
+		"overspent budget":       {budgetWithTokens(-30), false, 46 * time.Second},
+		"no budget":              {nil, true, 0},
+	}
+
+	for name, test := range tests {
+		spendable, delay := test.budget.Spendable()
+		if spendable != test.spendable {
+			t.Fatalf("%s: expected the budget to be spendable: %v, got %v", name, test.spendable, spendable)
+		}
+
+		// the delay is until the reserve is refilled, plus a second
+		if (test.delay == 0 && delay != 0) || (test.delay != 0 && !closeTo(delay, test.delay)) {
+			t.Fatalf("%s: expected the delay %v, got %v", name, test.delay, delay)
+		}
+	}
+}
+
+func TestHubAPIBudgetTake(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		tokens float64
+		wait   time.Duration
+		accept bool
+	}{
+		"tokens left":     {10, 0, true},
+		"last token":      {1, 0, true},
+		"partial token":   {0.5, 500 * time.Millisecond, false},
+		"spent budget":    {0, time.Second, false},
+		"overspent":       {-59, time.Minute, false},
+		"full and capped": {600, 0, true},
+	}
+
+	for name, test := range tests {
+		if accept := budgetWithTokens(test.tokens).TryAccept(); accept != test.accept {
+			t.Fatalf("%s: expected the request to be accepted without waiting: %v, got %v", name, test.accept,
+				accept)
+		}
+
+		budget := budgetWithTokens(test.tokens)
+
+		wait := budget.take()
+		if (test.wait == 0 && wait != 0) || (test.wait != 0 && !closeTo(wait, test.wait)) {
+			t.Fatalf("%s: expected to wait %v, got %v", name, test.wait, wait)
+		}
+
+		if spent := budget.spentRequests(); spent != 1 {
+			t.Fatalf("%s: expected the request to be counted as spent, got %v", name, spent)
+		}
+	}
+}
+
+func TestHubAPIBudgetRefill(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		tokens   float64
+		elapsed  time.Duration
+		expected float64
+	}{
+		"no time":        {10, 0, 10},
+		"half a minute":  {10, 30 * time.Second, 40},
+		"capped":         {10, 2 * time.Minute, 60},
+		"from overspent": {-30, 30 * time.Second, 0},
+		"already full":   {60, time.Minute, 60},
+	}
+
+	for name, test := range tests {
+		budget := budgetWithTokens(test.tokens)
+		budget.refill(budget.last.Add(test.elapsed))
+
+		if budget.tokens != test.expected {
+			t.Fatalf("%s: expected %v tokens, got %v", name, test.expected, budget.tokens)
+		}
+	}
+}
+
+func TestHubAPIBudgetWaitCanceled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	budget := budgetWithTokens(0)
+
+	if err := budget.Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the wait to be canceled, got %v", err)
+	}
+
+	// the token is spent even though the request didn't wait for it
+	if budget.tokens >= 0 || budget.spentRequests() != 1 {
+		t.Fatalf("expected the token to be spent, got %v tokens", budget.tokens)
+	}
+
+	if err := budgetWithTokens(1).Wait(ctx); err != nil {
+		t.Fatalf("expected no wait with a token left, got %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	addonclient "open-cluster-management.io/api/client/addon/clientset/versioned"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// HubCommunicationConditionType is the type of the condition of the ManagedClusterAddOn on the hub with
+	// the time of the last successful hub API request of the agent
+	HubCommunicationConditionType = "StatusSyncHubCommunication"
+	// HubCommunicatingReason is the reason of the hub communication condition
+	HubCommunicatingReason = "Communicating"
+)
+
+// lastHubCommunication is the time in Unix nanoseconds of the last successful hub API request
+var lastHubCommunication int64
+
+func init() {
+	metrics.Registry.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "policy_status_sync_last_hub_communication_timestamp_seconds",
+			Help: "The Unix time in seconds of the last successful hub API request or watch event of the agent, " +
+				"which is 0 if there was none",
+		},
+		func() float64 {
+			return float64(atomic.LoadInt64(&lastHubCommunication)) / float64(time.Second)
+		},
+	))
+}
+
+// LastHubCommunication returns the time of the last successful hub API request or watch event, which is zero
+// if there was none
+func LastHubCommunication() time.Time {
+	last := atomic.LoadInt64(&lastHubCommunication)
+	if last == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, last)
+}
+
+// recordHubCommunication records that the hub responded
+func recordHubCommunication() {
+	atomic.StoreInt64(&lastHubCommunication, time.Now().UnixNano())
+}
+
+// TrackHubCommunication wraps the transport of the hub config to record the time of the last successful hub
+// API request, which is the last response that isn't a server error, a throttling, or an authentication
+// failure, or the last data read from a response, such as a watch event. This is how stale the view of the
+// hub of this cluster could be.
+func TrackHubCommunication(hubCfg *rest.Config) {
+	hubCfg.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return &communicationTransport{wrapped: rt}
+	})
+}
+
+// communicationTransport records the hub communication of the responses
+type communicationTransport struct {
+	wrapped http.RoundTripper
+}
+
+// RoundTrip sends the request and records the hub communication if the hub responded successfully
+func (t *communicationTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.wrapped.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	switch {
+	case resp.StatusCode >= http.StatusInternalServerError, resp.StatusCode == http.StatusTooManyRequests,
+		resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusForbidden:
+		return resp, nil
+	}
+
+	recordHubCommunication()
+
+	resp.Body = &communicationBody{ReadCloser: resp.Body}
+
+	return resp, nil
+}
+
+// communicationBody records the hub communication when data is read, such as the events of a watch
+type communicationBody struct {
+	io.ReadCloser
+}
+
+// Read reads from the response body
+func (b *communicationBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		recordHubCommunication()
+	}
+
+	return n, err
+}
+
+// HubCommunicationReporter periodically reports the time of the last successful hub API request in the
+// HubCommunicationConditionType condition of the ManagedClusterAddOn on the hub, so that the hub has the same
+// signal of how stale its view of the cluster could be as the metric of the agent
+type HubCommunicationReporter struct {
+	HubClient addonclient.Interface
+	// HubNamespace is the cluster namespace on the hub
+	HubNamespace string
+	// AddonName is the name of the ManagedClusterAddOn of the agent on the hub
+	AddonName string
+	Interval  time.Duration
+}
+
+// Start reports the condition every Interval until the context is done
+func (r *HubCommunicationReporter) Start(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.report(ctx)
+		}
+	}
+}
+
+// report sets the condition on the ManagedClusterAddOn on the hub. Failures are logged and retried at the
+// next interval.
+func (r *HubCommunicationReporter) report(ctx context.Context) {
+	last := LastHubCommunication()
+	if last.IsZero() {
+		return
+	}
+
+	addons := r.HubClient.AddonV1alpha1().ManagedClusterAddOns(r.HubNamespace)
+
+	addon, err := addons.Get(ctx, r.AddonName, metav1.GetOptions{})
+	if err != nil {
+		log.Error(err, "Failed to get the ManagedClusterAddOn to report the hub communication condition",
+			"Namespace", r.HubNamespace, "Name", r.AddonName)
+
+		return
+	}
+
+	meta.SetStatusCondition(&addon.Status.Conditions, metav1.Condition{
+		Type:   HubCommunicationConditionType,
+		Status: metav1.ConditionTrue,
+		Reason: HubCommunicatingReason,
+		Message: fmt.Sprintf("The last successful hub API request of the policy status sync agent was at %s",
+			last.UTC().Format(time.RFC3339)),
+	})
+
+	if _, err := addons.UpdateStatus(ctx, addon, metav1.UpdateOptions{}); err != nil {
+		log.Error(err, "Failed to report the hub communication condition on the ManagedClusterAddOn",
+			"Namespace", r.HubNamespace, "Name", r.AddonName)
+	}
+}
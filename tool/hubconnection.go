@@ -0,0 +1,331 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	hubProbeInterval = 10 * time.Second
+	hubProbeTimeout  = 5 * time.Second
+	// hubReconnectThreshold is the number of consecutive failed probes before the client is rebuilt
+	hubReconnectThreshold = 3
+)
+
+// hubLog is the logger of the hub connection, whose level can be set with --log-level=hubclient=debug
+var hubLog = logf.Log.WithName("hubclient")
+
+// ErrHubNotReady is returned for writes to the hub while the hub connection is failing
+var ErrHubNotReady = errors.New("the connection to the hub is not ready")
+
+// HubClientFunc builds a hub client from a config
+type HubClientFunc func(cfg *rest.Config) (client.Client, error)
+
+// HubConnection is a hub client that monitors the connection to the hub and rebuilds the underlying client
+// on persistent failures, such as after a network outage or a DNS change. Each rebuilt client uses a new
+// transport, so connections are redialed and the hub address is resolved again. Writes fail with
+// ErrHubNotReady while the connection is failing so that the requests are retried with a backoff. It must
+// be added to the manager to be monitored.
+type HubConnection struct {
+	config    *rest.Config
+	newClient HubClientFunc
+	lock      sync.RWMutex
+	client    client.Client
+	probe     rest.Interface
+	ready     bool
+	failures  int
+}
+
+// blank assignment to verify that HubConnection implements client.Client
+var _ client.Client = &HubConnection{}
+
+// NewHubConnection returns a HubConnection that starts with the given client
+func NewHubConnection(
+	cfg *rest.Config, initialClient client.Client, newClient HubClientFunc,
+) (*HubConnection, error) {
+	probe, err := newHubProbe(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HubConnection{
+		config:    cfg,
+		newClient: newClient,
+		client:    initialClient,
+		probe:     probe,
+		ready:     true,
+	}, nil
+}
+
+// newHubProbe returns a REST client to probe the hub API server
+func newHubProbe(cfg *rest.Config) (rest.Interface, error) {
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return discoveryClient.RESTClient(), nil
+}
+
+// NeedLeaderElection is false so that standby instances keep a healthy hub connection
+func (h *HubConnection) NeedLeaderElection() bool {
+	return false
+}
+
+// Start probes the hub until the context is done
+func (h *HubConnection) Start(ctx context.Context) error {
+	ticker := time.NewTicker(hubProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			h.checkConnection(ctx)
+		}
+	}
+}
+
+// checkConnection probes the hub and rebuilds the client after hubReconnectThreshold consecutive failures
+func (h *HubConnection) checkConnection(ctx context.Context) {
+	h.lock.RLock()
+	probe := h.probe
+	h.lock.RUnlock()
+
+	probeCtx, cancel := context.WithTimeout(ctx, hubProbeTimeout)
+	defer cancel()
+
+	err := probe.Get().AbsPath("/version").Do(probeCtx).Error()
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if err == nil {
+		if !h.ready {
+			hubLog.Info("The connection to the hub recovered")
+		}
+
+		h.ready = true
+		h.failures = 0
+
+		return
+	}
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	h.failures++
+	if h.ready {
+		hubLog.Error(err, "The connection to the hub is failing")
+	}
+
+	h.ready = false
+
+	if h.failures < hubReconnectThreshold {
+		return
+	}
+
+	hubLog.Info("Rebuilding the hub client", "failedProbes", h.failures)
+
+	cfg := rest.CopyConfig(h.config)
+	// a custom dialer bypasses the client-go transport cache, so the old connections aren't reused
+	cfg.Dial = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+
+	newProbe, err := newHubProbe(cfg)
+	if err != nil {
+		hubLog.Error(err, "Failed to rebuild the hub client")
+
+		return
+	}
+
+	newClient, err := h.newClient(cfg)
+	if err != nil {
+		hubLog.Error(err, "Failed to rebuild the hub client")
+
+		return
+	}
+
+	h.client = newClient
+	h.probe = newProbe
+	h.failures = 0
+}
+
+// HubConnectivity is the result of a connectivity check of the hub for the support bundle
+type HubConnectivity struct {
+	Host string `json:"host"`
+	// Ready is false while the periodic probes of the hub are failing
+	Ready               bool   `json:"ready"`
+	ConsecutiveFailures int    `json:"consecutiveFailures"`
+	ProbeLatency        string `json:"probeLatency"`
+	ProbeError          string `json:"probeError,omitempty"`
+	// Version is the version of the hub API server from the probe
+	Version json.RawMessage `json:"version,omitempty"`
+}
+
+// Connectivity probes the hub now and returns the result along with the state of the periodic probes
+func (h *HubConnection) Connectivity(ctx context.Context) (interface{}, error) {
+	h.lock.RLock()
+	probe := h.probe
+	connectivity := HubConnectivity{Host: h.config.Host, Ready: h.ready, ConsecutiveFailures: h.failures}
+	h.lock.RUnlock()
+
+	probeCtx, cancel := context.WithTimeout(ctx, hubProbeTimeout)
+	defer cancel()
+
+	start := time.Now()
+	version, err := probe.Get().AbsPath("/version").Do(probeCtx).Raw()
+	connectivity.ProbeLatency = time.Since(start).String()
+
+	if err != nil {
+		connectivity.ProbeError = err.Error()
+	} else if json.Valid(version) {
+		connectivity.Version = version
+	}
+
+	return connectivity, nil
+}
+
+// current returns the current client and whether the connection is ready
+func (h *HubConnection) current() (client.Client, bool) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	return h.client, h.ready
+}
+
+// writer returns the current client for a write, or an error if the connection isn't ready
+func (h *HubConnection) writer() (client.Client, error) {
+	hubClient, ready := h.current()
+	if !ready {
+		return nil, ErrHubNotReady
+	}
+
+	return hubClient, nil
+}
+
+// Get retrieves an object from the hub
+func (h *HubConnection) Get(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	hubClient, _ := h.current()
+
+	return hubClient.Get(ctx, key, obj)
+}
+
+// List retrieves a list of objects from the hub
+func (h *HubConnection) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	hubClient, _ := h.current()
+
+	return hubClient.List(ctx, list, opts...)
+}
+
+// Create creates an object on the hub
+func (h *HubConnection) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	hubClient, err := h.writer()
+	if err != nil {
+		return err
+	}
+
+	return hubClient.Create(ctx, obj, opts...)
+}
+
+// Delete deletes an object on the hub
+func (h *HubConnection) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	hubClient, err := h.writer()
+	if err != nil {
+		return err
+	}
+
+	return hubClient.Delete(ctx, obj, opts...)
+}
+
+// Update updates an object on the hub
+func (h *HubConnection) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	hubClient, err := h.writer()
+	if err != nil {
+		return err
+	}
+
+	return hubClient.Update(ctx, obj, opts...)
+}
+
+// Patch patches an object on the hub
+func (h *HubConnection) Patch(
+	ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption,
+) error {
+	hubClient, err := h.writer()
+	if err != nil {
+		return err
+	}
+
+	return hubClient.Patch(ctx, obj, patch, opts...)
+}
+
+// DeleteAllOf deletes all objects of the given type matching the options on the hub
+func (h *HubConnection) DeleteAllOf(ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
+	hubClient, err := h.writer()
+	if err != nil {
+		return err
+	}
+
+	return hubClient.DeleteAllOf(ctx, obj, opts...)
+}
+
+// Status returns a writer for the status subresource on the hub
+func (h *HubConnection) Status() client.StatusWriter {
+	return &hubStatusWriter{connection: h}
+}
+
+// Scheme returns the scheme of the current client
+func (h *HubConnection) Scheme() *runtime.Scheme {
+	hubClient, _ := h.current()
+
+	return hubClient.Scheme()
+}
+
+// RESTMapper returns the REST mapper of the current client
+func (h *HubConnection) RESTMapper() meta.RESTMapper {
+	hubClient, _ := h.current()
+
+	return hubClient.RESTMapper()
+}
+
+// hubStatusWriter writes the status subresource with the current client of a HubConnection
+type hubStatusWriter struct {
+	connection *HubConnection
+}
+
+// Update updates the status of an object on the hub
+func (w *hubStatusWriter) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	hubClient, err := w.connection.writer()
+	if err != nil {
+		return err
+	}
+
+	return hubClient.Status().Update(ctx, obj, opts...)
+}
+
+// Patch patches the status of an object on the hub
+func (w *hubStatusWriter) Patch(
+	ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption,
+) error {
+	hubClient, err := w.connection.writer()
+	if err != nil {
+		return err
+	}
+
+	return hubClient.Status().Patch(ctx, obj, patch, opts...)
+}
@@ -0,0 +1,224 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	eventsv1 "k8s.io/api/events/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/events"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/tools/reference"
+)
+
+// hubEventAction is the action of the events.k8s.io/v1 events recorded on the hub
+const hubEventAction = "SyncStatus"
+
+// HubEventBroadcaster records the events on the hub with the core v1 API, or with the events.k8s.io/v1 API
+// when it's enabled, which counts the repeated occurrences of an event in its series instead of updating the
+// event on every occurrence. It falls back to the core v1 events if the hub doesn't serve the events.k8s.io/v1
+// API or doesn't grant the permission to create the events.k8s.io events.
+type HubEventBroadcaster struct {
+	eventsBroadcaster events.EventBroadcaster
+	coreBroadcaster   record.EventBroadcaster
+	stopCh            chan struct{}
+}
+
+// eventsActioner is implemented by the events.k8s.io/v1 broadcaster of client-go, whose embedded
+// watch.Broadcaster distributes the events to the sink. It's used to record the annotated events, which the
+// events.k8s.io/v1 recorder can't build.
+type eventsActioner interface {
+	Action(action watch.EventType, obj runtime.Object)
+}
+
+// NewHubEventBroadcaster returns a HubEventBroadcaster that records the events with the hub client, with the
+// events.k8s.io/v1 API if eventsAPI is set. The core v1 events are recorded in the namespace, or in the
+// namespace of each object if it's empty.
+func NewHubEventBroadcaster(client kubernetes.Interface, namespace string, eventsAPI bool) *HubEventBroadcaster {
+	broadcaster := &HubEventBroadcaster{stopCh: make(chan struct{})}
+
+	if eventsAPI {
+		if err := eventsAPIAllowed(client, namespace); err != nil {
+			log.Info("Can't record the events.k8s.io/v1 events on the hub, recording the core v1 events instead",
+				"error", err.Error())
+		} else {
+			eventsBroadcaster := events.NewBroadcaster(&events.EventSinkImpl{Interface: client.EventsV1()})
+
+			if _, ok := eventsBroadcaster.(eventsActioner); ok {
+				broadcaster.eventsBroadcaster = eventsBroadcaster
+				broadcaster.eventsBroadcaster.StartRecordingToSink(broadcaster.stopCh)
+
+				return broadcaster
+			}
+
+			// the annotations of the events would be dropped
+			log.Info("The events.k8s.io/v1 broadcaster can't record annotated events, recording the core v1 " +
+				"events instead")
+		}
+	}
+
+	broadcaster.coreBroadcaster = record.NewBroadcaster()
+	broadcaster.coreBroadcaster.StartRecordingToSink(
+		&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events(namespace)},
+	)
+
+	return broadcaster
+}
+
+// eventsAPIAllowed returns an error if the hub doesn't serve the events.k8s.io/v1 API, or if a
+// SelfSubjectAccessReview doesn't allow creating the events.k8s.io events in the namespace, or in all
+// namespaces if it's empty
+func eventsAPIAllowed(client kubernetes.Interface, namespace string) error {
+	_, err := client.Discovery().ServerResourcesForGroupVersion(eventsv1.SchemeGroupVersion.String())
+	if err != nil {
+		return fmt.Errorf("the hub doesn't serve the events.k8s.io/v1 API: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), PermissionCheckTimeout)
+	defer cancel()
+
+	review, err := client.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx,
+		&authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace: namespace,
+					Verb:      "create",
+					Group:     eventsv1.GroupName,
+					Resource:  "events",
+				},
+			},
+		}, metav1.CreateOptions{},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to review the permission on the events.k8s.io events: %w", err)
+	}
+
+	if !review.Status.Allowed {
+		return fmt.Errorf("the hub doesn't grant the permission to create the events.k8s.io events")
+	}
+
+	return nil
+}
+
+// NewRecorder returns a recorder of the events of the component on the hub
+func (b *HubEventBroadcaster) NewRecorder(scheme *runtime.Scheme, component string) record.EventRecorder {
+	if b.eventsBroadcaster != nil {
+		hostname, _ := os.Hostname()
+
+		return &eventsRecorder{
+			recorder:          b.eventsBroadcaster.NewRecorder(scheme, component),
+			actioner:          b.eventsBroadcaster.(eventsActioner),
+			scheme:            scheme,
+			component:         component,
+			reportingInstance: component + "-" + hostname,
+		}
+	}
+
+	return b.coreBroadcaster.NewRecorder(scheme, corev1.EventSource{Component: component})
+}
+
+// Shutdown stops recording the events
+func (b *HubEventBroadcaster) Shutdown() {
+	if b.eventsBroadcaster != nil {
+		// the broadcaster is shut down before its watcher is stopped, since stopping the watcher of a running
+		// broadcaster races with the shut down
+		b.eventsBroadcaster.Shutdown()
+		close(b.stopCh)
+
+		return
+	}
+
+	b.coreBroadcaster.Shutdown()
+}
+
+// eventsRecorder is a record.EventRecorder that records the events with an events.k8s.io/v1 recorder
+type eventsRecorder struct {
+	recorder events.EventRecorder
+	// actioner, scheme, component, and reportingInstance build the annotated events like the recorder
+	actioner          eventsActioner
+	scheme            *runtime.Scheme
+	component         string
+	reportingInstance string
+}
+
+// Event records an event on the object
+func (r *eventsRecorder) Event(object runtime.Object, eventtype string, reason string, message string) {
+	r.recorder.Eventf(object, nil, eventtype, reason, hubEventAction, "%s", message)
+}
+
+// Eventf records an event on the object with a formatted message
+func (r *eventsRecorder) Eventf(
+	object runtime.Object, eventtype string, reason string, messageFmt string, args ...interface{},
+) {
+	r.recorder.Eventf(object, nil, eventtype, reason, hubEventAction, messageFmt, args...)
+}
+
+// AnnotatedEventf records an event on the object with annotations and a formatted message. The
+// events.k8s.io/v1 recorder has no annotations, so the annotated event is built like the recorder does and
+// passed to the broadcaster, which sends it to the hub and counts its repeated occurrences like the other
+// events. A repeated occurrence keeps the annotations of the first one.
+func (r *eventsRecorder) AnnotatedEventf(
+	object runtime.Object, annotations map[string]string, eventtype string, reason string, messageFmt string,
+	args ...interface{},
+) {
+	if len(annotations) == 0 {
+		r.Eventf(object, eventtype, reason, messageFmt, args...)
+
+		return
+	}
+
+	message := fmt.Sprintf(messageFmt, args...)
+
+	ref, err := reference.GetReference(r.scheme, object)
+	if err != nil {
+		log.Error(err, "Failed to get the reference of the event object, not recording the event",
+			"type", eventtype, "reason", reason, "message", message)
+
+		return
+	}
+
+	if eventtype != corev1.EventTypeNormal && eventtype != corev1.EventTypeWarning {
+		log.Error(fmt.Errorf("unsupported event type: %s", eventtype), "Not recording the event",
+			"reason", reason, "message", message)
+
+		return
+	}
+
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+
+	now := metav1.NowMicro()
+	event := &eventsv1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        fmt.Sprintf("%v.%x", ref.Name, now.UnixNano()),
+			Namespace:   namespace,
+			Annotations: annotations,
+		},
+		EventTime:           now,
+		ReportingController: r.component,
+		ReportingInstance:   r.reportingInstance,
+		Action:              hubEventAction,
+		Reason:              reason,
+		Regarding:           *ref,
+		Note:                message,
+		Type:                eventtype,
+	}
+
+	go func() {
+		defer utilruntime.HandleCrash()
+
+		r.actioner.Action(watch.Added, event)
+	}()
+}
@@ -0,0 +1,147 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	eventsv1 "k8s.io/api/events/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+// hubEventsClient returns a fake hub client that serves the events.k8s.io/v1 API, and whose
+// SelfSubjectAccessReviews are allowed if allowed is set
+func hubEventsClient(allowed bool) *fake.Clientset {
+	client := fake.NewSimpleClientset()
+	client.Resources = []*metav1.APIResourceList{{GroupVersion: eventsv1.SchemeGroupVersion.String()}}
+
+	client.PrependReactor("create", "selfsubjectaccessreviews",
+		func(action clienttesting.Action) (bool, runtime.Object, error) {
+			review := action.(clienttesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+			review.Status.Allowed = allowed
+
+			return true, review, nil
+		},
+	)
+
+	return client
+}
+
+// recordedAnnotations waits for the event with the reason to be recorded with the events.k8s.io/v1 API, or
+// with the core v1 API if legacy is set, and returns its annotations
+func recordedAnnotations(
+	t *testing.T, client *fake.Clientset, legacy bool, namespace string, reason string,
+) map[string]string {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for time.Now().Before(deadline) {
+		if legacy {
+			events, err := client.CoreV1().Events(namespace).List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, event := range events.Items {
+				if event.Reason == reason {
+					return event.GetAnnotations()
+				}
+			}
+		} else {
+			events, err := client.EventsV1().Events(namespace).List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, event := range events.Items {
+				if event.Reason == reason {
+					if event.Action != hubEventAction || event.ReportingController != "test-component" {
+						t.Fatalf("expected the action and the reporting controller of the recorder, got %s and %s",
+							event.Action, event.ReportingController)
+					}
+
+					return event.GetAnnotations()
+				}
+			}
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("expected the event %s to be recorded", reason)
+
+	return nil
+}
+
+func TestHubEventRecorderAnnotations(t *testing.T) {
+	t.Parallel()
+
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	object := &corev1.ConfigMap{
+		TypeMeta:   metav1.TypeMeta{Kind: "ConfigMap", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: "object", Namespace: "cluster1"},
+	}
+
+	tests := map[string]struct {
+		eventsAPI bool
+		allowed   bool
+		legacy    bool
+	}{
+		"events.k8s.io": {eventsAPI: true, allowed: true, legacy: false},
+		"core v1":       {eventsAPI: false, allowed: true, legacy: true},
+		"forbidden":     {eventsAPI: true, allowed: false, legacy: true},
+	}
+
+	for name, test := range tests {
+		legacy := test.legacy
+		eventsAPI := test.eventsAPI
+		allowed := test.allowed
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			client := hubEventsClient(allowed)
+
+			broadcaster := NewHubEventBroadcaster(client, "cluster1", eventsAPI)
+			defer broadcaster.Shutdown()
+
+			if (broadcaster.eventsBroadcaster == nil) != legacy {
+				t.Fatalf("expected the events.k8s.io/v1 broadcaster to be used: %v", !legacy)
+			}
+
+			recorder := broadcaster.NewRecorder(scheme, "test-component")
+
+			recorder.AnnotatedEventf(object, map[string]string{"example.com/key": "value"}, "Normal", "Annotated",
+				"The event has %s", "annotations")
+			recorder.AnnotatedEventf(object, nil, "Normal", "NotAnnotated", "The event has no annotations")
+			IdentityRecorder(recorder, "cluster-identity").Event(object, "Warning", "Identity", "The event")
+
+			annotations := recordedAnnotations(t, client, legacy, "cluster1", "Annotated")
+			if annotations["example.com/key"] != "value" {
+				t.Fatalf("expected the annotations of the event, got %v", annotations)
+			}
+
+			annotations = recordedAnnotations(t, client, legacy, "cluster1", "NotAnnotated")
+			if len(annotations) != 0 {
+				t.Fatalf("expected no annotations, got %v", annotations)
+			}
+
+			annotations = recordedAnnotations(t, client, legacy, "cluster1", "Identity")
+			if annotations[ClusterIdentityAnnotation] != "cluster-identity" {
+				t.Fatalf("expected the cluster identity annotation, got %v", annotations)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"k8s.io/client-go/rest"
+
+	"github.com/stolostron/governance-policy-status-sync/version"
+)
+
+const (
+	// DefaultHubUserAgent is the default User-Agent of the hub API requests
+	DefaultHubUserAgent = "governance-policy-status-sync/{version} (cluster={cluster}; pod={pod})"
+	// maxFieldManagerLength is the longest field manager that the API server accepts
+	maxFieldManagerLength = 128
+)
+
+// ExpandIdentity replaces the {version}, {cluster}, and {pod} placeholders of the User-Agent or field
+// manager template with the version of the agent, the cluster name, and the name of the agent pod
+func ExpandIdentity(template string, clusterName string) string {
+	return strings.NewReplacer(
+		"{version}", version.Version,
+		"{cluster}", clusterName,
+		"{pod}", os.Getenv("HOSTNAME"),
+	).Replace(template)
+}
+
+// ApplyHubIdentity sets the User-Agent of the hub config from the --hub-user-agent flag, and returns the
+// field manager of the hub status writes from the --hub-field-manager flag, which is empty if it's not set.
+// An error is returned if the field manager is too long for the API server.
+func ApplyHubIdentity(hubCfg *rest.Config, clusterName string) (string, error) {
+	if Options.HubUserAgent != "" {
+		hubCfg.UserAgent = ExpandIdentity(Options.HubUserAgent, clusterName)
+	}
+
+	fieldManager := ExpandIdentity(Options.HubFieldManager, clusterName)
+	if len(fieldManager) > maxFieldManagerLength {
+		return "", fmt.Errorf(
+			"the hub field manager %q is longer than %d characters", fieldManager, maxFieldManagerLength,
+		)
+	}
+
+	hubLog.Info("Set the identity of the hub API requests", "userAgent", hubCfg.UserAgent,
+		"fieldManager", fieldManager)
+
+	return fieldManager, nil
+}
@@ -0,0 +1,122 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	addonHealthCheckGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "policy_status_sync_addon_health_check",
+			Help: "The result of the last addon health check that gates the addon lease updates, 1 if it passed " +
+				"and 0 if it failed, by check",
+		},
+		[]string{"check"},
+	)
+	addonLeaseRenewTimestamp = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "policy_status_sync_addon_lease_renew_timestamp_seconds",
+			Help: "The Unix time of the last successful update of the addon lease",
+		},
+	)
+	// addonLeaseRenewTime is the last renew time of the addon lease in Unix nanoseconds, or 0 if it's unknown
+	addonLeaseRenewTime  int64
+	addonLeaseRenewAgeFn = prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "policy_status_sync_addon_lease_renew_age_seconds",
+			Help: "The number of seconds since the last successful update of the addon lease, or 0 if it's unknown",
+		},
+		func() float64 {
+			renewTime := atomic.LoadInt64(&addonLeaseRenewTime)
+			if renewTime == 0 {
+				return 0
+			}
+
+			return time.Since(time.Unix(0, renewTime)).Seconds()
+		},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(addonHealthCheckGauge, addonLeaseRenewTimestamp, addonLeaseRenewAgeFn)
+}
+
+// ObserveHealthCheck returns the addon health check that also sets its result in the
+// policy_status_sync_addon_health_check metric with the name, so that a failing check, which stops the
+// addon lease updates, can be alerted on locally
+func ObserveHealthCheck(name string, check func() bool) func() bool {
+	return func() bool {
+		healthy := check()
+
+		result := 0.0
+		if healthy {
+			result = 1
+		}
+
+		addonHealthCheckGauge.WithLabelValues(name).Set(result)
+
+		return healthy
+	}
+}
+
+// LeaseMonitor periodically reads the renew time of the addon lease into the
+// policy_status_sync_addon_lease_renew_timestamp_seconds and policy_status_sync_addon_lease_renew_age_seconds
+// metrics. The lease is read from the hub cluster namespace if it's not found on the managed cluster, like
+// the addon lease updater does.
+type LeaseMonitor struct {
+	Client       kubernetes.Interface
+	Namespace    string
+	Name         string
+	HubClient    kubernetes.Interface
+	HubNamespace string
+	Interval     time.Duration
+}
+
+// Start reads the lease every Interval until the context is done
+func (m *LeaseMonitor) Start(ctx context.Context) {
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+
+	for {
+		m.observe(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// observe reads the renew time of the lease. Failures are only logged at the debug level, since the lease
+// updater logs its own failures.
+func (m *LeaseMonitor) observe(ctx context.Context) {
+	lease, err := m.Client.CoordinationV1().Leases(m.Namespace).Get(ctx, m.Name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) && m.HubClient != nil {
+		lease, err = m.HubClient.CoordinationV1().Leases(m.HubNamespace).Get(ctx, m.Name, metav1.GetOptions{})
+	}
+
+	if err != nil {
+		log.V(1).Info("Failed to read the addon lease", "Namespace", m.Namespace, "Name", m.Name,
+			"error", err.Error())
+
+		return
+	}
+
+	if lease.Spec.RenewTime == nil {
+		return
+	}
+
+	atomic.StoreInt64(&addonLeaseRenewTime, lease.Spec.RenewTime.UnixNano())
+	addonLeaseRenewTimestamp.Set(float64(lease.Spec.RenewTime.Unix()))
+}
@@ -0,0 +1,137 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// ValidateBindAddress returns an error if the bind address isn't a host and port that can be listened on.
+// IPv6 hosts must be in brackets, such as "[::]:8082". An empty host, such as ":8082", listens on all IPv4
+// and IPv6 interfaces. An empty address or "0" disables the listener.
+func ValidateBindAddress(addr string) error {
+	if addr == "" || addr == "0" {
+		return nil
+	}
+
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid bind address %q, IPv6 addresses must be in brackets such as [::]:8082: %w",
+			addr, err)
+	}
+
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return fmt.Errorf("invalid port in the bind address %q", addr)
+	}
+
+	return nil
+}
+
+// listenLocalhost listens on the port of both the IPv4 and IPv6 loopback addresses, so that localhost is
+// reachable on single-stack IPv4, single-stack IPv6, and dual-stack clusters. It only fails if neither
+// address can be listened on.
+func listenLocalhost(port string) (net.Listener, error) {
+	listeners := []net.Listener{}
+	errs := []error{}
+
+	for _, host := range []string{"127.0.0.1", "::1"} {
+		listener, err := net.Listen("tcp", net.JoinHostPort(host, port))
+		if err != nil {
+			errs = append(errs, err)
+
+			continue
+		}
+
+		listeners = append(listeners, listener)
+	}
+
+	switch len(listeners) {
+	case 0:
+		return nil, fmt.Errorf("failed to listen on localhost: %v", errs)
+	case 1:
+		return listeners[0], nil
+	default:
+		return newMultiListener(listeners), nil
+	}
+}
+
+// multiListener accepts the connections of several listeners
+type multiListener struct {
+	listeners []net.Listener
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newMultiListener(listeners []net.Listener) *multiListener {
+	m := &multiListener{
+		listeners: listeners,
+		conns:     make(chan net.Conn),
+		closed:    make(chan struct{}),
+	}
+
+	for _, listener := range listeners {
+		go m.accept(listener)
+	}
+
+	return m
+}
+
+// accept passes the connections of the listener to Accept until the listener is closed
+func (m *multiListener) accept(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Temporary() { //nolint:staticcheck
+				continue
+			}
+
+			return
+		}
+
+		select {
+		case m.conns <- conn:
+		case <-m.closed:
+			conn.Close()
+
+			return
+		}
+	}
+}
+
+// Accept returns the next connection of any of the listeners
+func (m *multiListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-m.conns:
+		return conn, nil
+	case <-m.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes all the listeners
+func (m *multiListener) Close() error {
+	var err error
+
+	m.closeOnce.Do(func() {
+		close(m.closed)
+
+		for _, listener := range m.listeners {
+			if closeErr := listener.Close(); closeErr != nil {
+				err = closeErr
+			}
+		}
+	})
+
+	return err
+}
+
+// Addr returns the address of the first listener
+func (m *multiListener) Addr() net.Addr {
+	return m.listeners[0].Addr()
+}
@@ -0,0 +1,271 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// maxLogVerbosity is the highest verbosity that the logs are checked for when detecting the default level
+	maxLogVerbosity = 10
+	// defaultLogSubsystem is the name of the overrides of the default level of all the subsystems
+	defaultLogSubsystem = "default"
+)
+
+// logSubsystemAliases are the short names of the subsystems whose logger name is longer
+var logSubsystemAliases = map[string]string{
+	"sync":    "policy-status-sync",
+	"webhook": "policy-status-webhook",
+}
+
+// LogLevels are the log levels of the subsystems that override the default log level, so that a single
+// subsystem can be debugged without debug logs for everything. The subsystems are the first name of the
+// loggers, such as policy-status-sync (or sync), hubclient, sinks, nscache, cmd, setup, or
+// controller-runtime. The levels are verbosities, where error is -1, info is 0, and debug is 1. The levels can
+// be changed at runtime with Update.
+type LogLevels struct {
+	lock         sync.RWMutex
+	defaultLevel int
+	levels       map[string]int
+	// flagDefaultLevel and flagLevels are the levels from the flags, which Update overrides
+	flagDefaultLevel int
+	flagLevels       map[string]int
+	// zapLevel is the zap level of the wrapped logger, which enables the most verbose of the levels
+	zapLevel zap.AtomicLevel
+}
+
+// NewLogLevels parses the --log-level overrides, such as sync=debug, against the default zap level. The
+// levels are error, info, debug, or a verbosity of 0 or more. It returns nil if there are no overrides,
+// unless the levels are dynamic so that they can be changed at runtime with Update.
+func NewLogLevels(
+	overrides map[string]string, defaultLevel zapcore.LevelEnabler, dynamic bool,
+) (*LogLevels, error) {
+	if len(overrides) == 0 && !dynamic {
+		return nil, nil
+	}
+
+	flagDefaultLevel, flagLevels, err := parseLogLevels(overrides, verbosity(defaultLevel))
+	if err != nil {
+		return nil, err
+	}
+
+	logLevels := &LogLevels{
+		defaultLevel:     flagDefaultLevel,
+		levels:           flagLevels,
+		flagDefaultLevel: flagDefaultLevel,
+		flagLevels:       flagLevels,
+		zapLevel:         zap.NewAtomicLevel(),
+	}
+	logLevels.setZapLevel()
+
+	return logLevels, nil
+}
+
+// parseLogLevels parses the overrides of the levels of the subsystems, and returns the default level, which
+// is the default subsystem if it's set, along with the levels of the other subsystems
+func parseLogLevels(overrides map[string]string, defaultLevel int) (int, map[string]int, error) {
+	levels := map[string]int{}
+
+	for subsystem, value := range overrides {
+		var level int
+
+		switch strings.ToLower(value) {
+		case "error":
+			level = -1
+		case "info":
+			level = 0
+		case "debug":
+			level = 1
+		default:
+			var err error
+
+			level, err = strconv.Atoi(value)
+			if err != nil || level < 0 {
+				return 0, nil, fmt.Errorf("invalid log level %q of the subsystem %s, it must be error, info, "+
+					"debug, or a verbosity of 0 or more", value, subsystem)
+			}
+		}
+
+		if subsystem == defaultLogSubsystem {
+			defaultLevel = level
+
+			continue
+		}
+
+		if alias, ok := logSubsystemAliases[subsystem]; ok {
+			subsystem = alias
+		}
+
+		levels[subsystem] = level
+	}
+
+	return defaultLevel, levels, nil
+}
+
+// Update overrides the levels from the flags with the overrides, such as sync=debug or default=debug for all
+// the subsystems, and restores the levels from the flags if there are no overrides
+func (l *LogLevels) Update(overrides map[string]string) error {
+	l.lock.RLock()
+	flagDefaultLevel := l.flagDefaultLevel
+	l.lock.RUnlock()
+
+	defaultLevel, levels, err := parseLogLevels(overrides, flagDefaultLevel)
+	if err != nil {
+		return err
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for subsystem, level := range l.flagLevels {
+		if _, ok := levels[subsystem]; !ok {
+			levels[subsystem] = level
+		}
+	}
+
+	l.defaultLevel = defaultLevel
+	l.levels = levels
+	l.setZapLevel()
+
+	return nil
+}
+
+// verbosity returns the highest verbosity that the zap level enables, which is -1 if it only enables errors
+func verbosity(level zapcore.LevelEnabler) int {
+	if level == nil {
+		return 0
+	}
+
+	if !level.Enabled(zapcore.InfoLevel) {
+		return -1
+	}
+
+	v := 0
+	for v < maxLogVerbosity && level.Enabled(zapcore.Level(-v-1)) {
+		v++
+	}
+
+	return v
+}
+
+// ZapLevel returns the zap level that enables the most verbose of the levels, since the logs of the other
+// subsystems are filtered by the loggers. It's updated when the levels change.
+func (l *LogLevels) ZapLevel() zapcore.LevelEnabler {
+	return l.zapLevel
+}
+
+// setZapLevel sets the zap level to the most verbose of the levels
+func (l *LogLevels) setZapLevel() {
+	highest := l.defaultLevel
+
+	for _, level := range l.levels {
+		if level > highest {
+			highest = level
+		}
+	}
+
+	if highest < 0 {
+		l.zapLevel.SetLevel(zapcore.ErrorLevel)
+	} else {
+		l.zapLevel.SetLevel(zapcore.Level(-highest))
+	}
+}
+
+// String returns the overrides, such as policy-status-sync=1, sorted by subsystem
+func (l *LogLevels) String() string {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	overrides := make([]string, 0, len(l.levels)+1)
+	for subsystem, level := range l.levels {
+		overrides = append(overrides, fmt.Sprintf("%s=%d", subsystem, level))
+	}
+
+	sort.Strings(overrides)
+
+	// the default level is only listed when it was overridden
+	if l.defaultLevel != l.flagDefaultLevel {
+		overrides = append([]string{fmt.Sprintf("%s=%d", defaultLogSubsystem, l.defaultLevel)}, overrides...)
+	}
+
+	return strings.Join(overrides, ",")
+}
+
+// Logger returns a logger that filters the info logs of the wrapped logger by the level of their subsystem.
+// It returns the wrapped logger if l is nil.
+func (l *LogLevels) Logger(wrapped logr.Logger) logr.Logger {
+	if l == nil {
+		return wrapped
+	}
+
+	return subsystemLogger{Logger: wrapped, levels: l}
+}
+
+// enabled returns true if the info logs of the subsystem at the verbosity are enabled
+func (l *LogLevels) enabled(subsystem string, v int) bool {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	level, ok := l.levels[subsystem]
+	if !ok {
+		level = l.defaultLevel
+	}
+
+	return v <= level
+}
+
+// subsystemLogger filters the info logs by the level of the subsystem, which is its first name. The errors
+// are always logged.
+type subsystemLogger struct {
+	logr.Logger
+	levels    *LogLevels
+	subsystem string
+	v         int
+}
+
+// Enabled returns true if the info logs are enabled
+func (l subsystemLogger) Enabled() bool {
+	return l.levels.enabled(l.subsystem, l.v) && l.Logger.Enabled()
+}
+
+// Info logs the message if the info logs of the subsystem at the verbosity are enabled
+func (l subsystemLogger) Info(msg string, keysAndValues ...interface{}) {
+	if l.levels.enabled(l.subsystem, l.v) {
+		l.Logger.Info(msg, keysAndValues...)
+	}
+}
+
+// V returns a logger at a higher verbosity
+func (l subsystemLogger) V(level int) logr.Logger {
+	l.Logger = l.Logger.V(level)
+	l.v += level
+
+	return l
+}
+
+// WithValues returns a logger with the key and value pairs
+func (l subsystemLogger) WithValues(keysAndValues ...interface{}) logr.Logger {
+	l.Logger = l.Logger.WithValues(keysAndValues...)
+
+	return l
+}
+
+// WithName returns a logger with the name, which is the subsystem if it's the first name
+func (l subsystemLogger) WithName(name string) logr.Logger {
+	l.Logger = l.Logger.WithName(name)
+
+	if l.subsystem == "" {
+		l.subsystem = name
+	}
+
+	return l
+}
@@ -0,0 +1,201 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"go.uber.org/zap/zapcore"
+)
+
+// recordingLogger is a logger that records the messages of all the logs
+type recordingLogger struct {
+	messages *[]string
+}
+
+func (l recordingLogger) Enabled() bool { return true }
+
+func (l recordingLogger) Info(msg string, _ ...interface{}) { *l.messages = append(*l.messages, msg) }
+
+func (l recordingLogger) Error(_ error, msg string, _ ...interface{}) {
+	*l.messages = append(*l.messages, msg)
+}
+
+func (l recordingLogger) V(int) logr.Logger { return l }
+
+func (l recordingLogger) WithValues(...interface{}) logr.Logger { return l }
+
+func (l recordingLogger) WithName(string) logr.Logger { return l }
+
+func TestNewLogLevels(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		overrides    map[string]string
+		defaultLevel zapcore.LevelEnabler
+		// expected are the overrides of the levels, and zapLevel is the level of the wrapped logger
+		expected string
+		zapLevel zapcore.Level
+		err      string
+	}{
+		"subsystems": {
+			map[string]string{"sync": "debug", "hubclient": "info"}, zapcore.InfoLevel,
+			"hubclient=0,policy-status-sync=1", zapcore.DebugLevel, "",
+		},
+		// the default level from the flags is only listed when Update overrides it
+		"default subsystem": {map[string]string{"default": "debug"}, zapcore.InfoLevel, "", zapcore.DebugLevel, ""},
+		"errors only":       {map[string]string{"sinks": "error"}, zapcore.InfoLevel, "sinks=-1", 0, ""},
+		"verbosity":         {map[string]string{"webhook": "3"}, nil, "policy-status-webhook=3", -3, ""},
+		"capitalized":       {map[string]string{"nscache": "Debug"}, nil, "nscache=1", zapcore.DebugLevel, ""},
+		"debug default":     {map[string]string{"sync": "error"}, zapcore.DebugLevel, "policy-status-sync=-1", -1, ""},
+		"errors default":    {map[string]string{"cmd": "info"}, zapcore.ErrorLevel, "cmd=0", zapcore.InfoLevel, ""},
+		"dynamic default":   {map[string]string{}, zapcore.ErrorLevel, "", zapcore.ErrorLevel, ""},
+		"invalid level": {
+			map[string]string{"sync": "verbose"}, nil, "", 0,
+			`invalid log level "verbose" of the subsystem sync, it must be error, info, debug, or a verbosity of 0 ` +
+				"or more",
+		},
+		"negative verbosity": {
+			map[string]string{"hubclient": "-2"}, nil, "", 0,
+			`invalid log level "-2" of the subsystem hubclient, it must be error, info, debug, or a verbosity of ` +
+				"0 or more",
+		},
+	}
+
+	for name, test := range tests {
+		logLevels, err := NewLogLevels(test.overrides, test.defaultLevel, true)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Fatalf("%s: expected the error %q, got %v", name, test.err, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if overrides := logLevels.String(); overrides != test.expected {
+			t.Fatalf("%s: expected the overrides %q, got %q", name, test.expected, overrides)
+		}
+
+		if zapLevel := logLevels.zapLevel.Level(); zapLevel != test.zapLevel {
+			t.Fatalf("%s: expected the zap level %v, got %v", name, test.zapLevel, zapLevel)
+		}
+	}
+
+	// the levels are only needed for overrides, unless they can be changed at runtime
+	if logLevels, err := NewLogLevels(nil, zapcore.InfoLevel, false); logLevels != nil || err != nil {
+		t.Fatalf("expected no log levels without overrides, got %v with %v", logLevels, err)
+	}
+}
+
+func TestLogLevelsUpdate(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		overrides map[string]string
+		expected  string
+		zapLevel  zapcore.Level
+		err       bool
+	}{
+		"restored flags":  {nil, "hubclient=-1,policy-status-sync=1", zapcore.DebugLevel, false},
+		"overridden flag": {map[string]string{"sync": "info"}, "hubclient=-1,policy-status-sync=0", 0, false},
+		"other subsystem": {
+			map[string]string{"sinks": "2"}, "hubclient=-1,policy-status-sync=1,sinks=2", -2, false,
+		},
+		"overridden default": {
+			map[string]string{"default": "error"}, "default=-1,hubclient=-1,policy-status-sync=1", -1, false,
+		},
+		"invalid level": {
+			map[string]string{"sync": "trace"}, "hubclient=-1,policy-status-sync=4,sinks=4", -4, true,
+		},
+	}
+
+	flagLevels := map[string]string{"sync": "debug", "hubclient": "error"}
+
+	for name, test := range tests {
+		logLevels, err := NewLogLevels(flagLevels, zapcore.InfoLevel, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the previous update is replaced
+		if err := logLevels.Update(map[string]string{"sync": "4", "sinks": "4"}); err != nil {
+			t.Fatal(err)
+		}
+
+		// an invalid update keeps the levels
+		if err := logLevels.Update(test.overrides); (err != nil) != test.err {
+			t.Fatalf("%s: expected an error: %v, got %v", name, test.err, err)
+		}
+
+		if overrides := logLevels.String(); overrides != test.expected {
+			t.Fatalf("%s: expected the overrides %q, got %q", name, test.expected, overrides)
+		}
+
+		if zapLevel := logLevels.zapLevel.Level(); zapLevel != test.zapLevel {
+			t.Fatalf("%s: expected the zap level %v, got %v", name, test.zapLevel, zapLevel)
+		}
+	}
+}
+
+func TestSubsystemLogger(t *testing.T) {
+	t.Parallel()
+
+	logLevels, err := NewLogLevels(map[string]string{"sync": "debug", "sinks": "error"}, zapcore.InfoLevel, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		names  []string
+		v      int
+		logged bool
+	}{
+		"debug subsystem":       {[]string{"policy-status-sync"}, 1, true},
+		"too verbose":           {[]string{"policy-status-sync"}, 2, false},
+		"default level":         {[]string{"hubclient"}, 0, true},
+		"debug at default":      {[]string{"hubclient"}, 1, false},
+		"errors only":           {[]string{"sinks"}, 0, false},
+		"first name":            {[]string{"policy-status-sync", "hubclient"}, 1, true},
+		"first name is limited": {[]string{"sinks", "policy-status-sync"}, 0, false},
+		"no name":               {nil, 0, true},
+	}
+
+	for name, test := range tests {
+		messages := []string{}
+		logger := logLevels.Logger(recordingLogger{messages: &messages})
+
+		for _, loggerName := range test.names {
+			logger = logger.WithName(loggerName)
+		}
+
+		logger = logger.WithValues("policy", "policies.policy").V(test.v)
+		logger.Info("info")
+
+		if logger.Enabled() != test.logged || (len(messages) == 1) != test.logged {
+			t.Fatalf("%s: expected the info log to be logged: %v, got %v", name, test.logged, messages)
+		}
+
+		// the errors are always logged
+		logger.Error(errors.New("an error"), "error")
+
+		if messages[len(messages)-1] != "error" {
+			t.Fatalf("%s: expected the error log to be logged, got %v", name, messages)
+		}
+	}
+
+	var disabled *LogLevels
+	if logger := (recordingLogger{}); disabled.Logger(logger) != logger {
+		t.Fatal("expected the wrapped logger without the log levels")
+	}
+
+	if strings.Contains(logLevels.String(), "default") {
+		t.Fatalf("expected the default level to not be listed, got %q", logLevels.String())
+	}
+}
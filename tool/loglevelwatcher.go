@@ -0,0 +1,109 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// LogLevelConfigMapKey is the key of the log level ConfigMap with the overrides of the log levels, in the
+	// format of --log-level
+	LogLevelConfigMapKey = "log-level"
+	// logLevelWatchInterval is the interval at which the log level ConfigMap is read
+	logLevelWatchInterval = 10 * time.Second
+)
+
+// LogLevelWatcher reads the log level ConfigMap in the namespace of the agent periodically and updates the
+// log levels from its LogLevelConfigMapKey key, such as sync=debug,hubclient=2, so that the verbosity of a
+// remote agent can be raised without changing its deployment or restarting it. The levels of the flags are
+// restored when the key or the ConfigMap is removed. An invalid value is logged and the levels are left
+// unchanged.
+type LogLevelWatcher struct {
+	Client    kubernetes.Interface
+	Namespace string
+	Name      string
+	Levels    *LogLevels
+	// applied is the value of the key that the levels were last updated from
+	applied string
+}
+
+// Start updates the log levels from the ConfigMap until the context is done
+func (w *LogLevelWatcher) Start(ctx context.Context) {
+	ticker := time.NewTicker(logLevelWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		w.update(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// update reads the ConfigMap and updates the log levels when the value of its key changed
+func (w *LogLevelWatcher) update(ctx context.Context) {
+	value := ""
+
+	configMap, err := w.Client.CoreV1().ConfigMaps(w.Namespace).Get(ctx, w.Name, metav1.GetOptions{})
+	if err == nil {
+		value = strings.TrimSpace(configMap.Data[LogLevelConfigMapKey])
+	} else if !errors.IsNotFound(err) {
+		log.V(1).Info("Failed to read the log level ConfigMap", "Namespace", w.Namespace, "Name", w.Name,
+			"error", err.Error())
+
+		return
+	}
+
+	if value == w.applied {
+		return
+	}
+
+	// an invalid value is only logged once until it changes
+	w.applied = value
+
+	overrides, err := parseLogLevelOverrides(value)
+	if err == nil {
+		err = w.Levels.Update(overrides)
+	}
+
+	if err != nil {
+		log.Error(err, "Invalid log levels in the log level ConfigMap", "Namespace", w.Namespace, "Name", w.Name,
+			"key", LogLevelConfigMapKey)
+
+		return
+	}
+
+	log.Info("Updated the log levels from the log level ConfigMap", "Namespace", w.Namespace, "Name", w.Name,
+		"levels", w.Levels.String())
+}
+
+// parseLogLevelOverrides parses the comma-separated subsystem=level overrides
+func parseLogLevelOverrides(value string) (map[string]string, error) {
+	overrides := map[string]string{}
+
+	if value == "" {
+		return overrides, nil
+	}
+
+	for _, override := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(override), "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid log level override %q, it must be subsystem=level", override)
+		}
+
+		overrides[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	return overrides, nil
+}
@@ -0,0 +1,178 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const managedCertCheckInterval = time.Minute
+
+var (
+	managedCertExpiryTimestamp = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "policy_status_sync_managed_client_cert_expiry_timestamp_seconds",
+			Help: "The Unix time when the client certificate of the managed cluster kubeconfig expires",
+		},
+	)
+	managedCertExpiring = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "policy_status_sync_managed_client_cert_expiring",
+			Help: "1 if the client certificate of the managed cluster kubeconfig expires within the expiry " +
+				"warning period or has expired, and 0 otherwise",
+		},
+	)
+	managedCertRenewalsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "policy_status_sync_managed_client_cert_renewals_total",
+			Help: "The number of times that a renewed client certificate of the managed cluster kubeconfig was " +
+				"detected",
+		},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(managedCertExpiryTimestamp, managedCertExpiring, managedCertRenewalsTotal)
+}
+
+// clientCertificate returns the client certificate of the config, read from its file if it's not embedded,
+// or nil if the config has no client certificate
+func clientCertificate(cfg *rest.Config) (*x509.Certificate, error) {
+	certData := cfg.TLSClientConfig.CertData
+	if len(certData) == 0 && cfg.TLSClientConfig.CertFile != "" {
+		var err error
+
+		certData, err = ioutil.ReadFile(cfg.TLSClientConfig.CertFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(certData) == 0 {
+		return nil, nil
+	}
+
+	block, _ := pem.Decode(certData)
+	if block == nil {
+		return nil, errors.New("the client certificate is not PEM encoded")
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// ReloadedCertFiles returns the client certificate and key files of the config if the clients reload them
+// when they change, which is the case when neither is embedded in the config. It returns nil otherwise.
+func ReloadedCertFiles(cfg *rest.Config) []string {
+	tlsConfig := cfg.TLSClientConfig
+	if tlsConfig.CertFile == "" || tlsConfig.KeyFile == "" ||
+		len(tlsConfig.CertData) != 0 || len(tlsConfig.KeyData) != 0 {
+		return nil
+	}
+
+	return []string{tlsConfig.CertFile, tlsConfig.KeyFile}
+}
+
+// ManagedCertMonitor monitors the client certificate of the managed cluster kubeconfig, such as an external
+// MANAGED_CONFIG whose client certificate is rotated. The expiry is set in the
+// policy_status_sync_managed_client_cert_expiry_timestamp_seconds metric, and a certificate that expires
+// within the Warning period is logged and sets the policy_status_sync_managed_client_cert_expiring metric.
+// The renewals are logged and counted, since the clients pick up the renewed certificate on their own: the
+// certificate and key files are reloaded by the client transports, which then close their connections, and
+// a kubeconfig with an embedded certificate restarts the container through the config checker. It must be
+// added to the manager to be monitored.
+type ManagedCertMonitor struct {
+	// Kubeconfig is the path of the managed cluster kubeconfig, which is read again on each check. The
+	// Config is used if it's empty.
+	Kubeconfig string
+	Config     *rest.Config
+	Warning    time.Duration
+	serial     string
+	warned     bool
+}
+
+// NeedLeaderElection is false so that the certificate of the standby instances is also monitored
+func (m *ManagedCertMonitor) NeedLeaderElection() bool {
+	return false
+}
+
+// Start checks the client certificate every minute until the context is done
+func (m *ManagedCertMonitor) Start(ctx context.Context) error {
+	ticker := time.NewTicker(managedCertCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		m.check(time.Now())
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// check reads the current client certificate and observes its expiry and renewal
+func (m *ManagedCertMonitor) check(now time.Time) {
+	cfg := m.Config
+
+	if m.Kubeconfig != "" {
+		var err error
+
+		cfg, err = clientcmd.BuildConfigFromFlags("", m.Kubeconfig)
+		if err != nil {
+			log.Error(err, "Failed to load the managed cluster kubeconfig to check its client certificate")
+
+			return
+		}
+	}
+
+	cert, err := clientCertificate(cfg)
+	if err != nil {
+		log.Error(err, "Failed to read the client certificate of the managed cluster kubeconfig")
+
+		return
+	}
+
+	if cert == nil {
+		return
+	}
+
+	serial := cert.SerialNumber.String()
+	if m.serial != "" && serial != m.serial {
+		log.Info("The client certificate of the managed cluster kubeconfig was renewed",
+			"NotAfter", cert.NotAfter.UTC().Format(time.RFC3339))
+		managedCertRenewalsTotal.Inc()
+
+		m.warned = false
+	}
+
+	m.serial = serial
+
+	managedCertExpiryTimestamp.Set(float64(cert.NotAfter.Unix()))
+
+	if cert.NotAfter.Sub(now) > m.Warning {
+		managedCertExpiring.Set(0)
+
+		return
+	}
+
+	managedCertExpiring.Set(1)
+
+	if !m.warned {
+		log.Error(fmt.Errorf("the client certificate expires at %s", cert.NotAfter.UTC().Format(time.RFC3339)),
+			"The client certificate of the managed cluster kubeconfig is expiring and hasn't been renewed")
+
+		m.warned = true
+	}
+}
@@ -0,0 +1,15 @@
+//go:build go1.19
+// +build go1.19
+
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import "runtime/debug"
+
+// setMemoryLimit sets the Go runtime soft memory limit and returns whether it is supported
+func setMemoryLimit(limit int64) bool {
+	debug.SetMemoryLimit(limit)
+
+	return true
+}
@@ -0,0 +1,11 @@
+//go:build !go1.19
+// +build !go1.19
+
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+// setMemoryLimit is a no-op since the Go runtime only supports a soft memory limit starting in Go 1.19
+func setMemoryLimit(limit int64) bool {
+	return false
+}
@@ -0,0 +1,99 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"errors"
+	"io/ioutil"
+	"math"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// cgroupMemoryLimitFiles are the files that contain the container memory limit for cgroup v2 and v1
+var cgroupMemoryLimitFiles = []string{
+	"/sys/fs/cgroup/memory.max",
+	"/sys/fs/cgroup/memory/memory.limit_in_bytes",
+}
+
+// errNoMemoryLimit indicates that the container doesn't have a memory limit
+var errNoMemoryLimit = errors.New("no container memory limit is set")
+
+// DetectMemoryLimit returns the container memory limit in bytes from the cgroup filesystem
+func DetectMemoryLimit() (int64, error) {
+	var lastErr error
+
+	for _, path := range cgroupMemoryLimitFiles {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			lastErr = err
+
+			continue
+		}
+
+		value := strings.TrimSpace(string(contents))
+		if value == "max" {
+			return 0, errNoMemoryLimit
+		}
+
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+
+		// cgroup v1 reports a very large number when there is no limit
+		if limit <= 0 || limit >= math.MaxInt64/2 {
+			return 0, errNoMemoryLimit
+		}
+
+		return limit, nil
+	}
+
+	return 0, lastErr
+}
+
+// ConfigureMemory applies the garbage collector and memory limit settings from the command line flags. The
+// soft memory limit is set to a ratio of the container memory limit unless GOMEMLIMIT is set, which the Go
+// runtime already honors.
+func ConfigureMemory() {
+	if Options.GCPercent != 0 {
+		previous := debug.SetGCPercent(Options.GCPercent)
+		log.Info("Set the garbage collection target percentage", "gcPercent", Options.GCPercent, "previous", previous)
+	}
+
+	if _, found := os.LookupEnv("GOMEMLIMIT"); found {
+		log.Info("Using the memory limit from the GOMEMLIMIT environment variable")
+
+		return
+	}
+
+	if Options.MemoryLimitRatio <= 0 {
+		return
+	}
+
+	if Options.MemoryLimitRatio > 1 {
+		log.Info("Ignoring the memory limit ratio since it is greater than 1", "ratio", Options.MemoryLimitRatio)
+
+		return
+	}
+
+	containerLimit, err := DetectMemoryLimit()
+	if err != nil {
+		log.V(1).Info("Not setting a memory limit", "reason", err.Error())
+
+		return
+	}
+
+	limit := int64(float64(containerLimit) * Options.MemoryLimitRatio)
+
+	if !setMemoryLimit(limit) {
+		log.Info("The Go runtime does not support a soft memory limit, not setting it")
+
+		return
+	}
+
+	log.Info("Set the Go soft memory limit from the container memory limit",
+		"containerLimit", containerLimit, "memoryLimit", limit)
+}
@@ -0,0 +1,86 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// ClusterMetricLabel is the label with the managed cluster name added to all exported metrics
+	ClusterMetricLabel = "cluster"
+	// ClusterIdentityMetricLabel is the label with the cluster identity added to all exported metrics
+	ClusterIdentityMetricLabel = "cluster_identity"
+)
+
+// clusterLabeledRegistry adds the cluster labels to every metric it gathers, including the controller-runtime
+// and Go runtime metrics, so that the metrics of many clusters can be aggregated without relabeling
+type clusterLabeledRegistry struct {
+	metrics.RegistererGatherer
+	// labels are the values of the cluster labels, by label name
+	labels map[string]string
+}
+
+// Gather gathers the metrics of the wrapped registry and adds the cluster labels to the metrics that don't
+// already have them
+func (r *clusterLabeledRegistry) Gather() ([]*dto.MetricFamily, error) {
+	families, err := r.RegistererGatherer.Gather()
+
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			for label, labelValue := range r.labels {
+				if hasLabel(metric, label) {
+					continue
+				}
+
+				name := label
+				value := labelValue
+				metric.Label = append(metric.Label, &dto.LabelPair{Name: &name, Value: &value})
+			}
+
+			sort.Slice(metric.Label, func(i, j int) bool {
+				return metric.Label[i].GetName() < metric.Label[j].GetName()
+			})
+		}
+	}
+
+	return families, err
+}
+
+func hasLabel(metric *dto.Metric, label string) bool {
+	for _, pair := range metric.Label {
+		if pair.GetName() == label {
+			return true
+		}
+	}
+
+	return false
+}
+
+// LabelMetrics adds the cluster label with the cluster name and the cluster_identity label with the cluster
+// identity to all the metrics exported by the manager. It must be called before the manager is started, and
+// an empty cluster name or identity isn't added.
+func LabelMetrics(clusterName string, clusterIdentity string) {
+	labels := map[string]string{}
+
+	if clusterName != "" {
+		labels[ClusterMetricLabel] = clusterName
+	}
+
+	if clusterIdentity != "" {
+		labels[ClusterIdentityMetricLabel] = clusterIdentity
+	}
+
+	if len(labels) == 0 {
+		return
+	}
+
+	metrics.Registry = &clusterLabeledRegistry{RegistererGatherer: metrics.Registry, labels: labels}
+}
+
+// blank assignment to verify that clusterLabeledRegistry implements prometheus.Gatherer
+var _ prometheus.Gatherer = &clusterLabeledRegistry{}
@@ -0,0 +1,232 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var hubNamespaceViolationsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "policy_status_sync_hub_namespace_violations_total",
+		Help: "The number of writes to the hub outside of the allowed hub namespaces that were refused, by " +
+			"operation",
+	},
+	[]string{"operation"},
+)
+
+func init() {
+	metrics.Registry.MustRegister(hubNamespaceViolationsTotal)
+}
+
+// NamespaceNotAllowedError is returned for writes to the hub outside of the allowed hub namespaces
+type NamespaceNotAllowedError struct {
+	Operation string
+	Namespace string
+	Allowed   []string
+}
+
+func (e *NamespaceNotAllowedError) Error() string {
+	return fmt.Sprintf("refusing to %s in the hub namespace %q, only %s are allowed", e.Operation, e.Namespace,
+		strings.Join(e.Allowed, ", "))
+}
+
+// HubNamespaceGuard restricts the writes to the hub, including events, to the allowed namespaces, which are
+// normally the cluster namespace of this agent, so that a misconfigured agent, such as one with a broad
+// WATCH_NAMESPACE, never writes to the namespace of another cluster on a shared hub. The refused writes are
+// logged and counted in the policy_status_sync_hub_namespace_violations_total metric.
+type HubNamespaceGuard struct {
+	allowed map[string]bool
+}
+
+// NewHubNamespaceGuard returns a HubNamespaceGuard that allows the namespaces
+func NewHubNamespaceGuard(namespaces []string) *HubNamespaceGuard {
+	guard := &HubNamespaceGuard{allowed: map[string]bool{}}
+
+	for _, namespace := range namespaces {
+		guard.allowed[namespace] = true
+	}
+
+	return guard
+}
+
+// check returns a NamespaceNotAllowedError if the namespace isn't allowed, and logs and counts it
+func (g *HubNamespaceGuard) check(operation string, namespace string) error {
+	if g.allowed[namespace] {
+		return nil
+	}
+
+	allowed := make([]string, 0, len(g.allowed))
+	for namespace := range g.allowed {
+		allowed = append(allowed, namespace)
+	}
+
+	sort.Strings(allowed)
+
+	err := &NamespaceNotAllowedError{Operation: operation, Namespace: namespace, Allowed: allowed}
+
+	hubNamespaceViolationsTotal.WithLabelValues(operation).Inc()
+	log.Error(err, "Refused a write to the hub outside of the allowed hub namespaces")
+
+	return err
+}
+
+// Client returns a client that refuses the writes of the wrapped hub client outside of the allowed namespaces
+func (g *HubNamespaceGuard) Client(wrapped client.Client) client.Client {
+	return &namespaceGuardClient{Client: wrapped, guard: g}
+}
+
+// Recorder returns a recorder that drops the events of the wrapped hub recorder on objects outside of the
+// allowed namespaces, since events are recorded in the namespace of their object
+func (g *HubNamespaceGuard) Recorder(wrapped record.EventRecorder) record.EventRecorder {
+	return &namespaceGuardRecorder{recorder: wrapped, guard: g}
+}
+
+// namespaceGuardClient refuses the writes outside of the allowed namespaces of the guard
+type namespaceGuardClient struct {
+	client.Client
+	guard *HubNamespaceGuard
+}
+
+// Create creates an object
+func (c *namespaceGuardClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if err := c.guard.check("create", obj.GetNamespace()); err != nil {
+		return err
+	}
+
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+// Delete deletes an object
+func (c *namespaceGuardClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	if err := c.guard.check("delete", obj.GetNamespace()); err != nil {
+		return err
+	}
+
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+// Update updates an object
+func (c *namespaceGuardClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if err := c.guard.check("update", obj.GetNamespace()); err != nil {
+		return err
+	}
+
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+// Patch patches an object
+func (c *namespaceGuardClient) Patch(
+	ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption,
+) error {
+	if err := c.guard.check("patch", obj.GetNamespace()); err != nil {
+		return err
+	}
+
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+// DeleteAllOf deletes all objects of the given type matching the options
+func (c *namespaceGuardClient) DeleteAllOf(
+	ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption,
+) error {
+	deleteAllOfOpts := &client.DeleteAllOfOptions{}
+	deleteAllOfOpts.ApplyOptions(opts)
+
+	if err := c.guard.check("delete", deleteAllOfOpts.Namespace); err != nil {
+		return err
+	}
+
+	return c.Client.DeleteAllOf(ctx, obj, opts...)
+}
+
+// Status returns a writer for the status subresource that refuses the writes outside of the allowed
+// namespaces
+func (c *namespaceGuardClient) Status() client.StatusWriter {
+	return &namespaceGuardStatusWriter{writer: c.Client.Status(), guard: c.guard}
+}
+
+// namespaceGuardStatusWriter refuses the status writes outside of the allowed namespaces of the guard
+type namespaceGuardStatusWriter struct {
+	writer client.StatusWriter
+	guard  *HubNamespaceGuard
+}
+
+// Update updates the status of an object
+func (w *namespaceGuardStatusWriter) Update(
+	ctx context.Context, obj client.Object, opts ...client.UpdateOption,
+) error {
+	if err := w.guard.check("update status", obj.GetNamespace()); err != nil {
+		return err
+	}
+
+	return w.writer.Update(ctx, obj, opts...)
+}
+
+// Patch patches the status of an object
+func (w *namespaceGuardStatusWriter) Patch(
+	ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption,
+) error {
+	if err := w.guard.check("patch status", obj.GetNamespace()); err != nil {
+		return err
+	}
+
+	return w.writer.Patch(ctx, obj, patch, opts...)
+}
+
+// namespaceGuardRecorder drops the events on objects outside of the allowed namespaces of the guard
+type namespaceGuardRecorder struct {
+	recorder record.EventRecorder
+	guard    *HubNamespaceGuard
+}
+
+// allowed returns true if the events of the object may be recorded
+func (r *namespaceGuardRecorder) allowed(object runtime.Object) bool {
+	if reference, ok := object.(*corev1.ObjectReference); ok {
+		return r.guard.check("record an event", reference.Namespace) == nil
+	}
+
+	accessor, err := meta.Accessor(object)
+	if err != nil {
+		return false
+	}
+
+	return r.guard.check("record an event", accessor.GetNamespace()) == nil
+}
+
+// Event records an event on the object
+func (r *namespaceGuardRecorder) Event(object runtime.Object, eventtype string, reason string, message string) {
+	if r.allowed(object) {
+		r.recorder.Event(object, eventtype, reason, message)
+	}
+}
+
+// Eventf records an event on the object with a formatted message
+func (r *namespaceGuardRecorder) Eventf(
+	object runtime.Object, eventtype string, reason string, messageFmt string, args ...interface{},
+) {
+	if r.allowed(object) {
+		r.recorder.Eventf(object, eventtype, reason, messageFmt, args...)
+	}
+}
+
+// AnnotatedEventf records an event on the object with annotations and a formatted message
+func (r *namespaceGuardRecorder) AnnotatedEventf(
+	object runtime.Object, annotations map[string]string, eventtype string, reason string, messageFmt string,
+	args ...interface{},
+) {
+	if r.allowed(object) {
+		r.recorder.AnnotatedEventf(object, annotations, eventtype, reason, messageFmt, args...)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func configMap(namespace string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "config"}}
+}
+
+func TestHubNamespaceGuardClient(t *testing.T) {
+	t.Parallel()
+
+	wrapped := fake.NewClientBuilder().WithObjects(configMap("cluster1"), configMap("cluster2")).Build()
+	guarded := NewHubNamespaceGuard([]string{"cluster1"}).Client(wrapped)
+	ctx := context.TODO()
+
+	tests := map[string]func(namespace string) error{
+		"create": func(namespace string) error {
+			return guarded.Create(ctx, &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "created"},
+			})
+		},
+		"update":        func(namespace string) error { return guarded.Update(ctx, configMap(namespace)) },
+		"update status": func(namespace string) error { return guarded.Status().Update(ctx, configMap(namespace)) },
+		"patch": func(namespace string) error {
+			return guarded.Patch(ctx, configMap(namespace), client.MergeFrom(configMap(namespace)))
+		},
+		"delete all of": func(namespace string) error {
+			return guarded.DeleteAllOf(ctx, &corev1.Secret{}, client.InNamespace(namespace))
+		},
+		"delete": func(namespace string) error { return guarded.Delete(ctx, configMap(namespace)) },
+	}
+
+	// the delete is last since the other writes need the objects
+	for _, name := range []string{"create", "update", "update status", "patch", "delete all of", "delete"} {
+		write := tests[name]
+
+		var notAllowed *NamespaceNotAllowedError
+		if err := write("cluster2"); !errors.As(err, &notAllowed) || notAllowed.Namespace != "cluster2" {
+			t.Fatalf("expected the %s in the cluster2 namespace to be refused, got %v", name, err)
+		}
+
+		if err := write("cluster1"); errors.As(err, &notAllowed) {
+			t.Fatalf("expected the %s in the cluster1 namespace to be allowed, got %v", name, err)
+		}
+	}
+
+	// the refused delete didn't reach the hub
+	if err := wrapped.Get(ctx, client.ObjectKeyFromObject(configMap("cluster2")), &corev1.ConfigMap{}); err != nil {
+		t.Fatalf("expected the object in the cluster2 namespace to still exist, got %v", err)
+	}
+}
+
+func TestHubNamespaceGuardRecorder(t *testing.T) {
+	t.Parallel()
+
+	wrapped := record.NewFakeRecorder(10)
+	guarded := NewHubNamespaceGuard([]string{"cluster1"}).Recorder(wrapped)
+
+	guarded.Event(configMap("cluster2"), "Normal", "Refused", "other cluster")
+	guarded.Eventf(&corev1.ObjectReference{Namespace: "cluster2"}, "Normal", "Refused", "other %s", "cluster")
+	guarded.AnnotatedEventf(configMap("cluster2"), nil, "Normal", "Refused", "other cluster")
+	guarded.Event(configMap("cluster1"), "Normal", "Allowed", "own cluster")
+	guarded.Eventf(&corev1.ObjectReference{Namespace: "cluster1"}, "Normal", "Allowed", "own %s", "cluster")
+	guarded.AnnotatedEventf(configMap("cluster1"), nil, "Normal", "Allowed", "own cluster")
+
+	close(wrapped.Events)
+
+	recorded := 0
+
+	for event := range wrapped.Events {
+		if event != "Normal Allowed own cluster" {
+			t.Fatalf("expected only the events in the cluster1 namespace, got %q", event)
+		}
+
+		recorded++
+	}
+
+	if recorded != 3 {
+		t.Fatalf("expected 3 events to be recorded, got %d", recorded)
+	}
+}
@@ -5,6 +5,7 @@ package tool
 
 import (
 	"context"
+	"time"
 
 	"github.com/spf13/pflag"
 	corev1 "k8s.io/api/core/v1"
@@ -18,14 +19,121 @@ var log = logf.Log.WithName("cmd")
 
 // PolicySpecSyncOptions for command line flag parsing
 type PolicySpecSyncOptions struct {
+	AllHubEvents              bool
+	CachePolicyEventsOnly     bool
+	ClusterIdentity           string
 	ClusterName               string
+	ComplianceHistoryAPIURL   string
+	ComplianceHistoryAuth     string
+	ComplianceHistoryAuthDir  string
+	ComplianceHistoryBatch    int
+	ComplianceHistoryCAFile   string
+	AddonName                 string
+	ComplianceHistoryOnly     bool
+	ComplianceHistoryToken    string
 	ClusterNamespace          string
+	ClusterNameSource         string
+	HistoryMinSeverity        string
+	HistorySummaryEntries     int
+	HubAPIBudget              int
+	HubAPIBudgetLease         string
+	HubConditionInterval      time.Duration
 	HubConfigFilePathName     string
+	HubConsistencyInterval    time.Duration
+	HubDryRun                 bool
+	HubEventsAPI              bool
+	HubFieldManager           string
+	HubWriteAudit             bool
+	HubWriteCoalescingWindow  time.Duration
+	HubWriteFaultErrorRate    float64
+	HubWriteFaultLatency      time.Duration
+	HubNamespaceLabel         string
+	HubServerSideApply        bool
+	HubStatusIntegrity        bool
+	HubStatusPatch            bool
+	HubUserAgent              string
+	HubWriteNamespaces        []string
+	KeepHistoryOnHubRecreate  bool
+	KubeAPIContentType        string
+	ManagedCertExpiryWarning  time.Duration
+	ManagedCertRotation       bool
 	ManagedConfigFilePathName string
+	HostingConfigFilePathName string
+	Hosted                    bool
 	EnableLease               bool
 	EnableLeaderElection      bool
+	ComplianceScoreClaim      bool
+	ComplianceScoreWeights    map[string]string
+	ComplianceTrendInterval   time.Duration
+	ComplianceTrendRetention  time.Duration
+	EnableComplianceAPI       bool
+	EnableStatusAPI           bool
+	EnableStatusWebhook       bool
+	EnableSupportBundle       bool
+	EventCacheSize            int
+	EventComponent            string
+	EventLookback             time.Duration
+	ExcludePolicies           []string
+	FakeHub                   bool
+	FakeHubDumpFile           string
+	FanInSecretNamespace      string
+	FeatureGates              map[string]string
+	FanInSecretSelector       string
+	GCPercent                 int
+	HealthSummaryInterval     time.Duration
 	LegacyLeaderElection      bool
+	LocalCluster              bool
+	LogBudget                 int
+	LogLevelConfigMap         string
+	LogLevels                 map[string]string
+	MarkStoppedOnShutdown     bool
+	MaxHubPolicyBytes         int
+	MaxWatchedPolicies        int
+	MemoryLimitRatio          float64
+	MetricsAddr               string
+	MQTTBroker                string
+	MQTTCAFile                string
+	MQTTCertFile              string
+	MQTTChannelTopicPrefixes  map[string]string
+	MQTTClientID              string
+	MQTTKeyFile               string
+	MQTTAuthSecretDir         string
+	MQTTPasswordFile          string
+	MQTTQoS                   uint8
+	MQTTTopicPrefix           string
+	MQTTUsername              string
+	NamespaceSelector         string
+	Once                      bool
+	PolicyOverflow            string
+	PolicyOverflowInterval    time.Duration
+	PolicyExclusionsConfigMap string
+	PolicySetMembership       bool
 	ProbeAddr                 string
+	ProbeCertFile             string
+	ProbeKeyFile              string
+	ProbeLocalhostOnly        bool
+	QueueStallTimeout         time.Duration
+	RootPolicyLabels          []string
+	SinkCompression           string
+	SinkCompressionThreshold  int
+	SinkControls              map[string]string
+	SinkDigestInterval        time.Duration
+	SelfMonitor               bool
+	SinkFilter                string
+	SinkQuietHours            []string
+	SinkQuietHoursMode        string
+	SinkQuietHoursTimezone    string
+	SpecDriftAudit            bool
+	StatusHeartbeatInterval   time.Duration
+	StatusSyncHealth          bool
+	StatusTransport           string
+	StartupRetryTimeout       time.Duration
+	StatusWebhookAllowedUsers []string
+	TemplateStaleThreshold    time.Duration
+	UnknownCompliance         string
+	WebhookCertDir            string
+	WebhookPort               int
+	WarmStandby               bool
 }
 
 // Options default value
@@ -42,6 +150,25 @@ func ProcessFlags() {
 		"Name of this endpoint.",
 	)
 
+	flag.StringVar(
+		&Options.ClusterIdentity,
+		"cluster-identity",
+		"",
+		"The identity of the managed cluster that annotates the events and aggregated objects, labels the "+
+			"metrics, and is in the external sink payloads, so that they remain attributable when they are "+
+			"exported off the cluster. It defaults to the --cluster-namespace, or to the watched namespace. In fan-in "+
+			"mode, it's the default of the managed clusters whose Secret has no cluster-identity key.",
+	)
+
+	flag.StringVar(
+		&Options.ClusterNameSource,
+		"cluster-name-source",
+		"",
+		"Discover the cluster name from the \"hub-kubeconfig\" client certificate or context namespace, or from "+
+			"the \"klusterlet\" resource. The discovered name is the default of --cluster-name and of the "+
+			"watched namespace if WATCH_NAMESPACE isn't set, and a mismatch with either is an error.",
+	)
+
 	flag.StringVar(
 		&Options.ClusterNamespace,
 		"cluster-namespace",
@@ -49,6 +176,14 @@ func ProcessFlags() {
 		"Cluster Namespace of this endpoint in hub.",
 	)
 
+	flag.StringVar(
+		&Options.EventComponent,
+		"event-component",
+		"",
+		"The source component of the events recorded by this controller, which identifies its own events. "+
+			"Set it when several status sync variants run side by side. The default is policy-status-sync.",
+	)
+
 	flag.StringVar(
 		&Options.HubConfigFilePathName,
 		"hub-cluster-configfile",
@@ -63,6 +198,57 @@ func ProcessFlags() {
 		"Configuration file pathname to managed kubernetes cluster",
 	)
 
+	flag.BoolVar(
+		&Options.ManagedCertRotation,
+		"managed-cert-rotation",
+		false,
+		"Handle the rotation of the client certificate of the managed cluster kubeconfig, such as an external "+
+			"MANAGED_CONFIG. The expiry of the certificate is monitored, and renewed certificate and key files "+
+			"are reloaded by the clients instead of restarting the container.",
+	)
+
+	flag.DurationVar(
+		&Options.ManagedCertExpiryWarning,
+		"managed-cert-expiry-warning",
+		72*time.Hour,
+		"How long before the client certificate of the managed cluster kubeconfig expires to warn that it "+
+			"hasn't been renewed, when --managed-cert-rotation is set",
+	)
+
+	flag.StringVar(
+		&Options.HostingConfigFilePathName,
+		"hosting-cluster-configfile",
+		Options.HostingConfigFilePathName,
+		"Configuration file pathname to the hosting kubernetes cluster in hosted mode. "+
+			"Defaults to the in-cluster configuration.",
+	)
+
+	flag.BoolVar(
+		&Options.Hosted,
+		"hosted",
+		false,
+		"If enabled, the controller runs on a hosting cluster separate from the managed cluster. "+
+			"Leader election and status reporting use the hosting cluster, while policies and events are "+
+			"read from the managed cluster configured with --managed-cluster-configfile.",
+	)
+
+	flag.StringVar(
+		&Options.FanInSecretSelector,
+		"fan-in-secret-selector",
+		"",
+		"Enables fan-in mode, where the status is synced for every managed cluster configured in a Secret "+
+			"matching this label selector on the hosting cluster. Each Secret must have a kubeconfig key and may "+
+			"have a cluster-name key, which defaults to the Secret name. The liveness probe fails once the "+
+			"Secrets change, so that the agent is restarted to sync the managed clusters of the new Secrets.",
+	)
+
+	flag.StringVar(
+		&Options.FanInSecretNamespace,
+		"fan-in-secret-namespace",
+		"",
+		"The namespace of the managed cluster Secrets in fan-in mode. Defaults to the controller namespace.",
+	)
+
 	flag.BoolVar(
 		&Options.EnableLease,
 		"enable-lease",
@@ -70,6 +256,22 @@ func ProcessFlags() {
 		"If enabled, the controller will start the lease controller to report its status",
 	)
 
+	flag.BoolVar(
+		&Options.SelfMonitor,
+		"self-monitor",
+		false,
+		"Observe the restarts and OOM kills of the agent pod and the availability of its deployment, and report "+
+			"the degraded self-health in the StatusSyncDegraded condition of the ManagedClusterAddOn on the hub "+
+			"and in metrics, when --enable-lease is set",
+	)
+
+	flag.StringVar(
+		&Options.AddonName,
+		"addon-name",
+		"governance-policy-framework",
+		"The name of the ManagedClusterAddOn of the agent on the hub that --self-monitor reports the condition on",
+	)
+
 	flag.BoolVar(
 		&Options.EnableLeaderElection,
 		"leader-elect",
@@ -78,6 +280,16 @@ func ProcessFlags() {
 			"Enabling this will ensure there is only one active controller manager.",
 	)
 
+	flag.BoolVar(
+		&Options.HubEventsAPI,
+		"hub-events-api",
+		false,
+		"Record the events on the hub with the events.k8s.io/v1 API instead of the core v1 events API, which "+
+			"counts the repeated occurrences of an event in its series. It requires the permission to create and "+
+			"patch events.k8s.io events on the hub. The core v1 events are still used when the hub doesn't serve "+
+			"the events.k8s.io/v1 API or doesn't grant the permission.",
+	)
+
 	flag.BoolVar(
 		&Options.LegacyLeaderElection,
 		"legacy-leader-elect",
@@ -85,18 +297,821 @@ func ProcessFlags() {
 		"Use a legacy leader election method for controller manager instead of the lease API.",
 	)
 
+	flag.BoolVar(
+		&Options.LocalCluster,
+		"local-cluster",
+		false,
+		"Indicates that the managed cluster is the hub itself (self-managed hub). A single client is used "+
+			"for both, and the hub status is not updated separately. This is also enabled when "+
+			"ON_MULTICLUSTERHUB=true is set.",
+	)
+
+	flag.IntVar(
+		&Options.LogBudget,
+		"log-budget",
+		30,
+		"The number of info logs of the reconciles of each policy per minute, after which they are logged at "+
+			"the debug level until the next minute, so that busy clusters don't drown the log aggregation. Pass "+
+			"--zap-log-level=debug to log them all. Set it to 0 to not limit the info logs.",
+	)
+
+	flag.BoolVar(
+		&Options.Once,
+		"once",
+		false,
+		"Sync the status of every policy in the watched namespaces a single time and exit. "+
+			"The exit code is non-zero if any policy failed to sync.",
+	)
+
+	flag.BoolVar(
+		&Options.WarmStandby,
+		"warm-standby",
+		false,
+		"If enabled, the managed cluster cache and a read-only cache of the hub policies are synced while "+
+			"waiting to become the leader, so that a new leader produces correct writes shortly after taking over.",
+	)
+
+	flag.IntVar(
+		&Options.MaxHubPolicyBytes,
+		"max-hub-policy-bytes",
+		1024*1024,
+		"The maximum size in bytes of the JSON of a hub policy, over which the oldest history entries are "+
+			"removed from its status so that the write fits in the hub size limits. Set it to 0 to only trim the "+
+			"history when the hub rejects a policy as too large.",
+	)
+
+	flag.Float64Var(
+		&Options.MemoryLimitRatio,
+		"memory-limit-ratio",
+		0,
+		"The ratio of the detected container memory limit to use as the Go soft memory limit, such as 0.9. "+
+			"The default of 0 doesn't set a limit. This is ignored if the GOMEMLIMIT environment variable is set.",
+	)
+
+	flag.IntVar(
+		&Options.GCPercent,
+		"gc-percent",
+		0,
+		"The garbage collection target percentage, like GOGC. Set to -1 to only collect garbage when "+
+			"approaching the memory limit. The default of 0 leaves the Go runtime setting unchanged.",
+	)
+
+	flag.BoolVar(
+		&Options.CachePolicyEventsOnly,
+		"cache-policy-events-only",
+		false,
+		"Only cache events on policies instead of all events in the watched namespace to reduce memory usage. "+
+			"This is only supported when watching a single namespace, and is rejected otherwise.",
+	)
+
 	flag.StringVar(
 		&Options.ProbeAddr,
 		"health-probe-bind-address",
 		":8082",
 		"The address the probe endpoint binds to.",
 	)
+
+	flag.StringVar(
+		&Options.ProbeCertFile,
+		"health-probe-cert-file",
+		"",
+		"The TLS certificate file to serve the probe endpoint over HTTPS. It requires --health-probe-key-file.",
+	)
+
+	flag.StringVar(
+		&Options.ProbeKeyFile,
+		"health-probe-key-file",
+		"",
+		"The TLS private key file to serve the probe endpoint over HTTPS. It requires --health-probe-cert-file.",
+	)
+
+	flag.BoolVar(
+		&Options.ProbeLocalhostOnly,
+		"health-probe-localhost-only",
+		false,
+		"Bind the probe endpoint to localhost only, regardless of the host in --health-probe-bind-address.",
+	)
+
+	flag.BoolVar(
+		&Options.EnableComplianceAPI,
+		"enable-compliance-api",
+		false,
+		"If enabled, a read-only API of the compliance of the policies by the namespaces that they affect is "+
+			"served at /compliance on the webhook server. The requesters authenticate with a bearer token and "+
+			"only see the namespaces where they may get policycompliances in the policy.open-cluster-management.io "+
+			"group.",
+	)
+
+	flag.BoolVar(
+		&Options.EnableStatusAPI,
+		"enable-status-api",
+		false,
+		"If enabled, an API is served at /policy-status on the webhook server to read a live snapshot of the "+
+			"status of a policy and to request its immediate sync to the hub, such as for the refresh buttons of "+
+			"consoles. The requesters authenticate with a bearer token of the managed cluster.",
+	)
+
+	flag.BoolVar(
+		&Options.EnableSupportBundle,
+		"enable-support-bundle",
+		false,
+		"If enabled, a support bundle is served at /debug/support-bundle on the health probe server as a "+
+			"gzipped tarball of the configuration, recent logs, queue state, per-policy sync status, and hub "+
+			"connectivity checks of the agent, to attach to support cases. The health probe server isn't "+
+			"authenticated, so the bundle is disabled by default.",
+	)
+
+	flag.BoolVar(
+		&Options.EnableStatusWebhook,
+		"enable-status-webhook",
+		false,
+		"If enabled, a validating webhook server is started that rejects updates to the status of replicated "+
+			"policies by anyone other than the service accounts in the controller namespace and the allowed users. "+
+			"A ValidatingWebhookConfiguration for the policies/status subresource must be created separately.",
+	)
+
+	flag.StringSliceVar(
+		&Options.StatusWebhookAllowedUsers,
+		"status-webhook-allowed-users",
+		[]string{},
+		"Additional usernames that are allowed to update the status of replicated policies.",
+	)
+
+	flag.StringSliceVar(
+		&Options.RootPolicyLabels,
+		"root-policy-labels",
+		[]string{},
+		"The labels that identify the root policy of a replicated policy in order of precedence, such as the "+
+			"ownership label of a higher-level hub in a hub-of-hubs topology. The first label set on the hub "+
+			"policy must match on the managed policy. Defaults to the policy.open-cluster-management.io/root-policy "+
+			"label.",
+	)
+
+	flag.BoolVar(
+		&Options.HubDryRun,
+		"hub-dry-run",
+		false,
+		"Validate each policy status write to the hub with a server-side dry run first, which logs the status "+
+			"fields that the hub Policy CRD schema rejects or prunes, such as during an upgrade. This doubles the "+
+			"status write requests to the hub.",
+	)
+
+	flag.StringVar(
+		&Options.HubNamespaceLabel,
+		"hub-namespace-label",
+		"",
+		"The label of the managed policies whose value is the namespace of the hub policy to sync the status to, "+
+			"such as an intermediate hub object. Policies without the label are synced to the same namespace.",
+	)
+
+	flag.BoolVar(
+		&Options.HubServerSideApply,
+		"hub-server-side-apply",
+		false,
+		"Write the policy status to the hub with server-side apply, so that this controller only owns the "+
+			"compliant and details status fields of the hub policy and doesn't conflict with the other writers of "+
+			"the policy. This requires a hub that supports server-side apply.",
+	)
+
+	flag.BoolVar(
+		&Options.HubStatusIntegrity,
+		"hub-status-integrity",
+		false,
+		"Annotate the hub status of each policy template with the hash of the synced status and the field "+
+			"manager of the agent, so that a modification of the hub status between the writes of the agent can be "+
+			"detected. The modifications are logged and counted when the policy is synced again.",
+	)
+
+	flag.BoolVar(
+		&Options.HubStatusPatch,
+		"hub-status-patch",
+		false,
+		"Write the policy status to the hub with a JSON patch of the changes, such as the new history entries, "+
+			"instead of an update of the whole status, which shrinks the requests for the policies with a long "+
+			"history. --hub-server-side-apply takes precedence.",
+	)
+
+	flag.StringVar(
+		&Options.HubUserAgent,
+		"hub-user-agent",
+		DefaultHubUserAgent,
+		"The User-Agent of the hub API requests, so that the hub audit logs attribute the requests to this agent. "+
+			"The {version}, {cluster}, and {pod} placeholders are replaced with the version of the agent, the "+
+			"cluster name, and the name of the agent pod. The managed fields of the hub status updates use the "+
+			"User-Agent up to the first slash as the manager.",
+	)
+
+	flag.StringVar(
+		&Options.HubFieldManager,
+		"hub-field-manager",
+		"",
+		"The field manager of the hub policy status writes, which supports the same placeholders as "+
+			"--hub-user-agent. When it's not set, the status updates use the manager from the User-Agent and "+
+			"the server-side apply uses governance-policy-status-sync. Changing it moves the ownership of the "+
+			"status fields, so a placeholder that changes often, such as {version} or {pod}, isn't recommended "+
+			"with --hub-server-side-apply.",
+	)
+
+	flag.IntVar(
+		&Options.HubAPIBudget,
+		"hub-api-budget",
+		0,
+		"The maximum number of hub API requests per minute of this agent, including its watches and events. "+
+			"A quarter of the budget is kept for the compliance state changes, and the history refreshes are "+
+			"deferred while only this reserve is left. They aren't limited by a budget if 0.",
+	)
+
+	flag.StringVar(
+		&Options.HubAPIBudgetLease,
+		"hub-api-budget-lease",
+		"",
+		"The name of the Lease in the cluster namespace of the managed cluster with the hub API budget shared "+
+			"with the other governance addons, which is created with the --hub-api-budget if it doesn't exist. "+
+			"This requires the --hub-api-budget, and the budget isn't shared if it's empty.",
+	)
+
+	flag.DurationVar(
+		&Options.HubConditionInterval,
+		"hub-communication-condition-interval",
+		0,
+		"The interval at which the time of the last successful hub API request of the agent is reported in the "+
+			"StatusSyncHubCommunication condition of its ManagedClusterAddOn on the hub, which isn't reported if "+
+			"0. The policy_status_sync_last_hub_communication_timestamp_seconds metric always has this time.",
+	)
+
+	flag.IntVar(
+		&Options.MaxWatchedPolicies,
+		"max-watched-policies",
+		0,
+		"The maximum number of policies that are synced on every change, so that a runaway policy generator on "+
+			"the managed cluster can't grow the memory of the agent without bounds. The policies over it are "+
+			"handled by --policy-overflow. It's unlimited if 0.",
+	)
+
+	flag.StringVar(
+		&Options.PolicyOverflow,
+		"policy-overflow",
+		"log",
+		"The behavior for the policies over --max-watched-policies, which is log to log the overflow and count "+
+			"the policies in the policy_status_sync_overflow_policies metric without syncing them, or periodic "+
+			"to also sync them at the --policy-overflow-interval instead of on every change.",
+	)
+
+	flag.DurationVar(
+		&Options.PolicyOverflowInterval,
+		"policy-overflow-interval",
+		10*time.Minute,
+		"The interval at which the policies over --max-watched-policies are listed and synced with "+
+			"--policy-overflow=periodic.",
+	)
+
+	flag.StringSliceVar(
+		&Options.ExcludePolicies,
+		"exclude-policies",
+		[]string{},
+		"The glob patterns of the replicated policies whose status isn't synced, such as canary or generated "+
+			"test policies. A pattern is namespace/name, or name to match the policies in any namespace, such "+
+			"as *.canary-*.",
+	)
+
+	flag.StringVar(
+		&Options.PolicyExclusionsConfigMap,
+		"policy-exclusions-configmap",
+		"",
+		"The name of a ConfigMap in the namespace of the agent whose exclude-policies key has more patterns of "+
+			"excluded policies at runtime, in the format of --exclude-policies. The ConfigMap is read every 10 "+
+			"seconds, and the policies that no longer match a pattern are synced again.",
+	)
+
+	flag.DurationVar(
+		&Options.HubConsistencyInterval,
+		"hub-consistency-interval",
+		0,
+		"The interval at which the hub status of the synced policies is read back and compared to their status "+
+			"on the managed cluster, such as to detect the status lost by a restore of the hub. The inconsistent "+
+			"policies are synced again. The policy_status_sync_hub_consistency metric has the ratio of the "+
+			"consistent policies of each namespace. It's disabled if 0.",
+	)
+
+	flag.StringSliceVar(
+		&Options.HubWriteNamespaces,
+		"hub-write-namespaces",
+		[]string{},
+		"The hub namespaces that the policy status and events may be written to, such as the namespaces of "+
+			"--hub-namespace-label. Writes to other namespaces are refused. Defaults to the cluster name, or else "+
+			"the namespaces in WATCH_NAMESPACE. In fan-in mode, these are allowed in addition to the namespace "+
+			"of each cluster.",
+	)
+
+	flag.IntVar(
+		&Options.WebhookPort,
+		"webhook-port",
+		9443,
+		"The port the webhook server serves at.",
+	)
+
+	flag.StringVar(
+		&Options.WebhookCertDir,
+		"webhook-cert-dir",
+		"",
+		"The directory that contains the webhook server key and certificate (tls.key and tls.crt).",
+	)
+
+	flag.StringVar(
+		&Options.MQTTBroker,
+		"mqtt-broker",
+		"",
+		"The URL of an MQTT broker to publish compliance transitions to, e.g. ssl://broker:8883. "+
+			"Publishing is disabled if this is empty.",
+	)
+
+	flag.StringVar(
+		&Options.MQTTTopicPrefix,
+		"mqtt-topic-prefix",
+		"policy-status",
+		"The prefix of the MQTT topic. Transitions are published to <prefix>/<cluster>/<namespace>/<policy>.",
+	)
+
+	flag.StringToStringVar(
+		&Options.MQTTChannelTopicPrefixes,
+		"mqtt-channel-topic-prefixes",
+		map[string]string{},
+		"The MQTT topic prefixes of the notification channels, such as payments-team=teams/payments, which "+
+			"replace --mqtt-topic-prefix for the policies with the notify.policy.open-cluster-management.io/channel "+
+			"annotation set to the channel.",
+	)
+
+	flag.StringVar(
+		&Options.MQTTClientID,
+		"mqtt-client-id",
+		"",
+		"The MQTT client ID. Defaults to policy-status-sync-<cluster>.",
+	)
+
+	flag.StringVar(
+		&Options.MQTTUsername,
+		"mqtt-username",
+		"",
+		"The username to authenticate to the MQTT broker with.",
+	)
+
+	flag.StringVar(
+		&Options.MQTTPasswordFile,
+		"mqtt-password-file",
+		"",
+		"The path to a file containing the password to authenticate to the MQTT broker with, which requires "+
+			"--mqtt-username.",
+	)
+
+	flag.StringVar(
+		&Options.MQTTAuthSecretDir,
+		"mqtt-auth-secret-dir",
+		"",
+		"The directory of a mounted Secret with the username and password keys to authenticate to the MQTT "+
+			"broker with, instead of --mqtt-username and --mqtt-password-file.",
+	)
+
+	flag.Uint8Var(
+		&Options.MQTTQoS,
+		"mqtt-qos",
+		1,
+		"The MQTT quality of service level to publish with, either 0 or 1.",
+	)
+
+	flag.StringVar(
+		&Options.MQTTCAFile,
+		"mqtt-ca-file",
+		"",
+		"The path to the CA bundle to verify the MQTT broker with when using TLS.",
+	)
+
+	flag.StringVar(
+		&Options.MQTTCertFile,
+		"mqtt-cert-file",
+		"",
+		"The path to the client certificate to authenticate to the MQTT broker with when using TLS.",
+	)
+
+	flag.StringVar(
+		&Options.MQTTKeyFile,
+		"mqtt-key-file",
+		"",
+		"The path to the client key to authenticate to the MQTT broker with when using TLS.",
+	)
+
+	flag.StringToStringVar(
+		&Options.SinkControls,
+		"sink-controls",
+		map[string]string{},
+		"The controls of each sink of the compliance transitions by name, which are on, off, or a rate limit "+
+			"such as 5/s or 30/m, for example mqtt=5/s,hub-events=on. The hub-events and managed-events sinks "+
+			"record the transitions as events and are off by default, and the external sinks are on.",
+	)
+
+	flag.StringVar(
+		&Options.SinkFilter,
+		"sink-filter",
+		"",
+		"A CEL expression that the compliance transitions must match to be sent to the external sinks, such "+
+			"as \"state == 'NonCompliant' && severity in ['high', 'critical']\". The variables are cluster, "+
+			"clusterIdentity, policyNamespace, policy, state, previousState, severity, and channel. By default, all "+
+			"transitions are sent.",
+	)
+
+	flag.StringVar(
+		&Options.SinkCompression,
+		"sink-compression",
+		"none",
+		"The compression of the payloads of the MQTT sink and of the compliance history API, which is none or "+
+			"gzip, to reduce the egress bandwidth of edge clusters on metered links.",
+	)
+
+	flag.IntVar(
+		&Options.SinkCompressionThreshold,
+		"sink-compression-threshold",
+		1024,
+		"The size in bytes from which the payloads of the external sinks are compressed with --sink-compression, "+
+			"since compressing small payloads doesn't save bandwidth.",
+	)
+
+	flag.DurationVar(
+		&Options.SinkDigestInterval,
+		"sink-digest-interval",
+		0,
+		"Send the compliance transitions to the external sinks in a digest at most once per interval, such as "+
+			"15m, with the last transition of each policy in the interval, instead of one message per transition. "+
+			"By default, each transition is sent.",
+	)
+
+	flag.StringArrayVar(
+		&Options.SinkQuietHours,
+		"sink-quiet-hours",
+		[]string{},
+		"A quiet hours window in which the compliance transitions aren't sent to the external sinks, which is a "+
+			"cron schedule of when the window starts followed by its duration, such as \"0 22 * * 5 56h\" for "+
+			"the weekends. It can be repeated for several windows. The hub status sync isn't affected.",
+	)
+
+	flag.StringVar(
+		&Options.SinkQuietHoursMode,
+		"sink-quiet-hours-mode",
+		"digest",
+		"Whether the compliance transitions during the --sink-quiet-hours are sent in a digest once the quiet "+
+			"hours end, or suppressed. It's either digest or suppress.",
+	)
+
+	flag.StringVar(
+		&Options.SinkQuietHoursTimezone,
+		"sink-quiet-hours-timezone",
+		"UTC",
+		"The time zone of the --sink-quiet-hours schedules, such as America/New_York.",
+	)
+
+	flag.BoolVar(
+		&Options.SpecDriftAudit,
+		"spec-drift-audit",
+		false,
+		"Compare the spec hash annotation of the compliance events with the one of the replicated policy, and "+
+			"mark the status of the policy templates whose latest event is for a previous spec as possibly "+
+			"stale, without a compliance state, until the template controller reports the current spec",
+	)
+
+	flag.BoolVar(
+		&Options.PolicySetMembership,
+		"policy-set-membership",
+		false,
+		"Annotate the status of the policy templates with the policy sets that the root policy belongs to, and "+
+			"aggregate the compliance of each policy set in a metric. This requires the permission to list the "+
+			"policy sets in the namespaces of the root policies on the hub.",
+	)
+
+	flag.BoolVar(
+		&Options.KeepHistoryOnHubRecreate,
+		"keep-history-on-hub-recreate",
+		false,
+		"Keep the compliance history of a policy when its hub policy is deleted and re-created with the same "+
+			"name, so that it carries forward to the new hub policy. By default, the status is reset.",
+	)
+
+	flag.StringVar(
+		&Options.KubeAPIContentType,
+		"kube-api-content-type",
+		ContentTypeProtobuf,
+		"The content type for Kubernetes API traffic, either protobuf or json. With protobuf, it is used for "+
+			"built-in types such as events where the API server supports it, and JSON is used otherwise.",
+	)
+
+	flag.BoolVar(
+		&Options.AllHubEvents,
+		"all-hub-events",
+		false,
+		"Record an event on the hub for every policy status update. By default, updates that keep a policy "+
+			"compliant don't record an event, to reduce the number of events stored on the hub.",
+	)
+
+	flag.StringVar(
+		&Options.HistoryMinSeverity,
+		"history-min-severity",
+		"",
+		"The minimum policy template severity (low, medium, high, or critical) for which compliance history is "+
+			"kept on the hub. Templates with a lower or no severity only sync their current compliance state. "+
+			"By default, the history of all templates is kept.",
+	)
+
+	flag.StringToStringVar(
+		&Options.ComplianceScoreWeights,
+		"compliance-score-weights",
+		map[string]string{},
+		"The weights of the policy template severities in the compliance score of the cluster, such as "+
+			"critical=10,low=0.5. The severities are low, medium, high, critical, and none for the templates "+
+			"without a severity, whose default weights are 1, 2, 4, 8, and 1.",
+	)
+
+	flag.BoolVar(
+		&Options.ComplianceScoreClaim,
+		"compliance-score-claim",
+		false,
+		"Publish the compliance score of the cluster in the "+
+			"compliancescore.policy.open-cluster-management.io ClusterClaim every minute.",
+	)
+
+	flag.DurationVar(
+		&Options.ComplianceTrendInterval,
+		"compliance-trend-interval",
+		0,
+		"The interval at which the number of policies in each compliance state is appended to the "+
+			"policy-status-sync-compliance-trend ConfigMap in the cluster namespace on the hub, such as 1h, for "+
+			"long-range compliance trend charts. It's disabled if 0.",
+	)
+
+	flag.DurationVar(
+		&Options.ComplianceTrendRetention,
+		"compliance-trend-retention",
+		30*24*time.Hour,
+		"The age of the oldest point of the compliance trend on the hub with --compliance-trend-interval.",
+	)
+
+	flag.IntVar(
+		&Options.HistorySummaryEntries,
+		"history-summary-entries",
+		0,
+		"The maximum number of summarized compliance history entries kept after the 10 most recent entries of "+
+			"each policy template. Older entries with the same compliance state are collapsed into a single "+
+			"entry, such as \"NonCompliant 14 times between X and Y\". By default, older entries are dropped.",
+	)
+
+	flag.IntVar(
+		&Options.EventCacheSize,
+		"event-cache-size",
+		10000,
+		"The maximum number of parsed events that are cached by UID, so that reconciles don't parse all the "+
+			"events in the namespace again. Set to 0 to disable the cache.",
+	)
+
+	flag.DurationVar(
+		&Options.EventLookback,
+		"event-lookback",
+		0,
+		"Ignore the compliance events older than this duration unless they are newer than the history recorded "+
+			"on the hub, so that days-old transitions aren't replayed at startup on clusters with a long event "+
+			"TTL. By default, all events are considered.",
+	)
+
+	flag.BoolVar(
+		&Options.MarkStoppedOnShutdown,
+		"mark-stopped-on-shutdown",
+		false,
+		"Set the policy.open-cluster-management.io/status-sync-stopped annotation on the templates of the hub "+
+			"status of every synced policy when the agent shuts down gracefully, such as when the cluster is "+
+			"detached, so that the hub users can tell that the status is no longer live. The next status write "+
+			"removes it.",
+	)
+
+	flag.DurationVar(
+		&Options.HealthSummaryInterval,
+		"health-summary-interval",
+		0,
+		"The interval at which the policy-status-sync-health Event in the namespace of the agent is updated with "+
+			"the queue depth, the hub connectivity, and the last sync error, or sooner when the agent becomes "+
+			"degraded or healthy, for the clusters without a metrics stack. It's disabled if 0.",
+	)
+
+	flag.DurationVar(
+		&Options.QueueStallTimeout,
+		"queue-stall-timeout",
+		15*time.Minute,
+		"Fail the liveness probe when the policy queue makes no progress for this long while policies are "+
+			"waiting, such as when a worker is wedged, so that the controller is restarted. 0 disables the check.",
+	)
+
+	flag.BoolVar(
+		&Options.StatusSyncHealth,
+		"status-sync-health",
+		false,
+		"Annotate the hub status of each policy template with whether the agent was healthy when it wrote the "+
+			"status, so that hub dashboards can tell a status that's possibly stale because the agent is degraded "+
+			"from a NonCompliant one. By default, the hub status isn't annotated.",
+	)
+
+	flag.DurationVar(
+		&Options.StatusHeartbeatInterval,
+		"status-heartbeat-interval",
+		0,
+		"Refresh the status sync heartbeat annotation in the hub status of each policy at this interval, so "+
+			"that hub dashboards can tell a stale status from an agent that stopped syncing. This writes the "+
+			"status of every policy to the hub once per interval. By default, the heartbeat is disabled.",
+	)
+
+	flag.StringVar(
+		&Options.StatusTransport,
+		"status-transport",
+		StatusTransportHub,
+		"How the status is reported to the hub: \"hub\" writes the hub policies, and \"manifestwork\" only "+
+			"writes the hub status to the replicated policies, which the work agent reports to the hub with the "+
+			"status feedback rules of the ManifestWork that deploys them, without a hub kubeconfig.",
+	)
+
+	flag.DurationVar(
+		&Options.TemplateStaleThreshold,
+		"template-stale-threshold",
+		0,
+		"Mark the status of a policy template as stale, with the time of its latest compliance event, when no "+
+			"event arrived for longer than this threshold, such as when its template controller is down. It "+
+			"must be longer than the evaluation interval of the templates. By default, it's disabled.",
+	)
+
+	flag.StringVar(
+		&Options.UnknownCompliance,
+		"unknown-compliance",
+		"unknown",
+		"The treatment of the policy template events whose message doesn't start with a known compliance state, "+
+			"such as a misspelled state from a third-party controller. It's unknown to clear the compliance state "+
+			"of the template and set its template-status-error annotation, noncompliant to treat it as "+
+			"NonCompliant, or drop to ignore these events. They are counted in the "+
+			"policy_status_sync_unknown_compliance_states_total metric.",
+	)
+
+	flag.DurationVar(
+		&Options.StartupRetryTimeout,
+		"startup-retry-timeout",
+		5*time.Minute,
+		"How long to retry transient startup failures, such as a kubeconfig that isn't mounted yet or an "+
+			"unreachable API server, before exiting. Set to 0 to exit on the first failure.",
+	)
+
+	flag.StringVar(
+		&Options.ComplianceHistoryAPIURL,
+		"compliance-history-api-url",
+		"",
+		"The URL of the compliance events endpoint of the compliance history API on the hub. When set, the "+
+			"compliance history entries synced to the hub are also sent to this API.",
+	)
+
+	flag.StringVar(
+		&Options.ComplianceHistoryToken,
+		"compliance-history-api-token-file",
+		"",
+		"The path to a file containing the bearer token for the compliance history API.",
+	)
+
+	flag.StringVar(
+		&Options.ComplianceHistoryAuth,
+		"compliance-history-api-auth",
+		"",
+		"The authentication of the compliance history API instead of --compliance-history-api-token-file, "+
+			"which is token, basic, oauth2 for the OAuth2 client credentials grant, or sigv4 for the AWS "+
+			"Signature Version 4. The credentials are read from --compliance-history-api-auth-secret-dir.",
+	)
+
+	flag.StringVar(
+		&Options.ComplianceHistoryAuthDir,
+		"compliance-history-api-auth-secret-dir",
+		"",
+		"The directory of a mounted Secret with the credentials of --compliance-history-api-auth, which are the "+
+			"token key for token, the username and password keys for basic, the client-id, client-secret, "+
+			"token-url, and optional scopes keys for oauth2, and the access-key-id, secret-access-key, region, "+
+			"and optional session-token and service keys for sigv4.",
+	)
+
+	flag.StringVar(
+		&Options.ComplianceHistoryCAFile,
+		"compliance-history-api-ca-file",
+		"",
+		"The path to the CA bundle to verify the compliance history API certificate.",
+	)
+
+	flag.IntVar(
+		&Options.ComplianceHistoryBatch,
+		"compliance-history-api-batch-size",
+		20,
+		"The maximum number of compliance events sent to the compliance history API in a single request.",
+	)
+
+	flag.BoolVar(
+		&Options.HubWriteAudit,
+		"hub-write-audit",
+		false,
+		"Record an event on the hub for every policy status write, annotated with the pod name, version, and "+
+			"reconcile ID of the agent that wrote the status, for the forensic analysis of unexpected status "+
+			"changes on shared hubs.",
+	)
+
+	flag.DurationVar(
+		&Options.HubWriteCoalescingWindow,
+		"hub-write-coalescing-window",
+		0,
+		"Delay the sync of a policy after a policy template event by this duration, so that the events of its "+
+			"other templates in the window are synced to the hub in a single status write. By default, each "+
+			"event is synced on its own.",
+	)
+
+	flag.BoolVar(
+		&Options.ComplianceHistoryOnly,
+		"compliance-history-api-only",
+		false,
+		"Don't record events on the hub for policy status updates since the compliance history API is used "+
+			"instead. This requires --compliance-history-api-url.",
+	)
+
+	flag.StringVar(
+		&Options.NamespaceSelector,
+		"namespace-selector",
+		"",
+		"A label selector of additional namespaces to watch for policies. Matching namespaces are watched as "+
+			"they are created or labeled, in addition to the namespaces in WATCH_NAMESPACE.",
+	)
+
+	flag.StringVar(
+		&Options.MetricsAddr,
+		"metrics-bind-address",
+		"0",
+		"The address the metrics endpoint binds to. The default of 0 disables the metrics endpoint.",
+	)
+
+	flag.StringToStringVar(
+		&Options.LogLevels,
+		"log-level",
+		map[string]string{},
+		"The log levels of the subsystems that override the --zap-log-level, such as "+
+			"sync=debug,hubclient=info. The levels are error, info, debug, or a verbosity. The subsystems are "+
+			"sync, webhook, hubclient, sinks, nscache, cmd, setup, and controller-runtime.",
+	)
+
+	flag.StringVar(
+		&Options.LogLevelConfigMap,
+		"log-level-configmap",
+		"",
+		"The name of a ConfigMap in the namespace of the agent whose log-level key overrides the log levels at "+
+			"runtime, in the format of --log-level, such as sync=debug or default=debug for all the subsystems. "+
+			"The ConfigMap is read every 10 seconds, and the levels of the flags are restored when the key is "+
+			"removed.",
+	)
+
+	flag.BoolVar(
+		&Options.FakeHub,
+		"fake-hub",
+		false,
+		"Write the hub status to an in-memory hub instead of a hub cluster, for local development. The hub "+
+			"policies are seeded from the managed policies, and the hub writes are dumped as JSON lines.",
+	)
+
+	flag.StringVar(
+		&Options.FakeHubDumpFile,
+		"fake-hub-dump-file",
+		"",
+		"The file that the hub writes are appended to with --fake-hub. By default, they are written to stdout.",
+	)
+
+	flag.StringToStringVar(
+		&Options.FeatureGates,
+		"feature-gates",
+		map[string]string{},
+		"A set of key=value pairs that enable or disable features that are only meant for testing, such as "+
+			FeatureFaultInjection+"=true.",
+	)
+
+	// the fault injection flags are hidden since they are only meant for resilience testing
+	flag.DurationVar(
+		&Options.HubWriteFaultLatency,
+		"hub-write-fault-latency",
+		0,
+		"The latency injected in every hub write. This requires --feature-gates="+FeatureFaultInjection+"=true.",
+	)
+
+	flag.Float64Var(
+		&Options.HubWriteFaultErrorRate,
+		"hub-write-fault-error-rate",
+		0,
+		"The rate between 0 and 1 of the hub writes that fail with an injected error. This requires "+
+			"--feature-gates="+FeatureFaultInjection+"=true.",
+	)
+
+	_ = flag.MarkHidden("hub-write-fault-latency")
+	_ = flag.MarkHidden("hub-write-fault-error-rate")
 }
 
 // CreateClusterNs creates the cluster namespace on managed cluster if not exists
 func CreateClusterNs(client *kubernetes.Interface, nsName string) error {
-	const clusterLabel = "policy.open-cluster-management.io/isClusterNamespace"
-
 	nameSpace, err := (*client).CoreV1().Namespaces().Get(context.TODO(), nsName, metav1.GetOptions{})
 
 	log.Info("Checking if cluster namespace exist.", "Namespace", nsName)
@@ -109,7 +1124,7 @@ func CreateClusterNs(client *kubernetes.Interface, nsName string) error {
 			_, err := (*client).CoreV1().Namespaces().Create(context.TODO(), &corev1.Namespace{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:   nsName,
-					Labels: map[string]string{clusterLabel: "true"},
+					Labels: map[string]string{ClusterNamespaceLabel: "true"},
 				},
 			}, metav1.CreateOptions{})
 
@@ -126,10 +1141,10 @@ func CreateClusterNs(client *kubernetes.Interface, nsName string) error {
 		labels = make(map[string]string)
 	}
 
-	if _, ok := labels[clusterLabel]; !ok {
+	if _, ok := labels[ClusterNamespaceLabel]; !ok {
 		log.Info("Label doesn't exist, patching it...", "Namespace", nsName)
 
-		labels[clusterLabel] = "true"
+		labels[ClusterNamespaceLabel] = "true"
 		nameSpace.SetLabels(labels)
 		_, err = (*client).CoreV1().Namespaces().Update(context.TODO(), nameSpace, metav1.UpdateOptions{})
 
@@ -0,0 +1,352 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// permissionRecheckInterval is the minimum time between permission checks triggered by the readiness probe
+// while permissions are missing.
+const permissionRecheckInterval = time.Minute
+
+// PermissionCheckTimeout bounds a permission check, so that an unreachable cluster doesn't block the startup
+const PermissionCheckTimeout = 30 * time.Second
+
+const policyGroup = "policy.open-cluster-management.io"
+
+// RequiredPermission is an API permission the controller needs on a cluster
+type RequiredPermission struct {
+	Group       string
+	Resource    string
+	Subresource string
+	Verb        string
+	Namespace   string
+	// Optional is set if the permission is only needed by a feature that degrades without it, such as the
+	// events recorded on the hub. A missing optional permission is logged without failing the readiness check.
+	Optional bool
+}
+
+// resourceName returns the resource in the form of resource/subresource.group
+func (p RequiredPermission) resourceName() string {
+	resource := p.Resource
+	if p.Subresource != "" {
+		resource += "/" + p.Subresource
+	}
+
+	if p.Group != "" {
+		resource += "." + p.Group
+	}
+
+	return resource
+}
+
+func (p RequiredPermission) String() string {
+	return p.Verb + " " + p.resourceName()
+}
+
+// permissionsFor generates a RequiredPermission for each of the verbs
+func permissionsFor(group, resource, subresource, namespace string, verbs ...string) []RequiredPermission {
+	perms := make([]RequiredPermission, 0, len(verbs))
+
+	for _, verb := range verbs {
+		perms = append(perms, RequiredPermission{
+			Group:       group,
+			Resource:    resource,
+			Subresource: subresource,
+			Verb:        verb,
+			Namespace:   namespace,
+		})
+	}
+
+	return perms
+}
+
+// optional marks the permissions as optional
+func optional(perms []RequiredPermission) []RequiredPermission {
+	for i := range perms {
+		perms[i].Optional = true
+	}
+
+	return perms
+}
+
+// HubPermissions returns the permissions the controller needs on the hub in each cluster namespace
+func HubPermissions(namespaces []string) []RequiredPermission {
+	perms := []RequiredPermission{}
+
+	for _, ns := range namespaces {
+		if Options.WarmStandby {
+			perms = append(perms, permissionsFor(policyGroup, "policies", "", ns, "get", "list", "watch")...)
+		} else {
+			perms = append(perms, permissionsFor(policyGroup, "policies", "", ns, "get")...)
+		}
+
+		perms = append(perms, permissionsFor(policyGroup, "policies", "status", ns, "update")...)
+
+		// the hub status is written with a patch instead of an update in the JSON patch and server-side apply modes
+		if Options.HubStatusPatch || Options.HubServerSideApply {
+			perms = append(perms, permissionsFor(policyGroup, "policies", "status", ns, "patch")...)
+		}
+
+		// the status is synced without the hub events, and the other hub features degrade without their
+		// permissions
+		perms = append(perms, optional(permissionsFor("", "events", "", ns, "create", "patch"))...)
+
+		// the hub events are recorded with the events.k8s.io/v1 API when it's enabled and permitted
+		if Options.HubEventsAPI {
+			perms = append(perms, optional(permissionsFor("events.k8s.io", "events", "", ns, "create", "patch"))...)
+		}
+
+		if Options.EnableLease {
+			perms = append(perms, optional(permissionsFor("coordination.k8s.io", "leases", "", ns, "get", "update"))...)
+		}
+
+		if Options.ComplianceTrendInterval > 0 {
+			perms = append(perms, optional(permissionsFor("", "configmaps", "", ns, "get", "create", "update"))...)
+		}
+	}
+
+	return perms
+}
+
+// ManagedPermissions returns the permissions the controller needs on the managed cluster in each cluster
+// namespace
+func ManagedPermissions(namespaces []string) []RequiredPermission {
+	perms := permissionsFor("", "namespaces", "", "", "get", "create", "update")
+
+	if Options.NamespaceSelector != "" {
+		perms = append(perms, permissionsFor("", "namespaces", "", "", "list", "watch")...)
+	}
+
+	for _, ns := range namespaces {
+		perms = append(perms, permissionsFor(
+			policyGroup, "policies", "", ns, "get", "list", "watch", "create", "update", "delete",
+		)...)
+		perms = append(perms, permissionsFor(policyGroup, "policies", "status", ns, "update")...)
+		perms = append(perms, permissionsFor("", "events", "", ns, "list", "watch")...)
+		perms = append(perms, optional(permissionsFor("", "events", "", ns, "create", "patch"))...)
+		// the hub sync state of the policies is kept in a ConfigMap for each policy
+		perms = append(perms, permissionsFor("", "configmaps", "", ns, "get", "create", "patch")...)
+
+		// the hub API budget isn't shared with the other addons without the lease
+		if Options.HubAPIBudgetLease != "" {
+			perms = append(perms, optional(
+				permissionsFor("coordination.k8s.io", "leases", "", ns, "get", "create", "update"),
+			)...)
+		}
+	}
+
+	return perms
+}
+
+type clusterPermissions struct {
+	cluster string
+	client  kubernetes.Interface
+	perms   []RequiredPermission
+}
+
+// PermissionChecker verifies through SelfSubjectAccessReviews that the controller has the permissions it
+// needs on each cluster, so that RBAC problems are reported at startup instead of as reconcile errors.
+type PermissionChecker struct {
+	clusters  []clusterPermissions
+	lock      sync.RWMutex
+	missing   map[string][]RequiredPermission
+	lastCheck time.Time
+	// err is the error of the last check if the access reviews could not be performed
+	err error
+}
+
+// NewPermissionChecker returns an empty PermissionChecker
+func NewPermissionChecker() *PermissionChecker {
+	return &PermissionChecker{}
+}
+
+// AddCluster registers the permissions to check on the cluster the client connects to
+func (c *PermissionChecker) AddCluster(cluster string, client kubernetes.Interface, perms []RequiredPermission) {
+	c.clusters = append(c.clusters, clusterPermissions{cluster: cluster, client: client, perms: perms})
+}
+
+// Run checks all registered permissions, logs a table of the missing required and optional ones, and returns
+// the missing ones by cluster. An error is only returned if the access reviews themselves could not be
+// performed, and is reported by the StartupCheck until a later check succeeds.
+func (c *PermissionChecker) Run(ctx context.Context) (map[string][]RequiredPermission, error) {
+	missing, err := c.review(ctx)
+
+	c.lock.Lock()
+	c.lastCheck = time.Now()
+	c.err = err
+
+	if err == nil {
+		c.missing = missing
+	}
+
+	c.lock.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	missingRequired, missingOptional := splitOptional(missing)
+
+	if len(missingRequired) == 0 {
+		log.Info("All required permissions are granted")
+	} else {
+		log.Info("The controller is missing required permissions. Update its RBAC to grant the following:\n" +
+			formatPermissions(missingRequired))
+	}
+
+	if len(missingOptional) != 0 {
+		log.Info("The controller is missing optional permissions, so the features that need them are degraded. " +
+			"Update its RBAC to grant the following to enable them:\n" + formatPermissions(missingOptional))
+	}
+
+	return missing, nil
+}
+
+// splitOptional splits the permissions by cluster into the required and the optional ones
+func splitOptional(
+	perms map[string][]RequiredPermission,
+) (map[string][]RequiredPermission, map[string][]RequiredPermission) {
+	requiredPerms := map[string][]RequiredPermission{}
+	optionalPerms := map[string][]RequiredPermission{}
+
+	for cluster, clusterPerms := range perms {
+		for _, perm := range clusterPerms {
+			if perm.Optional {
+				optionalPerms[cluster] = append(optionalPerms[cluster], perm)
+			} else {
+				requiredPerms[cluster] = append(requiredPerms[cluster], perm)
+			}
+		}
+	}
+
+	return requiredPerms, optionalPerms
+}
+
+// review returns the missing permissions by cluster
+func (c *PermissionChecker) review(ctx context.Context) (map[string][]RequiredPermission, error) {
+	missing := map[string][]RequiredPermission{}
+
+	for _, cluster := range c.clusters {
+		for _, perm := range cluster.perms {
+			review := &authorizationv1.SelfSubjectAccessReview{
+				Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+					ResourceAttributes: &authorizationv1.ResourceAttributes{
+						Namespace:   perm.Namespace,
+						Verb:        perm.Verb,
+						Group:       perm.Group,
+						Resource:    perm.Resource,
+						Subresource: perm.Subresource,
+					},
+				},
+			}
+
+			result, err := cluster.client.AuthorizationV1().SelfSubjectAccessReviews().Create(
+				ctx, review, metav1.CreateOptions{},
+			)
+			if err != nil {
+				return nil, fmt.Errorf(
+					"failed to review the %s permission on the %s cluster: %w", perm, cluster.cluster, err,
+				)
+			}
+
+			if !result.Status.Allowed {
+				missing[cluster.cluster] = append(missing[cluster.cluster], perm)
+			}
+		}
+	}
+
+	return missing, nil
+}
+
+// StartupCheck is a startup check that fails while the permissions could not be checked, such as when a
+// cluster is unreachable. While failing, the permissions are checked again at most once per
+// permissionRecheckInterval. Missing permissions are reported by the readiness Check instead.
+func (c *PermissionChecker) StartupCheck(req *http.Request) error {
+	c.lock.RLock()
+	err := c.err
+	lastCheck := c.lastCheck
+	c.lock.RUnlock()
+
+	if err != nil && time.Since(lastCheck) > permissionRecheckInterval {
+		ctx, cancel := context.WithTimeout(req.Context(), PermissionCheckTimeout)
+		defer cancel()
+
+		_, err = c.Run(ctx)
+	}
+
+	if err != nil {
+		return fmt.Errorf("the permissions could not be checked: %w", err)
+	}
+
+	return nil
+}
+
+// Check is a readiness check that fails while any required permission is missing. The missing optional
+// permissions are only logged. While failing, the permissions are checked again at most once per
+// permissionRecheckInterval so that fixing the RBAC makes the controller ready without a restart.
+func (c *PermissionChecker) Check(req *http.Request) error {
+	c.lock.RLock()
+	missing, _ := splitOptional(c.missing)
+	lastCheck := c.lastCheck
+	c.lock.RUnlock()
+
+	if len(missing) != 0 && time.Since(lastCheck) > permissionRecheckInterval {
+		ctx, cancel := context.WithTimeout(req.Context(), PermissionCheckTimeout)
+		defer cancel()
+
+		rechecked, err := c.Run(ctx)
+		if err != nil {
+			return err
+		}
+
+		missing, _ = splitOptional(rechecked)
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	summary := []string{}
+
+	for cluster, perms := range missing {
+		summary = append(summary, fmt.Sprintf("%d on the %s cluster", len(perms), cluster))
+	}
+
+	return fmt.Errorf("missing required permissions: %s", strings.Join(summary, ", "))
+}
+
+// formatPermissions formats the missing permissions as a table
+func formatPermissions(missing map[string][]RequiredPermission) string {
+	var buf bytes.Buffer
+
+	writer := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "CLUSTER\tNAMESPACE\tVERB\tRESOURCE")
+
+	for cluster, perms := range missing {
+		for _, perm := range perms {
+			namespace := perm.Namespace
+			if namespace == "" {
+				namespace = "(cluster-scoped)"
+			}
+
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", cluster, namespace, perm.Verb, perm.resourceName())
+		}
+	}
+
+	_ = writer.Flush()
+
+	return buf.String()
+}
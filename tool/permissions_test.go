@@ -0,0 +1,159 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestHubPermissionsStatusPatch(t *testing.T) {
+	// not parallel since the permissions depend on the global options
+	original := Options
+	defer func() { Options = original }()
+
+	tests := map[string]struct {
+		patch           bool
+		serverSideApply bool
+		expected        bool
+	}{
+		"update":            {false, false, false},
+		"JSON patch":        {true, false, true},
+		"server-side apply": {false, true, true},
+	}
+
+	for name, test := range tests {
+		Options.HubStatusPatch = test.patch
+		Options.HubServerSideApply = test.serverSideApply
+
+		found := false
+
+		for _, perm := range HubPermissions([]string{"cluster1"}) {
+			if perm.Resource == "policies" && perm.Subresource == "status" && perm.Verb == "patch" {
+				found = true
+			}
+		}
+
+		if found != test.expected {
+			t.Fatalf("%s: expected the patch permission of the policy status to be required: %v", name, test.expected)
+		}
+	}
+}
+
+func TestHubPermissionsEventsAPI(t *testing.T) {
+	// not parallel since the permissions depend on the global options
+	original := Options
+	defer func() { Options = original }()
+
+	for _, eventsAPI := range []bool{false, true} {
+		Options.HubEventsAPI = eventsAPI
+
+		found := false
+
+		for _, perm := range HubPermissions([]string{"cluster1"}) {
+			if perm.Group == "events.k8s.io" {
+				found = true
+			}
+		}
+
+		if found != eventsAPI {
+			t.Fatalf("expected the events.k8s.io permissions to be required: %v", eventsAPI)
+		}
+	}
+}
+
+// reviewingClient returns a fake client whose SelfSubjectAccessReviews deny the resources
+func reviewingClient(denied map[string]bool) *fake.Clientset {
+	client := fake.NewSimpleClientset()
+
+	client.PrependReactor("create", "selfsubjectaccessreviews",
+		func(action clienttesting.Action) (bool, runtime.Object, error) {
+			review := action.(clienttesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+			review.Status.Allowed = !denied[review.Spec.ResourceAttributes.Resource]
+
+			return true, review, nil
+		},
+	)
+
+	return client
+}
+
+func TestPermissionCheckerOptional(t *testing.T) {
+	t.Parallel()
+
+	perms := append(
+		permissionsFor(policyGroup, "policies", "", "cluster1", "get"),
+		optional(permissionsFor("coordination.k8s.io", "leases", "", "cluster1", "get"))...,
+	)
+
+	tests := map[string]struct {
+		denied map[string]bool
+		ready  bool
+	}{
+		"all granted":      {map[string]bool{}, true},
+		"optional missing": {map[string]bool{"leases": true}, true},
+		"required missing": {map[string]bool{"policies": true}, false},
+	}
+
+	for name, test := range tests {
+		checker := NewPermissionChecker()
+		checker.AddCluster("hub", reviewingClient(test.denied), perms)
+
+		missing, err := checker.Run(context.TODO())
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if len(missing["hub"]) != len(test.denied) {
+			t.Fatalf("%s: expected the missing permissions to be reported, got %v", name, missing)
+		}
+
+		req, _ := http.NewRequestWithContext(context.TODO(), http.MethodGet, "/readyz", nil)
+
+		if err := checker.Check(req); (err == nil) != test.ready {
+			t.Fatalf("%s: expected the readiness check to pass: %v, got %v", name, test.ready, err)
+		}
+	}
+}
+
+func TestPermissionCheckerRecheck(t *testing.T) {
+	t.Parallel()
+
+	denied := map[string]bool{"policies": true}
+	client := reviewingClient(denied)
+
+	checker := NewPermissionChecker()
+	checker.AddCluster("hub", client, permissionsFor(policyGroup, "policies", "", "cluster1", "get"))
+
+	if _, err := checker.Run(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequestWithContext(context.TODO(), http.MethodGet, "/readyz", nil)
+
+	if err := checker.Check(req); err == nil {
+		t.Fatal("expected the readiness check to fail while the permission is missing")
+	}
+
+	// the RBAC is fixed, which is rechecked after the recheck interval
+	delete(denied, "policies")
+
+	if err := checker.Check(req); err == nil {
+		t.Fatal("expected the permissions to not be rechecked before the recheck interval")
+	}
+
+	checker.lock.Lock()
+	checker.lastCheck = time.Now().Add(-2 * permissionRecheckInterval)
+	checker.lock.Unlock()
+
+	if err := checker.Check(req); err != nil {
+		t.Fatalf("expected the readiness check to pass once the permission is granted, got %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"context"
+	"hash/fnv"
+	"os"
+	"time"
+)
+
+const (
+	// defaultResyncPeriod is the controller-runtime default for the period of the cache resyncs
+	defaultResyncPeriod = 10 * time.Hour
+	// resyncSpread is the range of the phase offset added to the resync period
+	resyncSpread = time.Hour
+)
+
+// PhaseOffset returns a deterministic offset in [0, period) from the hash of the key, such as the cluster
+// name. Applying it to periodic timers keeps a fleet of agents that restarted together, such as after a
+// fleet-wide rollout, from writing to the hub on the same schedule. An empty key uses the pod name.
+func PhaseOffset(key string, period time.Duration) time.Duration {
+	if period <= 0 {
+		return 0
+	}
+
+	if key == "" {
+		key = os.Getenv("HOSTNAME")
+	}
+
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(key))
+
+	return time.Duration(hash.Sum64() % uint64(period))
+}
+
+// WaitForPhase waits for the phase offset of the key within the period. It returns false if the context
+// is done first.
+func WaitForPhase(ctx context.Context, key string, period time.Duration) bool {
+	timer := time.NewTimer(PhaseOffset(key, period))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// ResyncPeriod returns the period of the cache resyncs, which is the controller-runtime default plus the
+// phase offset of the key within an hour
+func ResyncPeriod(key string) time.Duration {
+	return defaultResyncPeriod + PhaseOffset(key, resyncSpread)
+}
@@ -0,0 +1,204 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// PolicyExclusionsConfigMapKey is the key of the policy exclusions ConfigMap with the patterns of the
+	// excluded policies, in the format of --exclude-policies
+	PolicyExclusionsConfigMapKey = "exclude-policies"
+	// policyExclusionsWatchInterval is the interval at which the policy exclusions ConfigMap is read
+	policyExclusionsWatchInterval = 10 * time.Second
+)
+
+// PolicyExclusions are the glob patterns of the policies whose status isn't synced, such as canary or generated
+// test policies. A pattern is namespace/name to match the namespace and the name of the replicated policy, or
+// name to match the name in any namespace, in the syntax of path.Match. The patterns of the flag are always
+// excluded, and the patterns of the ConfigMap are updated at runtime by the PolicyExclusionWatcher.
+type PolicyExclusions struct {
+	lock sync.RWMutex
+	// flagPatterns are the patterns of --exclude-policies
+	flagPatterns []string
+	// patterns are the flagPatterns and the patterns of the ConfigMap
+	patterns []string
+	// onChange are called when the patterns change
+	onChange []func()
+}
+
+// NewPolicyExclusions returns the PolicyExclusions of the --exclude-policies flag, or nil if no policy is
+// excluded by the flag or the --policy-exclusions-configmap
+func NewPolicyExclusions() (*PolicyExclusions, error) {
+	if len(Options.ExcludePolicies) == 0 && Options.PolicyExclusionsConfigMap == "" {
+		return nil, nil
+	}
+
+	flagPatterns, err := parsePolicyExclusions(strings.Join(Options.ExcludePolicies, ","))
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info("Excluding the policies that match the policy exclusions from the status sync",
+		"exclusions", strings.Join(flagPatterns, ","))
+
+	return &PolicyExclusions{flagPatterns: flagPatterns, patterns: flagPatterns}, nil
+}
+
+// parsePolicyExclusions parses and validates the comma-separated patterns
+func parsePolicyExclusions(value string) ([]string, error) {
+	patterns := []string{}
+
+	for _, pattern := range strings.Split(value, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+
+		if strings.Count(pattern, "/") > 1 {
+			return nil, fmt.Errorf("invalid policy exclusion %q, it must be namespace/name or name", pattern)
+		}
+
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid policy exclusion %q: %w", pattern, err)
+		}
+
+		patterns = append(patterns, pattern)
+	}
+
+	return patterns, nil
+}
+
+// Excluded returns true if the policy matches a pattern. It's always false if the exclusions are nil.
+func (e *PolicyExclusions) Excluded(namespace string, name string) bool {
+	if e == nil {
+		return false
+	}
+
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	for _, pattern := range e.patterns {
+		subject := name
+		if strings.Contains(pattern, "/") {
+			subject = namespace + "/" + name
+		}
+
+		if matched, _ := path.Match(pattern, subject); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// OnChange adds a function that is called after the patterns changed, such as to sync the policies that are
+// no longer excluded
+func (e *PolicyExclusions) OnChange(onChange func()) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.onChange = append(e.onChange, onChange)
+}
+
+// Update replaces the patterns of the ConfigMap with the comma-separated patterns, and leaves them unchanged
+// if a pattern is invalid
+func (e *PolicyExclusions) Update(value string) error {
+	configMapPatterns, err := parsePolicyExclusions(value)
+	if err != nil {
+		return err
+	}
+
+	e.lock.Lock()
+
+	e.patterns = append(append([]string{}, e.flagPatterns...), configMapPatterns...)
+	onChange := e.onChange
+
+	e.lock.Unlock()
+
+	for _, f := range onChange {
+		f()
+	}
+
+	return nil
+}
+
+// String returns the comma-separated patterns
+func (e *PolicyExclusions) String() string {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	return strings.Join(e.patterns, ",")
+}
+
+// PolicyExclusionWatcher reads the policy exclusions ConfigMap in the namespace of the agent periodically and
+// updates the policy exclusions from its PolicyExclusionsConfigMapKey key, so that policies can be excluded
+// from the hub reporting without labeling them or restarting the agent. Only the patterns of the flag are
+// excluded when the key or the ConfigMap is removed. An invalid value is logged and the exclusions are left
+// unchanged.
+type PolicyExclusionWatcher struct {
+	Client     kubernetes.Interface
+	Namespace  string
+	Name       string
+	Exclusions *PolicyExclusions
+	// applied is the value of the key that the exclusions were last updated from
+	applied string
+}
+
+// Start updates the policy exclusions from the ConfigMap until the context is done
+func (w *PolicyExclusionWatcher) Start(ctx context.Context) {
+	ticker := time.NewTicker(policyExclusionsWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		w.update(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// update reads the ConfigMap and updates the policy exclusions when the value of its key changed
+func (w *PolicyExclusionWatcher) update(ctx context.Context) {
+	value := ""
+
+	configMap, err := w.Client.CoreV1().ConfigMaps(w.Namespace).Get(ctx, w.Name, metav1.GetOptions{})
+	if err == nil {
+		value = strings.TrimSpace(configMap.Data[PolicyExclusionsConfigMapKey])
+	} else if !errors.IsNotFound(err) {
+		log.V(1).Info("Failed to read the policy exclusions ConfigMap", "Namespace", w.Namespace, "Name", w.Name,
+			"error", err.Error())
+
+		return
+	}
+
+	if value == w.applied {
+		return
+	}
+
+	// an invalid value is only logged once until it changes
+	w.applied = value
+
+	if err := w.Exclusions.Update(value); err != nil {
+		log.Error(err, "Invalid policy exclusions in the policy exclusions ConfigMap", "Namespace", w.Namespace,
+			"Name", w.Name, "key", PolicyExclusionsConfigMapKey)
+
+		return
+	}
+
+	log.Info("Updated the policy exclusions from the policy exclusions ConfigMap", "Namespace", w.Namespace,
+		"Name", w.Name, "exclusions", w.Exclusions.String())
+}
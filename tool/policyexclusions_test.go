@@ -0,0 +1,146 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParsePolicyExclusions(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		value    string
+		expected string
+		err      string
+	}{
+		"name":            {"canary-*", "canary-*", ""},
+		"namespace/name":  {"cluster1/policies.test-*", "cluster1/policies.test-*", ""},
+		"spaces and gaps": {" a , ,b/c ,", "a,b/c", ""},
+		"empty":           {"", "", ""},
+		"character class": {"policies.[ab]*", "policies.[ab]*", ""},
+		"too many slashes": {
+			"a/b/c", "", `invalid policy exclusion "a/b/c", it must be namespace/name or name`,
+		},
+		"bad pattern": {"policies.[a", "", `invalid policy exclusion "policies.[a": syntax error in pattern`},
+	}
+
+	for name, test := range tests {
+		patterns, err := parsePolicyExclusions(test.value)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Fatalf("%s: expected the error %q, got %v", name, test.err, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if joined := strings.Join(patterns, ","); joined != test.expected {
+			t.Fatalf("%s: expected the patterns %q, got %q", name, test.expected, joined)
+		}
+	}
+}
+
+func TestPolicyExclusionsExcluded(t *testing.T) {
+	t.Parallel()
+
+	exclusions := &PolicyExclusions{patterns: []string{"canary-*", "cluster1/policies.test-*", "*/generated.?"}}
+
+	tests := map[string]struct {
+		namespace string
+		name      string
+		expected  bool
+	}{
+		"name in any namespace":     {"cluster2", "canary-pod", true},
+		"name with a namespace":     {"cluster1", "policies.test-pod", true},
+		"name in another namespace": {"cluster2", "policies.test-pod", false},
+		"namespace wildcard":        {"cluster3", "generated.1", true},
+		"single character wildcard": {"cluster3", "generated.10", false},
+		"other name prefix":         {"cluster1", "policies.canary-pod", false},
+		"not excluded":              {"cluster1", "policies.pod", false},
+	}
+
+	for name, test := range tests {
+		if excluded := exclusions.Excluded(test.namespace, test.name); excluded != test.expected {
+			t.Fatalf("%s: expected the policy to be excluded: %v, got %v", name, test.expected, excluded)
+		}
+	}
+
+	var disabled *PolicyExclusions
+	if disabled.Excluded("cluster1", "canary-pod") {
+		t.Fatal("expected no policy to be excluded without exclusions")
+	}
+}
+
+func TestPolicyExclusionWatcher(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		// values are the values of the key of the ConfigMap at each update, where "-" deletes the ConfigMap
+		values []string
+		// expected are the patterns after each update
+		expected []string
+		// changes is the number of calls of the OnChange function
+		changes int
+	}{
+		"no configmap":      {[]string{"-"}, []string{"flag-*"}, 0},
+		"added patterns":    {[]string{"canary-*, b/c"}, []string{"flag-*,canary-*,b/c"}, 1},
+		"unchanged value":   {[]string{"canary-*", "canary-*"}, []string{"flag-*,canary-*", "flag-*,canary-*"}, 1},
+		"removed configmap": {[]string{"canary-*", "-"}, []string{"flag-*,canary-*", "flag-*"}, 2},
+		"removed key":       {[]string{"canary-*", ""}, []string{"flag-*,canary-*", "flag-*"}, 2},
+		"invalid value":     {[]string{"canary-*", "a/b/c"}, []string{"flag-*,canary-*", "flag-*,canary-*"}, 1},
+		"fixed value":       {[]string{"a/b/c", "canary-*"}, []string{"flag-*", "flag-*,canary-*"}, 1},
+	}
+
+	for name, test := range tests {
+		client := fake.NewSimpleClientset()
+		exclusions := &PolicyExclusions{flagPatterns: []string{"flag-*"}, patterns: []string{"flag-*"}}
+		watcher := &PolicyExclusionWatcher{
+			Client: client, Namespace: "agent", Name: "policy-exclusions", Exclusions: exclusions,
+		}
+
+		changes := 0
+		exclusions.OnChange(func() { changes++ })
+
+		configMaps := client.CoreV1().ConfigMaps("agent")
+
+		for i, value := range test.values {
+			_ = configMaps.Delete(context.TODO(), "policy-exclusions", metav1.DeleteOptions{})
+
+			if value != "-" {
+				configMap := &corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "agent", Name: "policy-exclusions"},
+					Data:       map[string]string{PolicyExclusionsConfigMapKey: value},
+				}
+				if value == "" {
+					configMap.Data = nil
+				}
+
+				if _, err := configMaps.Create(context.TODO(), configMap, metav1.CreateOptions{}); err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+			}
+
+			watcher.update(context.TODO())
+
+			if patterns := exclusions.String(); patterns != test.expected[i] {
+				t.Fatalf("%s: expected the patterns %q after the update %d, got %q", name, test.expected[i], i,
+					patterns)
+			}
+		}
+
+		if changes != test.changes {
+			t.Fatalf("%s: expected %d changes of the exclusions, got %d", name, test.changes, changes)
+		}
+	}
+}
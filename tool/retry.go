@@ -0,0 +1,87 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	startupRetryInitialDelay = time.Second
+	startupRetryMaxDelay     = 30 * time.Second
+)
+
+// permanentError marks a startup error that retrying won't resolve
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks the error as a permanent misconfiguration so that RetryStartup returns it immediately
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &permanentError{err: err}
+}
+
+// RetryStartup calls fn until it succeeds, returns a permanent error, or the --startup-retry-timeout
+// elapses, with an exponential backoff between attempts. This avoids crash loops while the clusters are
+// bootstrapping, such as when a kubeconfig isn't mounted yet or an API server isn't reachable yet. The
+// last error is returned without the permanent marker.
+func RetryStartup(operation string, fn func() error) error {
+	deadline := time.Now().Add(Options.StartupRetryTimeout)
+	delay := startupRetryInitialDelay
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			return permanent.err
+		}
+
+		if time.Now().Add(delay).After(deadline) {
+			return err
+		}
+
+		log.Error(err, "Startup step failed, retrying", "operation", operation, "attempt", attempt,
+			"retryIn", delay.String())
+
+		time.Sleep(delay)
+
+		delay *= 2
+		if delay > startupRetryMaxDelay {
+			delay = startupRetryMaxDelay
+		}
+	}
+}
+
+// RequireFile returns a retryable error if the file doesn't exist yet, such as a kubeconfig from a Secret
+// that hasn't been created yet, and a permanent error if it can't be accessed for another reason.
+func RequireFile(path string) error {
+	_, err := os.Stat(path)
+	if err == nil {
+		return nil
+	}
+
+	if os.IsNotExist(err) {
+		return fmt.Errorf("the file %s does not exist yet", path)
+	}
+
+	return Permanent(err)
+}
@@ -0,0 +1,275 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	addonclient "open-cluster-management.io/api/client/addon/clientset/versioned"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// SelfHealthConditionType is the type of the condition of the ManagedClusterAddOn on the hub that
+	// reports the self-health of the agent, which is true when the agent is degraded
+	SelfHealthConditionType = "StatusSyncDegraded"
+	// SelfHealthyReason is the reason of the self-health condition when the agent is healthy
+	SelfHealthyReason = "AsExpected"
+	// SelfRestartingReason is the reason of the self-health condition when a container of the agent pod
+	// recently restarted
+	SelfRestartingReason = "Restarting"
+	// SelfOOMKilledReason is the reason of the self-health condition when a container of the agent pod was
+	// recently killed because it ran out of memory
+	SelfOOMKilledReason = "OOMKilled"
+	// SelfUnavailableReason is the reason of the self-health condition when the deployment of the agent isn't
+	// available
+	SelfUnavailableReason = "DeploymentUnavailable"
+	// selfHealthWindow is how long after a container of the agent pod terminated that the agent is degraded
+	selfHealthWindow = 10 * time.Minute
+)
+
+var (
+	selfHealthyGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "policy_status_sync_self_healthy",
+			Help: "The self-health of the agent from the conditions of its pod and deployment, 1 if it's healthy and " +
+				"0 if it's degraded",
+		},
+	)
+	selfRestartsGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "policy_status_sync_self_container_restarts",
+			Help: "The number of restarts of each container of the agent pod",
+		},
+		[]string{"container"},
+	)
+	selfOOMKillsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "policy_status_sync_self_oom_kills_total",
+			Help: "The number of times each container of the agent pod was seen killed because it ran out of memory",
+		},
+		[]string{"container"},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(selfHealthyGauge, selfRestartsGauge, selfOOMKillsTotal)
+}
+
+// SelfMonitor periodically observes the pod and deployment of the agent, exports its self-health in the
+// policy_status_sync_self_* metrics, and reports it in the SelfHealthConditionType condition of the
+// ManagedClusterAddOn on the hub. The agent is degraded while a container of its pod terminated in the last
+// 10 minutes, such as when it's crash looping or OOMKilled, or while its deployment isn't available. This lets
+// the hub tell an agent that is crashing, which still reports the condition between restarts, from a network
+// partition, where the condition and the addon lease are both stale.
+type SelfMonitor struct {
+	// Client reads the pod and deployment of the agent
+	Client kubernetes.Interface
+	// Namespace is the namespace of the agent pod
+	Namespace string
+	// Pod is the name of the agent pod
+	Pod string
+	// HubClient updates the condition of the ManagedClusterAddOn on the hub. The condition isn't reported if
+	// it's nil.
+	HubClient addonclient.Interface
+	// HubNamespace is the cluster namespace on the hub
+	HubNamespace string
+	// AddonName is the name of the ManagedClusterAddOn of the agent on the hub
+	AddonName string
+	Interval  time.Duration
+	// terminations are the finish times of the last terminations of the containers, to count the OOM kills
+	// once
+	terminations map[string]time.Time
+	// reported is the condition that was last reported on the hub
+	reported *metav1.Condition
+}
+
+// Start observes the self-health every Interval until the context is done
+func (m *SelfMonitor) Start(ctx context.Context) {
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+
+	for {
+		if condition, ok := m.observe(ctx); ok {
+			m.report(ctx, condition)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// observe returns the self-health condition of the agent from its pod and deployment, and false if the pod
+// can't be read. A deployment that can't be read doesn't degrade the self-health. The read failures are only
+// logged at the debug level.
+func (m *SelfMonitor) observe(ctx context.Context) (metav1.Condition, bool) {
+	condition := metav1.Condition{
+		Type:    SelfHealthConditionType,
+		Status:  metav1.ConditionFalse,
+		Reason:  SelfHealthyReason,
+		Message: "The policy status sync agent is healthy",
+	}
+
+	pod, err := m.Client.CoreV1().Pods(m.Namespace).Get(ctx, m.Pod, metav1.GetOptions{})
+	if err != nil {
+		log.V(1).Info("Failed to read the agent pod", "Namespace", m.Namespace, "Name", m.Pod,
+			"error", err.Error())
+
+		return condition, false
+	}
+
+	if m.terminations == nil {
+		m.terminations = map[string]time.Time{}
+	}
+
+	for _, status := range pod.Status.ContainerStatuses {
+		selfRestartsGauge.WithLabelValues(status.Name).Set(float64(status.RestartCount))
+
+		terminated := status.LastTerminationState.Terminated
+		if terminated == nil {
+			continue
+		}
+
+		if terminated.Reason == SelfOOMKilledReason && !m.terminations[status.Name].Equal(terminated.FinishedAt.Time) {
+			selfOOMKillsTotal.WithLabelValues(status.Name).Inc()
+		}
+
+		m.terminations[status.Name] = terminated.FinishedAt.Time
+
+		if time.Since(terminated.FinishedAt.Time) > selfHealthWindow || condition.Reason == SelfOOMKilledReason {
+			continue
+		}
+
+		condition.Status = metav1.ConditionTrue
+		condition.Message = "The container " + status.Name + " of the policy status sync agent pod " + m.Pod +
+			" terminated at " + terminated.FinishedAt.UTC().Format(time.RFC3339) + " with the reason " +
+			terminated.Reason
+
+		if terminated.Reason == SelfOOMKilledReason {
+			condition.Reason = SelfOOMKilledReason
+		} else {
+			condition.Reason = SelfRestartingReason
+		}
+	}
+
+	if condition.Status == metav1.ConditionFalse {
+		if deployment := m.deployment(ctx, pod); deployment != nil && !deploymentAvailable(deployment) {
+			condition.Status = metav1.ConditionTrue
+			condition.Reason = SelfUnavailableReason
+			condition.Message = "The deployment " + deployment.GetName() + " of the policy status sync agent isn't " +
+				"available"
+		}
+	}
+
+	if condition.Status == metav1.ConditionTrue {
+		selfHealthyGauge.Set(0)
+	} else {
+		selfHealthyGauge.Set(1)
+	}
+
+	return condition, true
+}
+
+// deployment returns the deployment that owns the ReplicaSet of the agent pod, or nil if it can't be read
+func (m *SelfMonitor) deployment(ctx context.Context, pod *corev1.Pod) *appsv1.Deployment {
+	replicaSetName := ownerName(pod.GetOwnerReferences(), "ReplicaSet")
+	if replicaSetName == "" {
+		return nil
+	}
+
+	replicaSet, err := m.Client.AppsV1().ReplicaSets(m.Namespace).Get(ctx, replicaSetName, metav1.GetOptions{})
+	if err != nil {
+		log.V(1).Info("Failed to read the ReplicaSet of the agent pod", "Namespace", m.Namespace,
+			"Name", replicaSetName, "error", err.Error())
+
+		return nil
+	}
+
+	deploymentName := ownerName(replicaSet.GetOwnerReferences(), "Deployment")
+	if deploymentName == "" {
+		return nil
+	}
+
+	deployment, err := m.Client.AppsV1().Deployments(m.Namespace).Get(ctx, deploymentName, metav1.GetOptions{})
+	if err != nil {
+		log.V(1).Info("Failed to read the deployment of the agent pod", "Namespace", m.Namespace,
+			"Name", deploymentName, "error", err.Error())
+
+		return nil
+	}
+
+	return deployment
+}
+
+// ownerName returns the name of the controller owner of the kind, or an empty string if there's none
+func ownerName(owners []metav1.OwnerReference, kind string) string {
+	for _, owner := range owners {
+		if owner.Kind == kind && owner.Controller != nil && *owner.Controller {
+			return owner.Name
+		}
+	}
+
+	return ""
+}
+
+// deploymentAvailable returns false if the Available condition of the deployment is false
+func deploymentAvailable(deployment *appsv1.Deployment) bool {
+	for _, condition := range deployment.Status.Conditions {
+		if condition.Type == appsv1.DeploymentAvailable {
+			return condition.Status != corev1.ConditionFalse
+		}
+	}
+
+	return true
+}
+
+// report sets the condition on the ManagedClusterAddOn on the hub when it changed since it was last
+// reported. Failures are logged and retried at the next interval.
+func (m *SelfMonitor) report(ctx context.Context, condition metav1.Condition) {
+	if m.HubClient == nil {
+		return
+	}
+
+	if m.reported != nil && m.reported.Status == condition.Status && m.reported.Reason == condition.Reason &&
+		m.reported.Message == condition.Message {
+		return
+	}
+
+	addons := m.HubClient.AddonV1alpha1().ManagedClusterAddOns(m.HubNamespace)
+
+	addon, err := addons.Get(ctx, m.AddonName, metav1.GetOptions{})
+	if err != nil {
+		log.Error(err, "Failed to get the ManagedClusterAddOn to report the self-health condition",
+			"Namespace", m.HubNamespace, "Name", m.AddonName)
+
+		return
+	}
+
+	meta.SetStatusCondition(&addon.Status.Conditions, condition)
+
+	if _, err := addons.UpdateStatus(ctx, addon, metav1.UpdateOptions{}); err != nil {
+		log.Error(err, "Failed to report the self-health condition on the ManagedClusterAddOn",
+			"Namespace", m.HubNamespace, "Name", m.AddonName)
+
+		return
+	}
+
+	if condition.Status == metav1.ConditionTrue {
+		log.Info("The policy status sync agent is degraded", "reason", condition.Reason,
+			"message", condition.Message)
+	} else if m.reported != nil {
+		log.Info("The policy status sync agent is healthy again")
+	}
+
+	m.reported = &condition
+}
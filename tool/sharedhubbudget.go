@@ -0,0 +1,181 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// SharedHubAPIBudgetAnnotation is set on the shared hub API budget Lease to the requests per minute of the
+	// governance addons together
+	SharedHubAPIBudgetAnnotation = "policy.open-cluster-management.io/hub-api-budget"
+	// SharedHubAPITokensAnnotation is set on the shared hub API budget Lease to the requests left in the
+	// budget at its renew time, which is negative once the addons spent more than the budget
+	SharedHubAPITokensAnnotation = "policy.open-cluster-management.io/hub-api-budget-tokens"
+	// sharedHubAPIBudgetInterval is the interval at which the requests of the agent are taken from the shared
+	// budget, which bounds how long the addons can spend more than the shared budget
+	sharedHubAPIBudgetInterval = 10 * time.Second
+)
+
+var sharedHubAPIBudgetRemaining = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "policy_status_sync_shared_hub_api_budget_remaining",
+		Help: "The number of hub API requests left in the hub API budget shared with the other governance addons " +
+			"at the last sync of the --hub-api-budget-lease",
+	},
+)
+
+func init() {
+	metrics.Registry.MustRegister(sharedHubAPIBudgetRemaining)
+}
+
+// SharedHubAPIBudget coordinates the HubAPIBudget of the agent with the sibling governance addons on the
+// managed cluster, such as the spec sync and the template sync, so that they respect a single per-cluster hub
+// API budget together. The shared budget is a token bucket in the annotations of a Lease in the cluster
+// namespace: every addon periodically takes the requests it sent since its last sync from the tokens of the
+// Lease, after refilling them for the time since the renew time of the Lease, and caps its own budget at the
+// tokens left. The writes use the resource version of the Lease, so the concurrent syncs of the addons are
+// retried on the next interval instead of being lost. The Lease is created with the budget of the agent if it
+// doesn't exist. It must be added to the manager.
+type SharedHubAPIBudget struct {
+	// Budget is the hub API budget of the agent
+	Budget *HubAPIBudget
+	// Client reads and writes the Lease on the managed cluster
+	Client kubernetes.Interface
+	// Namespace is the cluster namespace of the Lease
+	Namespace string
+	// Name is the name of the Lease
+	Name string
+	// Holder identifies the agent as the holder of the Lease, which is the last addon that synced it
+	Holder string
+}
+
+// NeedLeaderElection is false since the hub requests of the standby replicas are also in the shared budget
+func (s *SharedHubAPIBudget) NeedLeaderElection() bool {
+	return false
+}
+
+// Start syncs the shared budget every sharedHubAPIBudgetInterval until the context is done
+func (s *SharedHubAPIBudget) Start(ctx context.Context) error {
+	log.Info("Sharing the hub API budget with the other governance addons", "namespace", s.Namespace,
+		"lease", s.Name)
+
+	ticker := time.NewTicker(sharedHubAPIBudgetInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := s.sync(ctx); err != nil {
+				log.Error(err, "Failed to sync the shared hub API budget, retrying on the next interval",
+					"namespace", s.Namespace, "lease", s.Name)
+			}
+		}
+	}
+}
+
+// sync takes the requests spent since the last sync from the shared budget and caps the budget of the agent at
+// the tokens left. The spent requests are only settled once the Lease is written.
+func (s *SharedHubAPIBudget) sync(ctx context.Context) error {
+	spent := s.Budget.spentRequests()
+	now := time.Now()
+
+	leases := s.Client.CoordinationV1().Leases(s.Namespace)
+
+	lease, err := leases.Get(ctx, s.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		tokens := s.Budget.perMinute - spent
+
+		_, err = leases.Create(ctx, &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      s.Name,
+				Namespace: s.Namespace,
+				Annotations: map[string]string{
+					SharedHubAPIBudgetAnnotation: strconv.FormatFloat(s.Budget.perMinute, 'f', -1, 64),
+					SharedHubAPITokensAnnotation: formatTokens(tokens),
+				},
+			},
+			Spec: coordinationv1.LeaseSpec{HolderIdentity: &s.Holder, RenewTime: &metav1.MicroTime{Time: now}},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return err
+		}
+
+		s.settle(spent, tokens)
+
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	perMinute, err := strconv.ParseFloat(lease.GetAnnotations()[SharedHubAPIBudgetAnnotation], 64)
+	if err != nil || perMinute <= 0 {
+		// a Lease without a valid budget is adopted with the budget of the agent
+		perMinute = s.Budget.perMinute
+	}
+
+	tokens, err := strconv.ParseFloat(lease.GetAnnotations()[SharedHubAPITokensAnnotation], 64)
+	if err != nil {
+		tokens = perMinute
+	}
+
+	if lease.Spec.RenewTime != nil && now.After(lease.Spec.RenewTime.Time) {
+		tokens += now.Sub(lease.Spec.RenewTime.Time).Minutes() * perMinute
+	}
+
+	tokens = math.Min(tokens, perMinute) - spent
+
+	annotations := lease.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+
+	annotations[SharedHubAPIBudgetAnnotation] = strconv.FormatFloat(perMinute, 'f', -1, 64)
+	annotations[SharedHubAPITokensAnnotation] = formatTokens(tokens)
+	lease.SetAnnotations(annotations)
+	lease.Spec.HolderIdentity = &s.Holder
+	lease.Spec.RenewTime = &metav1.MicroTime{Time: now}
+
+	if _, err := leases.Update(ctx, lease, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+
+	s.settle(spent, tokens)
+
+	return nil
+}
+
+// settle removes the spent requests that were taken from the shared budget from the budget of the agent, and
+// caps its tokens at the tokens left in the shared budget
+func (s *SharedHubAPIBudget) settle(spent float64, tokens float64) {
+	s.Budget.lock.Lock()
+	defer s.Budget.lock.Unlock()
+
+	s.Budget.spent -= spent
+
+	if tokens < s.Budget.tokens {
+		s.Budget.tokens = tokens
+	}
+
+	sharedHubAPIBudgetRemaining.Set(tokens)
+}
+
+// formatTokens formats the tokens of the shared budget with two decimals
+func formatTokens(tokens float64) string {
+	return strconv.FormatFloat(tokens, 'f', 2, 64)
+}
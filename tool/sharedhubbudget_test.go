@@ -0,0 +1,153 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+// budgetLease returns the shared hub API budget Lease with the budget and tokens annotations, which are
+// omitted if they're empty, renewed at the time unless it's zero
+func budgetLease(perMinute string, tokens string, renewTime time.Time) *coordinationv1.Lease {
+	lease := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "cluster1", Name: "governance-hub-api-budget"},
+	}
+
+	if !renewTime.IsZero() {
+		lease.Spec.RenewTime = &metav1.MicroTime{Time: renewTime}
+	}
+
+	annotations := map[string]string{}
+
+	if perMinute != "" {
+		annotations[SharedHubAPIBudgetAnnotation] = perMinute
+	}
+
+	if tokens != "" {
+		annotations[SharedHubAPITokensAnnotation] = tokens
+	}
+
+	lease.SetAnnotations(annotations)
+
+	return lease
+}
+
+func TestSharedHubAPIBudgetSync(t *testing.T) {
+	t.Parallel()
+
+	// the renew time in the future isn't refilled, and the refill of the test run is below the tolerance
+	future := time.Now().Add(time.Hour)
+
+	tests := map[string]struct {
+		lease *coordinationv1.Lease
+		// perMinute and tokens are the shared budget after the sync of the 10 spent requests of the agent,
+		// which has 60 requests per minute and 40 tokens left
+		perMinute   float64
+		tokens      float64
+		agentTokens float64
+	}{
+		"no lease":         {nil, 60, 50, 40},
+		"tokens left":      {budgetLease("60", "20", future), 60, 10, 10},
+		"refilled":         {budgetLease("60", "0", time.Now().Add(-30*time.Second)), 60, 20, 20},
+		"refill is capped": {budgetLease("60", "0", time.Now().Add(-time.Hour)), 60, 50, 40},
+		"larger budget":    {budgetLease("120", "200", future), 120, 110, 40},
+		"overspent":        {budgetLease("60", "-5", future), 60, -15, -15},
+		"invalid budget":   {budgetLease("many", "20", future), 60, 10, 10},
+		"negative budget":  {budgetLease("-60", "20", future), 60, 10, 10},
+		"no tokens":        {budgetLease("30", "", future), 30, 20, 20},
+		"no annotations":   {budgetLease("", "", future), 60, 50, 40},
+		"never renewed":    {budgetLease("60", "20", time.Time{}), 60, 10, 10},
+	}
+
+	for name, test := range tests {
+		client := fake.NewSimpleClientset()
+		if test.lease != nil {
+			client = fake.NewSimpleClientset(test.lease)
+		}
+
+		budget := &HubAPIBudget{perMinute: 60, tokens: 40, last: time.Now(), spent: 10}
+		shared := &SharedHubAPIBudget{
+			Budget: budget, Client: client, Namespace: "cluster1", Name: "governance-hub-api-budget",
+			Holder: "governance-policy-status-sync",
+		}
+
+		if err := shared.sync(context.TODO()); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		lease, err := client.CoordinationV1().Leases("cluster1").Get(
+			context.TODO(), "governance-hub-api-budget", metav1.GetOptions{},
+		)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		perMinute, _ := strconv.ParseFloat(lease.GetAnnotations()[SharedHubAPIBudgetAnnotation], 64)
+		tokens, _ := strconv.ParseFloat(lease.GetAnnotations()[SharedHubAPITokensAnnotation], 64)
+
+		if perMinute != test.perMinute || math.Abs(tokens-test.tokens) > 0.1 {
+			t.Fatalf("%s: expected the shared budget %v with %v tokens, got %v with %v", name, test.perMinute,
+				test.tokens, perMinute, tokens)
+		}
+
+		if *lease.Spec.HolderIdentity != "governance-policy-status-sync" || lease.Spec.RenewTime == nil {
+			t.Fatalf("%s: expected the agent to renew the lease, got %v", name, lease.Spec)
+		}
+
+		// the spent requests are settled, and the agent can't spend more than the shared tokens left
+		if budget.spent != 0 || math.Abs(budget.tokens-test.agentTokens) > 0.1 {
+			t.Fatalf("%s: expected %v agent tokens and no unsettled requests, got %v and %v", name,
+				test.agentTokens, budget.tokens, budget.spent)
+		}
+	}
+}
+
+func TestSharedHubAPIBudgetSyncErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		lease *coordinationv1.Lease
+		verb  string
+	}{
+		"get":    {budgetLease("60", "20", time.Now()), "get"},
+		"create": {nil, "create"},
+		"update": {budgetLease("60", "20", time.Now()), "update"},
+	}
+
+	for name, test := range tests {
+		client := fake.NewSimpleClientset()
+		if test.lease != nil {
+			client = fake.NewSimpleClientset(test.lease)
+		}
+
+		client.PrependReactor(test.verb, "leases", func(clienttesting.Action) (bool, runtime.Object, error) {
+			return true, nil, errors.New("the lease request failed")
+		})
+
+		budget := &HubAPIBudget{perMinute: 60, tokens: 40, last: time.Now(), spent: 10}
+		shared := &SharedHubAPIBudget{
+			Budget: budget, Client: client, Namespace: "cluster1", Name: "governance-hub-api-budget",
+		}
+
+		if err := shared.sync(context.TODO()); err == nil {
+			t.Fatalf("%s: expected the sync to fail", name)
+		}
+
+		// the spent requests are taken from the shared budget on the next sync
+		if budget.spent != 10 || budget.tokens != 40 {
+			t.Fatalf("%s: expected the spent requests to not be settled, got %v spent and %v tokens", name,
+				budget.spent, budget.tokens)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// ReplicaRoleHeader is set on the responses of the debug endpoints to the role of the replica, so that the
+	// responses of a standby replica aren't mistaken for those of the leader
+	ReplicaRoleHeader = "X-Policy-Status-Sync-Role"
+	// ReplicaLeader is the role of the elected replica, or of the only replica without leader election
+	ReplicaLeader = "leader"
+	// ReplicaStandby is the role of a replica waiting to become the leader
+	ReplicaStandby = "standby"
+)
+
+// elected is 1 once this replica is the leader
+var elected int32
+
+func init() {
+	metrics.Registry.MustRegister(prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "policy_status_sync_leader",
+			Help: "1 if this replica is the leader, or 0 if it's a standby replica, which serves the metrics and " +
+				"the debug endpoints read-only",
+		},
+		func() float64 {
+			return float64(atomic.LoadInt32(&elected))
+		},
+	))
+}
+
+// LeaderTracker records that this replica is the leader once it's elected, which is immediately without leader
+// election. It must be added to the manager.
+type LeaderTracker struct{}
+
+// NeedLeaderElection is true so that the tracker is only started once this replica is elected
+func (t *LeaderTracker) NeedLeaderElection() bool {
+	return true
+}
+
+// Start records that this replica is the leader and waits for the context to be done
+func (t *LeaderTracker) Start(ctx context.Context) error {
+	atomic.StoreInt32(&elected, 1)
+
+	<-ctx.Done()
+
+	return nil
+}
+
+// ReplicaRole returns ReplicaLeader if this replica is the leader, or else ReplicaStandby
+func ReplicaRole() string {
+	if atomic.LoadInt32(&elected) == 1 {
+		return ReplicaLeader
+	}
+
+	return ReplicaStandby
+}
+
+// ReplicaState returns the role and the pod of this replica for the support bundle
+func ReplicaState(_ context.Context) (interface{}, error) {
+	return map[string]string{"role": ReplicaRole(), "pod": os.Getenv("HOSTNAME")}, nil
+}
+
+// ReadOnlyOnStandby sets the ReplicaRoleHeader on the responses of the handler, and rejects the requests other
+// than GET and HEAD on a standby replica, such as an immediate sync, since only the leader writes
+func ReadOnlyOnStandby(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		role := ReplicaRole()
+		w.Header().Set(ReplicaRoleHeader, role)
+
+		if role == ReplicaStandby && req.Method != http.MethodGet && req.Method != http.MethodHead {
+			http.Error(w, "this replica is on standby and is read-only, send the request to the leader",
+				http.StatusServiceUnavailable)
+
+			return
+		}
+
+		handler.ServeHTTP(w, req)
+	})
+}
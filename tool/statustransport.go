@@ -0,0 +1,31 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import "fmt"
+
+const (
+	// StatusTransportHub writes the status of the replicated policies to the hub policies
+	StatusTransportHub = "hub"
+	// StatusTransportManifestWork only writes the hub status to the replicated policies, which the work agent
+	// reports to the hub in the status feedback of the ManifestWork that deploys them, so the agent needs no
+	// access to the hub
+	StatusTransportManifestWork = "manifestwork"
+)
+
+// ValidateStatusTransport returns an error if the --status-transport is invalid
+func ValidateStatusTransport() error {
+	switch Options.StatusTransport {
+	case StatusTransportHub, StatusTransportManifestWork:
+		return nil
+	}
+
+	return fmt.Errorf("invalid status transport %q, it must be %s or %s", Options.StatusTransport,
+		StatusTransportHub, StatusTransportManifestWork)
+}
+
+// ManifestWorkTransport returns true if the status is reported to the hub by the ManifestWork status feedback
+// instead of being written to the hub policies
+func ManifestWorkTransport() bool {
+	return Options.StatusTransport == StatusTransportManifestWork
+}
@@ -0,0 +1,190 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// SupportBundlePath is the path of the support bundle endpoint of the health probe server
+	SupportBundlePath = "/debug/support-bundle"
+	// recentLogLines is the number of the most recent log lines that are kept for the support bundle
+	recentLogLines = 2000
+	// supportBundleTimeout is the timeout to collect all the files of the support bundle
+	supportBundleTimeout = 30 * time.Second
+)
+
+// RecentLogs keeps the most recent log lines for the support bundle when it's set as a destination of the
+// logs
+var RecentLogs = &LogRing{size: recentLogLines}
+
+// LogRing is an io.Writer that keeps the most recent lines written to it
+type LogRing struct {
+	lock  sync.Mutex
+	size  int
+	lines []string
+}
+
+// Write keeps the lines of the log entries, which are written one entry per call
+func (l *LogRing) Write(p []byte) (int, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		l.lines = append(l.lines, line)
+	}
+
+	// the lines are only copied once they're twice the size, so that every write doesn't copy them
+	if len(l.lines) > 2*l.size {
+		l.lines = append([]string(nil), l.lines[len(l.lines)-l.size:]...)
+	}
+
+	return len(p), nil
+}
+
+// Lines returns the most recent lines, oldest first
+func (l *LogRing) Lines() []string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	start := 0
+	if len(l.lines) > l.size {
+		start = len(l.lines) - l.size
+	}
+
+	return append([]string(nil), l.lines[start:]...)
+}
+
+// SupportBundleFile returns the content of a file of the support bundle, which is written as is if it's a
+// string or bytes and as JSON otherwise
+type SupportBundleFile func(ctx context.Context) (interface{}, error)
+
+// SupportBundle serves a gzipped tarball of the state of the agent to attach to support cases, such as its
+// configuration, recent logs, queue state, per-policy sync status, and hub connectivity. The files are
+// collected when the bundle is requested, and a file that can't be collected is replaced with a .error file
+// that has the error.
+type SupportBundle struct {
+	lock  sync.Mutex
+	names []string
+	files map[string]SupportBundleFile
+}
+
+// NewSupportBundle returns a support bundle with the configuration snapshot and the recent logs
+func NewSupportBundle() *SupportBundle {
+	bundle := &SupportBundle{files: map[string]SupportBundleFile{}}
+
+	bundle.Add("config.json", func(_ context.Context) (interface{}, error) {
+		return Snapshot(), nil
+	})
+	bundle.Add("logs.txt", func(_ context.Context) (interface{}, error) {
+		return strings.Join(RecentLogs.Lines(), "\n") + "\n", nil
+	})
+
+	return bundle
+}
+
+// Add adds a file to the support bundle, which replaces the file with the same name
+func (b *SupportBundle) Add(name string, file SupportBundleFile) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if _, ok := b.files[name]; !ok {
+		b.names = append(b.names, name)
+	}
+
+	b.files[name] = file
+}
+
+// ServeHTTP collects the files and writes the support bundle
+func (b *SupportBundle) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "only GET requests are supported", http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), supportBundleTimeout)
+	defer cancel()
+
+	now := time.Now().UTC()
+
+	b.lock.Lock()
+	names := append([]string(nil), b.names...)
+	files := make(map[string]SupportBundleFile, len(b.files))
+
+	for name, file := range b.files {
+		files[name] = file
+	}
+	b.lock.Unlock()
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition",
+		"attachment; filename=policy-status-sync-"+now.Format("20060102T150405Z")+".tar.gz")
+
+	gzipWriter := gzip.NewWriter(w)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	for _, name := range names {
+		content, err := bundleFileContent(ctx, files[name])
+		if err != nil {
+			name += ".error"
+			content = []byte(err.Error() + "\n")
+		}
+
+		err = tarWriter.WriteHeader(&tar.Header{
+			Name:    "policy-status-sync/" + name,
+			Mode:    0o644,
+			Size:    int64(len(content)),
+			ModTime: now,
+		})
+		if err == nil {
+			_, err = tarWriter.Write(content)
+		}
+
+		if err != nil {
+			log.Error(err, "Failed to write the support bundle", "file", name)
+
+			return
+		}
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		log.Error(err, "Failed to write the support bundle")
+
+		return
+	}
+
+	if err := gzipWriter.Close(); err != nil {
+		log.Error(err, "Failed to write the support bundle")
+	}
+}
+
+// bundleFileContent returns the content of the support bundle file
+func bundleFileContent(ctx context.Context, file SupportBundleFile) ([]byte, error) {
+	value, err := file(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	switch typed := value.(type) {
+	case []byte:
+		return typed, nil
+	case string:
+		return []byte(typed), nil
+	}
+
+	content, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(content, '\n'), nil
+}
@@ -0,0 +1,23 @@
+// Copyright Contributors to the Open Cluster Management project
+
+package tool
+
+import (
+	"context"
+)
+
+// reconcileIDKey is the context key of the reconcile ID
+type reconcileIDKey struct{}
+
+// WithReconcileID returns a context with the ID of the reconcile, so that the API requests made with it are
+// logged with the ID
+func WithReconcileID(ctx context.Context, reconcileID string) context.Context {
+	return context.WithValue(ctx, reconcileIDKey{}, reconcileID)
+}
+
+// ReconcileID returns the ID of the reconcile of the context, or an empty string if it has none
+func ReconcileID(ctx context.Context) string {
+	reconcileID, _ := ctx.Value(reconcileIDKey{}).(string)
+
+	return reconcileID
+}